@@ -0,0 +1,276 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// activity report formats
+const (
+	activityReportCSV  = "csv"
+	activityReportJSON = "json"
+)
+
+const activityReportDefaultDays = 30
+const activityReportMaxDays = 366
+
+const activityReportDateLayout = "2006-01-02"
+
+// activityReportRow is the service's activity for the day
+type activityReportRow struct {
+	Date           string `json:"date"` // UTC
+	EventsReceived int    `json:"events_received"`
+	MessagesSent   int    `json:"messages_sent"`
+	ActiveChats    int    `json:"active_chats"` // chats received at least one message from the bot
+	ButtonPresses  int    `json:"button_presses"`
+}
+
+// services which reports are being generated
+var activityReportsMutex = sync.Mutex{}
+var activityReportsInProgress = make(map[string]bool)
+
+func init() {
+	registerAdminCommand("activity", adminActivityReport)
+}
+
+// parseActivityReportArgs parses '[days|from to] [csv|json]'. Returns the range of days [from, to] in UTC
+func parseActivityReportArgs(args []string, now time.Time) (from time.Time, to time.Time, format string, err error) {
+	format = activityReportCSV
+	if len(args) > 0 && (args[len(args)-1] == activityReportCSV || args[len(args)-1] == activityReportJSON) {
+		format = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+
+	to = now.UTC().Truncate(time.Hour * 24)
+
+	switch len(args) {
+	case 0:
+		from = to.AddDate(0, 0, -activityReportDefaultDays+1)
+	case 1:
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 1 {
+			return from, to, format, fmt.Errorf("Wrong number of days: %s", args[0])
+		}
+		from = to.AddDate(0, 0, -days+1)
+	case 2:
+		from, err = time.Parse(activityReportDateLayout, args[0])
+		if err != nil {
+			return from, to, format, fmt.Errorf("Wrong date '%s', use YYYY-MM-DD", args[0])
+		}
+
+		to, err = time.Parse(activityReportDateLayout, args[1])
+		if err != nil {
+			return from, to, format, fmt.Errorf("Wrong date '%s', use YYYY-MM-DD", args[1])
+		}
+
+		if to.Before(from) {
+			return from, to, format, errors.New("End of the range is before the start")
+		}
+	default:
+		return from, to, format, errors.New("Usage: activity [days|YYYY-MM-DD YYYY-MM-DD] [csv|json]")
+	}
+
+	if to.Sub(from) >= time.Hour*24*activityReportMaxDays {
+		return from, to, format, fmt.Errorf("Range is limited to %d days", activityReportMaxDays)
+	}
+
+	return from, to, format, nil
+}
+
+// newActivityReportRows returns the empty row for every day of the range
+func newActivityReportRows(from time.Time, to time.Time) []activityReportRow {
+	var rows []activityReportRow
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		rows = append(rows, activityReportRow{Date: d.Format(activityReportDateLayout)})
+	}
+	return rows
+}
+
+// statDayDate returns the date of the day since unix epoch used in the stats
+func statDayDate(day uint16) string {
+	return time.Unix(int64(day)*24*60*60, 0).UTC().Format(activityReportDateLayout)
+}
+
+// activityReport collects the service's activity per day from the stats and the stored messages
+func activityReport(db *mgo.Database, service *Service, from time.Time, to time.Time) ([]activityReportRow, error) {
+	rows := newActivityReportRows(from, to)
+	perDate := make(map[string]*activityReportRow)
+	for i := range rows {
+		perDate[rows[i].Date] = &rows[i]
+	}
+
+	fromDay := uint16(from.Unix() / (24 * 60 * 60))
+	toDay := uint16(to.Unix() / (24 * 60 * 60))
+
+	var stats []stat
+	err := db.C("stats").Find(bson.M{"s": service.Name, "k": StatWebhookHandled, "d": bson.M{"$gte": fromDay, "$lte": toDay}}).All(&stats)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range stats {
+		if row, exists := perDate[statDayDate(s.DayN)]; exists {
+			row.EventsReceived += int(s.Counter)
+		}
+	}
+
+	var presses []callbackStat
+	err = db.C("callback_stats").Find(bson.M{"s": service.Name, "d": bson.M{"$gte": fromDay, "$lte": toDay}}).Select(bson.M{"d": 1, "v": 1}).All(&presses)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range presses {
+		if row, exists := perDate[statDayDate(s.DayN)]; exists {
+			row.ButtonPresses += int(s.Counter)
+		}
+	}
+
+	bot := service.Bot()
+	if bot == nil {
+		return rows, nil
+	}
+
+	var sent []struct {
+		Date     string `bson:"_id"`
+		Messages int    `bson:"n"`
+		Chats    int    `bson:"c"`
+	}
+
+	err = db.C("messages").Pipe([]bson.M{
+		{"$match": bson.M{
+			"_id":    bson.M{"$gte": bson.NewObjectIdWithTime(from), "$lt": bson.NewObjectIdWithTime(to.AddDate(0, 0, 1))},
+			"botid":  bot.ID,
+			"fromid": bot.ID,
+		}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$date"}},
+			"n":     bson.M{"$sum": 1},
+			"chats": bson.M{"$addToSet": "$chatid"},
+		}},
+		{"$project": bson.M{"n": 1, "c": bson.M{"$size": "$chats"}}},
+	}).All(&sent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sent {
+		if row, exists := perDate[s.Date]; exists {
+			row.MessagesSent += s.Messages
+			row.ActiveChats += s.Chats
+		}
+	}
+
+	return rows, nil
+}
+
+// encodeActivityReport returns the report file's content in the format
+func encodeActivityReport(rows []activityReportRow, format string) ([]byte, error) {
+	if format == activityReportJSON {
+		return json.MarshalIndent(rows, "", "  ")
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"date", "events_received", "messages_sent", "active_chats", "button_presses"})
+
+	for _, row := range rows {
+		w.Write([]string{row.Date, strconv.Itoa(row.EventsReceived), strconv.Itoa(row.MessagesSent), strconv.Itoa(row.ActiveChats), strconv.Itoa(row.ButtonPresses)})
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// sendActivityReport generates the report and sends it as the document to the admin's chat
+func sendActivityReport(serviceName string, user User, chat Chat, from time.Time, to time.Time, format string) {
+	defer func() {
+		activityReportsMutex.Lock()
+		delete(activityReportsInProgress, serviceName)
+		activityReportsMutex.Unlock()
+
+		if r := recover(); r != nil {
+			log.Errorf("sendActivityReport panic recovered %v", r)
+		}
+	}()
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	ctx := &Context{ServiceName: serviceName, User: user, Chat: chat, db: db}
+	ctx.User.ctx = ctx
+	ctx.Chat.ctx = ctx
+
+	err := func() error {
+		rows, err := activityReport(db, ctx.Service(), from, to)
+		if err != nil {
+			return err
+		}
+
+		data, err := encodeActivityReport(rows, format)
+		if err != nil {
+			return err
+		}
+
+		f, err := ioutil.TempFile("", "activity_"+serviceName+"_")
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(data)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("%s_activity_%s_%s.%s", serviceName, from.Format(activityReportDateLayout), to.Format(activityReportDateLayout), format)
+		return ctx.NewMessage().
+			SetDocument(f.Name(), name).
+			EnableFileRemoveAfter().
+			Send()
+	}()
+
+	if err != nil {
+		ctx.Log().WithError(err).Error("sendActivityReport: can't generate the report")
+		ctx.NewMessage().SetText("Can't generate the activity report: " + err.Error()).SetParseMode("").Send()
+	}
+}
+
+// adminActivityReport: /integram activity [days|YYYY-MM-DD YYYY-MM-DD] [csv|json]
+func adminActivityReport(c *Context, args []string) (string, error) {
+	if c.ServiceName == "" {
+		return "", fmt.Errorf("Service must be specified")
+	}
+
+	if c.Chat.ID == 0 {
+		return "", errors.New("Activity report is sent as the document, request it in the bot's chat")
+	}
+
+	from, to, format, err := parseActivityReportArgs(args, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	activityReportsMutex.Lock()
+	defer activityReportsMutex.Unlock()
+
+	if activityReportsInProgress[c.ServiceName] {
+		return "", fmt.Errorf("Activity report for %s is already being generated", c.ServiceName)
+	}
+	activityReportsInProgress[c.ServiceName] = true
+
+	go sendActivityReport(c.ServiceName, c.User, c.Chat, from, to, format)
+
+	return fmt.Sprintf("Generating %s activity report for %s – %s, it will be sent as the document", c.ServiceName, from.Format(activityReportDateLayout), to.Format(activityReportDateLayout)), nil
+}
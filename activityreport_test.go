@@ -0,0 +1,85 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseActivityReportArgs(t *testing.T) {
+	now := time.Date(2019, 3, 15, 18, 30, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2019, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantFrom   time.Time
+		wantTo     time.Time
+		wantFormat string
+		wantErr    bool
+	}{
+		{"default", nil, day(2, 14), day(3, 15), activityReportCSV, false},
+		{"days", []string{"7"}, day(3, 9), day(3, 15), activityReportCSV, false},
+		{"days json", []string{"1", "json"}, day(3, 15), day(3, 15), activityReportJSON, false},
+		{"range", []string{"2019-01-01", "2019-01-31", "csv"}, day(1, 1), day(1, 31), activityReportCSV, false},
+		{"wrong days", []string{"0"}, time.Time{}, time.Time{}, "", true},
+		{"wrong date", []string{"2019-01-01", "31.01.2019"}, time.Time{}, time.Time{}, "", true},
+		{"reversed range", []string{"2019-02-01", "2019-01-01"}, time.Time{}, time.Time{}, "", true},
+		{"too long", []string{"400"}, time.Time{}, time.Time{}, "", true},
+		{"too many args", []string{"1", "2", "3"}, time.Time{}, time.Time{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, format, err := parseActivityReportArgs(tt.args, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseActivityReportArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) || format != tt.wantFormat {
+				t.Errorf("parseActivityReportArgs() = %v, %v, %v, want %v, %v, %v", from, to, format, tt.wantFrom, tt.wantTo, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func Test_newActivityReportRows(t *testing.T) {
+	rows := newActivityReportRows(time.Date(2019, 2, 27, 0, 0, 0, 0, time.UTC), time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC))
+	if len(rows) != 3 || rows[0].Date != "2019-02-27" || rows[2].Date != "2019-03-01" {
+		t.Errorf("newActivityReportRows() = %+v", rows)
+	}
+}
+
+func Test_statDayDate(t *testing.T) {
+	day := uint16(time.Date(2019, 3, 15, 12, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+	if got := statDayDate(day); got != "2019-03-15" {
+		t.Errorf("statDayDate() = %v, want %v", got, "2019-03-15")
+	}
+}
+
+func Test_encodeActivityReport(t *testing.T) {
+	rows := []activityReportRow{
+		{Date: "2019-03-14", EventsReceived: 10, MessagesSent: 8, ActiveChats: 3, ButtonPresses: 2},
+		{Date: "2019-03-15"},
+	}
+
+	data, err := encodeActivityReport(rows, activityReportCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "date,events_received,messages_sent,active_chats,button_presses\n2019-03-14,10,8,3,2\n2019-03-15,0,0,0,0\n"
+	if string(data) != want {
+		t.Errorf("encodeActivityReport() csv = %q, want %q", data, want)
+	}
+
+	data, err = encodeActivityReport(rows[1:], activityReportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = "[\n  {\n    \"date\": \"2019-03-15\",\n    \"events_received\": 0,\n    \"messages_sent\": 0,\n    \"active_chats\": 0,\n    \"button_presses\": 0\n  }\n]"
+	if string(data) != want {
+		t.Errorf("encodeActivityReport() json = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,151 @@
+package integram
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// adminCommandHandler produces the plain text report for the instance admin
+type adminCommandHandler func(c *Context, args []string) (string, error)
+
+var adminCommandsMutex = sync.RWMutex{}
+var adminCommands = make(map[string]adminCommandHandler)
+
+func registerAdminCommand(name string, handler adminCommandHandler) {
+	adminCommandsMutex.Lock()
+	defer adminCommandsMutex.Unlock()
+
+	adminCommands[name] = handler
+}
+
+func adminCommandByName(name string) adminCommandHandler {
+	adminCommandsMutex.RLock()
+	defer adminCommandsMutex.RUnlock()
+
+	return adminCommands[name]
+}
+
+func adminCommandsList() []string {
+	adminCommandsMutex.RLock()
+	defer adminCommandsMutex.RUnlock()
+
+	var names []string
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsAdmin checks if the user is listed in INTEGRAM_ADMIN_IDS
+func (user *User) IsAdmin() bool {
+	for _, id := range Config.AdminIDs {
+		if id == user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// runAdminCommand returns the report of the admin command
+func (c *Context) runAdminCommand(name string, args []string) (string, error) {
+	handler := adminCommandByName(name)
+	if handler == nil {
+		return "", fmt.Errorf("Unknown command '%s'. Available: %s", name, strings.Join(adminCommandsList(), ", "))
+	}
+	return handler(c, args)
+}
+
+// handleAdminCommand process '/integram command args' sent by admin in private chat. Returns true if message was handled
+func (c *Context) handleAdminCommand() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() || !c.User.IsAdmin() {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand("integram") {
+		return false
+	}
+
+	args := strings.Fields(param)
+	if len(args) == 0 {
+		args = []string{"help"}
+	}
+
+	var text string
+	if args[0] == "help" {
+		text = "Usage: /" + coreCommand("integram") + " command [args]\nAvailable commands: " + strings.Join(adminCommandsList(), ", ")
+	} else {
+		var err error
+		text, err = c.runAdminCommand(args[0], args[1:])
+		if err != nil {
+			text = err.Error()
+		}
+	}
+
+	if text == "" {
+		text = "Nothing to report"
+	}
+
+	err := c.NewMessage().SetText(HTMLRichText{}.Pre(text)).EnableHTML().Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleAdminCommand: can't send the report")
+	}
+
+	return true
+}
+
+// adminHandler serves /admin/command/service?token=INTEGRAM_ADMIN_TOKEN
+func adminHandler(c *gin.Context, command string, service string) {
+	token := c.Query("token")
+	if token == "" {
+		token = c.Request.Header.Get("X-Integram-Admin-Token")
+	}
+
+	if Config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(Config.AdminToken)) != 1 {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	ctx := &Context{db: c.MustGet("db").(*mgo.Database), gin: c}
+
+	if service != "" {
+		s, _ := serviceByName(service)
+		if s == nil {
+			c.String(http.StatusNotFound, "Service not found")
+			return
+		}
+
+		if Config.IsMainInstance() {
+			proxy := reverseProxyForService(s.Name)
+			proxy.ServeHTTP(c.Writer, c.Request)
+			return
+		}
+		ctx.ServiceName = s.Name
+	}
+
+	var args []string
+	if a := c.Query("args"); a != "" {
+		args = strings.Split(a, ",")
+	}
+
+	text, err := ctx.runAdminCommand(command, args)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// command has already responded by itself, e.g. with the binary dump
+	if c.Writer.Written() {
+		return
+	}
+
+	c.String(http.StatusOK, text)
+}
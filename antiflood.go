@@ -0,0 +1,93 @@
+package integram
+
+import (
+	"encoding/gob"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+)
+
+// delay before the retry when Telegram doesn't provide retry_after
+const antiFloodDefaultRetryAfter = time.Second
+
+// antiFloodRetryDelay returns the delay before the attempt (starting from 0) to retry the request rejected by Telegram anti-flood.
+// Returns false if err isn't the anti-flood error or the request shouldn't be retried anymore
+func antiFloodRetryDelay(err error, attempt int) (time.Duration, bool) {
+	tgErr, ok := err.(tg.Error)
+	if !ok || !tgErr.IsAntiFlood() && !tgErr.TooManyRequests() {
+		return 0, false
+	}
+
+	if attempt >= Config.TGAntiFloodRetries {
+		return 0, false
+	}
+
+	delay := antiFloodDefaultRetryAfter
+	if tgErr.Parameters != nil && tgErr.Parameters.RetryAfter > 0 {
+		delay = time.Duration(tgErr.Parameters.RetryAfter) * time.Second
+	}
+	delay <<= uint(attempt)
+
+	if Config.TGAntiFloodMaxDelay > 0 && delay > Config.TGAntiFloodMaxDelay {
+		return 0, false
+	}
+	return delay, true
+}
+
+// antiFloodEdit is the edit request queued to retry after it was rejected by Telegram anti-flood
+type antiFloodEdit struct {
+	BotID   int64
+	Edit    tg.Chattable
+	Attempt int
+}
+
+func init() {
+	gob.Register(tg.EditMessageTextConfig{})
+	gob.Register(tg.EditMessageCaptionConfig{})
+	gob.Register(tg.EditMessageReplyMarkupConfig{})
+}
+
+// sendEdit sends the edit request to Telegram. If rejected by anti-flood the edit is queued to retry after retry_after and considered sent
+func (c *Context) sendEdit(bot *Bot, edit tg.Chattable) (tg.Message, error) {
+	msg, err := bot.API.Send(edit)
+
+	delay, retry := antiFloodRetryDelay(err, 0)
+	if !retry {
+		return msg, err
+	}
+
+	_, scheduleErr := antiFloodEditJob.Schedule(0, time.Now().Add(delay), &antiFloodEdit{BotID: bot.ID, Edit: edit, Attempt: 1})
+	if scheduleErr != nil {
+		c.Log().WithError(scheduleErr).Error("Can't schedule antiFloodEditJob")
+		return msg, err
+	}
+
+	c.Log().WithError(err).Warnf("TG Anti flood activated, edit is queued to retry in %s", delay)
+	c.StatInc(StatAntiFloodRetry)
+	return msg, nil
+}
+
+// retryAntiFloodEdit resends the edit queued by sendEdit and queues it again with the backoff while rejected by anti-flood
+func retryAntiFloodEdit(e *antiFloodEdit) error {
+	bot := botByID(e.BotID)
+	if bot == nil {
+		log.WithField("bot", e.BotID).Error("retryAntiFloodEdit: bot not found")
+		return nil
+	}
+
+	_, err := bot.API.Send(e.Edit)
+
+	delay, retry := antiFloodRetryDelay(err, e.Attempt)
+	if !retry {
+		if err != nil {
+			log.WithField("bot", e.BotID).WithError(err).Errorf("TG edit failed after %d anti flood retries", e.Attempt)
+		}
+		return nil
+	}
+
+	log.WithField("bot", e.BotID).WithError(err).Warnf("TG Anti flood activated, retry #%d in %s", e.Attempt+1, delay)
+	e.Attempt++
+	_, err = antiFloodEditJob.Schedule(0, time.Now().Add(delay), e)
+	return err
+}
@@ -0,0 +1,41 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_antiFloodRetryDelay(t *testing.T) {
+	retries, maxDelay := Config.TGAntiFloodRetries, Config.TGAntiFloodMaxDelay
+	Config.TGAntiFloodRetries, Config.TGAntiFloodMaxDelay = 3, time.Second*30
+	defer func() { Config.TGAntiFloodRetries, Config.TGAntiFloodMaxDelay = retries, maxDelay }()
+
+	flood := func(retryAfter int) tg.Error {
+		return tg.Error{Code: 429, Message: "Too Many Requests: retry after", Parameters: &tg.ResponseParameters{RetryAfter: retryAfter}}
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		attempt   int
+		want      time.Duration
+		wantRetry bool
+	}{
+		{"no error", nil, 0, 0, false},
+		{"other error", errors.New("timeout"), 0, 0, false},
+		{"retry_after", flood(3), 0, time.Second * 3, true},
+		{"backoff", flood(3), 2, time.Second * 12, true},
+		{"without retry_after", flood(0), 1, time.Second * 2, true},
+		{"retries exceeded", flood(1), 3, 0, false},
+		{"wait is too long", flood(60), 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, retry := antiFloodRetryDelay(tt.err, tt.attempt)
+		if got != tt.want || retry != tt.wantRetry {
+			t.Errorf("%q. antiFloodRetryDelay() = %s, %v, want %s, %v", tt.name, got, retry, tt.want, tt.wantRetry)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package integram
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chat command to manage the API keys: '/api key [scopes]', '/api keys', '/api rotate key_id' and '/api revoke'
+const apiKeyCommand = "api"
+
+// prefix helps to recognize the leaked keys
+const apiKeyPrefix = "ik_"
+
+// number of the key's chars after apiKeyPrefix stored to identify the key in lists and admin commands
+const apiKeyIDLength = 8
+
+// rotated key remains valid for this period to let the clients switch to the new one
+const apiKeyRotationGracePeriod = time.Hour * 24
+
+// API key scopes
+const (
+	APIScopeSend   = "send"   // send messages to the key's chats
+	APIScopeStats  = "stats"  // read the key's chats stats
+	APIScopeEvents = "events" // stream the key's chats events
+)
+
+var apiScopes = []string{APIScopeSend, APIScopeStats, APIScopeEvents}
+
+func init() {
+	registerAdminCommand("apikeys", adminAPIKeys)
+}
+
+// apiKey is stored in the "api_keys" collection. The key itself is not stored, only its hash
+type apiKey struct {
+	Hash       string     `bson:"_id"`
+	KeyID      string     `bson:"id,omitempty"` // first chars of the key to identify it
+	Service    string     `bson:"s"`
+	ChatID     int64      `bson:"c"`
+	Chats      []int64    `bson:"cs,omitempty"` // additional chats allowed for the key
+	Scopes     []string   `bson:"sc,omitempty"` // empty for the keys created before the scopes and means APIScopeSend
+	CreatedBy  int64      `bson:"u"`
+	CreatedAt  time.Time  `bson:"d"`
+	LastUsedAt *time.Time `bson:"l,omitempty"`
+	Uses       int        `bson:"n"`
+	ExpiresAt  *time.Time `bson:"x,omitempty"` // set for the rotated keys
+}
+
+// apiMessage is the body of POST /api/v1/chats/:id/messages
+type apiMessage struct {
+	Text              string              `json:"text"`
+	ParseMode         string              `json:"parse_mode"` // "HTML", "Markdown" or "MarkdownV2"
+	Silent            bool                `json:"silent"`
+	DisableWebPreview bool                `json:"disable_web_page_preview"`
+	ReplyToMsgID      int                 `json:"reply_to_message_id"`
+	InlineKeyboard    [][]apiInlineButton `json:"inline_keyboard"`
+	EventID           string              `json:"event_id"` // to reference the message later. Generated if empty
+}
+
+// apiInlineButton is the URL button. Callback buttons aren't supported because there is no service to handle them
+type apiInlineButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+func apiKeyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// createAPIKey generates the key allowed to send messages to the chat through the service's bot
+func createAPIKey(db *mgo.Database, service string, chatID int64, userID int64) (string, error) {
+	return insertAPIKey(db, apiKey{Service: service, ChatID: chatID, Scopes: []string{APIScopeSend}, CreatedBy: userID})
+}
+
+// insertAPIKey generates the key with the k's service, chats and scopes
+func insertAPIKey(db *mgo.Database, k apiKey) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	k.Hash = apiKeyHash(key)
+	k.KeyID = key[len(apiKeyPrefix) : len(apiKeyPrefix)+apiKeyIDLength]
+	k.CreatedAt = time.Now()
+	k.LastUsedAt = nil
+	k.Uses = 0
+	k.ExpiresAt = nil
+
+	err := db.C("api_keys").Insert(k)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// parseAPIScopes validates the comma-separated scopes
+func parseAPIScopes(s string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(strings.ToLower(s), ",") {
+		if !SliceContainsString(apiScopes, scope) {
+			return nil, fmt.Errorf("unknown scope '%s', available: %s", scope, strings.Join(apiScopes, ", "))
+		}
+		if !SliceContainsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// allows returns true if the key is valid for the chat and scope
+func (k apiKey) allows(chatID int64, scope string) bool {
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+
+	if chatID != k.ChatID {
+		allowedChat := false
+		for _, id := range k.Chats {
+			if id == chatID {
+				allowedChat = true
+				break
+			}
+		}
+		if !allowedChat {
+			return false
+		}
+	}
+
+	if len(k.Scopes) == 0 {
+		return scope == APIScopeSend
+	}
+	return SliceContainsString(k.Scopes, scope)
+}
+
+// rotateAPIKey creates the new key with the same chats and scopes. The old one expires after apiKeyRotationGracePeriod
+func rotateAPIKey(db *mgo.Database, k apiKey, userID int64) (string, error) {
+	newKey := k
+	newKey.CreatedBy = userID
+	key, err := insertAPIKey(db, newKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.C("api_keys").UpdateId(k.Hash, bson.M{"$set": bson.M{"x": time.Now().Add(apiKeyRotationGracePeriod)}})
+	if err != nil {
+		db.C("api_keys").RemoveId(apiKeyHash(key))
+		return "", err
+	}
+
+	return key, nil
+}
+
+// findAPIKeyByID returns the service's key by the first chars of the key
+func findAPIKeyByID(db *mgo.Database, service string, keyID string) (*apiKey, error) {
+	var k apiKey
+	err := db.C("api_keys").Find(bson.M{"s": service, "id": strings.TrimPrefix(keyID, apiKeyPrefix)}).One(&k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// trackAPIKeyUsage counts the key's authorized requests
+func trackAPIKeyUsage(db *mgo.Database, k *apiKey) {
+	err := db.C("api_keys").UpdateId(k.Hash, bson.M{"$set": bson.M{"l": time.Now()}, "$inc": bson.M{"n": 1}})
+	if err != nil {
+		log.WithError(err).Error("Can't track the API key usage")
+	}
+}
+
+func (k apiKey) String() string {
+	id := k.KeyID
+	if id == "" {
+		id = "legacy_" + k.Hash[:apiKeyIDLength]
+	}
+
+	scopes := k.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{APIScopeSend}
+	}
+
+	s := fmt.Sprintf("%s%s chat %d", apiKeyPrefix, id, k.ChatID)
+	for _, c := range k.Chats {
+		s += fmt.Sprintf(",%d", c)
+	}
+	s += fmt.Sprintf(" [%s], %d requests", strings.Join(scopes, ","), k.Uses)
+	if k.LastUsedAt != nil {
+		s += ", last " + k.LastUsedAt.UTC().Format("2006-01-02 15:04")
+	}
+	if k.ExpiresAt != nil {
+		s += ", expires " + k.ExpiresAt.UTC().Format("2006-01-02 15:04")
+	}
+	return s
+}
+
+// revokeAPIKeys removes all the chat's keys for the service
+func revokeAPIKeys(db *mgo.Database, service string, chatID int64) (int, error) {
+	info, err := db.C("api_keys").RemoveAll(bson.M{"s": service, "c": chatID})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+func findAPIKey(db *mgo.Database, key string) (*apiKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, mgo.ErrNotFound
+	}
+
+	var k apiKey
+	err := db.C("api_keys").FindId(apiKeyHash(key)).One(&k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// outgoingMessage converts the request to the message
+func (m apiMessage) outgoingMessage(c *Context) (*OutgoingMessage, error) {
+	if strings.TrimSpace(m.Text) == "" {
+		return nil, errors.New("text is empty")
+	}
+
+	msg := c.NewMessage().SetText(m.Text)
+
+	switch strings.ToLower(m.ParseMode) {
+	case "":
+	case "html":
+		msg.EnableHTML()
+	case "markdown":
+		msg.EnableMarkdown()
+	case "markdownv2":
+		msg.EnableMarkdownV2()
+	default:
+		return nil, fmt.Errorf("unknown parse_mode '%s'", m.ParseMode)
+	}
+
+	if m.Silent {
+		msg.Silent = true
+	}
+
+	if m.DisableWebPreview {
+		msg.DisableWebPreview()
+	}
+
+	if m.ReplyToMsgID != 0 {
+		msg.SetReplyToMsgID(m.ReplyToMsgID)
+	}
+
+	if len(m.InlineKeyboard) > 0 {
+		kb := InlineKeyboard{}
+		for _, row := range m.InlineKeyboard {
+			buttons := InlineButtons{}
+			for _, b := range row {
+				if b.Text == "" || b.URL == "" {
+					return nil, errors.New("inline button must have both text and url")
+				}
+				buttons.AddURL(b.URL, b.Text)
+			}
+			kb.Buttons = append(kb.Buttons, buttons)
+		}
+		msg.SetInlineKeyboard(kb)
+	}
+
+	eventID := m.EventID
+	if eventID == "" {
+		eventID = apiMessageEventIDPrefix + rndStr.Get(16)
+	}
+	msg.AddEventID(eventID)
+
+	return msg, nil
+}
+
+// apiHandler serves the API authorized with 'Authorization: Bearer api_key' header:
+// POST /api/v1/chats/:id/messages with APIScopeSend
+// GET /api/v1/chats/:id/stats with APIScopeStats
+// GET /api/v1/chats/:id/events with APIScopeEvents
+func apiHandler(c *gin.Context) {
+	if c.Param("param1") != "api" || c.Param("param2") != "v1" {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	if c.Param("param3") == "services" && c.Request.Method == "GET" && c.Param("param5") == "events" {
+		serviceEventsHandler(c, c.Param("param4"))
+		return
+	}
+
+	if c.Param("param3") != "chats" {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	var scope string
+	switch {
+	case c.Request.Method == "POST" && c.Param("param5") == "messages":
+		scope = APIScopeSend
+	case c.Request.Method == "GET" && c.Param("param5") == "stats":
+		scope = APIScopeStats
+	case c.Request.Method == "GET" && c.Param("param5") == "events":
+		scope = APIScopeEvents
+	default:
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+
+	chatID, err := strconv.ParseInt(c.Param("param4"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong chat id"})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		key = c.Request.Header.Get("X-Integram-Api-Key")
+	}
+
+	db := c.MustGet("db").(*mgo.Database)
+	k, err := findAPIKey(db, key)
+	if err == mgo.ErrNotFound || k != nil && !k.allows(chatID, scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not valid for this chat or action"})
+		return
+	} else if err != nil {
+		log.WithError(err).Error("apiHandler: can't find the API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	s, _ := serviceByName(k.Service)
+	if s == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
+		return
+	}
+
+	if Config.IsMainInstance() {
+		proxy := reverseProxyForService(s.Name)
+		proxy.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	if rateLimitAndSetHeaders(c, "a"+k.Hash) {
+		return
+	}
+
+	ctx := &Context{db: db, gin: c, ServiceName: s.Name}
+	ctx.Chat = Chat{ID: chatID, ctx: ctx}
+
+	if scope == APIScopeEvents {
+		trackAPIKeyUsage(db, k)
+		streamChatEvents(c, s.Name, chatID)
+		return
+	}
+
+	if scope == APIScopeStats {
+		stats, err := apiChatStats(ctx)
+		if err != nil {
+			ctx.Log().WithError(err).Error("apiHandler: can't get the chat stats")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+
+		trackAPIKeyUsage(db, k)
+		c.JSON(http.StatusOK, stats)
+		return
+	}
+
+	var req apiMessage
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	msg, err := req.outgoingMessage(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = msg.Send()
+	if err != nil {
+		ctx.Log().WithError(err).Error("apiHandler: can't send the message")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trackAPIKeyUsage(db, k)
+	ref := ctx.MessageRef(&msg.Message)
+	// "id" is kept for the existing clients, use "ref" to reference the message
+	c.JSON(http.StatusAccepted, gin.H{"id": msg.ID.Hex(), "ref": ref.String()})
+}
+
+// apiChatStats returns the number of the bot's messages in the chat
+func apiChatStats(c *Context) (gin.H, error) {
+	botID := c.Bot().ID
+	now := time.Now()
+
+	count := func(since time.Time) (int, error) {
+		return c.db.C("messages").Find(bson.M{"chatid": c.Chat.ID, "botid": botID, "fromid": botID, "_id": bson.M{"$gt": bson.NewObjectIdWithTime(since)}}).Count()
+	}
+
+	day, err := count(now.Add(-time.Hour * 24))
+	if err != nil {
+		return nil, err
+	}
+
+	week, err := count(now.Add(-time.Hour * 24 * 7))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := gin.H{"chat_id": c.Chat.ID, "messages_24h": day, "messages_7d": week}
+
+	var last Message
+	err = c.db.C("messages").Find(bson.M{"chatid": c.Chat.ID, "botid": botID, "fromid": botID}).Sort("-_id").Select(bson.M{"date": 1}).One(&last)
+	if err == nil {
+		stats["last_message_at"] = last.Date.UTC().Format(time.RFC3339)
+	} else if err != mgo.ErrNotFound {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// handleAPIKeyCommand process '/api key [scopes]', '/api keys', '/api rotate key_id' and '/api revoke' sent by the chat admin. The keys are sent to the private chat. Returns true if message was handled
+func (c *Context) handleAPIKeyCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	args := strings.Fields(param)
+	if cmd != coreCommand(apiKeyCommand) || len(args) == 0 || !SliceContainsString([]string{"key", "keys", "rotate", "revoke"}, args[0]) {
+		return false
+	}
+
+	reply := func(text string) {
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleAPIKeyCommand: can't send the reply")
+		}
+	}
+
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("handleAPIKeyCommand: can't check chat admin")
+		reply("Can't check your permissions in this chat. Please try again later")
+		return true
+	} else if !isAdmin {
+		reply("Only chat admins can manage API keys")
+		return true
+	}
+
+	switch args[0] {
+	case "revoke":
+		n, err := revokeAPIKeys(c.db, c.ServiceName, c.Chat.ID)
+		if err != nil {
+			c.Log().WithError(err).Error("handleAPIKeyCommand: can't revoke the keys")
+			reply("Can't revoke API keys. Please try again later")
+			return true
+		}
+		reply(fmt.Sprintf("%d API keys revoked", n))
+		return true
+	case "keys":
+		var keys []apiKey
+		err := c.db.C("api_keys").Find(bson.M{"s": c.ServiceName, "c": c.Chat.ID}).Sort("d").All(&keys)
+		if err != nil {
+			c.Log().WithError(err).Error("handleAPIKeyCommand: can't find the keys")
+			reply("Can't get API keys. Please try again later")
+			return true
+		}
+		if len(keys) == 0 {
+			reply(fmt.Sprintf("No API keys for this chat. Create one with /%s key", coreCommand(apiKeyCommand)))
+			return true
+		}
+
+		lines := []string{"API keys of this chat:"}
+		for _, k := range keys {
+			lines = append(lines, k.String())
+		}
+		reply(strings.Join(lines, "\n"))
+		return true
+	case "rotate":
+		if len(args) < 2 {
+			reply(fmt.Sprintf("Specify the key ID from /%s keys", coreCommand(apiKeyCommand)))
+			return true
+		}
+
+		k, err := findAPIKeyByID(c.db, c.ServiceName, args[1])
+		if err != nil || k.ChatID != c.Chat.ID {
+			reply("API key not found")
+			return true
+		}
+
+		key, err := rotateAPIKey(c.db, *k, c.User.ID)
+		if err != nil {
+			c.Log().WithError(err).Error("handleAPIKeyCommand: can't rotate the key")
+			reply("Can't rotate API key. Please try again later")
+			return true
+		}
+
+		c.sendAPIKeyPrivately(key, reply, fmt.Sprintf("The previous key remains valid for %d hours", int(apiKeyRotationGracePeriod.Hours())))
+		return true
+	}
+
+	scopes := []string{APIScopeSend}
+	if len(args) > 1 {
+		var err error
+		scopes, err = parseAPIScopes(args[1])
+		if err != nil {
+			reply(err.Error())
+			return true
+		}
+	}
+
+	key, err := insertAPIKey(c.db, apiKey{Service: c.ServiceName, ChatID: c.Chat.ID, Scopes: scopes, CreatedBy: c.User.ID})
+	if err != nil {
+		c.Log().WithError(err).Error("handleAPIKeyCommand: can't create the key")
+		reply("Can't create API key. Please try again later")
+		return true
+	}
+
+	c.sendAPIKeyPrivately(key, reply, "")
+	return true
+}
+
+// sendAPIKeyPrivately sends the key with the usage example to the user's private chat. The key is removed if it can't be delivered
+func (c *Context) sendAPIKeyPrivately(key string, reply func(text string), note string) {
+	chatName := "this chat"
+	if c.Chat.Title != "" {
+		chatName = c.Chat.Title
+	}
+
+	mrk := HTMLRichText{}
+	text := fmt.Sprintf("API key for %s:\n%s\n\nSend messages with:\n%s\n\nList the keys with /%s keys, revoke all keys of the chat with /%s revoke",
+		mrk.Bold(chatName), mrk.Pre(key),
+		mrk.Pre(fmt.Sprintf("curl -H 'Authorization: Bearer %s' -d '{\"text\":\"Hello\"}' %s/api/v1/chats/%d/messages", key, Config.BaseURL, c.Chat.ID)),
+		coreCommand(apiKeyCommand), coreCommand(apiKeyCommand))
+
+	if note != "" {
+		text += "\n\n" + mrk.EncodeEntities(note)
+	}
+
+	// key must not be visible for the other chat members
+	err := c.NewMessage().SetChat(c.User.ID).SetText(text).EnableHTML().DisableWebPreview().Send()
+	if err != nil {
+		c.db.C("api_keys").RemoveId(apiKeyHash(key))
+		reply("Can't send you the key. Please start the private chat with me first")
+		return
+	}
+
+	if !c.Chat.IsPrivate() {
+		reply("API key is sent to you in the private chat")
+	}
+}
+
+// adminAPIKeys: /integram apikeys [chat_id] | issue chat_id scopes [extra_chat_ids] | rotate key_id | revoke key_id
+func adminAPIKeys(c *Context, args []string) (string, error) {
+	usage := errors.New("Usage: apikeys [chat_id] | issue chat_id send,stats,events [chat_id,chat_id] | rotate key_id | revoke key_id")
+
+	if c.ServiceName == "" {
+		return "", errors.New("Run the command in the service's bot")
+	}
+
+	if len(args) == 0 || len(args) == 1 && args[0] != "issue" && args[0] != "rotate" && args[0] != "revoke" {
+		q := bson.M{"s": c.ServiceName}
+		if len(args) == 1 {
+			chatID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return "", usage
+			}
+			q["c"] = chatID
+		}
+
+		var keys []apiKey
+		err := c.db.C("api_keys").Find(q).Sort("-n").Limit(50).All(&keys)
+		if err != nil {
+			return "", err
+		}
+		if len(keys) == 0 {
+			return "No API keys", nil
+		}
+
+		var lines []string
+		for _, k := range keys {
+			lines = append(lines, k.String())
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	switch args[0] {
+	case "issue":
+		if len(args) < 3 {
+			return "", usage
+		}
+
+		k := apiKey{Service: c.ServiceName, CreatedBy: c.User.ID}
+		var err error
+		if k.ChatID, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return "", usage
+		}
+		if k.Scopes, err = parseAPIScopes(args[2]); err != nil {
+			return "", err
+		}
+		if len(args) > 3 {
+			for _, id := range strings.Split(args[3], ",") {
+				chatID, err := strconv.ParseInt(id, 10, 64)
+				if err != nil {
+					return "", usage
+				}
+				k.Chats = append(k.Chats, chatID)
+			}
+		}
+
+		key, err := insertAPIKey(c.db, k)
+		if err != nil {
+			return "", err
+		}
+		return "API key issued: " + key, nil
+	case "rotate", "revoke":
+		if len(args) < 2 {
+			return "", usage
+		}
+
+		k, err := findAPIKeyByID(c.db, c.ServiceName, args[1])
+		if err == mgo.ErrNotFound {
+			return "", fmt.Errorf("API key %s not found", args[1])
+		} else if err != nil {
+			return "", err
+		}
+
+		if args[0] == "revoke" {
+			err = c.db.C("api_keys").RemoveId(k.Hash)
+			if err != nil {
+				return "", err
+			}
+			return "API key revoked: " + k.String(), nil
+		}
+
+		key, err := rotateAPIKey(c.db, *k, c.User.ID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("API key rotated: %s\nThe previous key remains valid for %d hours", key, int(apiKeyRotationGracePeriod.Hours())), nil
+	}
+
+	return "", usage
+}
@@ -0,0 +1,111 @@
+package integram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_createAPIKey(t *testing.T) {
+	defer db.C("api_keys").RemoveAll(bson.M{"c": -9999999999})
+
+	key, err := createAPIKey(db, "servicewithbottoken", -9999999999, 9999999999)
+	if err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		t.Errorf("createAPIKey() = %s, want prefix %s", key, apiKeyPrefix)
+	}
+
+	if n, _ := db.C("api_keys").FindId(key).Count(); n > 0 {
+		t.Error("createAPIKey() must not store the key itself")
+	}
+
+	k, err := findAPIKey(db, key)
+	if err != nil {
+		t.Fatalf("findAPIKey() error = %v", err)
+	}
+	if k.ChatID != -9999999999 || k.Service != "servicewithbottoken" || k.CreatedBy != 9999999999 {
+		t.Errorf("findAPIKey() = %+v", k)
+	}
+
+	if _, err := findAPIKey(db, key+"x"); err != mgo.ErrNotFound {
+		t.Errorf("findAPIKey() with wrong key error = %v, want not found", err)
+	}
+
+	if _, err := findAPIKey(db, strings.TrimPrefix(key, apiKeyPrefix)); err != mgo.ErrNotFound {
+		t.Errorf("findAPIKey() without prefix error = %v, want not found", err)
+	}
+
+	n, err := revokeAPIKeys(db, "servicewithbottoken", -9999999999)
+	if err != nil || n != 1 {
+		t.Errorf("revokeAPIKeys() = %d, %v, want 1 key revoked", n, err)
+	}
+
+	if _, err := findAPIKey(db, key); err != mgo.ErrNotFound {
+		t.Errorf("findAPIKey() after revoke error = %v, want not found", err)
+	}
+}
+
+func Test_apiKey_allows(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name   string
+		k      apiKey
+		chatID int64
+		scope  string
+		want   bool
+	}{
+		{"legacy key send", apiKey{ChatID: -1}, -1, APIScopeSend, true},
+		{"legacy key stats", apiKey{ChatID: -1}, -1, APIScopeStats, false},
+		{"other chat", apiKey{ChatID: -1, Scopes: []string{APIScopeSend}}, -2, APIScopeSend, false},
+		{"additional chat", apiKey{ChatID: -1, Chats: []int64{-2}, Scopes: []string{APIScopeSend}}, -2, APIScopeSend, true},
+		{"stats only", apiKey{ChatID: -1, Scopes: []string{APIScopeStats}}, -1, APIScopeSend, false},
+		{"expired", apiKey{ChatID: -1, Scopes: []string{APIScopeSend}, ExpiresAt: &expired}, -1, APIScopeSend, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.k.allows(tt.chatID, tt.scope); got != tt.want {
+				t.Errorf("apiKey.allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rotateAPIKey(t *testing.T) {
+	defer db.C("api_keys").RemoveAll(bson.M{"c": -9999999999})
+
+	key, err := insertAPIKey(db, apiKey{Service: "servicewithbottoken", ChatID: -9999999999, Scopes: []string{APIScopeStats}, CreatedBy: 9999999999})
+	if err != nil {
+		t.Fatalf("insertAPIKey() error = %v", err)
+	}
+
+	k, _ := findAPIKey(db, key)
+	if k == nil || !strings.HasPrefix(key, apiKeyPrefix+k.KeyID) {
+		t.Fatalf("insertAPIKey() stored %+v", k)
+	}
+
+	if byID, err := findAPIKeyByID(db, "servicewithbottoken", apiKeyPrefix+k.KeyID); err != nil || byID.Hash != k.Hash {
+		t.Errorf("findAPIKeyByID() = %+v, %v", byID, err)
+	}
+
+	newKey, err := rotateAPIKey(db, *k, 9999999999)
+	if err != nil {
+		t.Fatalf("rotateAPIKey() error = %v", err)
+	}
+
+	old, _ := findAPIKey(db, key)
+	if old == nil || old.ExpiresAt == nil || !old.allows(-9999999999, APIScopeStats) {
+		t.Errorf("rotateAPIKey() previous key = %+v, want valid during the grace period", old)
+	}
+
+	rotated, _ := findAPIKey(db, newKey)
+	if rotated == nil || rotated.ExpiresAt != nil || !rotated.allows(-9999999999, APIScopeStats) || rotated.allows(-9999999999, APIScopeSend) {
+		t.Errorf("rotateAPIKey() new key = %+v, want the same scopes", rotated)
+	}
+}
@@ -0,0 +1,264 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// AttachmentSource specifies where the attachment's content is located
+type AttachmentSource string
+
+const (
+	AttachmentSourceURL    AttachmentSource = "url"     // remote URL, f.e. upstream's file
+	AttachmentSourceFileID AttachmentSource = "file_id" // file already uploaded to Telegram
+	AttachmentSourceLocal  AttachmentSource = "local"   // local file path
+	AttachmentSourceStream AttachmentSource = "stream"  // io.Reader, e.g. upstream's response body
+)
+
+// max duration of the attachment's download, including reading the body
+const attachmentDownloadTimeout = time.Minute * 5
+
+var attachmentHTTPClient = &http.Client{Timeout: attachmentDownloadTimeout}
+
+// Attachment is the file used both in incoming and outgoing messages so services can move files between Telegram and upstreams without dealing with TG types
+type Attachment struct {
+	Kind   FileType
+	Source AttachmentSource
+
+	URL       string    `bson:",omitempty"`
+	FileID    string    `bson:",omitempty"`
+	LocalPath string    `bson:",omitempty"`
+	Reader    io.Reader `bson:"-" json:"-"`
+
+	Name string `bson:",omitempty"`
+	Mime string `bson:",omitempty"`
+	Size int64  `bson:",omitempty"`
+}
+
+// AttachmentFromURL returns the attachment that will be downloaded from URL
+func AttachmentFromURL(kind FileType, url string, name string) Attachment {
+	return Attachment{Kind: kind, Source: AttachmentSourceURL, URL: url, Name: name}
+}
+
+// AttachmentFromFileID returns the attachment already uploaded to Telegram
+func AttachmentFromFileID(kind FileType, fileID string, name string) Attachment {
+	return Attachment{Kind: kind, Source: AttachmentSourceFileID, FileID: fileID, Name: name}
+}
+
+// AttachmentFromLocalPath returns the attachment for the local file
+func AttachmentFromLocalPath(kind FileType, localPath string, name string) Attachment {
+	if name == "" {
+		name = filepath.Base(localPath)
+	}
+	return Attachment{Kind: kind, Source: AttachmentSourceLocal, LocalPath: localPath, Name: name}
+}
+
+// AttachmentFromReader returns the attachment that will be read from r
+func AttachmentFromReader(kind FileType, r io.Reader, name string) Attachment {
+	return Attachment{Kind: kind, Source: AttachmentSourceStream, Reader: r, Name: name}
+}
+
+// Attachment returns the media of incoming message as Attachment. Returns nil if message has no media
+func (m *IncomingMessage) Attachment(c *Context) (*Attachment, error) {
+	info, err := m.GetFileInfo(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.ID == "" {
+		return nil, nil
+	}
+
+	return &Attachment{
+		Kind:   info.Type,
+		Source: AttachmentSourceFileID,
+		FileID: info.ID,
+		Name:   info.Name,
+		Mime:   info.Mime,
+		Size:   info.Size,
+	}, nil
+}
+
+// LocalFile returns the local path of the attachment, downloading or saving it to the temp file if needed
+func (a *Attachment) LocalFile(c *Context) (string, error) {
+	switch a.Source {
+	case AttachmentSourceLocal:
+		return a.LocalPath, nil
+	case AttachmentSourceFileID:
+		return GetLocalFilePath(c, a.FileID)
+	case AttachmentSourceURL:
+		return c.DownloadURL(a.URL)
+	case AttachmentSourceStream:
+		if a.Reader == nil {
+			return "", errors.New("Attachment's Reader is nil")
+		}
+
+		out, err := ioutil.TempFile("", fmt.Sprintf("%d_%d_", c.Bot().ID, c.Chat.ID))
+		if err != nil {
+			return "", err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, a.Reader)
+		if err != nil {
+			os.Remove(out.Name())
+			return "", err
+		}
+
+		return out.Name(), nil
+	}
+
+	return "", fmt.Errorf("Unknown attachment source '%s'", a.Source)
+}
+
+// Open returns the reader of attachment's content. It must be closed after use
+func (a *Attachment) Open(c *Context) (io.ReadCloser, error) {
+	switch a.Source {
+	case AttachmentSourceStream:
+		if a.Reader == nil {
+			return nil, errors.New("Attachment's Reader is nil")
+		}
+		return ioutil.NopCloser(a.Reader), nil
+	case AttachmentSourceURL:
+		resp, err := attachmentHTTPClient.Get(a.URL)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, errors.New("non 2xx resp status")
+		}
+		return resp.Body, nil
+	}
+
+	localPath, err := a.LocalFile(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(localPath)
+}
+
+// SetAttachment adds the attachment to the message. URL attachments are downloaded when the message is sent, stream attachments are saved to the temp file.
+// Both are removed after the message is sent. If the attachment can't be added, the error is returned by Send
+func (m *OutgoingMessage) SetAttachment(a Attachment) *OutgoingMessage {
+	fileType := attachmentFileType(a.Kind)
+
+	if a.Source == AttachmentSourceFileID {
+		m.FileID = a.FileID
+		m.FileName = a.Name
+		m.FileType = fileType
+		return m
+	}
+
+	if a.Source == AttachmentSourceURL {
+		m.FileURL = a.URL
+		m.FileName = a.Name
+		m.FileType = fileType
+		return m
+	}
+
+	if m.ctx == nil {
+		m.attachmentErr = errors.New("SetAttachment: message has no context, use Context.NewMessage()")
+		return m
+	}
+
+	localPath, err := a.LocalFile(m.ctx)
+	if err != nil {
+		m.attachmentErr = fmt.Errorf("SetAttachment: can't get the local file: %s", err.Error())
+		return m
+	}
+
+	m.FileName = a.Name
+	m.FileType = fileType
+	m.setScannedFile(m.ctx, localPath, a.Source == AttachmentSourceStream)
+
+	return m
+}
+
+// setScannedFile sets the local file if it passed the virus scan. Otherwise the message is sent with the warning instead of the file
+func (m *OutgoingMessage) setScannedFile(c *Context, localPath string, removeAfter bool) {
+	err := c.scanFile(localPath, m.FileName, fileScanToChat)
+	if err != nil {
+		c.Log().WithError(err).Error("SetAttachment: file is blocked")
+		if removeAfter && !IsFileInfected(err) {
+			os.Remove(localPath)
+		}
+		m.Text = strings.TrimSpace(m.Text + "\n\n⚠️ " + err.Error())
+		return
+	}
+
+	m.FilePath = localPath
+	m.FileRemoveAfter = removeAfter
+}
+
+// downloadFileURL downloads the URL attachment right before the message is sent, so the handler isn't blocked by the download
+func (m *OutgoingMessage) downloadFileURL(db *mgo.Database, bot *Bot) error {
+	c := m.ctx
+	if c == nil {
+		c = &Context{db: db, ServiceName: bot.services[0].Name, Chat: Chat{ID: m.ChatID}}
+	}
+
+	localPath, err := c.DownloadURL(m.FileURL)
+	if err != nil {
+		return fmt.Errorf("Can't download the attachment: %s", err.Error())
+	}
+
+	m.FileURL = ""
+	m.setScannedFile(c, localPath, true)
+	return nil
+}
+
+// SetPhoto adds the photo located at localPath to the message. Message's text is sent as the caption using the message's parse mode
+func (m *OutgoingMessage) SetPhoto(localPath string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromLocalPath(FileTypePhoto, localPath, ""))
+}
+
+// SetPhotoFromReader adds the photo read from r to the message, e.g. the screenshot rendered in memory
+func (m *OutgoingMessage) SetPhotoFromReader(r io.Reader, name string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromReader(FileTypePhoto, r, name))
+}
+
+// SetPhotoFromURL adds the photo downloaded from url to the message, e.g. the upstream's preview
+func (m *OutgoingMessage) SetPhotoFromURL(url string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromURL(FileTypePhoto, url, path.Base(url)))
+}
+
+// SetPhotoFileID adds the photo already uploaded to Telegram. Use SentMessage's Stored().FileID to reuse the photo without uploading it again
+func (m *OutgoingMessage) SetPhotoFileID(fileID string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromFileID(FileTypePhoto, fileID, ""))
+}
+
+// SetDocumentFromReader adds the document read from r to the message, e.g. the CSV export generated in memory
+func (m *OutgoingMessage) SetDocumentFromReader(r io.Reader, name string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromReader(FileTypeDocument, r, name))
+}
+
+// SetDocumentFromURL adds the document downloaded from url to the message, e.g. the upstream's build log
+func (m *OutgoingMessage) SetDocumentFromURL(url string, name string) *OutgoingMessage {
+	if name == "" {
+		name = path.Base(url)
+	}
+	return m.SetAttachment(AttachmentFromURL(FileTypeDocument, url, name))
+}
+
+// SetDocumentFileID adds the document already uploaded to Telegram
+func (m *OutgoingMessage) SetDocumentFileID(fileID string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromFileID(FileTypeDocument, fileID, ""))
+}
+
+// SendDocument sends the document to the current chat with the caption using the default parse mode. The same file sent again by the bot is not uploaded twice, its Telegram's file_id is reused
+func (c *Context) SendDocument(a Attachment, caption string) error {
+	a.Kind = FileTypeDocument
+	return c.NewMessage().SetText(caption).SetAttachment(a).Send()
+}
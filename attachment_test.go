@@ -0,0 +1,148 @@
+package integram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentFrom(t *testing.T) {
+	reader := strings.NewReader("data")
+	tests := []struct {
+		name string
+		got  Attachment
+		want Attachment
+	}{
+		{"URL", AttachmentFromURL(FileTypePhoto, "https://example.com/a.png", "a.png"), Attachment{Kind: FileTypePhoto, Source: AttachmentSourceURL, URL: "https://example.com/a.png", Name: "a.png"}},
+		{"file ID", AttachmentFromFileID(FileTypeDocument, "BQAD", "report.pdf"), Attachment{Kind: FileTypeDocument, Source: AttachmentSourceFileID, FileID: "BQAD", Name: "report.pdf"}},
+		{"local path", AttachmentFromLocalPath(FileTypeDocument, "/tmp/report.pdf", ""), Attachment{Kind: FileTypeDocument, Source: AttachmentSourceLocal, LocalPath: "/tmp/report.pdf", Name: "report.pdf"}},
+		{"local path with name", AttachmentFromLocalPath(FileTypeDocument, "/tmp/1234", "report.pdf"), Attachment{Kind: FileTypeDocument, Source: AttachmentSourceLocal, LocalPath: "/tmp/1234", Name: "report.pdf"}},
+		{"reader", AttachmentFromReader(FileTypeVoice, reader, "voice.ogg"), Attachment{Kind: FileTypeVoice, Source: AttachmentSourceStream, Reader: reader, Name: "voice.ogg"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%q. attachment = %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestAttachment_LocalFile(t *testing.T) {
+	c := &Context{db: db, ServiceName: "servicewithbottoken", Chat: Chat{ID: 9999999999}}
+
+	tests := []struct {
+		name    string
+		a       Attachment
+		want    string
+		wantErr bool
+	}{
+		{"local", AttachmentFromLocalPath(FileTypeDocument, "/tmp/report.pdf", ""), "/tmp/report.pdf", false},
+		{"stream", AttachmentFromReader(FileTypeDocument, strings.NewReader("a,b\n1,2\n"), "export.csv"), "a,b\n1,2\n", false},
+		{"nil reader", AttachmentFromReader(FileTypeDocument, nil, "export.csv"), "", true},
+		{"unknown source", Attachment{Source: "ftp"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.LocalFile(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Attachment.LocalFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.a.Source == AttachmentSourceStream && err == nil {
+				defer os.Remove(got)
+				data, _ := ioutil.ReadFile(got)
+				got = string(data)
+			}
+			if got != tt.want {
+				t.Errorf("Attachment.LocalFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachment_Open(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attachment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	localPath := filepath.Join(dir, "report.txt")
+	ioutil.WriteFile(localPath, []byte("local"), 0600)
+
+	tests := []struct {
+		name    string
+		a       Attachment
+		want    string
+		wantErr bool
+	}{
+		{"stream", AttachmentFromReader(FileTypeDocument, strings.NewReader("stream"), ""), "stream", false},
+		{"local", AttachmentFromLocalPath(FileTypeDocument, localPath, ""), "local", false},
+		{"missing local", AttachmentFromLocalPath(FileTypeDocument, filepath.Join(dir, "missing.txt"), ""), "", true},
+		{"nil reader", AttachmentFromReader(FileTypeDocument, nil, ""), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := tt.a.Open(&Context{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Attachment.Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Close()
+
+			data, _ := ioutil.ReadAll(r)
+			if string(data) != tt.want {
+				t.Errorf("Attachment.Open() content = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutgoingMessage_SetAttachment(t *testing.T) {
+	c := &Context{db: db, ServiceName: "servicewithbottoken", Chat: Chat{ID: 9999999999}}
+
+	tests := []struct {
+		name         string
+		a            Attachment
+		wantFileID   string
+		wantFileType string
+		wantName     string
+		wantRemove   bool
+	}{
+		{"file ID", AttachmentFromFileID(FileTypePhoto, "AgAD", "a.png"), "AgAD", "image", "a.png", false},
+		{"local", AttachmentFromLocalPath(FileTypeDocument, "/tmp/report.pdf", ""), "", "document", "report.pdf", false},
+		{"stream", AttachmentFromReader(FileTypeVoice, strings.NewReader("ogg"), "voice.ogg"), "", "voice", "voice.ogg", true},
+		{"URL downloaded on send", AttachmentFromURL(FileTypeDocument, "https://example.com/a.pdf", "a.pdf"), "", "document", "a.pdf", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := c.NewMessage().SetAttachment(tt.a)
+			if m.FilePath != "" && m.FileRemoveAfter {
+				defer os.Remove(m.FilePath)
+			}
+
+			if m.FileID != tt.wantFileID || m.FileType != tt.wantFileType || m.FileName != tt.wantName || m.FileRemoveAfter != tt.wantRemove {
+				t.Errorf("SetAttachment() = FileID %q, FileType %q, FileName %q, FileRemoveAfter %v, want %q, %q, %q, %v", m.FileID, m.FileType, m.FileName, m.FileRemoveAfter, tt.wantFileID, tt.wantFileType, tt.wantName, tt.wantRemove)
+			}
+			if (tt.a.Source == AttachmentSourceLocal || tt.a.Source == AttachmentSourceStream) && m.FilePath == "" {
+				t.Errorf("SetAttachment() FilePath is empty")
+			}
+			if tt.a.Source == AttachmentSourceURL && (m.FilePath != "" || m.FileURL != tt.a.URL) {
+				t.Errorf("SetAttachment() = FilePath %q, FileURL %q, want the URL to be downloaded on send", m.FilePath, m.FileURL)
+			}
+		})
+	}
+
+	m := (&OutgoingMessage{}).SetAttachment(AttachmentFromReader(FileTypeDocument, strings.NewReader("csv"), "export.csv"))
+	if m.FilePath != "" {
+		t.Errorf("SetAttachment() without the context = %q, want no file", m.FilePath)
+	}
+	m.ChatID, m.BotID = 9999999999, 1
+	if err := m.Send(); err == nil {
+		t.Errorf("Send() of the message without the attachment didn't return the error")
+	}
+}
@@ -0,0 +1,502 @@
+package integram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// weight of the last observed lag in the Telegram queue lag moving average
+const tgQueueLagWeight = 0.2
+
+// how often the main instance saves the Telegram queue state for the service instances
+const tgQueueStateSyncInterval = time.Second * 5
+
+// how often the spilled webhooks are checked for the replay
+const webhookSpillDrainInterval = time.Second
+
+// don't repeat the same backpressure alert more often than this
+const backpressureAlertInterval = time.Minute * 15
+
+// key of the request's context value to mark the replayed webhooks. Value is the time the webhook was spilled at
+type replayedWebhookKey struct{}
+
+// tgQueueState is the Telegram queue lag observed by the main instance and stored in the "telegram_queue" collection
+type tgQueueState struct {
+	ID        string        `bson:"_id"`
+	Lag       time.Duration `bson:"lag"`
+	UpdatedAt time.Time     `bson:"updatedat"`
+}
+
+// tgQueueLag tracks the moving average of the time messages wait in the Telegram queue
+type tgQueueLag struct {
+	sync.Mutex
+	lag      time.Duration
+	syncedAt time.Time // when the state was saved (main instance) or loaded (service instance)
+}
+
+// observe adds the lag of the message just taken from the queue
+func (q *tgQueueLag) observe(lag time.Duration) time.Duration {
+	q.Lock()
+	defer q.Unlock()
+
+	if lag < 0 {
+		lag = 0
+	}
+	q.lag = time.Duration(float64(q.lag)*(1-tgQueueLagWeight) + float64(lag)*tgQueueLagWeight)
+	return q.lag
+}
+
+func (q *tgQueueLag) get() time.Duration {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.lag
+}
+
+var tgQueue = &tgQueueLag{}
+
+// spilledWebhook is the incoming webhook request stored on disk to be processed later
+type spilledWebhook struct {
+	Method     string
+	URL        string
+	Host       string
+	RemoteAddr string
+	Header     http.Header
+	Body       []byte
+	SpilledAt  time.Time
+}
+
+// webhookSlots limits the number of webhooks processed simultaneously
+var webhookSlots chan struct{}
+
+// number of the webhooks waiting on disk
+var webhooksSpilled int64
+
+// webhookRouter is used to replay the spilled webhooks
+var webhookRouter http.Handler
+
+var backpressureAlertsMutex = sync.Mutex{}
+var backpressureAlerts = make(map[string]time.Time)
+
+func init() {
+	registerAdminCommand("backpressure", adminBackpressureReport)
+}
+
+// queueLag returns the time the message waits in the Telegram queue since it was scheduled
+func (m *OutgoingMessage) queueLag(now time.Time) time.Duration {
+	scheduledAt := m.ID.Time()
+	if m.SendAfter != nil && m.SendAfter.After(scheduledAt) {
+		scheduledAt = *m.SendAfter
+	}
+
+	return now.Sub(scheduledAt)
+}
+
+// observeTGQueueLag updates the Telegram queue lag with the message taken from the queue and returns the current average
+func observeTGQueueLag(db *mgo.Database, m *OutgoingMessage) time.Duration {
+	if !m.ID.Valid() {
+		return tgQueue.get()
+	}
+
+	lag := tgQueue.observe(m.queueLag(time.Now()))
+
+	if !Config.IsMainInstance() {
+		return lag
+	}
+
+	tgQueue.Lock()
+	if time.Since(tgQueue.syncedAt) < tgQueueStateSyncInterval {
+		tgQueue.Unlock()
+		return lag
+	}
+	tgQueue.syncedAt = time.Now()
+	tgQueue.Unlock()
+
+	_, err := db.C("telegram_queue").UpsertId("lag", tgQueueState{ID: "lag", Lag: lag, UpdatedAt: time.Now()})
+	if err != nil {
+		log.WithError(err).Error("observeTGQueueLag: can't save the state")
+	}
+
+	return lag
+}
+
+// currentTGQueueLag returns the Telegram queue lag. Service instances load it from DB as observed by the main instance
+func currentTGQueueLag(db *mgo.Database) time.Duration {
+	if !Config.IsStandAloneServiceInstance() {
+		return tgQueue.get()
+	}
+
+	tgQueue.Lock()
+	defer tgQueue.Unlock()
+
+	if time.Since(tgQueue.syncedAt) < tgQueueStateSyncInterval {
+		return tgQueue.lag
+	}
+	tgQueue.syncedAt = time.Now()
+
+	var state tgQueueState
+	err := db.C("telegram_queue").FindId("lag").One(&state)
+	if err != nil && err != mgo.ErrNotFound {
+		log.WithError(err).Error("currentTGQueueLag: can't load the state")
+		return tgQueue.lag
+	}
+
+	// the main instance saves the state only while sending, so the outdated state means the queue is empty
+	if time.Since(state.UpdatedAt) > tgQueueStateSyncInterval*6 {
+		state.Lag = 0
+	}
+
+	tgQueue.lag = state.Lag
+	return tgQueue.lag
+}
+
+// tgQueueBacklogged returns true if messages wait in the Telegram queue longer than INTEGRAM_TG_QUEUE_MAX_LAG
+func tgQueueBacklogged(lag time.Duration) bool {
+	return Config.TGQueueMaxLag > 0 && lag > Config.TGQueueMaxLag
+}
+
+// shouldShed returns true if the message can be dropped to relieve the backlogged Telegram queue
+func (m *OutgoingMessage) shouldShed(lag time.Duration) bool {
+	return m.LowPriority && tgQueueBacklogged(lag)
+}
+
+// shed drops the message, counts it in stats and alerts about the shedding
+func (m *OutgoingMessage) shed(db *mgo.Database, lag time.Duration) {
+	serviceName := ""
+	if m.ctx != nil {
+		serviceName = m.ctx.ServiceName
+	} else if bot := botByID(m.BotID); bot != nil && len(bot.services) > 0 {
+		serviceName = bot.services[0].Name
+	}
+
+	log.WithFields(log.Fields{"chat": m.ChatID, "bot": m.BotID, "lag": lag.String()}).Warn("Low priority message shed")
+
+	ctx := &Context{db: db, ServiceName: serviceName}
+	ctx.StatInc(StatMessageShed)
+
+	backpressureAlert("shed", fmt.Sprintf("Telegram queue lag is %s, low priority messages are shed", lag.Truncate(time.Second)))
+}
+
+// backpressureAlert logs the alert at most once per backpressureAlertInterval for the same kind
+func backpressureAlert(kind string, text string) {
+	backpressureAlertsMutex.Lock()
+	defer backpressureAlertsMutex.Unlock()
+
+	if at, exists := backpressureAlerts[kind]; exists && time.Since(at) < backpressureAlertInterval {
+		return
+	}
+	backpressureAlerts[kind] = time.Now()
+
+	log.WithField("alert", "backpressure").WithField("kind", kind).Error(text)
+}
+
+// webhookSpillDir returns the directory to store the spilled webhooks
+func webhookSpillDir() string {
+	if Config.WebhookSpillDir != "" {
+		return Config.WebhookSpillDir
+	}
+	return filepath.Join(Config.ConfigDir, "webhooks_spill")
+}
+
+// initWebhookBackpressure creates the webhook slots and counts the webhooks spilled before restart
+func initWebhookBackpressure(router http.Handler) {
+	webhookRouter = router
+
+	if Config.WebhookMaxInFlight <= 0 || Config.IsMainInstance() {
+		return
+	}
+
+	webhookSlots = make(chan struct{}, Config.WebhookMaxInFlight)
+
+	err := os.MkdirAll(webhookSpillDir(), 0700)
+	if err != nil {
+		log.WithError(err).Error("initWebhookBackpressure: can't create the spill dir")
+	}
+
+	names, _ := spilledWebhookFiles(webhookSpillDir())
+	atomic.StoreInt64(&webhooksSpilled, int64(len(names)))
+}
+
+// acquireWebhookSlot returns the release func if the webhook can be processed now. Replayed webhooks wait for the slot
+func acquireWebhookSlot(r *http.Request, backlogged bool) (release func(), ok bool) {
+	if webhookSlots == nil {
+		return func() {}, true
+	}
+
+	release = func() { <-webhookSlots }
+
+	if r.Context().Value(replayedWebhookKey{}) != nil {
+		webhookSlots <- struct{}{}
+		return release, true
+	}
+
+	// keep the order: process the new webhooks directly only after the spilled ones were replayed
+	if backlogged || atomic.LoadInt64(&webhooksSpilled) > 0 {
+		return nil, false
+	}
+
+	select {
+	case webhookSlots <- struct{}{}:
+		return release, true
+	default:
+		return nil, false
+	}
+}
+
+// webhookBackpressure spills the webhook to disk if it can't be processed now. Returns true if the response was written
+func webhookBackpressure(c *gin.Context, db *mgo.Database, serviceName string) (release func(), handled bool) {
+	if c.Request.Method != "POST" {
+		return func() {}, false
+	}
+
+	lag := currentTGQueueLag(db)
+
+	release, ok := acquireWebhookSlot(c.Request, tgQueueBacklogged(lag))
+	if ok {
+		return release, false
+	}
+
+	ctx := &Context{db: db, ServiceName: serviceName}
+
+	if atomic.LoadInt64(&webhooksSpilled) >= int64(Config.WebhookSpillMax) {
+		ctx.StatInc(StatWebhookRejected)
+		backpressureAlert("rejected", fmt.Sprintf("%d webhooks are spilled to disk, new webhooks are rejected. Telegram queue lag is %s", atomic.LoadInt64(&webhooksSpilled), lag.Truncate(time.Second)))
+
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "Too many webhooks in the queue, please retry later")
+		return nil, true
+	}
+
+	err := spillWebhook(webhookSpillDir(), c.Request)
+	if err != nil {
+		log.WithError(err).Error("webhookBackpressure: can't spill the webhook")
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "Webhook can't be queued, please retry later")
+		return nil, true
+	}
+
+	atomic.AddInt64(&webhooksSpilled, 1)
+	ctx.StatInc(StatWebhookSpilled)
+	backpressureAlert("spilled", fmt.Sprintf("Webhooks are spilled to disk. Telegram queue lag is %s", lag.Truncate(time.Second)))
+
+	c.String(http.StatusAccepted, "Webhook accepted and queued")
+	return nil, true
+}
+
+// newSpilledWebhook reads the request to store it
+func newSpilledWebhook(r *http.Request) (*spilledWebhook, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spilledWebhook{
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header,
+		Body:       body,
+		SpilledAt:  time.Now(),
+	}, nil
+}
+
+// request restores the stored request marked as replayed
+func (w *spilledWebhook) request() (*http.Request, error) {
+	r, err := http.NewRequest(w.Method, w.URL, bytes.NewReader(w.Body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = w.Header
+	r.Host = w.Host
+	r.RemoteAddr = w.RemoteAddr
+
+	return r.WithContext(context.WithValue(r.Context(), replayedWebhookKey{}, w.SpilledAt)), nil
+}
+
+// spillWebhook stores the request in the dir
+func spillWebhook(dir string, r *http.Request) error {
+	w, err := newSpilledWebhook(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+
+	return writeSpillFile(dir, w.SpilledAt, data)
+}
+
+// writeSpillFile stores the data in the dir. Files are named to be sorted in the order of arrival
+func writeSpillFile(dir string, at time.Time, data []byte) error {
+	name := fmt.Sprintf("%019d_%s.json", at.UnixNano(), rndStr.Get(6))
+
+	// write to the temp file first so the drainer never reads the partial one
+	tmp := filepath.Join(dir, "."+name)
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+// spilledWebhookFiles returns the names of the spilled webhooks in the order of arrival
+func spilledWebhookFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readSpilledWebhook restores the request from the file
+func readSpilledWebhook(path string) (*http.Request, *spilledWebhook, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var w spilledWebhook
+	err = json.Unmarshal(data, &w)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := w.request()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, &w, nil
+}
+
+// webhookSpillDrainer replays the spilled webhooks once the Telegram queue is no longer backlogged
+func webhookSpillDrainer() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("webhookSpillDrainer panic recovered %v", r)
+			webhookSpillDrainer()
+		}
+	}()
+
+	if webhookSlots == nil {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	dir := webhookSpillDir()
+
+	for {
+		time.Sleep(webhookSpillDrainInterval)
+
+		if atomic.LoadInt64(&webhooksSpilled) == 0 || tgQueueBacklogged(currentTGQueueLag(db)) {
+			continue
+		}
+
+		names, err := spilledWebhookFiles(dir)
+		if err != nil {
+			log.WithError(err).Error("webhookSpillDrainer: can't list the spilled webhooks")
+			continue
+		}
+		atomic.StoreInt64(&webhooksSpilled, int64(len(names)))
+
+		// replay the batch using at most half of the slots to leave the rest for the new webhooks
+		parallel := cap(webhookSlots)/2 + 1
+		wg := sync.WaitGroup{}
+		sem := make(chan struct{}, parallel)
+
+		for i, name := range names {
+			if i > 0 && i%parallel == 0 && tgQueueBacklogged(currentTGQueueLag(db)) {
+				break
+			}
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(path string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				replaySpilledWebhook(path)
+			}(filepath.Join(dir, name))
+		}
+		wg.Wait()
+	}
+}
+
+// serveSpilledWebhook processes the spilled webhook with the router
+func serveSpilledWebhook(path string) {
+	r, w, err := readSpilledWebhook(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Error("serveSpilledWebhook: can't read the spilled webhook")
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	webhookRouter.ServeHTTP(rec, r)
+
+	if rec.Code >= 500 {
+		log.WithFields(log.Fields{"url": w.URL, "code": rec.Code}).Errorf("Spilled webhook replay failed: %s", rec.Body.String())
+	} else {
+		log.WithFields(log.Fields{"url": w.URL, "code": rec.Code}).Debugf("Spilled webhook replayed after %.2f secs", time.Since(w.SpilledAt).Seconds())
+	}
+}
+
+// replaySpilledWebhook processes the spilled webhook and removes its file
+func replaySpilledWebhook(path string) {
+	serveSpilledWebhook(path)
+
+	err := os.Remove(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Error("replaySpilledWebhook: can't remove the file")
+		return
+	}
+	atomic.AddInt64(&webhooksSpilled, -1)
+}
+
+// adminBackpressureReport: /integram backpressure
+func adminBackpressureReport(c *Context, args []string) (string, error) {
+	lag := currentTGQueueLag(c.db)
+
+	lines := []string{fmt.Sprintf("Telegram queue lag: %s (max %s)", lag.Truncate(time.Millisecond), Config.TGQueueMaxLag)}
+	if tgQueueBacklogged(lag) {
+		lines = append(lines, "Telegram queue is backlogged: low priority messages are shed, webhooks are spilled to disk")
+	}
+
+	if webhookSlots != nil {
+		lines = append(lines, fmt.Sprintf("Webhooks in flight: %d/%d", len(webhookSlots), cap(webhookSlots)))
+		lines = append(lines, fmt.Sprintf("Webhooks spilled: %d/%d", atomic.LoadInt64(&webhooksSpilled), Config.WebhookSpillMax))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
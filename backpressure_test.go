@@ -0,0 +1,166 @@
+package integram
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestOutgoingMessage_shouldShed(t *testing.T) {
+	maxLag := Config.TGQueueMaxLag
+	defer func() { Config.TGQueueMaxLag = maxLag }()
+
+	Config.TGQueueMaxLag = time.Second * 30
+
+	tests := []struct {
+		name        string
+		lowPriority bool
+		lag         time.Duration
+		maxLag      time.Duration
+		want        bool
+	}{
+		{"low priority, backlogged", true, time.Minute, time.Second * 30, true},
+		{"low priority, not backlogged", true, time.Second * 10, time.Second * 30, false},
+		{"normal priority, backlogged", false, time.Minute, time.Second * 30, false},
+		{"shedding disabled", true, time.Hour, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config.TGQueueMaxLag = tt.maxLag
+			m := &OutgoingMessage{LowPriority: tt.lowPriority}
+			if got := m.shouldShed(tt.lag); got != tt.want {
+				t.Errorf("OutgoingMessage.shouldShed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutgoingMessage_queueLag(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		id        bson.ObjectId
+		sendAfter *time.Time
+		want      time.Duration
+	}{
+		{"since scheduled", bson.NewObjectIdWithTime(now.Add(-time.Minute * 2)), nil, time.Minute * 2},
+		{"since send after", bson.NewObjectIdWithTime(now.Add(-time.Minute * 2)), &past, time.Minute},
+		{"delayed", bson.NewObjectIdWithTime(now), &future, -time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &OutgoingMessage{SendAfter: tt.sendAfter}
+			m.ID = tt.id
+			if got := m.queueLag(now).Truncate(time.Second); got != tt.want {
+				t.Errorf("OutgoingMessage.queueLag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tgQueueLag_observe(t *testing.T) {
+	q := &tgQueueLag{}
+
+	for i := 0; i < 50; i++ {
+		q.observe(time.Minute)
+	}
+	if got := q.get(); got < time.Second*59 {
+		t.Errorf("tgQueueLag after the constant lag = %v, want ~1m", got)
+	}
+
+	q.observe(-time.Hour)
+	if got := q.get(); got < time.Second*47 || got > time.Second*49 {
+		t.Errorf("tgQueueLag after the negative lag = %v, want 48s", got)
+	}
+}
+
+func Test_spillWebhook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integram_spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, body := range []string{"first", "second"} {
+		r, _ := http.NewRequest("POST", "http://integram.org/trello/c123?x=1", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/json")
+		err = spillWebhook(dir, r)
+		if err != nil {
+			t.Fatalf("spillWebhook() error = %v", err)
+		}
+	}
+
+	names, err := spilledWebhookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("spilledWebhookFiles() returned %d files, want 2", len(names))
+	}
+
+	for i, want := range []string{"first", "second"} {
+		r, w, err := readSpilledWebhook(dir + string(os.PathSeparator) + names[i])
+		if err != nil {
+			t.Fatalf("readSpilledWebhook() error = %v", err)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != want {
+			t.Errorf("readSpilledWebhook() body = %s, want %s", body, want)
+		}
+		if r.Method != "POST" || r.URL.RequestURI() != "/trello/c123?x=1" || r.Host != "integram.org" || w.URL != "/trello/c123?x=1" {
+			t.Errorf("readSpilledWebhook() request = %s %s%s", r.Method, r.Host, r.URL.RequestURI())
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("readSpilledWebhook() header = %v", r.Header)
+		}
+		if r.Context().Value(replayedWebhookKey{}) == nil {
+			t.Error("readSpilledWebhook() request is not marked as replayed")
+		}
+	}
+}
+
+func Test_acquireWebhookSlot(t *testing.T) {
+	defer func() {
+		webhookSlots = nil
+		webhooksSpilled = 0
+	}()
+
+	webhookSlots = make(chan struct{}, 1)
+	r, _ := http.NewRequest("POST", "/trello/c123", nil)
+
+	release, ok := acquireWebhookSlot(r, false)
+	if !ok {
+		t.Fatal("acquireWebhookSlot() = false for the free slot")
+	}
+
+	if _, ok := acquireWebhookSlot(r, false); ok {
+		t.Error("acquireWebhookSlot() = true when no slots left")
+	}
+	release()
+
+	if _, ok := acquireWebhookSlot(r, true); ok {
+		t.Error("acquireWebhookSlot() = true when Telegram queue is backlogged")
+	}
+
+	webhooksSpilled = 1
+	if _, ok := acquireWebhookSlot(r, false); ok {
+		t.Error("acquireWebhookSlot() = true while there are spilled webhooks to replay first")
+	}
+
+	replayed := r.WithContext(context.WithValue(r.Context(), replayedWebhookKey{}, true))
+	release, ok = acquireWebhookSlot(replayed, true)
+	if !ok {
+		t.Error("acquireWebhookSlot() = false for the replayed webhook")
+	}
+	release()
+}
@@ -0,0 +1,229 @@
+package integram
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Scopes of the bot's commands, see BotCommand.Scopes
+const (
+	BotCommandScopeDefault      = "default" // all chats without more specific commands
+	BotCommandScopePrivateChats = "all_private_chats"
+	BotCommandScopeGroupChats   = "all_group_chats"
+	BotCommandScopeChatAdmins   = "all_chat_administrators"
+)
+
+const botCommandMaxDescriptionRunes = 256
+
+var botCommandRE = regexp.MustCompile("^[a-z0-9_]{1,32}$")
+
+// BotCommand is the service's command shown in Telegram's autocomplete. Service.Commands are registered with setMyCommands on startup
+type BotCommand struct {
+	Command      string            // without the leading slash, e.g. "subscribe"
+	Description  string            // shown for the users without the translation
+	Scopes       []string          // BotCommandScope* where the command is shown. Empty means all chats
+	Translations map[string]string // description per the user's two-letter language code, e.g. {"es": "Suscribirse"}
+}
+
+type tgBotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// botCommandsSet is the list of the commands for the scope and the language. Empty language is the default for all users
+type botCommandsSet struct {
+	Scope    string
+	Language string
+	Commands []tgBotCommand
+}
+
+// botCommandsSynced is the hash of the set registered in Telegram. Stored in the "bot_commands" collection to skip the redundant setMyCommands
+type botCommandsSynced struct {
+	ID       string    `bson:"_id"` // see botCommandsSet.id
+	BotID    int64     `bson:"b"`
+	Scope    string    `bson:"s"`
+	Language string    `bson:"l"`
+	Hash     string    `bson:"h"`
+	SyncedAt time.Time `bson:"d"`
+}
+
+// valid returns the error if Telegram won't accept the command
+func (bc BotCommand) valid() error {
+	if !botCommandRE.MatchString(bc.Command) {
+		return fmt.Errorf("command '%s' must be 1-32 lowercase letters, digits or underscores", bc.Command)
+	}
+
+	if n := len([]rune(bc.Description)); n == 0 || n > botCommandMaxDescriptionRunes {
+		return fmt.Errorf("description of '%s' must be 1-%d characters", bc.Command, botCommandMaxDescriptionRunes)
+	}
+	return nil
+}
+
+// inScope returns true if the command is shown in the scope. Commands without the scopes are also the part of the more specific scopes
+// because Telegram shows the most specific list only
+func (bc BotCommand) inScope(scope string) bool {
+	if len(bc.Scopes) == 0 {
+		return true
+	}
+	return scope != BotCommandScopeDefault && SliceContainsString(bc.Scopes, scope)
+}
+
+// botCommandSets returns the lists of the commands to register per scope and language
+func botCommandSets(commands []BotCommand) []botCommandsSet {
+	scopes := []string{}
+	languages := []string{""}
+
+	for _, bc := range commands {
+		if len(bc.Scopes) == 0 && !SliceContainsString(scopes, BotCommandScopeDefault) {
+			scopes = append(scopes, BotCommandScopeDefault)
+		}
+
+		for _, scope := range bc.Scopes {
+			if !SliceContainsString(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		for lang := range bc.Translations {
+			if !SliceContainsString(languages, lang) {
+				languages = append(languages, lang)
+			}
+		}
+	}
+
+	sort.Strings(scopes)
+	sort.Strings(languages)
+
+	var sets []botCommandsSet
+	for _, scope := range scopes {
+		for _, lang := range languages {
+			set := botCommandsSet{Scope: scope, Language: lang}
+			translated := false
+
+			for _, bc := range commands {
+				if !bc.inScope(scope) {
+					continue
+				}
+
+				description := bc.Description
+				if d, exists := bc.Translations[lang]; exists && lang != "" {
+					description = d
+					translated = true
+				}
+				set.Commands = append(set.Commands, tgBotCommand{Command: bc.Command, Description: description})
+			}
+
+			// users of the language without translations see the default list
+			if len(set.Commands) > 0 && (lang == "" || translated) {
+				sets = append(sets, set)
+			}
+		}
+	}
+
+	return sets
+}
+
+func (set botCommandsSet) id(botID int64) string {
+	return fmt.Sprintf("%d:%s:%s", botID, set.Scope, set.Language)
+}
+
+func (set botCommandsSet) hash() string {
+	data, _ := json.Marshal(set.Commands)
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+// botCommandsRequest returns the params of setMyCommands or deleteMyCommands
+func botCommandsRequest(scope string, language string) url.Values {
+	v := url.Values{}
+	v.Set("scope", fmt.Sprintf(`{"type":"%s"}`, scope))
+	if language != "" {
+		v.Set("language_code", language)
+	}
+	return v
+}
+
+// syncCommands registers the commands of the bot's services with setMyCommands. Lists that are the same as the last registered are skipped
+func (bot *Bot) syncCommands() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("syncCommands panic recovered %v", r)
+		}
+	}()
+
+	var commands []BotCommand
+	for _, s := range bot.services {
+		for _, bc := range s.Commands {
+			if err := bc.valid(); err != nil {
+				log.WithError(err).WithField("service", s.Name).Error("Service.Commands: invalid command skipped")
+				continue
+			}
+			commands = append(commands, bc)
+		}
+	}
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	var synced []botCommandsSynced
+	err := db.C("bot_commands").Find(bson.M{"b": bot.ID}).All(&synced)
+	if err != nil {
+		log.WithError(err).WithField("bot", bot.ID).Error("syncCommands: can't get the registered commands")
+		return
+	}
+
+	outdated := make(map[string]botCommandsSynced)
+	for _, s := range synced {
+		outdated[s.ID] = s
+	}
+
+	for _, set := range botCommandSets(commands) {
+		id := set.id(bot.ID)
+		hash := set.hash()
+
+		prev, exists := outdated[id]
+		delete(outdated, id)
+		if exists && prev.Hash == hash {
+			continue
+		}
+
+		data, _ := json.Marshal(set.Commands)
+		v := botCommandsRequest(set.Scope, set.Language)
+		v.Set("commands", string(data))
+
+		_, err := bot.API.MakeRequest("setMyCommands", v)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"bot": bot.ID, "scope": set.Scope, "lang": set.Language}).Error("setMyCommands failed")
+			continue
+		}
+
+		_, err = db.C("bot_commands").UpsertId(id, botCommandsSynced{ID: id, BotID: bot.ID, Scope: set.Scope, Language: set.Language, Hash: hash, SyncedAt: time.Now()})
+		if err != nil {
+			log.WithError(err).WithField("bot", bot.ID).Error("syncCommands: can't save the registered commands")
+		}
+	}
+
+	// lists registered before but not needed anymore, e.g. the translation was removed
+	for id, s := range outdated {
+		_, err := bot.API.MakeRequest("deleteMyCommands", botCommandsRequest(s.Scope, s.Language))
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"bot": bot.ID, "scope": s.Scope, "lang": s.Language}).Error("deleteMyCommands failed")
+			continue
+		}
+
+		err = db.C("bot_commands").RemoveId(id)
+		if err != nil && err != mgo.ErrNotFound {
+			log.WithError(err).WithField("bot", bot.ID).Error("syncCommands: can't remove the registered commands")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBotCommand_valid(t *testing.T) {
+	tests := []struct {
+		name    string
+		command BotCommand
+		wantErr bool
+	}{
+		{"valid", BotCommand{Command: "subscribe", Description: "Subscribe to the repo"}, false},
+		{"slash", BotCommand{Command: "/subscribe", Description: "Subscribe to the repo"}, true},
+		{"uppercase", BotCommand{Command: "Subscribe", Description: "Subscribe to the repo"}, true},
+		{"no description", BotCommand{Command: "subscribe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.command.valid(); (err != nil) != tt.wantErr {
+				t.Errorf("BotCommand.valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_botCommandSets(t *testing.T) {
+	commands := []BotCommand{
+		{Command: "help", Description: "Help", Translations: map[string]string{"es": "Ayuda"}},
+		{Command: "settings", Description: "Settings", Scopes: []string{BotCommandScopePrivateChats}},
+	}
+
+	want := []botCommandsSet{
+		{Scope: BotCommandScopePrivateChats, Language: "", Commands: []tgBotCommand{{"help", "Help"}, {"settings", "Settings"}}},
+		{Scope: BotCommandScopePrivateChats, Language: "es", Commands: []tgBotCommand{{"help", "Ayuda"}, {"settings", "Settings"}}},
+		{Scope: BotCommandScopeDefault, Language: "", Commands: []tgBotCommand{{"help", "Help"}}},
+		{Scope: BotCommandScopeDefault, Language: "es", Commands: []tgBotCommand{{"help", "Ayuda"}}},
+	}
+
+	if got := botCommandSets(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("botCommandSets() = %+v, want %+v", got, want)
+	}
+
+	if got := botCommandSets(nil); len(got) != 0 {
+		t.Errorf("botCommandSets(nil) = %+v, want empty", got)
+	}
+}
+
+func Test_botCommandsSet_hash(t *testing.T) {
+	a := botCommandsSet{Commands: []tgBotCommand{{"help", "Help"}}}
+	b := botCommandsSet{Commands: []tgBotCommand{{"help", "Show help"}}}
+
+	if a.hash() == b.hash() {
+		t.Errorf("botCommandsSet.hash() is the same for the different descriptions")
+	}
+
+	if a.hash() != (botCommandsSet{Commands: []tgBotCommand{{"help", "Help"}}}).hash() {
+		t.Errorf("botCommandsSet.hash() is not stable")
+	}
+}
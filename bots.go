@@ -78,6 +78,8 @@ type Message struct {
 	OnReplyData      []byte           `bson:",omitempty"` // Args to send to this func
 	OnEditAction     string           `bson:",omitempty"` // Func to call on message edit
 	OnEditData       []byte           `bson:",omitempty"` // Args to send to this func
+	OnViewerAction   string           `bson:",omitempty"` // Func to render the viewer's keyboard in the private chat
+	OnViewerData     []byte           `bson:",omitempty"` // Args to send to this func
 	om               *OutgoingMessage // Cache when retreiving original replied message
 }
 
@@ -100,6 +102,7 @@ type IncomingMessage struct {
 	Sticker               *tg.Sticker         `json:"sticker"`                 // optional
 	Video                 *tg.Video           `json:"video"`                   // optional
 	Voice                 *tg.Voice           `json:"voice"`                   // optional
+	VideoNote             *tg.VideoNote       `json:"video_note"`              // optional
 	Caption               string              `json:"caption"`                 // optional
 	Contact               *tg.Contact         `json:"contact"`                 // optional
 	Location              *tg.Location        `json:"location"`                // optional
@@ -115,6 +118,7 @@ type IncomingMessage struct {
 	MigrateToChatID       int64               `json:"migrate_to_chat_id"`      // optional
 	MigrateFromChatID     int64               `json:"migrate_from_chat_id"`    // optional
 	PinnedMessage         *Message            `json:"pinned_message"`          // optional
+	Quote                 *MessageQuote       `json:"quote"`                   // optional. Part of ReplyToMessage quoted by the user
 
 	// Need to update message in DB. Used f.e. when you set the eventID for outgoing message
 	needToUpdateDB bool
@@ -135,12 +139,33 @@ type OutgoingMessage struct {
 	WebPreview           bool           `bson:",omitempty"`
 	Silent               bool           `bson:",omitempty"`
 	FilePath             string         `bson:",omitempty"`
+	FileID               string         `bson:",omitempty"` // Telegram's file_id of already uploaded file. Used instead of FilePath
+	FileURL              string         `bson:"-"`          // downloaded to FilePath right before sending, see SetAttachment
 	FileName             string         `bson:",omitempty"`
 	FileType             string         `bson:",omitempty"`
+	FileDuration         int            `bson:",omitempty"` // seconds, sent with the voice and the video note
 	FileRemoveAfter      bool           `bson:",omitempty"`
+	MediaGroupID         string         `bson:",omitempty"` // set for the album's messages sent with MediaGroup
 	SendAfter            *time.Time     `bson:",omitempty"`
+	LowPriority          bool           `bson:",omitempty"` // may be shed when Telegram queue is backlogged, e.g. digests
+	KeepRepeated         bool           `bson:"-"`          // don't collapse the notification into the previous identical one
+	Repeat               *messageRepeat `bson:",omitempty"` // streak of the identical notifications collapsed into this message
+	Pin                  *messagePin    `bson:",omitempty"` // set when the message is pinned with Context.PinMessage
+	ReplyQuote           string         `bson:",omitempty"` // part of the replied message to quote, see SetReplyQuote
+	ReplyQuotePosition   int            `bson:",omitempty"`
+	Poll                 *OutgoingPoll  `bson:",omitempty"` // set with SetPoll or SetQuiz
+	Critical             bool           `bson:",omitempty"` // also delivered to the user's notification channels when Telegram fails repeatedly, see SetCritical
+	CompactText          string         `bson:"-"`          // one-line summary sent to the compact chats, see SetCompactText
+	AutoSplit            bool           `bson:"-"`          // split the long text into several messages, see SetAutoSplit
+	SplitParts           []string       `bson:"-"`          // rest of the split text sent after this message
 	processed            bool
+	sync                 bool              // sent directly instead of the jobs queue
+	safeText             *safeText         // set with SetSafeTextFmt
+	uploadProgress       func(percent int) // reports the upload of the local document, see SendLargeDocument
+	attachmentErr        error             // set when SetAttachment failed, returned by Send
 	ctx                  *Context
+
+	Provenance *MessageProvenance `bson:",omitempty"` // webhook the message was sent on, see WebhookEvent
 }
 
 // Keyboard is a Shorthand for [][]Button
@@ -221,15 +246,31 @@ func (c *Bot) webhookURL() *url.URL {
 }
 
 func (service *Service) registerBot(fullTokenWithID string) error {
+	bot, err := service.addBot(fullTokenWithID)
+	if err != nil {
+		return err
+	}
+
+	botPerService[service.Name] = bot
+
+	err = service.registerOverflowBot(bot)
+	if err != nil {
+		log.WithError(err).WithField("service", service.Name).Error("Can't register the overflow bot")
+	}
+	return nil
+}
+
+// addBot creates the bot with the token or adds the service to the existing one
+func (service *Service) addBot(fullTokenWithID string) (*Bot, error) {
 
 	s := botTokenRE.FindStringSubmatch(fullTokenWithID)
 
 	if len(s) < 3 {
-		return errors.New("can't parse token")
+		return nil, errors.New("can't parse token")
 	}
 	id, err := strconv.ParseInt(s[1], 10, 64)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if b, exists := botPerID[id]; !exists || b.token != s[2] {
@@ -238,10 +279,10 @@ func (service *Service) registerBot(fullTokenWithID string) error {
 		botPerID[id] = &bot
 
 		token := bot.tgToken()
-		bot.API, err = tg.NewBotAPI(token)
+		bot.API, err = tg.NewBotAPIWithClient(token, newTGHTTPClient())
 		if err != nil {
 			log.WithError(err).WithField("token", token).Error("NewBotAPI returned error")
-			return err
+			return nil, err
 		}
 
 		bot.Username = bot.API.Self.UserName
@@ -261,8 +302,7 @@ func (service *Service) registerBot(fullTokenWithID string) error {
 		}
 		botPerID[id] = b
 	}
-	botPerService[service.Name] = botPerID[id]
-	return nil
+	return botPerID[id], nil
 }
 
 // Compare if InlineKeyboard.tg() of 2 keyboards are equal
@@ -691,6 +731,50 @@ func (m *OutgoingMessage) SetImage(localPath string, fileName string) *OutgoingM
 	return m
 }
 
+// fillFileBaseChat sets the reply, inline keyboard and notification options of the message with the file
+func (m *OutgoingMessage) fillFileBaseChat(base *tg.BaseChat) {
+	if m.ReplyToMsgID != 0 {
+		base.ReplyToMessageID = m.ReplyToMsgID
+	}
+
+	if len(m.InlineKeyboardMarkup.Buttons) > 0 {
+		base.ReplyMarkup = tg.InlineKeyboardMarkup{InlineKeyboard: m.InlineKeyboardMarkup.tg()}
+	}
+
+	base.DisableNotification = m.Silent
+}
+
+// uploadedFileID returns Telegram's file_id of the photo, document, video, sticker, voice or video note in the sent message. Animation is also returned as the document
+func uploadedFileID(msg *tg.Message) string {
+	if msg.Photo != nil && len(*msg.Photo) > 0 {
+		// the largest size is the last one
+		photos := *msg.Photo
+		return photos[len(photos)-1].FileID
+	}
+
+	if msg.Document != nil {
+		return msg.Document.FileID
+	}
+
+	if msg.Video != nil {
+		return msg.Video.FileID
+	}
+
+	if msg.Sticker != nil {
+		return msg.Sticker.FileID
+	}
+
+	if msg.Voice != nil {
+		return msg.Voice.FileID
+	}
+
+	if msg.VideoNote != nil {
+		return msg.VideoNote.FileID
+	}
+
+	return ""
+}
+
 // EnableFileRemoveAfter adds the flag to remove the file after message will be sent
 func (m *OutgoingMessage) EnableFileRemoveAfter() *OutgoingMessage {
 	m.FileRemoveAfter = true
@@ -905,7 +989,10 @@ func (t scheduleMessageSender) Send(m *OutgoingMessage) error {
 		return nil
 	}
 
-	if m.AntiFlood {
+	// the DB-backed features are skipped while the DB is unavailable
+	dbOK := !isDBDegraded()
+
+	if m.AntiFlood && dbOK {
 		db := mongoSession.Clone().DB(mongo.Database)
 		defer db.Session.Close()
 		msg, _ := findLastOutgoingMessageInChat(db, m.BotID, m.ChatID)
@@ -915,36 +1002,80 @@ func (t scheduleMessageSender) Send(m *OutgoingMessage) error {
 		}
 	}
 
-	if m.Selective && m.ChatID > 0 {
-		m.Selective = false
+	if dbOK && m.ChatID > 0 && m.SendAfter == nil && m.isNotification() {
+		db := mongoSession.Clone().DB(mongo.Database)
+		m.holdOutsideWorkingHours(db)
+		db.Session.Close()
 	}
-	m.ID = bson.NewObjectId()
 
-	if m.Selective && len(m.findUsernames()) == 0 && m.ReplyToMsgID == 0 {
-		err := errors.New("Inconsistence. Selective is true but there are no @mention or ReplyToMsgID specified")
-		log.WithField("chat", m.ChatID).Error(err)
+	if m.LowPriority && dbOK {
+		db := mongoSession.Clone().DB(mongo.Database)
+		lag := currentTGQueueLag(db)
+		if m.shouldShed(lag) {
+			m.shed(db, lag)
+			db.Session.Close()
+			m.processed = true
+			return nil
+		}
+		db.Session.Close()
+	}
+
+	var dn *messageDensity
+	if dbOK && m.ChatID != 0 && m.CompactText != "" {
+		db := mongoSession.Clone().DB(mongo.Database)
+		dn = m.applyDensity(db)
+		db.Session.Close()
+	}
+
+	var tr *messageTranslation
+	if dbOK && m.ChatID != 0 && m.isNotification() {
+		db := mongoSession.Clone().DB(mongo.Database)
+		tr = m.translate(db)
+		db.Session.Close()
+	}
+
+	err := m.prepare()
+	if err != nil {
 		return err
 	}
 
-	if m.ParseMode == "HTML" {
-		text := ""
-		var err error
-		if m.FilePath == "" {
-			text, err = sanitize.HTMLAllowing(m.Text, []string{"a", "b", "strong", "i", "em", "a", "code", "pre"}, []string{"href"})
-		} else {
-			// formatiing is not supported for file captions
-			text = sanitize.HTML(m.Text)
+	if dbOK && m.ChatID != 0 && Config.RepeatedNotificationsPeriod > 0 {
+		db := mongoSession.Clone().DB(mongo.Database)
+		collapsed := m.collapseRepeated(db)
+		db.Session.Close()
+		if collapsed {
+			m.processed = true
+			return nil
+		}
+	}
+
+	if tr != nil {
+		tr.ID = m.ID
+		db := mongoSession.Clone().DB(mongo.Database)
+		err = db.C("messages_translations").Insert(tr)
+		db.Session.Close()
+		if err != nil {
+			log.WithField("chat", m.ChatID).WithError(err).Error("Can't save the message translation")
 		}
+	}
 
-		if err == nil && text != "" {
-			m.Text = text
+	if dn != nil {
+		// details are stored as they would be sent: translated and sanitized the same way as the compact text
+		dn.ID = m.ID
+		dn.Compact = m.Text
+		if tr != nil {
+			dn.Detailed = translateText(dn.Detailed, m.ParseMode, tr.Lang)
 		}
-	} else {
-		text := sanitize.HTML(m.Text)
-		if text != "" {
-			m.Text = text
+		dn.Detailed = sanitizeText(dn.Detailed, m.ParseMode)
+
+		db := mongoSession.Clone().DB(mongo.Database)
+		err = db.C("messages_density").Insert(dn)
+		db.Session.Close()
+		if err != nil {
+			log.WithField("chat", m.ChatID).WithError(err).Error("Can't save the message details")
 		}
 	}
+
 	var sendAfter time.Time
 	if m.SendAfter != nil {
 		sendAfter = *m.SendAfter
@@ -952,15 +1083,92 @@ func (t scheduleMessageSender) Send(m *OutgoingMessage) error {
 		sendAfter = time.Now()
 	}
 
-	_, err := sendMessageJob.Schedule(0, sendAfter, &m)
+	_, err = sendMessageJob.Schedule(0, sendAfter, &m)
 	if err != nil {
 		log.WithField("chat", m.ChatID).WithError(err).Error("Can't schedule sendMessageJob")
 	} else {
 		m.processed = true
+		m.publishSentEvent()
+	}
+	return err
+}
+
+// prepare sets the message ID and sanitizes the text before sending
+func (m *OutgoingMessage) prepare() error {
+	if m.Selective && m.ChatID > 0 {
+		m.Selective = false
+	}
+	m.ID = bson.NewObjectId()
+	m.addViewerActionsButton()
+
+	if m.Selective && len(m.findUsernames()) == 0 && m.ReplyToMsgID == 0 {
+		err := errors.New("Inconsistence. Selective is true but there are no @mention or ReplyToMsgID specified")
+		log.WithField("chat", m.ChatID).Error(err)
+		return err
+	}
+
+	m.Text = sanitizeText(m.Text, m.ParseMode)
+	return nil
+}
+
+// sanitizeText removes the HTML tags not supported by Telegram in the parse mode
+func sanitizeText(text string, parseMode string) string {
+	if parseMode == "HTML" {
+		cleared, err := sanitize.HTMLAllowing(text, telegramHTMLTags, []string{"href"})
+		if err == nil && cleared != "" {
+			return cleared
+		}
+	} else {
+		cleared := sanitize.HTML(text)
+		if cleared != "" {
+			return cleared
+		}
 	}
+	return text
+}
+
+// reschedule puts the message back to the queue. Messages sent synchronously (e.g. by Saga) are never rescheduled
+func (m *OutgoingMessage) reschedule(after time.Time) error {
+	if m.sync {
+		return errors.New("Can't reschedule synchronously sent message")
+	}
+
+	_, err := sendMessageJob.Schedule(0, after, &m)
 	return err
 }
 
+// sendNow sends the message directly, bypassing the jobs queue. Returns error if the message wasn't sent
+func (m *OutgoingMessage) sendNow() error {
+	if m.processed {
+		return nil
+	}
+
+	if m.attachmentErr != nil {
+		return m.attachmentErr
+	}
+
+	m.sync = true
+	m.applySafeText()
+	m.splitText()
+	err := m.prepare()
+	if err != nil {
+		return err
+	}
+
+	err = sendMessage(m)
+	if err != nil {
+		return err
+	}
+
+	if m.MsgID == 0 {
+		return errors.New("Message wasn't sent")
+	}
+
+	m.processed = true
+	m.publishSentEvent()
+	return nil
+}
+
 // Send put the message to the jobs queue
 func (m *OutgoingMessage) Send() error {
 	if m.ChatID == 0 {
@@ -971,8 +1179,15 @@ func (m *OutgoingMessage) Send() error {
 		return errors.New("BotID is empty")
 	}
 
-	if m.Text == "" && m.FilePath == "" && m.Location == nil {
-		return errors.New("Text, FilePath and Location are empty")
+	if m.attachmentErr != nil {
+		return m.attachmentErr
+	}
+
+	m.applySafeText()
+	m.splitText()
+
+	if m.Text == "" && m.FilePath == "" && m.FileID == "" && m.FileURL == "" && m.Location == nil && m.Poll == nil {
+		return errors.New("Text, FilePath, FileID, FileURL, Location and Poll are empty")
 	}
 
 	if m.ctx != nil && m.ctx.messageAnsweredAt == nil {
@@ -989,6 +1204,12 @@ func (m *OutgoingMessage) SetSendAfter(after time.Time) *OutgoingMessage {
 	return m
 }
 
+// SetLowPriority marks the message as the one that can be dropped when Telegram queue is backlogged, e.g. digest or summary
+func (m *OutgoingMessage) SetLowPriority(b bool) *OutgoingMessage {
+	m.LowPriority = b
+	return m
+}
+
 // AddEventID attach one or more event ID. You can use eventid to edit the message in case of additional webhook received or to ignore in case of duplicate
 func (m *OutgoingMessage) AddEventID(id ...string) *OutgoingMessage {
 	m.EventID = append(m.EventID, id...)
@@ -1004,6 +1225,7 @@ func (m *OutgoingMessage) EnableAntiFlood() *OutgoingMessage {
 
 // SetTextFmt is a shorthand for SetText(fmt.Sprintf("%s %s %s", a, b, c))
 func (m *OutgoingMessage) SetTextFmt(text string, a ...interface{}) *OutgoingMessage {
+	m.safeText = nil
 	m.Text = fmt.Sprintf(text, a...)
 	return m
 }
@@ -1011,6 +1233,7 @@ func (m *OutgoingMessage) SetTextFmt(text string, a ...interface{}) *OutgoingMes
 // SetText set the text of message to sent
 // In case of documents and photo messages this text will be used in the caption
 func (m *OutgoingMessage) SetText(text string) *OutgoingMessage {
+	m.safeText = nil
 	m.Text = text
 	return m
 }
@@ -1039,7 +1262,7 @@ func (m *OutgoingMessage) EnableHTML() *OutgoingMessage {
 	return m
 }
 
-// SetParseMode sets parseMode: 'HTML' and 'markdown' supporting for now
+// SetParseMode sets parseMode: "HTML", "Markdown" or "MarkdownV2"
 func (m *OutgoingMessage) SetParseMode(s string) *OutgoingMessage {
 	m.ParseMode = s
 	return m
@@ -1120,6 +1343,22 @@ func initBots() error {
 		log.WithError(err).Panic("RegisterTypeWithPoolKey ensureService failed")
 	}
 
+	workingHoursDigestJob, err = jobs.RegisterTypeWithPoolKey("workingHoursDigest", "_telegram", 3, sendWorkingHoursDigest)
+	if err != nil {
+		log.WithError(err).Panic("RegisterTypeWithPoolKey workingHoursDigest failed")
+	}
+
+	undoExpireJob, err = jobs.RegisterTypeWithPoolKey("undoExpire", "_telegram", 3, expireUndo)
+	if err != nil {
+		log.WithError(err).Panic("RegisterTypeWithPoolKey undoExpire failed")
+	}
+
+	// retries are queued by retryAntiFloodEdit after retry_after
+	antiFloodEditJob, err = jobs.RegisterTypeWithPoolKey("antiFloodEdit", "_telegram", 0, retryAntiFloodEdit)
+	if err != nil {
+		log.WithError(err).Panic("RegisterTypeWithPoolKey antiFloodEdit failed")
+	}
+
 	if Config.IsStandAloneServiceInstance() || Config.IsSingleProcessInstance() {
 		for _, service := range services {
 
@@ -1127,13 +1366,22 @@ func initBots() error {
 			if bot == nil {
 				continue
 			}
-			if !service.UseWebhookInsteadOfLongPolling {
-				bot.listen()
-			} else {
-				_, err := bot.API.SetWebhook(tg.WebhookConfig{URL: bot.webhookURL()})
-				if err != nil {
-					log.WithError(err).WithField("botID", bot.ID).Error("Error on initial SetWebhook")
+
+			bots := []*Bot{bot}
+			if overflowBot := overflowBotPerBot[bot.ID]; overflowBot != nil {
+				bots = append(bots, overflowBot)
+			}
+
+			for _, b := range bots {
+				if !service.UseWebhookInsteadOfLongPolling {
+					b.listen()
+				} else {
+					_, err := b.API.SetWebhook(tg.WebhookConfig{URL: b.webhookURL()})
+					if err != nil {
+						log.WithError(err).WithField("botID", b.ID).Error("Error on initial SetWebhook")
+					}
 				}
+				go b.syncCommands()
 			}
 			log.Infof("%v is performing on behalf of @%v", service.Name, bot.Username)
 		}
@@ -1150,7 +1398,7 @@ func initBots() error {
 	return nil
 }
 
-var sendMessageJob, ensureStandAloneServiceJob *jobs.Type
+var sendMessageJob, ensureStandAloneServiceJob, workingHoursDigestJob, undoExpireJob, antiFloodEditJob *jobs.Type
 
 func (m *Message) findUsernames() []string {
 	r, _ := regexp.Compile("@([a-zA-Z0-9_]{5,})") // according to TG docs minimum username length is 5
@@ -1163,23 +1411,24 @@ func (m *Message) findUsernames() []string {
 
 }
 
+// GetRemoteFilePath returns the download URL of the Telegram file. Only the file_path is cached, because the URL contains the bot's token
 func GetRemoteFilePath(c *Context, fileID string) (string, error) {
+	bot := c.Bot()
 
-	var fileRemotePath string
-	c.ServiceCache("file_remote_"+fileID, &fileRemotePath)
+	var filePath string
+	c.ServiceCache("file_path_"+fileID, &filePath)
 
-	if fileRemotePath != "" {
-		return fileRemotePath, nil
-	}
+	if filePath == "" {
+		f, err := bot.API.GetFile(tg.FileConfig{FileID: fileID})
+		if err != nil {
+			return "", err
+		}
 
-	url, err := c.Bot().API.GetFileDirectURL(fileID)
-	if err != nil {
-		return "", err
+		filePath = f.FilePath
+		c.SetServiceCache("file_path_"+fileID, filePath, time.Hour*1)
 	}
 
-	c.SetServiceCache("file_remote_"+fileID, fileRemotePath, time.Hour*1)
-
-	return url, nil
+	return tg.File{FilePath: filePath}.Link(bot.API.Token), nil
 }
 
 func GetLocalFilePath(c *Context, fileID string) (string, error) {
@@ -1202,6 +1451,11 @@ func GetLocalFilePath(c *Context, fileID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
+
+		err = c.scanFile(fileLocalPath, "", fileScanToUpstream)
+		if err != nil {
+			return "", err
+		}
 		c.SetServiceCache("file_"+fileID, fileLocalPath, time.Hour*24)
 	}
 
@@ -1213,12 +1467,14 @@ var GetFileMaxSizeExceedError = errors.New("Maximum allowed file size exceed")
 type FileType string
 
 const (
-	FileTypeDocument FileType = "document"
-	FileTypePhoto    FileType = "photo"
-	FileTypeAudio    FileType = "audio"
-	FileTypeSticker  FileType = "sticker"
-	FileTypeVideo    FileType = "video"
-	FileTypeVoice    FileType = "voice"
+	FileTypeDocument  FileType = "document"
+	FileTypePhoto     FileType = "photo"
+	FileTypeAudio     FileType = "audio"
+	FileTypeSticker   FileType = "sticker"
+	FileTypeVideo     FileType = "video"
+	FileTypeVoice     FileType = "voice"
+	FileTypeAnimation FileType = "animation"
+	FileTypeVideoNote FileType = "video_note"
 )
 
 func fileTypeAllowed(allowedTypes []FileType, fileType FileType) bool {
@@ -1235,11 +1491,12 @@ func fileTypeAllowed(allowedTypes []FileType, fileType FileType) bool {
 }
 
 type FileInfo struct {
-	ID   string
-	Name string
-	Type FileType
-	Mime string
-	Size int64
+	ID       string
+	Name     string
+	Type     FileType
+	Mime     string
+	Size     int64
+	Duration int // seconds, set for the audio, video, voice and video note
 }
 
 func (info *FileInfo) Emoji() string {
@@ -1248,7 +1505,7 @@ func (info *FileInfo) Emoji() string {
 		return "🖼"
 	case FileTypeAudio:
 		return "🎵"
-	case FileTypeVideo:
+	case FileTypeVideo, FileTypeVideoNote:
 		return "🎬"
 	case FileTypeVoice:
 		return "🗣"
@@ -1298,6 +1555,7 @@ func (m *IncomingMessage) GetFileInfo(c *Context, allowedTypes []FileType) (info
 		info.Name += filepath.Ext(remotePath)
 		info.ID = m.Audio.FileID
 		info.Size = int64(m.Audio.FileSize)
+		info.Duration = m.Audio.Duration
 
 		return
 	}
@@ -1337,6 +1595,7 @@ func (m *IncomingMessage) GetFileInfo(c *Context, allowedTypes []FileType) (info
 		info.Name += filepath.Ext(remotePath)
 		info.ID = m.Video.FileID
 		info.Size = int64(m.Video.FileSize)
+		info.Duration = m.Video.Duration
 
 		return
 	}
@@ -1363,6 +1622,31 @@ func (m *IncomingMessage) GetFileInfo(c *Context, allowedTypes []FileType) (info
 		info.Name += filepath.Ext(remotePath)
 		info.ID = m.Voice.FileID
 		info.Size = int64(m.Voice.FileSize)
+		info.Duration = m.Voice.Duration
+		info.Mime = m.Voice.MimeType
+
+		return
+	}
+
+	if m.VideoNote != nil && fileTypeAllowed(allowedTypes, FileTypeVideoNote) {
+		info.Type = FileTypeVideoNote
+
+		if c.User.UserName != "" {
+			info.Name += c.User.UserName
+		} else if c.User.FirstName != "" {
+			info.Name += filepath.Clean(c.User.FirstName)
+		}
+		info.Name += fmt.Sprintf("_%d", m.MsgID)
+
+		var remotePath string
+		remotePath, err = GetRemoteFilePath(c, m.VideoNote.FileID)
+		if err != nil {
+			return
+		}
+		info.Name += filepath.Ext(remotePath)
+		info.ID = m.VideoNote.FileID
+		info.Size = int64(m.VideoNote.FileSize)
+		info.Duration = m.VideoNote.Duration
 
 		return
 	}
@@ -1456,6 +1740,10 @@ func sendMessage(m *OutgoingMessage) error {
 	//log.Infof("sendMessage chat=%d ts=%d text=%s",m.ChatID, m.ID.Time().UnixNano(), m.Text)
 	msg := tg.MessageConfig{Text: m.Text, BaseChat: tg.BaseChat{ChatID: m.ChatID}}
 
+	if isDBDegraded() {
+		return sendMessageDegraded(m)
+	}
+
 	db := mongoSession.Clone().DB(mongo.Database)
 	defer db.Session.Close()
 	if blacklisted, _ := db.C("chats").Find(bson.M{"_id": m.ChatID, "blacklisted": true}).Count(); blacklisted > 0 {
@@ -1467,6 +1755,19 @@ func sendMessage(m *OutgoingMessage) error {
 		return errors.New("ChatID empty")
 	}
 
+	if lag := observeTGQueueLag(db, m); m.shouldShed(lag) {
+		m.shed(db, lag)
+		return nil
+	}
+
+	if botID := handoffBotID(db, m.BotID, m.ChatID); botID != m.BotID {
+		// the chat was handed off to the overflow bot after the message was created
+		if m.FromID == m.BotID {
+			m.FromID = botID
+		}
+		m.BotID = botID
+	}
+
 	bot := botByID(m.BotID)
 
 	if bot == nil {
@@ -1476,6 +1777,16 @@ func sendMessage(m *OutgoingMessage) error {
 	var tgMsg tg.Message
 	var rescheduled bool
 
+	downloaded := false
+	if m.FilePath == "" && m.FileURL != "" {
+		err = m.downloadFileURL(db, bot)
+		if err != nil {
+			return err
+		}
+		downloaded = m.FilePath != ""
+		msg.Text = m.Text
+	}
+
 	startedAt := time.Now()
 	if m.FilePath != "" {
 		if _, err := os.Stat(m.FilePath); os.IsNotExist(err) {
@@ -1483,31 +1794,12 @@ func sendMessage(m *OutgoingMessage) error {
 			return nil
 		}
 
-		if m.FileType == "image" {
-
-			msg := tg.NewPhotoUpload(m.ChatID, m.FilePath)
-			msg.FileName = m.FileName
-			msg.Caption = m.Text
-			if m.ReplyToMsgID != 0 {
-				msg.BaseChat.ReplyToMessageID = m.ReplyToMsgID
-			}
-			tgMsg, err = bot.API.Send(msg)
-
-		} else {
-			msg := tg.NewDocumentUpload(m.ChatID, m.FilePath)
-			msg.FileName = m.FileName
-			msg.Caption = m.Text
-			if m.ReplyToMsgID != 0 {
-				msg.BaseChat.ReplyToMessageID = m.ReplyToMsgID
-			}
-			tgMsg, err = bot.API.Send(msg)
-
-		}
+		tgMsg, err = m.sendLocalFile(db, bot)
 
 		if m.FileRemoveAfter {
 			defer func() {
-				// message not rescheduled
-				if err == nil && !rescheduled {
+				// message not rescheduled. Failed job is retried with the URL, so the downloaded file isn't needed anymore
+				if (err == nil || downloaded) && !rescheduled {
 					err2 := os.Remove(m.FilePath)
 					if err2 != nil {
 						log.WithError(err).WithField("path", m.FilePath).Error("Error removing message's file")
@@ -1516,8 +1808,13 @@ func sendMessage(m *OutgoingMessage) error {
 			}()
 		}
 
+	} else if m.FileID != "" {
+		tgMsg, err = m.sendFileShare(bot, m.FileID)
 	} else if m.Location != nil {
 		tgMsg, err = bot.API.Send(tg.LocationConfig{BaseChat: msg.BaseChat, Latitude: m.Location.Latitude, Longitude: m.Location.Longitude})
+	} else if m.Poll != nil {
+		msg.DisableNotification = m.Silent
+		tgMsg, err = m.sendPoll(db, bot, msg)
 	} else {
 
 		if m.KeyboardHide {
@@ -1548,7 +1845,11 @@ func sendMessage(m *OutgoingMessage) error {
 			msg.ParseMode = m.ParseMode
 		}
 
-		tgMsg, err = bot.API.Send(msg)
+		if m.ReplyToMsgID != 0 && m.ReplyQuote != "" {
+			tgMsg, err = m.sendQuotedMessage(bot, msg)
+		} else {
+			tgMsg, err = bot.API.Send(msg)
+		}
 	}
 
 	if err == nil {
@@ -1557,6 +1858,11 @@ func sendMessage(m *OutgoingMessage) error {
 		m.MsgID = tgMsg.MessageID
 		m.Date = time.Now()
 
+		if m.FilePath != "" {
+			// stored to send the same file again without uploading, see SetPhotoFileID
+			m.FileID = uploadedFileID(&tgMsg)
+		}
+
 		err = saveKeyboard(m, db)
 		if err != nil {
 			log.WithError(err).Error("Error processing keyboard")
@@ -1570,12 +1876,18 @@ func sendMessage(m *OutgoingMessage) error {
 			log.WithError(err).Error("Error outgoing inserting message in db")
 		}
 
+		m.resetTGFailures(db)
+		m.sendNextPart()
 		return nil
 	}
 
 	if tgErr, ok := err.(tg.Error); ok {
 		//  Todo: Bad workaround to catch network errors
-		if tgErr.Code == 0 {
+		if (tgErr.Code == 0 || tgErr.Code == 500) && m.shouldShed(tgQueue.get()) {
+			// don't spend the retries on the low priority message while the queue is backlogged
+			m.shed(db, tgQueue.get())
+			return nil
+		} else if tgErr.Code == 0 {
 			log.WithError(err).Warn("Network error while sending a message")
 			// pass through the error so the job will be rescheduled
 			return err
@@ -1589,7 +1901,7 @@ func sendMessage(m *OutgoingMessage) error {
 			// looks like the message we replying on is no longer exists...
 			m.ReplyToMsgID = 0
 			rescheduled = true
-			_, err := sendMessageJob.Schedule(0, time.Now(), &m)
+			err := m.reschedule(time.Now())
 			if err != nil {
 				log.WithField("chat", m.ChatID).WithError(err).Error("Can't reschedule sendMessageJob")
 			}
@@ -1622,6 +1934,7 @@ func sendMessage(m *OutgoingMessage) error {
 			db.C("chats").Update(bson.M{"_id": m.ChatID, key: bson.M{"$exists": false}}, bson.M{"$set": bson.M{key: time.Now()}})
 
 			log.WithField("chat", m.ChatID).WithField("bot", m.BotID).Warn("sendMessage error: Bot stopped by user")
+			m.deliverToNotificationChannels(db, bot, "bot stopped")
 			if m.BackupChatID != 0 {
 				if m.BackupChatID != m.ChatID {
 					// if this fall from private messages - add the mention and selective to grace notifications and protect the keyboard
@@ -1636,7 +1949,7 @@ func sendMessage(m *OutgoingMessage) error {
 					}
 					m.ChatID = m.BackupChatID
 					rescheduled = true
-					_, err := sendMessageJob.Schedule(0, time.Now(), &m)
+					err := m.reschedule(time.Now())
 					return err
 				}
 
@@ -1647,6 +1960,7 @@ func sendMessage(m *OutgoingMessage) error {
 		} else if tgErr.ChatNotFound() {
 			// usually this means that user not initialized the private chat with the bot
 			log.WithField("chat", m.ChatID).WithField("bot", m.BotID).Warn("sendMessage error: Chat not found")
+			m.deliverToNotificationChannels(db, bot, "chat not found")
 			if m.BackupChatID != 0 {
 				if m.BackupChatID != m.ChatID {
 					// if this fall from private messages - add the mention and selective to grace notifications and protect the keyboard
@@ -1661,7 +1975,7 @@ func sendMessage(m *OutgoingMessage) error {
 					}
 					rescheduled = true
 					m.ChatID = m.BackupChatID
-					_, err := sendMessageJob.Schedule(0, time.Now(), &m)
+					err := m.reschedule(time.Now())
 					return err
 				}
 
@@ -1705,11 +2019,18 @@ func sendMessage(m *OutgoingMessage) error {
 			}
 
 			rescheduled = true
-			_, err := sendMessageJob.Schedule(0, time.Now().Add(time.Duration(delay+rand.Intn(10))*time.Second), &m)
+			err := m.reschedule(time.Now().Add(time.Duration(delay+rand.Intn(10)) * time.Second))
 			return err
 		} else if tgErr.IsParseError() {
+			offset := tgErr.ParseErrorOffset()
+			if offset == -1 && m.ParseMode == ParseModeMarkdownV2 {
+				// unescaped reserved chars are reported without the offset
+				if _, _, err := parseMarkdownV2Entities(m.Text); err != nil {
+					offset = err.(markdownV2ParseError).Offset
+				}
+			}
 
-			if offset := tgErr.ParseErrorOffset(); offset > -1 {
+			if offset > -1 && offset < len(m.Text) {
 
 				var escapedSymbol = ""
 				if m.ParseMode == "Markdown" {
@@ -1717,6 +2038,10 @@ func sendMessage(m *OutgoingMessage) error {
 
 					mrk := MarkdownRichText{}
 					escapedSymbol = mrk.Esc(m.Text[offset:offset+1])
+				} else if m.ParseMode == ParseModeMarkdownV2 {
+					log.WithError(tgErr.Err).WithField("chat", m.ChatID).WithField("bot", m.BotID).Error("Bad MarkdownV2 in the text")
+
+					escapedSymbol = EscapeMarkdownV2(m.Text[offset : offset+1])
 				} else {
 					log.WithError(tgErr.Err).WithField("chat", m.ChatID).WithField("bot", m.BotID).Error("Bad HTML in the text")
 
@@ -1727,7 +2052,7 @@ func sendMessage(m *OutgoingMessage) error {
 				m.SetText(m.Text[0:offset] + escapedSymbol + m.Text[offset+1:])
 
 				rescheduled = true
-				_, err := sendMessageJob.Schedule(0, time.Now(), &m)
+				err := m.reschedule(time.Now())
 				return err
 			}
 		}
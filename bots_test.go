@@ -2428,3 +2428,43 @@ func TestMessage_Update(t *testing.T) {
 		}
 	}
 }
+
+func Test_uploadedFileID(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  tg.Message
+		want string
+	}{
+		{"photo", tg.Message{Photo: &[]tg.PhotoSize{{FileID: "small", Width: 90}, {FileID: "large", Width: 1280}}}, "large"},
+		{"document", tg.Message{Document: &tg.Document{FileID: "doc"}}, "doc"},
+		{"sticker", tg.Message{Sticker: &tg.Sticker{FileID: "sticker"}}, "sticker"},
+		{"voice", tg.Message{Voice: &tg.Voice{FileID: "voice", Duration: 3}}, "voice"},
+		{"video note", tg.Message{VideoNote: &tg.VideoNote{FileID: "note", Length: 240}}, "note"},
+		{"text", tg.Message{Text: "hi"}, ""},
+	}
+	for _, tt := range tests {
+		if got := uploadedFileID(&tt.msg); got != tt.want {
+			t.Errorf("%q. uploadedFileID() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutgoingMessage_SetPhotoFileID(t *testing.T) {
+	m := &OutgoingMessage{ParseMode: "HTML"}
+	m.Text = `<b>Pipeline</b> <div>failed</div>`
+	m.SetPhotoFileID("AgADBAAD")
+
+	if m.FileID != "AgADBAAD" || m.FileType != "image" || m.FilePath != "" {
+		t.Errorf("SetPhotoFileID() = %+v", m)
+	}
+
+	err := m.prepare()
+	if err != nil {
+		t.Fatalf("prepare() error = %v", err)
+	}
+
+	// captions keep the formatting
+	if m.Text != "<b>Pipeline</b> failed" {
+		t.Errorf("prepare() caption = %v, want <b>Pipeline</b> failed", m.Text)
+	}
+}
@@ -0,0 +1,121 @@
+package integram
+
+import (
+	"strings"
+)
+
+// Error tones of the core messages
+const (
+	BrandingErrorToneNeutral  = "neutral"
+	BrandingErrorToneFriendly = "friendly"
+)
+
+// Branding is the instance-wide bot personality configured with INTEGRAM_BRANDING_* env vars. It is applied to the core messages and available to services via Context.Branding()
+type Branding struct {
+	Greeting      string // sent when the bot is added to the group. {bot} and {service} are replaced with the bot's username and the service's name
+	Emoji         bool   // prefix core messages with the emoji
+	Footer        string // plain text appended to the core messages
+	ParseMode     string // parse mode of the Greeting: "", "HTML", "Markdown" or "MarkdownV2". Services opt in with SetParseMode(c.Branding().ParseMode), other messages are sent as the plain text by default
+	ErrorTone     string // BrandingErrorToneNeutral or BrandingErrorToneFriendly
+	CommandPrefix string // prefix of the core commands, e.g. "acme_" turns /webhook into /acme_webhook
+}
+
+// instanceBranding returns the branding from the Config
+func instanceBranding() Branding {
+	b := Branding{
+		Greeting:      Config.BrandingGreeting,
+		Emoji:         Config.BrandingEmoji,
+		Footer:        Config.BrandingFooter,
+		ErrorTone:     strings.ToLower(Config.BrandingErrorTone),
+		CommandPrefix: Config.BrandingCommandPrefix,
+	}
+
+	switch strings.ToLower(Config.BrandingParseMode) {
+	case "html":
+		b.ParseMode = "HTML"
+	case "markdown":
+		b.ParseMode = "Markdown"
+	case "markdownv2":
+		b.ParseMode = ParseModeMarkdownV2
+	}
+
+	return b
+}
+
+// Branding returns the instance's bot personality settings
+func (c *Context) Branding() Branding {
+	return instanceBranding()
+}
+
+// EmojiPrefix returns the emoji followed by space or empty string if emoji are disabled
+func (b Branding) EmojiPrefix(emoji string) string {
+	if !b.Emoji || emoji == "" {
+		return ""
+	}
+	return emoji + " "
+}
+
+// ErrorText returns the error text in the configured tone
+func (b Branding) ErrorText(text string) string {
+	if b.ErrorTone == BrandingErrorToneFriendly {
+		return "Sorry! " + text
+	}
+	return text
+}
+
+// Command returns the core command's name with the configured prefix
+func (b Branding) Command(name string) string {
+	return b.CommandPrefix + name
+}
+
+// GreetingText returns the greeting with placeholders replaced or empty string if it isn't configured
+func (b Branding) GreetingText(botUsername string, serviceName string) string {
+	return b.greetingText(botUsername, serviceName, "")
+}
+
+// greetingText returns the greeting with placeholders replaced by the values escaped for the parse mode
+func (b Branding) greetingText(botUsername string, serviceName string, parseMode string) string {
+	if b.Greeting == "" {
+		return ""
+	}
+	return strings.NewReplacer("{bot}", escapeForParseMode(parseMode, "@"+botUsername), "{service}", escapeForParseMode(parseMode, serviceName)).Replace(b.Greeting)
+}
+
+// coreCommand returns the name of the core command with the instance's prefix
+func coreCommand(name string) string {
+	return instanceBranding().Command(name)
+}
+
+// applyBrandingFooter appends the footer to the core message's text according to its parse mode
+func (m *OutgoingMessage) applyBrandingFooter() *OutgoingMessage {
+	footer := instanceBranding().Footer
+	if footer == "" {
+		return m
+	}
+
+	switch m.ParseMode {
+	case "HTML":
+		footer = HTMLRichText{}.EncodeEntities(footer)
+	case "Markdown":
+		footer = MarkdownRichText{}.Esc(footer)
+	case ParseModeMarkdownV2:
+		footer = EscapeMarkdownV2(footer)
+	}
+
+	m.Text += "\n\n" + footer
+	return m
+}
+
+// sendBrandingGreeting sends the instance's greeting when the bot is added to the group
+func (c *Context) sendBrandingGreeting() {
+	b := c.Branding()
+	text := b.greetingText(c.Bot().Username, c.Service().NameToPrint, b.ParseMode)
+	if text == "" {
+		return
+	}
+
+	err := c.NewMessage().SetText(text).SetParseMode(b.ParseMode).applyBrandingFooter().Send()
+	if err != nil {
+		c.Log().WithError(err).Error("Can't send the greeting")
+	}
+}
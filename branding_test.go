@@ -0,0 +1,61 @@
+package integram
+
+import "testing"
+
+func TestBranding_EmojiPrefix(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     Branding
+		emoji string
+		want  string
+	}{
+		{"enabled", Branding{Emoji: true}, "⚠️", "⚠️ "},
+		{"disabled", Branding{Emoji: false}, "⚠️", ""},
+		{"no emoji", Branding{Emoji: true}, "", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.b.EmojiPrefix(tt.emoji); got != tt.want {
+			t.Errorf("%q. Branding.EmojiPrefix() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBranding_GreetingText(t *testing.T) {
+	b := Branding{Greeting: "Hi! I'm {bot}, I will post {service} notifications here"}
+	want := "Hi! I'm @trello_bot, I will post Trello notifications here"
+	if got := b.GreetingText("trello_bot", "Trello"); got != want {
+		t.Errorf("Branding.GreetingText() = %q, want %q", got, want)
+	}
+
+	if got := (Branding{}).GreetingText("trello_bot", "Trello"); got != "" {
+		t.Errorf("Branding.GreetingText() without greeting = %q, want empty", got)
+	}
+
+	b = Branding{Greeting: "<b>Hi!</b> I'm {bot}, I will post {service} notifications here", ParseMode: "HTML"}
+	want = "<b>Hi!</b> I'm @trello_bot, I will post R&amp;D notifications here"
+	if got := b.greetingText("trello_bot", "R&D", b.ParseMode); got != want {
+		t.Errorf("Branding.greetingText() = %q, want %q", got, want)
+	}
+}
+
+func TestOutgoingMessage_applyBrandingFooter(t *testing.T) {
+	defer func(footer string) { Config.BrandingFooter = footer }(Config.BrandingFooter)
+	Config.BrandingFooter = "Powered by <Acme>"
+
+	tests := []struct {
+		name      string
+		parseMode string
+		want      string
+	}{
+		{"plain", "", "text\n\nPowered by <Acme>"},
+		{"HTML", "HTML", "text\n\nPowered by &lt;Acme&gt;"},
+	}
+	for _, tt := range tests {
+		m := &OutgoingMessage{}
+		m.Text = "text"
+		m.ParseMode = tt.parseMode
+		if got := m.applyBrandingFooter().Text; got != tt.want {
+			t.Errorf("%q. applyBrandingFooter() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
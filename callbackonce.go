@@ -0,0 +1,78 @@
+package integram
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the callback executions are stored. Telegram stops the redeliveries much earlier
+const callbackExecutionTTL = time.Hour * 24
+
+// answer to the redelivered callback and to the callback which handler is timed out
+const callbackInProgressText = "Your action is still processing"
+
+// callbackExecution is stored in the "callbacks_executions" collection before the callback action is called
+// to make sure the action with side effects, e.g. merge MR, runs once when Telegram redelivers the update
+type callbackExecution struct {
+	ID        string    `bson:"_id"` // idempotency key, see callbackIdempotencyKey
+	Service   string    `bson:"s"`
+	Handler   string    `bson:"h"`
+	Done      bool      `bson:"done"`
+	Error     string    `bson:"e,omitempty"`
+	Answer    string    `bson:"a,omitempty"` // callback answer to send again for the redelivered callback
+	ShowAlert bool      `bson:"al,omitempty"`
+	Date      time.Time `bson:"d"`
+}
+
+// callbackIdempotencyKey returns the key that is the same for all the deliveries of the button press
+func callbackIdempotencyKey(callbackID, handler string) string {
+	h := sha1.Sum([]byte(callbackID + ":" + handler))
+	return hex.EncodeToString(h[:])
+}
+
+// beginCallbackExecution records the execution before the handler is called. Returns the previous execution if the callback was already processed or is in progress
+func (c *Context) beginCallbackExecution(handler string) (*callbackExecution, error) {
+	e := callbackExecution{ID: callbackIdempotencyKey(c.Callback.ID, handler), Service: c.ServiceName, Handler: handler, Date: time.Now()}
+
+	err := c.db.C("callbacks_executions").Insert(e)
+	if err == nil {
+		return nil, nil
+	} else if !mgo.IsDup(err) {
+		return nil, err
+	}
+
+	prev := callbackExecution{}
+	err = c.db.C("callbacks_executions").FindId(e.ID).One(&prev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prev, nil
+}
+
+// finishCallbackExecution caches the outcome of the handler to answer the redeliveries
+func (c *Context) finishCallbackExecution(handler string, handlerErr error) error {
+	set := bson.M{"done": true, "a": c.Callback.answerText, "al": c.Callback.answerAlert}
+	if handlerErr != nil {
+		set["e"] = handlerErr.Error()
+	}
+
+	return c.db.C("callbacks_executions").UpdateId(callbackIdempotencyKey(c.Callback.ID, handler), bson.M{"$set": set})
+}
+
+// answerRedeliveredCallback answers the redelivered callback with the cached outcome instead of calling the handler again
+func (c *Context) answerRedeliveredCallback(e *callbackExecution) {
+	c.Log().WithField("handler", e.Handler).WithField("done", e.Done).Warn("callback redelivered, the handler is not called again")
+	c.StatInc(StatCallbackRedelivered)
+
+	if !e.Done {
+		c.AnswerCallbackQuery(callbackInProgressText, false)
+		return
+	}
+
+	c.AnswerCallbackQuery(e.Answer, e.ShowAlert)
+}
@@ -0,0 +1,48 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_callbackIdempotencyKey(t *testing.T) {
+	key := callbackIdempotencyKey("123", "trello.cardButton")
+
+	if key != callbackIdempotencyKey("123", "trello.cardButton") {
+		t.Error("callbackIdempotencyKey() differs for the same callback")
+	}
+
+	if key == callbackIdempotencyKey("124", "trello.cardButton") || key == callbackIdempotencyKey("123", "trello.otherButton") {
+		t.Error("callbackIdempotencyKey() is the same for the different callbacks")
+	}
+}
+
+func TestContext_beginCallbackExecution(t *testing.T) {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken", Callback: &callback{ID: "cb_exactly_once"}}
+	db.C("callbacks_executions").RemoveId(callbackIdempotencyKey(ctx.Callback.ID, "merge"))
+
+	prev, err := ctx.beginCallbackExecution("merge")
+	if err != nil || prev != nil {
+		t.Fatalf("beginCallbackExecution() first delivery = %v, %v, want nil", prev, err)
+	}
+
+	prev, err = ctx.beginCallbackExecution("merge")
+	if err != nil || prev == nil || prev.Done {
+		t.Fatalf("beginCallbackExecution() redelivery in progress = %v, %v", prev, err)
+	}
+
+	ctx.Callback.answerText = "Merged"
+	err = ctx.finishCallbackExecution("merge", errors.New("pipeline failed"))
+	if err != nil {
+		t.Fatalf("finishCallbackExecution() error = %v", err)
+	}
+
+	prev, err = ctx.beginCallbackExecution("merge")
+	if err != nil || prev == nil {
+		t.Fatalf("beginCallbackExecution() redelivery = %v, %v", prev, err)
+	}
+
+	if !prev.Done || prev.Answer != "Merged" || prev.Error != "pipeline failed" {
+		t.Errorf("beginCallbackExecution() cached outcome = %+v", prev)
+	}
+}
@@ -0,0 +1,165 @@
+package integram
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// max number of the last handler durations stored per button per day to calculate the median
+const callbackStatDurationsLimit = 100
+
+// per-button per-day counters stored in the "callback_stats" collection
+type callbackStat struct {
+	Service   string  `bson:"s"`
+	DayN      uint16  `bson:"d"` // Days since unix epoch (Unix TS)/(24*60*60)
+	Action    string  `bson:"a"`
+	Button    string  `bson:"b"`
+	Counter   uint32  `bson:"v"`
+	Errors    uint32  `bson:"e"`
+	UserIDs   []int64 `bson:"u"`
+	Durations []int64 `bson:"ms"` // last handler durations in milliseconds
+}
+
+// CallbackStat is the aggregated analytics of the inline button
+type CallbackStat struct {
+	Action         string // callback action func set with SetCallbackAction
+	Button         string // button's data
+	Presses        int
+	UniqueUsers    int
+	Errors         int
+	MedianDuration time.Duration
+}
+
+func init() {
+	registerAdminCommand("callbacks", adminCallbackStatsReport)
+}
+
+// callbackStatRecord stores the button press and the handler duration
+func (c *Context) callbackStatRecord(action string, button string, duration time.Duration, handlerErr error) error {
+	if !Config.MongoStatistic {
+		return nil
+	}
+
+	unixDay := uint16(time.Now().Unix() / (24 * 60 * 60))
+
+	inc := bson.M{"v": 1}
+	if handlerErr != nil {
+		inc["e"] = 1
+	}
+
+	_, err := c.Db().C("callback_stats").Upsert(bson.M{"s": c.ServiceName, "d": unixDay, "a": action, "b": button}, bson.M{
+		"$inc":      inc,
+		"$addToSet": bson.M{"u": c.User.ID},
+		"$push": bson.M{"ms": bson.M{
+			"$each":  []int64{int64(duration / time.Millisecond)},
+			"$slice": -callbackStatDurationsLimit,
+		}},
+		"$setOnInsert": bson.M{"s": c.ServiceName, "d": unixDay, "a": action, "b": button},
+	})
+
+	return err
+}
+
+// CallbackStats returns per-button analytics for the service for the last days, sorted by the number of presses
+func (c *Context) CallbackStats(days int) ([]CallbackStat, error) {
+	fromDay := uint16(time.Now().Unix()/(24*60*60)) - uint16(days) + 1
+
+	var stats []callbackStat
+	err := c.Db().C("callback_stats").Find(bson.M{"s": c.ServiceName, "d": bson.M{"$gte": fromDay}}).All(&stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateCallbackStats(stats), nil
+}
+
+func aggregateCallbackStats(stats []callbackStat) []CallbackStat {
+	type acc struct {
+		CallbackStat
+		users     map[int64]struct{}
+		durations []int64
+	}
+
+	var keys []string
+	perButton := make(map[string]*acc)
+
+	for _, s := range stats {
+		key := s.Action + "\n" + s.Button
+		a, exists := perButton[key]
+		if !exists {
+			a = &acc{CallbackStat: CallbackStat{Action: s.Action, Button: s.Button}, users: make(map[int64]struct{})}
+			perButton[key] = a
+			keys = append(keys, key)
+		}
+
+		a.Presses += int(s.Counter)
+		a.Errors += int(s.Errors)
+		for _, id := range s.UserIDs {
+			a.users[id] = struct{}{}
+		}
+		a.durations = append(a.durations, s.Durations...)
+	}
+
+	res := make([]CallbackStat, 0, len(keys))
+	for _, key := range keys {
+		a := perButton[key]
+		a.UniqueUsers = len(a.users)
+		a.MedianDuration = time.Duration(median(a.durations)) * time.Millisecond
+		res = append(res, a.CallbackStat)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Presses > res[j].Presses
+	})
+
+	return res
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// adminCallbackStatsReport: /integram callbacks [days]
+func adminCallbackStatsReport(c *Context, args []string) (string, error) {
+	if c.ServiceName == "" {
+		return "", fmt.Errorf("Service must be specified")
+	}
+
+	days := 7
+	if len(args) > 0 {
+		var err error
+		days, err = strconv.Atoi(args[0])
+		if err != nil || days < 1 {
+			return "", fmt.Errorf("Wrong number of days: %s", args[0])
+		}
+	}
+
+	stats, err := c.CallbackStats(days)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("%s: inline buttons for the last %d days", c.ServiceName, days)}
+	for _, s := range stats {
+		lines = append(lines, fmt.Sprintf("%s [%s]: %d presses, %d users, %d errors, median %s", s.Action, s.Button, s.Presses, s.UniqueUsers, s.Errors, s.MedianDuration))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,40 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_median(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int64
+		want   int64
+	}{
+		{"empty", nil, 0},
+		{"odd", []int64{30, 10, 20}, 20},
+		{"even", []int64{40, 10, 30, 20}, 25},
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("%q. median() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_aggregateCallbackStats(t *testing.T) {
+	stats := []callbackStat{
+		{Action: "trello.cardButton", Button: "vote", Counter: 2, UserIDs: []int64{1, 2}, Durations: []int64{100, 300}},
+		{Action: "trello.cardButton", Button: "done", Counter: 1, UserIDs: []int64{1}, Durations: []int64{50}},
+		{Action: "trello.cardButton", Button: "vote", Counter: 3, Errors: 1, UserIDs: []int64{2, 3}, Durations: []int64{200}},
+	}
+	want := []CallbackStat{
+		{Action: "trello.cardButton", Button: "vote", Presses: 5, UniqueUsers: 3, Errors: 1, MedianDuration: 200 * time.Millisecond},
+		{Action: "trello.cardButton", Button: "done", Presses: 1, UniqueUsers: 1, MedianDuration: 50 * time.Millisecond},
+	}
+
+	if got := aggregateCallbackStats(stats); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateCallbackStats() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,409 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	chatSnapshotReasonPeriodic = "periodic"
+	chatSnapshotReasonRestore  = "restore" // state right before the restore, to be able to roll it back
+)
+
+// chatSnapshotData is the service's part of the chat configuration
+type chatSnapshotData struct {
+	Settings      interface{}    `bson:"settings,omitempty" json:"settings,omitempty"`
+	Hooks         []serviceHook  `bson:"hooks,omitempty" json:"hooks,omitempty"`                 // chat's own hooks
+	Keyboards     []chatKeyboard `bson:"keyboards,omitempty" json:"keyboards,omitempty"`         // keyboards sent by the service's bot
+	Subscriptions []string       `bson:"subscriptions,omitempty" json:"subscriptions,omitempty"` // sorted tokens of the users' hooks delivering to the chat
+}
+
+// chatSnapshot is stored in the "chats_snapshots" collection. Only the latest Config.ChatSnapshotsKeep versions are kept per chat and service
+type chatSnapshot struct {
+	ChatID    int64     `bson:"chatid"`
+	Service   string    `bson:"service"`
+	Version   int       `bson:"v"`
+	Hash      string    `bson:"hash"`
+	Reason    string    `bson:"reason"`
+	CreatedAt time.Time `bson:"createdat"`
+
+	chatSnapshotData `bson:",inline"`
+}
+
+func init() {
+	registerAdminCommand("restore", adminRestoreChat)
+}
+
+// chatSnapshotsMaker periodically snapshots the service's chats changed since the previous snapshot
+func chatSnapshotsMaker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("chatSnapshotsMaker panic recovered %v", r)
+			chatSnapshotsMaker()
+		}
+	}()
+
+	if Config.IsMainInstance() || Config.ChatSnapshotsInterval <= 0 {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		// spread the load after the restart
+		time.Sleep(time.Hour)
+
+		var list []*Service
+		serviceMapMutex.RLock()
+		for _, service := range services {
+			list = append(list, service)
+		}
+		serviceMapMutex.RUnlock()
+
+		for _, service := range list {
+			created, err := snapshotServiceChats(db, service)
+			if err != nil {
+				log.WithError(err).WithField("service", service.Name).Error("chatSnapshotsMaker: can't snapshot the chats")
+				continue
+			}
+
+			if created > 0 {
+				log.WithField("service", service.Name).Infof("chatSnapshotsMaker: %d chats snapshots created", created)
+			}
+		}
+
+		time.Sleep(Config.ChatSnapshotsInterval)
+	}
+}
+
+// serviceBotID returns the ID of the service's bot or 0 if the bot isn't registered
+func serviceBotID(service *Service) int64 {
+	if bot := service.Bot(); bot != nil {
+		return bot.ID
+	}
+	return 0
+}
+
+// chatSnapshotDataFrom extracts the service's part of the chat's data
+func chatSnapshotDataFrom(chat chatData, serviceName string, botID int64, subscriptions []string) chatSnapshotData {
+	d := chatSnapshotData{Settings: chat.Settings[serviceName]}
+
+	for _, hook := range chat.Hooks {
+		if SliceContainsString(hook.Services, serviceName) {
+			d.Hooks = append(d.Hooks, hook)
+		}
+	}
+
+	for _, kb := range chat.KeyboardPerBot {
+		if kb.BotID == botID {
+			d.Keyboards = append(d.Keyboards, kb)
+		}
+	}
+
+	if len(subscriptions) > 0 {
+		d.Subscriptions = append([]string{}, subscriptions...)
+		sort.Strings(d.Subscriptions)
+	}
+
+	return d
+}
+
+// chatSubscriptions returns the tokens of the users' service hooks delivering to the chats, grouped by the chat ID
+func chatSubscriptions(db *mgo.Database, serviceName string, chatIDs ...int64) (map[int64][]string, error) {
+	query := bson.M{"hooks.services": serviceName, "hooks.chats": bson.M{"$exists": true}}
+	if len(chatIDs) > 0 {
+		query["hooks.chats"] = bson.M{"$in": chatIDs}
+	}
+
+	var users []userData
+	err := db.C("users").Find(query).Select(bson.M{"hooks": 1}).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64][]string)
+	for _, user := range users {
+		for _, hook := range user.Hooks {
+			if !SliceContainsString(hook.Services, serviceName) {
+				continue
+			}
+			for _, chatID := range hook.Chats {
+				res[chatID] = append(res[chatID], hook.Token)
+			}
+		}
+	}
+	return res, nil
+}
+
+// chatSnapshotDataQuery selects the chats that have the service's part of the data
+func chatSnapshotDataQuery(serviceName string, botID int64) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"settings." + serviceName: bson.M{"$exists": true}},
+		{"hooks.services": serviceName},
+		{"keyboardperbot.botid": botID},
+	}}
+}
+
+// currentChatSnapshotData returns the current service's part of the chat's data
+func currentChatSnapshotData(db *mgo.Database, service *Service, chatID int64) (chatSnapshotData, error) {
+	botID := serviceBotID(service)
+
+	var chat chatData
+	err := db.C("chats").FindId(chatID).Select(bson.M{"settings." + service.Name: 1, "hooks": 1, "keyboardperbot": 1}).One(&chat)
+	if err != nil && err != mgo.ErrNotFound {
+		return chatSnapshotData{}, err
+	}
+
+	subscriptions, err := chatSubscriptions(db, service.Name, chatID)
+	if err != nil {
+		return chatSnapshotData{}, err
+	}
+
+	return chatSnapshotDataFrom(chat, service.Name, botID, subscriptions[chatID]), nil
+}
+
+// snapshotServiceChats snapshots the service's chats changed since their latest snapshot. Returns the number of the created snapshots
+func snapshotServiceChats(db *mgo.Database, service *Service) (int, error) {
+	botID := serviceBotID(service)
+
+	subscriptions, err := chatSubscriptions(db, service.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	snapshotted := make(map[int64]bool)
+
+	var chat chatData
+	iter := db.C("chats").Find(chatSnapshotDataQuery(service.Name, botID)).Select(bson.M{"settings." + service.Name: 1, "hooks": 1, "keyboardperbot": 1}).Iter()
+	for iter.Next(&chat) {
+		snapshotted[chat.ID] = true
+
+		_, isNew, err := saveChatSnapshot(db, service.Name, chat.ID, chatSnapshotDataFrom(chat, service.Name, botID, subscriptions[chat.ID]), chatSnapshotReasonPeriodic)
+		if err != nil {
+			log.WithError(err).WithField("chat", chat.ID).WithField("service", service.Name).Error("snapshotServiceChats: can't save the snapshot")
+		} else if isNew {
+			created++
+		}
+		chat = chatData{}
+	}
+
+	if err := iter.Close(); err != nil {
+		return created, err
+	}
+
+	// chats that only receive the users' hooks
+	for chatID, tokens := range subscriptions {
+		if snapshotted[chatID] {
+			continue
+		}
+
+		_, isNew, err := saveChatSnapshot(db, service.Name, chatID, chatSnapshotDataFrom(chatData{}, service.Name, botID, tokens), chatSnapshotReasonPeriodic)
+		if err != nil {
+			log.WithError(err).WithField("chat", chatID).WithField("service", service.Name).Error("snapshotServiceChats: can't save the snapshot")
+		} else if isNew {
+			created++
+		}
+	}
+
+	return created, nil
+}
+
+// latestChatSnapshot returns the latest chat's snapshot or nil if not exists
+func latestChatSnapshot(db *mgo.Database, serviceName string, chatID int64) (*chatSnapshot, error) {
+	var snap chatSnapshot
+	err := db.C("chats_snapshots").Find(bson.M{"chatid": chatID, "service": serviceName}).Sort("-v").One(&snap)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// saveChatSnapshot stores the new version of the chat's snapshot unless it is the same as the latest one. Returns true if the new version was created
+func saveChatSnapshot(db *mgo.Database, serviceName string, chatID int64, data chatSnapshotData, reason string) (*chatSnapshot, bool, error) {
+	hash, err := snapshotHash(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	latest, err := latestChatSnapshot(db, serviceName, chatID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if latest != nil && latest.Hash == hash {
+		return latest, false, nil
+	}
+
+	snap := chatSnapshot{ChatID: chatID, Service: serviceName, Version: 1, Hash: hash, Reason: reason, CreatedAt: time.Now(), chatSnapshotData: data}
+	if latest != nil {
+		snap.Version = latest.Version + 1
+	}
+
+	err = db.C("chats_snapshots").Insert(snap)
+	if mgo.IsDup(err) {
+		// snapshotted concurrently
+		return latest, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if Config.ChatSnapshotsKeep > 0 {
+		_, err = db.C("chats_snapshots").RemoveAll(bson.M{"chatid": chatID, "service": serviceName, "v": bson.M{"$lte": snap.Version - Config.ChatSnapshotsKeep}})
+		if err != nil {
+			log.WithError(err).WithField("chat", chatID).Error("saveChatSnapshot: can't remove the outdated snapshots")
+		}
+	}
+
+	return &snap, true, nil
+}
+
+// mergeChatSnapshot replaces the service's hooks and keyboards of the chat with the snapshot's ones keeping the rest
+func mergeChatSnapshot(chat chatData, snap chatSnapshotData, serviceName string, botID int64) (hooks []serviceHook, keyboards []chatKeyboard) {
+	for _, hook := range chat.Hooks {
+		if !SliceContainsString(hook.Services, serviceName) {
+			hooks = append(hooks, hook)
+		}
+	}
+	hooks = append(hooks, snap.Hooks...)
+
+	for _, kb := range chat.KeyboardPerBot {
+		if kb.BotID != botID {
+			keyboards = append(keyboards, kb)
+		}
+	}
+	keyboards = append(keyboards, snap.Keyboards...)
+
+	return hooks, keyboards
+}
+
+// restoreChatSnapshot rolls back the service's part of the chat's data to the snapshot's version. The current state is snapshotted before
+func restoreChatSnapshot(db *mgo.Database, service *Service, chatID int64, version int) (*chatSnapshot, error) {
+	var snap chatSnapshot
+	err := db.C("chats_snapshots").Find(bson.M{"chatid": chatID, "service": service.Name, "v": version}).One(&snap)
+	if err == mgo.ErrNotFound {
+		return nil, fmt.Errorf("Snapshot v%d not found", version)
+	} else if err != nil {
+		return nil, err
+	}
+
+	cur, err := currentChatSnapshotData(db, service, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = saveChatSnapshot(db, service.Name, chatID, cur, chatSnapshotReasonRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	var chat chatData
+	err = db.C("chats").FindId(chatID).Select(bson.M{"hooks": 1, "keyboardperbot": 1}).One(&chat)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+
+	hooks, keyboards := mergeChatSnapshot(chat, snap.chatSnapshotData, service.Name, serviceBotID(service))
+
+	set := bson.M{"hooks": hooks, "keyboardperbot": keyboards}
+	update := bson.M{"$set": set}
+	if snap.Settings != nil {
+		set["settings."+service.Name] = snap.Settings
+	} else {
+		update["$unset"] = bson.M{"settings." + service.Name: ""}
+	}
+
+	_, err = db.C("chats").UpsertId(chatID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := snap.Subscriptions
+	if tokens == nil {
+		tokens = []string{}
+	}
+
+	// unsubscribe the chat from the users' hooks added after the snapshot
+	_, err = db.C("users").UpdateAll(
+		bson.M{"hooks": bson.M{"$elemMatch": bson.M{"services": service.Name, "chats": chatID, "token": bson.M{"$nin": tokens}}}},
+		bson.M{"$pull": bson.M{"hooks.$.chats": chatID}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range snap.Subscriptions {
+		err = db.C("users").Update(bson.M{"hooks.token": token}, bson.M{"$addToSet": bson.M{"hooks.$.chats": chatID}})
+		if err == mgo.ErrNotFound {
+			log.WithField("chat", chatID).WithField("service", service.Name).Warn("restoreChatSnapshot: the user's hook was removed since the snapshot")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return &snap, nil
+}
+
+func (snap chatSnapshot) String() string {
+	return fmt.Sprintf("v%d %s %s: %d hooks, %d subscriptions, %d keyboards, settings %t",
+		snap.Version, snap.CreatedAt.UTC().Format("2006-01-02 15:04"), snap.Reason, len(snap.Hooks), len(snap.Subscriptions), len(snap.Keyboards), snap.Settings != nil)
+}
+
+// adminRestoreChat: /integram restore chat_id [version]
+func adminRestoreChat(c *Context, args []string) (string, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", errors.New("Usage: restore chat_id [version]")
+	}
+
+	s := c.Service()
+	if s == nil {
+		return "", errors.New("Service must be specified")
+	}
+
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Wrong chat ID: %s", args[0])
+	}
+
+	if len(args) == 2 {
+		version, err := strconv.Atoi(strings.TrimPrefix(args[1], "v"))
+		if err != nil {
+			return "", fmt.Errorf("Wrong version: %s", args[1])
+		}
+
+		snap, err := restoreChatSnapshot(c.db, s, chatID, version)
+		if err != nil {
+			return "", err
+		}
+
+		log.WithField("chat", chatID).WithField("service", s.Name).Warnf("Chat restored to the snapshot v%d", snap.Version)
+		return fmt.Sprintf("Chat %d restored to %s", chatID, snap.String()), nil
+	}
+
+	var snapshots []chatSnapshot
+	err = c.db.C("chats_snapshots").Find(bson.M{"chatid": chatID, "service": s.Name}).Sort("-v").All(&snapshots)
+	if err != nil {
+		return "", err
+	}
+
+	if len(snapshots) == 0 {
+		return fmt.Sprintf("No snapshots of the chat %d", chatID), nil
+	}
+
+	lines := []string{fmt.Sprintf("Snapshots of the chat %d. Use restore %d version to roll back", chatID, chatID)}
+	for _, snap := range snapshots {
+		lines = append(lines, snap.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,87 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_chatSnapshotDataFrom(t *testing.T) {
+	chat := chatData{
+		Settings:       map[string]interface{}{"gitlab": map[string]interface{}{"mr": true}, "trello": map[string]interface{}{"due": false}},
+		Hooks:          []serviceHook{{Token: "a", Services: []string{"gitlab"}}, {Token: "b", Services: []string{"trello"}}},
+		KeyboardPerBot: []chatKeyboard{{MsgID: 1, BotID: 10}, {MsgID: 2, BotID: 20}},
+	}
+
+	got := chatSnapshotDataFrom(chat, "gitlab", 10, []string{"z", "y"})
+	want := chatSnapshotData{
+		Settings:      map[string]interface{}{"mr": true},
+		Hooks:         []serviceHook{{Token: "a", Services: []string{"gitlab"}}},
+		Keyboards:     []chatKeyboard{{MsgID: 1, BotID: 10}},
+		Subscriptions: []string{"y", "z"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chatSnapshotDataFrom() = %+v, want %+v", got, want)
+	}
+
+	if got := chatSnapshotDataFrom(chat, "github", 30, nil); !reflect.DeepEqual(got, chatSnapshotData{}) {
+		t.Errorf("chatSnapshotDataFrom() for the other service = %+v, want empty", got)
+	}
+}
+
+func Test_mergeChatSnapshot(t *testing.T) {
+	chat := chatData{
+		Hooks:          []serviceHook{{Token: "new", Services: []string{"gitlab"}}, {Token: "b", Services: []string{"trello"}}},
+		KeyboardPerBot: []chatKeyboard{{MsgID: 3, BotID: 10}, {MsgID: 2, BotID: 20}},
+	}
+	snap := chatSnapshotData{
+		Hooks:     []serviceHook{{Token: "old", Services: []string{"gitlab"}}},
+		Keyboards: []chatKeyboard{{MsgID: 1, BotID: 10}},
+	}
+
+	hooks, keyboards := mergeChatSnapshot(chat, snap, "gitlab", 10)
+
+	if want := []serviceHook{{Token: "b", Services: []string{"trello"}}, {Token: "old", Services: []string{"gitlab"}}}; !reflect.DeepEqual(hooks, want) {
+		t.Errorf("mergeChatSnapshot() hooks = %+v, want %+v", hooks, want)
+	}
+
+	if want := []chatKeyboard{{MsgID: 2, BotID: 20}, {MsgID: 1, BotID: 10}}; !reflect.DeepEqual(keyboards, want) {
+		t.Errorf("mergeChatSnapshot() keyboards = %+v, want %+v", keyboards, want)
+	}
+}
+
+func Test_saveChatSnapshot(t *testing.T) {
+	defer func(keep int) { Config.ChatSnapshotsKeep = keep }(Config.ChatSnapshotsKeep)
+	Config.ChatSnapshotsKeep = 2
+
+	const chatID = -100500
+	db.C("chats_snapshots").RemoveAll(bson.M{"chatid": chatID})
+
+	_, isNew, err := saveChatSnapshot(db, "servicewithbottoken", chatID, chatSnapshotData{Subscriptions: []string{"a"}}, chatSnapshotReasonPeriodic)
+	if err != nil || !isNew {
+		t.Fatalf("saveChatSnapshot() first = %v, %v", isNew, err)
+	}
+
+	snap, isNew, err := saveChatSnapshot(db, "servicewithbottoken", chatID, chatSnapshotData{Subscriptions: []string{"a"}}, chatSnapshotReasonPeriodic)
+	if err != nil || isNew || snap.Version != 1 {
+		t.Fatalf("saveChatSnapshot() unchanged = %v, %v, %v", snap, isNew, err)
+	}
+
+	for _, token := range []string{"b", "c"} {
+		snap, isNew, err = saveChatSnapshot(db, "servicewithbottoken", chatID, chatSnapshotData{Subscriptions: []string{token}}, chatSnapshotReasonPeriodic)
+		if err != nil || !isNew {
+			t.Fatalf("saveChatSnapshot() changed = %v, %v", isNew, err)
+		}
+	}
+
+	if snap.Version != 3 {
+		t.Errorf("saveChatSnapshot() version = %d, want 3", snap.Version)
+	}
+
+	n, _ := db.C("chats_snapshots").Find(bson.M{"chatid": chatID}).Count()
+	if n != 2 {
+		t.Errorf("saveChatSnapshot() kept %d snapshots, want 2", n)
+	}
+}
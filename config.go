@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 type Mode string
@@ -42,6 +43,85 @@ type config struct {
 	MongoStatistic bool   `envconfig:"INTEGRAM_MONGO_STATISTIC" default:"0"`
 	ConfigDir      string `envconfig:"INTEGRAM_CONFIG_DIR" default:"./.conf"` // default is $GOPATH/.conf
 
+	AdminIDs   []int64 `envconfig:"INTEGRAM_ADMIN_IDS"`   // TG user IDs allowed to use /integram admin commands
+	AdminToken string  `envconfig:"INTEGRAM_ADMIN_TOKEN"` // token to access /admin HTTP endpoints. Set empty to disable them
+
+	Warmup      bool `envconfig:"INTEGRAM_WARMUP" default:"0"`          // preload the most active chats and users and check the bots webhooks on start. /ready returns 503 until it finished
+	WarmupChats int  `envconfig:"INTEGRAM_WARMUP_CHATS" default:"1000"` // number of the most active chats to preload
+
+	HibernateAfter time.Duration `envconfig:"INTEGRAM_HIBERNATE_AFTER" default:"2160h"` // stop processing webhooks for group chats without human activity for this period until the next message. Set 0 to disable
+
+	ReconcileChatsInterval time.Duration `envconfig:"INTEGRAM_RECONCILE_CHATS_INTERVAL" default:"720h"` // verify that the bot is still present in the subscribed chats once per this period. Set 0 to disable
+
+	ChatSnapshotsInterval time.Duration `envconfig:"INTEGRAM_CHAT_SNAPSHOTS_INTERVAL" default:"24h"` // snapshot the changed chats settings, subscriptions and keyboards once per this period. See /integram restore. Set 0 to disable
+	ChatSnapshotsKeep     int           `envconfig:"INTEGRAM_CHAT_SNAPSHOTS_KEEP" default:"10"`      // number of the latest snapshots stored per chat and service
+
+	TGAPICacheTTL time.Duration `envconfig:"INTEGRAM_TG_API_CACHE_TTL" default:"1m"` // cache getChat and getChatMember responses used for the permission checks. Invalidated by the members and chat updates. Set 0 to disable
+
+	OverflowBotTokens []string `envconfig:"INTEGRAM_OVERFLOW_BOT_TOKENS"` // "service:bot_token" list of the bots taking over the high-volume group chats of the service's bot, see /integram handoff
+
+	TGWebhookCheckInterval time.Duration `envconfig:"INTEGRAM_TG_WEBHOOK_CHECK_INTERVAL" default:"10m"` // check the Telegram webhooks of the bots with getWebhookInfo and set them again on the wrong URL or certificate errors. Set 0 to disable
+
+	RepeatedNotificationsPeriod time.Duration `envconfig:"INTEGRAM_REPEATED_NOTIFICATIONS_PERIOD" default:"1h"` // collapse the identical notifications sent in a row into the last message with the "×N" counter until the chat is quiet for this period. Set 0 to disable
+
+	MessageHistory bool `envconfig:"INTEGRAM_MESSAGE_HISTORY" default:"1"` // store the edits applied to the outgoing messages for 30 days. See /integram timeline
+
+	HandlerTimeout time.Duration `envconfig:"INTEGRAM_HANDLER_TIMEOUT" default:"60s"` // max execution time of the services' message, callback and webhook handlers. The handler keeps running in the background after it, use Context.Ctx() to abort it. Set 0 to disable
+
+	Profiling bool `envconfig:"INTEGRAM_PROFILING" default:"0"` // record per service and update type handlers stats and label the CPU profile samples. See /integram profile
+
+	BrandingGreeting      string `envconfig:"INTEGRAM_BRANDING_GREETING"`                     // sent when the bot is added to the group. {bot} and {service} are replaced with the bot's username and the service's name
+	BrandingEmoji         bool   `envconfig:"INTEGRAM_BRANDING_EMOJI" default:"1"`            // prefix core messages with the emoji
+	BrandingFooter        string `envconfig:"INTEGRAM_BRANDING_FOOTER"`                       // plain text appended to the core messages
+	BrandingParseMode     string `envconfig:"INTEGRAM_BRANDING_PARSE_MODE"`                   // parse mode of the greeting: HTML, Markdown or MarkdownV2. Empty for the plain text
+	BrandingErrorTone     string `envconfig:"INTEGRAM_BRANDING_ERROR_TONE" default:"neutral"` // tone of the error messages: neutral or friendly
+	BrandingCommandPrefix string `envconfig:"INTEGRAM_BRANDING_COMMAND_PREFIX"`               // prefix of the core commands, e.g. "acme_" turns /webhook into /acme_webhook
+
+	WebhookPauseAfterFailures   int           `envconfig:"INTEGRAM_WEBHOOK_PAUSE_AFTER_FAILURES" default:"20"`  // pause the hook and alert its chats after this number of failed deliveries in a row. Set 0 to disable
+	WebhookStoppedAfterFailures int           `envconfig:"INTEGRAM_WEBHOOK_STOPPED_AFTER_FAILURES" default:"3"` // alert the hook's chats if deliveries stopped after this number of failures in a row. Set 0 to disable
+	WebhookStoppedPeriod        time.Duration `envconfig:"INTEGRAM_WEBHOOK_STOPPED_PERIOD" default:"24h"`       // how long the hook must be silent to be considered disabled by upstream
+	WebhookDedupWindow          time.Duration `envconfig:"INTEGRAM_WEBHOOK_DEDUP_WINDOW"`                       // ignore the identical payloads received on the same hook within this window. Set 0 to disable
+
+	WebhookMultipartMaxMemory int64 `envconfig:"INTEGRAM_WEBHOOK_MULTIPART_MAX_MEMORY" default:"8388608"` // max bytes of the multipart webhook's files kept in memory, the rest are stored in the temp files. See WebhookContext.Multipart()
+	WebhookMultipartMaxSize   int64 `envconfig:"INTEGRAM_WEBHOOK_MULTIPART_MAX_SIZE" default:"52428800"`  // max size of the multipart webhook's body. Set 0 for unlimited
+
+	DegradedMode bool `envconfig:"INTEGRAM_DEGRADED_MODE" default:"1"` // when the DB is unavailable buffer the webhooks and Telegram updates to $INTEGRAM_CONFIG_DIR/degraded, send only the messages which don't need the DB and replay the buffered work after the recovery
+
+	WebhookTrustedProxies []string `envconfig:"INTEGRAM_WEBHOOK_TRUSTED_PROXIES"` // IPs or CIDRs of the reverse proxies in front of the instance. Their X-Forwarded-For, X-Real-IP and X-Forwarded-Proto are used for WebhookContext's ClientIP() and TLS()
+
+	TGRateLimit         int `envconfig:"INTEGRAM_TG_RATE_LIMIT" default:"30"`           // max messages per second sent by the bot. Messages and edits above the limits wait in the queue. Set 0 to disable
+	TGRateLimitPerChat  int `envconfig:"INTEGRAM_TG_RATE_LIMIT_PER_CHAT" default:"1"`   // max messages per second to the private chat. Set 0 to disable
+	TGRateLimitPerGroup int `envconfig:"INTEGRAM_TG_RATE_LIMIT_PER_GROUP" default:"20"` // max messages per minute to the group. Set 0 to disable
+
+	TGAntiFloodRetries  int           `envconfig:"INTEGRAM_TG_ANTI_FLOOD_RETRIES" default:"3"`     // queue the edits rejected by Telegram anti-flood to retry after retry_after with the exponential backoff. Set 0 to disable
+	TGAntiFloodMaxDelay time.Duration `envconfig:"INTEGRAM_TG_ANTI_FLOOD_MAX_DELAY" default:"30s"` // max delay before the retry. Edits are given up if Telegram asks to wait longer
+
+	TGQueueMaxLag      time.Duration `envconfig:"INTEGRAM_TG_QUEUE_MAX_LAG" default:"30s"`      // Telegram queue is backlogged when messages wait longer on average: low priority messages are shed and webhooks are spilled to disk. Set 0 to disable
+	WebhookMaxInFlight int           `envconfig:"INTEGRAM_WEBHOOK_MAX_IN_FLIGHT" default:"100"` // max number of webhooks processed simultaneously, the rest are spilled to disk. Set 0 to disable
+	WebhookSpillDir    string        `envconfig:"INTEGRAM_WEBHOOK_SPILL_DIR"`                   // default is $INTEGRAM_CONFIG_DIR/webhooks_spill
+	WebhookSpillMax    int           `envconfig:"INTEGRAM_WEBHOOK_SPILL_MAX" default:"10000"`   // max number of spilled webhooks. New webhooks are rejected with 503 above it
+
+	TranslationProvider string `envconfig:"INTEGRAM_TRANSLATION_PROVIDER"` // deepl, google or custom. Chats set the target language with /translate. Empty to disable
+	TranslationAPIKey   string `envconfig:"INTEGRAM_TRANSLATION_API_KEY"`
+	TranslationURL      string `envconfig:"INTEGRAM_TRANSLATION_URL"` // endpoint of the custom provider or the provider's API URL override, e.g. DeepL free plan
+
+	FileScan              bool   `envconfig:"INTEGRAM_FILE_SCAN" default:"0"`                          // scan the files forwarded between Telegram and upstreams for viruses. Infected files are moved to the quarantine
+	FileScanURL           string `envconfig:"INTEGRAM_FILE_SCAN_URL" default:"clamd://127.0.0.1:3310"` // clamd://host:port, unix:///path/to/clamd.sock or icap://host:port/service
+	FileScanMaxSize       int64  `envconfig:"INTEGRAM_FILE_SCAN_MAX_SIZE" default:"26214400"`          // larger files are forwarded without scanning. Set 0 to scan all files
+	FileScanAllowOnError  bool   `envconfig:"INTEGRAM_FILE_SCAN_ALLOW_ON_ERROR" default:"0"`           // forward the file if the scanner is unavailable instead of blocking it
+	FileScanQuarantineDir string `envconfig:"INTEGRAM_FILE_SCAN_QUARANTINE_DIR"`                       // default is $INTEGRAM_CONFIG_DIR/quarantine
+
+	OAuthBrokerURL      string   `envconfig:"INTEGRAM_OAUTH_BROKER_URL"`       // base URL of the hosted instance to delegate OAuth of the services without own OAuth app, e.g. https://integram.org
+	OAuthBrokerClientID string   `envconfig:"INTEGRAM_OAUTH_BROKER_CLIENT_ID"` // this instance's client ID registered at the broker
+	OAuthBrokerSecret   string   `envconfig:"INTEGRAM_OAUTH_BROKER_SECRET"`    // secret shared with the broker to sign the requests
+	OAuthBrokerClients  []string `envconfig:"INTEGRAM_OAUTH_BROKER_CLIENTS"`   // "client_id:secret" list of the self-hosted instances allowed to use this instance's OAuth apps. Empty to disable the broker
+
+	NotifyFallbackAfterFailures int    `envconfig:"INTEGRAM_NOTIFY_FALLBACK_AFTER_FAILURES" default:"3"` // send the critical alerts to the user's verified email or webhook (see /notify) after this number of failed Telegram deliveries in a row. Set 0 to disable
+	SMTPAddr                    string `envconfig:"INTEGRAM_SMTP_ADDR"`                                  // host:port of the SMTP server used for the email notifications. Empty to disable them
+	SMTPUser                    string `envconfig:"INTEGRAM_SMTP_USER"`
+	SMTPPassword                string `envconfig:"INTEGRAM_SMTP_PASSWORD"`
+	SMTPFrom                    string `envconfig:"INTEGRAM_SMTP_FROM"`
+
 	// -----
 	// only make sense for InstanceModeMultiProcessService
 	HealthcheckIntervalInSecond int    `envconfig:"INTEGRAM_HEALTHCHECK_INTERVAL" default:"30"` // interval to ping each service instance by the main instance
@@ -1,7 +1,10 @@
 package integram
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -38,12 +41,20 @@ type Context struct {
 	Chat               Chat                // Chat associated with current webhook or Telegram update
 	Message            *IncomingMessage    // Telegram incoming message if it triggired current request
 	MessageEdited      bool                // True if Message is edited message instead of the new one
+	MessagePrevText    string              // Text of the Message before the edit. Only for the services with TGEditMessageHandler, empty if unknown
 	InlineQuery        *tg.InlineQuery     // Telegram inline query if it triggired current request
 	ChosenInlineResult *chosenInlineResult // Telegram chosen inline result if it triggired current request
+	PollAnswer         *PollAnswer         // answer in the bot's poll if it triggired current request
 
 	Callback              *callback  // Telegram inline buttons callback if it it triggired current request
 	inlineQueryAnsweredAt *time.Time // used to log slow inline responses
 	messageAnsweredAt *time.Time 	 // used to log slow messages responses
+	requestID string // used to tag the ServiceCollection queries
+	readOnly bool // user and chat records are not created or updated, e.g. for inline queries
+	viewerKeyboard *InlineKeyboard // set by the viewer keyboard action with RenderViewerKeyboard
+	deadline context.Context // canceled when the handler's execution time exceeded, see runHandler
+	webhook *WebhookContext // webhook being handled, see WebhookEvent
+	replyKeyboardAnswer *Button // pressed button of the reply keyboard migrated to the inline one, see MigrateReplyKeyboard
 
 }
 
@@ -58,6 +69,24 @@ type callback struct {
 	Data       string
 	AnsweredAt *time.Time
 	State      int // state is used for checkbox buttons or for other switches
+
+	answerText  string // cached to answer the redelivered callback, see callbackExecution
+	answerAlert bool
+}
+
+// detachedCopy returns the copy of the context that can be used concurrently with c. User's and chat's data and the callback are copied too
+func (c *Context) detachedCopy() *Context {
+	cp := *c
+	cp.User.ctx = &cp
+	cp.Chat.ctx = &cp
+	cp.User.data = c.User.data.copy()
+	cp.Chat.data = c.Chat.data.copy()
+
+	if c.Callback != nil {
+		cb := *c.Callback
+		cp.Callback = &cb
+	}
+	return &cp
 }
 
 func (c *Context) SetDb(database *mgo.Database) {
@@ -201,7 +230,14 @@ type WebhookContext struct {
 	body       []byte
 	firstParse bool
 
-	requestID string
+	serviceName string
+	route       string // hook token
+	requestID   string
+	receivedAt  time.Time
+
+	multipart      *WebhookMultipart
+	multipartHolds int32           // handlers that may still read the multipart temp files, see holdMultipart
+	response       webhookResponse // set by the service with RespondJSON, Status and SetHeader
 }
 
 // FirstParse indicates that the request body is not yet readed
@@ -258,6 +294,10 @@ func (wc *WebhookContext) Redirect(code int, s string) {
 // KeyboardAnswer retrieve the data related to pressed button
 // buttonText will be returned only in case this button relates to the one in db for this chat
 func (c *Context) KeyboardAnswer() (data string, buttonText string) {
+	if c.replyKeyboardAnswer != nil {
+		return c.replyKeyboardAnswer.Data, c.replyKeyboardAnswer.Text
+	}
+
 	keyboard, err := c.keyboard()
 
 	if err != nil || keyboard.ChatID == 0 {
@@ -332,7 +372,8 @@ func saveKeyboard(m *OutgoingMessage, db *mgo.Database) error {
 			var info *mgo.ChangeInfo
 
 			usersID := detectTargetUsersID(db, &m.Message)
-			info, err := db.C("users").UpdateAll(bson.M{"_id": bson.M{"$in": usersID}, fmt.Sprintf("keyboardperchat.%d.botid", m.ChatID): m.BotID}, bson.M{"$unset": bson.M{fmt.Sprintf("keyboardperchat.%d", m.ChatID): true}})
+			// keyboardperchat is an array, so unsetting it by the chat ID as the index left the nulls, see the keyboard_nulls data repair
+			info, err := db.C("users").UpdateAll(bson.M{"_id": bson.M{"$in": usersID}}, bson.M{"$pull": bson.M{"keyboardperchat": bson.M{"chatid": m.ChatID, "botid": m.BotID}}})
 			log.WithField("changes", info).WithError(err).Info("unsetting keyboards")
 
 		} else {
@@ -352,9 +393,10 @@ func (c *Context) keyboard() (chatKeyboard, error) {
 
 	udata, _ := c.User.getData()
 	chatID := c.Chat.ID
+	botID := c.chatBot().ID
 
 	for _, kb := range udata.KeyboardPerChat {
-		if kb.ChatID == chatID && kb.BotID == c.Bot().ID {
+		if kb.ChatID == chatID && kb.BotID == botID {
 			return kb, nil
 		}
 
@@ -363,7 +405,7 @@ func (c *Context) keyboard() (chatKeyboard, error) {
 	cdata, _ := c.Chat.getData()
 
 	for _, kb := range cdata.KeyboardPerBot {
-		if kb.ChatID == chatID && kb.BotID == c.Bot().ID {
+		if kb.ChatID == chatID && kb.BotID == botID {
 			return kb, nil
 		}
 	}
@@ -415,7 +457,14 @@ func (c *Context) Log() *log.Entry {
 
 	if c.gin != nil {
 		fields["url"] = c.gin.Request.Method + " " + c.gin.Request.URL.String()
-		fields["ip"] = c.gin.Request.RemoteAddr
+		fields["ip"] = webhookClientIP(c.gin.Request, webhookTrustedProxies())
+	}
+
+	if c.webhook != nil {
+		fields["request"] = c.webhook.requestID
+		if id := c.webhook.Delivery().ID; id != "" {
+			fields["delivery"] = id
+		}
 	}
 
 	fields["domain"] = c.ServiceBaseURL.Host
@@ -439,6 +488,31 @@ func (c *Context) Bot() *Bot {
 	return c.Service().Bot()
 }
 
+// chatBot returns the bot serving the current chat. It's the service's overflow bot if the group chat was handed off to it
+func (c *Context) chatBot() *Bot {
+	bot := c.Bot()
+	if bot == nil || c.db == nil {
+		return bot
+	}
+
+	if botID := handoffBotID(c.db, bot.ID, c.Chat.ID); botID != bot.ID {
+		if overflowBot := botByID(botID); overflowBot != nil {
+			return overflowBot
+		}
+	}
+	return bot
+}
+
+// messageBot returns the bot that sent the message, so the messages of the handed off chats are edited by the overflow bot
+func (c *Context) messageBot(om *OutgoingMessage) *Bot {
+	if om.BotID != 0 {
+		if bot := botByID(om.BotID); bot != nil {
+			return bot
+		}
+	}
+	return c.Bot()
+}
+
 // EditPressedMessageText edit the text in the msg where user taped it in case this request is triggered by inlineButton callback
 func (c *Context) EditPressedMessageText(text string) error {
 	if c.Callback == nil {
@@ -482,7 +556,10 @@ func (c *Context) EditMessageText(om *OutgoingMessage, text string) error {
 		return errors.New("Empty message provided")
 	}
 
-	bot := c.Bot()
+	done := c.beginMessageEdit(om)
+	defer done()
+
+	bot := c.messageBot(om)
 	if om.ParseMode == "HTML" {
 		textCleared, err := sanitize.HTMLAllowing(text, []string{"a", "b", "strong", "i", "em", "a", "code", "pre"}, []string{"href"})
 
@@ -490,6 +567,7 @@ func (c *Context) EditMessageText(om *OutgoingMessage, text string) error {
 			text = textCleared
 		}
 	}
+	text = c.compactedEditText(om, text)
 	om.Text = text
 	prevTextHash := om.TextHash
 	om.TextHash = om.GetTextHash()
@@ -501,15 +579,16 @@ func (c *Context) EditMessageText(om *OutgoingMessage, text string) error {
 	}
 
 
-	_, err := bot.API.Send(tg.EditMessageTextConfig{
+	editText, parseMode := c.textForEdit(text, om.ParseMode)
+	_, err := c.sendEdit(bot, tg.EditMessageTextConfig{
 		BaseEdit: tg.BaseEdit{
 			ChatID:      om.ChatID,
 			MessageID:   om.MsgID,
 			ReplyMarkup: &tg.InlineKeyboardMarkup{InlineKeyboard: om.InlineKeyboardMarkup.tg()},
 		},
-		ParseMode:             om.ParseMode,
+		ParseMode:             parseMode,
 		DisableWebPagePreview: !om.WebPreview,
-		Text: text,
+		Text: editText,
 	})
 	if err != nil {
 		if err.(tg.Error).IsCantAccessChat() || err.(tg.Error).ChatMigrated() {
@@ -517,21 +596,21 @@ func (c *Context) EditMessageText(om *OutgoingMessage, text string) error {
 				c.AnswerCallbackQuery("Sorry, message can be outdated. Bot can't edit messages created before converting to the Super Group", false)
 			}
 		} else if err.(tg.Error).IsAntiFlood() {
-			c.Log().WithError(err).Warn("TG Anti flood activated")
+			c.Log().WithError(err).Warn("TG Anti flood activated, retries exceeded")
 		}
 	} else {
 		err = c.db.C("messages").UpdateId(om.ID, bson.M{"$set": bson.M{"texthash": om.TextHash}})
 	}
+	c.recordMessageHistory(om, messageHistoryOpEditText, text, nil, err)
 	return err
 }
 
 // EditMessagesTextWithEventID edit the last MaxMsgsToUpdateWithEventID messages' text with the corresponding eventID  in ALL chats
 func (c *Context) EditMessagesTextWithEventID(eventID string, text string) (edited int, err error) {
-	var messages []OutgoingMessage
 	//update MAX_MSGS_TO_UPDATE_WITH_EVENTID last bot messages
-	c.db.C("messages").Find(bson.M{"botid": c.Bot().ID, "eventid": eventID}).Sort("-_id").Limit(MaxMsgsToUpdateWithEventID).All(&messages)
+	messages, _ := c.Messages().AllChats().EventID(eventID).Limit(MaxMsgsToUpdateWithEventID).List()
 	for _, message := range messages {
-		err = c.EditMessageText(&message, text)
+		err = c.EditMessageText(message, text)
 		if err != nil {
 			c.Log().WithError(err).WithField("eventid", eventID).Error("EditMessagesTextWithEventID")
 		} else {
@@ -558,16 +637,10 @@ func (c *Context) EditMessageTextWithMessageID(msgID bson.ObjectId, text string)
 
 // EditMessagesWithEventID edit the last MaxMsgsToUpdateWithEventID messages' text and inline keyboard with the corresponding eventID in ALL chats
 func (c *Context) EditMessagesWithEventID(eventID string, fromState string, text string, kb InlineKeyboard) (edited int, err error) {
-	var messages []OutgoingMessage
-	f := bson.M{"botid": c.Bot().ID, "eventid": eventID}
-	if fromState != "" {
-		f["inlinekeyboardmarkup.state"] = fromState
-	}
-
 	//update MAX_MSGS_TO_UPDATE_WITH_EVENTID last bot messages
-	c.db.C("messages").Find(f).Sort("-_id").Limit(MaxMsgsToUpdateWithEventID).All(&messages)
+	messages, _ := c.Messages().AllChats().EventID(eventID).State(fromState).Limit(MaxMsgsToUpdateWithEventID).List()
 	for _, message := range messages {
-		err = c.EditMessageTextAndInlineKeyboard(&message, fromState, text, kb)
+		err = c.EditMessageTextAndInlineKeyboard(message, fromState, text, kb)
 		if err != nil {
 			c.Log().WithError(err).WithField("eventid", eventID).Error("EditMessagesWithEventID")
 		} else {
@@ -577,16 +650,37 @@ func (c *Context) EditMessagesWithEventID(eventID string, fromState string, text
 	return edited, err
 }
 
-// DeleteMessagesWithEventID deletes the last MaxMsgsToUpdateWithEventID messages' text and inline keyboard with the corresponding eventID in ALL chats
-func (c *Context) DeleteMessagesWithEventID(eventID string) (deleted int, err error) {
+// ErrMessageCantBeDeleted is returned when Telegram refuses to delete the message, e.g. it was sent to the group more than 48 hours ago or the bot lost the admin rights.
+// The message's inline keyboard is removed instead
+var ErrMessageCantBeDeleted = errors.New("Message can't be deleted")
+
+// isTGErrorWith returns true if err is the Bot API error which description contains the text
+func isTGErrorWith(err error, text string) bool {
+	tgErr, ok := err.(tg.Error)
+	return ok && strings.Contains(strings.ToLower(tgErr.Message), text)
+}
+
+// DeleteMessagesWithEventID deletes the last MaxMsgsToUpdateWithEventID messages with the corresponding eventID sent by the bot in ALL chats. Set botID to 0 to use the current bot
+func (c *Context) DeleteMessagesWithEventID(botID int64, eventID string) (deleted int, err error) {
+	bot := c.Bot()
+	if botID != 0 {
+		bot = botByID(botID)
+	}
+
+	if bot == nil {
+		return 0, fmt.Errorf("DeleteMessagesWithEventID: bot %d not found", botID)
+	}
+
 	var messages []OutgoingMessage
-	f := bson.M{"botid": c.Bot().ID, "eventid": eventID}
+	f := bson.M{"botid": bot.ID, "eventid": eventID}
 
 	//update MAX_MSGS_TO_UPDATE_WITH_EVENTID last bot messages
 	c.db.C("messages").Find(f).Sort("-_id").Limit(MaxMsgsToUpdateWithEventID).All(&messages)
 	for _, message := range messages {
-		err = c.DeleteMessage(&message)
-		if err != nil {
+		err = c.deleteMessage(bot, &message)
+		if err == ErrMessageCantBeDeleted {
+			c.Log().WithField("eventid", eventID).WithField("msgid", message.MsgID).Warn("DeleteMessagesWithEventID: message can't be deleted")
+		} else if err != nil {
 			c.Log().WithError(err).WithField("eventid", eventID).Error("DeleteMessagesWithEventID")
 		} else {
 			deleted++
@@ -595,15 +689,17 @@ func (c *Context) DeleteMessagesWithEventID(eventID string) (deleted int, err er
 	return deleted, err
 }
 
-// DeleteMessage deletes the outgoing message's text and inline keyboard
+// DeleteMessage deletes the outgoing message and removes it from the DB. Returns ErrMessageCantBeDeleted if Telegram doesn't allow to delete it anymore.
+// Messages already deleted in the chat are removed from the DB without error
 func (c *Context) DeleteMessage(om *OutgoingMessage) error {
-	bot := c.Bot()
-	if om.MsgID != 0 {
-		log.WithField("msgID", om.MsgID).Debug("DeleteMessage")
-	} else {
-		om.ChatID = 0
-		log.WithField("inlineMsgID", om.InlineMsgID).Debug("DeleteMessage")
+	return c.deleteMessage(c.messageBot(om), om)
+}
+
+func (c *Context) deleteMessage(bot *Bot, om *OutgoingMessage) error {
+	if om.MsgID == 0 {
+		return errors.New("DeleteMessage – inline messages can't be deleted")
 	}
+	log.WithField("msgID", om.MsgID).Debug("DeleteMessage")
 
 	var msg OutgoingMessage
 	var ci *mgo.ChangeInfo
@@ -631,25 +727,59 @@ func (c *Context) DeleteMessage(om *OutgoingMessage) error {
 		MessageID: om.MsgID,
 	})
 
-	if err != nil {
-		if err.(tg.Error).IsCantAccessChat() || err.(tg.Error).ChatMigrated() {
+	if isTGErrorWith(err, "message to delete not found") {
+		// already deleted in the chat
+		c.recordMessageHistory(om, messageHistoryOpDelete, "", nil, nil)
+		return nil
+	} else if isTGErrorWith(err, "message can't be deleted") {
+		// the removed record is restored, only its inline keyboard is cleared
+		if len(msg.InlineKeyboardMarkup.Buttons) > 0 {
+			_, kbErr := bot.API.Send(tg.EditMessageReplyMarkupConfig{
+				BaseEdit: tg.BaseEdit{
+					ChatID:      om.ChatID,
+					MessageID:   om.MsgID,
+					ReplyMarkup: &tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{}},
+				},
+			})
+
+			if kbErr == nil {
+				c.recordMessageHistory(&msg, messageHistoryOpEditKb, "", &InlineKeyboard{}, nil)
+				msg.InlineKeyboardMarkup = InlineKeyboard{}
+				om.InlineKeyboardMarkup = InlineKeyboard{}
+			}
+		}
+		c.db.C("messages").Insert(msg)
+		return ErrMessageCantBeDeleted
+	} else if err != nil {
+		if tgErr, ok := err.(tg.Error); ok && (tgErr.IsCantAccessChat() || tgErr.ChatMigrated()) {
 			if c.Callback != nil {
 				c.AnswerCallbackQuery("Message can be outdated. Bot can't edit messages created before converting to the Super Group", false)
 			}
-		} else if err.(tg.Error).IsAntiFlood() {
-			c.Log().WithError(err).Warn("TG Anti flood activated")
+		} else if ok && tgErr.IsAntiFlood() {
+			c.Log().WithError(err).Warn("TG Anti flood activated, retries exceeded")
 		}
 		// Oops. error is occurred – revert the original message
 		c.db.C("messages").Insert(om)
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", nil, err)
 		return err
 	}
 
+	c.recordMessageHistory(om, messageHistoryOpDelete, "", nil, nil)
 	return nil
 }
 
 // EditMessageTextAndInlineKeyboard edit the outgoing message's text and inline keyboard
 func (c *Context) EditMessageTextAndInlineKeyboard(om *OutgoingMessage, fromState string, text string, kb InlineKeyboard) error {
-	bot := c.Bot()
+	kb = withDensityButton(om.InlineKeyboardMarkup, kb)
+	return c.editMessageTextAndInlineKeyboard(om, fromState, c.compactedEditText(om, text), kb)
+}
+
+// editMessageTextAndInlineKeyboard edits the message as is, e.g. to toggle the core buttons' text
+func (c *Context) editMessageTextAndInlineKeyboard(om *OutgoingMessage, fromState string, text string, kb InlineKeyboard) error {
+	done := c.beginMessageEdit(om)
+	defer done()
+
+	bot := c.messageBot(om)
 	if om.MsgID != 0 {
 		log.WithField("msgID", om.MsgID).Debug("EditMessageTextAndInlineKeyboard")
 	} else {
@@ -700,15 +830,16 @@ func (c *Context) EditMessageTextAndInlineKeyboard(om *OutgoingMessage, fromStat
 	}
 
 
-	_, err = bot.API.Send(tg.EditMessageTextConfig{
+	editText, parseMode := c.textForEdit(text, om.ParseMode)
+	_, err = c.sendEdit(bot, tg.EditMessageTextConfig{
 		BaseEdit: tg.BaseEdit{
 			ChatID:          om.ChatID,
 			InlineMessageID: om.InlineMsgID,
 			MessageID:       om.MsgID,
 			ReplyMarkup:     &tg.InlineKeyboardMarkup{InlineKeyboard: tgKeyboard},
 		},
-		ParseMode: om.ParseMode,
-		Text:      text,
+		ParseMode: parseMode,
+		Text:      editText,
 		DisableWebPagePreview: !om.WebPreview,
 	})
 
@@ -718,20 +849,26 @@ func (c *Context) EditMessageTextAndInlineKeyboard(om *OutgoingMessage, fromStat
 				c.AnswerCallbackQuery("Message can be outdated. Bot can't edit messages created before converting to the Super Group", false)
 			}
 		} else if err.(tg.Error).IsAntiFlood() {
-			c.Log().WithError(err).Warn("TG Anti flood activated")
+			c.Log().WithError(err).Warn("TG Anti flood activated, retries exceeded")
 		}
 		// Oops. error is occurred – revert the original keyboard
 		c.db.C("messages").Update(bson.M{"_id": msg.ID}, bson.M{"$set": bson.M{"texthash": prevTextHash, "inlinekeyboardmarkup": msg.InlineKeyboardMarkup}})
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", &msg.InlineKeyboardMarkup, err)
 		return err
 	}
 
+	c.recordMessageHistory(om, messageHistoryOpEditTextAndKb, text, &kb, nil)
 	return nil
 }
 
 // EditInlineKeyboard edit the outgoing message's inline keyboard
 func (c *Context) EditInlineKeyboard(om *OutgoingMessage, fromState string, kb InlineKeyboard) error {
+	done := c.beginMessageEdit(om)
+	defer done()
 
-	bot := c.Bot()
+	kb = withDensityButton(om.InlineKeyboardMarkup, kb)
+
+	bot := c.messageBot(om)
 	if om.MsgID != 0 {
 		log.WithField("msgID", om.MsgID).Debug("EditMessageTextAndInlineKeyboard")
 	} else {
@@ -753,7 +890,7 @@ func (c *Context) EditInlineKeyboard(om *OutgoingMessage, fromState string, kb I
 		return nil
 	}
 
-	_, err = bot.API.Send(tg.EditMessageReplyMarkupConfig{
+	_, err = c.sendEdit(bot, tg.EditMessageReplyMarkupConfig{
 		BaseEdit: tg.BaseEdit{
 			ChatID:          om.ChatID,
 			MessageID:       om.MsgID,
@@ -768,13 +905,15 @@ func (c *Context) EditInlineKeyboard(om *OutgoingMessage, fromState string, kb I
 				c.AnswerCallbackQuery("Message can be outdated. Bot can't edit messages created before converting to the Super Group", false)
 			}
 		} else if err.(tg.Error).IsAntiFlood() {
-			c.Log().WithError(err).Warn("TG Anti flood activated")
+			c.Log().WithError(err).Warn("TG Anti flood activated, retries exceeded")
 		}
 		// Oops. error is occurred – revert the original keyboard
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", &msg.InlineKeyboardMarkup, err)
 		err := c.db.C("messages").Update(bson.M{"_id": msg.ID}, bson.M{"$set": bson.M{"inlinekeyboardmarkup": msg.InlineKeyboardMarkup}})
 		return err
 	}
 
+	c.recordMessageHistory(om, messageHistoryOpEditKb, "", &kb, nil)
 	return nil
 
 }
@@ -795,7 +934,10 @@ func (c *Context) EditInlineStateButton(om *OutgoingMessage, kbState string, old
 		c.Log().WithField("data", buttonData).WithField("text", newButtonText).Errorf("EditInlineStateButton – newButtonState must be [0-9], %d recived", newButtonState)
 	}
 
-	bot := c.Bot()
+	done := c.beginMessageEdit(om)
+	defer done()
+
+	bot := c.messageBot(om)
 
 	var msg OutgoingMessage
 	c.db.C("messages").Find(bson.M{"_id": om.ID, "inlinekeyboardmarkup.state": kbState}).One(&msg)
@@ -829,7 +971,7 @@ func (c *Context) EditInlineStateButton(om *OutgoingMessage, kbState string, old
 
 	// todo: the stored keyboard can differ from actual because we update the whole keyboard in TG but update only target button locally
 	// But maybe it's ok...
-	_, err = bot.API.Send(tg.EditMessageReplyMarkupConfig{
+	_, err = c.sendEdit(bot, tg.EditMessageReplyMarkupConfig{
 		BaseEdit: tg.BaseEdit{
 			ChatID:          om.ChatID,
 			MessageID:       om.MsgID,
@@ -839,10 +981,12 @@ func (c *Context) EditInlineStateButton(om *OutgoingMessage, kbState string, old
 	})
 	if err != nil {
 		// Oops. error is occurred – revert the original keyboard
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", &msg.InlineKeyboardMarkup, err)
 		err := c.db.C("messages").UpdateId(msg.ID, bson.M{"$set": bson.M{"inlinekeyboardmarkup": msg.InlineKeyboardMarkup}})
 		return err
 	}
 
+	c.recordMessageHistory(om, messageHistoryOpEditButton, "", &kb, nil)
 	return nil
 }
 
@@ -874,7 +1018,7 @@ func (c *Context) AnswerInlineQueryWithPM(text string, parameter string) error {
 }
 
 func (c *Context) AnswerCallbackQueryWithURL(url string) error {
-	bot := c.Bot()
+	bot := c.chatBot()
 	_, err := bot.API.AnswerCallbackQuery(tg.CallbackConfig{CallbackQueryID: c.Callback.ID, URL: url})
 	return err
 }
@@ -889,12 +1033,14 @@ func (c *Context) AnswerCallbackQuery(text string, showAlert bool) error {
 		return errors.New("Callback already answered")
 	}
 
-	bot := c.Bot()
+	bot := c.chatBot()
 
 	_, err := bot.API.AnswerCallbackQuery(tg.CallbackConfig{CallbackQueryID: c.Callback.ID, Text: text, ShowAlert: showAlert})
 	if err == nil {
 		n := time.Now()
 		c.Callback.AnsweredAt = &n
+		c.Callback.answerText = text
+		c.Callback.answerAlert = showAlert
 	}
 	return err
 }
@@ -911,16 +1057,33 @@ func (c *Context) NewMessage() *OutgoingMessage {
 	} else {
 		msg.ChatID = c.User.ID
 	}
+	if c.webhook != nil {
+		msg.Provenance = c.webhook.provenance()
+	}
 	msg.ctx = c
 	return msg
 }
 
 // SendAction send the one of "typing", "upload_photo", "record_video", "upload_video", "record_audio", "upload_audio", "upload_document", "find_location"
 func (c *Context) SendAction(s string) error {
-	_, err := c.Bot().API.Send(tg.NewChatAction(c.Chat.ID, s))
+	_, err := c.chatBot().API.Send(tg.NewChatAction(c.Chat.ID, s))
 	return err
 }
 
+// IsChatAdmin checks if the user is the creator or administrator of the current chat. Always true for the private chat
+func (c *Context) IsChatAdmin() (bool, error) {
+	if c.Chat.IsPrivate() {
+		return c.Chat.ID == c.User.ID, nil
+	}
+
+	member, err := c.GetChatMember(c.Chat.ID, c.User.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
 // DownloadURL downloads the remote URL and returns the local file path
 func (c *Context) DownloadURL(url string) (filePath string, err error) {
 
@@ -940,14 +1103,15 @@ func (c *Context) DownloadURL(url string) (filePath string, err error) {
 	}
 	defer out.Close()
 
-	resp, err := http.Get(url)
+	resp, err := attachmentHTTPClient.Get(url)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return "", errors.New("non 2xx resp status")
 	}
-	defer resp.Body.Close()
 
 	_, err = io.Copy(out, resp.Body)
 	if err != nil {
@@ -957,34 +1121,49 @@ func (c *Context) DownloadURL(url string) (filePath string, err error) {
 	return out.Name(), nil
 }
 
+// bufferBody reads the request's body once. RAW, JSON, XML and Form are served from the buffer in any order
+func (wc *WebhookContext) bufferBody() ([]byte, error) {
+	if wc.body != nil {
+		return wc.body, nil
+	}
+
+	wc.firstParse = true
+	if wc.gin.Request.Body == nil {
+		wc.body = []byte{}
+		return wc.body, nil
+	}
+
+	body, err := ioutil.ReadAll(wc.gin.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		body = []byte{}
+	}
+	wc.body = body
+	return wc.body, nil
+}
+
 // RAW returns request's body
 func (wc *WebhookContext) RAW() (*[]byte, error) {
-	var err error
-	if wc.body == nil {
-		wc.firstParse = true
-		wc.body, err = ioutil.ReadAll(wc.gin.Request.Body)
-		if err != nil {
-			return nil, err
-		}
+	_, err := wc.bufferBody()
+	if err != nil {
+		return nil, err
 	}
 	return &wc.body, nil
 }
 
 // JSON decodes the JSON in the request's body to the out interface
 func (wc *WebhookContext) JSON(out interface{}) error {
-	var err error
-	if wc.body == nil {
-		wc.firstParse = true
-		wc.body, err = ioutil.ReadAll(wc.gin.Request.Body)
-
-		if err != nil {
-			return err
-		}
+	body, err := wc.bufferBody()
+	if err != nil {
+		return err
 	}
-	err = json.Unmarshal(wc.body, out)
+	err = json.Unmarshal(body, out)
 
-	if err != nil && strings.HasPrefix(string(wc.body), "payload=") {
-		s := string(wc.body)
+	if err != nil && strings.HasPrefix(string(body), "payload=") {
+		s := string(body)
 		s, err = uurl.QueryUnescape(s[8:])
 		if err != nil {
 			return err
@@ -995,16 +1174,60 @@ func (wc *WebhookContext) JSON(out interface{}) error {
 	return err
 }
 
+// XML decodes the XML in the request's body to the out interface
+func (wc *WebhookContext) XML(out interface{}) error {
+	body, err := wc.bufferBody()
+	if err != nil {
+		return err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = xmlCharsetReader
+	return d.Decode(out)
+}
+
+// xmlCharsetReader converts the Latin-1 XML sent by the older servers, e.g. encoding="ISO-8859-1", to UTF-8
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "iso8859-1", "latin1", "us-ascii":
+		b, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("XML: unsupported charset %q", charset)
+}
+
+// parseForm parses the form from the buffered body, so RAW() is still available afterwards
+func (wc *WebhookContext) parseForm() error {
+	if wc.gin.Request.PostForm != nil {
+		return nil
+	}
+
+	body, err := wc.bufferBody()
+	if err != nil {
+		return err
+	}
+
+	wc.gin.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return wc.gin.Request.ParseForm()
+}
+
 // Form decodes the POST form in the request's body to the out interface
 func (wc *WebhookContext) Form() uurl.Values {
-	//todo: bug, RAW() unavailable after ParseForm()
-	wc.gin.Request.ParseForm()
+	wc.parseForm()
 	return wc.gin.Request.PostForm
 }
 
 // FormValue return form data with specific key
 func (wc *WebhookContext) FormValue(key string) string {
-	err := wc.gin.Request.ParseForm()
+	err := wc.parseForm()
 	if err != nil {
 		log.Error(err)
 	}
@@ -1013,7 +1236,7 @@ func (wc *WebhookContext) FormValue(key string) string {
 
 // FormValue return form data with specific key
 func (wc *WebhookContext) QueryValue(key string) string {
-	err := wc.gin.Request.ParseForm()
+	err := wc.parseForm()
 	if err != nil {
 		log.Error(err)
 	}
@@ -1021,6 +1244,16 @@ func (wc *WebhookContext) QueryValue(key string) string {
 	return wc.gin.Request.Form.Get(key)
 }
 
+// Query returns the URL's query parameter, e.g. the verification token. Unlike QueryValue the body isn't parsed
+func (wc *WebhookContext) Query(key string) string {
+	return wc.gin.Request.URL.Query().Get(key)
+}
+
+// QueryParams returns all the URL's query parameters
+func (wc *WebhookContext) QueryParams() uurl.Values {
+	return wc.gin.Request.URL.Query()
+}
+
 // HookID returns the HookID from the URL
 func (wc *WebhookContext) HookID() string {
 	return wc.gin.Param("param")
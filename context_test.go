@@ -1612,6 +1612,44 @@ func TestWebhookContext_JSON(t *testing.T) {
 	}
 }
 
+func TestWebhookContext_XML(t *testing.T) {
+	type issue struct {
+		Key     string `xml:"key,attr"`
+		Summary string `xml:"summary"`
+	}
+
+	r1, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc", bytes.NewReader([]byte(`<issue key="PRJ-1"><summary>Fix it</summary></issue>`)))
+	r2, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc", bytes.NewReader([]byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><issue key=\"PRJ-2\"><summary>Caf\xe9</summary></issue>")))
+	r3, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc", bytes.NewReader([]byte(`<issue key="PRJ-3"><summary>`)))
+	r4, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc", bytes.NewReader([]byte(`<?xml version="1.0" encoding="KOI8-R"?><issue key="PRJ-4"/>`)))
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		want    issue
+		wantErr bool
+	}{
+		{"good xml", r1, issue{Key: "PRJ-1", Summary: "Fix it"}, false},
+		{"latin1", r2, issue{Key: "PRJ-2", Summary: "Café"}, false},
+		{"bad xml", r3, issue{}, true},
+		{"unsupported charset", r4, issue{}, true},
+	}
+	for _, tt := range tests {
+		wc := &WebhookContext{gin: &gin.Context{Request: tt.r}}
+		got := issue{}
+		if err := wc.XML(&got); (err != nil) != tt.wantErr {
+			t.Errorf("%q. WebhookContext.XML() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%q. WebhookContext.XML() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if raw, err := wc.RAW(); err != nil || len(*raw) == 0 {
+			t.Errorf("%q. WebhookContext.RAW() after XML() = %v, %v, want the body", tt.name, raw, err)
+		}
+	}
+}
+
 func TestWebhookContext_Form(t *testing.T) {
 	type fields struct {
 		gin        *gin.Context
@@ -1648,6 +1686,55 @@ func TestWebhookContext_Form(t *testing.T) {
 	}
 }
 
+func TestWebhookContext_bufferBody(t *testing.T) {
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc?mode=test", bytes.NewReader([]byte("payload=%7B%22status%22%3A%22ok%22%7D")))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	want := []byte("payload=%7B%22status%22%3A%22ok%22%7D")
+
+	tests := []struct {
+		name  string
+		calls []string
+	}{
+		{"form first", []string{"form", "raw", "json"}},
+		{"raw first", []string{"raw", "form", "json"}},
+		{"json first", []string{"json", "query", "raw", "form"}},
+	}
+	for _, tt := range tests {
+		wc := &WebhookContext{gin: &gin.Context{Request: newRequest()}}
+		for _, call := range tt.calls {
+			switch call {
+			case "form":
+				if got := wc.FormValue("payload"); got != `{"status":"ok"}` {
+					t.Errorf("%q. WebhookContext.FormValue() = %q", tt.name, got)
+				}
+			case "query":
+				if got := wc.QueryValue("mode"); got != "test" {
+					t.Errorf("%q. WebhookContext.QueryValue() = %q, want test", tt.name, got)
+				}
+			case "raw":
+				got, err := wc.RAW()
+				if err != nil || !reflect.DeepEqual(*got, want) {
+					t.Errorf("%q. WebhookContext.RAW() = %v, %v, want %s", tt.name, got, err, want)
+				}
+			case "json":
+				out := struct{ Status string }{}
+				if err := wc.JSON(&out); err != nil || out.Status != "ok" {
+					t.Errorf("%q. WebhookContext.JSON() = %+v, %v", tt.name, out, err)
+				}
+			}
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "https://integram.org/uGs32432novfdc", nil)
+	wc := &WebhookContext{gin: &gin.Context{Request: r}}
+	if got, err := wc.RAW(); err != nil || got == nil || len(*got) != 0 {
+		t.Errorf("WebhookContext.RAW() without the body = %v, %v, want empty", got, err)
+	}
+}
+
 func TestWebhookContext_FormValue(t *testing.T) {
 	type fields struct {
 		gin        *gin.Context
@@ -1687,6 +1774,42 @@ func TestWebhookContext_FormValue(t *testing.T) {
 	}
 }
 
+func TestWebhookContext_Query(t *testing.T) {
+	r1, _ := http.NewRequest("POST", "https://integram.org/uGs32432novfdc?hub.mode=subscribe&hub.challenge=1158201444&tag=a&tag=b", bytes.NewReader([]byte("hub.mode=body")))
+	r1.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r2, _ := http.NewRequest("GET", "https://integram.org/uGs32432novfdc", nil)
+
+	tests := []struct {
+		name       string
+		r          *http.Request
+		key        string
+		want       string
+		wantParams int
+	}{
+		{"query param", r1, "hub.challenge", "1158201444", 3},
+		{"body is ignored", r1, "hub.mode", "subscribe", 3},
+		{"missing param", r1, "token", "", 3},
+		{"no query", r2, "hub.mode", "", 0},
+	}
+	for _, tt := range tests {
+		wc := &WebhookContext{gin: &gin.Context{Request: tt.r}}
+		if got := wc.Query(tt.key); got != tt.want {
+			t.Errorf("%q. WebhookContext.Query() = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := wc.QueryParams(); len(got) != tt.wantParams {
+			t.Errorf("%q. WebhookContext.QueryParams() = %v, want %d params", tt.name, got, tt.wantParams)
+		}
+	}
+
+	wc := &WebhookContext{gin: &gin.Context{Request: r1}}
+	if got := wc.QueryParams()["tag"]; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("WebhookContext.QueryParams() tag = %v, want [a b]", got)
+	}
+	if body, err := wc.RAW(); err != nil || string(*body) != "hub.mode=body" {
+		t.Errorf("WebhookContext.RAW() after Query() = %v, %v", body, err)
+	}
+}
+
 func TestWebhookContext_HookID(t *testing.T) {
 	type fields struct {
 		gin        *gin.Context
@@ -1742,3 +1865,22 @@ func TestWebhookContext_RequestID(t *testing.T) {
 		}
 	}
 }
+
+func Test_isTGErrorWith(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		text string
+		want bool
+	}{
+		{"48 hours window", tg.Error{Message: "Bad Request: message can't be deleted"}, "message can't be deleted", true},
+		{"already deleted", tg.Error{Message: "Bad Request: message to delete not found"}, "message can't be deleted", false},
+		{"not tg error", fmt.Errorf("message can't be deleted"), "message can't be deleted", false},
+		{"nil", nil, "message to delete not found", false},
+	}
+	for _, tt := range tests {
+		if got := isTGErrorWith(tt.err, tt.text); got != tt.want {
+			t.Errorf("%q. isTGErrorWith() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
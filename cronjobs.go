@@ -0,0 +1,376 @@
+package integram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to check the due cron jobs
+const cronJobsCheckInterval = time.Second * 15
+
+// cron job is claimed by the instance for this period and the lock is extended while the job is running, so the job is run again after the crash
+const cronJobLockTTL = time.Minute * 5
+
+// next run is searched within this period, e.g. "0 0 30 2 *" never runs
+const cronMaxLookahead = time.Hour * 24 * 366 * 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is the parsed 5-field cron expression. Each field is the bitmask of the allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// CronJob is run periodically by the cron spec on one of the instances, see Service.CronJobs
+type CronJob struct {
+	Name    string // unique within the service
+	Spec    string // standard 5-field cron expression in UTC, e.g. "0 9 * * *" for the daily digest at 9:00, or the macro like "@hourly"
+	Handler func(ctx *Context) error
+}
+
+// cronJob is the parsed CronJob
+type cronJob struct {
+	name     string
+	spec     string
+	schedule *cronSchedule
+	handler  func(ctx *Context) error
+}
+
+// cronJobState is stored in the "cron_jobs" collection to share the job's schedule and lock between the instances
+type cronJobState struct {
+	ID          string     `bson:"_id"` // service.name
+	Spec        string     `bson:"spec"`
+	Next        time.Time  `bson:"n"`
+	LockedUntil time.Time  `bson:"l,omitempty"`
+	LastRunAt   *time.Time `bson:"r,omitempty"`
+	LastError   string     `bson:"e,omitempty"`
+}
+
+func init() {
+	registerAdminCommand("cronjobs", adminCronJobsReport)
+}
+
+// parseCronField parses the field's list of values, ranges and steps, e.g. "*/15" or "1-5,10"
+func parseCronField(field string, min, max int) (mask uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i > -1 {
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// parseCronSpec parses the standard 5-field cron expression "minute hour day-of-month month day-of-week" or the macro like "@daily".
+// Day of week is 0-7, both 0 and 7 are Sunday
+func parseCronSpec(spec string) (*cronSchedule, error) {
+	if macro, exists := cronMacros[strings.TrimSpace(spec)]; exists {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q must have 5 fields", spec)
+	}
+
+	s := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+
+	fieldMasks := []struct {
+		mask     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+
+	for i, f := range fieldMasks {
+		mask, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %v", spec, err)
+		}
+		*f.mask = mask
+	}
+
+	// Sunday is 0 for time.Weekday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// dayMatches returns true if the day matches the day of month and the day of week. When both are restricted, either of them matches like in the standard cron
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatches := s.dom&(1<<uint(t.Day())) != 0
+	dowMatches := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatches && dowMatches
+	}
+	return domMatches || dowMatches
+}
+
+// next returns the first time matching the schedule after t or the zero time if there is none within cronMaxLookahead
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronMaxLookahead)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+	return time.Time{}
+}
+
+// RegisterJob registers the handler to run periodically by the cron spec, e.g. "0 9 * * *" for the daily digest at 9:00 UTC or "@hourly".
+// Only one instance runs the job at the time. Runs missed while the service was down are merged into one.
+// Must be called on the service returned by Servicer.Service before it's registered, otherwise use the CronJobs field
+func (s *Service) RegisterJob(name string, spec string, handler func(ctx *Context) error) error {
+	if registered, exists := services[s.Name]; exists && registered == s {
+		return fmt.Errorf("cron job %s must be added before the service is registered, use Service.CronJobs", name)
+	}
+
+	if handler == nil {
+		return fmt.Errorf("cron job %s has no handler", name)
+	}
+
+	schedule, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range s.cronJobs {
+		if job.name == name {
+			return fmt.Errorf("cron job %s is already registered", name)
+		}
+	}
+
+	s.cronJobs = append(s.cronJobs, cronJob{name: name, spec: spec, schedule: schedule, handler: handler})
+	return nil
+}
+
+// cronJobID returns the ID of the job's state
+func cronJobID(service string, name string) string {
+	return service + "." + name
+}
+
+// ensureCronJobs stores the schedule of the service's jobs. Next run is reset when the job's spec is changed
+func ensureCronJobs(db *mgo.Database, s *Service) {
+	now := time.Now().UTC()
+	for _, job := range s.cronJobs {
+		id := cronJobID(s.Name, job.name)
+
+		_, err := db.C("cron_jobs").Upsert(bson.M{"_id": id, "spec": bson.M{"$ne": job.spec}}, bson.M{"$set": bson.M{"spec": job.spec, "n": job.schedule.next(now)}})
+		if mgo.IsDup(err) {
+			// already stored with the same spec
+			continue
+		} else if err != nil {
+			s.Log().WithError(err).WithField("job", job.name).Error("Can't store the cron job")
+		}
+	}
+}
+
+// claimCronJob locks the service's due job. Returns mgo.ErrNotFound if the job isn't due or is locked by another instance
+func claimCronJob(db *mgo.Database, id string, now time.Time) (*cronJobState, error) {
+	var state cronJobState
+	_, err := db.C("cron_jobs").Find(bson.M{
+		"_id": id,
+		"n":   bson.M{"$lte": now, "$gt": time.Time{}},
+		"$or": []bson.M{
+			{"l": bson.M{"$exists": false}},
+			{"l": bson.M{"$lt": now}},
+		},
+	}).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"l": now.Add(cronJobLockTTL)}}, ReturnNew: true}, &state)
+
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// runCronJob runs the claimed job, extends its lock while it's running and schedules the next run
+func (s *Service) runCronJob(job cronJob) {
+	session := mongoSession.Clone()
+	defer session.Close()
+	db := session.DB(mongo.Database)
+
+	id := cronJobID(s.Name, job.name)
+	done := make(chan struct{})
+
+	// the job's session is closed as soon as the job is finished
+	go func(session *mgo.Session) {
+		defer session.Close()
+
+		ticker := time.NewTicker(cronJobLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := session.DB(mongo.Database).C("cron_jobs").UpdateId(id, bson.M{"$set": bson.M{"l": time.Now().Add(cronJobLockTTL)}})
+				if err != nil {
+					s.Log().WithError(err).WithField("job", job.name).Error("Can't extend the cron job's lock")
+				}
+			}
+		}
+	}(mongoSession.Clone())
+
+	startedAt := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+				log.Errorf("Panic recovery at cron job %s -> %s\n%s\n", id, r, stack(3))
+			}
+		}()
+
+		return job.handler(&Context{db: db, ServiceName: s.Name})
+	}()
+	close(done)
+
+	l := s.Log().WithField("job", job.name).WithField("duration", time.Since(startedAt).String())
+	update := bson.M{"$set": bson.M{"n": job.schedule.next(time.Now().UTC()), "r": startedAt}, "$unset": bson.M{"l": ""}}
+	if err != nil {
+		l.WithError(err).Error("Cron job failed")
+		update["$set"].(bson.M)["e"] = err.Error()
+	} else {
+		l.Info("Cron job finished")
+		update["$unset"].(bson.M)["e"] = ""
+	}
+
+	err = db.C("cron_jobs").UpdateId(id, update)
+	if err != nil {
+		s.Log().WithError(err).WithField("job", job.name).Error("Can't schedule the next run of the cron job")
+	}
+}
+
+// serviceCronScheduler runs the service's due jobs registered with RegisterJob
+func serviceCronScheduler(s *Service) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("service", s.Name).Errorf("serviceCronScheduler panic recovered %v", r)
+			serviceCronScheduler(s)
+		}
+	}()
+
+	session := mongoSession.Clone()
+	defer session.Close()
+	db := session.DB(mongo.Database)
+
+	ensureCronJobs(db, s)
+
+	for {
+		for _, job := range s.cronJobs {
+			_, err := claimCronJob(db, cronJobID(s.Name, job.name), time.Now())
+			if err == mgo.ErrNotFound {
+				continue
+			} else if err != nil {
+				s.Log().WithError(err).WithField("job", job.name).Error("serviceCronScheduler: can't claim the job")
+				continue
+			}
+
+			go s.runCronJob(job)
+		}
+
+		time.Sleep(cronJobsCheckInterval)
+	}
+}
+
+// adminCronJobsReport lists the cron jobs with their next and last runs
+func adminCronJobsReport(c *Context, args []string) (string, error) {
+	var states []cronJobState
+	err := c.db.C("cron_jobs").Find(nil).Sort("_id").All(&states)
+	if err != nil {
+		return "", err
+	}
+
+	if len(states) == 0 {
+		return "No cron jobs registered", nil
+	}
+
+	lines := []string{"Cron jobs:"}
+	for _, state := range states {
+		line := fmt.Sprintf("%s [%s] next: %s", state.ID, state.Spec, state.Next.UTC().Format("2006-01-02 15:04"))
+		if state.LastRunAt != nil {
+			line += ", last: " + state.LastRunAt.UTC().Format("2006-01-02 15:04")
+		}
+		if state.LockedUntil.After(time.Now()) {
+			line += ", running"
+		}
+		if state.LastError != "" {
+			line += ", error: " + state.LastError
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
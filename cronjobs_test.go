@@ -0,0 +1,118 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_cronSchedule_next(t *testing.T) {
+	// Tuesday
+	now := time.Date(2020, 3, 3, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 9 * * *", time.Date(2020, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 3, 3, 10, 45, 0, 0, time.UTC)},
+		{"31 10 * * *", time.Date(2020, 3, 3, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 3, 3, 11, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2020, 3, 4, 8, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2020, 3, 8, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week matches
+		{"0 0 15 * 5", time.Date(2020, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := parseCronSpec(tt.spec)
+			if err != nil {
+				t.Fatalf("parseCronSpec() error = %v", err)
+			}
+			if got := s.next(now); !got.Equal(tt.want) {
+				t.Errorf("next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseCronSpec_errors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := parseCronSpec(spec); err == nil {
+			t.Errorf("parseCronSpec(%q) error = nil, want error", spec)
+		}
+	}
+}
+
+func TestService_RegisterJob(t *testing.T) {
+	handler := func(ctx *Context) error { return nil }
+
+	s := &Service{Name: "cronjobstest"}
+	if err := s.RegisterJob("digest", "0 9 * * *", handler); err != nil {
+		t.Fatalf("RegisterJob() error = %v", err)
+	}
+	if err := s.RegisterJob("digest", "@hourly", handler); err == nil {
+		t.Errorf("RegisterJob() must refuse the duplicated name")
+	}
+	if err := s.RegisterJob("cleanup", "@often", handler); err == nil {
+		t.Errorf("RegisterJob() must refuse the wrong spec")
+	}
+	if err := s.RegisterJob("cleanup", "@daily", nil); err == nil {
+		t.Errorf("RegisterJob() must refuse the nil handler")
+	}
+
+	services[s.Name] = s
+	defer delete(services, s.Name)
+
+	if err := s.RegisterJob("cleanup", "@daily", handler); err == nil {
+		t.Errorf("RegisterJob() must refuse the job added after the service was registered")
+	}
+}
+
+func Test_claimCronJob(t *testing.T) {
+	id := cronJobID("cronjobstest", "digest")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	db.C("cron_jobs").RemoveId(id)
+	defer db.C("cron_jobs").RemoveId(id)
+
+	err := db.C("cron_jobs").Insert(cronJobState{ID: id, Spec: "@hourly", Next: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("can't insert the job: %v", err)
+	}
+
+	if _, err := claimCronJob(db, id, now); err != mgo.ErrNotFound {
+		t.Errorf("claimCronJob() before the next run error = %v, want mgo.ErrNotFound", err)
+	}
+
+	due := now.Add(time.Minute * 2)
+	state, err := claimCronJob(db, id, due)
+	if err != nil {
+		t.Fatalf("claimCronJob() error = %v", err)
+	}
+	if !state.LockedUntil.Equal(due.Add(cronJobLockTTL)) {
+		t.Errorf("claimCronJob() locked until %v, want %v", state.LockedUntil, due.Add(cronJobLockTTL))
+	}
+
+	if _, err := claimCronJob(db, id, due.Add(time.Minute)); err != mgo.ErrNotFound {
+		t.Errorf("claimCronJob() of the locked job error = %v, want mgo.ErrNotFound", err)
+	}
+
+	// lock of the crashed instance is expired
+	if _, err := claimCronJob(db, id, due.Add(cronJobLockTTL+time.Second)); err != nil {
+		t.Errorf("claimCronJob() after the lock expired error = %v", err)
+	}
+
+	err = db.C("cron_jobs").UpdateId(id, bson.M{"$set": bson.M{"n": time.Time{}}, "$unset": bson.M{"l": ""}})
+	if err != nil {
+		t.Fatalf("can't update the job: %v", err)
+	}
+	if _, err := claimCronJob(db, id, due.Add(cronJobLockTTL*2)); err != mgo.ErrNotFound {
+		t.Errorf("claimCronJob() of the job without the next run error = %v, want mgo.ErrNotFound", err)
+	}
+}
@@ -34,6 +34,14 @@ func ensureIndexes() {
 	db.C("messages").EnsureIndex(mgo.Index{Key: []string{"chatid", "botid", "msgid", "inlinemsgid"}, Unique: true})
 	db.C("messages").EnsureIndex(mgo.Index{Key: []string{"chatid", "botid", "fromid"}})
 	db.C("messages").EnsureIndex(mgo.Index{Key: []string{"chatid", "botid", "eventid"}}) //todo: test eventID uniqueness
+	db.C("messages").EnsureIndex(mgo.Index{Key: []string{"chatid", "pin.s"}, Sparse: true})
+	db.C("messages").EnsureIndex(mgo.Index{Key: []string{"botid", "mediagroupid"}, Sparse: true})
+
+	db.C("chat_handoffs").EnsureIndex(mgo.Index{Key: []string{"chatid", "to", "status"}})
+
+	db.C("webhook_keys").EnsureIndex(mgo.Index{Key: []string{"chatid", "service"}})
+
+	db.C("polls").EnsureIndex(mgo.Index{Key: []string{"chatid", "msgid", "botid"}})
 
 	db.C("previews").EnsureIndex(mgo.Index{Key: []string{"hash"}, Unique: true, Sparse: true})
 
@@ -43,6 +51,7 @@ func ensureIndexes() {
 	db.C("users").EnsureIndex(mgo.Index{Key: []string{"hooks.token"}, Unique: true, Sparse: true})
 	db.C("users").DropIndex("protected")
 	db.C("users").EnsureIndex(mgo.Index{Key: []string{"username"}}) // should be unique but what if users swap usernames... hm
+	db.C("users").EnsureIndex(mgo.Index{Key: []string{"usernamelower"}, Sparse: true})
 	db.C("users").EnsureIndex(mgo.Index{Key: []string{"keyboardperchat.chatid", "_id"}, Unique: true, Sparse: true})
 
 	db.C("users_cache").EnsureIndex(mgo.Index{Key: []string{"expiresat"}, ExpireAfter: time.Second})
@@ -60,6 +69,62 @@ func ensureIndexes() {
 	db.C("stats_unique").EnsureIndex(mgo.Index{Key: []string{"s", "k", "d", "p"}, Unique: true})
 	db.C("stats_unique").EnsureIndex(mgo.Index{Key: []string{"s", "k", "d", "p", "u"}, Unique: true})
 
+	db.C("callback_stats").EnsureIndex(mgo.Index{Key: []string{"s", "d", "a", "b"}, Unique: true})
+
+	db.C("sagas").EnsureIndex(mgo.Index{Key: []string{"updatedat"}, ExpireAfter: time.Hour * 24 * 30})
+	db.C("sagas").EnsureIndex(mgo.Index{Key: []string{"status", "updatedat"}})
+
+	db.C("bot_commands").EnsureIndex(mgo.Index{Key: []string{"b"}})
+
+	db.C("wizards").EnsureIndex(mgo.Index{Key: []string{"updatedat"}, ExpireAfter: wizardStateTTL})
+
+	db.C("deliveries").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: webhookDeliveriesTTL})
+	db.C("deliveries").EnsureIndex(mgo.Index{Key: []string{"t", "d"}})
+
+	db.C("users_snapshots").EnsureIndex(mgo.Index{Key: []string{"userid", "service", "key"}, Unique: true})
+	db.C("users_snapshots").EnsureIndex(mgo.Index{Key: []string{"userid", "service", "changedat"}})
+
+	db.C("messages_history").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: messageHistoryTTL})
+	db.C("messages_history").EnsureIndex(mgo.Index{Key: []string{"m"}})
+
+	db.C("chats").EnsureIndex(mgo.Index{Key: []string{"lasthumanat"}, Sparse: true})
+
+	db.C("messages_text").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: incomingTextTTL})
+
+	db.C("api_keys").EnsureIndex(mgo.Index{Key: []string{"s", "c"}})
+	db.C("api_keys").EnsureIndex(mgo.Index{Key: []string{"s", "id"}})
+	// rotated keys are removed after the grace period
+	db.C("api_keys").EnsureIndex(mgo.Index{Key: []string{"x"}, ExpireAfter: time.Second})
+
+	db.C("messages_translations").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: translationTTL})
+	db.C("messages_density").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: densityTTL})
+	// undos are removed by undoExpireJob, the index cleans up the ones which job failed
+	db.C("messages_undo").EnsureIndex(mgo.Index{Key: []string{"x"}, ExpireAfter: time.Hour})
+
+	db.C("files_quarantine").EnsureIndex(mgo.Index{Key: []string{"d"}})
+
+	db.C("callbacks_executions").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: callbackExecutionTTL})
+
+	db.C("oauth_broker_sessions").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: oauthBrokerSessionTTL})
+	db.C("oauth_broker_sessions").EnsureIndex(mgo.Index{Key: []string{"code"}, Sparse: true})
+
+	db.C("files_uploaded").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: uploadedFileTTL})
+
+	db.C("messages_scheduled").EnsureIndex(mgo.Index{Key: []string{"at"}})
+	db.C("messages_scheduled").EnsureIndex(mgo.Index{Key: []string{"s", "eid"}})
+
+	db.C("maintenance").EnsureIndex(mgo.Index{Key: []string{"from"}})
+	db.C("updates_queued").EnsureIndex(mgo.Index{Key: []string{"d"}, ExpireAfter: maintenanceQueuedUpdatesTTL})
+
+	db.C("webhooks_dedup").EnsureIndex(mgo.Index{Key: []string{"exp"}, ExpireAfter: time.Second})
+	db.C("webhooks_dead").EnsureIndex(mgo.Index{Key: []string{"f"}})
+
+	db.C("poll_targets").EnsureIndex(mgo.Index{Key: []string{"s", "k"}, Unique: true})
+	db.C("poll_targets").EnsureIndex(mgo.Index{Key: []string{"s", "n"}})
+	db.C("poll_targets").EnsureIndex(mgo.Index{Key: []string{"s", "c"}})
+
+	db.C("chats_snapshots").EnsureIndex(mgo.Index{Key: []string{"chatid", "service", "v"}, Unique: true})
+
 }
 
 func dbConnect() {
@@ -140,7 +205,7 @@ func (c *Context) FindChat(query interface{}) (chatData, error) {
 	chat := chatData{}
 	serviceID := c.getServiceID()
 
-	err := c.db.C("chats").Find(query).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "deactivated": 1, "hooks": 1}).One(&chat)
+	err := c.db.C("chats").Find(query).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "lang": 1, "deactivated": 1, "hibernatedat": 1, "hooks": 1}).One(&chat)
 	if err != nil {
 		//c.Log().WithError(err).WithField("query", query).Error("Can't find chat")
 		return chat, err
@@ -155,7 +220,7 @@ func (c *Context) FindChats(query interface{}) ([]chatData, error) {
 	chats := []chatData{}
 	serviceID := c.getServiceID()
 
-	err := c.db.C("chats").Find(query).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "deactivated": 1, "hooks": 1}).All(&chats)
+	err := c.db.C("chats").Find(query).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "lang": 1, "deactivated": 1, "hibernatedat": 1, "hooks": 1}).All(&chats)
 	if err != nil {
 		//c.Log().WithError(err).WithField("query", query).Error("Can't find chat")
 		return chats, err
@@ -172,7 +237,7 @@ func (c *Context) FindChatsLimit(query interface{}, limit int, sort ...string) (
 	chats := []chatData{}
 	serviceID := c.getServiceID()
 
-	err := c.db.C("chats").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "deactivated": 1, "hooks": 1}).All(&chats)
+	err := c.db.C("chats").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"type": 1, "firstname": 1, "lastname": 1, "username": 1, "title": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperbot": 1, "tz": 1, "lang": 1, "deactivated": 1, "hibernatedat": 1, "hooks": 1}).All(&chats)
 	if err != nil {
 		//c.Log().WithError(err).WithField("query", query).Error("Can't find chat")
 		return chats, err
@@ -190,9 +255,9 @@ func (c *Context) FindUser(query interface{}) (userData, error) {
 	serviceID := c.getServiceID()
 	var err error
 	if serviceID != "" {
-		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).One(&user) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).One(&user) // TODO: IS it ok to lean on c.Chat.ID here?
 	} else {
-		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).One(&user) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).One(&user) // TODO: IS it ok to lean on c.Chat.ID here?
 	}
 	user.ctx = c
 
@@ -208,9 +273,9 @@ func (c *Context) FindUsers(query interface{}) ([]userData, error) {
 	serviceID := c.getServiceID()
 	var err error
 	if serviceID != "" {
-		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
 	} else {
-		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
 	}
 
 	if err != nil {
@@ -229,9 +294,9 @@ func (c *Context) FindUsersLimit(query interface{}, limit int, sort ...string) (
 	serviceID := c.getServiceID()
 	var err error
 	if serviceID != "" {
-		err = c.db.C("users").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings." + serviceID: 1, "protected." + serviceID: 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
 	} else {
-		err = c.db.C("users").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
+		err = c.db.C("users").Find(query).Limit(limit).Sort(sort...).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "settings": 1, "protected": 1, "keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": c.Chat.ID}}, "tz": 1, "lang": 1, "hooks": 1}).All(&users) // TODO: IS it ok to lean on c.Chat.ID here?
 	}
 
 	if err != nil {
@@ -509,11 +574,106 @@ func (c *Context) UpdateServiceCache(key string, update interface{}, res interfa
 
 func (user *User) updateData() error {
 	_, err := user.ctx.db.C("users").UpsertId(user.ID, bson.M{"$set": user, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	if err == nil && user.UserName != "" {
+		err = user.ctx.db.C("users").UpdateId(user.ID, bson.M{"$set": bson.M{"usernamelower": strings.ToLower(user.UserName)}})
+	}
 	user.data.User = *user
 
 	return err
 }
 
+// changedFields returns the fields received from Telegram that differ from the stored ones. Empty fields are skipped because User may be created only with the ID
+func (user *User) changedFields(stored User) bson.M {
+	changed := bson.M{}
+	if user.FirstName != "" && user.FirstName != stored.FirstName {
+		changed["firstname"] = user.FirstName
+	}
+	if user.LastName != "" && user.LastName != stored.LastName {
+		changed["lastname"] = user.LastName
+	}
+	if user.UserName != "" && user.UserName != stored.UserName {
+		changed["username"] = user.UserName
+		// lowercased copy to find the user by the username case-insensitively, see resolveStoredUsername
+		changed["usernamelower"] = strings.ToLower(user.UserName)
+	}
+	return changed
+}
+
+// saveChangedFields updates only the changed fields. Nothing is written if the user's data is the same
+func (user *User) saveChangedFields() error {
+	changed := user.changedFields(user.data.User)
+	if len(changed) == 0 || user.ctx.readOnly {
+		return nil
+	}
+
+	err := user.ctx.db.C("users").UpdateId(user.ID, bson.M{"$set": changed})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range changed {
+		switch k {
+		case "firstname":
+			user.data.FirstName = v.(string)
+		case "lastname":
+			user.data.LastName = v.(string)
+		case "username":
+			user.data.UserName = v.(string)
+		}
+	}
+	return nil
+}
+
+// changedFields returns the fields received from Telegram that differ from the stored ones. Empty fields are skipped because Chat may be created only with the ID
+func (chat *Chat) changedFields(stored Chat) bson.M {
+	changed := bson.M{}
+	if chat.Type != "" && chat.Type != stored.Type {
+		changed["type"] = chat.Type
+	}
+	if chat.Title != "" && chat.Title != stored.Title {
+		changed["title"] = chat.Title
+	}
+	if chat.FirstName != "" && chat.FirstName != stored.FirstName {
+		changed["firstname"] = chat.FirstName
+	}
+	if chat.LastName != "" && chat.LastName != stored.LastName {
+		changed["lastname"] = chat.LastName
+	}
+	if chat.UserName != "" && chat.UserName != stored.UserName {
+		changed["username"] = chat.UserName
+	}
+	return changed
+}
+
+// saveChangedFields updates only the changed fields. Nothing is written if the chat's data is the same
+func (chat *Chat) saveChangedFields() error {
+	changed := chat.changedFields(chat.data.Chat)
+	if len(changed) == 0 || chat.ctx.readOnly {
+		return nil
+	}
+
+	err := chat.ctx.db.C("chats").UpdateId(chat.ID, bson.M{"$set": changed})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range changed {
+		switch k {
+		case "type":
+			chat.data.Type = v.(string)
+		case "title":
+			chat.data.Title = v.(string)
+		case "firstname":
+			chat.data.FirstName = v.(string)
+		case "lastname":
+			chat.data.LastName = v.(string)
+		case "username":
+			chat.data.UserName = v.(string)
+		}
+	}
+	return nil
+}
+
 func (chat *Chat) updateData() error {
 	_, err := chat.ctx.db.C("chats").UpsertId(chat.ID, bson.M{"$set": chat, "$setOnInsert": bson.M{"createdat": time.Now()}})
 	chat.data.Chat = *chat
@@ -529,12 +689,24 @@ func (chat *Chat) getData() (*chatData, error) {
 	if chat.data != nil {
 		return chat.data, nil
 	}
+
+	if wdata, exists := popWarmChat(chat.ID); exists {
+		chat.data = wdata
+		return chat.data, chat.saveChangedFields()
+	}
+
 	cdata, _ := chat.ctx.FindChat(bson.M{"_id": chat.ID})
 	chat.data = &cdata
 
+	if chat.ctx.readOnly {
+		return chat.data, nil
+	}
+
 	var err error
 	if cdata.Type == "" {
 		err = chat.updateData()
+	} else {
+		err = chat.saveChangedFields()
 	}
 
 	return chat.data, err
@@ -555,6 +727,78 @@ func (chat *Chat) BotWasKickedOrStopped() bool {
 	return false
 }
 
+// setData sets the stored data and the fields loaded from it. Lang of the current update is kept
+func (user *User) setData(data *userData) {
+	user.data = data
+	user.Tz = data.Tz
+	if user.Lang == "" {
+		user.Lang = data.Lang
+	}
+	user.Locale = data.Locale
+}
+
+// copy returns the copy of the data that can be changed concurrently with the original
+func (d *userData) copy() *userData {
+	if d == nil {
+		return nil
+	}
+
+	cp := *d
+	cp.KeyboardPerChat = append([]chatKeyboard(nil), d.KeyboardPerChat...)
+	cp.Hooks = append([]serviceHook(nil), d.Hooks...)
+	cp.Settings = copySettings(d.Settings)
+
+	if d.Protected != nil {
+		cp.Protected = make(map[string]*userProtected, len(d.Protected))
+		for serviceID, ps := range d.Protected {
+			if ps != nil {
+				psCopy := *ps
+				ps = &psCopy
+			}
+			cp.Protected[serviceID] = ps
+		}
+	}
+	return &cp
+}
+
+// copy returns the copy of the data that can be changed concurrently with the original
+func (d *chatData) copy() *chatData {
+	if d == nil {
+		return nil
+	}
+
+	cp := *d
+	cp.KeyboardPerBot = append([]chatKeyboard(nil), d.KeyboardPerBot...)
+	cp.Hooks = append([]serviceHook(nil), d.Hooks...)
+	cp.MembersIDs = append([]int64(nil), d.MembersIDs...)
+	cp.Settings = copySettings(d.Settings)
+
+	if d.Protected != nil {
+		cp.Protected = make(map[string]*chatProtected, len(d.Protected))
+		for serviceID, ps := range d.Protected {
+			if ps != nil {
+				psCopy := *ps
+				ps = &psCopy
+			}
+			cp.Protected[serviceID] = ps
+		}
+	}
+	return &cp
+}
+
+// copySettings copies the map of services settings. Settings are replaced as a whole on save so the values are shared
+func copySettings(settings map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		return nil
+	}
+
+	cp := make(map[string]interface{}, len(settings))
+	for serviceID, v := range settings {
+		cp[serviceID] = v
+	}
+	return cp
+}
+
 func (user *User) getData() (*userData, error) {
 
 	if user.ID == 0 {
@@ -567,13 +811,22 @@ func (user *User) getData() (*userData, error) {
 		panic("nil user context")
 	}
 
+	if wdata, exists := popWarmUser(user.ID); exists {
+		user.setData(wdata)
+		return user.data, user.saveChangedFields()
+	}
+
 	udata, err := user.ctx.FindUser(bson.M{"_id": user.ID})
+	user.setData(&udata)
 
-	user.data = &udata
-	user.Tz = user.data.Tz
+	if user.ctx.readOnly {
+		return user.data, err
+	}
 
 	if user.data.FirstName == "" {
 		err = user.updateData()
+	} else {
+		err = user.saveChangedFields()
 	}
 
 	return user.data, err
@@ -1775,3 +1775,68 @@ func TestContext_WebPreview(t *testing.T) {
 	}
 	db.C("previews").RemoveAll(bson.M{"headline": "testHeadline"})
 }
+
+func TestUser_getData_dirtyTracking(t *testing.T) {
+	clearData()
+	db.C("users").Insert(bson.M{"_id": 9999999999, "firstname": "Old", "username": "old_username"})
+
+	ctx := &Context{ServiceName: "servicewithbottoken", db: db, readOnly: true}
+	ctx.User = User{ID: 9999999999, FirstName: "New", UserName: "old_username", ctx: ctx}
+
+	ctx.User.getData()
+	var ud userData
+	db.C("users").FindId(int64(9999999999)).One(&ud)
+	if ud.FirstName != "Old" {
+		t.Errorf("User.getData() updated the user in read-only context: %+v", ud.User)
+	}
+
+	ctx = &Context{ServiceName: "servicewithbottoken", db: db}
+	// LastName is not received, so it's not changed
+	ctx.User = User{ID: 9999999999, FirstName: "New", UserName: "old_username", ctx: ctx}
+	if changed := ctx.User.changedFields(ud.User); !reflect.DeepEqual(changed, bson.M{"firstname": "New"}) {
+		t.Errorf("User.changedFields() = %v, want only firstname", changed)
+	}
+
+	data, err := ctx.User.getData()
+	if err != nil {
+		t.Fatalf("User.getData() error = %v", err)
+	}
+
+	db.C("users").FindId(int64(9999999999)).One(&ud)
+	if ud.FirstName != "New" || data.FirstName != "New" || ud.UserName != "old_username" {
+		t.Errorf("User.getData() stored %+v, cached %+v", ud.User, data.User)
+	}
+
+	if changed := ctx.User.changedFields(ud.User); len(changed) > 0 {
+		t.Errorf("User.changedFields() for the same user = %v", changed)
+	}
+	clearData()
+}
+
+func TestUser_getData_warmUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		lang     string
+		wantLang string
+	}{
+		{"stored lang", "", "de"},
+		{"update's lang", "en", "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warmCacheMutex.Lock()
+			warmUsers[9999999999] = warmUser{data: userData{User: User{ID: 9999999999, Lang: "de", Tz: "Europe/Berlin"}}, expiresAt: time.Now().Add(time.Minute)}
+			warmCacheMutex.Unlock()
+
+			ctx := &Context{ServiceName: "servicewithbottoken", db: db, readOnly: true}
+			ctx.User = User{ID: 9999999999, Lang: tt.lang, ctx: ctx}
+
+			if _, err := ctx.User.getData(); err != nil {
+				t.Fatalf("User.getData() error = %v", err)
+			}
+			if ctx.User.Lang != tt.wantLang || ctx.User.Tz != "Europe/Berlin" {
+				t.Errorf("User.getData() Lang = %q, Tz = %q, want %q, %q", ctx.User.Lang, ctx.User.Tz, tt.wantLang, "Europe/Berlin")
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// repairs run for this period every dataRepairInterval, so the database isn't loaded by the long scans
+const (
+	dataRepairSlice    = time.Millisecond * 200
+	dataRepairInterval = time.Second * 5
+	dataRepairBatch    = 100
+)
+
+// instance holds the repair for this period, so the same repair isn't run by several instances
+const dataRepairLease = time.Minute
+
+// dataRepair fixes one kind of the inconsistent data in batches. step processes the batch after the cursor and returns the next cursor, empty when done
+type dataRepair struct {
+	name        string
+	description string
+	step        func(db *mgo.Database, cursor string, limit int) (next string, checked int, fixed int, err error)
+}
+
+// dataRepairProgress is stored in the "data_repairs" collection to continue the repair after the restart
+type dataRepairProgress struct {
+	ID          string     `bson:"_id"` // repair's name
+	Cursor      string     `bson:"c,omitempty"`
+	Checked     int        `bson:"n"`
+	Fixed       int        `bson:"f"`
+	StartedBy   int64      `bson:"by,omitempty"` // admin notified when the repair is finished
+	ServiceName string     `bson:"s,omitempty"`  // service which bot is used to notify the admin
+	StartedAt   time.Time  `bson:"sa"`
+	UpdatedAt   time.Time  `bson:"ua"`
+	FinishedAt  *time.Time `bson:"fa,omitempty"`
+	LeasedUntil time.Time  `bson:"l"`
+	Error       string     `bson:"err,omitempty"`
+}
+
+var dataRepairs = []dataRepair{
+	{"keyboard_nulls", "users' keyboardperchat arrays with the null elements left by unsetting the keyboard by the chat ID as the index", repairKeyboardNulls},
+	{"orphaned_keyboards", "users' keyboards in the removed or deactivated chats", repairOrphanedKeyboards},
+	{"orphaned_messages", "messages of the deactivated or migrated chats and the chats the bot was kicked from", repairOrphanedMessages},
+}
+
+func init() {
+	registerAdminCommand("repair", adminDataRepair)
+}
+
+// dataRepairByName returns the repair or nil
+func dataRepairByName(name string) *dataRepair {
+	for i := range dataRepairs {
+		if dataRepairs[i].name == name {
+			return &dataRepairs[i]
+		}
+	}
+	return nil
+}
+
+// String returns the repair's progress
+func (p dataRepairProgress) String() string {
+	s := fmt.Sprintf("%s: %d checked, %d fixed, ", p.ID, p.Checked, p.Fixed)
+	switch {
+	case p.FinishedAt == nil:
+		s += "running since " + p.StartedAt.UTC().Format("2006-01-02 15:04")
+	case p.Error != "":
+		s += "stopped: " + p.Error
+	default:
+		s += fmt.Sprintf("finished in %s", p.FinishedAt.Sub(p.StartedAt).Round(time.Second))
+	}
+	return s
+}
+
+// repairKeyboardNulls removes the null elements from the users' keyboardperchat arrays
+func repairKeyboardNulls(db *mgo.Database, cursor string, limit int) (string, int, int, error) {
+	query := bson.M{"keyboardperchat": bson.M{"$type": 10}}
+	if cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		query["_id"] = bson.M{"$gt": id}
+	}
+
+	var users []struct {
+		ID int64 `bson:"_id"`
+	}
+	err := db.C("users").Find(query).Sort("_id").Limit(limit).Select(bson.M{"_id": 1}).All(&users)
+	if err != nil || len(users) == 0 {
+		return "", 0, 0, err
+	}
+
+	var ids []int64
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	info, err := db.C("users").UpdateAll(bson.M{"_id": bson.M{"$in": ids}}, bson.M{"$pull": bson.M{"keyboardperchat": nil}})
+	if err != nil {
+		return "", len(users), 0, err
+	}
+
+	return strconv.FormatInt(ids[len(ids)-1], 10), len(users), info.Updated, nil
+}
+
+// aliveChats returns the chats of the IDs which exist and are not deactivated. Private chats are alive while the user exists
+func aliveChats(db *mgo.Database, chatIDs []int64) (map[int64]bool, error) {
+	alive := make(map[int64]bool)
+	if len(chatIDs) == 0 {
+		return alive, nil
+	}
+
+	var chats []struct {
+		ID int64 `bson:"_id"`
+	}
+	err := db.C("chats").Find(bson.M{"_id": bson.M{"$in": chatIDs}, "deactivated": bson.M{"$ne": true}}).Select(bson.M{"_id": 1}).All(&chats)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []int64
+	for _, c := range chats {
+		alive[c.ID] = true
+	}
+
+	for _, id := range chatIDs {
+		if id > 0 && !alive[id] {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	if len(userIDs) > 0 {
+		err = db.C("users").Find(bson.M{"_id": bson.M{"$in": userIDs}}).Select(bson.M{"_id": 1}).All(&chats)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chats {
+			alive[c.ID] = true
+		}
+	}
+
+	return alive, nil
+}
+
+// repairOrphanedKeyboards removes the users' keyboards in the chats that were removed or deactivated
+func repairOrphanedKeyboards(db *mgo.Database, cursor string, limit int) (string, int, int, error) {
+	query := bson.M{"keyboardperchat.chatid": bson.M{"$exists": true}}
+	if cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		query["_id"] = bson.M{"$gt": id}
+	}
+
+	var users []struct {
+		ID              int64 `bson:"_id"`
+		KeyboardPerChat []struct {
+			ChatID int64
+		}
+	}
+	err := db.C("users").Find(query).Sort("_id").Limit(limit).Select(bson.M{"keyboardperchat.chatid": 1}).All(&users)
+	if err != nil || len(users) == 0 {
+		return "", 0, 0, err
+	}
+
+	var chatIDs []int64
+	for _, u := range users {
+		for _, kb := range u.KeyboardPerChat {
+			chatIDs = append(chatIDs, kb.ChatID)
+		}
+	}
+
+	alive, err := aliveChats(db, chatIDs)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	fixed := 0
+	for _, u := range users {
+		var orphaned []int64
+		for _, kb := range u.KeyboardPerChat {
+			if !alive[kb.ChatID] {
+				orphaned = append(orphaned, kb.ChatID)
+			}
+		}
+
+		if len(orphaned) == 0 {
+			continue
+		}
+
+		err := db.C("users").UpdateId(u.ID, bson.M{"$pull": bson.M{"keyboardperchat": bson.M{"chatid": bson.M{"$in": orphaned}}}})
+		if err != nil && err != mgo.ErrNotFound {
+			return "", len(users), fixed, err
+		}
+		fixed++
+	}
+
+	return strconv.FormatInt(users[len(users)-1].ID, 10), len(users), fixed, nil
+}
+
+// repairOrphanedMessages removes the stored messages of the chats known to be removed: deactivated, migrated to the supergroup or the message's bot was kicked.
+// Chats without the stored record are kept, as the records are created lazily. Inline messages have no chat and are kept
+func repairOrphanedMessages(db *mgo.Database, cursor string, limit int) (string, int, int, error) {
+	query := bson.M{}
+	if cursor != "" {
+		if !bson.IsObjectIdHex(cursor) {
+			return "", 0, 0, errors.New("wrong cursor")
+		}
+		query["_id"] = bson.M{"$gt": bson.ObjectIdHex(cursor)}
+	}
+
+	var messages []struct {
+		ID     bson.ObjectId `bson:"_id"`
+		ChatID int64
+		BotID  int64
+	}
+	err := db.C("messages").Find(query).Sort("_id").Limit(limit).Select(bson.M{"chatid": 1, "botid": 1}).All(&messages)
+	if err != nil || len(messages) == 0 {
+		return "", 0, 0, err
+	}
+
+	var chatIDs []int64
+	seen := make(map[int64]bool)
+	for _, m := range messages {
+		if m.ChatID != 0 && !seen[m.ChatID] {
+			seen[m.ChatID] = true
+			chatIDs = append(chatIDs, m.ChatID)
+		}
+	}
+
+	var chats []removedChat
+	err = db.C("chats").Find(bson.M{"_id": bson.M{"$in": chatIDs}}).Select(bson.M{"deactivated": 1, "migratedtochatid": 1, "protected": 1}).All(&chats)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	chatByID := make(map[int64]removedChat)
+	for _, c := range chats {
+		chatByID[c.ID] = c
+	}
+
+	var orphaned []bson.ObjectId
+	for _, m := range messages {
+		if c, exists := chatByID[m.ChatID]; exists && c.isRemovedForBot(m.BotID) {
+			orphaned = append(orphaned, m.ID)
+		}
+	}
+
+	fixed := 0
+	if len(orphaned) > 0 {
+		info, err := db.C("messages").RemoveAll(bson.M{"_id": bson.M{"$in": orphaned}})
+		if err != nil {
+			return "", len(messages), 0, err
+		}
+		fixed = info.Removed
+	}
+
+	return messages[len(messages)-1].ID.Hex(), len(messages), fixed, nil
+}
+
+// removedChat is the part of the chat's record telling if it was removed
+type removedChat struct {
+	ID               int64 `bson:"_id"`
+	Deactivated      bool
+	MigratedToChatID int64
+	Protected        map[string]*chatProtected
+}
+
+// isRemovedForBot returns true if the chat is deactivated, migrated or the bot was kicked from it by all its services
+func (c removedChat) isRemovedForBot(botID int64) bool {
+	if c.Deactivated || c.MigratedToChatID != 0 {
+		return true
+	}
+
+	bot := botByID(botID)
+	if bot == nil || len(bot.services) == 0 {
+		return false
+	}
+
+	for _, s := range bot.services {
+		if ps := c.Protected[s.Name]; ps == nil || ps.BotStoppedOrKickedAt == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// runDataRepairSlice processes the repair's batches until the slice ends. Progress is updated in place
+func runDataRepairSlice(db *mgo.Database, r *dataRepair, p *dataRepairProgress, slice time.Duration) {
+	deadline := time.Now().Add(slice)
+	for time.Now().Before(deadline) {
+		next, checked, fixed, err := r.step(db, p.Cursor, dataRepairBatch)
+		p.Checked += checked
+		p.Fixed += fixed
+		p.UpdatedAt = time.Now()
+
+		if err != nil || next == "" {
+			finishedAt := time.Now()
+			p.FinishedAt = &finishedAt
+			if err != nil {
+				p.Error = err.Error()
+			}
+			return
+		}
+
+		p.Cursor = next
+	}
+}
+
+// claimDataRepair leases the next running repair to this instance
+func claimDataRepair(db *mgo.Database, now time.Time) (*dataRepairProgress, error) {
+	var p dataRepairProgress
+	_, err := db.C("data_repairs").Find(bson.M{"fa": bson.M{"$exists": false}, "l": bson.M{"$lt": now}}).Sort("l").
+		Apply(mgo.Change{Update: bson.M{"$set": bson.M{"l": now.Add(dataRepairLease)}}, ReturnNew: true}, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// notifyDataRepairFinished sends the report to the admin started the repair
+func notifyDataRepairFinished(db *mgo.Database, p *dataRepairProgress) {
+	if p.StartedBy == 0 || p.ServiceName == "" {
+		return
+	}
+
+	ctx := &Context{db: db, ServiceName: p.ServiceName}
+	ctx.Chat = Chat{ID: p.StartedBy, ctx: ctx}
+
+	err := ctx.NewMessage().SetText(HTMLRichText{}.Pre("Repair " + p.String())).EnableHTML().Send()
+	if err != nil {
+		log.WithError(err).WithField("repair", p.ID).Error("Can't notify the admin about the finished repair")
+	}
+}
+
+// dataRepairsRunner runs the started repairs in the time slices
+func dataRepairsRunner() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("dataRepairsRunner panic recovered %v", r)
+			dataRepairsRunner()
+		}
+	}()
+
+	if Config.IsStandAloneServiceInstance() {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		time.Sleep(dataRepairInterval)
+
+		p, err := claimDataRepair(db, time.Now())
+		if err == mgo.ErrNotFound {
+			continue
+		} else if err != nil {
+			log.WithError(err).Error("dataRepairsRunner: can't get the running repair")
+			continue
+		}
+
+		r := dataRepairByName(p.ID)
+		if r == nil {
+			continue
+		}
+
+		runDataRepairSlice(db, r, p, dataRepairSlice)
+
+		update := bson.M{"c": p.Cursor, "n": p.Checked, "f": p.Fixed, "ua": p.UpdatedAt, "l": time.Now()}
+		if p.FinishedAt != nil {
+			update["fa"] = p.FinishedAt
+			update["err"] = p.Error
+		}
+
+		// the repair may be restarted or cancelled by the admin meanwhile
+		err = db.C("data_repairs").Update(bson.M{"_id": p.ID, "sa": p.StartedAt, "fa": bson.M{"$exists": false}}, bson.M{"$set": update})
+		if err == mgo.ErrNotFound {
+			continue
+		} else if err != nil {
+			log.WithError(err).WithField("repair", p.ID).Error("dataRepairsRunner: can't save the progress")
+			continue
+		}
+
+		if p.FinishedAt != nil {
+			log.WithField("repair", p.ID).Infof("Data repair finished: %s", p.String())
+			notifyDataRepairFinished(db, p)
+		}
+	}
+}
+
+// adminDataRepair process 'repair [status | name | all | cancel name]'
+func adminDataRepair(c *Context, args []string) (string, error) {
+	if len(args) == 0 || args[0] == "status" {
+		var progress []dataRepairProgress
+		err := c.db.C("data_repairs").Find(nil).Sort("_id").All(&progress)
+		if err != nil {
+			return "", err
+		}
+
+		lines := []string{"Usage: repair [status | name | all | cancel name]"}
+		for _, r := range dataRepairs {
+			lines = append(lines, fmt.Sprintf("%s – %s", r.name, r.description))
+		}
+
+		lines = append(lines, "")
+		for _, p := range progress {
+			lines = append(lines, p.String())
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if args[0] == "cancel" {
+		if len(args) < 2 {
+			return "", errors.New("Usage: repair cancel name")
+		}
+
+		now := time.Now()
+		err := c.db.C("data_repairs").Update(bson.M{"_id": args[1], "fa": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"fa": now, "err": "cancelled"}})
+		if err == mgo.ErrNotFound {
+			return fmt.Sprintf("Repair '%s' is not running", args[1]), nil
+		} else if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Repair '%s' cancelled", args[1]), nil
+	}
+
+	var names []string
+	if args[0] == "all" {
+		for _, r := range dataRepairs {
+			names = append(names, r.name)
+		}
+	} else if dataRepairByName(args[0]) != nil {
+		names = []string{args[0]}
+	} else {
+		return "", fmt.Errorf("Unknown repair '%s'", args[0])
+	}
+
+	now := time.Now()
+	var lines []string
+	for _, name := range names {
+		p := dataRepairProgress{ID: name, StartedBy: c.User.ID, ServiceName: c.ServiceName, StartedAt: now, UpdatedAt: now}
+
+		// restarts the finished repair from the beginning, the running one is kept
+		_, err := c.db.C("data_repairs").Upsert(bson.M{"_id": name, "fa": bson.M{"$exists": true}}, p)
+		if mgo.IsDup(err) {
+			lines = append(lines, fmt.Sprintf("%s: already running", name))
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: started", name))
+	}
+
+	log.Warnf("Data repairs started by %d: %s", c.User.ID, strings.Join(names, ", "))
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,103 @@
+package integram
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+func TestRunDataRepairSlice(t *testing.T) {
+	// fake repair over 250 documents, every 10th is broken
+	total := 250
+	step := func(db *mgo.Database, cursor string, limit int) (string, int, int, error) {
+		from := 0
+		if cursor != "" {
+			from, _ = strconv.Atoi(cursor)
+		}
+		to := from + limit
+		if to > total {
+			to = total
+		}
+		if from >= to {
+			return "", 0, 0, nil
+		}
+		return strconv.Itoa(to), to - from, (to - from) / 10, nil
+	}
+	r := &dataRepair{name: "fake", step: step}
+
+	p := &dataRepairProgress{ID: "fake"}
+	runDataRepairSlice(nil, r, p, time.Minute)
+
+	if p.FinishedAt == nil {
+		t.Fatal("runDataRepairSlice() didn't finish the repair")
+	}
+	if p.Checked != total || p.Fixed != 25 || p.Error != "" {
+		t.Errorf("runDataRepairSlice() checked %d, fixed %d, error %q, want %d, 25, empty", p.Checked, p.Fixed, p.Error, total)
+	}
+
+	// slice ended after the first batch, so the repair continues from the cursor
+	p = &dataRepairProgress{ID: "fake"}
+	runDataRepairSlice(nil, r, p, 0)
+	if p.FinishedAt != nil || p.Checked != 0 || p.Cursor != "" {
+		t.Errorf("runDataRepairSlice() with the ended slice = %+v", p)
+	}
+
+	failing := &dataRepair{name: "failing", step: func(db *mgo.Database, cursor string, limit int) (string, int, int, error) {
+		return "", 0, 0, errors.New("connection lost")
+	}}
+	p = &dataRepairProgress{ID: "failing", Cursor: "100"}
+	runDataRepairSlice(nil, failing, p, time.Minute)
+	if p.FinishedAt == nil || p.Error != "connection lost" || p.Cursor != "100" {
+		t.Errorf("runDataRepairSlice() with the error = %+v", p)
+	}
+}
+
+func TestDataRepairProgress_String(t *testing.T) {
+	started := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute*2 + time.Millisecond*300)
+
+	tests := []struct {
+		name string
+		p    dataRepairProgress
+		want string
+	}{
+		{"running", dataRepairProgress{ID: "orphaned_messages", Checked: 300, Fixed: 2, StartedAt: started}, "orphaned_messages: 300 checked, 2 fixed, running since 2024-03-10 12:00"},
+		{"finished", dataRepairProgress{ID: "keyboard_nulls", Checked: 10, StartedAt: started, FinishedAt: &finished}, "keyboard_nulls: 10 checked, 0 fixed, finished in 2m0s"},
+		{"stopped", dataRepairProgress{ID: "keyboard_nulls", StartedAt: started, FinishedAt: &finished, Error: "cancelled"}, "keyboard_nulls: 0 checked, 0 fixed, stopped: cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.String(); got != tt.want {
+				t.Errorf("dataRepairProgress.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemovedChat_isRemovedForBot(t *testing.T) {
+	botPerID[9999999991] = &Bot{ID: 9999999991, services: []*Service{{Name: "kickedservice"}}}
+	defer delete(botPerID, 9999999991)
+
+	kickedAt := time.Now()
+	tests := []struct {
+		name  string
+		chat  removedChat
+		botID int64
+		want  bool
+	}{
+		{"active", removedChat{ID: -1}, 9999999991, false},
+		{"deactivated", removedChat{ID: -1, Deactivated: true}, 9999999991, true},
+		{"migrated", removedChat{ID: -1, MigratedToChatID: -1001}, 9999999991, true},
+		{"bot kicked", removedChat{ID: -1, Protected: map[string]*chatProtected{"kickedservice": {BotStoppedOrKickedAt: &kickedAt}}}, 9999999991, true},
+		{"another service's bot kicked", removedChat{ID: -1, Protected: map[string]*chatProtected{"otherservice": {BotStoppedOrKickedAt: &kickedAt}}}, 9999999991, false},
+		{"unknown bot", removedChat{ID: -1, Protected: map[string]*chatProtected{"kickedservice": {BotStoppedOrKickedAt: &kickedAt}}}, 1, false},
+	}
+	for _, tt := range tests {
+		if got := tt.chat.isRemovedForBot(tt.botID); got != tt.want {
+			t.Errorf("%q. removedChat.isRemovedForBot() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package integram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+)
+
+// how often the DB availability is checked
+const dbHealthCheckInterval = time.Second * 5
+
+// DB is considered unavailable after this number of failed pings in a row
+const dbHealthMaxFailures = 2
+
+const dbPingTimeout = time.Second * 3
+
+// messages which can't be sent without the DB are put back to the queue with this delay
+const degradedRescheduleDelay = time.Minute
+
+// degradedUpdate is the Telegram update received while the DB was unavailable
+type degradedUpdate struct {
+	BotID      int64
+	Update     json.RawMessage
+	ReceivedAt time.Time
+}
+
+var errDBUnavailable = errors.New("DB is temporarily unavailable")
+
+// 1 while the DB is unavailable
+var dbDegraded int32
+
+var dbDegradedSinceMutex = sync.Mutex{}
+var dbDegradedSince time.Time
+
+// number of the webhooks and Telegram updates buffered on disk while the DB is unavailable
+var degradedBuffered int64
+
+var degradedCallbackTexts = map[string]string{
+	"en": "⚠️ Temporarily unavailable. Please try again in a few minutes",
+	"ru": "⚠️ Временно недоступно. Пожалуйста, попробуйте через несколько минут",
+	"de": "⚠️ Vorübergehend nicht verfügbar. Bitte versuche es in ein paar Minuten erneut",
+	"es": "⚠️ Temporalmente no disponible. Por favor, inténtalo en unos minutos",
+	"pt": "⚠️ Temporariamente indisponível. Por favor, tente novamente em alguns minutos",
+}
+
+// isDBDegraded returns true if the DB is unavailable and the instance works in the degraded mode:
+// webhooks and Telegram updates are buffered to disk, only the messages which don't need the DB are sent
+func isDBDegraded() bool {
+	return atomic.LoadInt32(&dbDegraded) == 1
+}
+
+// setDBDegraded switches the mode. Returns true if it was changed
+func setDBDegraded(degraded bool) bool {
+	if degraded {
+		if !atomic.CompareAndSwapInt32(&dbDegraded, 0, 1) {
+			return false
+		}
+
+		dbDegradedSinceMutex.Lock()
+		dbDegradedSince = time.Now()
+		dbDegradedSinceMutex.Unlock()
+		return true
+	}
+
+	return atomic.CompareAndSwapInt32(&dbDegraded, 1, 0)
+}
+
+func dbDegradedFor() time.Duration {
+	dbDegradedSinceMutex.Lock()
+	defer dbDegradedSinceMutex.Unlock()
+
+	return time.Since(dbDegradedSince)
+}
+
+// degradedDir returns the directory to buffer the webhooks and Telegram updates while the DB is unavailable
+func degradedDir(kind string) string {
+	return filepath.Join(Config.ConfigDir, "degraded", kind)
+}
+
+// initDegradedMode creates the buffer dirs and counts the work buffered before restart
+func initDegradedMode() {
+	if !Config.DegradedMode {
+		return
+	}
+
+	for _, kind := range []string{"webhooks", "updates"} {
+		err := os.MkdirAll(degradedDir(kind), 0700)
+		if err != nil {
+			log.WithError(err).Error("initDegradedMode: can't create the dir")
+		}
+
+		names, _ := spilledWebhookFiles(degradedDir(kind))
+		atomic.AddInt64(&degradedBuffered, int64(len(names)))
+	}
+}
+
+// pingDB checks the DB with the fresh socket
+func pingDB() error {
+	s := mongoSession.Copy()
+	defer s.Close()
+
+	s.SetSyncTimeout(dbPingTimeout)
+	s.SetSocketTimeout(dbPingTimeout)
+	return s.Ping()
+}
+
+// dbHealthWatcher switches the degraded mode on the DB outage and replays the buffered work when the DB recovers
+func dbHealthWatcher() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("dbHealthWatcher panic recovered %v", r)
+			dbHealthWatcher()
+		}
+	}()
+
+	if !Config.DegradedMode {
+		return
+	}
+
+	failures := 0
+	for {
+		time.Sleep(dbHealthCheckInterval)
+
+		err := pingDB()
+		if err != nil {
+			failures++
+			if failures >= dbHealthMaxFailures && setDBDegraded(true) {
+				log.WithField("alert", "degraded").WithError(err).Error("DB is unavailable, switched to the degraded mode: webhooks and updates are buffered to disk")
+			}
+			continue
+		}
+		failures = 0
+
+		if setDBDegraded(false) {
+			mongoSession.Refresh()
+			log.WithField("alert", "degraded").Infof("DB recovered after %s, replaying %d buffered webhooks and updates", dbDegradedFor().Truncate(time.Second), atomic.LoadInt64(&degradedBuffered))
+		}
+
+		if atomic.LoadInt64(&degradedBuffered) > 0 {
+			replayDegradedWork()
+		}
+	}
+}
+
+// replayDegradedWork processes the buffered webhooks and Telegram updates in the order of arrival. Stops if the DB becomes unavailable again
+func replayDegradedWork() {
+	for _, kind := range []string{"webhooks", "updates"} {
+		dir := degradedDir(kind)
+		names, err := spilledWebhookFiles(dir)
+		if err != nil {
+			log.WithError(err).Error("replayDegradedWork: can't list the buffered files")
+			continue
+		}
+
+		for _, name := range names {
+			if isDBDegraded() {
+				return
+			}
+
+			path := filepath.Join(dir, name)
+			if kind == "webhooks" {
+				serveSpilledWebhook(path)
+			} else {
+				replayDegradedUpdate(path)
+			}
+
+			err = os.Remove(path)
+			if err != nil {
+				log.WithError(err).WithField("path", path).Error("replayDegradedWork: can't remove the file")
+				return
+			}
+			atomic.AddInt64(&degradedBuffered, -1)
+		}
+	}
+}
+
+// degradedSpillWebhook buffers the webhook to disk while the DB is unavailable. Returns true if the response was written
+func degradedSpillWebhook(c *gin.Context) bool {
+	if !isDBDegraded() || c.Request.Method != "POST" {
+		return false
+	}
+
+	if atomic.LoadInt64(&degradedBuffered) >= int64(Config.WebhookSpillMax) {
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "Service is temporarily unavailable, please retry later")
+		return true
+	}
+
+	err := spillWebhook(degradedDir("webhooks"), c.Request)
+	if err != nil {
+		log.WithError(err).Error("degradedSpillWebhook: can't buffer the webhook")
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "Service is temporarily unavailable, please retry later")
+		return true
+	}
+
+	atomic.AddInt64(&degradedBuffered, 1)
+	c.String(http.StatusAccepted, "Webhook accepted and queued")
+	return true
+}
+
+// degradedCallbackText returns the toast in the user's language
+func degradedCallbackText(lang string) string {
+	if i := strings.IndexAny(lang, "-_"); i > -1 {
+		lang = lang[0:i]
+	}
+
+	if text, exists := degradedCallbackTexts[strings.ToLower(lang)]; exists {
+		return text
+	}
+	return degradedCallbackTexts["en"]
+}
+
+// handleUpdateInDegradedMode answers the callbacks with the "temporarily unavailable" toast and buffers the rest of the updates to disk while the DB is unavailable.
+// Returns true if the update was handled
+func handleUpdateInDegradedMode(b *Bot, u *tg.Update) bool {
+	if !isDBDegraded() {
+		return false
+	}
+
+	if u.CallbackQuery != nil {
+		_, err := b.API.AnswerCallbackQuery(tg.CallbackConfig{CallbackQueryID: u.CallbackQuery.ID, Text: degradedCallbackText(u.CallbackQuery.From.LanguageCode)})
+		if err != nil {
+			log.WithError(err).Error("Can't answer the callback in the degraded mode")
+		}
+		return true
+	}
+
+	if u.InlineQuery != nil {
+		// outdated after the recovery
+		return true
+	}
+
+	err := spillDegradedUpdate(degradedDir("updates"), b.ID, u)
+	if err != nil {
+		log.WithError(err).Error("Can't buffer the update in the degraded mode")
+		return true
+	}
+
+	atomic.AddInt64(&degradedBuffered, 1)
+	return true
+}
+
+// spillDegradedUpdate stores the update in the dir
+func spillDegradedUpdate(dir string, botID int64, u *tg.Update) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	du := degradedUpdate{BotID: botID, Update: data, ReceivedAt: time.Now()}
+	data, err = json.Marshal(du)
+	if err != nil {
+		return err
+	}
+
+	return writeSpillFile(dir, du.ReceivedAt, data)
+}
+
+// readDegradedUpdate restores the update from the file
+func readDegradedUpdate(path string) (*degradedUpdate, *tg.Update, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var du degradedUpdate
+	err = json.Unmarshal(data, &du)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var u tg.Update
+	err = json.Unmarshal(du.Update, &u)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &du, &u, nil
+}
+
+func replayDegradedUpdate(path string) {
+	du, u, err := readDegradedUpdate(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Error("replayDegradedUpdate: can't read the buffered update")
+		return
+	}
+
+	b := botByID(du.BotID)
+	if b == nil {
+		log.WithField("bot", du.BotID).Error("replayDegradedUpdate: bot not found")
+		return
+	}
+
+	updateRoutine(b, u)
+}
+
+// needsDB returns true if the message can't be sent without the DB: it's stored to be edited or answered later or uses the DB-backed features
+func (m *OutgoingMessage) needsDB() bool {
+	return len(m.EventID) > 0 || m.OnCallbackAction != "" || m.OnReplyAction != "" || m.OnEditAction != "" || m.OnViewerAction != "" ||
+		len(m.InlineKeyboardMarkup.Buttons) > 0 || len(m.KeyboardMarkup) > 0 || m.ForceReply ||
+		m.FilePath != "" || m.FileURL != "" || m.Poll != nil || m.MediaGroupID != "" || m.AntiFlood || len(m.SplitParts) > 0
+}
+
+// sendMessageDegraded sends the message which doesn't need the DB. The rest are put back to the queue until the DB recovers
+func sendMessageDegraded(m *OutgoingMessage) error {
+	if m.needsDB() {
+		if m.sync {
+			return errDBUnavailable
+		}
+		return m.reschedule(time.Now().Add(degradedRescheduleDelay))
+	}
+
+	bot := botByID(m.BotID)
+	if bot == nil {
+		return fmt.Errorf("Can't send TG message: Unknown bot id=%d", m.BotID)
+	}
+
+	var tgMsg tg.Message
+	var err error
+
+	if m.FileID != "" {
+		tgMsg, err = m.sendFileShare(bot, m.FileID)
+	} else if m.Location != nil {
+		tgMsg, err = bot.API.Send(tg.LocationConfig{BaseChat: tg.BaseChat{ChatID: m.ChatID}, Latitude: m.Location.Latitude, Longitude: m.Location.Longitude})
+	} else {
+		msg := tg.MessageConfig{Text: m.Text, BaseChat: tg.BaseChat{ChatID: m.ChatID, ReplyToMessageID: m.ReplyToMsgID}}
+		msg.DisableWebPagePreview = !m.WebPreview
+		msg.DisableNotification = m.Silent
+		msg.ParseMode = m.ParseMode
+
+		if m.KeyboardHide {
+			msg.ReplyMarkup = tg.ReplyKeyboardRemove{RemoveKeyboard: true, Selective: m.Selective}
+		}
+
+		if m.ReplyToMsgID != 0 && m.ReplyQuote != "" {
+			tgMsg, err = m.sendQuotedMessage(bot, msg)
+		} else {
+			tgMsg, err = bot.API.Send(msg)
+		}
+	}
+
+	if err != nil {
+		// pass through the error so the job will be rescheduled
+		log.WithError(err).WithField("chat", m.ChatID).Warn("sendMessageDegraded: can't send the message")
+		return err
+	}
+
+	m.MsgID = tgMsg.MessageID
+	m.Date = time.Now()
+	return nil
+}
@@ -0,0 +1,109 @@
+package integram
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_setDBDegraded(t *testing.T) {
+	defer setDBDegraded(false)
+
+	if !setDBDegraded(true) || !isDBDegraded() {
+		t.Fatalf("setDBDegraded(true) didn't switch to the degraded mode")
+	}
+	if setDBDegraded(true) {
+		t.Errorf("setDBDegraded(true) twice = true, want false")
+	}
+	if !setDBDegraded(false) || isDBDegraded() {
+		t.Errorf("setDBDegraded(false) didn't switch back")
+	}
+	if setDBDegraded(false) {
+		t.Errorf("setDBDegraded(false) twice = true, want false")
+	}
+}
+
+func TestOutgoingMessage_needsDB(t *testing.T) {
+	tests := []struct {
+		name string
+		m    OutgoingMessage
+		want bool
+	}{
+		{"plain text", OutgoingMessage{Message: Message{Text: "hello"}}, false},
+		{"reply with quote", OutgoingMessage{Message: Message{Text: "hello", ReplyToMsgID: 10}, ReplyQuote: "hel"}, false},
+		{"uploaded file", OutgoingMessage{FileID: "AgAD"}, false},
+		{"event ID", OutgoingMessage{Message: Message{Text: "hello", EventID: []string{"e1"}}}, true},
+		{"callback", OutgoingMessage{Message: Message{Text: "hello", OnCallbackAction: "action"}}, true},
+		{"inline keyboard", OutgoingMessage{Message: Message{Text: "hello"}, InlineKeyboardMarkup: InlineKeyboard{Buttons: []InlineButtons{{{Text: "a", Data: "a"}}}}}, true},
+		{"local file", OutgoingMessage{FilePath: "/tmp/file.txt"}, true},
+		{"anti flood", OutgoingMessage{Message: Message{Text: "hello", AntiFlood: true}}, true},
+		{"split", OutgoingMessage{Message: Message{Text: "hello"}, SplitParts: []string{"world"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.needsDB(); got != tt.want {
+				t.Errorf("OutgoingMessage.needsDB() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_degradedCallbackText(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"ru", degradedCallbackTexts["ru"]},
+		{"pt-BR", degradedCallbackTexts["pt"]},
+		{"DE", degradedCallbackTexts["de"]},
+		{"xx", degradedCallbackTexts["en"]},
+		{"", degradedCallbackTexts["en"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			if got := degradedCallbackText(tt.lang); got != tt.want {
+				t.Errorf("degradedCallbackText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_spillDegradedUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integram_degraded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, text := range []string{"first", "second"} {
+		u := &tg.Update{UpdateID: 1, Message: &tg.Message{MessageID: 10, Text: text, Chat: &tg.Chat{ID: -100}}}
+		err = spillDegradedUpdate(dir, 1111, u)
+		if err != nil {
+			t.Fatalf("spillDegradedUpdate() error = %v", err)
+		}
+	}
+
+	names, err := spilledWebhookFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("spilledWebhookFiles() returned %d files, want 2", len(names))
+	}
+
+	for i, want := range []string{"first", "second"} {
+		du, u, err := readDegradedUpdate(dir + string(os.PathSeparator) + names[i])
+		if err != nil {
+			t.Fatalf("readDegradedUpdate() error = %v", err)
+		}
+
+		if du.BotID != 1111 || du.ReceivedAt.IsZero() {
+			t.Errorf("readDegradedUpdate() = %+v", du)
+		}
+		if u.Message == nil || u.Message.Text != want || u.Message.Chat.ID != -100 {
+			t.Errorf("readDegradedUpdate() update = %+v, want the message %q", u, want)
+		}
+	}
+}
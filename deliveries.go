@@ -0,0 +1,267 @@
+package integram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the webhook deliveries are stored
+const webhookDeliveriesTTL = time.Hour * 24 * 30
+
+// number of the recent errors to show in /webhook stats
+const webhookStatsRecentErrors = 3
+
+// webhookDelivery is stored in the "deliveries" collection for each incoming webhook request
+type webhookDelivery struct {
+	ID      bson.ObjectId `bson:"_id"`
+	Token   string        `bson:"t"`
+	Service string        `bson:"s"`
+	Date    time.Time     `bson:"d"`
+	Size    int64         `bson:"sz"`
+	OK      bool          `bson:"ok"`
+	Error   string        `bson:"e,omitempty"`
+}
+
+// webhookStats is the summary of the hook's deliveries
+type webhookStats struct {
+	Token         string
+	LastDelivery  *time.Time
+	Delivered     int
+	Failed        int
+	RecentErrors  []string
+	SizeHistogram [4]int // <1KB, 1-10KB, 10-100KB, >100KB
+}
+
+var webhookSizeBuckets = []string{"<1KB", "1-10KB", "10-100KB", ">100KB"}
+
+// upper limits of the webhookSizeBuckets except the last one
+var webhookSizeBucketLimits = []int64{1024, 10 * 1024, 100 * 1024}
+
+// webhookDeliveriesGroup is the number of the hook's deliveries with the same result and size bucket
+type webhookDeliveriesGroup struct {
+	ID struct {
+		OK     bool `bson:"ok"`
+		Bucket int  `bson:"b"`
+	} `bson:"_id"`
+	Count int       `bson:"n"`
+	Last  time.Time `bson:"last"`
+}
+
+func recordWebhookDelivery(db *mgo.Database, token string, service string, size int64, handlerErr error) {
+	d := webhookDelivery{ID: bson.NewObjectId(), Token: token, Service: service, Date: time.Now(), Size: size, OK: handlerErr == nil}
+	if handlerErr != nil {
+		d.Error = handlerErr.Error()
+	}
+
+	err := db.C("deliveries").Insert(d)
+	if err != nil {
+		log.WithError(err).WithField("token", token).Error("Can't save the webhook delivery")
+	}
+
+	updateWebhookHealth(db, token, service, handlerErr)
+}
+
+func webhookSizeBucket(size int64) int {
+	for i, limit := range webhookSizeBucketLimits {
+		if size < limit {
+			return i
+		}
+	}
+	return len(webhookSizeBucketLimits)
+}
+
+// webhookSizeBucketExpr returns the aggregation expression of webhookSizeBucket for the delivery's size
+func webhookSizeBucketExpr() interface{} {
+	var expr interface{} = len(webhookSizeBucketLimits)
+	for i := len(webhookSizeBucketLimits) - 1; i >= 0; i-- {
+		expr = bson.M{"$cond": []interface{}{bson.M{"$lt": []interface{}{"$sz", webhookSizeBucketLimits[i]}}, i, expr}}
+	}
+	return expr
+}
+
+// webhookStatsPipeline groups the hook's deliveries by the result and size bucket
+func webhookStatsPipeline(token string) []bson.M {
+	return []bson.M{
+		{"$match": bson.M{"t": token}},
+		{"$group": bson.M{
+			"_id":  bson.M{"ok": "$ok", "b": webhookSizeBucketExpr()},
+			"n":    bson.M{"$sum": 1},
+			"last": bson.M{"$max": "$d"},
+		}},
+	}
+}
+
+func calcWebhookStats(token string, groups []webhookDeliveriesGroup, recentErrors []string) webhookStats {
+	stats := webhookStats{Token: token, RecentErrors: recentErrors}
+
+	for _, g := range groups {
+		if stats.LastDelivery == nil || g.Last.After(*stats.LastDelivery) {
+			last := g.Last
+			stats.LastDelivery = &last
+		}
+
+		if g.ID.OK {
+			stats.Delivered += g.Count
+		} else {
+			stats.Failed += g.Count
+		}
+
+		if g.ID.Bucket >= 0 && g.ID.Bucket < len(stats.SizeHistogram) {
+			stats.SizeHistogram[g.ID.Bucket] += g.Count
+		}
+	}
+
+	return stats
+}
+
+// loadWebhookStats aggregates the hook's deliveries and loads its recent errors
+func loadWebhookStats(db *mgo.Database, token string) (webhookStats, error) {
+	var groups []webhookDeliveriesGroup
+	err := db.C("deliveries").Pipe(webhookStatsPipeline(token)).All(&groups)
+	if err != nil {
+		return webhookStats{}, err
+	}
+
+	var failed []webhookDelivery
+	err = db.C("deliveries").Find(bson.M{"t": token, "ok": false}).Sort("-d").Select(bson.M{"e": 1}).Limit(webhookStatsRecentErrors).All(&failed)
+	if err != nil {
+		return webhookStats{}, err
+	}
+
+	var recentErrors []string
+	for _, d := range failed {
+		recentErrors = append(recentErrors, d.Error)
+	}
+
+	return calcWebhookStats(token, groups, recentErrors), nil
+}
+
+func (s webhookStats) String() string {
+	maskedToken := s.Token
+	if len(maskedToken) > 4 {
+		maskedToken = maskedToken[0:4] + "…"
+	}
+
+	lines := []string{fmt.Sprintf("Webhook %s", maskedToken)}
+	if s.LastDelivery == nil {
+		lines = append(lines, "No deliveries for the last 30 days")
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Last delivery: %s", s.LastDelivery.UTC().Format("2006-01-02 15:04:05 UTC")),
+		fmt.Sprintf("Last 30 days: %d delivered, %d failed", s.Delivered, s.Failed))
+
+	if len(s.RecentErrors) > 0 {
+		lines = append(lines, "Recent errors:")
+		for _, e := range s.RecentErrors {
+			lines = append(lines, "- "+e)
+		}
+	}
+
+	var sizes []string
+	for i, bucket := range webhookSizeBuckets {
+		sizes = append(sizes, fmt.Sprintf("%s: %d", bucket, s.SizeHistogram[i]))
+	}
+	lines = append(lines, "Payload sizes: "+strings.Join(sizes, ", "))
+
+	return strings.Join(lines, "\n")
+}
+
+// chatHookTokens returns the tokens of the service's hooks that deliver to the current chat
+func (c *Context) chatHookTokens() ([]string, error) {
+	var tokens []string
+
+	chat, err := c.FindChat(bson.M{"_id": c.Chat.ID})
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+
+	for _, hook := range chat.Hooks {
+		if SliceContainsString(hook.Services, c.ServiceName) {
+			tokens = append(tokens, hook.Token)
+		}
+	}
+
+	users, err := c.FindUsers(bson.M{"hooks": bson.M{"$elemMatch": bson.M{"chats": c.Chat.ID, "services": c.ServiceName}}})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		for _, hook := range user.Hooks {
+			if SliceContainsString(hook.Services, c.ServiceName) && hook.Chats != nil {
+				for _, chatID := range hook.Chats {
+					if chatID == c.Chat.ID {
+						tokens = append(tokens, hook.Token)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// WebhookStats returns the deliveries report for the hooks configured in the current chat
+func (c *Context) WebhookStats() (string, error) {
+	tokens, err := c.chatHookTokens()
+	if err != nil {
+		return "", err
+	}
+
+	if len(tokens) == 0 {
+		return "There are no webhooks configured for this chat", nil
+	}
+
+	var reports []string
+	for _, token := range tokens {
+		stats, err := loadWebhookStats(c.Db(), token)
+		if err != nil {
+			return "", err
+		}
+
+		reports = append(reports, stats.String())
+	}
+
+	return strings.Join(reports, "\n\n"), nil
+}
+
+// handleWebhookStatsCommand process '/webhook stats' sent by the chat admin. Returns true if message was handled
+func (c *Context) handleWebhookStatsCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand("webhook") || strings.TrimSpace(param) != "stats" {
+		return false
+	}
+
+	var text string
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("handleWebhookStatsCommand: can't check chat admin")
+		text = "Can't check your permissions in this chat. Please try again later"
+	} else if !isAdmin {
+		text = "Only chat admins can see webhook stats"
+	} else {
+		text, err = c.WebhookStats()
+		if err != nil {
+			c.Log().WithError(err).Error("handleWebhookStatsCommand: can't get the stats")
+			text = "Can't get webhook stats. Please try again later"
+		}
+	}
+
+	err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(HTMLRichText{}.Pre(text)).EnableHTML().Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleWebhookStatsCommand: can't send the report")
+	}
+
+	return true
+}
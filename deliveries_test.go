@@ -0,0 +1,72 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_webhookSizeBucket(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, 0},
+		{1023, 0},
+		{1024, 1},
+		{50 * 1024, 2},
+		{100 * 1024, 3},
+	}
+	for _, tt := range tests {
+		if got := webhookSizeBucket(tt.size); got != tt.want {
+			t.Errorf("webhookSizeBucket(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
+
+func Test_calcWebhookStats(t *testing.T) {
+	last := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	group := func(ok bool, bucket int, n int, last time.Time) webhookDeliveriesGroup {
+		g := webhookDeliveriesGroup{Count: n, Last: last}
+		g.ID.OK = ok
+		g.ID.Bucket = bucket
+		return g
+	}
+
+	groups := []webhookDeliveriesGroup{
+		group(false, 0, 2, last.Add(-3*time.Hour)),
+		group(true, 0, 1, last),
+		group(false, 1, 1, last.Add(-time.Hour)),
+		group(false, 3, 1, last.Add(-2*time.Hour)),
+	}
+
+	want := webhookStats{
+		Token:         "token",
+		LastDelivery:  &last,
+		Delivered:     1,
+		Failed:        4,
+		RecentErrors:  []string{"err1", "err2", "err3"},
+		SizeHistogram: [4]int{3, 1, 0, 1},
+	}
+
+	if got := calcWebhookStats("token", groups, []string{"err1", "err2", "err3"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("calcWebhookStats() = %v, want %v", got, want)
+	}
+
+	if got := calcWebhookStats("token", nil, nil); got.LastDelivery != nil || got.Delivered != 0 {
+		t.Errorf("calcWebhookStats() for empty deliveries = %v", got)
+	}
+}
+
+func Test_webhookSizeBucketExpr(t *testing.T) {
+	lt := func(limit int64, then int, otherwise interface{}) bson.M {
+		return bson.M{"$cond": []interface{}{bson.M{"$lt": []interface{}{"$sz", limit}}, then, otherwise}}
+	}
+
+	want := lt(1024, 0, lt(10*1024, 1, lt(100*1024, 2, 3)))
+	if got := webhookSizeBucketExpr(); !reflect.DeepEqual(got, want) {
+		t.Errorf("webhookSizeBucketExpr() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,254 @@
+package integram
+
+import (
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chat command to set the rendering density: '/density compact', '/density detailed'
+const densityCommand = "density"
+
+// Chat's rendering density. Detailed is the default
+const (
+	DensityDetailed = "detailed"
+	DensityCompact  = "compact"
+)
+
+// data of the button to expand or collapse the compact message
+const (
+	densityExpandData   = "_dn_more"
+	densityCollapseData = "_dn_less"
+)
+
+// detailed text is available behind the "Details" button for this period
+const densityTTL = time.Hour * 24 * 30
+
+// messageDensity is stored in the "messages_density" collection to toggle the message between the compact and the detailed text
+type messageDensity struct {
+	ID       bson.ObjectId `bson:"_id"` // ID of the outgoing message
+	Compact  string        `bson:"c"`
+	Detailed string        `bson:"f"`
+	Date     time.Time     `bson:"d"`
+}
+
+// SetCompactText sets the one-line summary sent instead of the text to the chats with the compact density.
+// The text is shown when the user presses the "Details" button
+func (m *OutgoingMessage) SetCompactText(text string) *OutgoingMessage {
+	m.CompactText = text
+	return m
+}
+
+// normalizeDensity returns the density constant or empty string if it's unknown
+func normalizeDensity(density string) string {
+	switch strings.ToLower(strings.TrimSpace(density)) {
+	case DensityCompact:
+		return DensityCompact
+	case DensityDetailed, "full":
+		return DensityDetailed
+	}
+	return ""
+}
+
+// Density returns the chat's rendering density: DensityCompact or DensityDetailed
+func (chat *Chat) Density() (string, error) {
+	var data struct {
+		Density string
+	}
+
+	err := chat.ctx.db.C("chats").FindId(chat.ID).Select(bson.M{"density": 1}).One(&data)
+	if err == mgo.ErrNotFound {
+		return DensityDetailed, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if data.Density == "" {
+		return DensityDetailed, nil
+	}
+	return data.Density, nil
+}
+
+// SetDensity sets the chat's rendering density. DensityDetailed resets it to the default
+func (chat *Chat) SetDensity(density string) error {
+	if density == DensityDetailed {
+		return chat.ctx.db.C("chats").UpdateId(chat.ID, bson.M{"$unset": bson.M{"density": ""}})
+	}
+
+	_, err := chat.ctx.db.C("chats").UpsertId(chat.ID, bson.M{"$set": bson.M{"density": density}, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	return err
+}
+
+// densityButtonText returns the text of the button to toggle the density
+func densityButtonText(expand bool) string {
+	if expand {
+		return "▾ Details"
+	}
+	return "▴ Less"
+}
+
+// applyDensity replaces the text with CompactText in the compact chats and adds the "Details" button. Returns nil if the message wasn't compacted
+func (m *OutgoingMessage) applyDensity(db *mgo.Database) *messageDensity {
+	if m.CompactText == "" || m.Text == "" || m.CompactText == m.Text || len(m.KeyboardMarkup) > 0 || m.ForceReply {
+		return nil
+	}
+
+	var data struct {
+		Density string
+	}
+	err := db.C("chats").FindId(m.ChatID).Select(bson.M{"density": 1}).One(&data)
+	if err != nil || data.Density != DensityCompact {
+		return nil
+	}
+
+	d := &messageDensity{Compact: m.CompactText, Detailed: m.Text, Date: time.Now()}
+
+	m.Text = m.CompactText
+	m.InlineKeyboardMarkup.AppendRows(InlineButtons{InlineButton{Text: densityButtonText(true), Data: densityExpandData}})
+
+	return d
+}
+
+// withDensityButton returns the keyboard with the "Details" button of the compacted message kept, e.g. when the service replaces the keyboard
+func withDensityButton(prev InlineKeyboard, kb InlineKeyboard) InlineKeyboard {
+	for _, data := range []string{densityExpandData, densityCollapseData} {
+		if _, _, b := kb.Find(data); b != nil {
+			return kb
+		}
+	}
+
+	for _, data := range []string{densityExpandData, densityCollapseData} {
+		if _, _, b := prev.Find(data); b != nil {
+			rows := make([]InlineButtons, len(kb.Buttons), len(kb.Buttons)+1)
+			copy(rows, kb.Buttons)
+			kb.Buttons = append(rows, InlineButtons{*b})
+			return kb
+		}
+	}
+	return kb
+}
+
+// compactedEditText stores the service's edit of the compacted message as its details. Returns the text to show: the compact one while the details are collapsed
+func (c *Context) compactedEditText(om *OutgoingMessage, text string) string {
+	_, _, expand := om.InlineKeyboardMarkup.Find(densityExpandData)
+	_, _, collapse := om.InlineKeyboardMarkup.Find(densityCollapseData)
+	if expand == nil && collapse == nil || !om.ID.Valid() {
+		return text
+	}
+
+	var d messageDensity
+	_, err := c.db.C("messages_density").FindId(om.ID).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"f": sanitizeText(text, om.ParseMode)}}}, &d)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			c.Log().WithError(err).Error("Can't update the message details")
+		}
+		return text
+	}
+
+	if expand != nil {
+		return d.Compact
+	}
+	return text
+}
+
+// toggleDensity edits the pressed message to show the detailed or the compact text
+func (c *Context) toggleDensity(expand bool) error {
+	om := c.Callback.Message
+
+	var d messageDensity
+	err := c.db.C("messages_density").FindId(om.ID).One(&d)
+	if err == mgo.ErrNotFound {
+		return c.AnswerCallbackQuery("Details are no longer available", false)
+	} else if err != nil {
+		return err
+	}
+
+	kb := om.InlineKeyboardMarkup
+	kb.Buttons = make([]InlineButtons, len(om.InlineKeyboardMarkup.Buttons))
+	for i, row := range om.InlineKeyboardMarkup.Buttons {
+		kb.Buttons[i] = append(InlineButtons{}, row...)
+	}
+
+	text, from, to := d.Compact, densityCollapseData, densityExpandData
+	if expand {
+		text, from, to = d.Detailed, densityExpandData, densityCollapseData
+	}
+
+	if i, j, b := kb.Find(from); b != nil {
+		kb.Buttons[i][j].Data = to
+		kb.Buttons[i][j].Text = densityButtonText(!expand)
+	}
+
+	err = c.editMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, text, kb)
+	if err != nil {
+		return err
+	}
+
+	return c.AnswerCallbackQuery("", false)
+}
+
+// handleDensityCommand process '/density [compact|detailed]' sent by the chat admin. Returns true if message was handled
+func (c *Context) handleDensityCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand(densityCommand) {
+		return false
+	}
+
+	reply := func(text string) {
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).SetParseMode("").applyBrandingFooter().Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleDensityCommand: can't send the reply")
+		}
+	}
+
+	usage := "Usage: /" + coreCommand(densityCommand) + " " + DensityCompact + " or " + DensityDetailed
+
+	param = strings.TrimSpace(param)
+	if param == "" {
+		density, err := c.Chat.Density()
+		if err != nil {
+			c.Log().WithError(err).Error("handleDensityCommand: can't get the density")
+			reply(c.Branding().ErrorText("Can't get the density settings. Please try again later"))
+			return true
+		}
+
+		reply("Notifications are " + density + ".\n" + usage)
+		return true
+	}
+
+	density := normalizeDensity(param)
+	if density == "" {
+		reply(usage)
+		return true
+	}
+
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("handleDensityCommand: can't check chat admin")
+		reply("Can't check your permissions in this chat. Please try again later")
+		return true
+	} else if !isAdmin {
+		reply("Only chat admins can change the density settings")
+		return true
+	}
+
+	err := c.Chat.SetDensity(density)
+	if err != nil && err != mgo.ErrNotFound {
+		c.Log().WithError(err).Error("handleDensityCommand: can't save the density")
+		reply(c.Branding().ErrorText("Can't save the density settings. Please try again later"))
+		return true
+	}
+
+	if density == DensityCompact {
+		reply("Notifications will be compact. Use the button below the message to see the details")
+	} else {
+		reply("Notifications will be detailed")
+	}
+
+	return true
+}
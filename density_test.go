@@ -0,0 +1,52 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_normalizeDensity(t *testing.T) {
+	tests := []struct {
+		density string
+		want    string
+	}{
+		{"compact", DensityCompact},
+		{" Compact ", DensityCompact},
+		{"detailed", DensityDetailed},
+		{"full", DensityDetailed},
+		{"short", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.density, func(t *testing.T) {
+			if got := normalizeDensity(tt.density); got != tt.want {
+				t.Errorf("normalizeDensity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withDensityButton(t *testing.T) {
+	details := InlineButton{Text: densityButtonText(true), Data: densityExpandData}
+	approve := InlineButton{Text: "Approve", Data: "approve"}
+	compacted := InlineKeyboard{Buttons: []InlineButtons{{approve}, {details}}}
+
+	tests := []struct {
+		name string
+		prev InlineKeyboard
+		kb   InlineKeyboard
+		want InlineKeyboard
+	}{
+		{"not compacted", InlineKeyboard{Buttons: []InlineButtons{{approve}}}, InlineKeyboard{}, InlineKeyboard{}},
+		{"replaced", compacted, InlineKeyboard{Buttons: []InlineButtons{{InlineButton{Text: "Approved", Data: "approved"}}}}, InlineKeyboard{Buttons: []InlineButtons{{InlineButton{Text: "Approved", Data: "approved"}}, {details}}}},
+		{"removed", compacted, InlineKeyboard{}, InlineKeyboard{Buttons: []InlineButtons{{details}}}},
+		{"kept by the service", compacted, compacted, compacted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withDensityButton(tt.prev, tt.kb); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDensityButton() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
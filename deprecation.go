@@ -0,0 +1,205 @@
+package integram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// notices are sent to the chats connected since the last check
+const deprecationCheckInterval = time.Hour
+
+// max length of the callback query's alert
+const callbackAlertMaxRunes = 200
+
+const deprecationDateLayout = "2 Jan 2006"
+
+// phases of the service's deprecation, see ServiceDeprecation
+const (
+	deprecationPhaseNone     = iota
+	deprecationPhaseNotice   // notices are sent, service works as usual
+	deprecationPhaseReadOnly // messages and buttons are answered with the notice, webhooks are still delivered
+	deprecationPhaseDisabled // webhooks are rejected too
+)
+
+// ServiceDeprecation retires the service gradually. Set it in Service.Deprecation, the phases are switched by the dates
+type ServiceDeprecation struct {
+	Notice       string         // why the service is retired and how to migrate. Sent once to every subscribed chat and authorized user
+	Buttons      []InlineButton // migration links shown below the notice, one per row
+	NoticeFrom   time.Time      // notices are sent starting from this time. Zero means right after the start
+	ReadOnlyFrom time.Time      // optional. Messages and buttons are answered with the notice instead of the handlers
+	DisableFrom  time.Time      // optional. Webhooks are rejected with 410 Gone
+}
+
+// phase returns the deprecation phase at the time
+func (d *ServiceDeprecation) phase(now time.Time) int {
+	switch {
+	case d == nil:
+		return deprecationPhaseNone
+	case !d.DisableFrom.IsZero() && !now.Before(d.DisableFrom):
+		return deprecationPhaseDisabled
+	case !d.ReadOnlyFrom.IsZero() && !now.Before(d.ReadOnlyFrom):
+		return deprecationPhaseReadOnly
+	case !now.Before(d.NoticeFrom):
+		return deprecationPhaseNotice
+	}
+	return deprecationPhaseNone
+}
+
+// text returns the notice with the upcoming phases
+func (d *ServiceDeprecation) text(now time.Time) string {
+	var schedule []string
+	if !d.ReadOnlyFrom.IsZero() && now.Before(d.ReadOnlyFrom) {
+		schedule = append(schedule, fmt.Sprintf("Commands and buttons will stop working on %s", d.ReadOnlyFrom.UTC().Format(deprecationDateLayout)))
+	}
+
+	if !d.DisableFrom.IsZero() && now.Before(d.DisableFrom) {
+		schedule = append(schedule, fmt.Sprintf("Notifications will stop on %s", d.DisableFrom.UTC().Format(deprecationDateLayout)))
+	}
+
+	if len(schedule) == 0 {
+		return d.Notice
+	}
+	return d.Notice + "\n\n" + strings.Join(schedule, "\n")
+}
+
+// keyboard returns the migration buttons
+func (d *ServiceDeprecation) keyboard() InlineKeyboard {
+	kb := InlineKeyboard{}
+	for _, b := range d.Buttons {
+		kb.AppendRows(InlineButtons{b})
+	}
+	return kb
+}
+
+// deprecationAffectedChats returns the chats subscribed to the service's webhooks and the private chats of the users authorized in the service
+func deprecationAffectedChats(db *mgo.Database, serviceName string) ([]int64, error) {
+	subscriptions, err := chatSubscriptions(db, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatIDs []int64
+	for chatID := range subscriptions {
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	var users []struct {
+		ID int64 `bson:"_id"`
+	}
+	err = db.C("users").Find(bson.M{"protected." + serviceName: bson.M{"$exists": true}}).Select(bson.M{"_id": 1}).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if _, subscribed := subscriptions[u.ID]; !subscribed {
+			chatIDs = append(chatIDs, u.ID)
+		}
+	}
+
+	return chatIDs, nil
+}
+
+// sendDeprecationNotices sends the notice to the affected chats that haven't received it yet
+func sendDeprecationNotices(db *mgo.Database, s *Service) error {
+	chatIDs, err := deprecationAffectedChats(db, s.Name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, chatID := range chatIDs {
+		// inserted before sending, so the notice is sent once even with several instances
+		err := db.C("deprecation_notices").Insert(bson.M{"_id": fmt.Sprintf("%s_%d", s.Name, chatID), "d": now})
+		if mgo.IsDup(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		ctx := &Context{db: db, ServiceName: s.Name}
+		ctx.Chat = Chat{ID: chatID, ctx: ctx}
+
+		err = ctx.NewMessage().SetText(s.Deprecation.text(now)).SetParseMode("").SetInlineKeyboard(s.Deprecation.keyboard()).Send()
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"service": s.Name, "chat": chatID}).Error("Can't send the deprecation notice")
+		}
+	}
+
+	return nil
+}
+
+// deprecationNotifier sends the notices of the deprecated services
+func deprecationNotifier() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("deprecationNotifier panic recovered %v", r)
+			deprecationNotifier()
+		}
+	}()
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	for {
+		var list []*Service
+		serviceMapMutex.RLock()
+		for _, s := range services {
+			list = append(list, s)
+		}
+		serviceMapMutex.RUnlock()
+
+		for _, s := range list {
+			if s.Deprecation.phase(time.Now()) == deprecationPhaseNone {
+				continue
+			}
+
+			err := sendDeprecationNotices(db, s)
+			if err != nil {
+				log.WithError(err).WithField("service", s.Name).Error("deprecationNotifier: can't send the notices")
+			}
+		}
+
+		time.Sleep(deprecationCheckInterval)
+	}
+}
+
+// handleDeprecatedServiceMessage answers the commands and the private messages with the notice when the service is read-only. Returns true if message was handled
+func (c *Context) handleDeprecatedServiceMessage() bool {
+	s := c.Service()
+	if c.Message == nil || s == nil || s.Deprecation.phase(time.Now()) < deprecationPhaseReadOnly {
+		return false
+	}
+
+	d := s.Deprecation
+
+	if cmd, _ := c.Message.GetCommand(); cmd == "" && !c.Chat.IsPrivate() {
+		// don't answer every message in the group
+		return true
+	}
+
+	err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(d.text(time.Now())).SetParseMode("").SetInlineKeyboard(d.keyboard()).Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleDeprecatedServiceMessage: can't send the notice")
+	}
+	return true
+}
+
+// answerDeprecatedServiceCallback answers the button press with the notice when the service is read-only. Returns true if callback was answered
+func (c *Context) answerDeprecatedServiceCallback() bool {
+	s := c.Service()
+	if s == nil || s.Deprecation.phase(time.Now()) < deprecationPhaseReadOnly {
+		return false
+	}
+
+	err := c.AnswerCallbackQuery(truncateRunes(s.Deprecation.text(time.Now()), callbackAlertMaxRunes), true)
+	if err != nil {
+		c.Log().WithError(err).Error("answerDeprecatedServiceCallback: can't answer the callback")
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceDeprecation_phase(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := time.Hour * 24
+
+	tests := []struct {
+		name string
+		d    *ServiceDeprecation
+		want int
+	}{
+		{"not deprecated", nil, deprecationPhaseNone},
+		{"notice right away", &ServiceDeprecation{}, deprecationPhaseNotice},
+		{"notice later", &ServiceDeprecation{NoticeFrom: now.Add(day)}, deprecationPhaseNone},
+		{"before read-only", &ServiceDeprecation{ReadOnlyFrom: now.Add(day), DisableFrom: now.Add(2 * day)}, deprecationPhaseNotice},
+		{"read-only", &ServiceDeprecation{ReadOnlyFrom: now.Add(-day), DisableFrom: now.Add(day)}, deprecationPhaseReadOnly},
+		{"disabled", &ServiceDeprecation{ReadOnlyFrom: now.Add(-2 * day), DisableFrom: now}, deprecationPhaseDisabled},
+		{"disabled without read-only", &ServiceDeprecation{DisableFrom: now.Add(-day)}, deprecationPhaseDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.phase(now); got != tt.want {
+				t.Errorf("ServiceDeprecation.phase() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceDeprecation_text(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	d := &ServiceDeprecation{
+		Notice:       "Service is moving to the new bot",
+		ReadOnlyFrom: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		DisableFrom:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	want := "Service is moving to the new bot\n\nCommands and buttons will stop working on 1 Apr 2024\nNotifications will stop on 1 May 2024"
+	if got := d.text(now); got != want {
+		t.Errorf("ServiceDeprecation.text() = %q, want %q", got, want)
+	}
+
+	if got := d.text(d.ReadOnlyFrom); got != "Service is moving to the new bot\n\nNotifications will stop on 1 May 2024" {
+		t.Errorf("ServiceDeprecation.text() in read-only = %q", got)
+	}
+
+	if got := d.text(d.DisableFrom); got != d.Notice {
+		t.Errorf("ServiceDeprecation.text() when disabled = %q, want the notice only", got)
+	}
+}
@@ -0,0 +1,303 @@
+package integram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const diagnoseCommand = "diagnose"
+
+// data of the /diagnose fix-it buttons
+const (
+	diagnoseFixReconnect  = "reconnect:" // followed by the hook's token
+	diagnoseFixFiltersOff = "filters_off"
+	diagnoseFixRecheck    = "recheck"
+)
+
+// diagnosisCheck is the result of the single /diagnose check
+type diagnosisCheck struct {
+	Name    string
+	OK      bool
+	Details string
+	Fix     *diagnosisFix // button to fix the problem
+}
+
+// diagnosisFix is the callback button if Data is set or the URL button
+type diagnosisFix struct {
+	Text string
+	Data string
+	URL  string
+}
+
+// diagnosisAge returns the human-readable time since the event
+func diagnosisAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min ago", int(d.Minutes()))
+	case d < time.Hour*48:
+		return fmt.Sprintf("%d h ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+}
+
+// diagnosisText returns the report of the checks
+func diagnosisText(serviceName string, checks []diagnosisCheck) string {
+	failed := 0
+	lines := []string{}
+	for _, check := range checks {
+		mark := "✅"
+		if !check.OK {
+			mark = "⚠️"
+			failed++
+		}
+
+		line := mark + " " + check.Name
+		if check.Details != "" {
+			line += ": " + check.Details
+		}
+		lines = append(lines, line)
+	}
+
+	summary := fmt.Sprintf("%s integration works in this chat", serviceName)
+	if failed > 0 {
+		summary = fmt.Sprintf("%s integration has %d problem(s) in this chat", serviceName, failed)
+	}
+
+	return summary + "\n\n" + strings.Join(lines, "\n")
+}
+
+// diagnoseBotPermissions checks that the bot can post to the chat
+func (c *Context) diagnoseBotPermissions() diagnosisCheck {
+	check := diagnosisCheck{Name: "Bot permissions"}
+
+	if data, _ := c.Chat.getData(); data != nil && (data.Deactivated || data.BotWasKickedOrStopped()) {
+		check.Details = "bot was removed from the chat or stopped"
+		return check
+	}
+
+	if c.Chat.IsPrivate() {
+		check.OK = true
+		check.Details = "bot can message you"
+		return check
+	}
+
+	member, err := c.GetChatMember(c.Chat.ID, c.Bot().ID)
+	if err != nil {
+		check.Details = "can't get the bot's permissions: " + err.Error()
+		return check
+	}
+
+	switch {
+	case member.HasLeft() || member.WasKicked():
+		check.Details = "bot is not a member of the chat"
+	case member.Status == "restricted" && !member.CanSendMessages:
+		check.Details = "bot is not allowed to send messages"
+	case c.Chat.Type == "channel" && !member.IsCreator() && !member.CanPostMessages:
+		check.Details = "bot needs the admin rights to post in the channel"
+	default:
+		check.OK = true
+		check.Details = "bot can post messages"
+		if member.IsAdministrator() && member.CanPinMessages {
+			check.Details += " and pin them"
+		}
+	}
+	return check
+}
+
+// diagnoseOAuth checks the user's authorization in the service. Returns nil if the service doesn't use OAuth
+func (c *Context) diagnoseOAuth() *diagnosisCheck {
+	s := c.Service()
+	if s.DefaultOAuth1 == nil && s.DefaultOAuth2 == nil {
+		return nil
+	}
+
+	check := &diagnosisCheck{Name: "Authorization"}
+	if c.User.ID != 0 && c.User.OAuthValid() {
+		check.OK = true
+		check.Details = fmt.Sprintf("you are authorized in %s", s.NameToPrint)
+		return check
+	}
+
+	check.Details = fmt.Sprintf("you are not authorized in %s", s.NameToPrint)
+	if url := c.User.OauthInitURL(); url != "" {
+		check.Fix = &diagnosisFix{Text: "🔑 Authorize", URL: url}
+	}
+	return check
+}
+
+// diagnoseWebhooks checks the chat's hooks: pause, the upstream registration and the last received event
+func (c *Context) diagnoseWebhooks() []diagnosisCheck {
+	s := c.Service()
+	tokens, err := c.chatHookTokens()
+	if err != nil {
+		return []diagnosisCheck{{Name: "Webhooks", Details: "can't get the chat's webhooks: " + err.Error()}}
+	}
+
+	if len(tokens) == 0 {
+		return []diagnosisCheck{{Name: "Webhooks", Details: fmt.Sprintf("no webhooks deliver to this chat. Use /start to set up %s", s.NameToPrint)}}
+	}
+
+	var checks []diagnosisCheck
+	for _, token := range tokens {
+		name := "Webhook " + token
+		if len(token) > 4 {
+			name = "Webhook " + token[0:4] + "…"
+		}
+
+		var reconnect *diagnosisFix
+		if s.WebhookReconnectHandler != nil {
+			reconnect = &diagnosisFix{Text: "🔄 Reconnect " + strings.TrimPrefix(name, "Webhook "), Data: diagnoseFixReconnect + token}
+		}
+
+		check := diagnosisCheck{Name: name}
+		if isWebhookPaused(c.db, token) {
+			check.Details = "paused after the failed deliveries"
+			check.Fix = reconnect
+			checks = append(checks, check)
+			continue
+		}
+
+		if s.WebhookStatusHandler != nil {
+			registered, err := s.WebhookStatusHandler(c, Config.BaseURL+"/"+s.Name+"/"+token)
+			if err != nil {
+				check.Details = fmt.Sprintf("can't check the webhook in %s: %s", s.NameToPrint, err.Error())
+				if IsOAuthUnauthorized(err) {
+					check.Details = fmt.Sprintf("can't check the webhook in %s, please authorize", s.NameToPrint)
+				}
+				checks = append(checks, check)
+				continue
+			} else if !registered {
+				check.Details = fmt.Sprintf("not registered in %s", s.NameToPrint)
+				check.Fix = reconnect
+				checks = append(checks, check)
+				continue
+			}
+		}
+
+		var last webhookDelivery
+		err := c.db.C("deliveries").Find(bson.M{"t": token}).Sort("-d").One(&last)
+		if err == mgo.ErrNotFound {
+			check.Details = fmt.Sprintf("no events received for the last %d days", int(webhookDeliveriesTTL.Hours()/24))
+		} else if err != nil {
+			check.Details = "can't get the deliveries: " + err.Error()
+		} else if !last.OK {
+			check.Details = fmt.Sprintf("last event received %s failed: %s", diagnosisAge(time.Since(last.Date)), last.Error)
+		} else {
+			check.OK = true
+			check.Details = "last event received " + diagnosisAge(time.Since(last.Date))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// diagnoseFilters reports the chat's notification filters that may hide the events
+func (c *Context) diagnoseFilters() diagnosisCheck {
+	check := diagnosisCheck{Name: "Notification filters"}
+
+	rules, err := c.Chat.NotificationFilters()
+	if err != nil {
+		check.Details = "can't get the filters: " + err.Error()
+		return check
+	}
+
+	check.OK = true
+	if len(rules) == 0 {
+		check.Details = "all events are delivered"
+		return check
+	}
+
+	check.Details = "only events matching " + strings.Join(rules, "; ") + " are delivered"
+	check.Fix = &diagnosisFix{Text: "🧹 Remove filters", Data: diagnoseFixFiltersOff}
+	return check
+}
+
+// diagnose runs the checks needed for the integration to work in the current chat
+func (c *Context) diagnose() []diagnosisCheck {
+	checks := []diagnosisCheck{c.diagnoseBotPermissions()}
+	if check := c.diagnoseOAuth(); check != nil {
+		checks = append(checks, *check)
+	}
+
+	checks = append(checks, c.diagnoseWebhooks()...)
+	return append(checks, c.diagnoseFilters())
+}
+
+// diagnosisMessage renders the report with the fix-it buttons
+func (c *Context) diagnosisMessage(checks []diagnosisCheck) (string, InlineKeyboard) {
+	buttons := InlineButtons{}
+	for _, check := range checks {
+		if check.Fix == nil {
+			continue
+		}
+
+		if check.Fix.URL != "" {
+			buttons.AddURL(check.Fix.URL, check.Fix.Text)
+		} else {
+			buttons.Append(check.Fix.Data, check.Fix.Text)
+		}
+	}
+	buttons.Append(diagnoseFixRecheck, "🔁 Check again")
+
+	return diagnosisText(c.Service().NameToPrint, checks), buttons.Markup(1, "")
+}
+
+// diagnoseFixAction process the fix-it buttons of the /diagnose report
+func diagnoseFixAction(c *Context) error {
+	data := c.Callback.Data
+	switch {
+	case strings.HasPrefix(data, diagnoseFixReconnect):
+		return webhookReconnectAction(c, strings.TrimPrefix(data, diagnoseFixReconnect))
+	case data == diagnoseFixFiltersOff:
+		if isAdmin, err := c.IsChatAdmin(); err != nil {
+			return err
+		} else if !isAdmin {
+			return c.AnswerCallbackQuery("Only chat admins can change the notification filters", true)
+		}
+
+		err := c.Chat.SetNotificationFilters(nil)
+		if err != nil {
+			return err
+		}
+		c.AnswerCallbackQuery("Notification filters removed", false)
+	}
+
+	text, kb := c.diagnosisMessage(c.diagnose())
+	om := c.Callback.Message
+	return c.EditMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, text, kb)
+}
+
+// handleDiagnoseCommand process '/diagnose' and reports everything needed for the integration to work in the chat. Returns true if message was handled
+func (c *Context) handleDiagnoseCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, _ := c.Message.GetCommand()
+	if cmd != coreCommand(diagnoseCommand) {
+		return false
+	}
+
+	text, kb := c.diagnosisMessage(c.diagnose())
+	err := c.NewMessage().
+		SetReplyToMsgID(c.Message.MsgID).
+		SetText(text).
+		SetParseMode("").
+		DisableWebPreview().
+		applyBrandingFooter().
+		SetInlineKeyboard(kb).
+		SetCallbackAction(diagnoseFixAction).
+		Send()
+
+	if err != nil {
+		c.Log().WithError(err).Error("handleDiagnoseCommand: can't send the report")
+	}
+
+	return true
+}
@@ -0,0 +1,28 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_diagnosisText(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []diagnosisCheck
+		want   string
+	}{
+		{"works", []diagnosisCheck{{Name: "Bot permissions", OK: true, Details: "bot can post messages"}}, "Trello integration works in this chat\n\n✅ Bot permissions: bot can post messages"},
+		{"problems", []diagnosisCheck{{Name: "Bot permissions", OK: true}, {Name: "Webhook c123…", Details: "paused after the failed deliveries"}}, "Trello integration has 1 problem(s) in this chat\n\n✅ Bot permissions\n⚠️ Webhook c123…: paused after the failed deliveries"},
+	}
+	for _, tt := range tests {
+		if got := diagnosisText("Trello", tt.checks); got != tt.want {
+			t.Errorf("%q. diagnosisText() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for d, want := range map[time.Duration]string{time.Second * 5: "just now", time.Minute * 90: "1 h ago", time.Hour * 72: "3 days ago"} {
+		if got := diagnosisAge(d); got != want {
+			t.Errorf("diagnosisAge(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package integram
+
+import (
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the incoming messages text is stored to provide the previous text to TGEditMessageHandler
+const incomingTextTTL = time.Hour * 24 * 7
+
+// incomingText is stored in the "messages_text" collection only for the services with TGEditMessageHandler
+type incomingText struct {
+	ID   string    `bson:"_id"` // botID_chatID_msgID
+	Text string    `bson:"t"`
+	Date time.Time `bson:"d"`
+}
+
+func incomingTextID(botID int64, chatID int64, msgID int) string {
+	return fmt.Sprintf("%d_%d_%d", botID, chatID, msgID)
+}
+
+// incomingMessageText returns the text or the caption of the message
+func incomingMessageText(m *IncomingMessage) string {
+	if m.Text != "" {
+		return m.Text
+	}
+	return m.Caption
+}
+
+// saveIncomingText stores the message's text so it can be compared with the edited one. Edited message replaces the stored text
+func saveIncomingText(db *mgo.Database, m *IncomingMessage) error {
+	text := incomingMessageText(m)
+	if text == "" {
+		return nil
+	}
+
+	_, err := db.C("messages_text").UpsertId(incomingTextID(m.BotID, m.ChatID, m.MsgID), bson.M{"$set": bson.M{"t": text, "d": time.Now()}})
+	return err
+}
+
+// findIncomingText returns the stored text of the message or empty string if it is unknown
+func findIncomingText(db *mgo.Database, botID int64, chatID int64, msgID int) string {
+	var it incomingText
+	err := db.C("messages_text").FindId(incomingTextID(botID, chatID, msgID)).One(&it)
+	if err != nil {
+		return ""
+	}
+	return it.Text
+}
+
+// handleEditedMessage calls the service's TGEditMessageHandler with the previous text in Context.MessagePrevText. Skipped if the message's OnEditAction was already processed
+func (c *Context) handleEditedMessage(service *Service) {
+	if service.TGEditMessageHandler == nil || c.Message.OnEditAction != "" {
+		return
+	}
+
+	c.MessagePrevText = findIncomingText(c.db, c.Message.BotID, c.Message.ChatID, c.Message.MsgID)
+
+	if c.MessagePrevText != "" && c.MessagePrevText == incomingMessageText(c.Message) {
+		// e.g. only the link preview was changed
+		return
+	}
+
+	err := service.TGEditMessageHandler(c)
+	if err != nil {
+		c.Log().WithError(err).Error("BotUpdateHandler edited message error")
+		c.renderServiceError(err)
+	}
+
+	err = saveIncomingText(c.db, c.Message)
+	if err != nil {
+		c.Log().WithError(err).Error("Can't save the edited message text")
+	}
+}
@@ -0,0 +1,31 @@
+package integram
+
+import "testing"
+
+func Test_saveIncomingText(t *testing.T) {
+	id := incomingTextID(1, -9999999999, 100)
+	defer db.C("messages_text").RemoveId(id)
+
+	m := &IncomingMessage{Message: Message{BotID: 1, ChatID: -9999999999, MsgID: 100, Text: "first"}}
+	if err := saveIncomingText(db, m); err != nil {
+		t.Fatalf("saveIncomingText() error = %v", err)
+	}
+
+	if got := findIncomingText(db, 1, -9999999999, 100); got != "first" {
+		t.Errorf("findIncomingText() = %q, want %q", got, "first")
+	}
+
+	m.Text = ""
+	m.Caption = "edited caption"
+	if err := saveIncomingText(db, m); err != nil {
+		t.Fatalf("saveIncomingText() error = %v", err)
+	}
+
+	if got := findIncomingText(db, 1, -9999999999, 100); got != "edited caption" {
+		t.Errorf("findIncomingText() = %q, want %q", got, "edited caption")
+	}
+
+	if got := findIncomingText(db, 1, -9999999999, 101); got != "" {
+		t.Errorf("findIncomingText() for unknown message = %q, want empty", got)
+	}
+}
@@ -0,0 +1,257 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+
+	tg "github.com/requilence/telegram-bot-api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// editMediaInputType returns the type of InputMedia for the editMessageMedia request
+func editMediaInputType(kind FileType) (string, error) {
+	switch kind {
+	case FileTypePhoto, FileTypeVideo, FileTypeAudio, FileTypeDocument:
+		return string(kind), nil
+	}
+	return "", fmt.Errorf("Media of type '%s' can't be edited", kind)
+}
+
+// EditMessageCaption edits the caption of the bot's message with the photo, video or document. Stored message is reverted if Telegram rejects the edit
+func (c *Context) EditMessageCaption(om *OutgoingMessage, caption string) error {
+	if om == nil {
+		return errors.New("Empty message provided")
+	}
+
+	done := c.beginMessageEdit(om)
+	defer done()
+
+	bot := c.Bot()
+	if om.MsgID == 0 {
+		om.ChatID = 0
+	}
+
+	prevTextHash := om.TextHash
+	om.Text = caption
+	om.TextHash = om.GetTextHash()
+
+	if om.TextHash == prevTextHash {
+		c.Log().Debugf("EditMessageCaption – message (_id=%s botid=%v id=%v) not updated caption have not changed", om.ID.Hex(), bot.ID, om.MsgID)
+		return nil
+	}
+
+	var msg OutgoingMessage
+	_, err := c.db.C("messages").Find(bson.M{"_id": om.ID}).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"texthash": om.TextHash}}}, &msg)
+	if err != nil {
+		c.Log().WithError(err).Error("EditMessageCaption messages update error")
+	}
+
+	if msg.BotID == 0 {
+		c.Log().Warn(fmt.Sprintf("EditMessageCaption – message (_id=%s botid=%v id=%v) not found", om.ID.Hex(), bot.ID, om.MsgID))
+		return nil
+	}
+
+	editCaption, parseMode := c.textForEdit(caption, om.ParseMode)
+	_, err = c.sendEdit(bot, tg.EditMessageCaptionConfig{
+		BaseEdit: tg.BaseEdit{
+			ChatID:          om.ChatID,
+			MessageID:       om.MsgID,
+			InlineMessageID: om.InlineMsgID,
+			ReplyMarkup:     &tg.InlineKeyboardMarkup{InlineKeyboard: msg.InlineKeyboardMarkup.tg()},
+		},
+		Caption:   editCaption,
+		ParseMode: parseMode,
+	})
+
+	if err != nil {
+		// Oops. error is occurred – revert the original caption
+		c.db.C("messages").UpdateId(msg.ID, bson.M{"$set": bson.M{"texthash": prevTextHash}})
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", nil, err)
+		return err
+	}
+
+	c.recordMessageHistory(om, messageHistoryOpEditCaption, caption, nil, nil)
+	return nil
+}
+
+// EditMessageMedia replaces the photo, video, audio or document of the bot's message keeping its caption and inline keyboard.
+// The same file sent again by the bot is not uploaded twice. Stored message is reverted if Telegram rejects the edit
+func (c *Context) EditMessageMedia(om *OutgoingMessage, newMedia Attachment) error {
+	if om == nil {
+		return errors.New("Empty message provided")
+	}
+
+	done := c.beginMessageEdit(om)
+	defer done()
+
+	inputType, err := editMediaInputType(newMedia.Kind)
+	if err != nil {
+		return err
+	}
+
+	bot := c.Bot()
+	fileType := mediaGroupFileType(newMedia.Kind)
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	in := mediaGroupInputMedia{Type: inputType, Media: newMedia.FileID}
+	if om.Text != "" {
+		in.Caption, in.ParseMode = c.textForEdit(om.Text, om.ParseMode)
+	}
+
+	var uploadKey string
+	if newMedia.Source != AttachmentSourceFileID {
+		localPath, err := newMedia.LocalFile(c)
+		if err != nil {
+			return err
+		}
+
+		if newMedia.Source != AttachmentSourceLocal {
+			defer os.Remove(localPath)
+		}
+
+		err = c.scanFile(localPath, newMedia.Name, fileScanToChat)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		uploadKey, err = uploadedFileKey(bot.ID, fileType, newMedia.Name, f)
+		if err != nil {
+			return err
+		}
+
+		if in.Media = findUploadedFileID(c.db, uploadKey); in.Media == "" {
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+
+			name := newMedia.Name
+			if name == "" {
+				name = "file"
+			}
+
+			part, err := w.CreateFormFile("file", name)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(part, f)
+			if err != nil {
+				return err
+			}
+
+			in.Media = "attach://file"
+		}
+	}
+
+	mediaJSON, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	if om.MsgID != 0 {
+		w.WriteField("chat_id", strconv.FormatInt(om.ChatID, 10))
+		w.WriteField("message_id", strconv.Itoa(om.MsgID))
+	} else {
+		w.WriteField("inline_message_id", om.InlineMsgID)
+	}
+	w.WriteField("media", string(mediaJSON))
+
+	var msg OutgoingMessage
+	_, err = c.db.C("messages").Find(bson.M{"_id": om.ID}).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"filetype": fileType, "filename": newMedia.Name}}}, &msg)
+	if err != nil {
+		c.Log().WithError(err).Error("EditMessageMedia messages update error")
+	}
+
+	if msg.BotID == 0 {
+		c.Log().Warn(fmt.Sprintf("EditMessageMedia – message (_id=%s botid=%v id=%v) not found", om.ID.Hex(), bot.ID, om.MsgID))
+		return nil
+	}
+
+	if len(msg.InlineKeyboardMarkup.Buttons) > 0 {
+		kbJSON, err := json.Marshal(tg.InlineKeyboardMarkup{InlineKeyboard: msg.InlineKeyboardMarkup.tg()})
+		if err != nil {
+			return err
+		}
+		w.WriteField("reply_markup", string(kbJSON))
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	tgMsg, err := editMessageMediaRequest(bot, body, w.FormDataContentType())
+	if err != nil {
+		// Oops. error is occurred – revert the original media
+		c.db.C("messages").UpdateId(msg.ID, bson.M{"$set": bson.M{"filetype": msg.FileType, "filename": msg.FileName, "fileid": msg.FileID}})
+		c.recordMessageHistory(om, messageHistoryOpRevert, "", nil, err)
+		return err
+	}
+
+	om.FileType = fileType
+	om.FileName = newMedia.Name
+	om.FilePath = ""
+	om.FileID = newMedia.FileID
+	if tgMsg != nil {
+		if fileID := uploadedFileID(tgMsg); fileID != "" {
+			om.FileID = fileID
+		}
+	}
+
+	if uploadKey != "" && om.FileID != "" {
+		err := saveUploadedFileID(c.db, uploadKey, bot.ID, om.FileID)
+		if err != nil {
+			c.Log().WithError(err).Error("Can't save the uploaded file's file_id")
+		}
+	}
+
+	err = c.db.C("messages").UpdateId(msg.ID, bson.M{"$set": bson.M{"fileid": om.FileID}})
+	if err != nil {
+		c.Log().WithError(err).Error("EditMessageMedia can't save the file_id")
+	}
+
+	c.recordMessageHistory(om, messageHistoryOpEditMedia, newMedia.Name, nil, nil)
+	return nil
+}
+
+// editMessageMediaRequest sends the multipart editMessageMedia request. Returns nil message when the inline message was edited
+func editMessageMediaRequest(bot *Bot, body io.Reader, contentType string) (*tg.Message, error) {
+	resp, err := bot.API.Client.Post(fmt.Sprintf(tg.APIEndpoint, bot.API.Token, "editMessageMedia"), contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp tg.APIResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiResp.Ok {
+		return nil, tg.Error{Code: apiResp.ErrorCode, Message: apiResp.Description, Parameters: apiResp.Parameters}
+	}
+
+	var msg tg.Message
+	if json.Unmarshal(apiResp.Result, &msg) != nil || msg.MessageID == 0 {
+		// result is true for the inline messages
+		return nil, nil
+	}
+	return &msg, nil
+}
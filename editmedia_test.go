@@ -0,0 +1,24 @@
+package integram
+
+import "testing"
+
+func Test_editMediaInputType(t *testing.T) {
+	tests := []struct {
+		kind    FileType
+		want    string
+		wantErr bool
+	}{
+		{FileTypePhoto, "photo", false},
+		{FileTypeVideo, "video", false},
+		{FileTypeDocument, "document", false},
+		{FileTypeAudio, "audio", false},
+		{FileTypeSticker, "", true},
+		{FileTypeVoice, "", true},
+	}
+	for _, tt := range tests {
+		got, err := editMediaInputType(tt.kind)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("editMediaInputType(%s) = %q, %v, want %q", tt.kind, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package integram
+
+import (
+	"strconv"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// messageEditQueue serializes the edits of the same message in the order they were requested
+type messageEditQueue struct {
+	next    uint64 // ticket of the next requested edit
+	serving uint64 // ticket of the edit being applied
+	cond    *sync.Cond
+}
+
+var messageEditQueuesMutex = sync.Mutex{}
+var messageEditQueues = make(map[string]*messageEditQueue)
+
+// messageEditKey returns the key of the message's edit queue
+func messageEditKey(om *OutgoingMessage) string {
+	if om.ID != "" {
+		return om.ID.Hex()
+	}
+
+	if om.InlineMsgID != "" {
+		return om.InlineMsgID
+	}
+	return strconv.FormatInt(om.BotID, 10) + ":" + strconv.FormatInt(om.ChatID, 10) + ":" + strconv.Itoa(om.MsgID)
+}
+
+// waitMessageEdit waits until the previous edits of the message with the key are applied. Returns the func to let the next edit proceed
+func waitMessageEdit(key string) func() {
+	messageEditQueuesMutex.Lock()
+	q, exists := messageEditQueues[key]
+	if !exists {
+		q = &messageEditQueue{cond: sync.NewCond(&messageEditQueuesMutex)}
+		messageEditQueues[key] = q
+	}
+
+	ticket := q.next
+	q.next++
+	for q.serving != ticket {
+		q.cond.Wait()
+	}
+	messageEditQueuesMutex.Unlock()
+
+	return func() {
+		messageEditQueuesMutex.Lock()
+		defer messageEditQueuesMutex.Unlock()
+
+		q.serving++
+		if q.serving == q.next {
+			delete(messageEditQueues, key)
+			return
+		}
+		q.cond.Broadcast()
+	}
+}
+
+// beginMessageEdit waits for the concurrent edits of the message to finish and merges their result into om,
+// so the edit is applied on top of the stored text and keyboard instead of overwriting them. Returns the func that must be called after the edit
+func (c *Context) beginMessageEdit(om *OutgoingMessage) func() {
+	done := waitMessageEdit(messageEditKey(om))
+
+	if om.ID == "" || c.db == nil {
+		return done
+	}
+
+	var stored OutgoingMessage
+	err := c.db.C("messages").FindId(om.ID).Select(bson.M{"texthash": 1, "inlinekeyboardmarkup": 1}).One(&stored)
+	if err == nil {
+		om.TextHash = stored.TextHash
+		om.InlineKeyboardMarkup = stored.InlineKeyboardMarkup
+	}
+
+	return done
+}
@@ -0,0 +1,71 @@
+package integram
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_messageEditKey(t *testing.T) {
+	id := bson.NewObjectId()
+	tests := []struct {
+		name string
+		om   OutgoingMessage
+		want string
+	}{
+		{"stored", OutgoingMessage{Message: Message{ID: id, MsgID: 5}}, id.Hex()},
+		{"inline", OutgoingMessage{Message: Message{InlineMsgID: "AAQ"}}, "AAQ"},
+		{"chat message", OutgoingMessage{Message: Message{BotID: 1, ChatID: -2, MsgID: 3}}, "1:-2:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageEditKey(&tt.om); got != tt.want {
+				t.Errorf("messageEditKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waitMessageEdit(t *testing.T) {
+	var applied []int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	done := waitMessageEdit("msg")
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next := waitMessageEdit("msg")
+			mu.Lock()
+			applied = append(applied, i)
+			mu.Unlock()
+			next()
+		}(i)
+		// let the edit take its place in the queue
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	mu.Lock()
+	if len(applied) != 0 {
+		t.Errorf("waitMessageEdit() edits applied before the previous finished: %v", applied)
+	}
+	mu.Unlock()
+
+	done()
+	wg.Wait()
+
+	for i, n := range applied {
+		if n != i+1 {
+			t.Fatalf("waitMessageEdit() edits applied out of order: %v", applied)
+		}
+	}
+
+	messageEditQueuesMutex.Lock()
+	defer messageEditQueuesMutex.Unlock()
+	if _, exists := messageEditQueues["msg"]; exists {
+		t.Errorf("waitMessageEdit() queue isn't removed after the last edit")
+	}
+}
@@ -88,3 +88,35 @@ func verifyTypeMatching(handlerFunc interface{}, args ...interface{}) error {
 	}
 	return nil
 }
+
+// callHandler calls the action handler with the context prepended to the args
+func callHandler(ctx *Context, handlerFunc interface{}, args ...interface{}) error {
+	handlerArgs := make([]reflect.Value, len(args)+1)
+	handlerArgs[0] = reflect.ValueOf(ctx)
+	for i, arg := range args {
+		handlerArgs[i+1] = reflect.ValueOf(arg)
+	}
+
+	returnVals := reflect.ValueOf(handlerFunc).Call(handlerArgs)
+	if !returnVals[0].IsNil() {
+		return returnVals[0].Interface().(error)
+	}
+	return nil
+}
+
+// callEncodedHandler decodes the gob encoded args and calls the action handler
+func callEncodedHandler(ctx *Context, handlerFunc interface{}, data []byte) error {
+	handlerType := reflect.TypeOf(handlerFunc)
+	handlerArgsInterfaces := make([]interface{}, handlerType.NumIn()-1)
+
+	for i := 1; i < handlerType.NumIn(); i++ {
+		dataVal := reflect.New(handlerType.In(i))
+		handlerArgsInterfaces[i-1] = dataVal.Interface()
+	}
+
+	if err := decode(data, &handlerArgsInterfaces); err != nil {
+		return err
+	}
+
+	return callHandler(ctx, handlerFunc, handlerArgsInterfaces...)
+}
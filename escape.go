@@ -0,0 +1,74 @@
+package integram
+
+import (
+	"fmt"
+	"strings"
+)
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// safeText is the text set with SetSafeTextFmt. Args are escaped when the message is sent, so the parse mode can be changed after
+type safeText struct {
+	format string
+	args   []interface{}
+}
+
+// EscapeHTML escapes '&', '<', '>' and '"', so the text is shown as is with the HTML parse mode
+func EscapeHTML(s string) string {
+	return htmlEscaper.Replace(s)
+}
+
+// EscapeMarkdown escapes '_', '*', '`' and '[' with the backslash, so the text is shown as is with the Markdown parse mode
+func EscapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// escapeForParseMode escapes the text for the parse mode. Text is returned as is for the plain text
+func escapeForParseMode(parseMode string, s string) string {
+	switch strings.ToLower(parseMode) {
+	case "html":
+		return EscapeHTML(s)
+	case "markdown":
+		return EscapeMarkdown(s)
+	case "markdownv2":
+		return EscapeMarkdownV2(s)
+	}
+	return s
+}
+
+// escapeFmtArgs returns the copy of the args with the strings, errors and fmt.Stringers escaped for the parse mode. Other values are kept to be formatted with the verbs like %d
+func escapeFmtArgs(parseMode string, args []interface{}) []interface{} {
+	res := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			res[i] = escapeForParseMode(parseMode, v)
+		case error:
+			res[i] = escapeForParseMode(parseMode, v.Error())
+		case fmt.Stringer:
+			res[i] = escapeForParseMode(parseMode, v.String())
+		default:
+			res[i] = arg
+		}
+	}
+	return res
+}
+
+// SetSafeTextFmt sets the text formatted like fmt.Sprintf. Args are escaped for the message's parse mode when it is sent,
+// so the upstream values can't break the message while the formatting of the format itself is kept
+func (m *OutgoingMessage) SetSafeTextFmt(format string, a ...interface{}) *OutgoingMessage {
+	m.safeText = &safeText{format: format, args: a}
+	m.Text = fmt.Sprintf(format, a...)
+	return m
+}
+
+// applySafeText renders the text set with SetSafeTextFmt for the current parse mode
+func (m *OutgoingMessage) applySafeText() {
+	if m.safeText == nil {
+		return
+	}
+
+	m.Text = fmt.Sprintf(m.safeText.format, escapeFmtArgs(m.ParseMode, m.safeText.args)...)
+	m.safeText = nil
+}
@@ -0,0 +1,102 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+)
+
+type escapeTestStringer struct{}
+
+func (escapeTestStringer) String() string { return "a<b>" }
+
+func TestEscapeHTML(t *testing.T) {
+	if got := EscapeHTML(`<b>Tom & "Jerry"</b>`); got != "&lt;b&gt;Tom &amp; &quot;Jerry&quot;&lt;/b&gt;" {
+		t.Errorf("EscapeHTML() = %v", got)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	if got := EscapeMarkdown("snake_case *bold* `code` [link](url)"); got != "snake\\_case \\*bold\\* \\`code\\` \\[link](url)" {
+		t.Errorf("EscapeMarkdown() = %v", got)
+	}
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	text := "v1.2-rc (build #3) _a_ *b* ~c~ `d` [e]! a>b {f}=g|h+ \\"
+	escaped := EscapeMarkdownV2(text)
+	if escaped != "v1\\.2\\-rc \\(build \\#3\\) \\_a\\_ \\*b\\* \\~c\\~ \\`d\\` \\[e\\]\\! a\\>b \\{f\\}\\=g\\|h\\+ \\\\" {
+		t.Errorf("EscapeMarkdownV2() = %v", escaped)
+	}
+
+	// escaped text is parsed by Telegram back to the original one
+	if plain, entities, err := parseMarkdownV2Entities(escaped); err != nil || plain != text || len(entities) > 0 {
+		t.Errorf("parseMarkdownV2Entities(EscapeMarkdownV2()) = %q, %v, %v", plain, entities, err)
+	}
+
+	if got := EscapeMarkdownV2Code("a`b\\c.d"); got != "a\\`b\\\\c.d" {
+		t.Errorf("EscapeMarkdownV2Code() = %v", got)
+	}
+
+	if got := EscapeMarkdownV2URL("https://en.wikipedia.org/wiki/Go_(language)"); got != "https://en.wikipedia.org/wiki/Go_(language\\)" {
+		t.Errorf("EscapeMarkdownV2URL() = %v", got)
+	}
+}
+
+func TestContext_textForEdit(t *testing.T) {
+	c := &Context{}
+	tests := []struct {
+		name          string
+		text          string
+		parseMode     string
+		wantText      string
+		wantParseMode string
+	}{
+		{"html", "<b>a.b</b>", "HTML", "<b>a.b</b>", "HTML"},
+		{"markdownv2", "*a\\.b*", ParseModeMarkdownV2, "*a\\.b*", ParseModeMarkdownV2},
+		{"broken markdownv2", "*a\\.b* v1.2", ParseModeMarkdownV2, "*a.b* v1.2", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, parseMode := c.textForEdit(tt.text, tt.parseMode)
+			if text != tt.wantText || parseMode != tt.wantParseMode {
+				t.Errorf("Context.textForEdit() = %q, %q, want %q, %q", text, parseMode, tt.wantText, tt.wantParseMode)
+			}
+		})
+	}
+}
+
+func TestOutgoingMessage_SetSafeTextFmt(t *testing.T) {
+	tests := []struct {
+		name      string
+		parseMode string
+		format    string
+		args      []interface{}
+		want      string
+	}{
+		{"HTML", "HTML", "<b>%s</b> opened #%d", []interface{}{"<script> & co", 42}, "<b>&lt;script&gt; &amp; co</b> opened #42"},
+		{"Markdown", "Markdown", "*%s* failed: %v", []interface{}{"build_all", errors.New("exit *1*")}, "*build\\_all* failed: exit \\*1\\*"},
+		{"MarkdownV2", ParseModeMarkdownV2, "*%s* released", []interface{}{"v1.2"}, "*v1\\.2* released"},
+		{"stringer", "HTML", "%s", []interface{}{escapeTestStringer{}}, "a&lt;b&gt;"},
+		{"plain text", "", "%s_%s", []interface{}{"<a>", "*b*"}, "<a>_*b*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &OutgoingMessage{}
+			m.SetSafeTextFmt(tt.format, tt.args...)
+			// parse mode may be changed after the text is set
+			m.SetParseMode(tt.parseMode)
+			m.applySafeText()
+
+			if m.Text != tt.want {
+				t.Errorf("SetSafeTextFmt() text = %q, want %q", m.Text, tt.want)
+			}
+		})
+	}
+
+	m := &OutgoingMessage{ParseMode: "HTML"}
+	m.SetSafeTextFmt("%s", "<i>").SetText("<b>plain</b>")
+	m.applySafeText()
+	if m.Text != "<b>plain</b>" {
+		t.Errorf("SetText() after SetSafeTextFmt() text = %q", m.Text)
+	}
+}
@@ -0,0 +1,110 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+
+	mgo "gopkg.in/mgo.v2"
+)
+
+// EventTransition is the upstream event's state change, e.g. issue opened, commented or closed
+type EventTransition string
+
+// Transitions of the event lifecycle
+const (
+	EventCreated EventTransition = "created"
+	EventUpdated EventTransition = "updated"
+	EventClosed  EventTransition = "closed"
+)
+
+// EventUpdateMode defines how the chat receives the updates of the event that was already posted
+type EventUpdateMode string
+
+// Update modes that can be set per chat with SetEventUpdateMode
+const (
+	EventUpdateEdit  EventUpdateMode = "edit"  // edit the existing message. Default one
+	EventUpdateReply EventUpdateMode = "reply" // send the follow-up message in reply to the existing one
+	EventUpdateNew   EventUpdateMode = "new"   // send the separate message
+)
+
+// chat's setting key to store EventUpdateMode
+const eventUpdateModeSettingKey = "eventupdatemode"
+
+// EventUpdateMode returns the chat's mode to deliver updates of already posted events
+func (chat *Chat) EventUpdateMode() EventUpdateMode {
+	v, exists := chat.Setting(eventUpdateModeSettingKey)
+	if !exists {
+		return EventUpdateEdit
+	}
+
+	mode, _ := v.(string)
+	switch EventUpdateMode(mode) {
+	case EventUpdateReply, EventUpdateNew:
+		return EventUpdateMode(mode)
+	}
+	return EventUpdateEdit
+}
+
+// SetEventUpdateMode sets the chat's mode to deliver updates of already posted events
+func (chat *Chat) SetEventUpdateMode(mode EventUpdateMode) error {
+	switch mode {
+	case EventUpdateEdit, EventUpdateReply, EventUpdateNew:
+	default:
+		return fmt.Errorf("Unknown event update mode '%s'", mode)
+	}
+
+	return chat.SaveSetting(eventUpdateModeSettingKey, string(mode))
+}
+
+// eventAction returns how the transition must be delivered to the chat, depending on whether the event message exists
+func eventAction(transition EventTransition, exists bool, mode EventUpdateMode) EventUpdateMode {
+	if !exists {
+		return EventUpdateNew
+	}
+
+	if transition == EventCreated {
+		// duplicate delivery of the same event
+		return ""
+	}
+
+	return mode
+}
+
+// PostEvent delivers the event's transition to the current chat. The core decides whether to post the new message, edit the existing one or reply to it according to the chat's EventUpdateMode.
+// msg must be created with Context.NewMessage(). Returns nil without sending in case the created event was already posted
+func (c *Context) PostEvent(eventID string, transition EventTransition, msg *OutgoingMessage) error {
+	if eventID == "" {
+		return errors.New("PostEvent: eventID is empty")
+	}
+
+	if msg == nil {
+		return errors.New("PostEvent: message is nil")
+	}
+
+	existing, err := c.FindMessageByEventID(eventID)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	exists := existing != nil && existing.om != nil && existing.MsgID != 0
+
+	mode := EventUpdateEdit
+	if exists && transition != EventCreated {
+		mode = c.Chat.EventUpdateMode()
+	}
+
+	switch eventAction(transition, exists, mode) {
+	case EventUpdateEdit:
+		om := existing.om
+		om.ParseMode = msg.ParseMode
+		om.WebPreview = msg.WebPreview
+		return c.EditMessageTextAndInlineKeyboard(om, "", msg.Text, msg.InlineKeyboardMarkup)
+	case EventUpdateReply:
+		return msg.SetChat(c.Chat.ID).SetReplyToMsgID(existing.MsgID).AddEventID(eventID).Send()
+	case EventUpdateNew:
+		return msg.SetChat(c.Chat.ID).AddEventID(eventID).Send()
+	}
+
+	c.Log().WithField("eventid", eventID).Debug("PostEvent: event is already posted")
+	return nil
+}
@@ -0,0 +1,50 @@
+package integram
+
+import "testing"
+
+func Test_eventAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		transition EventTransition
+		exists     bool
+		mode       EventUpdateMode
+		want       EventUpdateMode
+	}{
+		{"created", EventCreated, false, EventUpdateEdit, EventUpdateNew},
+		{"created duplicate", EventCreated, true, EventUpdateEdit, ""},
+		{"updated edit", EventUpdated, true, EventUpdateEdit, EventUpdateEdit},
+		{"updated reply", EventUpdated, true, EventUpdateReply, EventUpdateReply},
+		{"closed new", EventClosed, true, EventUpdateNew, EventUpdateNew},
+		{"updated not posted", EventUpdated, false, EventUpdateReply, EventUpdateNew},
+	}
+	for _, tt := range tests {
+		if got := eventAction(tt.transition, tt.exists, tt.mode); got != tt.want {
+			t.Errorf("%q. eventAction() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChat_EventUpdateMode(t *testing.T) {
+	clearData()
+	defer clearData()
+
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	ctx.Chat = Chat{ID: -9999999999, ctx: ctx}
+
+	if got := ctx.Chat.EventUpdateMode(); got != EventUpdateEdit {
+		t.Errorf("EventUpdateMode() = %v, want default %v", got, EventUpdateEdit)
+	}
+
+	if err := ctx.Chat.SetEventUpdateMode("unknown"); err == nil {
+		t.Error("SetEventUpdateMode() with unknown mode should return error")
+	}
+
+	if err := ctx.Chat.SetEventUpdateMode(EventUpdateReply); err != nil {
+		t.Fatalf("SetEventUpdateMode() error = %v", err)
+	}
+
+	ctx.Chat.data = nil
+	if got := ctx.Chat.EventUpdateMode(); got != EventUpdateReply {
+		t.Errorf("EventUpdateMode() = %v, want %v", got, EventUpdateReply)
+	}
+}
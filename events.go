@@ -0,0 +1,173 @@
+package integram
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Types of the chat events
+const (
+	ChatEventMessageSent     = "message_sent"     // message is accepted for the delivery
+	ChatEventButtonPressed   = "button_pressed"   // inline button is pressed
+	ChatEventWebhookReceived = "webhook_received" // service's webhook is processed for the chat
+)
+
+// events are dropped for the slow subscriber when its buffer is full
+const chatEventsBufferSize = 100
+
+// interval to send the keep-alive comment to the idle stream
+const chatEventsKeepAliveInterval = time.Second * 30
+
+// ChatEvent is the sanitized event streamed to the external dashboards. It doesn't contain the texts and the users' data
+type ChatEvent struct {
+	Type      string    `json:"type"`
+	Service   string    `json:"service"`
+	ChatID    int64     `json:"chat_id"`
+	At        time.Time `json:"at"`
+	MessageID string    `json:"message_id,omitempty"` // ID of the stored message
+	Ref       string    `json:"ref,omitempty"`        // see MessageRef
+	EventID   string    `json:"event_id,omitempty"`   // message's first event ID
+	Button    string    `json:"button,omitempty"`     // hash of the pressed button's data, because the data may contain the tokens
+}
+
+type chatEventsSubscriber struct {
+	service string
+	chatID  int64 // 0 to receive the events for all the service's chats
+	ch      chan ChatEvent
+}
+
+var chatEventsMutex = sync.RWMutex{}
+var chatEventsSubscribers = make(map[*chatEventsSubscriber]struct{})
+
+func subscribeChatEvents(service string, chatID int64) *chatEventsSubscriber {
+	s := &chatEventsSubscriber{service: service, chatID: chatID, ch: make(chan ChatEvent, chatEventsBufferSize)}
+
+	chatEventsMutex.Lock()
+	chatEventsSubscribers[s] = struct{}{}
+	chatEventsMutex.Unlock()
+
+	return s
+}
+
+func (s *chatEventsSubscriber) unsubscribe() {
+	chatEventsMutex.Lock()
+	delete(chatEventsSubscribers, s)
+	chatEventsMutex.Unlock()
+}
+
+// publishChatEvent delivers the event to the subscribers without blocking
+func publishChatEvent(e ChatEvent) {
+	chatEventsMutex.RLock()
+	defer chatEventsMutex.RUnlock()
+
+	for s := range chatEventsSubscribers {
+		if s.service != e.Service || s.chatID != 0 && s.chatID != e.ChatID {
+			continue
+		}
+
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+// publishChatEvent publishes the event for the current chat
+func (c *Context) publishChatEvent(eventType string, om *OutgoingMessage, button string) {
+	chatID := c.Chat.ID
+	if chatID == 0 {
+		chatID = c.User.ID
+	}
+
+	e := ChatEvent{Type: eventType, Service: c.ServiceName, ChatID: chatID, At: time.Now()}
+	if button != "" {
+		e.Button = compactHash(button)
+	}
+	if om != nil {
+		if om.ChatID != 0 {
+			e.ChatID = om.ChatID
+		}
+		if om.ID.Valid() {
+			e.MessageID = om.ID.Hex()
+		}
+		if len(om.EventID) > 0 {
+			e.EventID = om.EventID[0]
+		}
+		if om.MsgID != 0 || len(om.EventID) > 0 {
+			e.Ref = MessageRef{Service: c.ServiceName, ChatID: e.ChatID, MsgID: om.MsgID, EventID: e.EventID}.String()
+		}
+	}
+
+	publishChatEvent(e)
+}
+
+// publishSentEvent publishes ChatEventMessageSent for the message
+func (m *OutgoingMessage) publishSentEvent() {
+	if m.ctx != nil {
+		m.ctx.publishChatEvent(ChatEventMessageSent, m, "")
+		return
+	}
+
+	bot := botByID(m.BotID)
+	if bot == nil || len(bot.services) == 0 {
+		return
+	}
+
+	ctx := &Context{ServiceName: bot.services[0].Name}
+	ctx.publishChatEvent(ChatEventMessageSent, m, "")
+}
+
+// streamChatEvents streams the events as Server-Sent Events until the client disconnects
+func streamChatEvents(c *gin.Context, service string, chatID int64) {
+	s := subscribeChatEvents(service, chatID)
+	defer s.unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepAlive := time.NewTicker(chatEventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	done := c.Request.Context().Done()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-s.ch:
+			c.SSEvent(e.Type, e)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-done:
+			return false
+		}
+		return true
+	})
+}
+
+// serviceEventsHandler serves GET /api/v1/services/:service/events authorized with INTEGRAM_ADMIN_TOKEN in the X-Integram-Admin-Token header.
+// Token isn't accepted in the query, so it doesn't leak to the access logs of the long-living stream
+func serviceEventsHandler(c *gin.Context, service string) {
+	token := c.Request.Header.Get("X-Integram-Admin-Token")
+
+	if Config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(Config.AdminToken)) != 1 {
+		c.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	s, _ := serviceByName(service)
+	if s == nil {
+		c.String(http.StatusNotFound, "Service not found")
+		return
+	}
+
+	if Config.IsMainInstance() {
+		proxy := reverseProxyForService(s.Name)
+		proxy.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	streamChatEvents(c, s.Name, 0)
+}
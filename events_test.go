@@ -0,0 +1,52 @@
+package integram
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_publishChatEvent(t *testing.T) {
+	chatSub := subscribeChatEvents("servicewithbottoken", -9999999999)
+	defer chatSub.unsubscribe()
+	serviceSub := subscribeChatEvents("servicewithbottoken", 0)
+	defer serviceSub.unsubscribe()
+
+	ctx := &Context{ServiceName: "servicewithbottoken"}
+	ctx.Chat = Chat{ID: -9999999999, ctx: ctx}
+
+	om := &OutgoingMessage{Message: Message{ID: bson.NewObjectId(), ChatID: -9999999999, EventID: []string{"issue_1", "issue_2"}}}
+	ctx.publishChatEvent(ChatEventButtonPressed, om, "close")
+
+	other := &Context{ServiceName: "servicewithbottoken"}
+	other.Chat = Chat{ID: -9999999998, ctx: other}
+	other.publishChatEvent(ChatEventWebhookReceived, nil, "")
+
+	(&Context{ServiceName: "servicewithoauth2", Chat: Chat{ID: -9999999999}}).publishChatEvent(ChatEventWebhookReceived, nil, "")
+
+	if len(chatSub.ch) != 1 {
+		t.Fatalf("chat subscriber received %d events, want 1", len(chatSub.ch))
+	}
+
+	e := <-chatSub.ch
+	if e.Type != ChatEventButtonPressed || e.ChatID != -9999999999 || e.MessageID != om.ID.Hex() || e.EventID != "issue_1" || e.Button != compactHash("close") {
+		t.Errorf("chat subscriber received %+v", e)
+	}
+
+	if len(serviceSub.ch) != 2 {
+		t.Errorf("service subscriber received %d events, want 2", len(serviceSub.ch))
+	}
+}
+
+func Test_publishChatEvent_slowSubscriber(t *testing.T) {
+	sub := subscribeChatEvents("servicewithbottoken", -9999999999)
+	defer sub.unsubscribe()
+
+	for i := 0; i < chatEventsBufferSize+10; i++ {
+		publishChatEvent(ChatEvent{Type: ChatEventMessageSent, Service: "servicewithbottoken", ChatID: -9999999999})
+	}
+
+	if len(sub.ch) != chatEventsBufferSize {
+		t.Errorf("subscriber buffered %d events, want %d", len(sub.ch), chatEventsBufferSize)
+	}
+}
@@ -0,0 +1,295 @@
+package integram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecDefaultTimeout is the default time limit for the exec service's program
+const ExecDefaultTimeout = time.Second * 10
+
+// max length of program's stderr to include into the error
+const execStderrLimit = 1024
+
+// max size of program's stdout, the program is stopped when it's exceeded
+const execStdoutLimit = 1 << 20
+
+// variables of the integram's environment passed to the program, the rest may contain the secrets like the bot tokens
+var execInheritedEnv = []string{"PATH", "HOME", "LANG"}
+
+var errExecStdoutTooLarge = fmt.Errorf("stdout exceeds %d bytes", execStdoutLimit)
+
+// ExecService is the config of service which webhooks are handled by the external program.
+// Webhook is piped to the program's stdin as ExecInput JSON and the program's stdout must contain ExecOutput JSON.
+// It allows to write the integrations in any language, e.g.:
+//
+//	integram.Register(integram.ExecService{Name: "jenkins", Command: "/opt/hooks/jenkins.py"}, os.Getenv("INTEGRAM_BOT_TOKEN"))
+type ExecService struct {
+	Name        string   // Service lowercase name
+	NameToPrint string   // Service print name
+	Command     string   // path to the executable
+	Args        []string // additional arguments for the command
+	Env         []string // environment variables in the "KEY=value" form. Only PATH, HOME and LANG are inherited
+	Timeout     time.Duration
+}
+
+// ExecInput is the JSON piped to the exec service's program stdin
+type ExecInput struct {
+	Service string              `json:"service"`
+	Chat    ExecChat            `json:"chat"`
+	User    ExecUser            `json:"user"`
+	Method  string              `json:"method"`
+	Headers map[string][]string `json:"headers"`
+	Query   map[string][]string `json:"query"`
+	Body    json.RawMessage     `json:"body,omitempty"`     // set if the webhook's body is a valid JSON
+	BodyRaw string              `json:"body_raw,omitempty"` // set otherwise
+}
+
+// ExecChat is the chat the webhook will be delivered to
+type ExecChat struct {
+	ID    int64  `json:"id"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// ExecUser is the owner of the webhook
+type ExecUser struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	UserName  string `json:"username,omitempty"`
+}
+
+// ExecOutput is the JSON the exec service's program must print to stdout. Empty stdout means nothing to send
+type ExecOutput struct {
+	Messages []ExecMessage `json:"messages"`
+}
+
+// ExecMessage is the message to send to the chat
+type ExecMessage struct {
+	Text              string          `json:"text"`
+	Format            string          `json:"format,omitempty"` // "html", "markdown", "markdownv2" or empty for the plain text
+	DisableWebPreview bool            `json:"disable_web_preview,omitempty"`
+	Silent            bool            `json:"silent,omitempty"`
+	Buttons           [][]ExecButton  `json:"buttons,omitempty"` // rows of inline URL buttons
+	Attachment        *ExecAttachment `json:"attachment,omitempty"`
+}
+
+// ExecButton is the inline URL button
+type ExecButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+// ExecAttachment is the file to download from URL and send
+type ExecAttachment struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"`
+	Kind string `json:"kind,omitempty"` // "photo" or "document"(default)
+}
+
+// Service produce the service for the exec config
+func (e ExecService) Service() *Service {
+	nameToPrint := e.NameToPrint
+	if nameToPrint == "" {
+		nameToPrint = e.Name
+	}
+
+	return &Service{
+		Name:           e.Name,
+		NameToPrint:    nameToPrint,
+		WebhookHandler: e.webhookHandler,
+	}
+}
+
+func (e ExecService) webhookHandler(c *Context, wc *WebhookContext) error {
+	input, err := execInputFromWebhook(c, wc)
+	if err != nil {
+		return err
+	}
+
+	output, err := e.run(input)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range output.Messages {
+		om, err := m.outgoingMessage(c)
+		if err != nil {
+			return err
+		}
+
+		err = om.Send()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func execInputFromWebhook(c *Context, wc *WebhookContext) (*ExecInput, error) {
+	body, err := wc.RAW()
+	if err != nil {
+		return nil, err
+	}
+
+	input := ExecInput{
+		Service: c.ServiceName,
+		Chat:    ExecChat{ID: c.Chat.ID, Type: c.Chat.Type, Title: c.Chat.Title},
+		User:    ExecUser{ID: c.User.ID, FirstName: c.User.FirstName, LastName: c.User.LastName, UserName: c.User.UserName},
+		Method:  wc.Request().Method,
+		Headers: wc.Headers(),
+		Query:   wc.Request().URL.Query(),
+	}
+
+	if body != nil && json.Valid(*body) {
+		input.Body = json.RawMessage(*body)
+	} else if body != nil {
+		input.BodyRaw = string(*body)
+	}
+
+	return &input, nil
+}
+
+// run executes the command with input piped to stdin and parses its stdout
+func (e ExecService) run(input *ExecInput) (*ExecOutput, error) {
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = ExecDefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = execEnv(e.Env)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	stdout := limitedBuffer{limit: execStdoutLimit}
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s: timeout after %s", e.Command, timeout)
+	}
+
+	if stdout.exceeded {
+		return nil, fmt.Errorf("%s: %s", e.Command, errExecStdoutTooLarge.Error())
+	}
+
+	if err != nil {
+		errText := strings.TrimSpace(stderr.String())
+		if len(errText) > execStderrLimit {
+			errText = errText[0:execStderrLimit]
+		}
+		return nil, fmt.Errorf("%s: %s: %s", e.Command, err.Error(), errText)
+	}
+
+	return parseExecOutput(stdout.Bytes())
+}
+
+// execEnv returns the program's environment: the inherited variables overridden by the service's ones
+func execEnv(env []string) []string {
+	var res []string
+	for _, key := range execInheritedEnv {
+		if value, exists := os.LookupEnv(key); exists {
+			res = append(res, key+"="+value)
+		}
+	}
+	return append(res, env...)
+}
+
+// limitedBuffer fails the write exceeding the limit, so the program flooding the stdout is stopped with the broken pipe.
+// Buffer isn't embedded to hide its ReadFrom from io.Copy
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		b.exceeded = true
+		return 0, errExecStdoutTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func parseExecOutput(b []byte) (*ExecOutput, error) {
+	output := ExecOutput{}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return &output, nil
+	}
+
+	err := json.Unmarshal(b, &output)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse the program's output: %s", err.Error())
+	}
+
+	return &output, nil
+}
+
+func (m ExecMessage) outgoingMessage(c *Context) (*OutgoingMessage, error) {
+	if m.Text == "" && m.Attachment == nil {
+		return nil, errors.New("message must have text or attachment")
+	}
+
+	om := c.NewMessage().SetText(m.Text).SetSilent(m.Silent)
+
+	switch strings.ToLower(m.Format) {
+	case "html":
+		om.EnableHTML()
+	case "markdown":
+		om.EnableMarkdown()
+	case "markdownv2":
+		om.EnableMarkdownV2()
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown message format '%s'", m.Format)
+	}
+
+	if m.DisableWebPreview {
+		om.DisableWebPreview()
+	}
+
+	if len(m.Buttons) > 0 {
+		kb := InlineKeyboard{}
+		for _, row := range m.Buttons {
+			var buttons InlineButtons
+			for _, b := range row {
+				buttons = append(buttons, InlineButton{Text: b.Text, URL: b.URL})
+			}
+			kb.Buttons = append(kb.Buttons, buttons)
+		}
+		om.SetInlineKeyboard(kb)
+	}
+
+	if m.Attachment != nil {
+		kind := FileTypeDocument
+		if m.Attachment.Kind == "photo" {
+			kind = FileTypePhoto
+		}
+		om.SetAttachment(AttachmentFromURL(kind, m.Attachment.URL, m.Attachment.Name))
+	}
+
+	return om, nil
+}
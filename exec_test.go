@@ -0,0 +1,61 @@
+package integram
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		stdout  string
+		want    *ExecOutput
+		wantErr bool
+	}{
+		{"empty", " \n", &ExecOutput{}, false},
+		{"message", `{"messages":[{"text":"<b>build</b> passed","format":"html","buttons":[[{"text":"Open","url":"https://ci.example.com"}]]}]}`, &ExecOutput{Messages: []ExecMessage{
+			{Text: "<b>build</b> passed", Format: "html", Buttons: [][]ExecButton{{{Text: "Open", URL: "https://ci.example.com"}}}},
+		}}, false},
+		{"not json", "Traceback (most recent call last)", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseExecOutput([]byte(tt.stdout))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. parseExecOutput() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. parseExecOutput() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecService_run(t *testing.T) {
+	os.Setenv("INTEGRAM_EXEC_TEST_SECRET", "secret")
+	defer os.Unsetenv("INTEGRAM_EXEC_TEST_SECRET")
+
+	tests := []struct {
+		name    string
+		e       ExecService
+		want    *ExecOutput
+		wantErr bool
+	}{
+		{"stdin piped", ExecService{Command: "sh", Args: []string{"-c", `grep -q '"service":"exec"' && echo '{"messages":[{"text":"ok"}]}'`}}, &ExecOutput{Messages: []ExecMessage{{Text: "ok"}}}, false},
+		{"exit code", ExecService{Command: "sh", Args: []string{"-c", "echo fail >&2; exit 1"}}, nil, true},
+		{"timeout", ExecService{Command: "sleep", Args: []string{"1"}, Timeout: time.Millisecond * 50}, nil, true},
+		{"env", ExecService{Command: "sh", Args: []string{"-c", `test -z "$INTEGRAM_EXEC_TEST_SECRET" && test "$TOKEN" = abc && echo '{"messages":[{"text":"ok"}]}'`}, Env: []string{"TOKEN=abc"}}, &ExecOutput{Messages: []ExecMessage{{Text: "ok"}}}, false},
+		{"stdout limit", ExecService{Command: "sh", Args: []string{"-c", "while :; do echo flood; done"}}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.e.run(&ExecInput{Service: "exec"})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. ExecService.run() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. ExecService.run() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package integram
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Directions of the scanned files
+const (
+	fileScanToUpstream = "to_upstream" // user's upload forwarded to the upstream
+	fileScanToChat     = "to_chat"     // upstream's file sent to the chat
+)
+
+const fileScanTimeout = time.Minute
+
+const fileScanChunkSize = 32 * 1024
+
+// FileScanner checks the local file for viruses and returns the threat's name or empty string if the file is clean
+type FileScanner interface {
+	Scan(path string) (threat string, err error)
+}
+
+// FileInfectedError is returned when the file is blocked by the scanner and moved to the quarantine
+type FileInfectedError struct {
+	Name   string
+	Threat string
+}
+
+func (e FileInfectedError) Error() string {
+	return fmt.Sprintf("File '%s' is blocked by the virus scanner: %s", e.Name, e.Threat)
+}
+
+// IsFileInfected checks if the error was caused by the file blocked by the virus scanner
+func IsFileInfected(err error) bool {
+	_, ok := err.(FileInfectedError)
+	return ok
+}
+
+// quarantinedFile is stored in the "files_quarantine" collection
+type quarantinedFile struct {
+	ID        bson.ObjectId `bson:"_id"`
+	Service   string        `bson:"s"`
+	ChatID    int64         `bson:"c"`
+	UserID    int64         `bson:"u,omitempty"`
+	Name      string        `bson:"n"`
+	Size      int64         `bson:"sz"`
+	Threat    string        `bson:"t"`
+	Direction string        `bson:"dir"`
+	Path      string        `bson:"p"` // path in the quarantine dir
+	Date      time.Time     `bson:"d"`
+}
+
+var customFileScannerMutex = sync.RWMutex{}
+var customFileScanner FileScanner
+
+func init() {
+	registerAdminCommand("quarantine", adminQuarantineReport)
+}
+
+// SetFileScanner sets the scanner used instead of the one configured with INTEGRAM_FILE_SCAN_URL. Scanning must be enabled with INTEGRAM_FILE_SCAN
+func SetFileScanner(s FileScanner) {
+	customFileScannerMutex.Lock()
+	defer customFileScannerMutex.Unlock()
+
+	customFileScanner = s
+}
+
+// fileScanner returns the scanner of the instance or nil if scanning is disabled
+func fileScanner() FileScanner {
+	if !Config.FileScan {
+		return nil
+	}
+
+	customFileScannerMutex.RLock()
+	s := customFileScanner
+	customFileScannerMutex.RUnlock()
+
+	if s != nil {
+		return s
+	}
+
+	s, err := fileScannerFromURL(Config.FileScanURL)
+	if err != nil {
+		log.WithError(err).Error("Can't create the file scanner")
+		return nil
+	}
+	return s
+}
+
+// fileScannerFromURL returns the scanner for clamd://host:port, unix:///path/to/clamd.sock or icap://host:port/service
+func fileScannerFromURL(rawURL string) (FileScanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "clamd", "tcp":
+		return clamdScanner{network: "tcp", addr: u.Host}, nil
+	case "unix":
+		return clamdScanner{network: "unix", addr: u.Path}, nil
+	case "icap":
+		return icapScanner{u: u}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown file scanner scheme '%s'", u.Scheme)
+}
+
+func fileScanQuarantineDir() string {
+	if Config.FileScanQuarantineDir != "" {
+		return Config.FileScanQuarantineDir
+	}
+	return filepath.Join(Config.ConfigDir, "quarantine")
+}
+
+// scanFile checks the file before forwarding it. Infected file is moved to the quarantine and FileInfectedError is returned
+func (c *Context) scanFile(path string, name string, direction string) error {
+	s := fileScanner()
+	if s == nil {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = filepath.Base(path)
+	}
+
+	if Config.FileScanMaxSize > 0 && info.Size() > Config.FileScanMaxSize {
+		c.Log().WithField("file", name).Debugf("File scan skipped: %d bytes", info.Size())
+		c.StatInc(StatFileScanSkipped)
+		return nil
+	}
+
+	threat, err := s.Scan(path)
+	if err != nil {
+		c.Log().WithError(err).WithField("file", name).Error("Can't scan the file")
+		if Config.FileScanAllowOnError {
+			return nil
+		}
+		return fmt.Errorf("File '%s' can't be checked for viruses", name)
+	}
+
+	c.StatInc(StatFileScanned)
+
+	if threat == "" {
+		return nil
+	}
+
+	c.StatInc(StatFileInfected)
+	c.Log().WithFields(log.Fields{"file": name, "threat": threat, "direction": direction}).Warn("Infected file blocked")
+
+	err = c.quarantineFile(path, name, info.Size(), threat, direction)
+	if err != nil {
+		c.Log().WithError(err).WithField("file", name).Error("Can't quarantine the file")
+		os.Remove(path)
+	}
+
+	return FileInfectedError{Name: name, Threat: threat}
+}
+
+// quarantineFile moves the file to the quarantine dir and records it
+func (c *Context) quarantineFile(path string, name string, size int64, threat string, direction string) error {
+	q := quarantinedFile{
+		ID:        bson.NewObjectId(),
+		Service:   c.ServiceName,
+		ChatID:    c.Chat.ID,
+		UserID:    c.User.ID,
+		Name:      name,
+		Size:      size,
+		Threat:    threat,
+		Direction: direction,
+		Date:      time.Now(),
+	}
+
+	dir := fileScanQuarantineDir()
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	q.Path = filepath.Join(dir, q.ID.Hex())
+	err = moveFile(path, q.Path)
+	if err != nil {
+		return err
+	}
+
+	// quarantined files must not be executable or readable by others
+	os.Chmod(q.Path, 0400)
+
+	return c.db.C("files_quarantine").Insert(q)
+}
+
+// moveFile renames the file and falls back to copying when the paths are on the different devices
+func moveFile(from string, to string) error {
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		in.Close()
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	in.Close()
+	out.Close()
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	return os.Remove(from)
+}
+
+// clamdScanner streams the file to ClamAV daemon with the INSTREAM command
+type clamdScanner struct {
+	network string
+	addr    string
+}
+
+func (s clamdScanner) Scan(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	conn, err := net.DialTimeout(s.network, s.addr, fileScanTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(fileScanTimeout))
+
+	_, err = conn.Write([]byte("zINSTREAM\x00"))
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, fileScanChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	// zero-length chunk ends the stream
+	_, err = conn.Write([]byte{0, 0, 0, 0})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	return parseClamdResponse(string(resp))
+}
+
+// parseClamdResponse parses 'stream: OK' and 'stream: Threat-Name FOUND'
+func parseClamdResponse(resp string) (string, error) {
+	resp = strings.TrimSpace(strings.TrimRight(resp, "\x00"))
+	resp = strings.TrimPrefix(resp, "stream: ")
+
+	if resp == "OK" {
+		return "", nil
+	}
+
+	if strings.HasSuffix(resp, " FOUND") {
+		return strings.TrimSuffix(resp, " FOUND"), nil
+	}
+
+	return "", fmt.Errorf("clamd: %s", resp)
+}
+
+// icapScanner sends the file to the ICAP server as the RESPMOD request
+type icapScanner struct {
+	u *url.URL
+}
+
+func (s icapScanner) Scan(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	host := s.u.Host
+	if s.u.Port() == "" {
+		host += ":1344"
+	}
+
+	conn, err := net.DialTimeout("tcp", host, fileScanTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(fileScanTimeout))
+
+	resHeader := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", info.Size())
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD icap://%s%s ICAP/1.0\r\n", s.u.Host, s.u.RequestURI())
+	fmt.Fprintf(w, "Host: %s\r\n", s.u.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(resHeader))
+	w.WriteString(resHeader)
+
+	buf := make([]byte, fileScanChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+
+	err = w.Flush()
+	if err != nil {
+		return "", err
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return parseICAPResponse(status, header)
+}
+
+// parseICAPResponse returns the threat reported by the ICAP server. 204 means the file is clean
+func parseICAPResponse(status string, header textproto.MIMEHeader) (string, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("icap: malformed status line '%s'", status)
+	}
+
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+	default:
+		return "", fmt.Errorf("icap: %s", status)
+	}
+
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+	if v := header.Get("X-Infection-Found"); v != "" {
+		for _, part := range strings.Split(v, ";") {
+			part = strings.TrimSpace(part)
+			if strings.HasPrefix(part, "Threat=") {
+				return strings.TrimPrefix(part, "Threat="), nil
+			}
+		}
+		return v, nil
+	}
+
+	if v := header.Get("X-Virus-ID"); v != "" {
+		return v, nil
+	}
+
+	if v := header.Get("X-Violations-Found"); v != "" {
+		return "violation found", nil
+	}
+
+	// the server returned the unmodified content
+	return "", nil
+}
+
+// adminQuarantineReport: /integram quarantine
+func adminQuarantineReport(c *Context, args []string) (string, error) {
+	var files []quarantinedFile
+	err := c.db.C("files_quarantine").Find(nil).Sort("-d").Limit(20).All(&files)
+	if err != nil {
+		return "", err
+	}
+
+	if len(files) == 0 {
+		return "No files in the quarantine", nil
+	}
+
+	lines := []string{"Last quarantined files:"}
+	for _, f := range files {
+		lines = append(lines, fmt.Sprintf("%s %s (%d): '%s' %d bytes, %s, %s -> %s", f.Date.UTC().Format("2006-01-02 15:04"), f.Service, f.ChatID, f.Name, f.Size, f.Direction, f.Threat, f.Path))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
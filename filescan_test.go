@@ -0,0 +1,198 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_parseClamdResponse(t *testing.T) {
+	tests := []struct {
+		resp       string
+		wantThreat string
+		wantErr    bool
+	}{
+		{"stream: OK\x00", "", false},
+		{"stream: Eicar-Test-Signature FOUND\x00", "Eicar-Test-Signature", false},
+		{"INSTREAM size limit exceeded. ERROR\x00", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resp, func(t *testing.T) {
+			threat, err := parseClamdResponse(tt.resp)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseClamdResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if threat != tt.wantThreat {
+				t.Errorf("parseClamdResponse() = %v, want %v", threat, tt.wantThreat)
+			}
+		})
+	}
+}
+
+func Test_parseICAPResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		header     textproto.MIMEHeader
+		wantThreat string
+		wantErr    bool
+	}{
+		{"clean", "ICAP/1.0 204 No Content", nil, "", false},
+		{"unmodified", "ICAP/1.0 200 OK", textproto.MIMEHeader{}, "", false},
+		{"infection found", "ICAP/1.0 200 OK", textproto.MIMEHeader{"X-Infection-Found": {"Type=0; Resolution=2; Threat=Eicar-Test-Signature;"}}, "Eicar-Test-Signature", false},
+		{"virus id", "ICAP/1.0 200 OK", textproto.MIMEHeader{"X-Virus-Id": {"EICAR"}}, "EICAR", false},
+		{"server error", "ICAP/1.0 500 Server Error", nil, "", true},
+		{"malformed", "HTTP/1.1 200 OK", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threat, err := parseICAPResponse(tt.status, tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseICAPResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if threat != tt.wantThreat {
+				t.Errorf("parseICAPResponse() = %v, want %v", threat, tt.wantThreat)
+			}
+		})
+	}
+}
+
+func Test_fileScannerFromURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    FileScanner
+		wantErr bool
+	}{
+		{"clamd://127.0.0.1:3310", clamdScanner{network: "tcp", addr: "127.0.0.1:3310"}, false},
+		{"unix:///var/run/clamd.sock", clamdScanner{network: "unix", addr: "/var/run/clamd.sock"}, false},
+		{"ftp://127.0.0.1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := fileScannerFromURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fileScannerFromURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fileScannerFromURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if s, err := fileScannerFromURL("icap://127.0.0.1/avscan"); err != nil {
+		t.Errorf("fileScannerFromURL() icap error = %v", err)
+	} else if _, ok := s.(icapScanner); !ok {
+		t.Errorf("fileScannerFromURL() icap = %T", s)
+	}
+}
+
+// fakeClamd reads the INSTREAM chunks and reports the threat if the content contains "EICAR"
+func fakeClamd(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			cmd := make([]byte, len("zINSTREAM\x00"))
+			io.ReadFull(conn, cmd)
+
+			var content []byte
+			size := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(conn, size); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				io.ReadFull(conn, chunk)
+				content = append(content, chunk...)
+			}
+
+			if bytes.Contains(content, []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+
+	return l
+}
+
+func Test_clamdScanner_Scan(t *testing.T) {
+	l := fakeClamd(t)
+	defer l.Close()
+
+	dir, err := ioutil.TempDir("", "integram_scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		content    []byte
+		wantThreat string
+	}{
+		{"clean", []byte("hello"), ""},
+		{"infected", []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"), "Eicar-Test-Signature"},
+		{"large clean", bytes.Repeat([]byte("a"), fileScanChunkSize*3+1), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			ioutil.WriteFile(path, tt.content, 0600)
+
+			threat, err := clamdScanner{network: "tcp", addr: l.Addr().String()}.Scan(path)
+			if err != nil {
+				t.Fatalf("clamdScanner.Scan() error = %v", err)
+			}
+			if threat != tt.wantThreat {
+				t.Errorf("clamdScanner.Scan() = %v, want %v", threat, tt.wantThreat)
+			}
+		})
+	}
+}
+
+func Test_moveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integram_move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from := filepath.Join(dir, "from")
+	to := filepath.Join(dir, "to")
+	ioutil.WriteFile(from, []byte("content"), 0600)
+
+	err = moveFile(from, to)
+	if err != nil {
+		t.Fatalf("moveFile() error = %v", err)
+	}
+
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Error("moveFile() source file still exists")
+	}
+
+	if b, _ := ioutil.ReadFile(to); string(b) != "content" {
+		t.Errorf("moveFile() destination content = %s", b)
+	}
+}
@@ -0,0 +1,98 @@
+package integram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// max number of chats processed concurrently by ForEachChat
+const forkMaxWorkers = 10
+
+// ChatErrors contains the errors returned by ForEachChat handler per chat
+type ChatErrors map[int64]error
+
+func (e ChatErrors) Error() string {
+	chatIDs := make([]int64, 0, len(e))
+	for chatID := range e {
+		chatIDs = append(chatIDs, chatID)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+
+	var errs []string
+	for _, chatID := range chatIDs {
+		errs = append(errs, fmt.Sprintf("chat %d: %s", chatID, e[chatID].Error()))
+	}
+	return fmt.Sprintf("%d chats failed: %s", len(e), strings.Join(errs, "; "))
+}
+
+// ForkForChat returns the copy of the context for another chat. Chat's data and settings are loaded on demand, use Recipient() to get its language and timezone.
+// Incoming message is kept so the child can refer to the original request, but replies must be sent with NewMessage(). Callback is answered by the parent only so it isn't kept.
+// User's data is copied so the forks can change the settings concurrently
+func (c *Context) ForkForChat(chatID int64) *Context {
+	child := c.detachedCopy()
+	child.Callback = nil
+
+	if c.Chat.ID != chatID {
+		child.Chat = Chat{ID: chatID, ctx: child}
+	}
+
+	return child
+}
+
+// ForEachChat calls the handler concurrently for each chat with the forked context. Number of concurrent handlers is limited by forkMaxWorkers.
+// Error or panic in one chat doesn't affect the others. Returns ChatErrors if any of handlers failed
+func (c *Context) ForEachChat(chatIDs []int64, handler func(ctx *Context) error) error {
+	var mutex sync.Mutex
+	errs := ChatErrors{}
+
+	jobs := make(chan int64)
+	wg := sync.WaitGroup{}
+
+	workers := forkMaxWorkers
+	if len(chatIDs) < workers {
+		workers = len(chatIDs)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chatID := range jobs {
+				err := c.ForkForChat(chatID).runForked(handler)
+				if err != nil {
+					mutex.Lock()
+					errs[chatID] = err
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, chatID := range chatIDs {
+		jobs <- chatID
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Context) runForked(handler func(ctx *Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Log().WithField("chat", c.Chat.ID).Errorf("ForEachChat: panic recovered %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	err = handler(c)
+	if err != nil {
+		c.Log().WithError(err).WithField("chat", c.Chat.ID).Error("ForEachChat: handler returned error")
+	}
+	return err
+}
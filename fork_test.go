@@ -0,0 +1,72 @@
+package integram
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestContext_ForkForChat(t *testing.T) {
+	ctx := &Context{ServiceName: "servicewithbottoken", User: User{ID: 9999999999}, Chat: Chat{ID: -9999999999, Lang: "de"}, Callback: &callback{Data: "a"}}
+	ctx.User.data = &userData{Settings: map[string]interface{}{}}
+
+	child := ctx.ForkForChat(-9999999998)
+	if child.Chat.ID != -9999999998 || child.Chat.Lang != "" {
+		t.Errorf("ForkForChat() chat = %+v, want the empty chat -9999999998", child.Chat)
+	}
+	if child.Chat.ctx != child || child.User.ctx != child {
+		t.Error("ForkForChat() chat and user must refer to the child context")
+	}
+	if ctx.Chat.ID != -9999999999 {
+		t.Errorf("ForkForChat() changed the parent's chat to %d", ctx.Chat.ID)
+	}
+	if child.Callback != nil || ctx.Callback == nil {
+		t.Error("ForkForChat() must drop the callback in the child only")
+	}
+	child.User.data.Settings["s"] = 1
+	if len(ctx.User.data.Settings) != 0 {
+		t.Error("ForkForChat() child shares the user's settings with the parent")
+	}
+
+	same := ctx.ForkForChat(-9999999999)
+	if same.Chat.Lang != "de" {
+		t.Error("ForkForChat() for the same chat must keep the chat data")
+	}
+}
+
+func TestContext_ForEachChat(t *testing.T) {
+	ctx := &Context{ServiceName: "servicewithbottoken"}
+
+	var mutex sync.Mutex
+	processed := map[int64]bool{}
+
+	err := ctx.ForEachChat([]int64{-1, -2, -3, -4}, func(c *Context) error {
+		mutex.Lock()
+		processed[c.Chat.ID] = true
+		mutex.Unlock()
+
+		switch c.Chat.ID {
+		case -2:
+			return errors.New("failed")
+		case -3:
+			panic("oops")
+		}
+		return nil
+	})
+
+	if len(processed) != 4 {
+		t.Errorf("ForEachChat() processed %d chats, want 4", len(processed))
+	}
+
+	errs, ok := err.(ChatErrors)
+	if !ok {
+		t.Fatalf("ForEachChat() error = %v, want ChatErrors", err)
+	}
+	if len(errs) != 2 || errs[-2] == nil || errs[-3] == nil {
+		t.Errorf("ForEachChat() errors = %v, want errors for chats -2 and -3", errs)
+	}
+
+	if err := ctx.ForEachChat(nil, func(c *Context) error { return nil }); err != nil {
+		t.Errorf("ForEachChat() with no chats error = %v", err)
+	}
+}
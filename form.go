@@ -0,0 +1,214 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formDateLayouts are the accepted formats of the time.Time fields. The date is parsed in the user's timezone
+var formDateLayouts = []string{"2006-01-02 15:04", "2006-01-02", "02.01.2006 15:04", "02.01.2006"}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+
+// FormField is the field of the Form's struct the user is asked for
+type FormField struct {
+	Name     string                                // name of the struct's field
+	Prompt   string                                // question sent to the user using the default parse mode
+	Optional bool                                  // field can be skipped with /skip, it keeps the zero value
+	Validate func(c *Context, answer string) error // optional. Called after the answer is parsed into the field's type
+}
+
+// Form asks the user to fill the struct's fields one by one with ForceReply prompts in the private chat with the bot.
+// Supported field types are string, int, float, bool, time.Time and time.Duration. Add it to Service.Forms and start with Context.StartForm
+type Form struct {
+	Name   string
+	Fields []FormField
+	// func(c *Context, result *T) error, where T is the struct with Fields. Called after the last field is answered
+	Handler interface{}
+}
+
+// formWizardName returns the name of the form's wizard. Prefixed to not clash with the service's wizards
+func formWizardName(name string) string {
+	return "form:" + name
+}
+
+// formFieldTypeSupported returns true if parseFormValue can set the answer to the field of type t
+func formFieldTypeSupported(t reflect.Type) bool {
+	if t == timeType || t == durationType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// parseFormValue parses the user's answer and sets it to v
+func parseFormValue(v reflect.Value, answer string, loc *time.Location) error {
+	switch {
+	case v.Type() == timeType:
+		for _, layout := range formDateLayouts {
+			if t, err := time.ParseInLocation(layout, answer, loc); err == nil {
+				v.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return errors.New("Please send the date as YYYY-MM-DD or YYYY-MM-DD HH:MM")
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(answer)
+		if err != nil {
+			return errors.New("Please send the duration, e.g. 1h30m")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if answer == "" {
+			return errors.New("Please send the text")
+		}
+		v.SetString(answer)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(answer, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("Please send the whole number")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(answer, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("Please send the positive whole number")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.Replace(answer, ",", ".", 1), v.Type().Bits())
+		if err != nil {
+			return errors.New("Please send the number")
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		switch strings.ToLower(answer) {
+		case "yes", "y", "true", "1":
+			v.SetBool(true)
+		case "no", "n", "false", "0":
+			v.SetBool(false)
+		default:
+			return errors.New("Please answer yes or no")
+		}
+	default:
+		return fmt.Errorf("Field type %s is not supported", v.Type())
+	}
+
+	return nil
+}
+
+// formResultType checks the form's handler and fields and returns the type of its struct
+func (f *Form) formResultType() (reflect.Type, error) {
+	handlerType := reflect.TypeOf(f.Handler)
+	if handlerType == nil || handlerType.Kind() != reflect.Func || handlerType.NumIn() != 2 || handlerType.NumOut() != 1 ||
+		handlerType.In(0) != reflect.TypeOf(&Context{}) || handlerType.In(1).Kind() != reflect.Ptr || handlerType.In(1).Elem().Kind() != reflect.Struct ||
+		handlerType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return nil, errors.New("Form's Handler must be func(*integram.Context, *Struct) error")
+	}
+
+	resultType := handlerType.In(1).Elem()
+	for _, field := range f.Fields {
+		sf, exists := resultType.FieldByName(field.Name)
+		if !exists {
+			return nil, fmt.Errorf("%s has no field %s", resultType, field.Name)
+		}
+
+		if !formFieldTypeSupported(sf.Type) {
+			return nil, fmt.Errorf("Type %s of the field %s is not supported", sf.Type, field.Name)
+		}
+	}
+
+	return resultType, nil
+}
+
+// fillForm returns the pointer to the new struct with the answers set
+func fillForm(resultType reflect.Type, values map[string]string, loc *time.Location) (reflect.Value, error) {
+	result := reflect.New(resultType)
+	for name, answer := range values {
+		field := result.Elem().FieldByName(name)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+
+		if err := parseFormValue(field, answer, loc); err != nil {
+			return result, fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	return result, nil
+}
+
+// wizard returns the wizard asking the form's fields
+func (f *Form) wizard() (Wizard, error) {
+	resultType, err := f.formResultType()
+	if err != nil {
+		return Wizard{}, err
+	}
+
+	w := Wizard{Name: formWizardName(f.Name)}
+	for _, field := range f.Fields {
+		field := field
+		sf, _ := resultType.FieldByName(field.Name)
+
+		w.Steps = append(w.Steps, WizardStep{
+			Name:     field.Name,
+			Prompt:   field.Prompt,
+			Optional: field.Optional,
+			Validate: func(c *Context, answer string) error {
+				if err := parseFormValue(reflect.New(sf.Type).Elem(), answer, c.User.TzLocation()); err != nil {
+					return err
+				}
+
+				if field.Validate != nil {
+					return field.Validate(c, answer)
+				}
+				return nil
+			},
+		})
+	}
+
+	handler := reflect.ValueOf(f.Handler)
+	w.Done = func(c *Context, state *WizardState) error {
+		result, err := fillForm(resultType, state.Values, c.User.TzLocation())
+		if err != nil {
+			return err
+		}
+
+		out := handler.Call([]reflect.Value{reflect.ValueOf(c), result})
+		if !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+		return nil
+	}
+
+	return w, nil
+}
+
+// StartForm asks the current user to fill the service's form with the name in the private chat with the bot.
+// Form's Handler is called with the filled struct after the last field, user can stop it with /cancel
+func (c *Context) StartForm(name string) error {
+	if c.Service().wizard(formWizardName(name)) == nil {
+		return fmt.Errorf("Form '%s' not found in Service.Forms", name)
+	}
+
+	pc := *c
+	pc.Chat = Chat{ID: c.User.ID}
+	pc.User.ctx = &pc
+	pc.Chat.ctx = &pc
+
+	return pc.StartWizard(formWizardName(name), nil)
+}
@@ -0,0 +1,84 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCardForm struct {
+	Title       string
+	Description string
+	Due         time.Time
+	Estimate    time.Duration
+	Points      int
+	Urgent      bool
+}
+
+func Test_parseFormValue(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	tests := []struct {
+		name    string
+		field   string
+		answer  string
+		want    interface{}
+		wantErr bool
+	}{
+		{"text", "Title", "Fix login", "Fix login", false},
+		{"empty text", "Title", "", "", true},
+		{"date", "Due", "2026-10-20", time.Date(2026, 10, 20, 0, 0, 0, 0, loc), false},
+		{"date with time", "Due", "20.10.2026 18:30", time.Date(2026, 10, 20, 18, 30, 0, 0, loc), false},
+		{"wrong date", "Due", "next friday", time.Time{}, true},
+		{"duration", "Estimate", "1h30m", time.Hour + time.Minute*30, false},
+		{"number", "Points", "8", 8, false},
+		{"wrong number", "Points", "eight", 0, true},
+		{"bool", "Urgent", "Yes", true, false},
+		{"wrong bool", "Urgent", "maybe", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := reflect.New(reflect.TypeOf(testCardForm{})).Elem().FieldByName(tt.field)
+			err := parseFormValue(v, tt.answer, loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFormValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := v.Interface(); !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFormValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForm_formResultType(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    Form
+		wantErr bool
+	}{
+		{"valid", Form{Fields: []FormField{{Name: "Title"}, {Name: "Due"}}, Handler: func(c *Context, f *testCardForm) error { return nil }}, false},
+		{"unknown field", Form{Fields: []FormField{{Name: "Assignee"}}, Handler: func(c *Context, f *testCardForm) error { return nil }}, true},
+		{"not a pointer", Form{Fields: []FormField{{Name: "Title"}}, Handler: func(c *Context, f testCardForm) error { return nil }}, true},
+		{"no error returned", Form{Fields: []FormField{{Name: "Title"}}, Handler: func(c *Context, f *testCardForm) {}}, true},
+		{"unsupported type", Form{Fields: []FormField{{Name: "Labels"}}, Handler: func(c *Context, f *struct{ Labels []string }) error { return nil }}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.form.formResultType(); (err != nil) != tt.wantErr {
+				t.Errorf("Form.formResultType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fillForm(t *testing.T) {
+	result, err := fillForm(reflect.TypeOf(testCardForm{}), map[string]string{"Title": "Fix login", "Points": "3"}, time.UTC)
+	if err != nil {
+		t.Fatalf("fillForm() error = %v", err)
+	}
+
+	want := &testCardForm{Title: "Fix login", Points: 3}
+	if got := result.Interface().(*testCardForm); !reflect.DeepEqual(got, want) {
+		t.Errorf("fillForm() = %+v, want %+v", got, want)
+	}
+}
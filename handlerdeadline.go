@@ -0,0 +1,162 @@
+package integram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// ErrHandlerTimeout is returned by runHandler when the handler's execution time exceeded. The handler keeps running in the background
+var ErrHandlerTimeout = errors.New("Handler execution time exceeded")
+
+// max size of the all goroutines dump used to find the stack of the timed out handler
+const handlerStacksDumpMaxSize = 4 * 1024 * 1024
+
+// Ctx returns the context that is canceled when the handler's execution time exceeded. Pass it to the upstream requests or check it between the long steps to abort the handler
+func (c *Context) Ctx() context.Context {
+	if c.deadline == nil {
+		return context.Background()
+	}
+	return c.deadline
+}
+
+// Aborted returns true if the handler's execution time exceeded and the core has already answered the update or webhook
+func (c *Context) Aborted() bool {
+	return c.deadline != nil && c.deadline.Err() != nil
+}
+
+// handlerTimeout returns the max execution time of the service's handlers or 0 if unlimited
+func (s *Service) handlerTimeout() time.Duration {
+	if s.HandlerTimeout < 0 {
+		return 0
+	} else if s.HandlerTimeout > 0 {
+		return s.HandlerTimeout
+	}
+	return Config.HandlerTimeout
+}
+
+// runHandler calls the handler with the copy of the context that has its own DB session and the deadline of the service's handler timeout.
+// The changes of the context made by the handler are copied back to c. If the timeout exceeded ErrHandlerTimeout is returned immediately,
+// the handler keeps running in the background until it returns and its result is passed to late (can be nil)
+func (c *Context) runHandler(kind string, handler func(hc *Context) error, late func(hc *Context, err error)) error {
+	var timeout time.Duration
+	if s := c.Service(); s != nil {
+		timeout = s.handlerTimeout()
+	}
+
+	if timeout <= 0 {
+		return handler(c)
+	}
+
+	deadline, cancel := context.WithTimeout(c.Ctx(), timeout)
+
+	// the timed out handler keeps running after the caller returned, so it must not share the maps with it
+	hc := *c.detachedCopy()
+	hc.deadline = deadline
+	hc.User.ctx = &hc
+	hc.Chat.ctx = &hc
+	// original session and gin context may be released while the timed out handler is still running
+	if c.db != nil {
+		hc.db = c.db.Session.Clone().DB(c.db.Name)
+	}
+	if c.gin != nil {
+		hc.gin = c.gin.Copy()
+	}
+
+	goroutineID := make(chan int64, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		goroutineID <- currentGoroutineID()
+
+		defer func() {
+			if r := recover(); r != nil {
+				hc.Log().Errorf("Panic recovery at %s handler -> %s\n%s\n", kind, r, stack(3))
+				done <- fmt.Errorf("%s handler panic: %v", kind, r)
+			}
+		}()
+
+		done <- handler(&hc)
+	}()
+
+	release := func() {
+		cancel()
+		if hc.db != nil {
+			hc.db.Session.Close()
+		}
+	}
+
+	select {
+	case err := <-done:
+		release()
+
+		db, gin := c.db, c.gin
+		*c = hc
+		c.db, c.gin = db, gin
+		c.deadline = nil
+		c.User.ctx = c
+		c.Chat.ctx = c
+		return err
+	case <-deadline.Done():
+		c.Log().WithField("handler", kind).WithField("timeout", timeout).Errorf("Handler execution time exceeded\n%s", goroutineStack(<-goroutineID))
+		c.StatInc(StatHandlerTimeout)
+
+		startedAt := time.Now().Add(-timeout)
+		go func() {
+			err := <-done
+			hc.Log().WithError(err).WithField("handler", kind).Warnf("Timed out handler finished after %s", time.Since(startedAt).Round(time.Millisecond))
+
+			if late != nil {
+				late(&hc, err)
+			}
+			release()
+		}()
+
+		return ErrHandlerTimeout
+	}
+}
+
+// currentGoroutineID parses the ID from the header of the current goroutine's stack, e.g. "goroutine 18 [running]:"
+func currentGoroutineID() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+
+	id, _ := strconv.ParseInt(string(buf), 10, 64)
+	return id
+}
+
+// goroutineStack returns the stack of the goroutine with the ID or empty string if not found
+func goroutineStack(id int64) string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= handlerStacksDumpMaxSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	return findGoroutineStack(buf, id)
+}
+
+// findGoroutineStack returns the goroutine's stack from the all goroutines dump
+func findGoroutineStack(dump []byte, id int64) string {
+	header := []byte("goroutine " + strconv.FormatInt(id, 10) + " ")
+
+	for _, g := range bytes.Split(dump, []byte("\n\n")) {
+		if bytes.HasPrefix(g, header) {
+			return string(g)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package integram
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_findGoroutineStack(t *testing.T) {
+	dump := []byte("goroutine 1 [running]:\nmain.main()\n\nmain.go:10\n\ngoroutine 12 [select]:\nintegram.slowHandler()\n\ngoroutine 123 [sleep]:\ntime.Sleep()")
+
+	tests := []struct {
+		id   int64
+		want string
+	}{
+		{12, "goroutine 12 [select]:\nintegram.slowHandler()"},
+		{123, "goroutine 123 [sleep]:\ntime.Sleep()"},
+		{2, ""},
+	}
+	for _, tt := range tests {
+		if got := findGoroutineStack(dump, tt.id); got != tt.want {
+			t.Errorf("findGoroutineStack(%d) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+
+	if id := currentGoroutineID(); id <= 0 || !strings.Contains(goroutineStack(id), "Test_findGoroutineStack") {
+		t.Errorf("goroutineStack(currentGoroutineID()) doesn't contain the current func")
+	}
+}
+
+func TestContext_runHandler(t *testing.T) {
+	s, _ := serviceByName("servicewithbottoken")
+	defer func(timeout time.Duration) { s.HandlerTimeout = timeout }(s.HandlerTimeout)
+	s.HandlerTimeout = time.Millisecond * 100
+
+	ctx := &Context{db: db, ServiceName: s.Name}
+	ctx.User.ctx = ctx
+
+	handlerErr := errors.New("upstream error")
+	err := ctx.runHandler("test", func(hc *Context) error {
+		now := time.Now()
+		hc.messageAnsweredAt = &now
+		return handlerErr
+	}, nil)
+
+	if err != handlerErr {
+		t.Errorf("runHandler() error = %v, want %v", err, handlerErr)
+	}
+	if ctx.messageAnsweredAt == nil || ctx.User.ctx != ctx || ctx.db != db || ctx.deadline != nil {
+		t.Errorf("runHandler() context isn't copied back: %+v", ctx)
+	}
+
+	late := make(chan bool, 1)
+	startedAt := time.Now()
+	err = ctx.runHandler("test", func(hc *Context) error {
+		<-hc.Ctx().Done()
+		return hc.Ctx().Err()
+	}, func(hc *Context, err error) {
+		late <- hc.Aborted() && err != nil
+	})
+
+	if err != ErrHandlerTimeout || time.Since(startedAt) > time.Second {
+		t.Errorf("runHandler() error = %v after %s, want %v", err, time.Since(startedAt), ErrHandlerTimeout)
+	}
+
+	select {
+	case aborted := <-late:
+		if !aborted {
+			t.Errorf("runHandler() late handler isn't aborted")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("runHandler() late func isn't called")
+	}
+
+	s.HandlerTimeout = -1
+	err = ctx.runHandler("test", func(hc *Context) error {
+		if hc != ctx {
+			return errors.New("handler is called with the copy of context")
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Errorf("runHandler() without timeout error = %v", err)
+	}
+}
+
+func TestContext_detachedCopy(t *testing.T) {
+	ctx := &Context{Callback: &callback{Data: "a"}}
+	ctx.User.data = &userData{Settings: map[string]interface{}{"s": 1}, Protected: map[string]*userProtected{"s": {OAuthToken: "t"}}}
+	ctx.Chat.data = &chatData{Settings: map[string]interface{}{"s": 1}}
+
+	cp := ctx.detachedCopy()
+	cp.User.data.Settings["s"] = 2
+	cp.User.data.Protected["s"].OAuthToken = "changed"
+	cp.Chat.data.Settings["other"] = 1
+	cp.Callback.Data = "b"
+
+	if ctx.User.data.Settings["s"] != 1 || ctx.User.data.Protected["s"].OAuthToken != "t" || len(ctx.Chat.data.Settings) != 1 || ctx.Callback.Data != "a" {
+		t.Errorf("detachedCopy() shares the data with the original context")
+	}
+	if cp.User.ctx != cp || cp.Chat.ctx != cp {
+		t.Errorf("detachedCopy() user and chat refer to the original context")
+	}
+}
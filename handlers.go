@@ -67,6 +67,9 @@ func init() {
 
 	startedAt = time.Now()
 
+	if renderMode {
+		return
+	}
 	dbConnect()
 }
 
@@ -143,7 +146,12 @@ func gracefulShutdownJobPools() {
 }
 
 // Run initiates Integram to listen webhooks, TG updates and start the workers pool
+// When the binary is started with "render" arg it renders the webhook fixture offline instead, see renderCLI
 func Run() {
+	if renderMode {
+		os.Exit(renderCLI(os.Args[2:], os.Stdout))
+	}
+
 	if Config.Debug {
 		gin.SetMode(gin.DebugMode)
 		log.SetLevel(log.DebugLevel)
@@ -241,12 +249,30 @@ func Run() {
 
 		/auth/service_name - OAuth2 redirect URL
 		/auth/service_name/provider_id - adds provider_id for the custom OAuth provider (e.g. self-hosted instance)
+		/oauthbroker/service_name/action - OAuth broker for the self-hosted instances: start, token and refresh
 
 
 		WebPreview resolving:
 		/a/token
+
+		Admin reports:
+		/admin/command/service_name?token=admin_token
+
+		Readiness probe(503 until warmup is finished):
+		/ready
+
+		Messages API (authorized with the chat's API key):
+		POST /api/v1/chats/chat_id/messages
+		GET /api/v1/chats/chat_id/stats
+		GET /api/v1/chats/chat_id/events
+
+		Service events stream (authorized with INTEGRAM_ADMIN_TOKEN in the X-Integram-Admin-Token header):
+		GET /api/v1/services/service_name/events
 	*/
 
+	router.POST("/:param1/:param2/:param3/:param4/:param5", apiHandler)
+	router.GET("/:param1/:param2/:param3/:param4/:param5", apiHandler)
+
 	router.HEAD("/:param1/:param2/:param3", serviceHookHandler)
 	router.GET("/:param1/:param2/:param3", serviceHookHandler)
 	router.POST("/:param1/:param2/:param3", serviceHookHandler)
@@ -259,11 +285,28 @@ func Run() {
 	router.GET("/:param1", serviceHookHandler)
 	router.POST("/:param1", serviceHookHandler)
 
+	initWebhookBackpressure(router)
+	initDegradedMode()
+
 	// Start listening
 
 	var err error
 
 	go gracefulShutdownJobPools()
+	go warmup()
+	go webhooksHealthChecker()
+	go hibernationChecker()
+	go reconcileChatsChecker()
+	go chatSnapshotsMaker()
+	go oauthProvidersChecker()
+	go tgWebhooksChecker()
+	go webhookSpillDrainer()
+	go scheduledMessagesSender()
+	go stuckSagasCompensator()
+	go maintenanceWatcher()
+	go dbHealthWatcher()
+	go deprecationNotifier()
+	go dataRepairsRunner()
 
 	if Config.Port == "443" || Config.Port == "1443" {
 		if _, err := os.Stat(Config.ConfigDir + string(os.PathSeparator) + "ssl.crt"); !os.IsNotExist(err) {
@@ -338,7 +381,7 @@ func (s *Service) TriggerEventHandler(queryChat bool, bsonQuery map[string]inter
 			s.Log().WithError(err).Error("FindChats error")
 		}
 		for _, chat := range chats {
-			if chat.Deactivated || chat.BotWasKickedOrStopped() {
+			if chat.Deactivated || chat.BotWasKickedOrStopped() || chat.IsHibernated() {
 				continue
 			}
 			ctx.Chat = chat.Chat
@@ -403,6 +446,8 @@ func reverseProxyForService(service string) *httputil.ReverseProxy {
 
 	buf := new(bytes.Buffer)
 	rp.ErrorLog = stdlog.New(buf, "reverseProxy ", stdlog.LUTC)
+	// flush the streamed responses, e.g. the chat events
+	rp.FlushInterval = time.Second
 
 	reverseProxiesMap[service] = rp
 
@@ -453,9 +498,20 @@ func serviceHookHandler(c *gin.Context) {
 		c.HTML(http.StatusOK, "determineTZ", gin.H{"redirectURL": Config.BaseURL + c.Query("r")})
 		return
 
+	// readiness probe
+	case "ready":
+		readinessHandler(c)
+		return
+
+	// /admin/command/service_name
+	case "admin":
+		adminHandler(c, p2, p3)
+		return
+
 	// /oauth1/service_name
 	// /auth/service_name
-	case "auth", "oauth1":
+	// /oauthbroker/service_name/action
+	case "auth", "oauth1", "oauthbroker":
 		service = p2
 
 	default:
@@ -490,6 +546,10 @@ func serviceHookHandler(c *gin.Context) {
 		// /oauth1/service_name/auth_temp_id
 		oAuthInitRedirect(c, p2, p3)
 
+		return
+	} else if p1 == "oauthbroker" {
+		// /oauthbroker/service_name/action
+		oauthBrokerHandler(c, s, p3)
 		return
 	} else if p1 == "auth" {
 
@@ -519,15 +579,42 @@ func serviceHookHandler(c *gin.Context) {
 		return
 	}
 
-	ctx := &Context{db: db, gin: c}
-
+	serviceName := ""
 	if s != nil {
-		ctx.ServiceName = s.Name
+		serviceName = s.Name
 	}
 
+	if maintenanceRejectWebhook(c) {
+		return
+	}
+
+	if degradedSpillWebhook(c) {
+		return
+	}
+
+	if s != nil && s.Deprecation.phase(time.Now()) == deprecationPhaseDisabled {
+		c.String(http.StatusGone, "Service is disabled")
+		return
+	}
+
+	if enqueueAsyncWebhook(c, db, s) {
+		return
+	}
+
+	release, handled := webhookBackpressure(c, db, serviceName)
+	if handled {
+		return
+	}
+	defer release()
+
+	ctx := &Context{db: db, gin: c, ServiceName: serviceName}
+
 	var hooks []serviceHook
 
-	wctx := &WebhookContext{gin: c, requestID: rndStr.Get(10)}
+	wctx := &WebhookContext{gin: c, serviceName: serviceName, route: webhookToken, requestID: rndStr.Get(10), receivedAt: webhookReceivedAt(c.Request)}
+	defer wctx.closeMultipart()
+	ctx.requestID = wctx.requestID
+	ctx.webhook = wctx
 
 	// if service has its own TokenHandler use it to resolve the URL query and get the user/chat db Query
 	if s != nil && s.TokenHandler != nil {
@@ -537,6 +624,20 @@ func serviceHookHandler(c *gin.Context) {
 			return
 		}
 
+		duplicate, payloadKey := isDuplicateWebhook(db, s, webhookToken, c.Request)
+		if duplicate {
+			ctx.StatInc(StatWebhookDuplicate)
+			c.String(http.StatusOK, "Duplicate webhook ignored")
+			return
+		}
+
+		retried, idempotencyKey := isRetriedWebhook(db, s, webhookToken, wctx)
+		if retried {
+			ctx.StatInc(StatWebhookDuplicate)
+			c.String(http.StatusOK, "Retried webhook ignored")
+			return
+		}
+
 		queryChat, query, err := s.TokenHandler(ctx, wctx)
 
 		if err != nil {
@@ -544,11 +645,24 @@ func serviceHookHandler(c *gin.Context) {
 		}
 
 		if query == nil {
+			if wctx.writeServiceResponse() {
+				// e.g. the verification handshake answered by the service
+				return
+			}
 			ctx.StatInc(StatWebhookProcessingError)
+			if err != nil && asyncWebhookAttempt(c.Request) > 0 {
+				// retried by the worker pool
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
 			c.Status(http.StatusNoContent)
 			return
 		}
 
+		// handler's error is reported to the worker pool only if no chat or user processed the webhook, the same as for the hooks below
+		var lastHandlerErr error
+		processedWithoutErrors := false
+
 		if queryChat {
 			chats, err := ctx.FindChats(query)
 
@@ -562,27 +676,43 @@ func serviceHookHandler(c *gin.Context) {
 			}
 
 			for _, chat := range chats {
-				if chat.Deactivated || chat.BotWasKickedOrStopped() {
+				if chat.Deactivated || chat.BotWasKickedOrStopped() || chat.IsHibernated() {
 					continue
 				}
 				ctxCopy := *ctx
 				ctxCopy.Chat = chat.Chat
 				ctxCopy.Chat.ctx = &ctxCopy
-				err := s.WebhookHandler(&ctxCopy, wctx)
+
+				if buffered, err := ctxCopy.bufferWebhookBurst(s, webhookToken, wctx); buffered {
+					continue
+				} else if err != nil {
+					ctxCopy.Log().WithError(err).Error("Can't buffer the webhook's burst")
+				}
+
+				err := ctxCopy.runWebhookHandler(wctx, func(hc *Context) error { return s.WebhookHandler(hc, wctx) })
 
 				if err != nil {
 					ctxCopy.StatIncChat(StatWebhookProcessingError)
 					if err == ErrorFlood {
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusTooManyRequests, err.Error())
 						return
 					} else if strings.HasPrefix(err.Error(), ErrorBadRequstPrefix) {
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusBadRequest, err.Error())
 						return
+					} else if err == ErrHandlerTimeout {
+						// the message may still be delivered by the handler running in the background
+						continue
 					} else {
+						lastHandlerErr = err
 						ctx.Log().WithFields(log.Fields{"token": webhookToken}).WithError(err).Error("WebhookHandler returned error")
+						ctxCopy.renderServiceError(err)
 					}
 				} else {
+					processedWithoutErrors = true
 					ctxCopy.StatIncChat(StatWebhookHandled)
+					ctxCopy.publishChatEvent(ChatEventWebhookReceived, nil, "")
 				}
 			}
 
@@ -603,27 +733,52 @@ func serviceHookHandler(c *gin.Context) {
 				ctxCopy.User = user.User
 				ctxCopy.User.ctx = &ctxCopy
 				ctxCopy.Chat = Chat{ID: user.ID, ctx: &ctxCopy}
-				err := s.WebhookHandler(&ctxCopy, wctx)
+
+				if buffered, err := ctxCopy.bufferWebhookBurst(s, webhookToken, wctx); buffered {
+					continue
+				} else if err != nil {
+					ctxCopy.Log().WithError(err).Error("Can't buffer the webhook's burst")
+				}
+
+				err := ctxCopy.runWebhookHandler(wctx, func(hc *Context) error { return s.WebhookHandler(hc, wctx) })
 
 				if err != nil {
 					ctxCopy.StatIncUser(StatWebhookProcessingError)
 
 					if err == ErrorFlood {
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusTooManyRequests, err.Error())
 						return
 					} else if strings.HasPrefix(err.Error(), ErrorBadRequstPrefix) {
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusBadRequest, err.Error())
 						return
+					} else if err == ErrHandlerTimeout {
+						// the message may still be delivered by the handler running in the background
+						continue
 					} else {
+						lastHandlerErr = err
 						ctxCopy.Log().WithFields(log.Fields{"token": webhookToken}).WithError(err).Error("WebhookHandler returned error")
+						ctxCopy.renderServiceError(err)
 					}
 				} else {
+					processedWithoutErrors = true
 					ctxCopy.StatIncUser(StatWebhookHandled)
+					ctxCopy.publishChatEvent(ChatEventWebhookReceived, nil, "")
 				}
 			}
 
 		}
-		c.AbortWithStatus(http.StatusAccepted)
+
+		if lastHandlerErr != nil && !processedWithoutErrors && asyncWebhookAttempt(c.Request) > 0 {
+			// retried by the worker pool
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if !wctx.writeServiceResponse() {
+			c.AbortWithStatus(http.StatusAccepted)
+		}
 		return
 	} else if webhookToken[0:1] == "u" {
 		// Here is some trick
@@ -723,6 +878,13 @@ func serviceHookHandler(c *gin.Context) {
 		return
 	}
 	atLeastOneChatProcessedWithoutErrors := false
+	hibernatedChats := 0
+	var lastHandlerErr error
+
+	payloadSize := wctx.ContentLength()
+	if payloadSize < 0 {
+		payloadSize = 0
+	}
 
 	for _, hook := range hooks {
 		if hook.Token != webhookToken {
@@ -781,6 +943,36 @@ func serviceHookHandler(c *gin.Context) {
 				}
 			}
 
+			if isWebhookPaused(db, webhookToken) {
+				// answer 2xx to prevent upstream from disabling the hook while it is paused
+				c.String(http.StatusAccepted, "Webhook is paused. Please reconnect it in the chat")
+				return
+			}
+
+			duplicate, payloadKey := isDuplicateWebhook(db, s, webhookToken, c.Request)
+			if duplicate {
+				ctx.StatInc(StatWebhookDuplicate)
+				c.String(http.StatusOK, "Duplicate webhook ignored")
+				return
+			}
+
+			retried, idempotencyKey := isRetriedWebhook(db, s, webhookToken, wctx)
+			if retried {
+				ctx.StatInc(StatWebhookDuplicate)
+				c.String(http.StatusOK, "Retried webhook ignored")
+				return
+			}
+
+			if s.WebhookEncryption {
+				if err := decryptWebhook(db, s, wctx, hook.Chats); err != nil {
+					ctx.Log().WithFields(log.Fields{"token": webhookToken}).WithError(err).Warn("Can't decrypt the webhook")
+					recordWebhookDelivery(db, webhookToken, serviceName, payloadSize, err)
+					forgetWebhookPayload(db, payloadKey, idempotencyKey)
+					c.String(http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+
 			// todo: if bot kicked or stopped in all chats – need to remove the webhook?
 
 			for _, chatID := range hook.Chats {
@@ -790,39 +982,83 @@ func serviceHookHandler(c *gin.Context) {
 				if ctx.Chat.ID == chatID {
 					if ctx.Chat.BotWasKickedOrStopped() || ctx.Chat.data.Deactivated {
 						continue
+					} else if ctx.Chat.data.IsHibernated() {
+						hibernatedChats++
+						continue
 					}
 				} else if d, _ := ctxCopy.Chat.getData(); d != nil && (d.BotWasKickedOrStopped() || d.Deactivated) {
 					continue
+				} else if d != nil && d.IsHibernated() {
+					hibernatedChats++
+					continue
 				}
-				err := s.WebhookHandler(&ctxCopy, wctx)
+				if buffered, err := ctxCopy.bufferWebhookBurst(s, webhookToken, wctx); buffered {
+					// handled with the batch after the burst window
+					atLeastOneChatProcessedWithoutErrors = true
+					continue
+				} else if err != nil {
+					ctxCopy.Log().WithError(err).Error("Can't buffer the webhook's burst")
+				}
+
+				stopProfiling := startProfiling(serviceName, "webhook")
+				err := ctxCopy.runWebhookHandler(wctx, func(hc *Context) error { return s.WebhookHandler(hc, wctx) })
+				stopProfiling()
 
 				if err != nil {
+					lastHandlerErr = err
 					if err == ErrorFlood {
+						recordWebhookDelivery(db, webhookToken, serviceName, payloadSize, err)
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusTooManyRequests, err.Error())
 						return
 					} else if strings.HasPrefix(err.Error(), ErrorBadRequstPrefix) {
+						recordWebhookDelivery(db, webhookToken, serviceName, payloadSize, err)
+						forgetWebhookPayload(db, payloadKey, idempotencyKey)
 						c.String(http.StatusBadRequest, err.Error())
 						return
+					} else if err == ErrHandlerTimeout {
+						// the message may still be delivered by the handler running in the background
+						continue
 					} else {
 						ctxCopy.Log().WithFields(log.Fields{"token": webhookToken}).WithError(err).Error("WebhookHandler returned error")
+						ctxCopy.renderServiceError(err)
 					}
 				} else {
 
 					if ctxCopy.messageAnsweredAt != nil {
 						ctxCopy.StatIncChat(StatWebhookProducedMessageToChat)
 					}
+					ctxCopy.publishChatEvent(ChatEventWebhookReceived, nil, "")
 					atLeastOneChatProcessedWithoutErrors = true
 				}
 			}
 		}
 
 		if atLeastOneChatProcessedWithoutErrors {
+			recordWebhookDelivery(db, webhookToken, ctx.ServiceName, payloadSize, nil)
 			ctx.StatIncUser(StatWebhookHandled)
-			c.AbortWithStatus(200)
+			if !wctx.writeServiceResponse() {
+				c.AbortWithStatus(200)
+			}
+		} else if hibernatedChats > 0 && lastHandlerErr == nil {
+			// subscriptions are kept until the next human message in the chat, so this is not a delivery failure
+			c.String(http.StatusAccepted, "Chats are hibernated because of no activity")
 		} else {
+			// the handler timed out is still running in the background
+			handlerFailed := lastHandlerErr != nil && lastHandlerErr != ErrHandlerTimeout
+			if lastHandlerErr == nil {
+				lastHandlerErr = errors.New("No chats processed the webhook")
+			}
+			recordWebhookDelivery(db, webhookToken, ctx.ServiceName, payloadSize, lastHandlerErr)
 			ctx.StatIncUser(StatWebhookProcessingError)
 			log.WithField("token", webhookToken).Warn("Hook not handled")
 
+			if asyncWebhookAttempt(c.Request) > 0 && handlerFailed {
+				// retried by the worker pool
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+
 			// need to answer 2xx otherwise we webhook will be retried and the error will reappear
 			// todo: maybe throw 500 if error because of DB fault etc.
 			c.AbortWithStatus(http.StatusAccepted)
@@ -917,6 +1153,14 @@ func oAuthCallback(c *gin.Context, oauthProviderID string) {
 		authTempID = c.Query("state")
 	}
 
+	// the user is passing OAuth for the self-hosted instance
+	if len(Config.OAuthBrokerClients) > 0 {
+		if sess, err := findOAuthBrokerSession(db, authTempID); err == nil {
+			oauthBrokerCallback(c, db, sess)
+			return
+		}
+	}
+
 	val := oAuthIDCache{}
 	err := db.C("users_cache").Find(bson.M{"key": "auth_" + authTempID}).One(&val)
 
@@ -952,7 +1196,15 @@ func oAuthCallback(c *gin.Context, oauthProviderID string) {
 	var expiresAt *time.Time
 
 	if s.DefaultOAuth2 != nil {
-		if s.DefaultOAuth2.AccessTokenReceiver != nil {
+		if oauthBrokered(oap) {
+			var otoken *oauth2.Token
+			otoken, err = oauthBrokerRequestToken(s.Name, oauthBrokerActionToken, c.Request.FormValue("code"))
+			if otoken != nil {
+				accessToken = otoken.AccessToken
+				refreshToken = otoken.RefreshToken
+				expiresAt = &otoken.Expiry
+			}
+		} else if s.DefaultOAuth2.AccessTokenReceiver != nil {
 			accessToken, expiresAt, refreshToken, err = s.DefaultOAuth2.AccessTokenReceiver(ctx, c.Request)
 		} else {
 			code := c.Request.FormValue("code")
@@ -993,11 +1245,21 @@ func oAuthCallback(c *gin.Context, oauthProviderID string) {
 		oauthTokenStore.SetOAuthRefreshToken(&ctx.User, refreshToken)
 	}
 
+	// own token is used from now
+	if ctx.User.OAuthSharedFrom() != "" {
+		ctx.User.RevokeOAuthSharing()
+	}
+
 	ctx.StatIncUser(StatOAuthSuccess)
 
 	if s.OAuthSuccessful != nil {
 		s.DoJob(s.OAuthSuccessful, ctx)
 	}
 
+	err = ctx.User.runAfterAuthAction()
+	if err != nil {
+		ctx.Log().WithError(err).Error("AfterAuth action failed")
+	}
+
 	c.Redirect(302, "https://telegram.me/"+s.Bot().Username)
 }
@@ -0,0 +1,302 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// handed off chats are cached on every instance for this period. Chats that weren't handed off aren't cached,
+// so the other instances stop sending with the service's bot as soon as it leaves the chat
+const chatHandoffCacheTTL = time.Minute
+
+const (
+	chatHandoffPending = "pending" // admins are asked to add the overflow bot
+	chatHandoffDone    = "done"    // chat is served by the overflow bot
+)
+
+// overflow bots per the ID of the service's bot
+var overflowBotPerBot = make(map[int64]*Bot)
+
+// ID of the bot serving the chat per the chat handoff ID
+var chatHandoffsCache = &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+// chatHandoff moves the high-volume group chat from the service's bot to the overflow bot to keep the bot below Telegram's global limits
+type chatHandoff struct {
+	ID          string     `bson:"_id"` // see chatHandoffID
+	ChatID      int64      `bson:"chatid"`
+	Service     string     `bson:"service"`
+	FromBotID   int64      `bson:"from"`
+	ToBotID     int64      `bson:"to"`
+	Status      string     `bson:"status"`
+	RequestedAt time.Time  `bson:"requestedat"`
+	DoneAt      *time.Time `bson:"doneat,omitempty"`
+}
+
+func init() {
+	registerAdminCommand("handoff", adminChatHandoff)
+}
+
+func chatHandoffID(botID int64, chatID int64) string {
+	return fmt.Sprintf("%d_%d", botID, chatID)
+}
+
+// overflowBotToken returns the token of the service's overflow bot from Config.OverflowBotTokens
+func overflowBotToken(serviceName string) string {
+	for _, item := range Config.OverflowBotTokens {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) == 2 && kv[0] == serviceName {
+			return kv[1]
+		}
+	}
+
+	return ""
+}
+
+// registerOverflowBot registers the service's overflow bot if it's configured. It receives the updates like the service's bot
+func (service *Service) registerOverflowBot(bot *Bot) error {
+	token := overflowBotToken(service.Name)
+	if token == "" {
+		return nil
+	}
+
+	overflowBot, err := service.addBot(token)
+	if err != nil {
+		return err
+	}
+
+	if overflowBot.ID == bot.ID {
+		return errors.New("overflow bot must differ from the service's bot")
+	}
+
+	overflowBotPerBot[bot.ID] = overflowBot
+	return nil
+}
+
+// chatHandoffInviteURL returns the link to add the bot to the group
+func chatHandoffInviteURL(botUsername string) string {
+	return fmt.Sprintf("https://t.me/%s?startgroup=handoff", botUsername)
+}
+
+// handoffBotID returns the ID of the overflow bot the group chat was handed off to or botID if it wasn't
+func handoffBotID(db *mgo.Database, botID int64, chatID int64) int64 {
+	if chatID >= 0 {
+		// only the groups are handed off
+		return botID
+	}
+
+	if _, exists := overflowBotPerBot[botID]; !exists {
+		return botID
+	}
+
+	id := chatHandoffID(botID, chatID)
+	if val, cached := chatHandoffsCache.get(id); cached {
+		return val.(int64)
+	}
+
+	var h chatHandoff
+	err := db.C("chat_handoffs").Find(bson.M{"_id": id, "status": chatHandoffDone}).One(&h)
+	if err != nil && err != mgo.ErrNotFound {
+		log.WithError(err).WithField("chat", chatID).Error("Can't get the chat's handoff")
+		return botID
+	}
+
+	if err == mgo.ErrNotFound {
+		return botID
+	}
+
+	chatHandoffsCache.set(id, h.ToBotID, chatHandoffCacheTTL)
+	return h.ToBotID
+}
+
+// requestChatHandoff asks the chat's admins to add the overflow bot to the group. Chat is handed off once it's added
+func requestChatHandoff(db *mgo.Database, s *Service, bot *Bot, overflowBot *Bot, chatID int64) error {
+	h := chatHandoff{
+		ID:          chatHandoffID(bot.ID, chatID),
+		ChatID:      chatID,
+		Service:     s.Name,
+		FromBotID:   bot.ID,
+		ToBotID:     overflowBot.ID,
+		Status:      chatHandoffPending,
+		RequestedAt: time.Now(),
+	}
+
+	err := db.C("chat_handoffs").Insert(h)
+	if mgo.IsDup(err) {
+		return fmt.Errorf("Chat %d is already handed off or waiting for the admins", chatID)
+	} else if err != nil {
+		return err
+	}
+
+	ctx := &Context{db: db, ServiceName: s.Name}
+	ctx.Chat = Chat{ID: chatID, ctx: ctx}
+
+	text := fmt.Sprintf("This chat is very active, so @%s will deliver its %s notifications from now on to keep them on time.\n\n"+
+		"Chat admins, please add @%s to the group. Settings and subscriptions are kept, @%s leaves the group once it's done",
+		overflowBot.Username, s.NameToPrint, overflowBot.Username, bot.Username)
+
+	kb := InlineKeyboard{}
+	kb.AppendRows(InlineButtons{InlineButton{Text: "➕ Add @" + overflowBot.Username, URL: chatHandoffInviteURL(overflowBot.Username)}})
+
+	err = ctx.NewMessage().SetText(text).SetParseMode("").SetInlineKeyboard(kb).Send()
+	if err != nil {
+		db.C("chat_handoffs").RemoveId(h.ID)
+		return err
+	}
+	return nil
+}
+
+// completeChatHandoff hands off the chat once the overflow bot is added to it: the chat's state is re-keyed to the overflow bot
+// and the service's bot leaves the chat. Messages sent by the service's bot before can't be edited after it
+func completeChatHandoff(db *mgo.Database, b *Bot, chatID int64, newMembers []tg.User) {
+	added := false
+	for _, member := range newMembers {
+		if member.ID == b.ID {
+			added = true
+			break
+		}
+	}
+
+	if !added {
+		return
+	}
+
+	var h chatHandoff
+	now := time.Now()
+	_, err := db.C("chat_handoffs").Find(bson.M{"chatid": chatID, "to": b.ID, "status": chatHandoffPending}).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"status": chatHandoffDone, "doneat": now}},
+		ReturnNew: true,
+	}, &h)
+	if err == mgo.ErrNotFound {
+		return
+	} else if err != nil {
+		log.WithError(err).WithField("chat", chatID).Error("Can't complete the chat's handoff")
+		return
+	}
+
+	err = rekeyChatHandoff(db, h)
+	if err != nil {
+		log.WithError(err).WithField("chat", chatID).Error("Can't re-key the handed off chat's keyboards")
+	}
+
+	chatHandoffsCache.set(h.ID, h.ToBotID, chatHandoffCacheTTL)
+	log.WithFields(log.Fields{"chat": chatID, "service": h.Service, "bot": b.ID}).Info("Chat handed off to the overflow bot")
+
+	ctx := &Context{db: db, ServiceName: h.Service}
+	ctx.Chat = Chat{ID: chatID, ctx: ctx}
+
+	err = ctx.NewMessage().SetText(fmt.Sprintf("✅ @%s delivers the notifications of this chat from now on", b.Username)).SetParseMode("").Send()
+	if err != nil {
+		log.WithError(err).WithField("chat", chatID).Error("Can't confirm the chat's handoff")
+	}
+
+	// frees the service's bot from the chat's updates
+	if bot := botByID(h.FromBotID); bot != nil {
+		_, err = bot.API.LeaveChat(tg.ChatConfig{ChatID: chatID})
+		if err != nil {
+			log.WithError(err).WithField("chat", chatID).Error("Service's bot can't leave the handed off chat")
+		}
+	}
+}
+
+// rekeyChatHandoff moves the chat's keyboards to the overflow bot, so the buttons pressed in the chat are recognized
+func rekeyChatHandoff(db *mgo.Database, h chatHandoff) error {
+	_, err := db.C("users").UpdateAll(
+		bson.M{"keyboardperchat": bson.M{"$elemMatch": bson.M{"chatid": h.ChatID, "botid": h.FromBotID}}},
+		bson.M{"$set": bson.M{"keyboardperchat.$.botid": h.ToBotID}})
+	if err != nil {
+		return err
+	}
+
+	err = db.C("chats").Update(
+		bson.M{"_id": h.ChatID, "keyboardperbot.botid": h.FromBotID},
+		bson.M{"$set": bson.M{"keyboardperbot.$.botid": h.ToBotID}})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// adminChatHandoff lists the service's handed off chats, hands off the chat or cancels the pending handoff
+func adminChatHandoff(c *Context, args []string) (string, error) {
+	s := c.Service()
+	if s == nil {
+		return "", errors.New("Service must be specified")
+	}
+
+	bot := s.Bot()
+	if bot == nil {
+		return "", errors.New("Service's bot isn't registered")
+	}
+
+	overflowBot := overflowBotPerBot[bot.ID]
+	if overflowBot == nil {
+		return "", fmt.Errorf("%s has no overflow bot, add it to INTEGRAM_OVERFLOW_BOT_TOKENS", s.Name)
+	}
+
+	if len(args) == 0 {
+		var handoffs []chatHandoff
+		err := c.db.C("chat_handoffs").Find(bson.M{"service": s.Name}).Sort("-requestedat").All(&handoffs)
+		if err != nil {
+			return "", err
+		}
+
+		if len(handoffs) == 0 {
+			return fmt.Sprintf("No chats handed off to @%s", overflowBot.Username), nil
+		}
+
+		lines := []string{fmt.Sprintf("Chats handed off to @%s", overflowBot.Username)}
+		for _, h := range handoffs {
+			lines = append(lines, h.String())
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	cancel := args[0] == "cancel"
+	if cancel {
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return "", errors.New("Usage: handoff [[cancel] chat_id]")
+	}
+
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Wrong chat ID: %s", args[0])
+	}
+
+	if chatID >= 0 {
+		return "", errors.New("Only the group chats can be handed off")
+	}
+
+	if cancel {
+		err = c.db.C("chat_handoffs").Remove(bson.M{"_id": chatHandoffID(bot.ID, chatID), "status": chatHandoffPending})
+		if err == mgo.ErrNotFound {
+			return "", fmt.Errorf("Chat %d has no pending handoff", chatID)
+		} else if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Handoff of the chat %d canceled", chatID), nil
+	}
+
+	err = requestChatHandoff(c.db, s, bot, overflowBot, chatID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Admins of the chat %d are asked to add @%s", chatID, overflowBot.Username), nil
+}
+
+func (h chatHandoff) String() string {
+	if h.DoneAt != nil {
+		return fmt.Sprintf("%d: %s %s", h.ChatID, h.Status, h.DoneAt.UTC().Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("%d: %s since %s", h.ChatID, h.Status, h.RequestedAt.UTC().Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,81 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_overflowBotToken(t *testing.T) {
+	defer func(tokens []string) { Config.OverflowBotTokens = tokens }(Config.OverflowBotTokens)
+	Config.OverflowBotTokens = []string{"trello:1234:AAAA-bbbb", "github:5678:CCCC"}
+
+	tests := []struct {
+		name    string
+		service string
+		want    string
+	}{
+		{"token with the colon", "trello", "1234:AAAA-bbbb"},
+		{"second service", "github", "5678:CCCC"},
+		{"not configured", "gitlab", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overflowBotToken(tt.service); got != tt.want {
+				t.Errorf("overflowBotToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handoffBotID(t *testing.T) {
+	overflowBotPerBot[1111] = &Bot{ID: 2222}
+	defer delete(overflowBotPerBot, 1111)
+
+	chatHandoffsCache.set(chatHandoffID(1111, -100), int64(2222), time.Minute)
+	defer chatHandoffsCache.delete(chatHandoffID(1111, -100))
+
+	tests := []struct {
+		name   string
+		botID  int64
+		chatID int64
+		want   int64
+	}{
+		{"private chat", 1111, 100, 1111},
+		{"bot without overflow bot", 3333, -100, 3333},
+		{"handed off", 1111, -100, 2222},
+		{"not handed off", 1111, -200, 1111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := handoffBotID(db, tt.botID, tt.chatID); got != tt.want {
+				t.Errorf("handoffBotID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, cached := chatHandoffsCache.get(chatHandoffID(1111, -200)); cached {
+		t.Errorf("handoffBotID() cached the chat that wasn't handed off")
+	}
+}
+
+func Test_chatHandoff_String(t *testing.T) {
+	requested := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	done := requested.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		h    chatHandoff
+		want string
+	}{
+		{"pending", chatHandoff{ChatID: -100, Status: chatHandoffPending, RequestedAt: requested}, "-100: pending since 2024-03-10 12:00"},
+		{"done", chatHandoff{ChatID: -100, Status: chatHandoffDone, RequestedAt: requested, DoneAt: &done}, "-100: done 2024-03-10 13:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.String(); got != tt.want {
+				t.Errorf("chatHandoff.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package integram
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to look for the chats without human activity
+const hibernationCheckInterval = time.Hour * 24
+
+// chat's last human activity is stored not more often than this
+const humanActivityPrecision = time.Hour * 24
+
+// IsHibernated returns true if the chat had no human activity for Config.HibernateAfter and its subscriptions are not processed until the next message
+func (d *chatData) IsHibernated() bool {
+	return d.HibernatedAt != nil
+}
+
+// trackHumanActivity stores the chat's last human activity and wakes the chat up if it was hibernated
+func trackHumanActivity(db *mgo.Database, chatID int64) {
+	now := time.Now()
+
+	var prev chatData
+	_, err := db.C("chats").Find(bson.M{"_id": chatID, "$or": []bson.M{
+		{"lasthumanat": bson.M{"$lt": now.Add(-humanActivityPrecision)}},
+		{"lasthumanat": bson.M{"$exists": false}},
+		{"hibernatedat": bson.M{"$exists": true}},
+	}}).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"lasthumanat": now}, "$unset": bson.M{"hibernatedat": ""}}}, &prev)
+
+	if err == mgo.ErrNotFound {
+		// activity is already tracked recently
+		return
+	} else if err != nil {
+		log.WithError(err).WithField("chat", chatID).Error("Can't track the chat's human activity")
+		return
+	}
+
+	if prev.IsHibernated() {
+		log.WithField("chat", chatID).Infof("Chat woke up after the hibernation since %s", prev.HibernatedAt.Format(time.RFC3339))
+	}
+}
+
+// hibernationChecker periodically hibernates the group chats without human activity for Config.HibernateAfter
+func hibernationChecker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("hibernationChecker panic recovered %v", r)
+			hibernationChecker()
+		}
+	}()
+
+	if Config.HibernateAfter <= 0 || Config.IsStandAloneServiceInstance() {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		info, err := hibernateInactiveChats(db, time.Now().Add(-Config.HibernateAfter))
+		if err != nil {
+			log.WithError(err).Error("hibernationChecker: can't hibernate the chats")
+		} else if info.Updated > 0 {
+			log.Infof("hibernationChecker: %d chats hibernated", info.Updated)
+		}
+
+		time.Sleep(hibernationCheckInterval)
+	}
+}
+
+// hibernateInactiveChats hibernates the group chats with the last human activity before the time. Chats without tracked activity are backfilled first
+func hibernateInactiveChats(db *mgo.Database, before time.Time) (*mgo.ChangeInfo, error) {
+	err := backfillHumanActivity(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.C("chats").UpdateAll(
+		bson.M{"_id": bson.M{"$lt": 0}, "lasthumanat": bson.M{"$lt": before}, "hibernatedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"hibernatedat": time.Now()}})
+}
+
+// backfillHumanActivity sets the last human activity of the group chats tracked before it was stored, e.g. created earlier.
+// The last message's date is used or the chat's creation time if there are no messages
+func backfillHumanActivity(db *mgo.Database) error {
+	var chat struct {
+		ID        int64 `bson:"_id"`
+		CreatedAt time.Time
+	}
+
+	iter := db.C("chats").Find(bson.M{"_id": bson.M{"$lt": 0}, "lasthumanat": bson.M{"$exists": false}, "hibernatedat": bson.M{"$exists": false}}).Select(bson.M{"createdat": 1}).Iter()
+	for iter.Next(&chat) {
+		lastAt := chat.CreatedAt
+
+		var last Message
+		err := db.C("messages").Find(bson.M{"chatid": chat.ID}).Sort("-_id").Select(bson.M{"date": 1}).One(&last)
+		if err == nil {
+			lastAt = last.Date
+		} else if err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+
+		if lastAt.IsZero() {
+			lastAt = time.Now()
+		}
+
+		err = db.C("chats").Update(bson.M{"_id": chat.ID, "lasthumanat": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"lasthumanat": lastAt}})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,66 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_hibernateInactiveChats(t *testing.T) {
+	clearData()
+	defer clearData()
+
+	lastHumanAt := time.Now().Add(-time.Hour * 24 * 100)
+	err := db.C("chats").Insert(bson.M{"_id": -9999999999, "title": "dead chat", "lasthumanat": lastHumanAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = hibernateInactiveChats(db, time.Now().Add(-time.Hour*24*90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chat chatData
+	db.C("chats").FindId(-9999999999).One(&chat)
+	if !chat.IsHibernated() {
+		t.Fatalf("chat isn't hibernated")
+	}
+
+	trackHumanActivity(db, -9999999999)
+
+	chat = chatData{}
+	db.C("chats").FindId(-9999999999).One(&chat)
+	if chat.IsHibernated() {
+		t.Errorf("chat wasn't woken up by the human activity")
+	}
+
+	if chat.LastHumanAt == nil || !chat.LastHumanAt.After(lastHumanAt) {
+		t.Errorf("LastHumanAt = %v, want updated", chat.LastHumanAt)
+	}
+}
+
+func Test_backfillHumanActivity(t *testing.T) {
+	clearData()
+	defer clearData()
+	defer db.C("messages").RemoveAll(bson.M{"chatid": -9999999999})
+
+	lastMessageAt := time.Now().Add(-time.Hour * 24 * 100).Truncate(time.Millisecond)
+	db.C("chats").Insert(bson.M{"_id": -9999999999, "title": "legacy chat"})
+	db.C("messages").Insert(Message{ID: bson.NewObjectIdWithTime(lastMessageAt), ChatID: -9999999999, BotID: 1, MsgID: 1, Date: lastMessageAt})
+
+	_, err := hibernateInactiveChats(db, time.Now().Add(-time.Hour*24*90))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chat chatData
+	db.C("chats").FindId(-9999999999).One(&chat)
+	if chat.LastHumanAt == nil || !chat.LastHumanAt.Equal(lastMessageAt) {
+		t.Errorf("LastHumanAt = %v, want the last message's date %v", chat.LastHumanAt, lastMessageAt)
+	}
+	if !chat.IsHibernated() {
+		t.Errorf("chat without the tracked activity isn't hibernated")
+	}
+}
@@ -0,0 +1,244 @@
+package integram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chat command to choose the user's language from the service's translations
+const languageCommand = "language"
+
+// data of the /language message's buttons: '_lang:de'. Empty code resets to the Telegram app's language
+const languageCallbackPrefix = "_lang:"
+
+// language of the texts used as the keys when Service.DefaultLanguage is not set
+const i18nDefaultLanguage = "en"
+
+// key of the translation file with the language's own name shown by /language, e.g. "Deutsch"
+const languageNameKey = "_name"
+
+// loadTranslations reads the '<lang>.json' files of the dir. Each file is the flat object of the keys and fmt formats
+func loadTranslations(dir string) (map[string]map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	translations := make(map[string]map[string]string)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		lang := normalizeTranslationLanguage(strings.TrimSuffix(f.Name(), ".json"))
+		if lang == "" {
+			return nil, fmt.Errorf("%s: file name must be the language code, e.g. de.json", f.Name())
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		texts := make(map[string]string)
+		if err := json.Unmarshal(data, &texts); err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name(), err.Error())
+		}
+		translations[lang] = texts
+	}
+
+	return translations, nil
+}
+
+// defaultLanguage returns the language of the service's texts used as the keys
+func (s *Service) defaultLanguage() string {
+	if s.DefaultLanguage != "" {
+		return strings.ToLower(s.DefaultLanguage)
+	}
+	return i18nDefaultLanguage
+}
+
+// translation returns the service's text for the key in the language. Base language and then the default one are used if there is no exact translation
+func (s *Service) translation(lang string, key string) (string, bool) {
+	lang = strings.Replace(strings.ToLower(lang), "_", "-", -1)
+	candidates := []string{lang}
+	if i := strings.Index(lang, "-"); i > -1 {
+		candidates = append(candidates, lang[0:i])
+	}
+	candidates = append(candidates, s.defaultLanguage())
+
+	for _, l := range candidates {
+		if text, exists := s.translations[l][key]; exists && text != "" {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+// languages returns the sorted codes of the languages the service is translated to, including the default one
+func (s *Service) languages() []string {
+	langs := []string{s.defaultLanguage()}
+	for lang := range s.translations {
+		if !SliceContainsString(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+
+	sort.Strings(langs)
+	return langs
+}
+
+// languageName returns the language's own name from its translation file or the code
+func (s *Service) languageName(lang string) string {
+	if name := s.translations[lang][languageNameKey]; name != "" {
+		return name
+	}
+	return lang
+}
+
+// T returns the text for the key in the current chat's language formatted with args, see Context.Lang.
+// Key is returned as is if there is no translation, so the texts of the default language may be used as the keys
+func (c *Context) T(key string, args ...interface{}) string {
+	format := key
+	if s := c.Service(); s != nil {
+		if text, exists := s.translation(c.Lang(), key); exists {
+			format = text
+		}
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Lang returns the language of the current chat: set with Chat.SetLang for the group, otherwise the user's one, see User.Language
+func (c *Context) Lang() string {
+	r := c.Recipient()
+	if r.Lang == "" && c.User.ID != 0 && c.User.ctx != nil {
+		return strings.ToLower(c.User.Language())
+	}
+	return r.Lang
+}
+
+// Language returns the language chosen by the user with /language or the Telegram app's one
+func (user *User) Language() string {
+	if user.Locale == "" && user.data == nil && user.ID != 0 && user.ctx != nil {
+		user.getData()
+	}
+
+	if user.Locale != "" {
+		return user.Locale
+	}
+	return user.Lang
+}
+
+// SetLanguage sets the language the user prefers over the Telegram app's one. Empty string resets it
+func (user *User) SetLanguage(lang string) error {
+	user.Locale = lang
+	if user.data != nil {
+		user.data.Locale = lang
+	}
+
+	if lang == "" {
+		return user.ctx.db.C("users").UpdateId(user.ID, bson.M{"$unset": bson.M{"locale": ""}})
+	}
+	return user.ctx.db.C("users").UpdateId(user.ID, bson.M{"$set": bson.M{"locale": lang}})
+}
+
+// languageKeyboard returns the buttons of the service's languages, the current one is checked
+func (s *Service) languageKeyboard(current string) InlineKeyboard {
+	kb := InlineKeyboard{}
+
+	var row InlineButtons
+	for _, lang := range s.languages() {
+		text := s.languageName(lang)
+		if lang == current {
+			text = "✅ " + text
+		}
+
+		row = append(row, InlineButton{Text: text, Data: languageCallbackPrefix + lang})
+		if len(row) == 2 {
+			kb.AppendRows(row)
+			row = nil
+		}
+	}
+
+	if len(row) > 0 {
+		kb.AppendRows(row)
+	}
+
+	kb.AppendRows(InlineButtons{InlineButton{Text: "📱 Telegram app's language", Data: languageCallbackPrefix}})
+	return kb
+}
+
+// isLanguageCallback returns true if the button of the /language message is pressed
+func isLanguageCallback(data string) bool {
+	return strings.HasPrefix(data, languageCallbackPrefix)
+}
+
+// handleLanguageCallback sets the language pressed in the /language message for the user
+func (c *Context) handleLanguageCallback() error {
+	lang := strings.TrimPrefix(c.Callback.Data, languageCallbackPrefix)
+	if lang != "" && !SliceContainsString(c.Service().languages(), lang) {
+		return c.AnswerCallbackQuery("This language is no longer available", false)
+	}
+
+	err := c.User.SetLanguage(lang)
+	if err != nil {
+		return err
+	}
+
+	// group's message is shared with other users, so it stays unchanged
+	if c.Chat.IsGroup() {
+		return c.AnswerCallbackQuery(c.T("Language changed"), false)
+	}
+
+	om := c.Callback.Message
+	err = c.EditMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, c.T("Choose the language"), c.Service().languageKeyboard(lang))
+	if err != nil {
+		return err
+	}
+
+	return c.AnswerCallbackQuery(c.T("Language changed"), false)
+}
+
+// handleLanguageCommand process '/language' for the services with the translations. Returns true if message was handled
+func (c *Context) handleLanguageCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	s := c.Service()
+	if s == nil || len(s.translations) == 0 {
+		return false
+	}
+
+	cmd, _ := c.Message.GetCommand()
+	if cmd != coreCommand(languageCommand) {
+		return false
+	}
+
+	// current language is checked in the keyboard
+	if _, err := c.User.getData(); err != nil {
+		c.Log().WithError(err).Error("handleLanguageCommand: can't get the user")
+	}
+
+	err := c.NewMessage().
+		SetReplyToMsgID(c.Message.MsgID).
+		SetText(c.T("Choose the language")).
+		SetParseMode("").
+		SetInlineKeyboard(s.languageKeyboard(c.User.Locale)).
+		Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleLanguageCommand: can't send the reply")
+	}
+
+	return true
+}
@@ -0,0 +1,73 @@
+package integram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadTranslations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integram-i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"_name": "Deutsch", "Hello, %s": "Hallo, %s"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "pt_BR.json"), []byte(`{"Hello, %s": "Olá, %s"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte(`not a translation`), 0644)
+
+	got, err := loadTranslations(dir)
+	if err != nil {
+		t.Fatalf("loadTranslations() error = %v", err)
+	}
+
+	want := map[string]map[string]string{
+		"de":    {"_name": "Deutsch", "Hello, %s": "Hallo, %s"},
+		"pt-br": {"Hello, %s": "Olá, %s"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadTranslations() = %v, want %v", got, want)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "german.json"), []byte(`{}`), 0644)
+	if _, err := loadTranslations(dir); err == nil {
+		t.Error("loadTranslations() expected error for the file without the language code")
+	}
+}
+
+func TestService_translation(t *testing.T) {
+	s := &Service{DefaultLanguage: "EN", translations: map[string]map[string]string{
+		"en": {"greeting": "Hello, %s", "bye": "Bye"},
+		"pt": {"greeting": "Olá, %s"},
+		"de": {"greeting": ""},
+	}}
+
+	tests := []struct {
+		lang       string
+		key        string
+		want       string
+		wantExists bool
+	}{
+		{"pt", "greeting", "Olá, %s", true},
+		{"pt_BR", "greeting", "Olá, %s", true},
+		{"pt-br", "bye", "Bye", true},
+		{"de", "greeting", "Hello, %s", true},
+		{"", "greeting", "Hello, %s", true},
+		{"pt", "unknown", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang+"/"+tt.key, func(t *testing.T) {
+			got, exists := s.translation(tt.lang, tt.key)
+			if got != tt.want || exists != tt.wantExists {
+				t.Errorf("Service.translation() = %v, %v, want %v, %v", got, exists, tt.want, tt.wantExists)
+			}
+		})
+	}
+
+	if got := s.languages(); !reflect.DeepEqual(got, []string{"de", "en", "pt"}) {
+		t.Errorf("Service.languages() = %v", got)
+	}
+}
@@ -0,0 +1,144 @@
+package integram
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// callback data of the inline buttons translated from the reply keyboard is the stored button's data with this prefix
+const replyKeyboardCallbackPrefix = "_rk:"
+
+const replyKeyboardMovedText = "⌨️ Menu moved to the buttons below"
+
+var errNoReplyKeyboardButtons = errors.New("none of the stored reply keyboard's buttons found in the layout")
+
+// translateReplyKeyboard returns the inline equivalent of the stored reply keyboard. Stored buttons are keyed by the text's checksum, so the texts are taken from the layout.
+// Buttons of the layout which aren't stored or which data doesn't fit the callback are skipped
+func translateReplyKeyboard(stored map[string]string, layout Keyboard) (kb InlineKeyboard, translated int) {
+	for _, row := range layout {
+		var inlineRow InlineButtons
+		for _, button := range row {
+			data, exists := stored[checksumString(button.Text)]
+			if !exists || len(replyKeyboardCallbackPrefix+data) > inlineButtonDataMaxLength {
+				continue
+			}
+			inlineRow = append(inlineRow, InlineButton{Text: button.Text, Data: replyKeyboardCallbackPrefix + data})
+		}
+
+		if len(inlineRow) > 0 {
+			kb.Buttons = append(kb.Buttons, inlineRow)
+			translated += len(inlineRow)
+		}
+	}
+	return
+}
+
+// MigrateReplyKeyboard replaces the reply keyboard stored for the current chat with the inline one sent with the text. Texts of the buttons are taken from the layout,
+// the service's current reply keyboard, and matched with the stored buttons by checksum. Pressed inline buttons are passed to TGNewMessageHandler as the reply keyboard's answers,
+// so KeyboardAnswer returns the same data. Returns false if the chat has no stored reply keyboard
+func (c *Context) MigrateReplyKeyboard(layout Keyboard, text string) (bool, error) {
+	stored, err := c.keyboard()
+	if err != nil {
+		return false, err
+	}
+
+	if stored.ChatID == 0 || len(stored.Keyboard) == 0 {
+		return false, nil
+	}
+
+	kb, translated := translateReplyKeyboard(stored.Keyboard, layout)
+	if translated == 0 {
+		return false, errNoReplyKeyboardButtons
+	}
+
+	// reply keyboard can't be removed with the message which has the inline one
+	err = c.NewMessage().SetText(c.T(replyKeyboardMovedText)).HideKeyboard().Send()
+	if err != nil {
+		return false, err
+	}
+
+	// selective keyboards are stored per user
+	if c.User.ID != 0 {
+		err = c.db.C("users").UpdateId(c.User.ID, bson.M{"$pull": bson.M{"keyboardperchat": bson.M{"chatid": stored.ChatID, "botid": stored.BotID}}})
+		if err != nil {
+			c.Log().WithError(err).Error("MigrateReplyKeyboard: can't remove the user's keyboard")
+		}
+	}
+
+	err = c.NewMessage().SetText(text).SetInlineKeyboard(kb).Send()
+	if err != nil {
+		return false, err
+	}
+
+	c.Log().WithField("buttons", translated).WithField("stored", len(stored.Keyboard)).Info("Reply keyboard migrated to the inline one")
+	return true, nil
+}
+
+// migrateReplyKeyboardOnAnswer migrates the chat's reply keyboard after its button was pressed if the service defines ReplyKeyboardMigration
+func (c *Context) migrateReplyKeyboardOnAnswer(s *Service) {
+	if c.Message == nil || c.Message.Text == "" {
+		return
+	}
+
+	stored, err := c.keyboard()
+	if err != nil || stored.ChatID == 0 {
+		return
+	}
+
+	if _, pressed := stored.Keyboard[checksumString(c.Message.Text)]; !pressed {
+		return
+	}
+
+	layout, text := s.ReplyKeyboardMigration(c)
+	if len(layout) == 0 {
+		return
+	}
+
+	_, err = c.MigrateReplyKeyboard(layout, text)
+	if err != nil {
+		c.Log().WithError(err).Error("Can't migrate the reply keyboard")
+	}
+}
+
+// isReplyKeyboardCallback returns true if the button of the inline keyboard migrated from the reply one is pressed
+func isReplyKeyboardCallback(data string) bool {
+	return strings.HasPrefix(data, replyKeyboardCallbackPrefix)
+}
+
+// handleReplyKeyboardCallback passes the pressed button of the migrated keyboard to TGNewMessageHandler as the message with the button's text
+func (c *Context) handleReplyKeyboardCallback(s *Service) error {
+	button := Button{Data: strings.TrimPrefix(c.Callback.Data, replyKeyboardCallbackPrefix)}
+	for _, row := range c.Callback.Message.InlineKeyboardMarkup.Buttons {
+		for _, b := range row {
+			if b.Data == c.Callback.Data {
+				button.Text = b.Text
+			}
+		}
+	}
+
+	if s.TGNewMessageHandler == nil {
+		return c.AnswerCallbackQuery("", false)
+	}
+
+	c.replyKeyboardAnswer = &button
+	c.Message = &IncomingMessage{
+		Message: Message{BotID: c.Bot().ID, FromID: c.User.ID, ChatID: c.Chat.ID, Date: time.Now(), Text: button.Text},
+		From:    c.User,
+		Chat:    c.Chat,
+	}
+
+	err := c.runHandler("message", s.withMiddlewares(s.TGNewMessageHandler), nil)
+	if err == ErrHandlerTimeout {
+		return c.AnswerCallbackQuery(callbackInProgressText, false)
+	} else if err != nil {
+		return err
+	}
+
+	if c.Callback.AnsweredAt == nil {
+		return c.AnswerCallbackQuery("", false)
+	}
+	return nil
+}
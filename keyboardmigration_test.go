@@ -0,0 +1,68 @@
+package integram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_translateReplyKeyboard(t *testing.T) {
+	stored := Keyboard{
+		{{Text: "📋 My tasks", Data: "tasks"}, {Text: "➕ New task", Data: "new"}},
+		{{Text: "⚙️ Settings", Data: "settings"}, {Text: "Long", Data: strings.Repeat("x", 61)}},
+	}.db()
+
+	tests := []struct {
+		name           string
+		layout         Keyboard
+		want           []InlineButtons
+		wantTranslated int
+	}{
+		{
+			"same layout",
+			Keyboard{{{Text: "📋 My tasks"}, {Text: "➕ New task"}}, {{Text: "⚙️ Settings"}}},
+			[]InlineButtons{
+				{{Text: "📋 My tasks", Data: "_rk:tasks"}, {Text: "➕ New task", Data: "_rk:new"}},
+				{{Text: "⚙️ Settings", Data: "_rk:settings"}},
+			},
+			3,
+		},
+		{
+			"renamed button skipped",
+			Keyboard{{{Text: "📋 Tasks"}, {Text: "➕ New task"}}, {{Text: "⚙️ Settings"}}},
+			[]InlineButtons{
+				{{Text: "➕ New task", Data: "_rk:new"}},
+				{{Text: "⚙️ Settings", Data: "_rk:settings"}},
+			},
+			2,
+		},
+		{
+			"data too long",
+			Keyboard{{{Text: "Long"}}},
+			nil,
+			0,
+		},
+		{
+			"nothing stored",
+			Keyboard{{{Text: "Help"}}},
+			nil,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, translated := translateReplyKeyboard(stored, tt.layout)
+			if !reflect.DeepEqual(kb.Buttons, tt.want) || translated != tt.wantTranslated {
+				t.Errorf("translateReplyKeyboard() = %v, %d, want %v, %d", kb.Buttons, translated, tt.want, tt.wantTranslated)
+			}
+
+			for _, row := range kb.Buttons {
+				for _, b := range row {
+					if !isReplyKeyboardCallback(b.Data) {
+						t.Errorf("isReplyKeyboardCallback(%q) = false, want true", b.Data)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// max size of the file that bot can upload via Bot API
+const largeDocumentMaxSize = 50 * 1024 * 1024
+
+// placeholder is edited not more often than this and only when the percentage increased at least by largeDocumentProgressStep
+const (
+	largeDocumentProgressInterval = time.Second * 3
+	largeDocumentProgressStep     = 10
+)
+
+// uploadProgressReader counts the bytes read by the uploader and reports the percentage
+type uploadProgressReader struct {
+	r        io.Reader
+	total    int64
+	read     int64
+	step     int
+	interval time.Duration
+
+	reportedPercent int
+	reportedAt      time.Time
+	report          func(percent int)
+}
+
+func (p *uploadProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	percent := uploadProgressPercent(p.read, p.total)
+	if percent-p.reportedPercent >= p.step && percent < 100 && time.Since(p.reportedAt) >= p.interval {
+		p.reportedPercent = percent
+		p.reportedAt = time.Now()
+		p.report(percent)
+	}
+
+	return n, err
+}
+
+// uploadProgressPercent returns the uploaded percentage in the range 0..100
+func uploadProgressPercent(read int64, total int64) int {
+	if total <= 0 || read <= 0 {
+		return 0
+	}
+	if read >= total {
+		return 100
+	}
+	return int(read * 100 / total)
+}
+
+func largeDocumentPlaceholderText(name string, percent int) string {
+	return fmt.Sprintf("⏳ Uploading %s… %d%%", name, percent)
+}
+
+// saveLargeDocument saves the URL or stream attachment to the temp file. Fails once more than maxSize bytes are read or the URL's Content-Length exceeds it
+func (c *Context) saveLargeDocument(a Attachment, maxSize int64) (string, error) {
+	var r io.Reader
+	switch a.Source {
+	case AttachmentSourceStream:
+		if a.Reader == nil {
+			return "", errors.New("Attachment's Reader is nil")
+		}
+		r = a.Reader
+	case AttachmentSourceURL:
+		resp, err := attachmentHTTPClient.Get(a.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return "", errors.New("non 2xx resp status")
+		}
+		if resp.ContentLength > maxSize {
+			return "", largeDocumentTooLargeError(resp.ContentLength)
+		}
+		r = resp.Body
+	default:
+		return "", fmt.Errorf("Unknown attachment source '%s'", a.Source)
+	}
+
+	out, err := ioutil.TempFile("", fmt.Sprintf("%d_%d_", c.Chat.ID, time.Now().UnixNano()))
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	// read one byte more to detect the overflow
+	n, err := io.Copy(out, io.LimitReader(r, maxSize+1))
+	if err == nil && n > maxSize {
+		err = largeDocumentTooLargeError(n)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
+
+func largeDocumentTooLargeError(size int64) error {
+	return fmt.Errorf("File is too large to upload: %d bytes, max %d", size, largeDocumentMaxSize)
+}
+
+// sendFileWithProgress uploads the message's local document reporting the percentage with m.uploadProgress
+func (m *OutgoingMessage) sendFileWithProgress(bot *Bot) (tg.Message, error) {
+	msg, ok := m.fileUploadConfig().(tg.DocumentConfig)
+	if !ok {
+		return bot.API.Send(m.fileUploadConfig())
+	}
+
+	f, err := os.Open(m.FilePath)
+	if err != nil {
+		return tg.Message{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	pr := &uploadProgressReader{
+		r:        f,
+		total:    info.Size(),
+		step:     largeDocumentProgressStep,
+		interval: largeDocumentProgressInterval,
+		report:   m.uploadProgress,
+	}
+
+	msg.File = tg.FileReader{Name: m.FileName, Reader: pr, Size: info.Size()}
+	return bot.API.Send(msg)
+}
+
+// SendLargeDocument uploads the document to the current chat while showing the upload progress in the placeholder message.
+// Placeholder is removed once the document is sent or edited with the error otherwise. URL and stream attachments are saved to the temp file first.
+// Both messages are sent directly instead of the jobs queue to report the progress, but stored as usual
+func (c *Context) SendLargeDocument(a Attachment, caption string) error {
+	if a.Source == AttachmentSourceFileID {
+		return c.NewMessage().SetText(caption).SetAttachment(a).Send()
+	}
+
+	if a.Size > largeDocumentMaxSize {
+		return largeDocumentTooLargeError(a.Size)
+	}
+
+	if c.Bot() == nil {
+		return errors.New("SendLargeDocument: bot not found")
+	}
+
+	localPath := a.LocalPath
+	if a.Source != AttachmentSourceLocal {
+		var err error
+		localPath, err = c.saveLargeDocument(a, largeDocumentMaxSize)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(localPath)
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	if size > largeDocumentMaxSize {
+		return largeDocumentTooLargeError(size)
+	}
+
+	err = c.scanFile(localPath, a.Name, fileScanToChat)
+	if err != nil {
+		return err
+	}
+
+	name := a.Name
+	if name == "" {
+		name = "file"
+	}
+
+	placeholder := c.NewMessage().SetText(largeDocumentPlaceholderText(name, 0)).SetParseMode("")
+	err = placeholder.sendNow()
+	if err != nil {
+		return err
+	}
+
+	// edits are sent from the separate goroutine to not slow down the upload
+	progress := make(chan int, 1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for percent := range progress {
+			err := c.EditMessageText(placeholder, largeDocumentPlaceholderText(name, percent))
+			if err != nil {
+				c.Log().WithError(err).Debug("SendLargeDocument: can't edit the placeholder")
+			}
+		}
+	}()
+
+	m := c.NewMessage().SetText(caption)
+	m.FilePath = localPath
+	m.FileName = name
+	m.FileType = string(FileTypeDocument)
+	m.uploadProgress = func(percent int) {
+		select {
+		case progress <- percent:
+		default:
+			// previous edit is still in progress
+		}
+	}
+
+	startedAt := time.Now()
+	err = m.sendNow()
+
+	close(progress)
+	wg.Wait()
+
+	if err != nil {
+		c.Log().WithError(err).WithField("size", size).Error("SendLargeDocument: upload failed")
+
+		editErr := c.EditMessageText(placeholder, fmt.Sprintf("❌ Can't upload %s", name))
+		if editErr != nil {
+			c.Log().WithError(editErr).Error("SendLargeDocument: can't edit the placeholder")
+		}
+		return err
+	}
+
+	c.Log().WithField("size", size).Debugf("SendLargeDocument: %s uploaded in %.2f secs", name, time.Since(startedAt).Seconds())
+
+	err = c.DeleteMessage(placeholder)
+	if err != nil {
+		c.Log().WithError(err).Error("SendLargeDocument: can't delete the placeholder")
+	}
+
+	return nil
+}
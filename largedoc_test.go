@@ -0,0 +1,100 @@
+package integram
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_uploadProgressPercent(t *testing.T) {
+	tests := []struct {
+		name  string
+		read  int64
+		total int64
+		want  int
+	}{
+		{"nothing read", 0, 100, 0},
+		{"half", 50, 100, 50},
+		{"rounded down", 999, 1000, 99},
+		{"done", 100, 100, 100},
+		{"unknown total", 50, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := uploadProgressPercent(tt.read, tt.total); got != tt.want {
+			t.Errorf("%q. uploadProgressPercent() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_uploadProgressReader(t *testing.T) {
+	var reported []int
+	pr := &uploadProgressReader{
+		r:      bytes.NewReader(make([]byte, 1000)),
+		total:  1000,
+		step:   25,
+		report: func(percent int) { reported = append(reported, percent) },
+	}
+
+	buf := make([]byte, 100)
+	for {
+		if _, err := pr.Read(buf); err != nil {
+			break
+		}
+	}
+
+	// 100% is not reported because the placeholder is removed after the upload
+	want := []int{30, 60, 90}
+	if !reflect.DeepEqual(reported, want) {
+		t.Errorf("uploadProgressReader reported %v, want %v", reported, want)
+	}
+
+	pr = &uploadProgressReader{r: bytes.NewReader([]byte("abc")), total: 3, step: 10, report: func(int) {}}
+	if data, _ := ioutil.ReadAll(pr); string(data) != "abc" {
+		t.Errorf("uploadProgressReader changed the data: %q", data)
+	}
+}
+
+func TestContext_saveLargeDocument(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(r.URL.Path)))
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	c := &Context{Chat: Chat{ID: 9999999999}}
+
+	tests := []struct {
+		name    string
+		a       Attachment
+		want    string
+		wantErr bool
+	}{
+		{"stream", AttachmentFromReader(FileTypeDocument, strings.NewReader("0123456789"), "a.txt"), "0123456789", false},
+		{"stream too large", AttachmentFromReader(FileTypeDocument, strings.NewReader("0123456789a"), "a.txt"), "", true},
+		{"url", AttachmentFromURL(FileTypeDocument, ts.URL+"/short", "a.txt"), "/short", false},
+		{"url too large", AttachmentFromURL(FileTypeDocument, ts.URL+"/very/long/path", "a.txt"), "", true},
+		{"local", AttachmentFromLocalPath(FileTypeDocument, "/tmp/a.txt", ""), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.saveLargeDocument(tt.a, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Context.saveLargeDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer os.Remove(got)
+
+			if data, _ := ioutil.ReadFile(got); string(data) != tt.want {
+				t.Errorf("Context.saveLargeDocument() content = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package integram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to reload the maintenance windows, so all the instances switch the mode at the same time
+const maintenanceCheckInterval = time.Second * 10
+
+// Telegram updates queued during the maintenance are dropped after this period
+const maintenanceQueuedUpdatesTTL = time.Hour * 24 * 7
+
+// Retry-After returned to the upstream webhooks when the maintenance ETA is unknown
+const maintenanceDefaultRetryAfter = time.Minute * 5
+
+// maintenanceWindow is the period when webhooks are rejected and Telegram updates are queued. Scheduled with /integram maintenance
+type maintenanceWindow struct {
+	ID      bson.ObjectId `bson:"_id"`
+	From    time.Time     `bson:"from"`
+	To      time.Time     `bson:"to,omitempty"` // zero until the maintenance is turned off manually
+	Message string        `bson:"msg,omitempty"`
+}
+
+// queuedUpdate is the Telegram update received during the maintenance
+type queuedUpdate struct {
+	ID     bson.ObjectId `bson:"_id"`
+	BotID  int64         `bson:"b"`
+	Update []byte        `bson:"u"` // JSON-encoded tg.Update
+	Date   time.Time     `bson:"d"`
+}
+
+var maintenanceMutex = sync.RWMutex{}
+var activeMaintenance *maintenanceWindow
+
+var maintenanceNoticeTexts = map[string]string{
+	"en": "🛠 The bot is under maintenance right now. Please try again later",
+	"ru": "🛠 Сейчас проводятся технические работы. Пожалуйста, попробуйте позже",
+	"de": "🛠 Der Bot wird gerade gewartet. Bitte versuche es später noch einmal",
+	"es": "🛠 El bot está en mantenimiento. Por favor, inténtalo más tarde",
+	"pt": "🛠 O bot está em manutenção. Por favor, tente novamente mais tarde",
+}
+
+var maintenanceETATexts = map[string]string{
+	"en": "Expected to be back in %s",
+	"ru": "Ожидаемое время окончания: через %s",
+	"de": "Voraussichtlich wieder verfügbar in %s",
+	"es": "Volveremos en %s",
+	"pt": "Voltaremos em %s",
+}
+
+func init() {
+	registerAdminCommand("maintenance", adminMaintenance)
+}
+
+func (w maintenanceWindow) isActive(now time.Time) bool {
+	return !w.From.After(now) && (w.To.IsZero() || now.Before(w.To))
+}
+
+// String returns the window's period
+func (w maintenanceWindow) String() string {
+	s := w.From.UTC().Format("2006-01-02 15:04") + " – "
+	if w.To.IsZero() {
+		s += "until turned off"
+	} else {
+		s += w.To.UTC().Format("2006-01-02 15:04") + " UTC"
+	}
+
+	if w.Message != "" {
+		s += ": " + w.Message
+	}
+	return s
+}
+
+// currentMaintenance returns the active maintenance window or nil
+func currentMaintenance() *maintenanceWindow {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+
+	if activeMaintenance == nil || !activeMaintenance.isActive(time.Now()) {
+		return nil
+	}
+	return activeMaintenance
+}
+
+// loadMaintenance reloads the active window
+func loadMaintenance(db *mgo.Database) error {
+	now := time.Now()
+
+	var w maintenanceWindow
+	err := db.C("maintenance").Find(bson.M{
+		"from": bson.M{"$lte": now},
+		"$or":  []bson.M{{"to": bson.M{"$exists": false}}, {"to": bson.M{"$gt": now}}},
+	}).Sort("from").One(&w)
+
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	if err == mgo.ErrNotFound {
+		activeMaintenance = nil
+	} else {
+		activeMaintenance = &w
+	}
+	return nil
+}
+
+// maintenanceWatcher reloads the maintenance windows and replays the queued Telegram updates when the maintenance is finished
+func maintenanceWatcher() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("maintenanceWatcher panic recovered %v", r)
+			maintenanceWatcher()
+		}
+	}()
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		err := loadMaintenance(db)
+		if err != nil {
+			log.WithError(err).Error("maintenanceWatcher: can't load the maintenance windows")
+		} else if currentMaintenance() == nil && !Config.IsStandAloneServiceInstance() {
+			// also picks up the updates left by the crashed instance
+			replayQueuedUpdates(db)
+		}
+
+		time.Sleep(maintenanceCheckInterval)
+	}
+}
+
+// maintenanceETA returns the rounded time left until the end of the window or empty string if unknown
+func maintenanceETA(w *maintenanceWindow, now time.Time) string {
+	if w.To.IsZero() {
+		return ""
+	}
+
+	left := w.To.Sub(now)
+	if left < time.Minute {
+		left = time.Minute
+	}
+
+	if left < time.Hour {
+		return fmt.Sprintf("~%d min", int(left.Minutes()+0.5))
+	}
+	return fmt.Sprintf("~%.0f h", left.Hours())
+}
+
+// maintenanceNotice returns the notice in the user's language with the ETA
+func maintenanceNotice(w *maintenanceWindow, lang string, now time.Time) string {
+	if i := strings.IndexAny(lang, "-_"); i > -1 {
+		lang = lang[0:i]
+	}
+	lang = strings.ToLower(lang)
+
+	text, exists := maintenanceNoticeTexts[lang]
+	if !exists {
+		lang = "en"
+		text = maintenanceNoticeTexts[lang]
+	}
+
+	if w.Message != "" {
+		text += "\n" + w.Message
+	}
+
+	if eta := maintenanceETA(w, now); eta != "" {
+		text += "\n" + fmt.Sprintf(maintenanceETATexts[lang], eta)
+	}
+	return text
+}
+
+// maintenanceRejectWebhook responds 503 to the upstream, so it retries the webhook after the maintenance. Returns true if the webhook was rejected
+func maintenanceRejectWebhook(c *gin.Context) bool {
+	w := currentMaintenance()
+	if w == nil {
+		return false
+	}
+
+	retryAfter := maintenanceDefaultRetryAfter
+	if !w.To.IsZero() {
+		retryAfter = time.Until(w.To)
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	c.String(http.StatusServiceUnavailable, "Service is under maintenance, retry later")
+	return true
+}
+
+// handleUpdateInMaintenance answers the user's commands and callbacks with the notice and queues the rest of the updates. Returns true if the update was handled.
+// Admins' updates are processed as usual to manage the maintenance
+func handleUpdateInMaintenance(b *Bot, u *tg.Update) bool {
+	w := currentMaintenance()
+	if w == nil {
+		return false
+	}
+
+	var from *tg.User
+	if u.Message != nil {
+		from = u.Message.From
+	} else if u.CallbackQuery != nil {
+		from = u.CallbackQuery.From
+	}
+
+	if from != nil && (&User{ID: from.ID}).IsAdmin() {
+		return false
+	}
+
+	now := time.Now()
+	if u.Message != nil && u.Message.IsCommand() {
+		msg := tg.NewMessage(u.Message.Chat.ID, maintenanceNotice(w, from.LanguageCode, now))
+		msg.ReplyToMessageID = u.Message.MessageID
+		if _, err := b.API.Send(msg); err != nil {
+			log.WithError(err).WithField("chat", u.Message.Chat.ID).Error("Can't send the maintenance notice")
+		}
+		return true
+	}
+
+	if u.CallbackQuery != nil {
+		_, err := b.API.AnswerCallbackQuery(tg.CallbackConfig{CallbackQueryID: u.CallbackQuery.ID, Text: maintenanceNotice(w, from.LanguageCode, now), ShowAlert: true})
+		if err != nil {
+			log.WithError(err).Error("Can't answer the callback with the maintenance notice")
+		}
+		return true
+	}
+
+	if u.InlineQuery != nil {
+		// outdated after the maintenance
+		return true
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		log.WithError(err).Error("Can't encode the update to queue during the maintenance")
+		return true
+	}
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	err = db.C("updates_queued").Insert(queuedUpdate{ID: bson.NewObjectId(), BotID: b.ID, Update: data, Date: now})
+	if err != nil {
+		log.WithError(err).Error("Can't queue the update during the maintenance")
+	}
+	return true
+}
+
+// replayQueuedUpdates processes the updates queued during the maintenance in the order they were received
+func replayQueuedUpdates(db *mgo.Database) {
+	for {
+		var qu queuedUpdate
+		_, err := db.C("updates_queued").Find(nil).Sort("_id").Apply(mgo.Change{Remove: true}, &qu)
+		if err == mgo.ErrNotFound {
+			return
+		} else if err != nil {
+			log.WithError(err).Error("replayQueuedUpdates: can't get the queued update")
+			return
+		}
+
+		b := botByID(qu.BotID)
+		if b == nil {
+			log.WithField("bot", qu.BotID).Error("replayQueuedUpdates: bot not found")
+			continue
+		}
+
+		var u tg.Update
+		err = json.Unmarshal(qu.Update, &u)
+		if err != nil {
+			log.WithError(err).Error("replayQueuedUpdates: can't decode the update")
+			continue
+		}
+
+		updateRoutine(b, &u)
+	}
+}
+
+// parseMaintenanceWindow parses the admin command's args: "on [duration] [message]" or "at time duration [message]"
+func parseMaintenanceWindow(args []string, now time.Time) (*maintenanceWindow, error) {
+	if len(args) == 0 {
+		return nil, errors.New("Usage: maintenance [on [duration] [message] | at 2006-01-02T15:04 duration [message] | off | cancel]")
+	}
+
+	w := &maintenanceWindow{ID: bson.NewObjectId(), From: now}
+	switch args[0] {
+	case "on":
+		args = args[1:]
+		if len(args) > 0 {
+			if d, err := time.ParseDuration(args[0]); err == nil && d > 0 {
+				w.To = now.Add(d)
+				args = args[1:]
+			}
+		}
+	case "at":
+		if len(args) < 3 {
+			return nil, errors.New("Usage: maintenance at 2006-01-02T15:04 duration [message]")
+		}
+
+		from, err := time.Parse("2006-01-02T15:04", args[1])
+		if err != nil {
+			from, err = time.Parse(time.RFC3339, args[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Wrong time '%s', use the UTC time like 2006-01-02T15:04", args[1])
+		}
+
+		d, err := time.ParseDuration(args[2])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Wrong duration '%s', use the duration like 30m or 2h", args[2])
+		}
+
+		if !from.After(now) {
+			return nil, errors.New("Scheduled maintenance must start in the future, use 'maintenance on' to start it now")
+		}
+
+		w.From = from
+		w.To = from.Add(d)
+		args = args[3:]
+	default:
+		return nil, fmt.Errorf("Unknown action '%s'", args[0])
+	}
+
+	w.Message = strings.Join(args, " ")
+	return w, nil
+}
+
+// adminMaintenance shows, starts, schedules or finishes the maintenance
+func adminMaintenance(c *Context, args []string) (string, error) {
+	now := time.Now()
+
+	if len(args) > 0 && (args[0] == "off" || args[0] == "cancel") {
+		var err error
+		var n int
+		if args[0] == "off" {
+			var info *mgo.ChangeInfo
+			info, err = c.db.C("maintenance").UpdateAll(bson.M{
+				"from": bson.M{"$lte": now},
+				"$or":  []bson.M{{"to": bson.M{"$exists": false}}, {"to": bson.M{"$gt": now}}},
+			}, bson.M{"$set": bson.M{"to": now}})
+			if info != nil {
+				n = info.Updated
+			}
+		} else {
+			var info *mgo.ChangeInfo
+			info, err = c.db.C("maintenance").RemoveAll(bson.M{"from": bson.M{"$gt": now}})
+			if info != nil {
+				n = info.Removed
+			}
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if err := loadMaintenance(c.db); err != nil {
+			c.Log().WithError(err).Error("adminMaintenance: can't reload the maintenance")
+		}
+
+		if args[0] == "off" {
+			return fmt.Sprintf("Maintenance finished (%d windows). Queued updates will be processed in %s", n, maintenanceCheckInterval), nil
+		}
+		return fmt.Sprintf("%d scheduled windows canceled", n), nil
+	}
+
+	if len(args) > 0 {
+		w, err := parseMaintenanceWindow(args, now)
+		if err != nil {
+			return "", err
+		}
+
+		err = c.db.C("maintenance").Insert(w)
+		if err != nil {
+			return "", err
+		}
+
+		if err := loadMaintenance(c.db); err != nil {
+			c.Log().WithError(err).Error("adminMaintenance: can't reload the maintenance")
+		}
+
+		log.Warnf("Maintenance scheduled by %d: %s", c.User.ID, w.String())
+		return "Maintenance scheduled: " + w.String(), nil
+	}
+
+	var windows []maintenanceWindow
+	err := c.db.C("maintenance").Find(bson.M{"$or": []bson.M{{"to": bson.M{"$exists": false}}, {"to": bson.M{"$gt": now}}}}).Sort("from").All(&windows)
+	if err != nil {
+		return "", err
+	}
+
+	if len(windows) == 0 {
+		return "No maintenance scheduled", nil
+	}
+
+	queued, _ := c.db.C("updates_queued").Count()
+
+	lines := []string{fmt.Sprintf("Updates queued: %d", queued)}
+	for _, w := range windows {
+		state := "scheduled"
+		if w.isActive(now) {
+			state = "ACTIVE"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", state, w.String()))
+	}
+	return strings.Join(lines, "\n"), nil
+}
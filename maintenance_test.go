@@ -0,0 +1,64 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_maintenanceNotice(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		w    maintenanceWindow
+		lang string
+		want string
+	}{
+		{"unknown ETA", maintenanceWindow{From: now}, "en", maintenanceNoticeTexts["en"]},
+		{"minutes", maintenanceWindow{From: now, To: now.Add(time.Minute * 20)}, "en-US", maintenanceNoticeTexts["en"] + "\nExpected to be back in ~20 min"},
+		{"hours with message", maintenanceWindow{From: now, To: now.Add(time.Hour * 2), Message: "DB migration"}, "ru", maintenanceNoticeTexts["ru"] + "\nDB migration\nОжидаемое время окончания: через ~2 h"},
+		{"unknown language", maintenanceWindow{From: now}, "xx", maintenanceNoticeTexts["en"]},
+	}
+	for _, tt := range tests {
+		if got := maintenanceNotice(&tt.w, tt.lang, now); got != tt.want {
+			t.Errorf("%q. maintenanceNotice() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_parseMaintenanceWindow(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    maintenanceWindow
+		wantErr bool
+	}{
+		{"on", []string{"on"}, maintenanceWindow{From: now}, false},
+		{"on with duration", []string{"on", "30m", "DB", "migration"}, maintenanceWindow{From: now, To: now.Add(time.Minute * 30), Message: "DB migration"}, false},
+		{"on with message", []string{"on", "upgrade"}, maintenanceWindow{From: now, Message: "upgrade"}, false},
+		{"at", []string{"at", "2018-05-11T03:00", "1h"}, maintenanceWindow{From: now.Add(time.Hour * 15), To: now.Add(time.Hour * 16)}, false},
+		{"at in the past", []string{"at", "2018-05-09T03:00", "1h"}, maintenanceWindow{}, true},
+		{"at without duration", []string{"at", "2018-05-11T03:00"}, maintenanceWindow{}, true},
+		{"unknown action", []string{"start"}, maintenanceWindow{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseMaintenanceWindow(tt.args, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. parseMaintenanceWindow() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if err == nil && (!got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) || got.Message != tt.want.Message) {
+			t.Errorf("%q. parseMaintenanceWindow() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	w := maintenanceWindow{From: now, To: now.Add(time.Hour)}
+	for at, want := range map[time.Duration]bool{-time.Second: false, 0: true, time.Minute: true, time.Hour: false} {
+		if got := w.isActive(now.Add(at)); got != want {
+			t.Errorf("maintenanceWindow.isActive(+%s) = %v, want %v", at, got, want)
+		}
+	}
+}
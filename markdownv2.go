@@ -0,0 +1,236 @@
+package integram
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseModeMarkdownV2 supports underline, strikethrough, spoiler and nested entities, but every reserved char of the text must be escaped, see EscapeMarkdownV2
+const ParseModeMarkdownV2 = "MarkdownV2"
+
+// chars that must be escaped with '\' anywhere in the MarkdownV2 text outside of the entities' markup
+const markdownV2Reserved = "_*[]()~`>#+-=|{}.!\\"
+
+var markdownV2Escaper = newBackslashEscaper(markdownV2Reserved)
+var markdownV2CodeEscaper = newBackslashEscaper("`\\")
+var markdownV2URLEscaper = newBackslashEscaper(")\\")
+
+// entity markers, the longer ones are checked first
+var markdownV2Markers = []string{"__", "||", "*", "_", "~"}
+
+var markdownV2EntityTypes = map[string]string{"*": "bold", "_": "italic", "__": "underline", "~": "strikethrough", "||": "spoiler", "`": "code", "```": "pre"}
+
+// markdownV2ParseError is returned when Telegram would reject the MarkdownV2 text. Offset is in bytes like in Telegram's errors
+type markdownV2ParseError struct {
+	Offset int
+	Reason string
+}
+
+func (e markdownV2ParseError) Error() string {
+	return fmt.Sprintf("%s at byte offset %d", e.Reason, e.Offset)
+}
+
+func newBackslashEscaper(chars string) *strings.Replacer {
+	var pairs []string
+	for _, c := range chars {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// EscapeMarkdownV2 escapes all the chars reserved by the MarkdownV2 parse mode, so the text is shown as is
+func EscapeMarkdownV2(s string) string {
+	return markdownV2Escaper.Replace(s)
+}
+
+// EscapeMarkdownV2Code escapes '`' and '\' for the text inside the MarkdownV2 code and pre entities
+func EscapeMarkdownV2Code(s string) string {
+	return markdownV2CodeEscaper.Replace(s)
+}
+
+// EscapeMarkdownV2URL escapes ')' and '\' for the URL inside the MarkdownV2 link: [text](URL)
+func EscapeMarkdownV2URL(s string) string {
+	return markdownV2URLEscaper.Replace(s)
+}
+
+// EnableMarkdownV2 sets parseMode to MarkdownV2
+func (m *OutgoingMessage) EnableMarkdownV2() *OutgoingMessage {
+	m.ParseMode = ParseModeMarkdownV2
+	return m
+}
+
+// parseMarkdownV2Entities returns the text without the formatting and its entities. Error is markdownV2ParseError
+func parseMarkdownV2Entities(text string) (string, []messageEntity, error) {
+	var plain bytes.Buffer
+	var entities []messageEntity
+
+	type openEntity struct {
+		marker string
+		start  int // byte offset in the text
+		offset int // UTF-16 offset in the plain text
+	}
+	var stack []openEntity
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		if c == '\\' {
+			if i+1 == len(text) {
+				return plain.String(), entities, markdownV2ParseError{i, "text must not end with '\\'"}
+			}
+			r, size := utf8.DecodeRuneInString(text[i+1:])
+			plain.WriteRune(r)
+			i += 1 + size
+			continue
+		}
+
+		if c == '`' {
+			marker := "`"
+			if strings.HasPrefix(text[i:], "```") {
+				marker = "```"
+			}
+
+			content, n, err := readMarkdownV2Code(text, i+len(marker), marker)
+			if err != nil {
+				return plain.String(), entities, err
+			}
+
+			if marker == "```" {
+				// first line is the language of the code block
+				if nl := strings.IndexByte(content, '\n'); nl > -1 && !strings.ContainsAny(content[:nl], " \t") {
+					content = content[nl+1:]
+				}
+			}
+
+			entities = append(entities, messageEntity{Type: markdownV2EntityTypes[marker], Offset: utf16Len(plain.String()), Length: utf16Len(content)})
+			plain.WriteString(content)
+			i += len(marker) + n
+			continue
+		}
+
+		if c == '[' {
+			stack = append(stack, openEntity{marker: "[", start: i, offset: utf16Len(plain.String())})
+			i++
+			continue
+		}
+
+		if c == ']' && len(stack) > 0 && stack[len(stack)-1].marker == "[" {
+			open := stack[len(stack)-1]
+			if !strings.HasPrefix(text[i+1:], "(") {
+				return plain.String(), entities, markdownV2ParseError{i, "link must be followed by the URL in parentheses"}
+			}
+
+			url, n, err := readMarkdownV2URL(text, i+2)
+			if err != nil {
+				return plain.String(), entities, err
+			}
+
+			stack = stack[:len(stack)-1]
+			entities = append(entities, messageEntity{Type: "text_link", Offset: open.offset, Length: utf16Len(plain.String()) - open.offset, URL: url})
+			i += 2 + n
+			continue
+		}
+
+		marker := ""
+		for _, m := range markdownV2Markers {
+			if strings.HasPrefix(text[i:], m) {
+				marker = m
+				break
+			}
+		}
+
+		if marker != "" {
+			if len(stack) > 0 && stack[len(stack)-1].marker == marker {
+				open := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				entities = append(entities, messageEntity{Type: markdownV2EntityTypes[marker], Offset: open.offset, Length: utf16Len(plain.String()) - open.offset})
+			} else {
+				stack = append(stack, openEntity{marker: marker, start: i, offset: utf16Len(plain.String())})
+			}
+			i += len(marker)
+			continue
+		}
+
+		if strings.IndexByte(markdownV2Reserved, c) > -1 {
+			return plain.String(), entities, markdownV2ParseError{i, fmt.Sprintf("character '%c' is reserved and must be escaped with the preceding '\\'", c)}
+		}
+
+		plain.WriteByte(c)
+		i++
+	}
+
+	if len(stack) > 0 {
+		return plain.String(), entities, markdownV2ParseError{stack[len(stack)-1].start, "can't find end of the entity"}
+	}
+
+	return plain.String(), entities, nil
+}
+
+// readMarkdownV2Code returns the unescaped content of the code entity starting at the byte offset and the bytes read including the closing marker
+func readMarkdownV2Code(text string, start int, marker string) (string, int, error) {
+	var content bytes.Buffer
+	for i := start; i < len(text); {
+		if text[i] == '\\' && i+1 < len(text) && (text[i+1] == '`' || text[i+1] == '\\') {
+			content.WriteByte(text[i+1])
+			i += 2
+			continue
+		}
+
+		if strings.HasPrefix(text[i:], marker) {
+			return content.String(), i + len(marker) - start, nil
+		}
+
+		content.WriteByte(text[i])
+		i++
+	}
+
+	return "", 0, markdownV2ParseError{start - len(marker), "can't find end of the entity"}
+}
+
+// readMarkdownV2URL returns the unescaped URL of the link starting at the byte offset and the bytes read including ')'
+func readMarkdownV2URL(text string, start int) (string, int, error) {
+	var url bytes.Buffer
+	for i := start; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text):
+			i++
+			url.WriteByte(text[i])
+		case text[i] == ')':
+			return url.String(), i + 1 - start, nil
+		default:
+			url.WriteByte(text[i])
+		}
+	}
+
+	return "", 0, markdownV2ParseError{start - 1, "can't find end of the URL"}
+}
+
+// unescapeMarkdownV2 removes the escaping backslashes, the markup is kept as is
+func unescapeMarkdownV2(text string) string {
+	var res bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && strings.IndexByte(markdownV2Reserved, text[i+1]) > -1 {
+			i++
+		}
+		res.WriteByte(text[i])
+	}
+	return res.String()
+}
+
+// textForEdit returns the text and the parse mode to edit the message with. Telegram rejects the whole edit if the MarkdownV2 is broken,
+// so the text is downgraded to the plain one to keep the message up to date
+func (c *Context) textForEdit(text string, parseMode string) (string, string) {
+	if parseMode != ParseModeMarkdownV2 {
+		return text, parseMode
+	}
+
+	_, _, err := parseMarkdownV2Entities(text)
+	if err == nil {
+		return text, parseMode
+	}
+
+	c.Log().WithError(err).Warn("Bad MarkdownV2 in the edited text, sent as the plain text")
+	return unescapeMarkdownV2(text), ""
+}
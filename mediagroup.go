@@ -0,0 +1,309 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Telegram's limits of the number of items in the album
+const (
+	MediaGroupMinItems = 2
+	MediaGroupMaxItems = 10
+)
+
+// MediaGroup is the album of photos and videos sent at once. Use Context.NewMediaGroup() to create it
+type MediaGroup struct {
+	ChatID       int64
+	ReplyToMsgID int
+	ParseMode    string
+	Silent       bool
+
+	items []mediaGroupItem
+	ctx   *Context
+}
+
+type mediaGroupItem struct {
+	Attachment
+	Caption string
+}
+
+// mediaGroupInputMedia is the InputMediaPhoto or InputMediaVideo of the sendMediaGroup request
+type mediaGroupInputMedia struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"` // file_id or attach://<field name>
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// NewMediaGroup creates the album targeted to the current chat
+func (c *Context) NewMediaGroup() *MediaGroup {
+	g := &MediaGroup{ctx: c}
+	if c.Chat.ID != 0 {
+		g.ChatID = c.Chat.ID
+	} else {
+		g.ChatID = c.User.ID
+	}
+	return g
+}
+
+// AddPhoto adds the photo to the album. Album's caption is the caption of its first item
+func (g *MediaGroup) AddPhoto(a Attachment, caption string) *MediaGroup {
+	a.Kind = FileTypePhoto
+	g.items = append(g.items, mediaGroupItem{Attachment: a, Caption: caption})
+	return g
+}
+
+// AddVideo adds the video to the album
+func (g *MediaGroup) AddVideo(a Attachment, caption string) *MediaGroup {
+	a.Kind = FileTypeVideo
+	g.items = append(g.items, mediaGroupItem{Attachment: a, Caption: caption})
+	return g
+}
+
+// SetReplyToMsgID sets the message to reply on
+func (g *MediaGroup) SetReplyToMsgID(msgID int) *MediaGroup {
+	g.ReplyToMsgID = msgID
+	return g
+}
+
+// SetSilent turns off the notification
+func (g *MediaGroup) SetSilent(b bool) *MediaGroup {
+	g.Silent = b
+	return g
+}
+
+// mediaGroupFileType returns the FileType of OutgoingMessage for the album's item
+func mediaGroupFileType(kind FileType) string {
+	if kind == FileTypePhoto {
+		return "image"
+	}
+	return string(kind)
+}
+
+// mediaGroupInput returns the sendMediaGroup's media of the item. file is the name of multipart field to upload the file or empty if fileID is used
+func mediaGroupInput(item mediaGroupItem, parseMode string, fileID string, file string) mediaGroupInputMedia {
+	in := mediaGroupInputMedia{Type: string(item.Kind), Media: fileID, Caption: item.Caption}
+	if file != "" {
+		in.Media = "attach://" + file
+	}
+
+	if in.Caption != "" {
+		in.ParseMode = parseMode
+	}
+	return in
+}
+
+// Send sends the album synchronously and stores its messages with the same MediaGroupID so they can be edited or deleted as a unit.
+// The same files sent again by the bot are not uploaded twice
+func (g *MediaGroup) Send() ([]*OutgoingMessage, error) {
+	if len(g.items) < MediaGroupMinItems || len(g.items) > MediaGroupMaxItems {
+		return nil, fmt.Errorf("Media group must contain %d-%d photos or videos, got %d", MediaGroupMinItems, MediaGroupMaxItems, len(g.items))
+	}
+
+	c := g.ctx
+	bot := c.Bot()
+	if bot == nil {
+		return nil, errors.New("MediaGroup: bot not found")
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+
+	media := make([]mediaGroupInputMedia, len(g.items))
+	uploadKeys := make([]string, len(g.items))
+
+	for i, item := range g.items {
+		if item.Source == AttachmentSourceFileID {
+			media[i] = mediaGroupInput(item, g.ParseMode, item.FileID, "")
+			continue
+		}
+
+		localPath, err := item.LocalFile(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if item.Source != AttachmentSourceLocal {
+			defer os.Remove(localPath)
+		}
+
+		err = c.scanFile(localPath, item.Name, fileScanToChat)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		uploadKeys[i], err = uploadedFileKey(bot.ID, mediaGroupFileType(item.Kind), item.Name, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if fileID := findUploadedFileID(c.db, uploadKeys[i]); fileID != "" {
+			media[i] = mediaGroupInput(item, g.ParseMode, fileID, "")
+			continue
+		}
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		field := "file" + strconv.Itoa(i)
+		name := item.Name
+		if name == "" {
+			name = field
+		}
+
+		part, err := w.CreateFormFile(field, name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.Copy(part, f)
+		if err != nil {
+			return nil, err
+		}
+
+		media[i] = mediaGroupInput(item, g.ParseMode, "", field)
+	}
+
+	mediaJSON, err := json.Marshal(media)
+	if err != nil {
+		return nil, err
+	}
+
+	w.WriteField("chat_id", strconv.FormatInt(g.ChatID, 10))
+	w.WriteField("media", string(mediaJSON))
+	if g.ReplyToMsgID != 0 {
+		w.WriteField("reply_to_message_id", strconv.Itoa(g.ReplyToMsgID))
+	}
+	if g.Silent {
+		w.WriteField("disable_notification", "true")
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	tgMsgs, err := sendMediaGroupRequest(bot, body, w.FormDataContentType())
+	if err != nil {
+		return nil, err
+	}
+
+	groupID := bson.NewObjectId().Hex()
+	var sent []*OutgoingMessage
+
+	for i, tgMsg := range tgMsgs {
+		om := &OutgoingMessage{ParseMode: g.ParseMode, Silent: g.Silent, MediaGroupID: groupID, ctx: c}
+		om.ID = bson.NewObjectId()
+		om.BotID = bot.ID
+		om.FromID = bot.ID
+		om.ChatID = g.ChatID
+		om.ReplyToMsgID = g.ReplyToMsgID
+		om.MsgID = tgMsg.MessageID
+		om.Date = time.Now()
+		om.FileID = uploadedFileID(&tgMsg)
+
+		if i < len(g.items) {
+			om.FileType = mediaGroupFileType(g.items[i].Kind)
+			om.FileName = g.items[i].Name
+			om.Text = g.items[i].Caption
+			om.TextHash = om.GetTextHash()
+
+			if uploadKeys[i] != "" && om.FileID != "" {
+				err := saveUploadedFileID(c.db, uploadKeys[i], bot.ID, om.FileID)
+				if err != nil {
+					c.Log().WithError(err).Error("Can't save the uploaded file's file_id")
+				}
+			}
+		}
+
+		err = c.db.C("messages").Insert(om)
+		if err != nil {
+			c.Log().WithError(err).Error("Error outgoing inserting media group message in db")
+		}
+
+		sent = append(sent, om)
+	}
+
+	return sent, nil
+}
+
+// sendMediaGroupRequest sends the multipart sendMediaGroup request. Bot API client can't upload several files at once and decode the array of messages
+func sendMediaGroupRequest(bot *Bot, body io.Reader, contentType string) ([]tg.Message, error) {
+	resp, err := bot.API.Client.Post(fmt.Sprintf(tg.APIEndpoint, bot.API.Token, "sendMediaGroup"), contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp tg.APIResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if !apiResp.Ok {
+		return nil, fmt.Errorf("sendMediaGroup failed: %d %s", apiResp.ErrorCode, apiResp.Description)
+	}
+
+	var msgs []tg.Message
+	err = json.Unmarshal(apiResp.Result, &msgs)
+	return msgs, err
+}
+
+// findMediaGroupMessages returns the stored messages of the album
+func (c *Context) findMediaGroupMessages(mediaGroupID string) ([]OutgoingMessage, error) {
+	var msgs []OutgoingMessage
+	err := c.db.C("messages").Find(bson.M{"botid": c.Bot().ID, "mediagroupid": mediaGroupID}).Sort("msgid").All(&msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msgs) == 0 {
+		return nil, errors.New("Media group not found")
+	}
+	return msgs, nil
+}
+
+// EditMediaGroupCaption edits the album's caption, which is the caption of its first item
+func (c *Context) EditMediaGroupCaption(mediaGroupID string, caption string) error {
+	msgs, err := c.findMediaGroupMessages(mediaGroupID)
+	if err != nil {
+		return err
+	}
+
+	return c.EditMessageCaption(&msgs[0], caption)
+}
+
+// DeleteMediaGroup deletes all the album's messages
+func (c *Context) DeleteMediaGroup(mediaGroupID string) error {
+	msgs, err := c.findMediaGroupMessages(mediaGroupID)
+	if err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		err = c.DeleteMessage(&msgs[i])
+		if err != nil {
+			c.Log().WithError(err).WithField("mediagroup", mediaGroupID).Error("DeleteMediaGroup: can't delete the message")
+		}
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package integram
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_mediaGroupInput(t *testing.T) {
+	photo := mediaGroupItem{Attachment: Attachment{Kind: FileTypePhoto}, Caption: "Build #12"}
+	video := mediaGroupItem{Attachment: Attachment{Kind: FileTypeVideo}}
+
+	tests := []struct {
+		name   string
+		item   mediaGroupItem
+		fileID string
+		file   string
+		want   string
+	}{
+		{"file_id with caption", photo, "AgADxx", "", `{"type":"photo","media":"AgADxx","caption":"Build #12","parse_mode":"HTML"}`},
+		{"upload without caption", video, "", "file1", `{"type":"video","media":"attach://file1"}`},
+	}
+	for _, tt := range tests {
+		b, _ := json.Marshal(mediaGroupInput(tt.item, "HTML", tt.fileID, tt.file))
+		if string(b) != tt.want {
+			t.Errorf("%q. mediaGroupInput() = %s, want %s", tt.name, b, tt.want)
+		}
+	}
+}
+
+func TestMediaGroup_Send(t *testing.T) {
+	g := &MediaGroup{}
+	g.AddPhoto(AttachmentFromFileID(FileTypeDocument, "AgADxx", ""), "")
+
+	if _, err := g.Send(); err == nil {
+		t.Error("MediaGroup.Send() with the single item should return error")
+	}
+
+	if g.items[0].Kind != FileTypePhoto {
+		t.Errorf("MediaGroup.AddPhoto() kind = %s, want %s", g.items[0].Kind, FileTypePhoto)
+	}
+
+	for i := 0; i < MediaGroupMaxItems; i++ {
+		g.AddVideo(AttachmentFromFileID(FileTypeVideo, "BAADxx", ""), "")
+	}
+
+	if _, err := g.Send(); err == nil {
+		t.Error("MediaGroup.Send() with 11 items should return error")
+	}
+}
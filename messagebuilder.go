@@ -0,0 +1,219 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrMessageNotSentYet is returned when the queued message is edited before it was delivered to Telegram
+var ErrMessageNotSentYet = errors.New("Message is not sent yet")
+
+// MessageBuilder composes the OutgoingMessage with the fluent API, e.g. c.Reply().Text("Done").Silent().Send()
+type MessageBuilder struct {
+	ctx *Context
+	msg *OutgoingMessage
+}
+
+// SentMessage is the result of MessageBuilder.Send. It is used for the follow-up edits
+type SentMessage struct {
+	ID     bson.ObjectId // ID of the message stored in DB
+	ChatID int64
+	MsgID  int // Telegram message ID. 0 until the queued message is delivered
+
+	ctx *Context
+	msg *OutgoingMessage
+}
+
+// Compose starts the new message to the current chat
+func (c *Context) Compose() *MessageBuilder {
+	return &MessageBuilder{ctx: c, msg: c.NewMessage()}
+}
+
+// Reply starts the new message to the current chat as the reply to the incoming message if any
+func (c *Context) Reply() *MessageBuilder {
+	b := c.Compose()
+	if c.Message != nil && c.Message.MsgID != 0 {
+		b.msg.SetReplyToMsgID(c.Message.MsgID)
+	}
+	return b
+}
+
+// Text sets the message's text
+func (b *MessageBuilder) Text(text string) *MessageBuilder {
+	b.msg.SetText(text)
+	return b
+}
+
+// Textf sets the message's text formatted according to the format specifier
+func (b *MessageBuilder) Textf(format string, a ...interface{}) *MessageBuilder {
+	b.msg.SetText(fmt.Sprintf(format, a...))
+	return b
+}
+
+// HTML enables HTML parse mode
+func (b *MessageBuilder) HTML() *MessageBuilder {
+	b.msg.EnableHTML()
+	return b
+}
+
+// Markdown enables Markdown parse mode
+func (b *MessageBuilder) Markdown() *MessageBuilder {
+	b.msg.EnableMarkdown()
+	return b
+}
+
+// MarkdownV2 enables MarkdownV2 parse mode. Use EscapeMarkdownV2 for the values inserted into the text
+func (b *MessageBuilder) MarkdownV2() *MessageBuilder {
+	b.msg.EnableMarkdownV2()
+	return b
+}
+
+// PlainText disables the parse mode
+func (b *MessageBuilder) PlainText() *MessageBuilder {
+	b.msg.SetParseMode("")
+	return b
+}
+
+// Keyboard sets the inline keyboard
+func (b *MessageBuilder) Keyboard(kb InlineKeyboardMarkup) *MessageBuilder {
+	b.msg.SetInlineKeyboard(kb)
+	return b
+}
+
+// ReplyKeyboard sets the keyboard shown instead of the user's keyboard
+func (b *MessageBuilder) ReplyKeyboard(kb KeyboardMarkup, selective bool) *MessageBuilder {
+	b.msg.SetKeyboard(kb, selective)
+	return b
+}
+
+// Silent disables the notification sound
+func (b *MessageBuilder) Silent() *MessageBuilder {
+	b.msg.SetSilent(true)
+	return b
+}
+
+// DisablePreview disables the link preview
+func (b *MessageBuilder) DisablePreview() *MessageBuilder {
+	b.msg.DisableWebPreview()
+	return b
+}
+
+// Chat sets the target chat instead of the current one
+func (b *MessageBuilder) Chat(id int64) *MessageBuilder {
+	b.msg.SetChat(id)
+	return b
+}
+
+// ReplyTo sets the Telegram message ID to reply to
+func (b *MessageBuilder) ReplyTo(msgID int) *MessageBuilder {
+	b.msg.SetReplyToMsgID(msgID)
+	return b
+}
+
+// EventID attaches the event IDs to edit the message later with EditMessagesWithEventID
+func (b *MessageBuilder) EventID(id ...string) *MessageBuilder {
+	b.msg.AddEventID(id...)
+	return b
+}
+
+// Attachment sets the file or the remote URL to send with the message
+func (b *MessageBuilder) Attachment(a Attachment) *MessageBuilder {
+	b.msg.SetAttachment(a)
+	return b
+}
+
+// OnCallback sets the handler for the inline buttons presses
+func (b *MessageBuilder) OnCallback(handlerFunc interface{}, args ...interface{}) *MessageBuilder {
+	b.msg.SetCallbackAction(handlerFunc, args...)
+	return b
+}
+
+// OnReply sets the handler for the replies to the message
+func (b *MessageBuilder) OnReply(handlerFunc interface{}, args ...interface{}) *MessageBuilder {
+	b.msg.SetReplyAction(handlerFunc, args...)
+	return b
+}
+
+// After delays the message until the time
+func (b *MessageBuilder) After(t time.Time) *MessageBuilder {
+	b.msg.SetSendAfter(t)
+	return b
+}
+
+// Message returns the underlying OutgoingMessage to set the fields not covered by the builder
+func (b *MessageBuilder) Message() *OutgoingMessage {
+	return b.msg
+}
+
+// Send puts the message to the queue. SentMessage.MsgID is 0 until the message is delivered
+func (b *MessageBuilder) Send() (*SentMessage, error) {
+	err := b.msg.Send()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.sent(), nil
+}
+
+// SendNow sends the message to Telegram directly, bypassing the queue, and returns it with the Telegram message ID
+func (b *MessageBuilder) SendNow() (*SentMessage, error) {
+	err := b.msg.sendNow()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.sent(), nil
+}
+
+func (b *MessageBuilder) sent() *SentMessage {
+	return &SentMessage{ID: b.msg.ID, ChatID: b.msg.ChatID, MsgID: b.msg.MsgID, ctx: b.ctx, msg: b.msg}
+}
+
+// Stored returns the message as it is stored in DB after the delivery. Returns ErrMessageNotSentYet if it's still in the queue
+func (sm *SentMessage) Stored() (*OutgoingMessage, error) {
+	if sm.MsgID != 0 {
+		return sm.msg, nil
+	}
+
+	m, err := findMessageByBsonID(sm.ctx.db, sm.ID)
+	if err != nil || m.MsgID == 0 {
+		return nil, ErrMessageNotSentYet
+	}
+
+	sm.MsgID = m.MsgID
+	sm.msg = m.om
+	return sm.msg, nil
+}
+
+// EditText edits the message's text
+func (sm *SentMessage) EditText(text string) error {
+	om, err := sm.Stored()
+	if err != nil {
+		return err
+	}
+
+	return sm.ctx.EditMessageText(om, text)
+}
+
+// EditTextAndKeyboard edits the message's text and inline keyboard
+func (sm *SentMessage) EditTextAndKeyboard(text string, kb InlineKeyboard) error {
+	om, err := sm.Stored()
+	if err != nil {
+		return err
+	}
+
+	return sm.ctx.EditMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, text, kb)
+}
+
+// Delete deletes the message
+func (sm *SentMessage) Delete() error {
+	om, err := sm.Stored()
+	if err != nil {
+		return err
+	}
+
+	return sm.ctx.DeleteMessage(om)
+}
@@ -0,0 +1,44 @@
+package integram
+
+import (
+	"testing"
+)
+
+func TestContext_Reply(t *testing.T) {
+	var sent *OutgoingMessage
+	activeMessageSender = messageSender(fakeMessageSender{sendFunc: func(m *OutgoingMessage) error {
+		sent = m
+		return nil
+	}})
+	defer func() { activeMessageSender = scheduleMessageSender{} }()
+
+	ctx := &Context{ServiceName: "servicewithbottoken", db: db}
+	ctx.Chat = Chat{ID: -9999999999, ctx: ctx}
+	ctx.Message = &IncomingMessage{Message: Message{MsgID: 123, ChatID: -9999999999}}
+
+	kb := InlineButtons{}
+	kb.Append("ok", "OK")
+
+	sm, err := ctx.Reply().Textf("Done %d", 1).Keyboard(kb.Markup(1, "")).Silent().DisablePreview().HTML().Send()
+	if err != nil {
+		t.Fatalf("MessageBuilder.Send() error = %v", err)
+	}
+
+	if sent == nil || sent.Text != "Done 1" || sent.ReplyToMsgID != 123 || !sent.Silent || sent.WebPreview || sent.ParseMode != "HTML" || len(sent.InlineKeyboardMarkup.Buttons) != 1 {
+		t.Errorf("MessageBuilder.Send() sent %+v", sent)
+	}
+
+	if sm.ChatID != -9999999999 || sm.MsgID != 0 {
+		t.Errorf("MessageBuilder.Send() returned %+v", sm)
+	}
+
+	if err := sm.EditText("Edited"); err != ErrMessageNotSentYet {
+		t.Errorf("SentMessage.EditText() for the queued message error = %v, want %v", err, ErrMessageNotSentYet)
+	}
+
+	sent = nil
+	_, err = ctx.Compose().Chat(9999999999).Text("Hi").Send()
+	if err != nil || sent == nil || sent.ChatID != 9999999999 || sent.ReplyToMsgID != 0 {
+		t.Errorf("Context.Compose().Send() error = %v, sent %+v", err, sent)
+	}
+}
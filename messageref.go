@@ -0,0 +1,85 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// prefix of the event ID generated for the messages sent with the HTTP API without one
+const apiMessageEventIDPrefix = "api_"
+
+// MessageRef is the public reference of the stored message. Unlike Message.ID it doesn't depend on the storage,
+// so it can be kept by the services or returned by the HTTP API and resolved later with Context.FindMessageByRef
+type MessageRef struct {
+	Service string `json:"service"`
+	ChatID  int64  `json:"chat_id"`
+	MsgID   int    `json:"msg_id,omitempty"`   // Telegram's message ID. Not set until the message is sent
+	EventID string `json:"event_id,omitempty"` // message's first event ID
+}
+
+// String returns the ref as 'service:chat:msgid:eventid'. Event ID is the last one so it may contain ':'
+func (ref MessageRef) String() string {
+	return fmt.Sprintf("%s:%d:%d:%s", ref.Service, ref.ChatID, ref.MsgID, ref.EventID)
+}
+
+// ParseMessageRef parses the ref returned by MessageRef.String
+func ParseMessageRef(s string) (MessageRef, error) {
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) != 4 || parts[0] == "" {
+		return MessageRef{}, fmt.Errorf("'%s' is not a message ref", s)
+	}
+
+	chatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || chatID == 0 {
+		return MessageRef{}, fmt.Errorf("'%s' is not a message ref: wrong chat", s)
+	}
+
+	msgID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return MessageRef{}, fmt.Errorf("'%s' is not a message ref: wrong message ID", s)
+	}
+
+	ref := MessageRef{Service: parts[0], ChatID: chatID, MsgID: msgID, EventID: parts[3]}
+	if ref.MsgID == 0 && ref.EventID == "" {
+		return MessageRef{}, fmt.Errorf("'%s' is not a message ref: message ID or event ID required", s)
+	}
+	return ref, nil
+}
+
+// messageRef returns the ref of the service's message
+func messageRef(serviceName string, m *Message) MessageRef {
+	ref := MessageRef{Service: serviceName, ChatID: m.ChatID, MsgID: m.MsgID}
+	if len(m.EventID) > 0 {
+		ref.EventID = m.EventID[0]
+	}
+	return ref
+}
+
+// MessageRef returns the public reference of the current service's message. Message must have the event ID to be found before it is sent
+func (c *Context) MessageRef(m *Message) MessageRef {
+	return messageRef(c.ServiceName, m)
+}
+
+// FindMessageByRef returns the current service's message referenced by ref. Telegram's message ID is used if set, otherwise the event ID
+func (c *Context) FindMessageByRef(ref MessageRef) (*Message, error) {
+	if ref.Service != c.ServiceName {
+		return nil, fmt.Errorf("Message ref belongs to the service '%s'", ref.Service)
+	}
+
+	bot := c.Bot()
+	if bot == nil {
+		return nil, errors.New("Bot not set for the service")
+	}
+
+	if ref.MsgID != 0 {
+		return findMessage(c.db, ref.ChatID, bot.ID, ref.MsgID)
+	}
+
+	if ref.EventID != "" {
+		return findMessageByEventID(c.db, ref.ChatID, bot.ID, ref.EventID)
+	}
+
+	return nil, errors.New("Message ref has neither message ID nor event ID")
+}
@@ -0,0 +1,54 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessageRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    MessageRef
+		wantErr bool
+	}{
+		{"message ID", "github:-100123:42:", MessageRef{Service: "github", ChatID: -100123, MsgID: 42}, false},
+		{"event ID with colons", "gitlab:7:0:mr:15:note", MessageRef{Service: "gitlab", ChatID: 7, EventID: "mr:15:note"}, false},
+		{"both", "trello:7:5:card_1", MessageRef{Service: "trello", ChatID: 7, MsgID: 5, EventID: "card_1"}, false},
+		{"neither", "trello:7:0:", MessageRef{}, true},
+		{"no chat", "trello:0:5:", MessageRef{}, true},
+		{"bson id", "5c8b0f1e2d3a4b5c6d7e8f90", MessageRef{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessageRef(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMessageRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMessageRef() = %+v, want %+v", got, tt.want)
+			}
+
+			if !tt.wantErr && got.String() != tt.s {
+				t.Errorf("MessageRef.String() = %v, want %v", got.String(), tt.s)
+			}
+		})
+	}
+}
+
+func Test_messageRef(t *testing.T) {
+	m := &Message{ChatID: -100123, MsgID: 42, EventID: []string{"push_1", "push_2"}}
+
+	want := MessageRef{Service: "github", ChatID: -100123, MsgID: 42, EventID: "push_1"}
+	if got := messageRef("github", m); !reflect.DeepEqual(got, want) {
+		t.Errorf("messageRef() = %+v, want %+v", got, want)
+	}
+}
+
+func TestContext_FindMessageByRef(t *testing.T) {
+	c := &Context{ServiceName: "github"}
+	if _, err := c.FindMessageByRef(MessageRef{Service: "gitlab", ChatID: 1, MsgID: 1}); err == nil {
+		t.Errorf("FindMessageByRef() returned the message of the other service")
+	}
+}
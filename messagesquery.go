@@ -0,0 +1,198 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// default number of the messages returned by MessagesQuery's List and Page
+const messagesQueryDefaultLimit = 50
+
+// MessagesQuery selects the bot's outgoing messages stored in the DB, newest first. Create it with Context.Messages()
+type MessagesQuery struct {
+	ctx    *Context
+	filter bson.M
+	from   time.Time
+	to     time.Time
+	limit  int
+	err    error
+}
+
+// Messages returns the query of the bot's messages in the current chat. Use AllChats() to query the messages in all chats, e.g. in the webhook handler
+func (c *Context) Messages() *MessagesQuery {
+	q := &MessagesQuery{ctx: c, filter: bson.M{}, limit: messagesQueryDefaultLimit}
+
+	bot := c.Bot()
+	if bot == nil {
+		q.err = errors.New("Messages: bot not found")
+		return q
+	}
+
+	q.filter["botid"] = bot.ID
+	if c.Chat.ID != 0 {
+		q.filter["chatid"] = c.Chat.ID
+	}
+	return q
+}
+
+// AllChats removes the filter by the current chat
+func (q *MessagesQuery) AllChats() *MessagesQuery {
+	delete(q.filter, "chatid")
+	return q
+}
+
+// EventID selects the messages with any of the event IDs
+func (q *MessagesQuery) EventID(eventIDs ...string) *MessagesQuery {
+	if len(eventIDs) == 1 {
+		q.filter["eventid"] = eventIDs[0]
+	} else if len(eventIDs) > 1 {
+		q.filter["eventid"] = bson.M{"$in": eventIDs}
+	}
+	return q
+}
+
+// Between selects the messages sent in [from, to). Zero time leaves the side of the range open
+func (q *MessagesQuery) Between(from time.Time, to time.Time) *MessagesQuery {
+	q.from = from
+	q.to = to
+	return q
+}
+
+// State selects the messages with the inline keyboard's state, see InlineKeyboard.State. Empty state removes the filter
+func (q *MessagesQuery) State(state string) *MessagesQuery {
+	if state == "" {
+		delete(q.filter, "inlinekeyboardmarkup.state")
+	} else {
+		q.filter["inlinekeyboardmarkup.state"] = state
+	}
+	return q
+}
+
+// Limit sets the max number of the messages returned by List and Page. Default is 50
+func (q *MessagesQuery) Limit(n int) *MessagesQuery {
+	if n > 0 {
+		q.limit = n
+	}
+	return q
+}
+
+// selector returns the DB query of the messages older than the cursor's one
+func (q *MessagesQuery) selector(cursor string) (bson.M, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	sel := bson.M{}
+	for k, v := range q.filter {
+		sel[k] = v
+	}
+
+	// _id is used instead of the date to use the index and the sort by _id
+	id := bson.M{}
+	if !q.from.IsZero() {
+		id["$gte"] = bson.NewObjectIdWithTime(q.from)
+	}
+	if !q.to.IsZero() {
+		id["$lt"] = bson.NewObjectIdWithTime(q.to)
+	}
+
+	if cursor != "" {
+		if !bson.IsObjectIdHex(cursor) {
+			return nil, fmt.Errorf("Messages: wrong cursor %q", cursor)
+		}
+
+		lt := bson.ObjectIdHex(cursor)
+		if prev, exists := id["$lt"]; !exists || lt < prev.(bson.ObjectId) {
+			id["$lt"] = lt
+		}
+	}
+
+	if len(id) > 0 {
+		sel["_id"] = id
+	}
+	return sel, nil
+}
+
+// Count returns the number of the matching messages
+func (q *MessagesQuery) Count() (int, error) {
+	sel, err := q.selector("")
+	if err != nil {
+		return 0, err
+	}
+	return q.ctx.db.C("messages").Find(sel).Count()
+}
+
+// List returns the latest matching messages up to the limit
+func (q *MessagesQuery) List() ([]*OutgoingMessage, error) {
+	messages, _, err := q.Page("")
+	return messages, err
+}
+
+// Page returns the page of the matching messages after the cursor and the cursor of the next page. Pass the empty cursor to get the first page.
+// Next cursor is empty on the last page
+func (q *MessagesQuery) Page(cursor string) (messages []*OutgoingMessage, next string, err error) {
+	sel, err := q.selector(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one more to know if there is the next page
+	err = q.ctx.db.C("messages").Find(sel).Sort("-_id").Limit(q.limit + 1).All(&messages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(messages) > q.limit {
+		messages = messages[:q.limit]
+		next = messages[q.limit-1].ID.Hex()
+	}
+	return messages, next, nil
+}
+
+// Each calls fn for every matching message page by page. Stops on the first error returned by fn
+func (q *MessagesQuery) Each(fn func(om *OutgoingMessage) error) error {
+	cursor := ""
+	for {
+		messages, next, err := q.Page(cursor)
+		if err != nil {
+			return err
+		}
+
+		for _, om := range messages {
+			err = fn(om)
+			if err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Delete deletes all matching messages. Messages which Telegram doesn't allow to delete anymore are skipped, see Context.DeleteMessage
+func (q *MessagesQuery) Delete() (deleted int, err error) {
+	err = q.Each(func(om *OutgoingMessage) error {
+		bot := botByID(om.BotID)
+		if bot == nil || om.MsgID == 0 {
+			return nil
+		}
+
+		err := q.ctx.deleteMessage(bot, om)
+		if err == ErrMessageCantBeDeleted {
+			q.ctx.Log().WithField("msgid", om.MsgID).Warn("MessagesQuery.Delete: message can't be deleted")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
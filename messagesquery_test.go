@@ -0,0 +1,54 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMessagesQuery_selector(t *testing.T) {
+	from := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	cursor := bson.NewObjectIdWithTime(from.Add(time.Hour))
+	newQuery := func() *MessagesQuery {
+		return &MessagesQuery{filter: bson.M{"botid": int64(1111), "chatid": int64(-100)}, limit: messagesQueryDefaultLimit}
+	}
+
+	tests := []struct {
+		name   string
+		q      *MessagesQuery
+		cursor string
+		want   bson.M
+	}{
+		{"chat", newQuery(), "", bson.M{"botid": int64(1111), "chatid": int64(-100)}},
+		{"all chats", newQuery().AllChats(), "", bson.M{"botid": int64(1111)}},
+		{"event", newQuery().EventID("e1"), "", bson.M{"botid": int64(1111), "chatid": int64(-100), "eventid": "e1"}},
+		{"events", newQuery().EventID("e1", "e2"), "", bson.M{"botid": int64(1111), "chatid": int64(-100), "eventid": bson.M{"$in": []string{"e1", "e2"}}}},
+		{"state", newQuery().State("open"), "", bson.M{"botid": int64(1111), "chatid": int64(-100), "inlinekeyboardmarkup.state": "open"}},
+		{"state removed", newQuery().State("open").State(""), "", bson.M{"botid": int64(1111), "chatid": int64(-100)}},
+		{"range", newQuery().Between(from, to), "", bson.M{"botid": int64(1111), "chatid": int64(-100),
+			"_id": bson.M{"$gte": bson.NewObjectIdWithTime(from), "$lt": bson.NewObjectIdWithTime(to)}}},
+		{"open range", newQuery().Between(from, time.Time{}), "", bson.M{"botid": int64(1111), "chatid": int64(-100),
+			"_id": bson.M{"$gte": bson.NewObjectIdWithTime(from)}}},
+		{"cursor", newQuery(), cursor.Hex(), bson.M{"botid": int64(1111), "chatid": int64(-100), "_id": bson.M{"$lt": cursor}}},
+		{"cursor inside range", newQuery().Between(from, to), cursor.Hex(), bson.M{"botid": int64(1111), "chatid": int64(-100),
+			"_id": bson.M{"$gte": bson.NewObjectIdWithTime(from), "$lt": cursor}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.q.selector(tt.cursor)
+			if err != nil {
+				t.Fatalf("MessagesQuery.selector() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MessagesQuery.selector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newQuery().selector("bad"); err == nil {
+		t.Errorf("MessagesQuery.selector() with the wrong cursor error = nil, want error")
+	}
+}
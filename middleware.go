@@ -0,0 +1,27 @@
+package integram
+
+// Middleware is called before the service's message, reply and callback handlers, e.g. to check the auth, limit the rate or record the metrics.
+// Call next to continue the chain, return without calling it to stop the update. Returned error is handled the same way as the handler's one
+type Middleware func(c *Context, next func(c *Context) error) error
+
+// Use appends the middlewares to the service's chain. Middlewares are called in the order they were added, the first one is the outermost.
+// Call it in the Servicer's Service() before the service is registered
+func (s *Service) Use(mw ...Middleware) {
+	s.middlewares = append(s.middlewares, mw...)
+}
+
+// withMiddlewares wraps the handler with the service's middlewares chain
+func (s *Service) withMiddlewares(handler func(c *Context) error) func(c *Context) error {
+	if s == nil {
+		return handler
+	}
+
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		mw, next := s.middlewares[i], handler
+		handler = func(c *Context) error {
+			return mw(c, next)
+		}
+	}
+
+	return handler
+}
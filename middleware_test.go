@@ -0,0 +1,50 @@
+package integram
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestService_withMiddlewares(t *testing.T) {
+	var calls []string
+	record := func(name string, stop bool) Middleware {
+		return func(c *Context, next func(c *Context) error) error {
+			calls = append(calls, name)
+			if stop {
+				return errors.New(name + " stopped")
+			}
+			return next(c)
+		}
+	}
+	handler := func(c *Context) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		middlewares []Middleware
+		wantCalls   []string
+		wantErr     bool
+	}{
+		{"no middlewares", nil, []string{"handler"}, false},
+		{"in order", []Middleware{record("a", false), record("b", false)}, []string{"a", "b", "handler"}, false},
+		{"stopped", []Middleware{record("a", false), record("b", true), record("c", false)}, []string{"a", "b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			s := &Service{}
+			s.Use(tt.middlewares...)
+
+			err := s.withMiddlewares(handler)(&Context{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withMiddlewares() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("withMiddlewares() calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
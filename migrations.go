@@ -1,6 +1,7 @@
 package integram
 
 import (
+	"strings"
 	"time"
 
 	"gopkg.in/mgo.v2"
@@ -13,6 +14,11 @@ func migrations(db *mgo.Database, serviceName string) error {
 		return err
 	}
 
+	err = migrateUsernameLower(db)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -42,3 +48,33 @@ func migrateMissingOAuthStores(db *mgo.Database, serviceName string) error {
 
 	return db.C("migrations").Insert(bson.M{"_id": serviceName + "_" + name, "date": time.Now(), "migrated": info.Updated})
 }
+
+// migrateUsernameLower stores the lowercased usernames of the users saved before resolveStoredUsername matched them
+func migrateUsernameLower(db *mgo.Database) error {
+	name := "UsernameLower"
+	n, _ := db.C("migrations").FindId(name).Count()
+	if n > 0 {
+		return nil
+	}
+
+	var user struct {
+		ID       int64 `bson:"_id"`
+		UserName string
+	}
+	migrated := 0
+	iter := db.C("users").Find(bson.M{"username": bson.M{"$exists": true, "$ne": ""}, "usernamelower": bson.M{"$exists": false}}).Select(bson.M{"username": 1}).Iter()
+	for iter.Next(&user) {
+		err := db.C("users").UpdateId(user.ID, bson.M{"$set": bson.M{"usernamelower": strings.ToLower(user.UserName)}})
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		migrated++
+	}
+	err := iter.Close()
+	if err != nil {
+		return err
+	}
+
+	return db.C("migrations").Insert(bson.M{"_id": name, "date": time.Now(), "migrated": migrated})
+}
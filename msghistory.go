@@ -0,0 +1,223 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the messages edit history is stored
+const messageHistoryTTL = time.Hour * 24 * 30
+
+// max number of entries shown by /integram timeline
+const messageHistoryTimelineLimit = 50
+
+const (
+	messageHistoryOpEditText      = "edit_text"
+	messageHistoryOpEditTextAndKb = "edit_text_kb"
+	messageHistoryOpEditKb        = "edit_kb"
+	messageHistoryOpEditButton    = "edit_button"
+	messageHistoryOpEditCaption   = "edit_caption"
+	messageHistoryOpEditMedia     = "edit_media"
+	messageHistoryOpDelete        = "delete"
+	messageHistoryOpRevert        = "revert" // edit failed in TG and the stored message was reverted
+)
+
+const (
+	messageHistoryTriggerCallback   = "callback"
+	messageHistoryTriggerMessage    = "message"
+	messageHistoryTriggerInline     = "inline"
+	messageHistoryTriggerWebhook    = "webhook"
+	messageHistoryTriggerBackground = "background" // job, worker or event handler
+)
+
+// messageHistoryEntry is stored in the append-only "messages_history" collection for each edit applied to the OutgoingMessage
+type messageHistoryEntry struct {
+	ID       bson.ObjectId   `bson:"_id"`
+	MsgID    bson.ObjectId   `bson:"m"` // OutgoingMessage's _id
+	Date     time.Time       `bson:"d"`
+	Op       string          `bson:"op"`
+	Text     string          `bson:"t,omitempty"`
+	Keyboard *InlineKeyboard `bson:"kb,omitempty"`
+	Trigger  string          `bson:"tr"`
+	Data     string          `bson:"cb,omitempty"` // pressed button's data
+	UserID   int64           `bson:"u,omitempty"`
+	Caller   string          `bson:"f,omitempty"` // func outside the Context that requested the edit
+	Error    string          `bson:"e,omitempty"`
+}
+
+func init() {
+	registerAdminCommand("timeline", adminMessageTimeline)
+}
+
+// recordMessageHistory appends the entry to the message's history. kb can be nil if keyboard wasn't changed
+func (c *Context) recordMessageHistory(om *OutgoingMessage, op string, text string, kb *InlineKeyboard, editErr error) {
+	if !Config.MessageHistory || om == nil || om.ID == "" {
+		return
+	}
+
+	e := messageHistoryEntry{ID: bson.NewObjectId(), MsgID: om.ID, Date: time.Now(), Op: op, Text: text, Keyboard: kb, UserID: c.User.ID, Caller: messageEditCaller()}
+
+	switch {
+	case c.Callback != nil:
+		e.Trigger = messageHistoryTriggerCallback
+		e.Data = c.Callback.Data
+	case c.Message != nil:
+		e.Trigger = messageHistoryTriggerMessage
+	case c.InlineQuery != nil || c.ChosenInlineResult != nil:
+		e.Trigger = messageHistoryTriggerInline
+	case c.gin != nil:
+		e.Trigger = messageHistoryTriggerWebhook
+	default:
+		e.Trigger = messageHistoryTriggerBackground
+	}
+
+	if editErr != nil {
+		e.Error = editErr.Error()
+	}
+
+	err := c.db.C("messages_history").Insert(e)
+	if err != nil {
+		c.Log().WithError(err).WithField("msgid", om.ID.Hex()).Error("Can't save the message history")
+	}
+}
+
+// messageEditCaller returns the first func in the stack outside the Context's methods
+func messageEditCaller() string {
+	pc := make([]uintptr, 16)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, "integram.(*Context).") {
+			return frame.Function
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+// inlineKeyboardSummary returns the compact keyboard representation, e.g. "[Done ✅] [Open] / [Assign]"
+func inlineKeyboardSummary(kb *InlineKeyboard) string {
+	if kb == nil || len(kb.Buttons) == 0 {
+		return "no keyboard"
+	}
+
+	var rows []string
+	for _, row := range kb.Buttons {
+		var buttons []string
+		for _, b := range row {
+			buttons = append(buttons, "["+b.Text+"]")
+		}
+		rows = append(rows, strings.Join(buttons, " "))
+	}
+
+	s := strings.Join(rows, " / ")
+	if kb.State != "" {
+		s += " (state " + kb.State + ")"
+	}
+	return s
+}
+
+func (e messageHistoryEntry) String() string {
+	s := fmt.Sprintf("%s %s by %s", e.Date.UTC().Format("2006-01-02 15:04:05"), e.Op, e.Trigger)
+
+	if e.Data != "" {
+		s += fmt.Sprintf(" '%s'", e.Data)
+	}
+	if e.UserID != 0 {
+		s += fmt.Sprintf(" (user %d)", e.UserID)
+	}
+	if e.Caller != "" {
+		s += " from " + e.Caller
+	}
+	if e.Text != "" {
+		s += "\n  text: " + truncateRunes(e.Text, 100)
+	}
+	if e.Keyboard != nil {
+		s += "\n  kb: " + inlineKeyboardSummary(e.Keyboard)
+	}
+	if e.Error != "" {
+		s += "\n  error: " + e.Error
+	}
+
+	return s
+}
+
+// messageHistory returns the edits applied to the message, sorted from the oldest
+func (c *Context) messageHistory(msgID bson.ObjectId) ([]messageHistoryEntry, error) {
+	var entries []messageHistoryEntry
+	err := c.db.C("messages_history").Find(bson.M{"m": msgID}).Sort("-_id").Limit(messageHistoryTimelineLimit).All(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// adminMessageTimeline: /integram timeline message_bson_id | chat_id msg_id
+func adminMessageTimeline(c *Context, args []string) (string, error) {
+	var om *OutgoingMessage
+
+	switch {
+	case len(args) == 1 && bson.IsObjectIdHex(args[0]):
+		msg, err := findMessageByBsonID(c.db, bson.ObjectIdHex(args[0]))
+		if err != nil {
+			return "", err
+		}
+		om = msg.om
+	case len(args) == 2:
+		if c.ServiceName == "" {
+			return "", errors.New("Service must be specified")
+		}
+
+		chatID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("Wrong chat ID: %s", args[0])
+		}
+
+		msgID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", fmt.Errorf("Wrong message ID: %s", args[1])
+		}
+
+		msg, err := findMessage(c.db, chatID, c.Bot().ID, msgID)
+		if err != nil {
+			return "", err
+		}
+		om = msg.om
+	default:
+		return "", errors.New("Usage: timeline message_bson_id | chat_id msg_id")
+	}
+
+	if om == nil {
+		return "", errors.New("Outgoing message not found")
+	}
+
+	entries, err := c.messageHistory(om.ID)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("Message %s (chat %d, msg %d)", om.ID.Hex(), om.ChatID, om.MsgID)}
+	if len(entries) == 0 {
+		lines = append(lines, "No edits recorded")
+	}
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+
+	lines = append(lines, "Current kb: "+inlineKeyboardSummary(&om.InlineKeyboardMarkup))
+	return strings.Join(lines, "\n"), nil
+}
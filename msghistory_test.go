@@ -0,0 +1,42 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_inlineKeyboardSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		kb   *InlineKeyboard
+		want string
+	}{
+		{"nil", nil, "no keyboard"},
+		{"rows", &InlineKeyboard{Buttons: []InlineButtons{{{Text: "Done ✅"}, {Text: "Open"}}, {{Text: "Assign"}}}, State: "card"}, "[Done ✅] [Open] / [Assign] (state card)"},
+	}
+	for _, tt := range tests {
+		if got := inlineKeyboardSummary(tt.kb); got != tt.want {
+			t.Errorf("%q. inlineKeyboardSummary() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_messageHistoryEntry_String(t *testing.T) {
+	date := time.Date(2018, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		e    messageHistoryEntry
+		want string
+	}{
+		{"edit", messageHistoryEntry{Date: date, Op: messageHistoryOpEditKb, Trigger: messageHistoryTriggerCallback, Data: "done", UserID: 1, Caller: "trello.cardDone", Keyboard: &InlineKeyboard{Buttons: []InlineButtons{{{Text: "Undo"}}}}},
+			"2018-05-01 12:30:00 edit_kb by callback 'done' (user 1) from trello.cardDone\n  kb: [Undo]"},
+		{"revert", messageHistoryEntry{Date: date, Op: messageHistoryOpRevert, Trigger: messageHistoryTriggerBackground, Keyboard: &InlineKeyboard{Buttons: []InlineButtons{{{Text: "Done"}}}}, Error: "Too Many Requests"},
+			"2018-05-01 12:30:00 revert by background\n  kb: [Done]\n  error: Too Many Requests"},
+	}
+	for _, tt := range tests {
+		if got := tt.e.String(); got != tt.want {
+			t.Errorf("%q. messageHistoryEntry.String() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package integram
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// private chat command to set up the notification channels: '/notify email me@example.com', '/notify verify 123456', '/notify off email'
+const notifyChannelCommand = "notify"
+
+// verification code must be entered within this period
+const notifyChannelCodeTTL = time.Hour
+
+// max verification codes sent by the user within notifyChannelCodeTTL, so /notify can't be used to spam the addresses
+const notifyChannelCodesLimit = 5
+
+const notifyChannelRequestTimeout = time.Second * 10
+
+var notifyChannelClient = publicHTTPClient(notifyChannelRequestTimeout)
+
+// Notification is the critical notification delivered outside of Telegram
+type Notification struct {
+	Service string `json:"service"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"` // plain text
+}
+
+// NotificationChannel delivers the critical notifications to the user's address when Telegram delivery fails repeatedly, e.g. the user blocked the bot
+type NotificationChannel interface {
+	ValidateAddress(address string) error
+	Send(address string, n Notification) error
+}
+
+// userNotificationChannel is the user's address stored in the users "notifychannels"
+type userNotificationChannel struct {
+	Kind       string    `bson:"k"`
+	Address    string    `bson:"a"`
+	Verified   bool      `bson:"v"`
+	Code       string    `bson:"c,omitempty"` // verification code sent to the address
+	CodeSentAt time.Time `bson:"at"`
+}
+
+var notificationChannelsMutex = sync.RWMutex{}
+var notificationChannels = map[string]NotificationChannel{
+	"email":   emailNotificationChannel{},
+	"webhook": webhookNotificationChannel{},
+}
+
+// RegisterNotificationChannel adds the channel users can set up with /notify. Built-in channels are "email" (requires INTEGRAM_SMTP_ADDR) and "webhook"
+func RegisterNotificationChannel(kind string, ch NotificationChannel) {
+	notificationChannelsMutex.Lock()
+	defer notificationChannelsMutex.Unlock()
+
+	notificationChannels[kind] = ch
+}
+
+func notificationChannelByKind(kind string) NotificationChannel {
+	notificationChannelsMutex.RLock()
+	defer notificationChannelsMutex.RUnlock()
+
+	return notificationChannels[kind]
+}
+
+// emailNotificationChannel sends the email with the SMTP server configured with INTEGRAM_SMTP_ADDR
+type emailNotificationChannel struct{}
+
+func (emailNotificationChannel) ValidateAddress(address string) error {
+	if Config.SMTPAddr == "" {
+		return errors.New("Email notifications are not enabled on this instance")
+	}
+
+	a, err := mail.ParseAddress(address)
+	if err != nil || a.Address != address {
+		return fmt.Errorf("'%s' is not an email address", address)
+	}
+	return nil
+}
+
+func (emailNotificationChannel) Send(address string, n Notification) error {
+	if Config.SMTPAddr == "" {
+		return errors.New("SMTP is not configured")
+	}
+
+	return sendMail(Config.SMTPAddr, Config.SMTPFrom, address, notificationEmail(Config.SMTPFrom, address, n))
+}
+
+// sendMail sends the message like smtp.SendMail, but the whole SMTP session is limited with notifyChannelRequestTimeout
+func sendMail(addr string, from string, to string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, notifyChannelRequestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(notifyChannelRequestTimeout))
+	if err != nil {
+		return err
+	}
+
+	host := strings.Split(addr, ":")[0]
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if Config.SMTPUser != "" {
+		err = client.Auth(smtp.PlainAuth("", Config.SMTPUser, Config.SMTPPassword, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// notificationEmail returns the RFC 822 message
+func notificationEmail(from string, to string, n Notification) []byte {
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Subject)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, to, subject)
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buf.WriteString(strings.Replace(n.Text, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
+
+// webhookNotificationChannel posts the notification as JSON to the user's URL
+type webhookNotificationChannel struct{}
+
+func (webhookNotificationChannel) ValidateAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("'%s' is not an https:// URL", address)
+	}
+	return nil
+}
+
+func (webhookNotificationChannel) Send(address string, n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyChannelClient.Post(address, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyChannelCode returns the random 6-digit verification code
+func notifyChannelCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// verifyNotificationChannel returns the index of the channel waiting for the code or -1
+func verifyNotificationChannel(channels []userNotificationChannel, code string, now time.Time) int {
+	for i, ch := range channels {
+		if !ch.Verified && ch.Code != "" && ch.Code == code && now.Sub(ch.CodeSentAt) < notifyChannelCodeTTL {
+			return i
+		}
+	}
+	return -1
+}
+
+// userNotificationChannels returns the user's notification channels
+func userNotificationChannels(db *mgo.Database, userID int64) ([]userNotificationChannel, error) {
+	var data struct {
+		NotifyChannels []userNotificationChannel
+	}
+
+	err := db.C("users").FindId(userID).Select(bson.M{"notifychannels": 1}).One(&data)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	return data.NotifyChannels, err
+}
+
+// allowNotifyChannelCode counts the verification code about to be sent by the user. Returns false if notifyChannelCodesLimit is exceeded
+func allowNotifyChannelCode(db *mgo.Database, userID int64, now time.Time) (bool, error) {
+	// start the new period if the previous one is over
+	err := db.C("users").Update(
+		bson.M{"_id": userID, "$or": []bson.M{{"notifycodesfrom": bson.M{"$exists": false}}, {"notifycodesfrom": bson.M{"$lte": now.Add(-notifyChannelCodeTTL)}}}},
+		bson.M{"$set": bson.M{"notifycodesfrom": now, "notifycodes": 1}},
+	)
+	if err == nil {
+		return true, nil
+	} else if err != mgo.ErrNotFound {
+		return false, err
+	}
+
+	err = db.C("users").Update(bson.M{"_id": userID, "notifycodes": bson.M{"$lt": notifyChannelCodesLimit}}, bson.M{"$inc": bson.M{"notifycodes": 1}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// resetTGFailures resets the failed deliveries counter once the message was delivered to the private chat
+func (m *OutgoingMessage) resetTGFailures(db *mgo.Database) {
+	if m.ChatID <= 0 || Config.NotifyFallbackAfterFailures <= 0 {
+		return
+	}
+
+	db.C("users").Update(bson.M{"_id": m.ChatID, "tgfailures": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"tgfailures": ""}})
+}
+
+// SetCritical marks the message as the critical alert. If Telegram delivery to the private chat fails repeatedly it is sent to the user's verified notification channels
+func (m *OutgoingMessage) SetCritical() *OutgoingMessage {
+	m.Critical = true
+	return m
+}
+
+// deliverToNotificationChannels counts the failed delivery of the critical message to the private chat and sends it to the user's verified channels once the failures exceeded
+func (m *OutgoingMessage) deliverToNotificationChannels(db *mgo.Database, bot *Bot, reason string) {
+	if !m.Critical || m.ChatID <= 0 || Config.NotifyFallbackAfterFailures <= 0 {
+		return
+	}
+
+	var user struct {
+		TGFailures     int
+		NotifyChannels []userNotificationChannel
+	}
+
+	_, err := db.C("users").FindId(m.ChatID).Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"tgfailures": 1}}, ReturnNew: true}, &user)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			log.WithError(err).WithField("user", m.ChatID).Error("Can't count the failed Telegram delivery")
+		}
+		return
+	}
+
+	if user.TGFailures < Config.NotifyFallbackAfterFailures {
+		return
+	}
+
+	text, _, err := parseMessageEntities(m.Text, m.ParseMode)
+	if err != nil {
+		text = m.Text
+	}
+
+	n := Notification{Text: text}
+	if len(bot.services) > 0 {
+		n.Service = bot.services[0].Name
+		n.Subject = fmt.Sprintf("%s alert: Telegram delivery failed (%s)", bot.services[0].NameToPrint, reason)
+	}
+
+	for _, ch := range user.NotifyChannels {
+		if !ch.Verified {
+			continue
+		}
+
+		channel := notificationChannelByKind(ch.Kind)
+		if channel == nil {
+			continue
+		}
+
+		err := channel.Send(ch.Address, n)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{"user": m.ChatID, "channel": ch.Kind}).Error("Can't deliver the critical notification")
+			continue
+		}
+
+		ctx := &Context{ServiceName: n.Service, db: db}
+		ctx.StatIncBy(StatNotificationChannelDelivered, m.ChatID, 1)
+	}
+}
+
+// handleNotifyChannelCommand process '/notify [kind address|verify code|off kind]' in the private chat. Returns true if message was handled
+func (c *Context) handleNotifyChannelCommand() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand(notifyChannelCommand) {
+		return false
+	}
+
+	reply := func(text string) {
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).SetParseMode("").applyBrandingFooter().Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't send the reply")
+		}
+	}
+
+	channels, err := userNotificationChannels(c.db, c.User.ID)
+	if err != nil {
+		c.Log().WithError(err).Error("handleNotifyChannelCommand: can't get the channels")
+		reply(c.Branding().ErrorText("Can't get your notification channels. Please try again later"))
+		return true
+	}
+
+	args := strings.Fields(param)
+	usage := "Critical alerts are also sent to your verified channels if the bot can't reach you in Telegram.\n" +
+		"Usage: /" + coreCommand(notifyChannelCommand) + " email me@example.com, /" + coreCommand(notifyChannelCommand) + " webhook https://example.com/hook, /" +
+		coreCommand(notifyChannelCommand) + " verify code, /" + coreCommand(notifyChannelCommand) + " off email"
+
+	switch {
+	case len(args) == 0:
+		lines := []string{}
+		for _, ch := range channels {
+			status := "verified"
+			if !ch.Verified {
+				status = "waiting for the verification code"
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s (%s)", ch.Kind, ch.Address, status))
+		}
+
+		if len(lines) == 0 {
+			lines = append(lines, "No notification channels set up")
+		}
+		reply(strings.Join(lines, "\n") + "\n\n" + usage)
+	case len(args) == 2 && args[0] == "verify":
+		i := verifyNotificationChannel(channels, args[1], time.Now())
+		if i < 0 {
+			reply("Wrong or expired verification code")
+			return true
+		}
+
+		err := c.db.C("users").Update(bson.M{"_id": c.User.ID, "notifychannels.k": channels[i].Kind}, bson.M{
+			"$set":   bson.M{"notifychannels.$.v": true},
+			"$unset": bson.M{"notifychannels.$.c": ""},
+		})
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't verify the channel")
+			reply(c.Branding().ErrorText("Can't verify the channel. Please try again later"))
+			return true
+		}
+		reply(fmt.Sprintf("%s %s is verified", channels[i].Kind, channels[i].Address))
+	case len(args) == 2 && args[0] == "off":
+		err := c.db.C("users").UpdateId(c.User.ID, bson.M{"$pull": bson.M{"notifychannels": bson.M{"k": args[1]}}})
+		if err != nil && err != mgo.ErrNotFound {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't remove the channel")
+			reply(c.Branding().ErrorText("Can't remove the channel. Please try again later"))
+			return true
+		}
+		reply(fmt.Sprintf("%s notifications are turned off", args[1]))
+	case len(args) == 2:
+		channel := notificationChannelByKind(args[0])
+		if channel == nil {
+			reply(fmt.Sprintf("Unknown channel '%s'.\n%s", args[0], usage))
+			return true
+		}
+
+		if err := channel.ValidateAddress(args[1]); err != nil {
+			reply(err.Error())
+			return true
+		}
+
+		allowed, err := allowNotifyChannelCode(c.db, c.User.ID, time.Now())
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't count the verification codes")
+			reply(c.Branding().ErrorText("Can't set up the channel. Please try again later"))
+			return true
+		} else if !allowed {
+			reply("Too many verification codes were sent. Please try again later")
+			return true
+		}
+
+		code, err := notifyChannelCode()
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't generate the code")
+			reply(c.Branding().ErrorText("Can't set up the channel. Please try again later"))
+			return true
+		}
+
+		err = channel.Send(args[1], Notification{
+			Service: c.ServiceName,
+			Subject: fmt.Sprintf("%s verification code", c.Service().NameToPrint),
+			Text:    fmt.Sprintf("Send '/%s verify %s' to the bot to receive %s critical alerts here", coreCommand(notifyChannelCommand), code, c.Service().NameToPrint),
+		})
+		if err != nil {
+			c.Log().WithError(err).WithField("channel", args[0]).Warn("handleNotifyChannelCommand: can't send the code")
+			reply(fmt.Sprintf("Can't send the verification code to %s: %s", args[1], err.Error()))
+			return true
+		}
+
+		err = c.db.C("users").UpdateId(c.User.ID, bson.M{"$pull": bson.M{"notifychannels": bson.M{"k": args[0]}}})
+		if err == nil {
+			err = c.db.C("users").UpdateId(c.User.ID, bson.M{"$push": bson.M{"notifychannels": userNotificationChannel{Kind: args[0], Address: args[1], Code: code, CodeSentAt: time.Now()}}})
+		}
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotifyChannelCommand: can't save the channel")
+			reply(c.Branding().ErrorText("Can't set up the channel. Please try again later"))
+			return true
+		}
+		reply(fmt.Sprintf("Verification code was sent to %s. Send /%s verify code to confirm it", args[1], coreCommand(notifyChannelCommand)))
+	default:
+		reply(usage)
+	}
+
+	return true
+}
@@ -0,0 +1,150 @@
+package integram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_webhookNotificationChannel_ValidateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"https://example.com/hook", false},
+		{"http://example.com/hook", true},
+		{"example.com", true},
+		{"https://", true},
+	}
+	for _, tt := range tests {
+		if err := (webhookNotificationChannel{}).ValidateAddress(tt.address); (err != nil) != tt.wantErr {
+			t.Errorf("webhookNotificationChannel.ValidateAddress(%q) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_emailNotificationChannel_ValidateAddress(t *testing.T) {
+	prev := Config.SMTPAddr
+	defer func() { Config.SMTPAddr = prev }()
+
+	Config.SMTPAddr = ""
+	if err := (emailNotificationChannel{}).ValidateAddress("me@example.com"); err == nil {
+		t.Errorf("emailNotificationChannel.ValidateAddress() must fail when SMTP is not configured")
+	}
+
+	Config.SMTPAddr = "smtp.example.com:587"
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"me@example.com", false},
+		{"Me <me@example.com>", true},
+		{"me", true},
+	}
+	for _, tt := range tests {
+		if err := (emailNotificationChannel{}).ValidateAddress(tt.address); (err != nil) != tt.wantErr {
+			t.Errorf("emailNotificationChannel.ValidateAddress(%q) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_webhookNotificationChannel_Send(t *testing.T) {
+	var received Notification
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		if received.Text == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	n := Notification{Service: "trello", Subject: "alert", Text: "Card moved"}
+	if err := (webhookNotificationChannel{}).Send(ts.URL, n); err == nil {
+		t.Fatalf("webhookNotificationChannel.Send() must refuse the loopback address")
+	}
+
+	prev := notifyChannelClient
+	defer func() { notifyChannelClient = prev }()
+	notifyChannelClient = ts.Client()
+
+	if err := (webhookNotificationChannel{}).Send(ts.URL, n); err != nil {
+		t.Fatalf("webhookNotificationChannel.Send() error = %v", err)
+	}
+
+	if received != n {
+		t.Errorf("webhookNotificationChannel.Send() posted %+v, want %+v", received, n)
+	}
+
+	if err := (webhookNotificationChannel{}).Send(ts.URL, Notification{Text: "fail"}); err == nil {
+		t.Errorf("webhookNotificationChannel.Send() must fail on the error status")
+	}
+}
+
+func Test_notificationEmail(t *testing.T) {
+	got := string(notificationEmail("bot@example.com", "me@example.com", Notification{Subject: "Alert\r\nBcc: x@example.com", Text: "line 1\nline 2"}))
+	want := "From: bot@example.com\r\nTo: me@example.com\r\nSubject: Alert  Bcc: x@example.com\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nline 1\r\nline 2"
+	if got != want {
+		t.Errorf("notificationEmail() = %q, want %q", got, want)
+	}
+}
+
+func Test_notifyChannelCode(t *testing.T) {
+	code, err := notifyChannelCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`^\d{6}$`).MatchString(code) {
+		t.Errorf("notifyChannelCode() = %q, want 6 digits", code)
+	}
+}
+
+func Test_verifyNotificationChannel(t *testing.T) {
+	now := time.Now()
+	channels := []userNotificationChannel{
+		{Kind: "email", Address: "me@example.com", Verified: true},
+		{Kind: "webhook", Address: "https://example.com", Code: "123456", CodeSentAt: now.Add(-time.Minute)},
+		{Kind: "custom", Address: "x", Code: "654321", CodeSentAt: now.Add(-notifyChannelCodeTTL)},
+	}
+
+	tests := []struct {
+		code string
+		want int
+	}{
+		{"123456", 1},
+		{"000000", -1},
+		{"654321", -1}, // expired
+		{"", -1},
+	}
+	for _, tt := range tests {
+		if got := verifyNotificationChannel(channels, tt.code, now); got != tt.want {
+			t.Errorf("verifyNotificationChannel(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func Test_allowNotifyChannelCode(t *testing.T) {
+	clearData()
+	db.C("users").Insert(bson.M{"_id": 9999999999, "firstname": "Matthew"})
+
+	now := time.Now()
+	for i := 0; i < notifyChannelCodesLimit; i++ {
+		if allowed, err := allowNotifyChannelCode(db, 9999999999, now); err != nil || !allowed {
+			t.Fatalf("allowNotifyChannelCode() #%d = %v, %v, want true", i+1, allowed, err)
+		}
+	}
+
+	if allowed, err := allowNotifyChannelCode(db, 9999999999, now); err != nil || allowed {
+		t.Errorf("allowNotifyChannelCode() over the limit = %v, %v, want false", allowed, err)
+	}
+
+	if allowed, err := allowNotifyChannelCode(db, 9999999999, now.Add(notifyChannelCodeTTL)); err != nil || !allowed {
+		t.Errorf("allowNotifyChannelCode() after the period = %v, %v, want true", allowed, err)
+	}
+}
@@ -0,0 +1,535 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const notificationFilterCommand = "filter"
+
+// inline buttons of the /filter message
+const (
+	notificationFilterPresetData = "_nf_p" // followed by the index of the service's preset
+	notificationFilterDeleteData = "_nf_d" // followed by the index of the chat's rule
+)
+
+// max number of rules per chat and service
+const notificationFiltersMax = 20
+
+// EventFields are the event's fields checked by the chats' notification filters, e.g. {"author": "bot", "branch": "release/1.2", "labels": "bug,urgent"}
+type EventFields map[string]string
+
+// notificationFilter is the parsed filter expression
+type notificationFilter interface {
+	match(fields EventFields) bool
+}
+
+type filterComparison struct {
+	field string
+	op    string // ==, !=, matches or contains
+	value string
+}
+
+type filterAnd struct{ left, right notificationFilter }
+type filterOr struct{ left, right notificationFilter }
+type filterNot struct{ expr notificationFilter }
+
+func (f filterComparison) match(fields EventFields) bool {
+	v := fields[f.field]
+	switch f.op {
+	case "==":
+		return strings.EqualFold(v, f.value)
+	case "!=":
+		return !strings.EqualFold(v, f.value)
+	case "matches":
+		ok, _ := path.Match(strings.ToLower(f.value), strings.ToLower(v))
+		return ok
+	case "contains":
+		return strings.Contains(strings.ToLower(v), strings.ToLower(f.value))
+	}
+	return false
+}
+
+func (f filterAnd) match(fields EventFields) bool {
+	return f.left.match(fields) && f.right.match(fields)
+}
+func (f filterOr) match(fields EventFields) bool {
+	return f.left.match(fields) || f.right.match(fields)
+}
+func (f filterNot) match(fields EventFields) bool { return !f.expr.match(fields) }
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeNotificationFilter splits the expression into the words, quoted strings, parentheses and == != operators
+func tokenizeNotificationFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	r := []rune(s)
+
+	for i := 0; i < len(r); {
+		switch {
+		case unicode.IsSpace(r[i]):
+			i++
+		case r[i] == '(' || r[i] == ')':
+			tokens = append(tokens, filterToken{text: string(r[i])})
+			i++
+		case r[i] == '=' || r[i] == '!':
+			if i+1 >= len(r) || r[i+1] != '=' {
+				return nil, fmt.Errorf("unexpected '%c', use == or !=", r[i])
+			}
+			tokens = append(tokens, filterToken{text: string(r[i : i+2])})
+			i += 2
+		case r[i] == '"' || r[i] == '\'':
+			end := i + 1
+			for end < len(r) && r[end] != r[i] {
+				end++
+			}
+			if end >= len(r) {
+				return nil, errors.New("unterminated quoted string")
+			}
+			tokens = append(tokens, filterToken{text: string(r[i+1 : end]), quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(r) && !unicode.IsSpace(r[end]) && !strings.ContainsRune("()=!\"'", r[end]) {
+				end++
+			}
+			tokens = append(tokens, filterToken{text: string(r[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type notificationFilterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// keyword returns true and moves to the next token if the current one is the unquoted keyword
+func (p *notificationFilterParser) keyword(words ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return "", false
+	}
+
+	for _, w := range words {
+		if strings.EqualFold(p.tokens[p.pos].text, w) {
+			p.pos++
+			return w, true
+		}
+	}
+	return "", false
+}
+
+func (p *notificationFilterParser) parseOr() (notificationFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.keyword("or"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+}
+
+func (p *notificationFilterParser) parseAnd() (notificationFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if _, ok := p.keyword("and"); !ok {
+			return left, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+}
+
+func (p *notificationFilterParser) parseUnary() (notificationFilter, error) {
+	if _, ok := p.keyword("not"); ok {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{expr}, nil
+	}
+
+	if _, ok := p.keyword("("); ok {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.keyword(")"); !ok {
+			return nil, errors.New("missing ')'")
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *notificationFilterParser) parseComparison() (notificationFilter, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, errors.New("expected the condition like: field == value")
+	}
+
+	field := p.tokens[p.pos]
+	if field.quoted || field.text == "(" || field.text == ")" {
+		return nil, fmt.Errorf("expected the field name, got '%s'", field.text)
+	}
+	p.pos++
+
+	op, ok := p.keyword("==", "!=", "matches", "contains")
+	if !ok {
+		return nil, fmt.Errorf("expected ==, !=, matches or contains after '%s'", field.text)
+	}
+
+	value := p.tokens[p.pos]
+	if !value.quoted && (value.text == "(" || value.text == ")") {
+		return nil, fmt.Errorf("expected the value after '%s %s'", field.text, op)
+	}
+	p.pos++
+
+	if op == "matches" {
+		if _, err := path.Match(value.text, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern '%s'", value.text)
+		}
+	}
+
+	return filterComparison{field: strings.ToLower(field.text), op: op, value: value.text}, nil
+}
+
+// parseNotificationFilter parses the expression like `author != bot and (branch matches release/* or labels contains urgent)`
+func parseNotificationFilter(s string) (notificationFilter, error) {
+	tokens, err := tokenizeNotificationFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, errors.New("empty filter")
+	}
+
+	p := &notificationFilterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected '%s'", tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+// notificationFiltersMatch returns true if the event matches all the rules. Rules that can't be parsed are ignored
+func notificationFiltersMatch(rules []string, fields EventFields) bool {
+	for _, rule := range rules {
+		f, err := parseNotificationFilter(rule)
+		if err != nil {
+			continue
+		}
+
+		if !f.match(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// NotificationFilters returns the chat's notification filter rules for the current service
+func (chat *Chat) NotificationFilters() ([]string, error) {
+	var data struct {
+		Filters map[string][]string
+	}
+
+	err := chat.ctx.db.C("chats").FindId(chat.ID).Select(bson.M{"filters." + chat.ctx.ServiceName: 1}).One(&data)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return data.Filters[chat.ctx.ServiceName], nil
+}
+
+// SetNotificationFilters saves the chat's notification filter rules for the current service. Empty rules disable the filtering
+func (chat *Chat) SetNotificationFilters(rules []string) error {
+	key := "filters." + chat.ctx.ServiceName
+	if len(rules) == 0 {
+		return chat.ctx.db.C("chats").UpdateId(chat.ID, bson.M{"$unset": bson.M{key: ""}})
+	}
+
+	for _, rule := range rules {
+		if _, err := parseNotificationFilter(rule); err != nil {
+			return fmt.Errorf("rule '%s': %s", rule, err.Error())
+		}
+	}
+
+	_, err := chat.ctx.db.C("chats").UpsertId(chat.ID, bson.M{"$set": bson.M{key: rules}, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	return err
+}
+
+// AcceptsEvent returns true if the event's fields match the chat's notification filters. Use it before rendering the notification
+func (chat *Chat) AcceptsEvent(fields EventFields) (bool, error) {
+	if fields == nil {
+		return true, nil
+	}
+
+	rules, err := chat.NotificationFilters()
+	if err != nil {
+		return true, err
+	}
+
+	return notificationFiltersMatch(rules, fields), nil
+}
+
+// SendEventToChats works like SendToChats, but skips the chats which notification filters don't match the event's fields before rendering
+func (c *Context) SendEventToChats(chatIDs []int64, fields EventFields, render RenderFunc) (sent int, err error) {
+	for _, chatID := range chatIDs {
+		ctx := c.ForkForChat(chatID)
+
+		accepts, filterErr := ctx.Chat.AcceptsEvent(fields)
+		if filterErr != nil {
+			ctx.Log().WithError(filterErr).Error("SendEventToChats: can't get the notification filters")
+		}
+
+		if !accepts {
+			ctx.StatInc(StatNotificationFiltered)
+			continue
+		}
+
+		msg, renderErr := render(ctx, ctx.Recipient())
+		if renderErr != nil {
+			ctx.Log().WithError(renderErr).Error("SendToChats: can't render the message")
+			err = renderErr
+			continue
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		if sendErr := msg.Send(); sendErr != nil {
+			ctx.Log().WithError(sendErr).Error("SendToChats: can't send the message")
+			err = sendErr
+			continue
+		}
+
+		sent++
+	}
+
+	return sent, err
+}
+
+// notificationFiltersText lists the chat's rules and the syntax
+func notificationFiltersText(rules []string) string {
+	b := instanceBranding()
+	usage := fmt.Sprintf("Add the rule with /%s field == value. Operators: ==, !=, matches (e.g. release/*), contains. Combine them with and, or, not and parentheses. /%s off removes all rules", coreCommand(notificationFilterCommand), coreCommand(notificationFilterCommand))
+
+	if len(rules) == 0 {
+		return b.EmojiPrefix("🔍") + "Notification filters are not set, all notifications are delivered.\n" + usage
+	}
+
+	lines := []string{b.EmojiPrefix("🔍") + "Notifications are delivered only if they match all the rules:"}
+	for i, rule := range rules {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, rule))
+	}
+	return strings.Join(lines, "\n") + "\n" + usage
+}
+
+// notificationFiltersKeyboard returns the buttons to toggle the service's presets and remove the other rules
+func notificationFiltersKeyboard(rules []string, presets []string) InlineKeyboard {
+	kb := InlineKeyboard{}
+
+	enabled := make(map[string]bool)
+	for _, rule := range rules {
+		enabled[rule] = true
+	}
+
+	isPreset := make(map[string]bool)
+	for i, preset := range presets {
+		if _, err := parseNotificationFilter(preset); err != nil {
+			continue
+		}
+		isPreset[preset] = true
+
+		text := "➕ " + preset
+		if enabled[preset] {
+			text = "✅ " + preset
+		}
+		kb.AppendRows(InlineButtons{InlineButton{Text: text, Data: notificationFilterPresetData + strconv.Itoa(i)}})
+	}
+
+	for i, rule := range rules {
+		if isPreset[rule] {
+			continue
+		}
+		kb.AppendRows(InlineButtons{InlineButton{Text: "🗑 " + rule, Data: notificationFilterDeleteData + strconv.Itoa(i)}})
+	}
+
+	return kb
+}
+
+// toggleNotificationFilter applies the /filter message's button to the rules
+func toggleNotificationFilter(rules []string, presets []string, data string) ([]string, error) {
+	if strings.HasPrefix(data, notificationFilterPresetData) {
+		i, err := strconv.Atoi(strings.TrimPrefix(data, notificationFilterPresetData))
+		if err != nil || i < 0 || i >= len(presets) {
+			return nil, errors.New("unknown preset")
+		}
+
+		var res []string
+		for _, rule := range rules {
+			if rule != presets[i] {
+				res = append(res, rule)
+			}
+		}
+
+		if len(res) == len(rules) {
+			res = append(res, presets[i])
+		}
+		return res, nil
+	}
+
+	i, err := strconv.Atoi(strings.TrimPrefix(data, notificationFilterDeleteData))
+	if err != nil || i < 0 || i >= len(rules) {
+		return nil, errors.New("unknown rule")
+	}
+
+	return append(append([]string{}, rules[:i]...), rules[i+1:]...), nil
+}
+
+// isNotificationFilterCallback returns true if the button of the /filter message is pressed
+func isNotificationFilterCallback(data string) bool {
+	return strings.HasPrefix(data, notificationFilterPresetData) || strings.HasPrefix(data, notificationFilterDeleteData)
+}
+
+// handleNotificationFilterCallback process the buttons of the /filter message pressed by the chat admin
+func (c *Context) handleNotificationFilterCallback() error {
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		return err
+	} else if !isAdmin {
+		return c.AnswerCallbackQuery("Only chat admins can change the notification filters", true)
+	}
+
+	rules, err := c.Chat.NotificationFilters()
+	if err != nil {
+		return err
+	}
+
+	presets := c.Service().NotificationFilterPresets
+	rules, err = toggleNotificationFilter(rules, presets, c.Callback.Data)
+	if err != nil {
+		return c.AnswerCallbackQuery("This button is outdated, please send /"+coreCommand(notificationFilterCommand)+" again", false)
+	}
+
+	err = c.Chat.SetNotificationFilters(rules)
+	if err != nil {
+		return err
+	}
+
+	om := c.Callback.Message
+	err = c.EditMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, notificationFiltersText(rules), notificationFiltersKeyboard(rules, presets))
+	if err != nil {
+		return err
+	}
+
+	return c.AnswerCallbackQuery("", false)
+}
+
+// handleNotificationFilterCommand process '/filter [rule|off]' sent by the chat admin. Returns true if message was handled
+func (c *Context) handleNotificationFilterCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand(notificationFilterCommand) {
+		return false
+	}
+
+	reply := func(text string, rules []string) {
+		msg := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).SetParseMode("").applyBrandingFooter()
+		if rules != nil {
+			msg.SetInlineKeyboard(notificationFiltersKeyboard(rules, c.Service().NotificationFilterPresets))
+		}
+
+		err := msg.Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleNotificationFilterCommand: can't send the reply")
+		}
+	}
+
+	rules, err := c.Chat.NotificationFilters()
+	if err != nil {
+		c.Log().WithError(err).Error("handleNotificationFilterCommand: can't get the filters")
+		reply(c.Branding().ErrorText("Can't get the notification filters. Please try again later"), nil)
+		return true
+	}
+
+	param = strings.TrimSpace(param)
+	if param == "" {
+		reply(notificationFiltersText(rules), append([]string{}, rules...))
+		return true
+	}
+
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("handleNotificationFilterCommand: can't check chat admin")
+		reply("Can't check your permissions in this chat. Please try again later", nil)
+		return true
+	} else if !isAdmin {
+		reply("Only chat admins can change the notification filters", nil)
+		return true
+	}
+
+	if strings.ToLower(param) == "off" {
+		rules = nil
+	} else {
+		if _, err := parseNotificationFilter(param); err != nil {
+			reply(fmt.Sprintf("Can't parse the rule: %s", err.Error()), nil)
+			return true
+		}
+
+		if len(rules) >= notificationFiltersMax {
+			reply(fmt.Sprintf("Max %d rules are allowed. Remove some of them first", notificationFiltersMax), nil)
+			return true
+		}
+		rules = append(rules, param)
+	}
+
+	err = c.Chat.SetNotificationFilters(rules)
+	if err != nil {
+		c.Log().WithError(err).Error("handleNotificationFilterCommand: can't save the filters")
+		reply(c.Branding().ErrorText("Can't save the notification filters. Please try again later"), nil)
+		return true
+	}
+
+	reply(notificationFiltersText(rules), append([]string{}, rules...))
+	return true
+}
@@ -0,0 +1,118 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseNotificationFilter(t *testing.T) {
+	fields := EventFields{"author": "dependabot", "branch": "release/1.2", "labels": "bug,Urgent"}
+
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{"author != bot", true, false},
+		{"author == Dependabot", true, false},
+		{"branch matches release/*", true, false},
+		{"branch matches 'feature/*'", false, false},
+		{"labels contains urgent", true, false},
+		{"author!=dependabot or labels contains bug and branch == master", false, false},
+		{"(author != dependabot or labels contains bug) and not branch == master", true, false},
+		{"missing == ''", true, false},
+		{"author = bot", false, true},
+		{"author != ", false, true},
+		{"branch matches '['", false, true},
+		{"(author != bot", false, true},
+		{"author != bot labels", false, true},
+		{"author is bot", false, true},
+		{"'author' == bot", false, true},
+		{"", false, true},
+	}
+	for _, tt := range tests {
+		f, err := parseNotificationFilter(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseNotificationFilter(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if err == nil && f.match(fields) != tt.want {
+			t.Errorf("parseNotificationFilter(%q).match() = %v, want %v", tt.expr, !tt.want, tt.want)
+		}
+	}
+}
+
+func Test_toggleNotificationFilter(t *testing.T) {
+	presets := []string{"author != bot", "labels contains urgent"}
+
+	tests := []struct {
+		name    string
+		rules   []string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"enable preset", []string{"branch == master"}, "_nf_p1", []string{"branch == master", "labels contains urgent"}, false},
+		{"disable preset", []string{"author != bot", "branch == master"}, "_nf_p0", []string{"branch == master"}, false},
+		{"delete rule", []string{"author != bot", "branch == master"}, "_nf_d1", []string{"author != bot"}, false},
+		{"unknown preset", nil, "_nf_p2", nil, true},
+		{"outdated rule", []string{"branch == master"}, "_nf_d1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := toggleNotificationFilter(tt.rules, presets, tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. toggleNotificationFilter() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. toggleNotificationFilter() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	kb := notificationFiltersKeyboard([]string{"branch == master", "author != bot"}, append(presets, "bad =="))
+	var texts []string
+	for _, row := range kb.Buttons {
+		texts = append(texts, row[0].Text+" "+row[0].Data)
+	}
+
+	want := []string{"✅ author != bot _nf_p0", "➕ labels contains urgent _nf_p1", "🗑 branch == master _nf_d0"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("notificationFiltersKeyboard() = %v, want %v", texts, want)
+	}
+}
+
+func TestChat_AcceptsEvent(t *testing.T) {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	ctx.Chat = Chat{ID: -100600, ctx: ctx}
+	defer db.C("chats").RemoveId(ctx.Chat.ID)
+
+	if err := ctx.Chat.SetNotificationFilters([]string{"author =="}); err == nil {
+		t.Error("SetNotificationFilters() with the bad rule should return error")
+	}
+
+	err := ctx.Chat.SetNotificationFilters([]string{"author != bot", "branch matches release/*"})
+	if err != nil {
+		t.Fatalf("SetNotificationFilters() error = %v", err)
+	}
+
+	tests := []struct {
+		fields EventFields
+		want   bool
+	}{
+		{nil, true},
+		{EventFields{"author": "alice", "branch": "release/2.0"}, true},
+		{EventFields{"author": "bot", "branch": "release/2.0"}, false},
+		{EventFields{"author": "alice", "branch": "master"}, false},
+	}
+	for _, tt := range tests {
+		got, err := ctx.Chat.AcceptsEvent(tt.fields)
+		if err != nil || got != tt.want {
+			t.Errorf("AcceptsEvent(%v) = %v, %v, want %v", tt.fields, got, err, tt.want)
+		}
+	}
+
+	err = ctx.Chat.SetNotificationFilters(nil)
+	if got, _ := ctx.Chat.AcceptsEvent(EventFields{"author": "bot"}); err != nil || !got {
+		t.Errorf("AcceptsEvent() after filters removed = %v, %v", got, err)
+	}
+}
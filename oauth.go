@@ -1,9 +1,11 @@
 package integram
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -14,11 +16,24 @@ import (
 type OAuthTokenSource struct {
 	user *User
 	last oauth2.Token
+	mu   sync.Mutex
 }
 
+// Token returns the last token or refreshes it with the stored refresh token if it's expired. Refreshed tokens are saved to the store
 func (tsw *OAuthTokenSource) Token() (*oauth2.Token, error) {
+	tsw.mu.Lock()
+	defer tsw.mu.Unlock()
+
 	lastToken := tsw.last
-	ts := tsw.user.ctx.OAuthProvider().OAuth2Client(tsw.user.ctx).TokenSource(oauth2.NoContext, &lastToken)
+	provider := tsw.user.ctx.OAuthProvider()
+
+	var ts oauth2.TokenSource
+	if oauthBrokered(provider) {
+		// only the broker has the OAuth app's secret to refresh the token
+		ts = oauth2.ReuseTokenSource(&lastToken, oauthBrokerTokenSource{service: provider.Service, refreshToken: lastToken.RefreshToken})
+	} else {
+		ts = provider.OAuth2Client(tsw.user.ctx).TokenSource(oauth2.NoContext, &lastToken)
+	}
 	token, err := ts.Token()
 	if err != nil {
 		if strings.Contains(err.Error(), "revoked") || strings.Contains(err.Error(), "invalid_grant") {
@@ -49,12 +64,33 @@ func (tsw *OAuthTokenSource) Token() (*oauth2.Token, error) {
 				tsw.user.ctx.Log().Errorf("failed to set OAuth Access token in store: %s", err.Error())
 			}
 		}
+
+		// the same source is used for all requests of the client
+		tsw.last = *token
 	}
 
 	return token, nil
 }
 
-// OAuthHTTPClient returns HTTP client with Bearer authorization headers
+// refresh refreshes the access token even if it isn't expired yet, e.g. revoked by the provider before the expiry
+func (tsw *OAuthTokenSource) refresh(rejected *oauth2.Token) (*oauth2.Token, error) {
+	tsw.mu.Lock()
+	if tsw.last.RefreshToken == "" {
+		tsw.mu.Unlock()
+		return nil, errors.New("Refresh token is not set")
+	}
+
+	// token could be already refreshed by the concurrent request
+	if rejected == nil || tsw.last.AccessToken == rejected.AccessToken {
+		tsw.last.Expiry = time.Now().Add(-time.Minute)
+	}
+	tsw.mu.Unlock()
+
+	return tsw.Token()
+}
+
+// OAuthHTTPClient returns HTTP client with Bearer authorization headers. Expired access token is refreshed with the stored refresh token.
+// Request answered with 401 Unauthorized is retried once after the refresh, so the user doesn't need to authorize again when the provider revokes the token early
 func (user *User) OAuthHTTPClient() *http.Client {
 	if user.ctx.Service().DefaultOAuth2 != nil {
 		ts, err := user.OAuthTokenSource()
@@ -63,6 +99,9 @@ func (user *User) OAuthHTTPClient() *http.Client {
 			return nil
 		}
 
+		if ots, ok := ts.(*OAuthTokenSource); ok {
+			return &http.Client{Transport: &oauthRefreshTransport{source: ots, base: http.DefaultTransport}}
+		}
 		return oauth2.NewClient(oauth2.NoContext, ts)
 	} else if user.ctx.Service().DefaultOAuth1 != nil {
 		//todo make a correct httpclient
@@ -73,6 +112,10 @@ func (user *User) OAuthHTTPClient() *http.Client {
 
 // OAuthValid checks if OAuthToken for service is set
 func (user *User) OAuthValid() bool {
+	if shared := user.sharedOAuthUser(); shared != nil {
+		return shared.OAuthValid()
+	}
+
 	token, _, _ := oauthTokenStore.GetOAuthAccessToken(user)
 	return token != ""
 }
@@ -98,6 +141,10 @@ func (user *User) OAuthTokenSource() (oauth2.TokenSource, error) {
 		return nil, fmt.Errorf("DefaultOAuth2 config not set for the service")
 	}
 
+	if shared := user.sharedOAuthUser(); shared != nil {
+		return shared.OAuthTokenSource()
+	}
+
 	accessToken, expireDate, err := oauthTokenStore.GetOAuthAccessToken(user)
 	if err != nil {
 		user.ctx.Log().Errorf("can't create OAuthTokenSource: oauthTokenStore.GetOAuthAccessToken got error: %s", err.Error())
@@ -148,6 +195,11 @@ func (user *User) OAuthToken() string {
 
 // ResetOAuthToken reset OAuthToken for service
 func (user *User) ResetOAuthToken() error {
+	// don't reset the token of another service, only the consent to use it
+	if user.OAuthSharedFrom() != "" {
+		return user.RevokeOAuthSharing()
+	}
+
 	err := oauthTokenStore.SetOAuthAccessToken(user, "", nil)
 	if err != nil {
 		user.ctx.Log().WithError(err).Error("ResetOAuthToken error")
@@ -175,6 +227,9 @@ func (user *User) OauthInitURL() string {
 	}
 	if s.DefaultOAuth2 != nil {
 		provider := user.ctx.OAuthProvider()
+		if oauthBrokered(provider) {
+			return user.oauthBrokerStartURL(authTempToken)
+		}
 
 		return provider.OAuth2Client(user.ctx).AuthCodeURL(authTempToken, oauth2.AccessTypeOffline)
 	}
@@ -0,0 +1,346 @@
+package integram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// OAuth broker allows the self-hosted instance to use the OAuth apps registered by the hosted instance (broker).
+// Self-hosted instance (client) signs the requests with the secret shared with the broker:
+//
+//  1. client redirects the user to /oauthbroker/service_name/start with its auth temp token as the state and its redirect URL
+//  2. broker redirects the user to the upstream's OAuth with its own app and receives the token at /auth/service_name
+//  3. broker redirects the user back to the client with the one-time code
+//  4. client exchanges the code for the token at /oauthbroker/service_name/token. Later the token is refreshed at /oauthbroker/service_name/refresh
+const (
+	oauthBrokerActionStart   = "start"
+	oauthBrokerActionToken   = "token"
+	oauthBrokerActionRefresh = "refresh"
+)
+
+// max difference between the signed request's timestamp and the current time
+const oauthBrokerRequestMaxAge = time.Minute * 5
+
+// how long the user has to finish the OAuth and the client to exchange the code
+const oauthBrokerSessionTTL = time.Minute * 10
+
+var oauthBrokerHTTPClient = &http.Client{Timeout: time.Second * 15}
+
+// oauthBrokerSession is stored in the "oauth_broker_sessions" collection by the broker while the user is passing the OAuth
+type oauthBrokerSession struct {
+	ID       string        `bson:"_id"` // state passed to the upstream
+	Client   string        `bson:"c"`
+	Service  string        `bson:"s"`
+	State    string        `bson:"st"` // client's auth temp token
+	Redirect string        `bson:"r"`  // client's OAuth redirect URL
+	Code     string        `bson:"code,omitempty"`
+	Token    *oauth2.Token `bson:"tok,omitempty"`
+	Date     time.Time     `bson:"d"`
+}
+
+// oauthBrokerSign returns the signature of the broker request's fields
+func oauthBrokerSign(secret string, fields ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// oauthBrokerClientSecret returns the secret of the client allowed to use the broker
+func oauthBrokerClientSecret(clientID string) string {
+	for _, client := range Config.OAuthBrokerClients {
+		kv := strings.SplitN(client, ":", 2)
+		if len(kv) == 2 && kv[0] == clientID {
+			return kv[1]
+		}
+	}
+
+	return ""
+}
+
+// verifyOAuthBrokerRequest checks the signature and the timestamp of the client's request
+func verifyOAuthBrokerRequest(clientID, service, action, ts, sig string, payload ...string) error {
+	secret := oauthBrokerClientSecret(clientID)
+	if clientID == "" || secret == "" {
+		return errors.New("unknown client")
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("bad timestamp")
+	}
+
+	if age := time.Since(time.Unix(unix, 0)); age > oauthBrokerRequestMaxAge || age < -oauthBrokerRequestMaxAge {
+		return errors.New("request expired")
+	}
+
+	want := oauthBrokerSign(secret, append([]string{clientID, service, action, ts}, payload...)...)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return errors.New("bad signature")
+	}
+
+	return nil
+}
+
+// signedOAuthBrokerValues returns the client's request params signed with the broker's secret
+func signedOAuthBrokerValues(service, action string, payload ...string) url.Values {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	v := url.Values{}
+	v.Set("client", Config.OAuthBrokerClientID)
+	v.Set("ts", ts)
+	v.Set("sig", oauthBrokerSign(Config.OAuthBrokerSecret, append([]string{Config.OAuthBrokerClientID, service, action, ts}, payload...)...))
+	return v
+}
+
+// oauthBrokered returns true if the provider has no own OAuth app and the instance delegates OAuth to the broker
+func oauthBrokered(o *OAuthProvider) bool {
+	if Config.OAuthBrokerURL == "" || o == nil || o.IsSetup() {
+		return false
+	}
+
+	s, _ := serviceByName(o.Service)
+	return s != nil && s.DefaultOAuth2 != nil && o.internalID() == s.Name
+}
+
+// oauthBrokerStartURL returns the broker's URL to start OAuth for the user
+func (user *User) oauthBrokerStartURL(authTempToken string) string {
+	service := user.ctx.ServiceName
+	redirect := user.OauthRedirectURL()
+
+	v := signedOAuthBrokerValues(service, oauthBrokerActionStart, authTempToken, redirect)
+	v.Set("state", authTempToken)
+	v.Set("redirect", redirect)
+
+	return fmt.Sprintf("%s/oauthbroker/%s/%s?%s", strings.TrimRight(Config.OAuthBrokerURL, "/"), service, oauthBrokerActionStart, v.Encode())
+}
+
+// oauthBrokerRequestToken gets the token from the broker in exchange for the one-time code or the refresh token
+func oauthBrokerRequestToken(service, action, codeOrRefreshToken string) (*oauth2.Token, error) {
+	v := signedOAuthBrokerValues(service, action, codeOrRefreshToken)
+	if action == oauthBrokerActionToken {
+		v.Set("code", codeOrRefreshToken)
+	} else {
+		v.Set("refresh_token", codeOrRefreshToken)
+	}
+
+	resp, err := oauthBrokerHTTPClient.PostForm(fmt.Sprintf("%s/oauthbroker/%s/%s", strings.TrimRight(Config.OAuthBrokerURL, "/"), service, action), v)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OAuth broker returned %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	token := oauth2.Token{}
+	err = json.Unmarshal(b, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, errors.New("OAuth broker returned empty token")
+	}
+
+	return &token, nil
+}
+
+// oauthBrokerTokenSource refreshes the token with the broker, because the client doesn't have the OAuth app's secret
+type oauthBrokerTokenSource struct {
+	service      string
+	refreshToken string
+}
+
+func (ts oauthBrokerTokenSource) Token() (*oauth2.Token, error) {
+	if ts.refreshToken == "" {
+		return nil, errors.New("oauth2: token expired and refresh token is not set")
+	}
+
+	token, err := oauthBrokerRequestToken(ts.service, oauthBrokerActionRefresh, ts.refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken == "" {
+		token.RefreshToken = ts.refreshToken
+	}
+
+	return token, nil
+}
+
+// oauthBrokerConfig returns the broker's own OAuth app config for the service
+func oauthBrokerConfig(c *Context) *oauth2.Config {
+	o := c.Service().DefaultOAuthProvider()
+	if !o.IsSetup() {
+		return nil
+	}
+
+	config := o.OAuth2Client(c)
+	if config != nil {
+		config.RedirectURL = o.RedirectURL()
+	}
+
+	return config
+}
+
+// oauthBrokerHandler handles the client's requests to the broker: /oauthbroker/service_name/action
+func oauthBrokerHandler(c *gin.Context, s *Service, action string) {
+	if len(Config.OAuthBrokerClients) == 0 || s == nil {
+		c.String(http.StatusNotFound, "OAuth broker is disabled")
+		return
+	}
+
+	db := c.MustGet("db").(*mgo.Database)
+	ctx := &Context{ServiceName: s.Name, db: db, gin: c}
+
+	config := oauthBrokerConfig(ctx)
+	if s.DefaultOAuth2 == nil || config == nil {
+		c.String(http.StatusNotFound, "OAuth is not available for this service")
+		return
+	}
+
+	clientID := c.Request.FormValue("client")
+	l := log.WithField("client", clientID).WithField("service", s.Name).WithField("action", action)
+
+	var payload []string
+	switch action {
+	case oauthBrokerActionStart:
+		payload = []string{c.Query("state"), c.Query("redirect")}
+	case oauthBrokerActionToken:
+		payload = []string{c.Request.PostFormValue("code")}
+	case oauthBrokerActionRefresh:
+		payload = []string{c.Request.PostFormValue("refresh_token")}
+	default:
+		c.String(http.StatusNotFound, "Unknown action")
+		return
+	}
+
+	err := verifyOAuthBrokerRequest(clientID, s.Name, action, c.Request.FormValue("ts"), c.Request.FormValue("sig"), payload...)
+	if err != nil {
+		l.WithError(err).Warn("OAuth broker request rejected")
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+
+	switch action {
+	case oauthBrokerActionStart:
+		if payload[0] == "" || !strings.HasPrefix(payload[1], "http") {
+			c.String(http.StatusBadRequest, "state and redirect are required")
+			return
+		}
+
+		sess := oauthBrokerSession{ID: strings.ToLower(rndStr.Get(32)), Client: clientID, Service: s.Name, State: payload[0], Redirect: payload[1], Date: time.Now()}
+		err = db.C("oauth_broker_sessions").Insert(sess)
+		if err != nil {
+			l.WithError(err).Error("Can't save OAuth broker session")
+			c.String(http.StatusInternalServerError, "Error occurred")
+			return
+		}
+
+		c.Redirect(http.StatusFound, config.AuthCodeURL(sess.ID, oauth2.AccessTypeOffline))
+	case oauthBrokerActionToken:
+		sess := oauthBrokerSession{}
+		// the code is one-time
+		_, err = db.C("oauth_broker_sessions").Find(bson.M{"code": payload[0], "c": clientID, "s": s.Name}).Apply(mgo.Change{Remove: true}, &sess)
+		if err != nil || sess.Token == nil {
+			c.String(http.StatusForbidden, "Unknown code")
+			return
+		}
+
+		c.JSON(http.StatusOK, sess.Token)
+	case oauthBrokerActionRefresh:
+		token, err := config.TokenSource(oauth2.NoContext, &oauth2.Token{RefreshToken: payload[0]}).Token()
+		if err != nil {
+			l.WithError(err).Warn("OAuth broker can't refresh the token")
+			c.String(http.StatusForbidden, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, token)
+	}
+}
+
+// findOAuthBrokerSession returns the broker's session by the state received from the upstream
+func findOAuthBrokerSession(db *mgo.Database, state string) (*oauthBrokerSession, error) {
+	sess := oauthBrokerSession{}
+	err := db.C("oauth_broker_sessions").Find(bson.M{"_id": state, "code": bson.M{"$exists": false}}).One(&sess)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sess, nil
+}
+
+// oauthBrokerCallback receives the token from the upstream and redirects the user back to the client with the one-time code
+func oauthBrokerCallback(c *gin.Context, db *mgo.Database, sess *oauthBrokerSession) {
+	s, _ := serviceByName(sess.Service)
+	if s == nil || s.DefaultOAuth2 == nil {
+		c.String(http.StatusNotFound, "Service not found")
+		return
+	}
+
+	ctx := &Context{ServiceName: s.Name, db: db, gin: c}
+	l := ctx.Log().WithField("client", sess.Client)
+
+	var token *oauth2.Token
+	var err error
+	if s.DefaultOAuth2.AccessTokenReceiver != nil {
+		token = &oauth2.Token{TokenType: "Bearer"}
+		var expiresAt *time.Time
+		token.AccessToken, expiresAt, token.RefreshToken, err = s.DefaultOAuth2.AccessTokenReceiver(ctx, c.Request)
+		if expiresAt != nil {
+			token.Expiry = *expiresAt
+		}
+	} else {
+		token, err = oauthBrokerConfig(ctx).Exchange(oauth2.NoContext, c.Request.FormValue("code"))
+	}
+
+	if err != nil || token == nil || token.AccessToken == "" {
+		l.WithError(err).Error("OAuth broker can't exchange the code")
+		c.String(http.StatusForbidden, "Can't verify OAuth token")
+		return
+	}
+
+	code := strings.ToLower(rndStr.Get(32))
+	err = db.C("oauth_broker_sessions").UpdateId(sess.ID, bson.M{"$set": bson.M{"code": code, "tok": token}})
+	if err != nil {
+		l.WithError(err).Error("Can't save OAuth broker session")
+		c.String(http.StatusInternalServerError, "Error occurred")
+		return
+	}
+
+	u, err := url.Parse(sess.Redirect)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Bad redirect URL")
+		return
+	}
+
+	q := u.Query()
+	q.Set("state", sess.State)
+	q.Set("code", code)
+	u.RawQuery = q.Encode()
+
+	c.Redirect(http.StatusFound, u.String())
+}
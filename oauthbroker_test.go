@@ -0,0 +1,81 @@
+package integram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_verifyOAuthBrokerRequest(t *testing.T) {
+	defer func(clients []string) { Config.OAuthBrokerClients = clients }(Config.OAuthBrokerClients)
+	Config.OAuthBrokerClients = []string{"selfhosted:secret", "other:secret2"}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-oauthBrokerRequestMaxAge*2).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		clientID string
+		ts       string
+		sig      string
+		wantErr  bool
+	}{
+		{"valid", "selfhosted", now, oauthBrokerSign("secret", "selfhosted", "gitlab", "token", now, "code"), false},
+		{"unknown client", "unknown", now, oauthBrokerSign("secret", "unknown", "gitlab", "token", now, "code"), true},
+		{"other client's secret", "other", now, oauthBrokerSign("secret", "other", "gitlab", "token", now, "code"), true},
+		{"expired", "selfhosted", old, oauthBrokerSign("secret", "selfhosted", "gitlab", "token", old, "code"), true},
+		{"other payload", "selfhosted", now, oauthBrokerSign("secret", "selfhosted", "gitlab", "token", now, "code2"), true},
+		{"other service", "selfhosted", now, oauthBrokerSign("secret", "selfhosted", "trello", "token", now, "code"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyOAuthBrokerRequest(tt.clientID, "gitlab", oauthBrokerActionToken, tt.ts, tt.sig, "code")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyOAuthBrokerRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_oauthBrokerRequestToken(t *testing.T) {
+	defer func(url, clientID, secret string, clients []string) {
+		Config.OAuthBrokerURL, Config.OAuthBrokerClientID, Config.OAuthBrokerSecret, Config.OAuthBrokerClients = url, clientID, secret, clients
+	}(Config.OAuthBrokerURL, Config.OAuthBrokerClientID, Config.OAuthBrokerSecret, Config.OAuthBrokerClients)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauthbroker/gitlab/refresh" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := verifyOAuthBrokerRequest(r.FormValue("client"), "gitlab", oauthBrokerActionRefresh, r.FormValue("ts"), r.FormValue("sig"), r.PostFormValue("refresh_token"))
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"access_token":"new","token_type":"Bearer"}`))
+	}))
+	defer srv.Close()
+
+	Config.OAuthBrokerURL = srv.URL + "/"
+	Config.OAuthBrokerClientID = "selfhosted"
+	Config.OAuthBrokerClients = []string{"selfhosted:secret"}
+
+	Config.OAuthBrokerSecret = "secret"
+	token, err := oauthBrokerTokenSource{service: "gitlab", refreshToken: "refresh"}.Token()
+	if err != nil {
+		t.Fatalf("oauthBrokerTokenSource.Token() error = %v", err)
+	}
+
+	// the refresh token is kept if the broker didn't return the new one
+	if token.AccessToken != "new" || token.RefreshToken != "refresh" {
+		t.Errorf("oauthBrokerTokenSource.Token() = %+v", token)
+	}
+
+	Config.OAuthBrokerSecret = "wrong"
+	if _, err := oauthBrokerRequestToken("gitlab", oauthBrokerActionRefresh, "refresh"); err == nil {
+		t.Error("oauthBrokerRequestToken() with the wrong secret should return error")
+	}
+}
@@ -0,0 +1,272 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to probe the self-hosted OAuth providers
+const oauthProvidersCheckInterval = time.Hour * 24
+
+// users are notified once the provider is unreachable for this period
+const oauthProviderNotifyAfter = time.Hour * 24 * 7
+
+const oauthProviderProbeTimeout = time.Second * 15
+
+var oauthProviderProbeClient = &http.Client{Timeout: oauthProviderProbeTimeout}
+
+// oauthProviderHealth is the part of the "oauth_providers" document with the connectivity state
+type oauthProviderHealth struct {
+	ID      string `bson:"_id"`
+	Service string
+	BaseURL struct {
+		Scheme string
+		Host   string
+		Path   string
+	}
+	CheckedAt        *time.Time `bson:",omitempty"`
+	UnreachableSince *time.Time `bson:",omitempty"`
+	LastError        string     `bson:",omitempty"`
+	NotifiedAt       *time.Time `bson:",omitempty"`
+}
+
+func init() {
+	registerAdminCommand("oauthproviders", adminOAuthProviders)
+}
+
+func (p oauthProviderHealth) URL() string {
+	scheme := p.BaseURL.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + p.BaseURL.Host + p.BaseURL.Path
+}
+
+// serviceID returns the key of the users' settings and tokens for this provider
+func (p oauthProviderHealth) serviceID() string {
+	return p.Service + "_" + escapeDot(p.BaseURL.Host)
+}
+
+// probeOAuthProvider returns error if the provider's server is not reachable. Any response except 5xx means the server is alive
+func probeOAuthProvider(url string) error {
+	resp, err := oauthProviderProbeClient.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// oauthProvidersChecker periodically probes the self-hosted OAuth providers of the services running on this instance
+func oauthProvidersChecker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("oauthProvidersChecker panic recovered %v", r)
+			oauthProvidersChecker()
+		}
+	}()
+
+	if Config.IsMainInstance() {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		time.Sleep(oauthProvidersCheckInterval)
+
+		var serviceNames []string
+		serviceMapMutex.RLock()
+		for name := range services {
+			serviceNames = append(serviceNames, name)
+		}
+		serviceMapMutex.RUnlock()
+
+		var providers []oauthProviderHealth
+		err := db.C("oauth_providers").Find(bson.M{"service": bson.M{"$in": serviceNames}}).All(&providers)
+		if err != nil {
+			log.WithError(err).Error("oauthProvidersChecker: can't find the providers")
+			continue
+		}
+
+		for _, p := range providers {
+			checkOAuthProvider(db, p, probeOAuthProvider(p.URL()))
+		}
+	}
+}
+
+// checkOAuthProvider saves the probe result and notifies the provider's users if it is unreachable for oauthProviderNotifyAfter
+func checkOAuthProvider(db *mgo.Database, p oauthProviderHealth, probeErr error) {
+	now := time.Now()
+
+	if probeErr == nil {
+		err := db.C("oauth_providers").UpdateId(p.ID, bson.M{"$set": bson.M{"checkedat": now}, "$unset": bson.M{"unreachablesince": "", "lasterror": "", "notifiedat": ""}})
+		if err != nil {
+			log.WithError(err).WithField("provider", p.ID).Error("Can't save the OAuth provider check")
+		}
+		if p.UnreachableSince != nil {
+			log.WithField("provider", p.ID).Infof("OAuth provider %s is reachable again", p.BaseURL.Host)
+		}
+		return
+	}
+
+	if p.UnreachableSince == nil {
+		p.UnreachableSince = &now
+	}
+
+	err := db.C("oauth_providers").UpdateId(p.ID, bson.M{"$set": bson.M{"checkedat": now, "unreachablesince": *p.UnreachableSince, "lasterror": probeErr.Error()}})
+	if err != nil {
+		log.WithError(err).WithField("provider", p.ID).Error("Can't save the OAuth provider check")
+		return
+	}
+
+	log.WithField("provider", p.ID).WithError(probeErr).Warnf("OAuth provider %s is unreachable since %s", p.BaseURL.Host, p.UnreachableSince.Format(time.RFC3339))
+
+	if p.NotifiedAt != nil || now.Sub(*p.UnreachableSince) < oauthProviderNotifyAfter {
+		return
+	}
+
+	err = db.C("oauth_providers").Update(bson.M{"_id": p.ID, "notifiedat": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"notifiedat": now}})
+	if err != nil {
+		// already notified by the concurrent instance
+		return
+	}
+
+	notifyOAuthProviderUsers(db, p)
+}
+
+// oauthProviderUserIDs returns the users that have the settings or tokens for the provider
+func oauthProviderUserIDs(db *mgo.Database, p oauthProviderHealth) ([]int64, error) {
+	var users []struct {
+		ID int64 `bson:"_id"`
+	}
+
+	err := db.C("users").Find(bson.M{"protected." + p.serviceID(): bson.M{"$exists": true}}).Select(bson.M{"_id": 1}).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+func notifyOAuthProviderUsers(db *mgo.Database, p oauthProviderHealth) {
+	s, _ := serviceByName(p.Service)
+	if s == nil {
+		return
+	}
+
+	userIDs, err := oauthProviderUserIDs(db, p)
+	if err != nil {
+		log.WithError(err).WithField("provider", p.ID).Error("notifyOAuthProviderUsers: can't find the users")
+		return
+	}
+
+	text := fmt.Sprintf("%s server at %s is unreachable since %s. Notifications and actions for it won't work until the server is back. If it was moved, please connect the new address",
+		s.NameToPrint, p.BaseURL.Host, p.UnreachableSince.Format("2 Jan 2006"))
+
+	for _, userID := range userIDs {
+		ctx := &Context{db: db, ServiceName: s.Name}
+		ctx.Chat = Chat{ID: userID, ctx: ctx}
+
+		err := ctx.NewMessage().SetText(text).SetParseMode("").applyBrandingFooter().Send()
+		if err != nil {
+			ctx.Log().WithError(err).WithField("provider", p.ID).Error("notifyOAuthProviderUsers: can't send the message")
+		}
+	}
+
+	log.WithField("provider", p.ID).Infof("%d users notified about unreachable OAuth provider %s", len(userIDs), p.BaseURL.Host)
+}
+
+// purgeOAuthProvider removes the provider and its users' tokens and settings
+func purgeOAuthProvider(db *mgo.Database, p oauthProviderHealth) (int, error) {
+	info, err := db.C("users").UpdateAll(bson.M{"protected." + p.serviceID(): bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"protected." + p.serviceID(): "", "settings." + p.serviceID(): ""}})
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.C("oauth_providers").RemoveId(p.ID)
+	if err != nil {
+		return info.Updated, err
+	}
+
+	return info.Updated, nil
+}
+
+func (p oauthProviderHealth) String() string {
+	s := fmt.Sprintf("%s %s %s", p.ID, p.Service, p.BaseURL.Host)
+	if p.UnreachableSince != nil {
+		s += " unreachable since " + p.UnreachableSince.UTC().Format("2006-01-02 15:04")
+		if p.LastError != "" {
+			s += ": " + p.LastError
+		}
+	} else if p.CheckedAt == nil {
+		s += " not checked yet"
+	}
+	return s
+}
+
+// adminOAuthProviders: /integram oauthproviders [purge provider_id [confirm]]
+func adminOAuthProviders(c *Context, args []string) (string, error) {
+	if len(args) == 0 {
+		var providers []oauthProviderHealth
+		err := c.db.C("oauth_providers").Find(bson.M{"unreachablesince": bson.M{"$exists": true}}).Sort("unreachablesince").All(&providers)
+		if err != nil {
+			return "", err
+		}
+
+		if len(providers) == 0 {
+			return "All OAuth providers are reachable", nil
+		}
+
+		lines := []string{"Unreachable OAuth providers:"}
+		for _, p := range providers {
+			lines = append(lines, p.String())
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if args[0] != "purge" || len(args) < 2 {
+		return "", errors.New("Usage: oauthproviders [purge provider_id [confirm]]")
+	}
+
+	var p oauthProviderHealth
+	err := c.db.C("oauth_providers").FindId(args[1]).One(&p)
+	if err == mgo.ErrNotFound {
+		return "", fmt.Errorf("OAuth provider %s not found", args[1])
+	} else if err != nil {
+		return "", err
+	}
+
+	if len(args) < 3 || args[2] != "confirm" {
+		userIDs, err := oauthProviderUserIDs(c.db, p)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("%s\n%d users will lose their tokens and settings for it. Repeat with 'purge %s confirm' to proceed", p.String(), len(userIDs), p.ID), nil
+	}
+
+	n, err := purgeOAuthProvider(c.db, p)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("OAuth provider %s purged, %d users cleaned up", p.BaseURL.Host, n), nil
+}
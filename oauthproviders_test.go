@@ -0,0 +1,44 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_checkOAuthProvider(t *testing.T) {
+	p := oauthProviderHealth{ID: "testprovider", Service: "servicewithoauth2"}
+	p.BaseURL.Host = "git.example.com"
+
+	db.C("oauth_providers").Insert(bson.M{"_id": p.ID, "service": p.Service, "baseurl": p.BaseURL})
+	defer db.C("oauth_providers").RemoveId(p.ID)
+
+	checkOAuthProvider(db, p, errors.New("connection refused"))
+
+	var got oauthProviderHealth
+	db.C("oauth_providers").FindId(p.ID).One(&got)
+	if got.UnreachableSince == nil || got.LastError != "connection refused" {
+		t.Fatalf("checkOAuthProvider() with error saved %+v", got)
+	}
+
+	// unreachable since is kept while the provider is down
+	since := got.UnreachableSince.Add(-time.Hour)
+	got.UnreachableSince = &since
+	db.C("oauth_providers").UpdateId(p.ID, bson.M{"$set": bson.M{"unreachablesince": since}})
+	checkOAuthProvider(db, got, errors.New("timeout"))
+
+	db.C("oauth_providers").FindId(p.ID).One(&got)
+	if !got.UnreachableSince.Equal(since) || got.NotifiedAt != nil {
+		t.Errorf("checkOAuthProvider() changed unreachable since to %v, notified at %v", got.UnreachableSince, got.NotifiedAt)
+	}
+
+	checkOAuthProvider(db, got, nil)
+
+	got = oauthProviderHealth{}
+	db.C("oauth_providers").FindId(p.ID).One(&got)
+	if got.UnreachableSince != nil || got.LastError != "" || got.CheckedAt == nil {
+		t.Errorf("checkOAuthProvider() without error saved %+v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package integram
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// oauthRefreshTransport authorizes the requests with the user's OAuth2 token. Request answered with 401 Unauthorized is retried once with the refreshed token
+type oauthRefreshTransport struct {
+	source *OAuthTokenSource
+	base   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The original request isn't modified
+func (t *oauthRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	body, getBody, err := rewindableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(authorizedRequest(req, token, body))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	refreshed, refreshErr := t.source.refresh(token)
+	if refreshErr != nil {
+		t.source.user.ctx.Log().WithError(refreshErr).Warn("OAuth token rejected by the provider and can't be refreshed")
+		return resp, nil
+	}
+
+	body, err = getBody()
+	if err != nil {
+		return resp, nil
+	}
+
+	// retried only once, next 401 is returned to the caller
+	resp.Body.Close()
+	return t.base.RoundTrip(authorizedRequest(req, refreshed, body))
+}
+
+// rewindableBody returns the body for the first attempt, nil means the request's own one, and the func to get the body again for the retry.
+// Body without GetBody is read into the memory
+func rewindableBody(req *http.Request) (io.ReadCloser, func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, func() (io.ReadCloser, error) { return req.Body, nil }, nil
+	}
+
+	if req.GetBody != nil {
+		return nil, req.GetBody, nil
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	getBody := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	body, _ := getBody()
+	return body, getBody, nil
+}
+
+// authorizedRequest returns the copy of the request with the token's Authorization header and the body if set
+func authorizedRequest(req *http.Request, token *oauth2.Token, body io.ReadCloser) *http.Request {
+	r := new(http.Request)
+	*r = *req
+
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	if body != nil {
+		r.Body = body
+	}
+
+	token.SetAuthHeader(r)
+	return r
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package integram
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func Test_rewindableBody(t *testing.T) {
+	withGetBody, _ := http.NewRequest("POST", "https://api.example.com/cards", strings.NewReader(`{"name":"card"}`))
+	withoutGetBody, _ := http.NewRequest("POST", "https://api.example.com/cards", ioutil.NopCloser(bytes.NewBufferString(`{"name":"card"}`)))
+	withoutBody, _ := http.NewRequest("GET", "https://api.example.com/cards", nil)
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		wantFirst bool
+		want      string
+	}{
+		{"GetBody", withGetBody, false, `{"name":"card"}`},
+		{"buffered", withoutGetBody, true, `{"name":"card"}`},
+		{"no body", withoutBody, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, getBody, err := rewindableBody(tt.req)
+			if err != nil {
+				t.Fatalf("rewindableBody() error = %v", err)
+			}
+			if (first != nil) != tt.wantFirst {
+				t.Errorf("rewindableBody() first body = %v, want set %v", first, tt.wantFirst)
+			}
+
+			for i := 0; i < 2; i++ {
+				body, err := getBody()
+				if err != nil {
+					t.Fatalf("getBody() error = %v", err)
+				}
+
+				got := ""
+				if body != nil {
+					data, _ := ioutil.ReadAll(body)
+					got = string(data)
+				}
+				if got != tt.want {
+					t.Errorf("getBody() attempt %d = %q, want %q", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_authorizedRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://api.example.com/me", nil)
+	req.Header.Set("Accept", "application/json")
+
+	r := authorizedRequest(req, &oauth2.Token{AccessToken: "new", TokenType: "Bearer"}, nil)
+
+	if got := r.Header.Get("Authorization"); got != "Bearer new" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer new")
+	}
+	if r.Header.Get("Accept") != "application/json" {
+		t.Errorf("Accept header wasn't copied")
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Errorf("original request was modified")
+	}
+}
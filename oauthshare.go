@@ -0,0 +1,185 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+)
+
+// private chat command to revoke the consent given with OfferSharedOAuth
+const revokeSharedOAuthCommand = "revoke_shared_auth"
+
+// sharedOAuthUser returns the User in the context of the service which OAuth token the user allowed to use. Returns nil if sharing isn't granted
+func (user *User) sharedOAuthUser() *User {
+	ps, _ := user.protectedSettings()
+	if ps == nil || ps.OAuthSharedFrom == "" {
+		return nil
+	}
+
+	if s, _ := serviceByName(ps.OAuthSharedFrom); s == nil {
+		user.ctx.Log().WithField("from", ps.OAuthSharedFrom).Error("OAuth is shared from the service that isn't registered on this instance")
+		return nil
+	}
+
+	ctx := *user.ctx
+	ctx.ServiceName = ps.OAuthSharedFrom
+
+	shared := *user
+	shared.ctx = &ctx
+	ctx.User = shared
+
+	return &shared
+}
+
+// canShareOAuthFrom checks that the service is allowed to use the OAuth token of another one
+func (user *User) canShareOAuthFrom(from string) error {
+	s := user.ctx.Service()
+	if s == nil || s.SharedOAuthFrom == "" || s.SharedOAuthFrom != from {
+		return fmt.Errorf("%s can't use OAuth token of %s", user.ctx.ServiceName, from)
+	}
+
+	if source, _ := serviceByName(from); source == nil {
+		return fmt.Errorf("Service %s isn't registered", from)
+	}
+
+	return nil
+}
+
+// GrantOAuthSharing stores the user's consent to use the OAuth token of another service instead of the separate authorization
+func (user *User) GrantOAuthSharing(from string) error {
+	err := user.canShareOAuthFrom(from)
+	if err != nil {
+		return err
+	}
+
+	// saveProtectedSetting needs the service's protected settings to be initialized
+	if _, err := user.protectedSettings(); err != nil {
+		return err
+	}
+
+	return user.saveProtectedSetting("OAuthSharedFrom", from)
+}
+
+// RevokeOAuthSharing removes the user's consent. The user will need to authorize in the service separately
+func (user *User) RevokeOAuthSharing() error {
+	if _, err := user.protectedSettings(); err != nil {
+		return err
+	}
+
+	return user.saveProtectedSetting("OAuthSharedFrom", "")
+}
+
+// OAuthSharedFrom returns the name of the service which OAuth token is used with the user's consent or empty string
+func (user *User) OAuthSharedFrom() string {
+	ps, _ := user.protectedSettings()
+	if ps == nil {
+		return ""
+	}
+
+	return ps.OAuthSharedFrom
+}
+
+// OfferSharedOAuth asks the user in private chat to allow the service to use the OAuth token of Service.SharedOAuthFrom.
+// Returns false if there is nothing to offer: sharing isn't configured, already granted or the user isn't authorized in the source service
+func (c *Context) OfferSharedOAuth() (bool, error) {
+	s := c.Service()
+	if s.SharedOAuthFrom == "" || c.User.OAuthSharedFrom() != "" {
+		return false, nil
+	}
+
+	if err := c.User.canShareOAuthFrom(s.SharedOAuthFrom); err != nil {
+		return false, err
+	}
+
+	sourceCtx := *c
+	sourceCtx.ServiceName = s.SharedOAuthFrom
+	sourceUser := c.User
+	sourceUser.ctx = &sourceCtx
+
+	if !sourceUser.OAuthValid() {
+		return false, nil
+	}
+
+	source := sourceCtx.Service()
+
+	buttons := InlineButtons{}
+	buttons.Append("allow", fmt.Sprintf("✅ Use %s authorization", source.NameToPrint))
+	buttons.Append("deny", "Authorize separately")
+
+	err := c.NewMessage().
+		SetChat(c.User.ID).
+		SetText(fmt.Sprintf("You have already connected your %s account to @%s. Do you want to allow %s to use the same authorization?", source.NameToPrint, sourceCtx.Bot().Username, s.NameToPrint)).
+		SetInlineKeyboard(buttons.Markup(1, "")).
+		SetCallbackAction(oauthSharingConsentAction, s.SharedOAuthFrom).
+		Send()
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// oauthSharingConsentAction process the user's answer to the OfferSharedOAuth message
+func oauthSharingConsentAction(c *Context, from string) error {
+	s := c.Service()
+
+	if c.Callback.Data != "allow" {
+		c.AnswerCallbackQuery("", false)
+
+		buttons := InlineButtons{}
+		buttons.AddURL(c.User.OauthInitURL(), "Authorize")
+		return c.EditPressedMessageTextAndInlineKeyboard(fmt.Sprintf("Please authorize in %s with the button below", s.NameToPrint), buttons.Markup(1, ""))
+	}
+
+	err := c.User.GrantOAuthSharing(from)
+	if err != nil {
+		return err
+	}
+
+	if !c.User.OAuthValid() {
+		c.User.RevokeOAuthSharing()
+		return errors.New("OAuth token of the source service is not valid anymore")
+	}
+
+	c.AnswerCallbackQuery("Authorization shared", false)
+	err = c.EditPressedMessageTextAndInlineKeyboard(fmt.Sprintf("✅ %s now uses your %s authorization. You can revoke it with /%s", s.NameToPrint, from, coreCommand(revokeSharedOAuthCommand)), InlineKeyboard{})
+	if err != nil {
+		c.Log().WithError(err).Error("oauthSharingConsentAction: can't edit the message")
+	}
+
+	c.StatIncUser(StatOAuthSuccess)
+
+	if s.OAuthSuccessful != nil {
+		s.DoJob(s.OAuthSuccessful, c)
+	}
+
+	return c.User.runAfterAuthAction()
+}
+
+// handleRevokeSharedOAuthCommand process '/revoke_shared_auth' in the private chat. Returns true if message was handled
+func (c *Context) handleRevokeSharedOAuthCommand() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() {
+		return false
+	}
+
+	if cmd, _ := c.Message.GetCommand(); cmd != coreCommand(revokeSharedOAuthCommand) {
+		return false
+	}
+
+	text := "You haven't shared the authorization from another service"
+	if from := c.User.OAuthSharedFrom(); from != "" {
+		if err := c.User.RevokeOAuthSharing(); err != nil {
+			c.Log().WithError(err).Error("handleRevokeSharedOAuthCommand: can't revoke")
+			text = "Can't revoke the shared authorization. Please try again later"
+		} else {
+			text = fmt.Sprintf("%s authorization is not shared anymore", from)
+		}
+	}
+
+	err := c.NewMessage().SetText(text).Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleRevokeSharedOAuthCommand: can't send the reply")
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package integram
+
+import "testing"
+
+func TestUser_canShareOAuthFrom(t *testing.T) {
+	serviceMapMutex.Lock()
+	services["sharesource"] = &Service{Name: "sharesource"}
+	services["sharetarget"] = &Service{Name: "sharetarget", SharedOAuthFrom: "sharesource"}
+	serviceMapMutex.Unlock()
+
+	defer func() {
+		serviceMapMutex.Lock()
+		delete(services, "sharesource")
+		delete(services, "sharetarget")
+		serviceMapMutex.Unlock()
+	}()
+
+	tests := []struct {
+		name    string
+		service string
+		from    string
+		wantErr bool
+	}{
+		{"allowed", "sharetarget", "sharesource", false},
+		{"another source", "sharetarget", "sharetarget", true},
+		{"sharing not configured", "sharesource", "sharetarget", true},
+	}
+	for _, tt := range tests {
+		user := &User{ID: 1, ctx: &Context{ServiceName: tt.service}}
+		if err := user.canShareOAuthFrom(tt.from); (err != nil) != tt.wantErr {
+			t.Errorf("%q. User.canShareOAuthFrom() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUser_GrantOAuthSharing(t *testing.T) {
+	clearData()
+	defer clearData()
+
+	serviceMapMutex.Lock()
+	services["sharesource"] = &Service{Name: "sharesource"}
+	services["sharetarget"] = &Service{Name: "sharetarget", SharedOAuthFrom: "sharesource"}
+	serviceMapMutex.Unlock()
+
+	defer func() {
+		serviceMapMutex.Lock()
+		delete(services, "sharesource")
+		delete(services, "sharetarget")
+		serviceMapMutex.Unlock()
+	}()
+
+	// the user has no protected settings for the service yet
+	ctx := &Context{ServiceName: "sharetarget", db: db}
+	ctx.User = User{ID: 9999999999, FirstName: "Matthew", ctx: ctx}
+
+	if err := ctx.User.GrantOAuthSharing("sharesource"); err != nil {
+		t.Fatalf("User.GrantOAuthSharing() error = %v", err)
+	}
+
+	ctx = &Context{ServiceName: "sharetarget", db: db}
+	ctx.User = User{ID: 9999999999, ctx: ctx}
+	if got := ctx.User.OAuthSharedFrom(); got != "sharesource" {
+		t.Errorf("User.OAuthSharedFrom() = %q, want sharesource", got)
+	}
+}
@@ -0,0 +1,249 @@
+package integram
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrCantPinMessages is returned when the bot isn't allowed to pin messages in the chat
+var ErrCantPinMessages = errors.New("Bot needs the admin rights to pin messages in the chat")
+
+// ErrPinLimitReached is returned when the service has pinned the max number of messages in the chat and the policy keeps the older ones
+var ErrPinLimitReached = errors.New("Max number of pinned messages reached")
+
+// messagePin is stored in the pinned message's doc, so the digests and dashboards can reference the pinned state. Chat can have several messages pinned
+type messagePin struct {
+	Service string    `bson:"s"`
+	At      time.Time `bson:"at"`
+}
+
+// PinPolicy limits the service's pinned messages in the chat
+type PinPolicy struct {
+	Max       int  `bson:"max,omitempty"`  // max number of messages pinned by the service. Default is 1
+	KeepOlder bool `bson:"keep,omitempty"` // refuse to pin with ErrPinLimitReached instead of unpinning the oldest message
+}
+
+func (p PinPolicy) max() int {
+	if p.Max < 1 {
+		return 1
+	}
+	return p.Max
+}
+
+// pinsToUnpin returns the oldest pinned messages to unpin so the new one fits the policy. Pinned messages must be sorted from the oldest
+func pinsToUnpin(pinned []OutgoingMessage, policy PinPolicy) ([]OutgoingMessage, error) {
+	extra := len(pinned) - policy.max() + 1
+	if extra <= 0 {
+		return nil, nil
+	}
+
+	if policy.KeepOlder {
+		return nil, ErrPinLimitReached
+	}
+	return pinned[0:extra], nil
+}
+
+// canPinMessages checks that the bot has the rights to pin messages in the chat
+func (c *Context) canPinMessages(bot *Bot, chatID int64) (bool, error) {
+	if chatID > 0 {
+		// private chat
+		return true, nil
+	}
+
+	member, err := c.GetChatMember(chatID, bot.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return member.IsCreator() || member.IsAdministrator() && (member.CanPinMessages || member.CanEditMessages), nil
+}
+
+// PinMessage pins the bot's message according to the chat's PinPolicy: the oldest messages pinned by the service are unpinned to fit the limit.
+// Returns ErrCantPinMessages if the bot has no rights to pin messages in the chat
+func (c *Context) PinMessage(om *OutgoingMessage, silent bool) error {
+	if om == nil || om.MsgID == 0 {
+		return errors.New("PinMessage: message is not sent yet")
+	}
+
+	canPin, err := c.canPinMessages(c.Bot(), om.ChatID)
+	if err != nil {
+		return err
+	} else if !canPin {
+		return ErrCantPinMessages
+	}
+
+	chat := Chat{ID: om.ChatID, ctx: c}
+	policy, err := chat.PinPolicy()
+	if err != nil {
+		return err
+	}
+
+	pinned, err := chat.PinnedMessages()
+	if err != nil {
+		return err
+	}
+
+	for i, m := range pinned {
+		if m.ID == om.ID {
+			pinned = append(pinned[0:i], pinned[i+1:]...)
+			break
+		}
+	}
+
+	unpin, err := pinsToUnpin(pinned, policy)
+	if err != nil {
+		return err
+	}
+
+	err = c.PinChatMessage(om, silent)
+	if err != nil {
+		return err
+	}
+
+	for i := range unpin {
+		err := c.UnpinMessage(&unpin[i])
+		if err != nil {
+			c.Log().WithError(err).WithField("msgid", unpin[i].MsgID).Warn("PinMessage: can't unpin the older message")
+		}
+	}
+	return nil
+}
+
+// UnpinMessage unpins the bot's message pinned with PinMessage
+func (c *Context) UnpinMessage(om *OutgoingMessage) error {
+	if om == nil || om.MsgID == 0 {
+		return errors.New("UnpinMessage: message is not sent yet")
+	}
+
+	bot := c.Bot()
+	canPin, err := c.canPinMessages(bot, om.ChatID)
+	if err != nil {
+		return err
+	} else if !canPin {
+		return ErrCantPinMessages
+	}
+
+	_, err = bot.API.MakeRequest("unpinChatMessage", url.Values{"chat_id": {strconv.FormatInt(om.ChatID, 10)}, "message_id": {strconv.Itoa(om.MsgID)}})
+	if err != nil && !isTGErrorWith(err, "not found") {
+		return err
+	}
+
+	om.Pin = nil
+	err = c.db.C("messages").Update(bson.M{"chatid": om.ChatID, "botid": bot.ID, "msgid": om.MsgID}, bson.M{"$unset": bson.M{"pin": ""}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// PinPolicy returns the chat's policy for the messages pinned by the current service
+func (chat *Chat) PinPolicy() (PinPolicy, error) {
+	var data struct {
+		PinPolicy map[string]PinPolicy
+	}
+
+	err := chat.ctx.db.C("chats").FindId(chat.ID).Select(bson.M{"pinpolicy." + chat.ctx.ServiceName: 1}).One(&data)
+	if err != nil && err != mgo.ErrNotFound {
+		return PinPolicy{}, err
+	}
+
+	return data.PinPolicy[chat.ctx.ServiceName], nil
+}
+
+// SetPinPolicy saves the chat's policy for the messages pinned by the current service
+func (chat *Chat) SetPinPolicy(policy PinPolicy) error {
+	_, err := chat.ctx.db.C("chats").UpsertId(chat.ID, bson.M{"$set": bson.M{"pinpolicy." + chat.ctx.ServiceName: policy}, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	return err
+}
+
+// PinnedMessages returns the chat's messages pinned by the current service with PinMessage, from the oldest
+func (chat *Chat) PinnedMessages() ([]OutgoingMessage, error) {
+	var messages []OutgoingMessage
+	err := chat.ctx.db.C("messages").Find(bson.M{"chatid": chat.ID, "pin.s": chat.ctx.ServiceName}).Sort("pin.at").All(&messages)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range messages {
+		messages[i].ctx = chat.ctx
+	}
+	return messages, nil
+}
+
+// PinChatMessage pins the bot's message in the chat, e.g. the "current sprint" message. Bot must be the chat admin with the rights to pin messages
+func (c *Context) PinChatMessage(om *OutgoingMessage, disableNotification bool) error {
+	if om == nil || om.MsgID == 0 {
+		return errors.New("PinChatMessage: message is not sent yet")
+	}
+
+	bot := c.Bot()
+	_, err := bot.API.Send(tg.PinChatMessageConfig{ChatID: om.ChatID, MessageID: om.MsgID, DisableNotification: disableNotification})
+	if err != nil {
+		return err
+	}
+
+	om.Pin = &messagePin{Service: c.ServiceName, At: time.Now()}
+	err = c.db.C("messages").Update(bson.M{"chatid": om.ChatID, "botid": bot.ID, "msgid": om.MsgID}, bson.M{"$set": bson.M{"pin": om.Pin}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// UnpinChatMessage unpins the chat's most recently pinned message
+func (c *Context) UnpinChatMessage(chatID int64) error {
+	bot := c.Bot()
+	_, err := bot.API.Send(tg.UnpinChatMessageConfig{ChatID: chatID})
+	if err != nil {
+		return err
+	}
+
+	last, err := lastPinnedMessage(c.db, bson.M{"chatid": chatID, "botid": bot.ID, "pin": bson.M{"$exists": true}})
+	if last == nil || err != nil {
+		return err
+	}
+
+	err = c.db.C("messages").UpdateId(last.ID, bson.M{"$unset": bson.M{"pin": ""}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// PinnedMessage returns the chat's message pinned by the current service most recently or nil if there is none
+func (chat *Chat) PinnedMessage() (*OutgoingMessage, error) {
+	om, err := lastPinnedMessage(chat.ctx.db, bson.M{"chatid": chat.ID, "pin.s": chat.ctx.ServiceName})
+	if om != nil {
+		om.ctx = chat.ctx
+	}
+	return om, err
+}
+
+// lastPinnedMessage returns the most recently pinned message matching the query or nil
+func lastPinnedMessage(db *mgo.Database, query bson.M) (*OutgoingMessage, error) {
+	var om OutgoingMessage
+	err := db.C("messages").Find(query).Sort("-pin.at").One(&om)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &om, nil
+}
+
+// syncChatPin stores the pin of the bot's message made outside of PinChatMessage, e.g. by the chat admin. Pins of the other messages are kept, as the chat can have several
+func syncChatPin(db *mgo.Database, botID int64, serviceName string, chatID int64, msgID int) error {
+	err := db.C("messages").Update(
+		bson.M{"chatid": chatID, "botid": botID, "msgid": msgID, "pin": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pin": messagePin{Service: serviceName, At: time.Now()}}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
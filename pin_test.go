@@ -0,0 +1,136 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestChat_PinnedMessage(t *testing.T) {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	ctx.Chat = Chat{ID: -100700, ctx: ctx}
+	defer db.C("messages").RemoveAll(bson.M{"chatid": ctx.Chat.ID})
+
+	now := time.Now()
+	var ids []bson.ObjectId
+	for i, pin := range []*messagePin{{Service: ctx.ServiceName, At: now.Add(-time.Hour)}, nil} {
+		om := OutgoingMessage{Pin: pin}
+		om.ID = bson.NewObjectId()
+		om.BotID = 100
+		om.ChatID = ctx.Chat.ID
+		om.MsgID = 42 + i
+		db.C("messages").Insert(om)
+		ids = append(ids, om.ID)
+	}
+
+	pinned, err := ctx.Chat.PinnedMessage()
+	if err != nil || pinned == nil || pinned.ID != ids[0] {
+		t.Fatalf("PinnedMessage() = %v, %v, want %s", pinned, err, ids[0])
+	}
+
+	other := &Context{db: db, ServiceName: "other"}
+	other.Chat = Chat{ID: ctx.Chat.ID, ctx: other}
+	if pinned, _ := other.Chat.PinnedMessage(); pinned != nil {
+		t.Errorf("PinnedMessage() for the other service = %v, want nil", pinned.ID)
+	}
+
+	tests := []struct {
+		name   string
+		msgID  int
+		wantID bson.ObjectId
+	}{
+		{"same message pinned again", 42, ids[0]},
+		{"bot's message pinned by the admin", 43, ids[1]},
+		{"unknown message pinned", 44, ids[1]},
+	}
+	for _, tt := range tests {
+		err := syncChatPin(db, 100, ctx.ServiceName, ctx.Chat.ID, tt.msgID)
+		if err != nil {
+			t.Errorf("%q. syncChatPin() error = %v", tt.name, err)
+			continue
+		}
+
+		pinned, _ := ctx.Chat.PinnedMessage()
+		if pinned == nil || pinned.ID != tt.wantID {
+			t.Errorf("%q. PinnedMessage() after syncChatPin() = %v, want %v", tt.name, pinned, tt.wantID)
+		}
+	}
+
+	// the earlier pin is kept, as the chat can have several pinned messages
+	if pinned, _ := ctx.Chat.PinnedMessages(); len(pinned) != 2 {
+		t.Errorf("PinnedMessages() = %v, want both messages", pinned)
+	}
+}
+
+func Test_pinsToUnpin(t *testing.T) {
+	pinned := []OutgoingMessage{{}, {}, {}}
+	for i := range pinned {
+		pinned[i].MsgID = i + 1
+	}
+
+	tests := []struct {
+		name    string
+		pinned  []OutgoingMessage
+		policy  PinPolicy
+		want    []int
+		wantErr error
+	}{
+		{"nothing pinned", nil, PinPolicy{}, nil, nil},
+		{"default replaces the pinned", pinned[0:1], PinPolicy{}, []int{1}, nil},
+		{"fits the limit", pinned[0:2], PinPolicy{Max: 3}, nil, nil},
+		{"oldest unpinned", pinned, PinPolicy{Max: 2}, []int{1, 2}, nil},
+		{"keep older", pinned, PinPolicy{Max: 3, KeepOlder: true}, nil, ErrPinLimitReached},
+	}
+	for _, tt := range tests {
+		got, err := pinsToUnpin(tt.pinned, tt.policy)
+		if err != tt.wantErr {
+			t.Errorf("%q. pinsToUnpin() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		var ids []int
+		for _, m := range got {
+			ids = append(ids, m.MsgID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%q. pinsToUnpin() = %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+}
+
+func TestChat_PinnedMessages(t *testing.T) {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	ctx.Chat = Chat{ID: -100701, ctx: ctx}
+	defer db.C("messages").RemoveAll(bson.M{"chatid": ctx.Chat.ID})
+	defer db.C("chats").RemoveId(ctx.Chat.ID)
+
+	now := time.Now()
+	for i, pin := range []*messagePin{{Service: ctx.ServiceName, At: now}, nil, {Service: "other", At: now}, {Service: ctx.ServiceName, At: now.Add(-time.Hour)}} {
+		om := OutgoingMessage{Pin: pin}
+		om.ID = bson.NewObjectId()
+		om.BotID = 100
+		om.ChatID = ctx.Chat.ID
+		om.MsgID = i + 1
+		db.C("messages").Insert(om)
+	}
+
+	pinned, err := ctx.Chat.PinnedMessages()
+	if err != nil || len(pinned) != 2 || pinned[0].MsgID != 4 || pinned[1].MsgID != 1 {
+		t.Errorf("PinnedMessages() = %v, %v, want messages 4 and 1", pinned, err)
+	}
+
+	if policy, err := ctx.Chat.PinPolicy(); err != nil || policy != (PinPolicy{}) {
+		t.Errorf("PinPolicy() = %v, %v, want the default", policy, err)
+	}
+
+	want := PinPolicy{Max: 3, KeepOlder: true}
+	if err := ctx.Chat.SetPinPolicy(want); err != nil {
+		t.Fatalf("SetPinPolicy() error = %v", err)
+	}
+
+	if policy, err := ctx.Chat.PinPolicy(); err != nil || policy != want {
+		t.Errorf("PinPolicy() = %v, %v, want %v", policy, err, want)
+	}
+}
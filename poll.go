@@ -0,0 +1,290 @@
+package integram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Poll types
+const (
+	PollTypeRegular = "regular"
+	PollTypeQuiz    = "quiz"
+)
+
+// OutgoingPoll is the poll to send with OutgoingMessage.SetPoll or SetQuiz
+type OutgoingPoll struct {
+	Question              string
+	Options               []string
+	Type                  string
+	CorrectOptionID       int  `bson:",omitempty"` // only for quiz
+	AllowsMultipleAnswers bool `bson:",omitempty"`
+	Anonymous             bool `bson:",omitempty"` // answers of the anonymous polls are not received
+}
+
+// Poll is the poll sent by the bot and stored in the "polls" collection with the received answers
+type Poll struct {
+	ID                    string `bson:"_id"` // Telegram's poll_id
+	BotID                 int64
+	ChatID                int64
+	MsgID                 int
+	Question              string
+	Options               []string
+	Type                  string
+	CorrectOptionID       int
+	AllowsMultipleAnswers bool
+	Answers               map[string][]int `bson:",omitempty"` // chosen options per user ID
+	CreatedAt             time.Time
+	ClosedAt              *time.Time `bson:",omitempty"`
+}
+
+// PollAnswer is the user's answer in the non-anonymous poll sent by the bot. Empty OptionIDs means the vote was retracted
+type PollAnswer struct {
+	PollID    string
+	OptionIDs []int
+	Poll      *Poll // stored poll including this answer
+}
+
+// tgPollAnswer is poll_answer of the update, which Bot API client doesn't decode
+type tgPollAnswer struct {
+	PollID    string   `json:"poll_id"`
+	User      *tg.User `json:"user"`
+	OptionIDs []int    `json:"option_ids"`
+}
+
+// SetPoll makes the message the regular poll with the options
+func (m *OutgoingMessage) SetPoll(question string, options ...string) *OutgoingMessage {
+	m.Poll = &OutgoingPoll{Question: question, Options: options, Type: PollTypeRegular}
+	return m
+}
+
+// SetQuiz makes the message the quiz with the single correct option
+func (m *OutgoingMessage) SetQuiz(question string, correctOptionID int, options ...string) *OutgoingMessage {
+	m.Poll = &OutgoingPoll{Question: question, Options: options, Type: PollTypeQuiz, CorrectOptionID: correctOptionID}
+	return m
+}
+
+// validate returns the error if Telegram will reject the poll
+func (p *OutgoingPoll) validate() error {
+	if p.Question == "" {
+		return errors.New("Poll question is empty")
+	}
+
+	if len(p.Options) < 2 || len(p.Options) > 10 {
+		return fmt.Errorf("Poll must have 2-10 options, %d provided", len(p.Options))
+	}
+
+	if p.Type == PollTypeQuiz {
+		if p.AllowsMultipleAnswers {
+			return errors.New("Quiz can't allow multiple answers")
+		}
+
+		if p.CorrectOptionID < 0 || p.CorrectOptionID >= len(p.Options) {
+			return fmt.Errorf("Quiz's correct option %d is out of range", p.CorrectOptionID)
+		}
+	}
+	return nil
+}
+
+// sendPoll sends the poll with the sendPoll request, which Bot API client doesn't support, and stores it to receive the answers
+func (m *OutgoingMessage) sendPoll(db *mgo.Database, bot *Bot, msg tg.MessageConfig) (tg.Message, error) {
+	p := m.Poll
+	err := p.validate()
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	options, err := json.Marshal(p.Options)
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	v := url.Values{}
+	v.Set("chat_id", strconv.FormatInt(m.ChatID, 10))
+	v.Set("question", p.Question)
+	v.Set("options", string(options))
+	v.Set("type", p.Type)
+	v.Set("is_anonymous", strconv.FormatBool(p.Anonymous))
+	v.Set("allows_multiple_answers", strconv.FormatBool(p.AllowsMultipleAnswers))
+	v.Set("disable_notification", strconv.FormatBool(msg.DisableNotification))
+
+	if p.Type == PollTypeQuiz {
+		v.Set("correct_option_id", strconv.Itoa(p.CorrectOptionID))
+	}
+
+	if m.ReplyToMsgID != 0 {
+		v.Set("reply_to_message_id", strconv.Itoa(m.ReplyToMsgID))
+	}
+
+	if len(m.InlineKeyboardMarkup.Buttons) > 0 {
+		data, err := json.Marshal(tg.InlineKeyboardMarkup{InlineKeyboard: m.InlineKeyboardMarkup.tg()})
+		if err != nil {
+			return tg.Message{}, err
+		}
+		v.Set("reply_markup", string(data))
+	}
+
+	resp, err := bot.API.MakeRequest("sendPoll", v)
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	var tgMsg tg.Message
+	err = json.Unmarshal(resp.Result, &tgMsg)
+	if err != nil {
+		return tgMsg, err
+	}
+
+	var sent struct {
+		Poll struct {
+			ID string `json:"id"`
+		} `json:"poll"`
+	}
+	json.Unmarshal(resp.Result, &sent)
+
+	if sent.Poll.ID == "" {
+		log.WithField("chat", m.ChatID).Error("sendPoll: poll_id is missing in the response")
+		return tgMsg, nil
+	}
+
+	err = db.C("polls").Insert(Poll{
+		ID:                    sent.Poll.ID,
+		BotID:                 bot.ID,
+		ChatID:                m.ChatID,
+		MsgID:                 tgMsg.MessageID,
+		Question:              p.Question,
+		Options:               p.Options,
+		Type:                  p.Type,
+		CorrectOptionID:       p.CorrectOptionID,
+		AllowsMultipleAnswers: p.AllowsMultipleAnswers,
+		CreatedAt:             time.Now(),
+	})
+	if err != nil {
+		log.WithError(err).WithField("chat", m.ChatID).Error("sendPoll: can't save the poll")
+	}
+	return tgMsg, nil
+}
+
+// Votes returns the number of votes per option
+func (p *Poll) Votes() []int {
+	votes := make([]int, len(p.Options))
+	for _, optionIDs := range p.Answers {
+		for _, id := range optionIDs {
+			if id >= 0 && id < len(votes) {
+				votes[id]++
+			}
+		}
+	}
+	return votes
+}
+
+// Correct returns true if the answer is the correct option of the quiz
+func (a *PollAnswer) Correct() bool {
+	return a.Poll != nil && a.Poll.Type == PollTypeQuiz && len(a.OptionIDs) == 1 && a.OptionIDs[0] == a.Poll.CorrectOptionID
+}
+
+// FindPoll returns the poll sent by the bot in the message
+func (c *Context) FindPoll(om *OutgoingMessage) (*Poll, error) {
+	var poll Poll
+	err := c.db.C("polls").Find(bson.M{"chatid": om.ChatID, "msgid": om.MsgID, "botid": om.BotID}).One(&poll)
+	if err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// StopPoll closes the poll, so it doesn't accept the answers anymore
+func (c *Context) StopPoll(poll *Poll) error {
+	v := url.Values{}
+	v.Set("chat_id", strconv.FormatInt(poll.ChatID, 10))
+	v.Set("message_id", strconv.Itoa(poll.MsgID))
+
+	_, err := c.Bot().API.MakeRequest("stopPoll", v)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	poll.ClosedAt = &now
+	return c.db.C("polls").UpdateId(poll.ID, bson.M{"$set": bson.M{"closedat": now}})
+}
+
+// extractPollAnswers returns the poll answers in the getUpdates result
+func extractPollAnswers(result []byte) []tgPollAnswer {
+	var updates []struct {
+		PollAnswer *tgPollAnswer `json:"poll_answer"`
+	}
+
+	if json.Unmarshal(result, &updates) != nil {
+		return nil
+	}
+
+	var answers []tgPollAnswer
+	for _, u := range updates {
+		if u.PollAnswer != nil && u.PollAnswer.User != nil {
+			answers = append(answers, *u.PollAnswer)
+		}
+	}
+	return answers
+}
+
+// pollAnswerRoutine stores the answer and passes it to the service's PollAnswerHandler
+func pollAnswerRoutine(botID int64, answer tgPollAnswer) {
+	if !Config.Debug {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := stack(3)
+				log.Errorf("Panic recovery at pollAnswerRoutine -> %s\n%s\n", r, stack)
+			}
+		}()
+	}
+
+	service, err := detectServiceByBot(botID)
+	if err != nil {
+		log.WithError(err).WithField("bot", botID).Error("Can't detect service")
+		return
+	}
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	key := "answers." + strconv.FormatInt(answer.User.ID, 10)
+	update := bson.M{"$set": bson.M{key: answer.OptionIDs}}
+	if len(answer.OptionIDs) == 0 {
+		update = bson.M{"$unset": bson.M{key: ""}}
+	}
+
+	var poll Poll
+	_, err = db.C("polls").Find(bson.M{"_id": answer.PollID, "botid": botID}).Apply(mgo.Change{Update: update, ReturnNew: true}, &poll)
+	if err == mgo.ErrNotFound {
+		log.WithField("bot", botID).WithField("poll", answer.PollID).Debug("Answer received for the unknown poll")
+		return
+	} else if err != nil {
+		log.WithError(err).WithField("poll", answer.PollID).Error("Can't save the poll answer")
+		return
+	}
+
+	ctx := &Context{ServiceName: service.Name, User: tgUser(answer.User), Chat: Chat{ID: poll.ChatID}, db: db}
+	ctx.PollAnswer = &PollAnswer{PollID: answer.PollID, OptionIDs: answer.OptionIDs, Poll: &poll}
+	ctx.User.ctx = ctx
+	ctx.Chat.ctx = ctx
+
+	ctx.StatIncUser(StatPollAnswer)
+
+	if service.PollAnswerHandler == nil {
+		return
+	}
+
+	err = ctx.runHandler("poll answer", service.PollAnswerHandler, nil)
+	if err != nil {
+		ctx.Log().WithError(err).Error("PollAnswerHandler error")
+	}
+}
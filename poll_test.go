@@ -0,0 +1,75 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutgoingPoll_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		poll    OutgoingPoll
+		wantErr bool
+	}{
+		{"regular", OutgoingPoll{Question: "Deploy?", Options: []string{"yes", "no"}, Type: PollTypeRegular}, false},
+		{"empty question", OutgoingPoll{Options: []string{"yes", "no"}, Type: PollTypeRegular}, true},
+		{"single option", OutgoingPoll{Question: "Deploy?", Options: []string{"yes"}, Type: PollTypeRegular}, true},
+		{"quiz", OutgoingPoll{Question: "2+2", Options: []string{"3", "4"}, Type: PollTypeQuiz, CorrectOptionID: 1}, false},
+		{"quiz correct option out of range", OutgoingPoll{Question: "2+2", Options: []string{"3", "4"}, Type: PollTypeQuiz, CorrectOptionID: 2}, true},
+		{"quiz multiple answers", OutgoingPoll{Question: "2+2", Options: []string{"3", "4"}, Type: PollTypeQuiz, AllowsMultipleAnswers: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.poll.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("OutgoingPoll.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPoll_Votes(t *testing.T) {
+	p := Poll{Options: []string{"a", "b", "c"}, Answers: map[string][]int{"1": {0}, "2": {0, 2}, "3": {5}}}
+	if got := p.Votes(); !reflect.DeepEqual(got, []int{2, 0, 1}) {
+		t.Errorf("Poll.Votes() = %v, want %v", got, []int{2, 0, 1})
+	}
+}
+
+func TestPollAnswer_Correct(t *testing.T) {
+	quiz := &Poll{Type: PollTypeQuiz, CorrectOptionID: 1}
+	tests := []struct {
+		name   string
+		answer PollAnswer
+		want   bool
+	}{
+		{"correct", PollAnswer{OptionIDs: []int{1}, Poll: quiz}, true},
+		{"wrong", PollAnswer{OptionIDs: []int{0}, Poll: quiz}, false},
+		{"retracted", PollAnswer{Poll: quiz}, false},
+		{"regular poll", PollAnswer{OptionIDs: []int{0}, Poll: &Poll{Type: PollTypeRegular}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.answer.Correct(); got != tt.want {
+				t.Errorf("PollAnswer.Correct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_extractPollAnswers(t *testing.T) {
+	result := []byte(`[{"update_id":1,"message":{"message_id":5,"chat":{"id":7,"type":"private"},"text":"hi"}},` +
+		`{"update_id":2,"poll_answer":{"poll_id":"5432","user":{"id":7,"first_name":"Ann"},"option_ids":[1]}},` +
+		`{"update_id":3,"poll_answer":{"poll_id":"5432","user":{"id":8,"first_name":"Bob"},"option_ids":[]}}]`)
+
+	answers := extractPollAnswers(result)
+	if len(answers) != 2 {
+		t.Fatalf("extractPollAnswers() returned %d answers, want 2", len(answers))
+	}
+
+	if answers[0].PollID != "5432" || answers[0].User.ID != 7 || !reflect.DeepEqual(answers[0].OptionIDs, []int{1}) {
+		t.Errorf("extractPollAnswers()[0] = %+v", answers[0])
+	}
+
+	if answers[1].User.ID != 8 || len(answers[1].OptionIDs) != 0 {
+		t.Errorf("extractPollAnswers()[1] = %+v", answers[1])
+	}
+}
@@ -0,0 +1,305 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// default interval between the polls of the same target
+const defaultPollInterval = time.Minute * 10
+
+// interval to check the due poll targets
+const pollCheckInterval = time.Second * 10
+
+// poll target is claimed by the instance for this period, so it is polled again after the crash
+const pollLockTTL = time.Minute * 5
+
+// interval is doubled after each failed poll up to this value
+const pollMaxBackoff = time.Hour * 6
+
+// number of the last entries' IDs remembered per target to skip the already sent ones
+const pollSeenMax = 500
+
+// targets are the user-provided URLs, so the internal addresses are refused
+var pollHTTPClient = publicHTTPClient(time.Second * 30)
+
+// ErrPollNotModified is returned by Poller.Poll or PollTarget.Get when the target wasn't changed since the last poll
+var ErrPollNotModified = errors.New("Poll target is not modified")
+
+// Poller polls the targets the chats are subscribed to with Context.Subscribe, e.g. the feeds. New entries are sent to the subscribed chats
+type Poller struct {
+	// Interval between the polls of the same target. Default to 10 minutes
+	Interval time.Duration
+
+	// Fetches the target's entries, e.g. with PollTarget.Get to skip the unchanged target. Entries with the already seen IDs are skipped, the rest are sent in the returned order.
+	// Entries of the first poll are only marked as seen, so the new subscriber isn't flooded with the old ones
+	Poll func(ctx *Context, target *PollTarget) ([]PollEntry, error)
+}
+
+// PollEntry is the entry of the poll target, e.g. the feed's item
+type PollEntry struct {
+	ID     string     // unique within the target, e.g. the item's guid
+	Render RenderFunc // renders the entry's message for the subscribed chat
+}
+
+// PollTarget is the resource polled by Service.Poller for the subscribed chats
+type PollTarget struct {
+	ID           bson.ObjectId `bson:"_id"`
+	Service      string        `bson:"s"`
+	Key          string        `bson:"k"` // e.g. the feed's URL
+	Chats        []int64       `bson:"c"`
+	ETag         string        `bson:"etag,omitempty"`
+	LastModified string        `bson:"lm,omitempty"`
+	Seen         []string      `bson:"seen,omitempty"`
+	PolledAt     *time.Time    `bson:"p,omitempty"` // last successful poll
+	NextPollAt   time.Time     `bson:"n"`
+	LockedUntil  time.Time     `bson:"l,omitempty"` // set when the target is claimed for the poll
+	Failures     int           `bson:"f,omitempty"` // number of the failed polls in a row
+	LastError    string        `bson:"e,omitempty"`
+}
+
+// Get requests the URL with the target's validators from the last poll. Returns ErrPollNotModified if upstream answers 304 Not Modified.
+// Validators of the successful response are saved when the poll is finished without error
+func (t *PollTarget) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ETag != "" {
+		req.Header.Set("If-None-Match", t.ETag)
+	}
+	if t.LastModified != "" {
+		req.Header.Set("If-Modified-Since", t.LastModified)
+	}
+
+	resp, err := pollHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, ErrPollNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+
+	t.ETag = resp.Header.Get("ETag")
+	t.LastModified = resp.Header.Get("Last-Modified")
+	return resp, nil
+}
+
+// Subscribe subscribes the current chat to the service's poll target, e.g. the feed's URL. Returns false if the chat is already subscribed
+func (c *Context) Subscribe(key string) (bool, error) {
+	if c.Chat.ID == 0 {
+		return false, errors.New("Chat is empty")
+	}
+
+	n, err := c.db.C("poll_targets").Find(bson.M{"s": c.ServiceName, "k": key, "c": c.Chat.ID}).Count()
+	if err != nil {
+		return false, err
+	} else if n > 0 {
+		return false, nil
+	}
+
+	_, err = c.db.C("poll_targets").Upsert(bson.M{"s": c.ServiceName, "k": key}, bson.M{
+		"$addToSet":    bson.M{"c": c.Chat.ID},
+		"$setOnInsert": bson.M{"n": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unsubscribe unsubscribes the current chat from the service's poll target. Target without subscribers is removed. Returns false if the chat wasn't subscribed
+func (c *Context) Unsubscribe(key string) (bool, error) {
+	err := c.db.C("poll_targets").Update(bson.M{"s": c.ServiceName, "k": key, "c": c.Chat.ID}, bson.M{"$pull": bson.M{"c": c.Chat.ID}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	_, err = c.db.C("poll_targets").RemoveAll(bson.M{"s": c.ServiceName, "k": key, "c": bson.M{"$size": 0}})
+	if err != nil {
+		c.Log().WithError(err).WithField("key", key).Error("Unsubscribe: can't remove the target")
+	}
+	return true, nil
+}
+
+// Subscriptions returns the keys of the service's poll targets the current chat is subscribed to
+func (c *Context) Subscriptions() ([]string, error) {
+	var targets []PollTarget
+	err := c.db.C("poll_targets").Find(bson.M{"s": c.ServiceName, "c": c.Chat.ID}).Select(bson.M{"k": 1}).Sort("k").All(&targets)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(targets))
+	for _, t := range targets {
+		keys = append(keys, t.Key)
+	}
+	return keys, nil
+}
+
+// unseenPollEntries returns the entries which IDs are not seen
+func unseenPollEntries(entries []PollEntry, seen []string) []PollEntry {
+	seenMap := make(map[string]struct{}, len(seen))
+	for _, id := range seen {
+		seenMap[id] = struct{}{}
+	}
+
+	var unseen []PollEntry
+	for _, entry := range entries {
+		if _, exists := seenMap[entry.ID]; exists {
+			continue
+		}
+		seenMap[entry.ID] = struct{}{}
+		unseen = append(unseen, entry)
+	}
+	return unseen
+}
+
+// appendPollSeen appends the IDs of the entries to seen and keeps the last pollSeenMax of them. Limit is raised for the target with more entries,
+// otherwise its oldest entries would be sent again
+func appendPollSeen(seen []string, entries []PollEntry, total int) []string {
+	for _, entry := range entries {
+		seen = append(seen, entry.ID)
+	}
+
+	max := pollSeenMax
+	if total*2 > max {
+		max = total * 2
+	}
+
+	if len(seen) > max {
+		seen = seen[len(seen)-max:]
+	}
+	return seen
+}
+
+// pollBackoff returns the delay before the next poll after the number of failures in a row. Interval longer than pollMaxBackoff isn't increased
+func pollBackoff(interval time.Duration, failures int) time.Duration {
+	if interval >= pollMaxBackoff {
+		return interval
+	}
+
+	for i := 0; i < failures && interval < pollMaxBackoff; i++ {
+		interval *= 2
+	}
+
+	if interval > pollMaxBackoff {
+		return pollMaxBackoff
+	}
+	return interval
+}
+
+// claimPollTarget locks the service's due target for the poll. Returns mgo.ErrNotFound if there are no due targets
+func claimPollTarget(db *mgo.Database, service string, now time.Time) (*PollTarget, error) {
+	var t PollTarget
+	_, err := db.C("poll_targets").Find(bson.M{
+		"s": service,
+		"n": bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"l": bson.M{"$exists": false}},
+			{"l": bson.M{"$lt": now}},
+		},
+	}).Sort("n").Apply(mgo.Change{Update: bson.M{"$set": bson.M{"l": now.Add(pollLockTTL)}}, ReturnNew: true}, &t)
+
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// pollTarget polls the claimed target, sends the new entries to the subscribed chats and schedules the next poll
+func (s *Service) pollTarget(db *mgo.Database, t *PollTarget) {
+	c := &Context{db: db, ServiceName: s.Name}
+
+	interval := s.Poller.Interval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+
+	entries, err := s.Poller.Poll(c, t)
+	now := time.Now()
+
+	if err != nil && err != ErrPollNotModified {
+		t.Failures++
+		c.Log().WithError(err).WithField("key", t.Key).WithField("failures", t.Failures).Warn("Poll failed")
+
+		err = db.C("poll_targets").UpdateId(t.ID, bson.M{
+			"$set":   bson.M{"n": now.Add(pollBackoff(interval, t.Failures)), "f": t.Failures, "e": err.Error()},
+			"$unset": bson.M{"l": ""},
+		})
+		if err != nil {
+			c.Log().WithError(err).Error("pollTarget: can't save the failure")
+		}
+		return
+	}
+
+	unseen := unseenPollEntries(entries, t.Seen)
+
+	// first poll only remembers the entries
+	if t.PolledAt != nil {
+		for _, entry := range unseen {
+			_, sendErr := c.SendToChats(t.Chats, entry.Render)
+			if sendErr != nil {
+				c.Log().WithError(sendErr).WithField("key", t.Key).WithField("entry", entry.ID).Error("pollTarget: can't send the entry")
+			}
+		}
+	}
+
+	set := bson.M{"p": now, "n": now.Add(interval), "f": 0}
+	if err != ErrPollNotModified {
+		set["seen"] = appendPollSeen(t.Seen, unseen, len(entries))
+		set["etag"] = t.ETag
+		set["lm"] = t.LastModified
+	}
+
+	err = db.C("poll_targets").UpdateId(t.ID, bson.M{"$set": set, "$unset": bson.M{"l": "", "e": ""}})
+	if err != nil {
+		c.Log().WithError(err).Error("pollTarget: can't save the target")
+	}
+}
+
+// servicePoller polls the service's due targets with Service.Poller
+func servicePoller(s *Service) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("service", s.Name).Errorf("servicePoller panic recovered %v", r)
+			servicePoller(s)
+		}
+	}()
+
+	session := mongoSession.Clone()
+	defer session.Close()
+	db := session.DB(mongo.Database)
+
+	for {
+		for {
+			t, err := claimPollTarget(db, s.Name, time.Now())
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				s.Log().WithError(err).Error("servicePoller: can't get the due targets")
+				break
+			}
+
+			s.pollTarget(db, t)
+		}
+
+		time.Sleep(pollCheckInterval)
+	}
+}
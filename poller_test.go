@@ -0,0 +1,100 @@
+package integram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_unseenPollEntries(t *testing.T) {
+	entries := []PollEntry{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "3"}}
+
+	tests := []struct {
+		name string
+		seen []string
+		want []string
+	}{
+		{"nothing seen", nil, []string{"1", "2", "3"}},
+		{"some seen", []string{"0", "1"}, []string{"2", "3"}},
+		{"all seen", []string{"3", "2", "1"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, entry := range unseenPollEntries(entries, tt.seen) {
+				got = append(got, entry.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unseenPollEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendPollSeen(t *testing.T) {
+	seen := make([]string, pollSeenMax)
+
+	got := appendPollSeen(seen, []PollEntry{{ID: "new"}}, 10)
+	if len(got) != pollSeenMax || got[len(got)-1] != "new" {
+		t.Errorf("appendPollSeen() kept %d IDs ending with %q, want %d ending with \"new\"", len(got), got[len(got)-1], pollSeenMax)
+	}
+
+	got = appendPollSeen(seen, []PollEntry{{ID: "new"}}, pollSeenMax)
+	if len(got) != pollSeenMax+1 {
+		t.Errorf("appendPollSeen() for the large target kept %d IDs, want %d", len(got), pollSeenMax+1)
+	}
+}
+
+func Test_pollBackoff(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Minute * 10, 0, time.Minute * 10},
+		{time.Minute * 10, 1, time.Minute * 20},
+		{time.Minute * 10, 3, time.Minute * 80},
+		{time.Minute * 10, 100, pollMaxBackoff},
+		{time.Hour * 12, 1, time.Hour * 12},
+	}
+	for _, tt := range tests {
+		if got := pollBackoff(tt.interval, tt.failures); got != tt.want {
+			t.Errorf("pollBackoff(%v, %d) = %v, want %v", tt.interval, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestPollTarget_Get(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+	}))
+	defer ts.Close()
+
+	target := &PollTarget{}
+	if _, err := target.Get(ts.URL); err == nil {
+		t.Fatalf("PollTarget.Get() must refuse the loopback address")
+	}
+
+	prev := pollHTTPClient
+	defer func() { pollHTTPClient = prev }()
+	pollHTTPClient = ts.Client()
+
+	resp, err := target.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("PollTarget.Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if target.ETag != `"v1"` {
+		t.Errorf("PollTarget.ETag = %q, want %q", target.ETag, `"v1"`)
+	}
+	if _, err := target.Get(ts.URL); err != ErrPollNotModified {
+		t.Errorf("PollTarget.Get() error = %v, want ErrPollNotModified", err)
+	}
+}
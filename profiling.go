@@ -0,0 +1,229 @@
+package integram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// default and max duration of the CPU profile dumped via /admin/profile
+const (
+	profileCPUDefaultSeconds = 30
+	profileCPUMaxSeconds     = 120
+)
+
+// allocations are measured for every Nth handler call, because runtime.ReadMemStats stops the world
+const profileAllocSampleRate = 100
+
+type profileKey struct {
+	Service string
+	Update  string
+}
+
+// profileStat is the aggregated stats of the handlers for the service and update type
+type profileStat struct {
+	profileKey
+	Count      int64
+	Total      time.Duration
+	Max        time.Duration
+	AllocBytes uint64 // approximate, because it also includes allocations of the concurrent handlers
+	AllocCalls int64  // number of calls the allocations were measured for
+}
+
+var profileStatsMutex = sync.Mutex{}
+var profileStats = make(map[profileKey]*profileStat)
+var profileCalls uint64
+
+// used to prevent the concurrent CPU profiles
+var profileCPUMutex = sync.Mutex{}
+
+func init() {
+	registerAdminCommand("profile", adminProfileReport)
+}
+
+// startProfiling labels the current goroutine with the service and update type so the CPU profile samples can be filtered by them.
+// Returns the func that must be called after the handler finished to record its stats
+func startProfiling(service string, update string) func() {
+	if !Config.Profiling {
+		return func() {}
+	}
+
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("service", service, "update", update)))
+
+	sampled := atomic.AddUint64(&profileCalls, 1)%profileAllocSampleRate == 0
+
+	var ms runtime.MemStats
+	if sampled {
+		runtime.ReadMemStats(&ms)
+	}
+	allocBefore := ms.TotalAlloc
+	startedAt := time.Now()
+
+	return func() {
+		duration := time.Since(startedAt)
+		if sampled {
+			runtime.ReadMemStats(&ms)
+		}
+		recordProfileStat(profileKey{service, update}, duration, sampled, ms.TotalAlloc-allocBefore)
+
+		pprof.SetGoroutineLabels(context.Background())
+	}
+}
+
+func recordProfileStat(key profileKey, duration time.Duration, allocSampled bool, allocBytes uint64) {
+	profileStatsMutex.Lock()
+	defer profileStatsMutex.Unlock()
+
+	s, exists := profileStats[key]
+	if !exists {
+		s = &profileStat{profileKey: key}
+		profileStats[key] = s
+	}
+
+	s.Count++
+	s.Total += duration
+	if allocSampled {
+		s.AllocCalls++
+		s.AllocBytes += allocBytes
+	}
+	if duration > s.Max {
+		s.Max = duration
+	}
+}
+
+// sortedProfileStats returns the copy of stats sorted by the total time spent
+func sortedProfileStats() []profileStat {
+	profileStatsMutex.Lock()
+	defer profileStatsMutex.Unlock()
+
+	res := make([]profileStat, 0, len(profileStats))
+	for _, s := range profileStats {
+		res = append(res, *s)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Total > res[j].Total
+	})
+
+	return res
+}
+
+func resetProfileStats() {
+	profileStatsMutex.Lock()
+	defer profileStatsMutex.Unlock()
+
+	profileStats = make(map[profileKey]*profileStat)
+}
+
+// tgUpdateType returns the name of the update's field as it named in the Bot API
+func tgUpdateType(u *tg.Update) string {
+	switch {
+	case u.Message != nil:
+		return "message"
+	case u.EditedMessage != nil:
+		return "edited_message"
+	case u.ChannelPost != nil:
+		return "channel_post"
+	case u.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case u.CallbackQuery != nil:
+		return "callback_query"
+	case u.InlineQuery != nil:
+		return "inline_query"
+	case u.ChosenInlineResult != nil:
+		return "chosen_inline_result"
+	}
+	return "other"
+}
+
+func (s profileStat) String() string {
+	var avg time.Duration
+	if s.Count > 0 {
+		avg = s.Total / time.Duration(s.Count)
+	}
+
+	// extrapolate the sampled allocations to all calls
+	var alloc float64
+	if s.AllocCalls > 0 {
+		alloc = float64(s.AllocBytes) / float64(s.AllocCalls) * float64(s.Count)
+	}
+
+	return fmt.Sprintf("%s %s: %d calls, total %s, avg %s, max %s, ~%.1f MB allocated",
+		s.Service, s.Update, s.Count, s.Total.Round(time.Millisecond), avg.Round(time.Microsecond), s.Max.Round(time.Millisecond), alloc/(1024*1024))
+}
+
+// adminProfileReport: /integram profile [reset|cpu [seconds]]
+func adminProfileReport(c *Context, args []string) (string, error) {
+	if !Config.Profiling {
+		return "", errors.New("Profiling is disabled. Set INTEGRAM_PROFILING=1 to enable it")
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "reset":
+			resetProfileStats()
+			return "Profiling stats were reset", nil
+		case "cpu":
+			return "", c.dumpCPUProfile(args[1:])
+		default:
+			return "", fmt.Errorf("Unknown argument '%s'. Usage: profile [reset|cpu [seconds]]", args[0])
+		}
+	}
+
+	stats := sortedProfileStats()
+	if len(stats) == 0 {
+		return "No handlers profiled yet", nil
+	}
+
+	lines := []string{"Handlers sorted by the total time spent:"}
+	for _, s := range stats {
+		lines = append(lines, s.String())
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// dumpCPUProfile responds with the pprof CPU profile. Samples are labeled with 'service' and 'update', e.g. use 'go tool pprof -tagfocus service=trello'
+func (c *Context) dumpCPUProfile(args []string) error {
+	if c.gin == nil {
+		return errors.New("CPU profile can be dumped only via /admin/profile HTTP endpoint")
+	}
+
+	seconds := profileCPUDefaultSeconds
+	if len(args) > 0 {
+		var err error
+		seconds, err = strconv.Atoi(args[0])
+		if err != nil || seconds < 1 || seconds > profileCPUMaxSeconds {
+			return fmt.Errorf("Wrong number of seconds: %s. Must be between 1 and %d", args[0], profileCPUMaxSeconds)
+		}
+	}
+
+	profileCPUMutex.Lock()
+	defer profileCPUMutex.Unlock()
+
+	buf := &bytes.Buffer{}
+	err := pprof.StartCPUProfile(buf)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(time.Duration(seconds) * time.Second)
+	pprof.StopCPUProfile()
+
+	c.gin.Header("Content-Disposition", fmt.Sprintf("attachment; filename=cpu-%s.pprof", time.Now().Format("20060102-150405")))
+	c.gin.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_tgUpdateType(t *testing.T) {
+	tests := []struct {
+		name string
+		u    *tg.Update
+		want string
+	}{
+		{"message", &tg.Update{Message: &tg.Message{}}, "message"},
+		{"callback", &tg.Update{CallbackQuery: &tg.CallbackQuery{}}, "callback_query"},
+		{"inline query", &tg.Update{InlineQuery: &tg.InlineQuery{}}, "inline_query"},
+		{"empty", &tg.Update{}, "other"},
+	}
+	for _, tt := range tests {
+		if got := tgUpdateType(tt.u); got != tt.want {
+			t.Errorf("%q. tgUpdateType() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_recordProfileStat(t *testing.T) {
+	resetProfileStats()
+	defer resetProfileStats()
+
+	recordProfileStat(profileKey{"trello", "webhook"}, time.Millisecond*10, true, 100)
+	recordProfileStat(profileKey{"trello", "webhook"}, time.Millisecond*30, true, 200)
+	recordProfileStat(profileKey{"trello", "webhook"}, time.Millisecond*20, false, 0)
+	recordProfileStat(profileKey{"github", "message"}, time.Millisecond*5, true, 50)
+
+	stats := sortedProfileStats()
+	if len(stats) != 2 {
+		t.Fatalf("sortedProfileStats() returned %d stats, want 2", len(stats))
+	}
+
+	want := profileStat{profileKey: profileKey{"trello", "webhook"}, Count: 3, Total: time.Millisecond * 60, Max: time.Millisecond * 30, AllocBytes: 300, AllocCalls: 2}
+	if stats[0] != want {
+		t.Errorf("sortedProfileStats()[0] = %+v, want %+v", stats[0], want)
+	}
+}
@@ -0,0 +1,82 @@
+package integram
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// networks the user-provided URLs can't reach besides the loopback, link-local and unspecified addresses
+var nonPublicNetworks = parseTrustedProxies([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+})
+
+var publicDialer = &net.Dialer{Timeout: time.Second * 30, KeepAlive: time.Second * 30}
+
+// isPublicIP returns false for the loopback, private, link-local and unspecified addresses
+func isPublicIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic resolves the host and connects to its checked IP, so the host can't be rebound to the internal address between the check and the connection.
+// Host resolved to any non-public address is rejected
+func dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if !isPublicIP(ip.IP) {
+			return nil, fmt.Errorf("%s resolves to the non-public address %s", host, ip.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = publicDialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("%s has no addresses", host)
+	}
+	return nil, err
+}
+
+// publicHTTPClient returns the client for the user-provided URLs. It only connects to the public addresses, including on the redirects, and ignores the proxy settings
+func publicHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialPublic,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       time.Second * 90,
+			TLSHandshakeTimeout:   time.Second * 10,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,42 @@
+package integram
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func Test_isPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func Test_dialPublic(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:80", "[::1]:443", "169.254.169.254:80", "localhost:80"} {
+		if conn, err := dialPublic(context.Background(), "tcp", addr); err == nil {
+			conn.Close()
+			t.Errorf("dialPublic(%s) must refuse the non-public address", addr)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package integram
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	tg "github.com/requilence/telegram-bot-api"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// prefix of the inline result's ID to recognize the chosen quick-create result
+const quickCreateResultPrefix = "_qc:"
+
+// data of the button shown while the object is being created. Telegram provides the inline message ID only for the messages with the inline keyboard
+const quickCreatePendingData = "_qc_wait"
+
+// start parameter of the private chat opened from the inline results when the user isn't authorized
+const quickCreateAuthStartParam = "qc_auth"
+
+var quickCreateQueryRE = regexp.MustCompile(`(?is)^\s*new\s+([^\s:]*)\s*(?::\s*(.*))?$`)
+
+var quickCreateAuthStartRE = regexp.MustCompile(`^/start(?:@[a-zA-Z0-9_]+)? ` + quickCreateAuthStartParam + `$`)
+
+// QuickCreate lets users create the upstream objects from any chat by typing '@bot new <type>: <title>' in the inline mode.
+// Chosen result is posted as the placeholder, then replaced with the card returned by the handler.
+// Requires the inline feedback to be enabled for the bot with @BotFather's /setinlinefeedback
+type QuickCreate struct {
+	Type        string // typed after 'new', e.g. "bug". Single word
+	Description string // shown in the inline result, e.g. "Create the bug in the default project"
+
+	// Creates the object via the user's credentials. Returned message's text, parse mode, inline keyboard and callback action replace the placeholder.
+	// ctx.Chat is empty, because the inline message's chat is unknown
+	Handler func(ctx *Context, title string) (*OutgoingMessage, error)
+}
+
+// parseQuickCreateQuery returns the type and the title of 'new bug: title' inline query. ok is false if the query isn't quick-create one
+func parseQuickCreateQuery(query string) (objType string, title string, ok bool) {
+	match := quickCreateQueryRE.FindStringSubmatch(query)
+	if match == nil {
+		return "", "", false
+	}
+	return strings.ToLower(match[1]), strings.TrimSpace(match[2]), true
+}
+
+// quickCreate returns the service's quick-create of the type or nil
+func (s *Service) quickCreate(objType string) *QuickCreate {
+	for i := range s.QuickCreates {
+		if strings.EqualFold(s.QuickCreates[i].Type, objType) {
+			return &s.QuickCreates[i]
+		}
+	}
+	return nil
+}
+
+// quickCreateAuthorized returns true if the service doesn't use OAuth or the user is authorized
+func (c *Context) quickCreateAuthorized() bool {
+	s := c.Service()
+	if s.DefaultOAuth1 == nil && s.DefaultOAuth2 == nil {
+		return true
+	}
+	return c.User.OAuthValid()
+}
+
+// handleQuickCreateQuery answers 'new <type>: <title>' inline query with the results of the matching types. Returns true if query was handled
+func (c *Context) handleQuickCreateQuery() (bool, error) {
+	s := c.Service()
+	if s == nil || len(s.QuickCreates) == 0 {
+		return false, nil
+	}
+
+	objType, title, ok := parseQuickCreateQuery(c.InlineQuery.Query)
+	if !ok {
+		return false, nil
+	}
+
+	if !c.quickCreateAuthorized() {
+		return true, c.AnswerInlineQueryWithPM(c.T("Connect your %s account to create", s.NameToPrint), quickCreateAuthStartParam)
+	}
+
+	res := []interface{}{}
+	// result can't be chosen without posting it, so the types are offered once the title is typed
+	if title != "" {
+		for _, qc := range s.QuickCreates {
+			if !strings.HasPrefix(strings.ToLower(qc.Type), objType) {
+				continue
+			}
+
+			res = append(res, tg.InlineQueryResultArticle{
+				Type:        "article",
+				ID:          quickCreateResultPrefix + strings.ToLower(qc.Type),
+				Title:       c.T("New %s: %s", qc.Type, title),
+				Description: qc.Description,
+				InputMessageContent: tg.InputTextMessageContent{
+					Text:                  c.T("⏳ Creating the %s: %s", qc.Type, title),
+					DisableWebPagePreview: true,
+				},
+				ReplyMarkup: &tg.InlineKeyboardMarkup{InlineKeyboard: [][]tg.InlineKeyboardButton{{tg.NewInlineKeyboardButtonData("⏳", quickCreatePendingData)}}},
+			})
+		}
+	}
+
+	return true, c.AnswerInlineQueryWithResults(res, 0, true, "")
+}
+
+// handleQuickCreateResult creates the object of the chosen quick-create result and replaces the placeholder with its card. Returns true if result was handled
+func (c *Context) handleQuickCreateResult() bool {
+	s := c.Service()
+	if s == nil || !strings.HasPrefix(c.ChosenInlineResult.ResultID, quickCreateResultPrefix) {
+		return false
+	}
+
+	om := c.ChosenInlineResult.Message
+	if om == nil || om.InlineMsgID == "" {
+		c.Log().Error("handleQuickCreateResult: inline message ID is empty, the result must have the inline keyboard")
+		return true
+	}
+
+	qc := s.quickCreate(strings.TrimPrefix(c.ChosenInlineResult.ResultID, quickCreateResultPrefix))
+	_, title, _ := parseQuickCreateQuery(c.ChosenInlineResult.Query)
+	if qc == nil || title == "" {
+		c.editQuickCreatePlaceholder(om, c.T("❌ This object can't be created"))
+		return true
+	}
+
+	var card *OutgoingMessage
+	err := c.runHandler("inline", s.withMiddlewares(func(hc *Context) error {
+		var err error
+		card, err = qc.Handler(hc, title)
+		return err
+	}), func(hc *Context, err error) {
+		hc.finishQuickCreate(om, qc, title, card, err)
+	})
+
+	// otherwise the placeholder is replaced once the handler finishes
+	if err != ErrHandlerTimeout {
+		c.finishQuickCreate(om, qc, title, card, err)
+	}
+	return true
+}
+
+// finishQuickCreate replaces the placeholder with the created object's card or the error
+func (c *Context) finishQuickCreate(om *OutgoingMessage, qc *QuickCreate, title string, card *OutgoingMessage, err error) {
+	if err != nil {
+		c.Log().WithError(err).WithField("type", qc.Type).Error("QuickCreate handler failed")
+
+		text := c.T("❌ Can't create the %s. Please try again later", qc.Type)
+		if se, ok := AsServiceError(err); ok {
+			text = "❌ " + se.Text
+		}
+		c.editQuickCreatePlaceholder(om, text)
+		return
+	}
+
+	if card == nil {
+		c.editQuickCreatePlaceholder(om, c.T("✅ Created the %s: %s", qc.Type, title))
+		return
+	}
+
+	c.applyQuickCreateCard(om, card)
+}
+
+// editQuickCreatePlaceholder replaces the placeholder with the plain text and removes the pending button
+func (c *Context) editQuickCreatePlaceholder(om *OutgoingMessage, text string) {
+	om.ParseMode = ""
+	err := c.EditMessageTextAndInlineKeyboard(om, "", text, InlineKeyboard{})
+	if err != nil {
+		c.Log().WithError(err).Error("Can't edit the quick-create placeholder")
+	}
+}
+
+// applyQuickCreateCard replaces the placeholder with the created object's card
+func (c *Context) applyQuickCreateCard(om *OutgoingMessage, card *OutgoingMessage) {
+	set := bson.M{"onreplyaction": card.OnReplyAction, "onreplydata": card.OnReplyData, "oncallbackaction": card.OnCallbackAction, "oncallbackdata": card.OnCallbackData}
+	if len(card.EventID) > 0 {
+		set["eventid"] = card.EventID
+	}
+
+	// actions are stored before the edit, so the card's buttons work once they are shown
+	err := c.db.C("messages").UpdateId(om.ID, bson.M{"$set": set})
+	if err != nil {
+		c.Log().WithError(err).Error("Can't save the quick-create card's actions")
+	}
+
+	card.applySafeText()
+	om.ParseMode = card.ParseMode
+	om.WebPreview = card.WebPreview
+	err = c.EditMessageTextAndInlineKeyboard(om, "", card.Text, card.InlineKeyboardMarkup)
+	if err != nil {
+		c.Log().WithError(err).Error("Can't edit the quick-create placeholder")
+	}
+}
+
+// answerQuickCreatePendingCallback answers the press of the placeholder's button
+func (c *Context) answerQuickCreatePendingCallback() error {
+	return c.AnswerCallbackQuery(c.T("Creating, please wait…"), false)
+}
+
+// handleQuickCreateAuthStart process '/start qc_auth' in the private chat opened from the inline results. Returns true if message was handled
+func (c *Context) handleQuickCreateAuthStart() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() || !quickCreateAuthStartRE.MatchString(c.Message.Text) {
+		return false
+	}
+
+	s := c.Service()
+	if s == nil || len(s.QuickCreates) == 0 {
+		return false
+	}
+
+	example := fmt.Sprintf("@%s %s", c.Bot().Username, s.QuickCreates[0].String())
+	msg := c.NewMessage().SetParseMode("")
+	if c.quickCreateAuthorized() {
+		msg.SetText(c.T("You are connected. Type in any chat to create, e.g. %s", example))
+	} else if url := c.User.OauthInitURL(); url != "" {
+		msg.SetText(c.T("Connect your %s account, then type in any chat to create, e.g. %s", s.NameToPrint, example)).
+			SetInlineKeyboard(InlineKeyboard{Buttons: []InlineButtons{{InlineButton{Text: c.T("🔑 Authorize"), URL: url}}}})
+	} else {
+		msg.SetText(c.T("Can't start the authorization. Please try again later"))
+	}
+
+	err := msg.Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleQuickCreateAuthStart: can't send the reply")
+	}
+	return true
+}
+
+// String returns the example of the inline query, e.g. 'new bug: title'
+func (qc QuickCreate) String() string {
+	return fmt.Sprintf("new %s: title", strings.ToLower(qc.Type))
+}
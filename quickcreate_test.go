@@ -0,0 +1,42 @@
+package integram
+
+import "testing"
+
+func TestParseQuickCreateQuery(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantType  string
+		wantTitle string
+		wantOK    bool
+	}{
+		{"new bug: Login button is broken", "bug", "Login button is broken", true},
+		{"  New Task:Write the docs ", "task", "Write the docs", true},
+		{"new bug : title: with colon", "bug", "title: with colon", true},
+		{"new b", "b", "", true},
+		{"new ", "", "", true},
+		{"new bug:", "bug", "", true},
+		{"newbug: title", "", "", false},
+		{"renew bug: title", "", "", false},
+		{"search bugs", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			gotType, gotTitle, gotOK := parseQuickCreateQuery(tt.query)
+			if gotType != tt.wantType || gotTitle != tt.wantTitle || gotOK != tt.wantOK {
+				t.Errorf("parseQuickCreateQuery(%q) = %q, %q, %v, want %q, %q, %v", tt.query, gotType, gotTitle, gotOK, tt.wantType, tt.wantTitle, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestService_quickCreate(t *testing.T) {
+	s := &Service{QuickCreates: []QuickCreate{{Type: "Bug"}, {Type: "task"}}}
+
+	if qc := s.quickCreate("bug"); qc == nil || qc.Type != "Bug" {
+		t.Errorf("Service.quickCreate(bug) = %v, want Bug", qc)
+	}
+	if qc := s.quickCreate("epic"); qc != nil {
+		t.Errorf("Service.quickCreate(epic) = %v, want nil", qc)
+	}
+}
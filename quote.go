@@ -0,0 +1,123 @@
+package integram
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// incoming quotes wait for the update to be processed for this period
+const incomingQuoteTTL = time.Minute * 10
+
+// MessageQuote is the part of the replied message quoted by the user, e.g. the line of the code review comment
+type MessageQuote struct {
+	Text     string `json:"text"`
+	Position int    `json:"position"`  // offset of the quote in the replied message's text in UTF-16 code units
+	IsManual bool   `json:"is_manual"` // quote was selected by the user, not added automatically
+}
+
+// tgReplyParameters is reply_parameters of the sendMessage request
+type tgReplyParameters struct {
+	MessageID                int    `json:"message_id"`
+	Quote                    string `json:"quote,omitempty"`
+	QuoteParseMode           string `json:"quote_parse_mode,omitempty"`
+	QuotePosition            int    `json:"quote_position,omitempty"`
+	AllowSendingWithoutReply bool   `json:"allow_sending_without_reply,omitempty"`
+}
+
+// Bot API client doesn't decode the message's quote, so it is extracted from the raw getUpdates result
+var tgIncomingQuotes = &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+func incomingQuoteKey(chatID int64, msgID int) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(msgID)
+}
+
+// SetReplyQuote sets the message to reply on and quotes its part, e.g. the line of code to comment. position is the quote's offset in UTF-16 code units, set 0 to find the first occurrence.
+// Quote must be the exact substring of the replied message, otherwise Telegram rejects it
+func (m *OutgoingMessage) SetReplyQuote(msgID int, quote string, position int) *OutgoingMessage {
+	m.ReplyToMsgID = msgID
+	m.ReplyQuote = quote
+	m.ReplyQuotePosition = position
+	return m
+}
+
+// replyParameters returns reply_parameters for the message with the quote
+func (m *OutgoingMessage) replyParameters() tgReplyParameters {
+	return tgReplyParameters{MessageID: m.ReplyToMsgID, Quote: m.ReplyQuote, QuotePosition: m.ReplyQuotePosition, AllowSendingWithoutReply: true}
+}
+
+// sendQuotedMessage sends the text message with reply_parameters, which Bot API client doesn't support
+func (m *OutgoingMessage) sendQuotedMessage(bot *Bot, msg tg.MessageConfig) (tg.Message, error) {
+	v := url.Values{}
+	v.Set("chat_id", strconv.FormatInt(m.ChatID, 10))
+	v.Set("text", msg.Text)
+	v.Set("disable_web_page_preview", strconv.FormatBool(msg.DisableWebPagePreview))
+	v.Set("disable_notification", strconv.FormatBool(msg.DisableNotification))
+
+	if msg.ParseMode != "" {
+		v.Set("parse_mode", msg.ParseMode)
+	}
+
+	if msg.ReplyMarkup != nil {
+		data, err := json.Marshal(msg.ReplyMarkup)
+		if err != nil {
+			return tg.Message{}, err
+		}
+		v.Set("reply_markup", string(data))
+	}
+
+	data, err := json.Marshal(m.replyParameters())
+	if err != nil {
+		return tg.Message{}, err
+	}
+	v.Set("reply_parameters", string(data))
+
+	resp, err := bot.API.MakeRequest("sendMessage", v)
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	var tgMsg tg.Message
+	err = json.Unmarshal(resp.Result, &tgMsg)
+	return tgMsg, err
+}
+
+// extractIncomingQuotes stores the quotes of the messages in the getUpdates result
+func extractIncomingQuotes(result []byte) {
+	type quotedMessage struct {
+		MessageID int           `json:"message_id"`
+		Chat      *tg.Chat      `json:"chat"`
+		Quote     *MessageQuote `json:"quote"`
+	}
+
+	var updates []struct {
+		Message           *quotedMessage `json:"message"`
+		EditedMessage     *quotedMessage `json:"edited_message"`
+		ChannelPost       *quotedMessage `json:"channel_post"`
+		EditedChannelPost *quotedMessage `json:"edited_channel_post"`
+	}
+
+	if json.Unmarshal(result, &updates) != nil {
+		return
+	}
+
+	for _, u := range updates {
+		for _, m := range []*quotedMessage{u.Message, u.EditedMessage, u.ChannelPost, u.EditedChannelPost} {
+			if m != nil && m.Quote != nil && m.Chat != nil {
+				tgIncomingQuotes.set(incomingQuoteKey(m.Chat.ID, m.MessageID), m.Quote, incomingQuoteTTL)
+			}
+		}
+	}
+}
+
+// incomingQuote returns the quote of the received message or nil
+func incomingQuote(chatID int64, msgID int) *MessageQuote {
+	quote, exists := tgIncomingQuotes.get(incomingQuoteKey(chatID, msgID))
+	if !exists {
+		return nil
+	}
+	return quote.(*MessageQuote)
+}
@@ -0,0 +1,43 @@
+package integram
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_extractIncomingQuotes(t *testing.T) {
+	result := []byte(`[
+		{"update_id":1,"message":{"message_id":10,"chat":{"id":-100500,"type":"supergroup"},"text":"looks wrong","reply_to_message":{"message_id":9},"quote":{"text":"return nil","position":42,"is_manual":true}}},
+		{"update_id":2,"message":{"message_id":11,"chat":{"id":-100500,"type":"supergroup"},"text":"no quote"}},
+		{"update_id":3,"callback_query":{"id":"1","data":"x"}}
+	]`)
+
+	extractIncomingQuotes(result)
+
+	tests := []struct {
+		name  string
+		msgID int
+		want  *MessageQuote
+	}{
+		{"quoted", 10, &MessageQuote{Text: "return nil", Position: 42, IsManual: true}},
+		{"not quoted", 11, nil},
+		{"unknown", 12, nil},
+	}
+	for _, tt := range tests {
+		got := incomingQuote(-100500, tt.msgID)
+		if got == nil && tt.want != nil || got != nil && (tt.want == nil || *got != *tt.want) {
+			t.Errorf("%q. incomingQuote() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutgoingMessage_SetReplyQuote(t *testing.T) {
+	m := &OutgoingMessage{}
+	m.SetReplyQuote(9, "return nil", 42)
+
+	data, err := json.Marshal(m.replyParameters())
+	want := `{"message_id":9,"quote":"return nil","quote_position":42,"allow_sending_without_reply":true}`
+	if err != nil || string(data) != want {
+		t.Errorf("replyParameters() = %s, %v, want %s", data, err, want)
+	}
+}
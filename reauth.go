@@ -0,0 +1,118 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrorOAuthUnauthorized should be returned by the action when upstream responded with 401 to the user's OAuth token
+var ErrorOAuthUnauthorized = errors.New("Upstream responded with 401 Unauthorized")
+
+// IsOAuthUnauthorized checks if the error was caused by invalid or expired OAuth token
+func IsOAuthUnauthorized(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if err == ErrorOAuthUnauthorized {
+		return true
+	}
+
+	if re, ok := err.(*oauth2.RetrieveError); ok && re.Response != nil {
+		return re.Response.StatusCode == http.StatusUnauthorized
+	}
+
+	return strings.Contains(err.Error(), "401 Unauthorized")
+}
+
+// WithReauth calls the action and, if it fails with unauthorized error, refreshes the OAuth token and retries it once.
+// If the retry also fails the action is stored to be resumed after the user authorizes again and the user is asked to do so
+// !!! Please note that you must omit first arg *integram.Context, because it will be automatically prepended
+func (c *Context) WithReauth(handlerFunc interface{}, args ...interface{}) error {
+	err := verifyTypeMatching(handlerFunc, args...)
+	if err != nil {
+		return err
+	}
+
+	err = callHandler(c, handlerFunc, args...)
+	if !IsOAuthUnauthorized(err) {
+		return err
+	}
+
+	c.Log().WithError(err).Info("WithReauth: action got unauthorized error, trying to refresh the token")
+
+	if refreshErr := c.User.forceRefreshOAuthToken(); refreshErr == nil {
+		err = callHandler(c, handlerFunc, args...)
+		if !IsOAuthUnauthorized(err) {
+			return err
+		}
+	} else {
+		c.Log().WithError(refreshErr).Info("WithReauth: can't refresh the token")
+	}
+
+	return c.askForReauth(handlerFunc, args...)
+}
+
+// askForReauth stores the action to be resumed after auth and sends the auth link to the user
+func (c *Context) askForReauth(handlerFunc interface{}, args ...interface{}) error {
+	err := c.User.SetAfterAuthAction(handlerFunc, args...)
+	if err != nil {
+		return err
+	}
+
+	c.User.ResetOAuthToken()
+
+	buttons := InlineButtons{}
+	buttons.AddURL(c.User.OauthInitURL(), "Authorize")
+
+	msg := c.NewMessage().
+		SetChat(c.User.ID).
+		SetText(fmt.Sprintf("Your %s authorization has expired. Please authorize again and your last action will be completed automatically", c.Service().NameToPrint)).
+		SetInlineKeyboard(buttons.Markup(1, ""))
+
+	if c.Chat.ID != 0 && c.Chat.ID != c.User.ID {
+		msg.SetBackupChat(c.Chat.ID)
+	}
+
+	return msg.Send()
+}
+
+// forceRefreshOAuthToken refreshes the OAuth2 access token using the stored refresh token even if it isn't expired yet
+func (user *User) forceRefreshOAuthToken() error {
+	ts, err := user.OAuthTokenSource()
+	if err != nil {
+		return err
+	}
+
+	_, err = ts.(*OAuthTokenSource).refresh(nil)
+	return err
+}
+
+// runAfterAuthAction calls the action stored with SetAfterAuthAction and removes it
+func (user *User) runAfterAuthAction() error {
+	ps, err := user.protectedSettings()
+	if err != nil || ps.AfterAuthHandler == "" {
+		return err
+	}
+
+	funcName := user.ctx.Service().trimFuncPath(ps.AfterAuthHandler)
+	data := ps.AfterAuthData
+
+	ps.AfterAuthHandler = ""
+	ps.AfterAuthData = nil
+	err = user.saveProtectedSettings()
+	if err != nil {
+		return err
+	}
+
+	handler, ok := actionFuncs[funcName]
+	if !ok {
+		return fmt.Errorf("AfterAuth handler '%s' not registred in service's configuration", funcName)
+	}
+
+	return callEncodedHandler(user.ctx, handler, data)
+}
@@ -0,0 +1,32 @@
+package integram
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestIsOAuthUnauthorized(t *testing.T) {
+	type args struct {
+		err error
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{"nil", args{nil}, false},
+		{"sentinel", args{ErrorOAuthUnauthorized}, true},
+		{"retrieve error 401", args{&oauth2.RetrieveError{Response: &http.Response{StatusCode: 401}}}, true},
+		{"retrieve error 500", args{&oauth2.RetrieveError{Response: &http.Response{StatusCode: 500}}}, false},
+		{"http status text", args{errors.New("GET https://api.trello.com/1/cards: 401 Unauthorized")}, true},
+		{"other error", args{errors.New("connection refused")}, false},
+	}
+	for _, tt := range tests {
+		if got := IsOAuthUnauthorized(tt.args.err); got != tt.want {
+			t.Errorf("%q. IsOAuthUnauthorized() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package integram
+
+import (
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Recipient is the target chat's language and timezone to render the message for
+type Recipient struct {
+	ChatID int64
+	Lang   string         // IETF language tag as received from Telegram, e.g. "en" or "pt-br". Empty if unknown
+	Tz     *time.Location // UTC if unknown
+}
+
+// RenderFunc produces the message for the specific recipient. It is called right before sending, so the text can be rendered with the recipient's language and timezone.
+// ctx.Chat is set to the recipient's chat, use ctx.NewMessage() to create the message. Return nil message to skip the recipient
+type RenderFunc func(ctx *Context, r Recipient) (*OutgoingMessage, error)
+
+// RenderEditFunc produces the text and keyboard to edit the message for the specific recipient
+type RenderEditFunc func(ctx *Context, r Recipient) (text string, kb InlineKeyboard, err error)
+
+func newRecipient(chatID int64, lang string, tz string) Recipient {
+	return Recipient{ChatID: chatID, Lang: strings.ToLower(lang), Tz: tzLocation(tz)}
+}
+
+// BaseLang returns the primary language subtag, e.g. "pt" for "pt-br"
+func (r Recipient) BaseLang() string {
+	if i := strings.IndexAny(r.Lang, "-_"); i > -1 {
+		return r.Lang[0:i]
+	}
+	return r.Lang
+}
+
+// In returns the time in the recipient's timezone
+func (r Recipient) In(t time.Time) time.Time {
+	if r.Tz == nil {
+		return t.UTC()
+	}
+	return t.In(r.Tz)
+}
+
+// findRecipient returns the stored language and timezone of the user for private chat or of the group otherwise
+func findRecipient(db *mgo.Database, chatID int64) (Recipient, error) {
+	var data struct {
+		Lang   string
+		Locale string
+		Tz     string
+	}
+
+	collection := "chats"
+	if chatID > 0 {
+		collection = "users"
+	}
+
+	err := db.C(collection).FindId(chatID).Select(bson.M{"lang": 1, "locale": 1, "tz": 1}).One(&data)
+	if err != nil && err != mgo.ErrNotFound {
+		return newRecipient(chatID, "", ""), err
+	}
+
+	if data.Locale != "" {
+		data.Lang = data.Locale
+	}
+
+	return newRecipient(chatID, data.Lang, data.Tz), nil
+}
+
+// Recipient returns the language and timezone of the current chat. For the private chat the user's ones are used
+func (c *Context) Recipient() Recipient {
+	chatID := c.Chat.ID
+	if chatID == 0 {
+		chatID = c.User.ID
+	}
+
+	if chatID == c.User.ID && c.User.Lang != "" && c.User.Tz != "" {
+		return newRecipient(chatID, c.User.Language(), c.User.Tz)
+	}
+
+	r, err := findRecipient(c.db, chatID)
+	if err != nil {
+		c.Log().WithError(err).WithField("chat", chatID).Error("Can't find the recipient's language and timezone")
+	}
+
+	// language received within the current update is more actual unless the user has chosen one with /language
+	if chatID == c.User.ID && c.User.Lang != "" {
+		r.Lang = strings.ToLower(c.User.Language())
+	}
+
+	return r
+}
+
+// SetLang sets the language used to render messages for the group chat. For private chats the user's Telegram language is used
+func (chat *Chat) SetLang(lang string) error {
+	chat.Lang = lang
+	if chat.data != nil {
+		chat.data.Lang = lang
+	}
+
+	return chat.ctx.db.C("chats").UpdateId(chat.ID, bson.M{"$set": bson.M{"lang": lang}})
+}
+
+// SendToChats renders the message for each chat with its own language and timezone and sends it. Returns the number of sent messages
+func (c *Context) SendToChats(chatIDs []int64, render RenderFunc) (sent int, err error) {
+	return c.SendEventToChats(chatIDs, nil, render)
+}
+
+// EditMessagesWithEventIDPerRecipient works like EditMessagesWithEventID, but renders the text and inline keyboard for each message's chat separately
+func (c *Context) EditMessagesWithEventIDPerRecipient(eventID string, fromState string, render RenderEditFunc) (edited int, err error) {
+	//update MAX_MSGS_TO_UPDATE_WITH_EVENTID last bot messages
+	messages, _ := c.Messages().AllChats().EventID(eventID).State(fromState).Limit(MaxMsgsToUpdateWithEventID).List()
+	for _, message := range messages {
+		ctx := c.ForkForChat(message.ChatID)
+
+		text, kb, renderErr := render(ctx, ctx.Recipient())
+		if renderErr != nil {
+			ctx.Log().WithError(renderErr).WithField("eventid", eventID).Error("EditMessagesWithEventIDPerRecipient: can't render the message")
+			if err == nil {
+				err = renderErr
+			}
+			continue
+		}
+
+		editErr := ctx.EditMessageTextAndInlineKeyboard(message, fromState, text, kb)
+		if editErr != nil {
+			ctx.Log().WithError(editErr).WithField("eventid", eventID).Error("EditMessagesWithEventIDPerRecipient")
+			// the first error is returned
+			if err == nil {
+				err = editErr
+			}
+		} else {
+			edited++
+		}
+	}
+	return edited, err
+}
@@ -0,0 +1,40 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecipient_BaseLang(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"", ""},
+		{"en", "en"},
+		{"pt-br", "pt"},
+		{"zh_Hans", "zh"},
+	}
+	for _, tt := range tests {
+		if got := (Recipient{Lang: tt.lang}).BaseLang(); got != tt.want {
+			t.Errorf("Recipient{Lang: %q}.BaseLang() = %v, want %v", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestRecipient_In(t *testing.T) {
+	ts := time.Date(2018, 5, 1, 23, 30, 0, 0, time.UTC)
+
+	r := newRecipient(1, "RU", "Europe/Moscow")
+	if r.Lang != "ru" {
+		t.Errorf("newRecipient() Lang = %v, want ru", r.Lang)
+	}
+
+	if got := r.In(ts).Format("2006-01-02 15:04"); got != "2018-05-02 02:30" {
+		t.Errorf("Recipient.In() = %v, want 2018-05-02 02:30", got)
+	}
+
+	if got := (Recipient{}).In(ts); !got.Equal(ts) || got.Location() != time.UTC {
+		t.Errorf("Recipient{}.In() = %v, want %v", got, ts)
+	}
+}
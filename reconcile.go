@@ -0,0 +1,331 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// number of chats fetched from the db at once. All chats are paged through within one run
+const reconcileChatsBatch = 500
+
+// delay between the requests to not hit the Bot API limits
+const reconcileChatsRequestDelay = time.Millisecond * 200
+
+const (
+	reconcileStatusActive      = "active"
+	reconcileStatusKicked      = "kicked"
+	reconcileStatusDeactivated = "deactivated"
+	reconcileStatusMigrated    = "migrated"
+	reconcileStatusUnknown     = "unknown" // request failed, chat will be verified on the next run
+)
+
+// reconcileReport is the result of the chats membership reconciliation for the service
+type reconcileReport struct {
+	Service     string
+	StartedAt   time.Time
+	Duration    time.Duration
+	Checked     int
+	Active      int
+	Kicked      int
+	Deactivated int
+	Migrated    int
+	Errors      int
+}
+
+var reconcileReportsMutex = sync.Mutex{}
+var reconcileReports = make(map[string]reconcileReport)
+var reconcileRunning = make(map[string]bool)
+
+func init() {
+	registerAdminCommand("reconcile", adminReconcileChats)
+}
+
+// reconcileChatsChecker periodically verifies that the bot is still present in the chats with active subscriptions
+func reconcileChatsChecker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("reconcileChatsChecker panic recovered %v", r)
+			reconcileChatsChecker()
+		}
+	}()
+
+	if Config.IsMainInstance() || Config.ReconcileChatsInterval <= 0 {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		// spread the load after the restart
+		time.Sleep(time.Hour)
+
+		var list []*Service
+		serviceMapMutex.RLock()
+		for _, service := range services {
+			list = append(list, service)
+		}
+		serviceMapMutex.RUnlock()
+
+		for _, service := range list {
+			report, err := reconcileChats(db, service, time.Now().Add(-Config.ReconcileChatsInterval))
+			if err != nil {
+				log.WithError(err).WithField("service", service.Name).Error("reconcileChatsChecker: can't reconcile the chats")
+				continue
+			}
+
+			if report.Checked > 0 {
+				log.WithField("service", service.Name).Infof("reconcileChatsChecker: %s", report.String())
+			}
+		}
+
+		time.Sleep(Config.ReconcileChatsInterval)
+	}
+}
+
+// subscribedChats returns the chats with ID greater than afterID that receive the service's notifications and wasn't verified since the time, ordered by ID
+func subscribedChats(db *mgo.Database, serviceName string, verifiedBefore time.Time, afterID int64, limit int) ([]int64, error) {
+	var chatIDs []int64
+	err := db.C("users").Find(bson.M{"hooks.services": serviceName}).Distinct("hooks.chats", &chatIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatHooksIDs []int64
+	err = db.C("chats").Find(bson.M{"hooks.services": serviceName}).Distinct("_id", &chatHooksIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	chatIDs = append(chatIDs, chatHooksIDs...)
+	if len(chatIDs) == 0 {
+		return nil, nil
+	}
+
+	key := "protected." + serviceName
+	var chats []struct {
+		ID int64 `bson:"_id"`
+	}
+
+	err = db.C("chats").Find(bson.M{
+		"_id":                         bson.M{"$in": chatIDs, "$gt": afterID},
+		"deactivated":                 bson.M{"$ne": true},
+		"migratedtochatid":            bson.M{"$exists": false},
+		key + ".botstoppedorkickedat": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{key + ".reconciledat": bson.M{"$exists": false}},
+			{key + ".reconciledat": bson.M{"$lt": verifiedBefore}},
+		},
+	}).Select(bson.M{"_id": 1}).Sort("_id").Limit(limit).All(&chats)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]int64, 0, len(chats))
+	for _, chat := range chats {
+		res = append(res, chat.ID)
+	}
+	return res, nil
+}
+
+// chatMembershipStatus returns the reconcile status by the getChatMember or sendChatAction result
+func chatMembershipStatus(member *tg.ChatMember, err error) string {
+	if err != nil {
+		tgErr, ok := err.(tg.Error)
+		if !ok {
+			return reconcileStatusUnknown
+		}
+
+		switch {
+		case tgErr.BotKicked():
+			return reconcileStatusKicked
+		case tgErr.ChatDiactivated(), tgErr.ChatNotFound():
+			return reconcileStatusDeactivated
+		case tgErr.ChatMigrated():
+			return reconcileStatusMigrated
+		}
+		return reconcileStatusUnknown
+	}
+
+	if member != nil && (member.HasLeft() || member.WasKicked()) {
+		return reconcileStatusKicked
+	}
+
+	return reconcileStatusActive
+}
+
+// verifyChatMembership checks the bot's membership in the group with getChatMember. For private chats the typing action is sent as the lightweight ping, because it fails if the user stopped the bot
+func verifyChatMembership(bot *Bot, chatID int64) (string, error) {
+	if chatID > 0 {
+		_, err := bot.API.Send(tg.NewChatAction(chatID, tg.ChatTyping))
+		return chatMembershipStatus(nil, err), err
+	}
+
+	member, err := bot.API.GetChatMember(tg.ChatConfigWithUser{ChatID: chatID, UserID: bot.ID})
+	return chatMembershipStatus(&member, err), err
+}
+
+// reconcileChats verifies the bot's membership in the service's chats that wasn't verified since the time and marks the stale ones
+func reconcileChats(db *mgo.Database, service *Service, verifiedBefore time.Time) (reconcileReport, error) {
+	report := reconcileReport{Service: service.Name, StartedAt: time.Now()}
+
+	bot := service.Bot()
+	if bot == nil {
+		return report, errors.New("Bot not found for the service")
+	}
+
+	reconcileReportsMutex.Lock()
+	if reconcileRunning[service.Name] {
+		reconcileReportsMutex.Unlock()
+		return report, errors.New("Reconciliation is already running for the service")
+	}
+	reconcileRunning[service.Name] = true
+	reconcileReportsMutex.Unlock()
+
+	defer func() {
+		reconcileReportsMutex.Lock()
+		delete(reconcileRunning, service.Name)
+		reconcileReportsMutex.Unlock()
+	}()
+
+	key := "protected." + service.Name
+	afterID := int64(math.MinInt64)
+
+	for {
+		chatIDs, err := subscribedChats(db, service.Name, verifiedBefore, afterID, reconcileChatsBatch)
+		if err != nil {
+			return report, err
+		}
+
+		if len(chatIDs) == 0 {
+			break
+		}
+		afterID = chatIDs[len(chatIDs)-1]
+
+		for _, chatID := range chatIDs {
+			reconcileChat(db, bot, service.Name, key, chatID, &report)
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+
+	reconcileReportsMutex.Lock()
+	reconcileReports[service.Name] = report
+	reconcileReportsMutex.Unlock()
+
+	return report, nil
+}
+
+// reconcileChat verifies the bot's membership in the chat and adds the result to the report
+func reconcileChat(db *mgo.Database, bot *Bot, serviceName string, key string, chatID int64, report *reconcileReport) {
+	status, err := verifyChatMembership(bot, chatID)
+	report.Checked++
+
+	switch status {
+	case reconcileStatusActive:
+		report.Active++
+	case reconcileStatusKicked:
+		report.Kicked++
+		removeHooksForChat(db, serviceName, chatID)
+		db.C("chats").Update(bson.M{"_id": chatID, key + ".botstoppedorkickedat": bson.M{"$exists": false}}, bson.M{"$set": bson.M{key + ".botstoppedorkickedat": time.Now()}})
+	case reconcileStatusDeactivated:
+		report.Deactivated++
+		removeHooksForChat(db, serviceName, chatID)
+		db.C("chats").UpdateId(chatID, bson.M{"$set": bson.M{"deactivated": true}})
+	case reconcileStatusMigrated:
+		// hooks are moved to the supergroup on the first message there
+		report.Migrated++
+	default:
+		report.Errors++
+		log.WithError(err).WithField("chat", chatID).WithField("service", serviceName).Warn("reconcileChats: can't verify the chat")
+
+		if tgErr, ok := err.(tg.Error); ok && tgErr.TooManyRequests() {
+			delay := 60
+			if tgErr.Parameters != nil && tgErr.Parameters.RetryAfter > 0 {
+				delay = tgErr.Parameters.RetryAfter
+			}
+			time.Sleep(time.Duration(delay) * time.Second)
+		}
+		return
+	}
+
+	err = db.C("chats").UpdateId(chatID, bson.M{"$set": bson.M{key + ".reconciledat": time.Now()}})
+	if err != nil && err != mgo.ErrNotFound {
+		log.WithError(err).WithField("chat", chatID).Error("reconcileChats: can't save the reconcile time")
+	}
+
+	time.Sleep(reconcileChatsRequestDelay)
+}
+
+func (r reconcileReport) String() string {
+	return fmt.Sprintf("%s %s: %d chats checked in %s, %d active, %d kicked or stopped, %d deactivated, %d migrated, %d errors",
+		r.StartedAt.UTC().Format("2006-01-02 15:04"), r.Service, r.Checked, r.Duration.Round(time.Second), r.Active, r.Kicked, r.Deactivated, r.Migrated, r.Errors)
+}
+
+// adminReconcileChats: /integram reconcile [run]
+func adminReconcileChats(c *Context, args []string) (string, error) {
+	if len(args) > 0 {
+		if args[0] != "run" {
+			return "", fmt.Errorf("Unknown argument '%s'. Usage: reconcile [run]", args[0])
+		}
+
+		s := c.Service()
+		if s == nil {
+			return "", errors.New("Service must be specified")
+		}
+
+		reconcileReportsMutex.Lock()
+		running := reconcileRunning[s.Name]
+		reconcileReportsMutex.Unlock()
+
+		if running {
+			return "", errors.New("Reconciliation is already running for the service")
+		}
+
+		// may take hours for the large installations because of the Bot API limits
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("adminReconcileChats panic recovered %v", r)
+				}
+			}()
+
+			session := mongoSession.Clone()
+			defer session.Close()
+
+			report, err := reconcileChats(session.DB(mongo.Database), s, time.Now())
+			if err != nil {
+				log.WithError(err).WithField("service", s.Name).Error("adminReconcileChats: can't reconcile the chats")
+				return
+			}
+			log.WithField("service", s.Name).Infof("adminReconcileChats: %s", report.String())
+		}()
+
+		return "Reconciliation started. Run 'reconcile' to see the report when it's finished", nil
+	}
+
+	reconcileReportsMutex.Lock()
+	defer reconcileReportsMutex.Unlock()
+
+	if len(reconcileReports) == 0 {
+		return "No chats reconciled yet", nil
+	}
+
+	var lines []string
+	for _, r := range reconcileReports {
+		lines = append(lines, r.String())
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
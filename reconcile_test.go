@@ -0,0 +1,29 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_chatMembershipStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		member *tg.ChatMember
+		err    error
+		want   string
+	}{
+		{"private chat ping", nil, nil, reconcileStatusActive},
+		{"member", &tg.ChatMember{Status: "member"}, nil, reconcileStatusActive},
+		{"administrator", &tg.ChatMember{Status: "administrator"}, nil, reconcileStatusActive},
+		{"left", &tg.ChatMember{Status: "left"}, nil, reconcileStatusKicked},
+		{"kicked", &tg.ChatMember{Status: "kicked"}, nil, reconcileStatusKicked},
+		{"network error", nil, errors.New("timeout"), reconcileStatusUnknown},
+	}
+	for _, tt := range tests {
+		if got := chatMembershipStatus(tt.member, tt.err); got != tt.want {
+			t.Errorf("%q. chatMembershipStatus() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+)
+
+// renderCommand is the CLI mode that runs the service's webhook handler against the fixture offline and prints the messages instead of sending, e.g.:
+//
+//	gitlab render --service gitlab --fixture push.json --header "X-Gitlab-Event: Push Hook"
+const renderCommand = "render"
+
+// renderMode is set when the binary is started with the render command. MongoDB, Redis and Telegram aren't connected then
+var renderMode = len(os.Args) > 1 && os.Args[1] == renderCommand
+
+// TelegramCaptionMaxLength is the max length of the file's caption
+const TelegramCaptionMaxLength = 1024
+
+const (
+	inlineButtonDataMaxLength = 64
+	inlineKeyboardRowMaxWidth = 8
+)
+
+// used for the offline render instead of the service's bot
+const renderBotID = 1
+
+// tags supported by Telegram in the HTML parse mode. Others are removed by prepare
+var telegramHTMLTags = []string{"a", "b", "strong", "i", "em", "code", "pre"}
+
+var htmlTagRE = regexp.MustCompile(`</?([a-zA-Z][a-zA-Z0-9]*)[^>]*>`)
+
+// messageEntity is the formatting entity of the message's text as Telegram parses it. Offset and Length are in UTF-16 code units
+type messageEntity struct {
+	Type   string
+	Offset int
+	Length int
+	URL    string
+}
+
+// renderedMessage is the message captured by the offline render
+type renderedMessage struct {
+	Message  *OutgoingMessage // prepared message as it would be sent
+	Warnings []string
+}
+
+// renderMessageSender captures the messages instead of sending
+type renderMessageSender struct {
+	messages *[]renderedMessage
+}
+
+func (s renderMessageSender) Send(m *OutgoingMessage) error {
+	if m.processed {
+		return nil
+	}
+
+	raw := *m
+	err := m.prepare()
+	if err != nil {
+		return err
+	}
+
+	m.processed = true
+	*s.messages = append(*s.messages, renderedMessage{Message: m, Warnings: lintMessage(&raw, m)})
+	return nil
+}
+
+type renderHeaders []string
+
+func (h *renderHeaders) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *renderHeaders) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return errors.New("header must be in the 'Name: value' form")
+	}
+
+	*h = append(*h, s)
+	return nil
+}
+
+// renderCLI parses the render command's args, renders the fixture and prints the result. Returns the exit code
+func renderCLI(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet(renderCommand, flag.ContinueOnError)
+	fs.SetOutput(out)
+
+	serviceName := fs.String("service", "", "service name, e.g. gitlab")
+	fixture := fs.String("fixture", "", "path to the file with the webhook's body")
+	method := fs.String("method", "POST", "webhook's HTTP method")
+	query := fs.String("query", "", "webhook's URL query, e.g. 'a=1&b=2'")
+	userID := fs.Int64("user", 1, "ID of the webhook's user")
+	chatID := fs.Int64("chat", 0, "ID of the chat to render the messages for. Negative for the groups, user's private chat by default")
+	userSettings := fs.String("user-settings", "", "path to the JSON file with the user's settings for the service")
+	chatSettings := fs.String("chat-settings", "", "path to the JSON file with the chat's settings for the service")
+
+	var headers renderHeaders
+	fs.Var(&headers, "header", "webhook's header in the 'Name: value' form. Can be repeated")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *serviceName == "" || *fixture == "" {
+		fmt.Fprintln(out, "--service and --fixture are required")
+		fs.PrintDefaults()
+		return 2
+	}
+
+	s, exists := services[*serviceName]
+	if !exists {
+		fmt.Fprintf(out, "Service '%s' is not registered\n", *serviceName)
+		return 1
+	}
+
+	body, err := ioutil.ReadFile(*fixture)
+	if err != nil {
+		fmt.Fprintf(out, "Can't read the fixture: %s\n", err.Error())
+		return 1
+	}
+
+	target := "/" + s.Name + "/render"
+	if *query != "" {
+		target += "?" + *query
+	}
+
+	r := httptest.NewRequest(*method, target, strings.NewReader(string(body)))
+	for _, header := range headers {
+		kv := strings.SplitN(header, ":", 2)
+		r.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if r.Header.Get("Content-Type") == "" && len(body) > 0 && (body[0] == '{' || body[0] == '[') {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	if *chatID == 0 {
+		*chatID = *userID
+	}
+
+	user := userData{User: User{ID: *userID}}
+	chat := chatData{Chat: Chat{ID: *chatID}}
+
+	for _, fixtureSettings := range []struct {
+		path     string
+		settings *map[string]interface{}
+	}{{*userSettings, &user.Settings}, {*chatSettings, &chat.Settings}} {
+		if fixtureSettings.path == "" {
+			continue
+		}
+
+		settings, err := readRenderSettings(fixtureSettings.path)
+		if err != nil {
+			fmt.Fprintf(out, "Can't read the settings: %s\n", err.Error())
+			return 1
+		}
+		*fixtureSettings.settings = map[string]interface{}{s.Name: settings}
+	}
+
+	messages, err := renderFixture(s, r, user, chat)
+	if err != nil {
+		fmt.Fprintf(out, "WebhookHandler returned error: %s\n", err.Error())
+	}
+
+	printRenderedMessages(out, messages)
+
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// readRenderSettings reads the service's settings from the JSON file
+func readRenderSettings(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[string]interface{}
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// renderFixture runs the service's WebhookHandler with the request and returns the messages it would send.
+// Context is in-memory: the user and chat data is taken from the args and the handler has no DB access
+func renderFixture(s *Service, r *http.Request, user userData, chat chatData) (messages []renderedMessage, err error) {
+	if s.WebhookHandler == nil {
+		return nil, errors.New("service has no WebhookHandler")
+	}
+
+	if _, exists := botPerService[s.Name]; !exists {
+		botPerService[s.Name] = &Bot{ID: renderBotID, Username: s.Name + "_bot", services: []*Service{s}}
+	}
+
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = r
+
+	ctx := &Context{gin: gc, ServiceName: s.Name, readOnly: true}
+	ctx.User = User{ID: user.ID, ctx: ctx}
+	ctx.User.setData(&user)
+	ctx.Chat = Chat{ID: chat.ID, ctx: ctx, data: &chat}
+
+	wctx := &WebhookContext{gin: gc, requestID: rndStr.Get(10), receivedAt: time.Now()}
+	ctx.requestID = wctx.requestID
+
+	prevSender := activeMessageSender
+	activeMessageSender = renderMessageSender{messages: &messages}
+	defer func() { activeMessageSender = prevSender }()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("WebhookHandler panicked, it may need the DB that isn't available offline: %v", r)
+		}
+	}()
+
+	err = s.WebhookHandler(ctx, wctx)
+	return messages, err
+}
+
+func printRenderedMessages(out io.Writer, messages []renderedMessage) {
+	if len(messages) == 0 {
+		fmt.Fprintln(out, "No messages")
+		return
+	}
+
+	for i, rm := range messages {
+		m := rm.Message
+		text, entities, _ := parseMessageEntities(m.Text, m.ParseMode)
+
+		fmt.Fprintf(out, "#%d to chat %d\n", i+1, m.ChatID)
+
+		parseMode := m.ParseMode
+		if parseMode == "" {
+			parseMode = "none"
+		}
+		fmt.Fprintf(out, "Parse mode: %s\n", parseMode)
+
+		if m.FilePath != "" || m.FileID != "" {
+			fmt.Fprintf(out, "File: %s%s (%s)\n", m.FilePath, m.FileID, m.FileType)
+		}
+
+		fmt.Fprintf(out, "Text (%d chars):\n%s\n", utf8.RuneCountInString(text), m.Text)
+
+		if len(entities) > 0 {
+			fmt.Fprintln(out, "Entities:")
+			for _, e := range entities {
+				fmt.Fprintf(out, "  %s %d+%d %s\n", e.Type, e.Offset, e.Length, e.URL)
+			}
+		}
+
+		if rows := m.InlineKeyboardMarkup.tg(); len(rows) > 0 {
+			fmt.Fprintln(out, "Keyboard:")
+			for _, row := range rows {
+				var buttons []string
+				for _, b := range row {
+					switch {
+					case b.URL != nil:
+						buttons = append(buttons, fmt.Sprintf("[%s → %s]", b.Text, *b.URL))
+					case b.CallbackData != nil:
+						buttons = append(buttons, fmt.Sprintf("[%s | %s]", b.Text, *b.CallbackData))
+					default:
+						buttons = append(buttons, fmt.Sprintf("[%s]", b.Text))
+					}
+				}
+				fmt.Fprintf(out, "  %s\n", strings.Join(buttons, " "))
+			}
+		}
+
+		if len(rm.Warnings) > 0 {
+			fmt.Fprintln(out, "Warnings:")
+			for _, w := range rm.Warnings {
+				fmt.Fprintf(out, "  ⚠️ %s\n", w)
+			}
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// lintMessage returns warnings about the message that may be cut, rejected or rendered not as expected by Telegram. raw is the message before prepare
+func lintMessage(raw, prepared *OutgoingMessage) []string {
+	var warnings []string
+
+	if raw.ParseMode == "HTML" {
+		var unsupported []string
+		for _, match := range htmlTagRE.FindAllStringSubmatch(raw.Text, -1) {
+			tag := strings.ToLower(match[1])
+			if !SliceContainsString(telegramHTMLTags, tag) && !SliceContainsString(unsupported, tag) {
+				unsupported = append(unsupported, tag)
+			}
+		}
+
+		if len(unsupported) > 0 {
+			warnings = append(warnings, fmt.Sprintf("unsupported HTML tags will be removed: %s", strings.Join(unsupported, ", ")))
+		}
+	} else if raw.ParseMode == "" && htmlTagRE.MatchString(raw.Text) {
+		warnings = append(warnings, "text contains HTML tags, but the parse mode is not set")
+	}
+
+	text, _, err := parseMessageEntities(prepared.Text, prepared.ParseMode)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("Telegram can't parse the %s: %s", prepared.ParseMode, err.Error()))
+	}
+
+	maxLength := TelegramMessageMaxLength
+	if prepared.FilePath != "" || prepared.FileID != "" {
+		maxLength = TelegramCaptionMaxLength
+	}
+
+	if l := utf8.RuneCountInString(text); l > maxLength {
+		warnings = append(warnings, fmt.Sprintf("text is too long: %d chars, max %d", l, maxLength))
+	} else if l == 0 && prepared.FilePath == "" && prepared.FileID == "" && prepared.Location == nil {
+		warnings = append(warnings, "text is empty")
+	}
+
+	for i, row := range prepared.InlineKeyboardMarkup.Buttons {
+		if len(row) > inlineKeyboardRowMaxWidth {
+			warnings = append(warnings, fmt.Sprintf("keyboard row %d has %d buttons, max %d", i+1, len(row), inlineKeyboardRowMaxWidth))
+		}
+
+		for _, button := range row {
+			if strings.TrimSpace(button.Text) == "" {
+				warnings = append(warnings, fmt.Sprintf("keyboard row %d has the button with empty text", i+1))
+			}
+
+			if len(button.Data) > inlineButtonDataMaxLength {
+				warnings = append(warnings, fmt.Sprintf("button '%s' data is %d bytes, max %d", button.Text, len(button.Data), inlineButtonDataMaxLength))
+			}
+
+			if button.Data != "" && button.URL == "" && prepared.OnCallbackAction == "" {
+				warnings = append(warnings, fmt.Sprintf("button '%s' has the callback data, but the message has no callback action", button.Text))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// parseMessageEntities returns the text without the formatting and its entities
+func parseMessageEntities(text, parseMode string) (string, []messageEntity, error) {
+	switch parseMode {
+	case "HTML":
+		return parseHTMLEntities(text)
+	case "Markdown":
+		return parseMarkdownEntities(text)
+	case ParseModeMarkdownV2:
+		return parseMarkdownV2Entities(text)
+	}
+
+	return text, nil, nil
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+var htmlEntityTypes = map[string]string{"b": "bold", "strong": "bold", "i": "italic", "em": "italic", "a": "text_link", "code": "code", "pre": "pre"}
+
+func parseHTMLEntities(text string) (string, []messageEntity, error) {
+	var plain bytes.Buffer
+	var entities []messageEntity
+	var opened []messageEntity
+	var openedTags []string
+
+	z := html.NewTokenizer(strings.NewReader(text))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return plain.String(), entities, z.Err()
+			}
+
+			if len(openedTags) > 0 {
+				return plain.String(), entities, fmt.Errorf("tag <%s> is not closed", openedTags[len(openedTags)-1])
+			}
+			return plain.String(), entities, nil
+		case html.TextToken:
+			plain.WriteString(html.UnescapeString(string(z.Raw())))
+		case html.StartTagToken:
+			t := z.Token()
+			entityType, ok := htmlEntityTypes[t.Data]
+			if !ok {
+				return plain.String(), entities, fmt.Errorf("unsupported start tag <%s>", t.Data)
+			}
+
+			e := messageEntity{Type: entityType, Offset: utf16Len(plain.String())}
+			if t.Data == "a" {
+				e.URL = htmlAttr(t, "href")
+			}
+			opened = append(opened, e)
+			openedTags = append(openedTags, t.Data)
+		case html.EndTagToken:
+			t := z.Token()
+			if len(openedTags) == 0 || openedTags[len(openedTags)-1] != t.Data {
+				return plain.String(), entities, fmt.Errorf("unexpected end tag </%s>", t.Data)
+			}
+
+			e := opened[len(opened)-1]
+			e.Length = utf16Len(plain.String()) - e.Offset
+			entities = append(entities, e)
+			opened = opened[:len(opened)-1]
+			openedTags = openedTags[:len(openedTags)-1]
+		case html.SelfClosingTagToken:
+			return plain.String(), entities, fmt.Errorf("unsupported tag <%s/>", z.Token().Data)
+		}
+	}
+}
+
+var markdownEntityTypes = map[string]string{"*": "bold", "_": "italic", "`": "code", "```": "pre"}
+
+var markdownLinkRE = regexp.MustCompile(`^\[([^\]]*)\]\(([^)]*)\)`)
+
+func parseMarkdownEntities(text string) (string, []messageEntity, error) {
+	var plain bytes.Buffer
+	var entities []messageEntity
+
+	for i := 0; i < len(text); {
+		if m := markdownLinkRE.FindStringSubmatch(text[i:]); m != nil {
+			entities = append(entities, messageEntity{Type: "text_link", Offset: utf16Len(plain.String()), Length: utf16Len(m[1]), URL: m[2]})
+			plain.WriteString(m[1])
+			i += len(m[0])
+			continue
+		}
+
+		marker := text[i : i+1]
+		if strings.HasPrefix(text[i:], "```") {
+			marker = "```"
+		}
+
+		entityType, ok := markdownEntityTypes[marker]
+		if !ok {
+			plain.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		end := strings.Index(text[i+len(marker):], marker)
+		if end == -1 {
+			return plain.String(), entities, fmt.Errorf("can't find end of the entity starting at byte offset %d", i)
+		}
+
+		content := text[i+len(marker) : i+len(marker)+end]
+		entities = append(entities, messageEntity{Type: entityType, Offset: utf16Len(plain.String()), Length: utf16Len(content)})
+		plain.WriteString(content)
+		i += len(marker)*2 + end
+	}
+
+	return plain.String(), entities, nil
+}
@@ -0,0 +1,120 @@
+package integram
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseMessageEntities(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		parseMode string
+		wantText  string
+		want      []messageEntity
+		wantErr   bool
+	}{
+		{"plain", "<b>hi</b>", "", "<b>hi</b>", nil, false},
+		{"html", `<b>Push</b> to <a href="https://gitlab.com">repo</a> &amp; 🚀 <i>ok</i>`, "HTML", "Push to repo & 🚀 ok", []messageEntity{{"bold", 0, 4, ""}, {"text_link", 8, 4, "https://gitlab.com"}, {"italic", 18, 2, ""}}, false},
+		{"html not closed", "<b>Push", "HTML", "Push", nil, true},
+		{"html wrong end tag", "<b>Push</i>", "HTML", "Push", nil, true},
+		{"html unsupported tag", "<div>Push</div>", "HTML", "", nil, true},
+		{"markdown", "*Push* to [repo](https://gitlab.com) `x`", "Markdown", "Push to repo x", []messageEntity{{"bold", 0, 4, ""}, {"text_link", 8, 4, "https://gitlab.com"}, {"code", 13, 1, ""}}, false},
+		{"markdown pre", "```a*b```", "Markdown", "a*b", []messageEntity{{"pre", 0, 3, ""}}, false},
+		{"markdown not closed", "snake_case", "Markdown", "snake", nil, true},
+		{"markdownv2", "*Push* to [repo](https://gitlab.com/a\\)b) __u__ ~s~ ||x|| v1\\.2", ParseModeMarkdownV2, "Push to repo u s x v1.2", []messageEntity{{"bold", 0, 4, ""}, {"text_link", 8, 4, "https://gitlab.com/a)b"}, {"underline", 13, 1, ""}, {"strikethrough", 15, 1, ""}, {"spoiler", 17, 1, ""}}, false},
+		{"markdownv2 nested", "*bold _italic_*", ParseModeMarkdownV2, "bold italic", []messageEntity{{"italic", 5, 6, ""}, {"bold", 0, 11, ""}}, false},
+		{"markdownv2 pre", "```go\nfmt.Println(`a\\`)```", ParseModeMarkdownV2, "fmt.Println(`a`)", []messageEntity{{"pre", 0, 16, ""}}, false},
+		{"markdownv2 code", "`a_b\\\\`", ParseModeMarkdownV2, "a_b\\", []messageEntity{{"code", 0, 4, ""}}, false},
+		{"markdownv2 reserved", "v1.2", ParseModeMarkdownV2, "v1", nil, true},
+		{"markdownv2 not closed", "*bold", ParseModeMarkdownV2, "bold", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := parseMessageEntities(tt.text, tt.parseMode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMessageEntities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if text != tt.wantText {
+				t.Errorf("parseMessageEntities() text = %q, want %q", text, tt.wantText)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(entities, tt.want) {
+				t.Errorf("parseMessageEntities() entities = %v, want %v", entities, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lintMessage(t *testing.T) {
+	msg := func(text, parseMode string, buttons ...InlineButtons) *OutgoingMessage {
+		m := &OutgoingMessage{ParseMode: parseMode, InlineKeyboardMarkup: InlineKeyboard{Buttons: buttons}}
+		m.Text = text
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		raw      *OutgoingMessage
+		prepared *OutgoingMessage
+		want     []string
+	}{
+		{"ok", msg("<b>ok</b>", "HTML"), msg("<b>ok</b>", "HTML"), nil},
+		{"unsupported tags", msg("<h1>ok</h1><b>ok</b><br/>", "HTML"), msg("ok<b>ok</b>", "HTML"), []string{"unsupported HTML tags will be removed: h1, br"}},
+		{"no parse mode", msg("<b>ok</b>", ""), msg("<b>ok</b>", ""), []string{"text contains HTML tags, but the parse mode is not set"}},
+		{"too long", msg(strings.Repeat("a", TelegramMessageMaxLength+1), ""), msg(strings.Repeat("a", TelegramMessageMaxLength+1), ""), []string{"text is too long: 4097 chars, max 4096"}},
+		{"bad markdown", msg("snake_case", "Markdown"), msg("snake_case", "Markdown"), []string{"Telegram can't parse the Markdown: can't find end of the entity starting at byte offset 5"}},
+		{"keyboard", msg("ok", ""), msg("ok", "", InlineButtons{{Text: "", URL: "https://a"}, {Text: "Merge", Data: strings.Repeat("d", 65)}}), []string{
+			"keyboard row 1 has the button with empty text",
+			"button 'Merge' data is 65 bytes, max 64",
+			"button 'Merge' has the callback data, but the message has no callback action",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lintMessage(tt.raw, tt.prepared); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lintMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderFixture(t *testing.T) {
+	s := &Service{Name: "renderservice", WebhookHandler: func(c *Context, wc *WebhookContext) error {
+		var settings struct{ Prefix string }
+		if err := c.Chat.Settings(&settings); err != nil {
+			return err
+		}
+
+		var body struct{ Text string }
+		if err := wc.JSON(&body); err != nil {
+			return err
+		}
+
+		return c.NewMessage().SetText(settings.Prefix + body.Text).Send()
+	}}
+
+	serviceMapMutex.Lock()
+	services[s.Name] = s
+	serviceMapMutex.Unlock()
+	defer func() {
+		serviceMapMutex.Lock()
+		delete(services, s.Name)
+		serviceMapMutex.Unlock()
+		delete(botPerService, s.Name)
+	}()
+
+	r := httptest.NewRequest("POST", "/renderservice/render", strings.NewReader(`{"text":"pushed"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	chat := chatData{Chat: Chat{ID: -100123}, Settings: map[string]interface{}{s.Name: map[string]interface{}{"prefix": "gitlab: "}}}
+	messages, err := renderFixture(s, r, userData{User: User{ID: 1}}, chat)
+	if err != nil {
+		t.Fatalf("renderFixture() error = %v", err)
+	}
+
+	if len(messages) != 1 || messages[0].Message.ChatID != -100123 || messages[0].Message.Text != "gitlab: pushed" {
+		t.Errorf("renderFixture() = %+v, want one message \"gitlab: pushed\" to -100123", messages)
+	}
+}
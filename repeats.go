@@ -0,0 +1,100 @@
+package integram
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// messageRepeat is the streak of the identical notifications collapsed into the first message
+type messageRepeat struct {
+	Hash  string    `bson:"h"` // text hash of the repeated notification
+	Count int       `bson:"n"`
+	First time.Time `bson:"f"`
+	Last  time.Time `bson:"l"`
+}
+
+// SetKeepRepeated sends the notification as the new message even if it is identical to the previous one
+func (m *OutgoingMessage) SetKeepRepeated(b bool) *OutgoingMessage {
+	m.KeepRepeated = b
+	return m
+}
+
+// nextMessageRepeat returns the streak continued by the notification with the text hash or nil if the last message doesn't repeat it or the quiet period passed
+func nextMessageRepeat(last *OutgoingMessage, hash string, now time.Time, quietPeriod time.Duration) *messageRepeat {
+	if last.Repeat == nil {
+		if last.TextHash != hash || now.Sub(last.Date) > quietPeriod {
+			return nil
+		}
+		return &messageRepeat{Hash: hash, Count: 2, First: last.Date, Last: now}
+	}
+
+	if last.Repeat.Hash != hash || now.Sub(last.Repeat.Last) > quietPeriod {
+		return nil
+	}
+
+	r := *last.Repeat
+	r.Count++
+	r.Last = now
+	return &r
+}
+
+// repeatedMessageText appends the counter and the time of the first and the last occurrence in the recipient's timezone
+func repeatedMessageText(text string, r messageRepeat, recipient Recipient) string {
+	first, last := recipient.In(r.First), recipient.In(r.Last)
+
+	layout := "15:04"
+	if first.YearDay() != last.YearDay() || first.Year() != last.Year() {
+		layout = "Jan 2 15:04"
+	}
+
+	return fmt.Sprintf("%s\n\n🔁 ×%d · first %s, last %s", text, r.Count, first.Format(layout), last.Format(layout))
+}
+
+// collapseRepeated edits the last message in the chat with the counter instead of sending the identical notification again. Returns true if the message was collapsed
+func (m *OutgoingMessage) collapseRepeated(db *mgo.Database) bool {
+	period := Config.RepeatedNotificationsPeriod
+	if period <= 0 || m.KeepRepeated || m.SendAfter != nil || !m.isNotification() || m.Text == "" || m.FilePath != "" || m.FileID != "" || m.Location != nil || m.ReplyToMsgID != 0 || len(m.KeyboardMarkup) > 0 || m.ForceReply {
+		return false
+	}
+
+	last, err := findLastOutgoingMessageInChat(db, m.BotID, m.ChatID)
+	if err != nil || last.om.MsgID == 0 || last.om.FileType != "" || last.om.MediaGroupID != "" {
+		return false
+	}
+
+	if !whetherTGInlineKeyboardsAreEqual(last.om.InlineKeyboardMarkup.tg(), m.InlineKeyboardMarkup.tg()) {
+		return false
+	}
+
+	r := nextMessageRepeat(last.om, m.GetTextHash(), time.Now(), period)
+	if r == nil {
+		return false
+	}
+
+	// streak can be continued by the concurrent notification, update it only if it wasn't changed
+	err = db.C("messages").Update(bson.M{"_id": last.om.ID, "repeat": last.om.Repeat}, bson.M{"$set": bson.M{"repeat": r}})
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			log.WithError(err).WithField("chat", m.ChatID).Error("Can't save the repeated notification counter")
+		}
+		return false
+	}
+
+	recipient, _ := findRecipient(db, m.ChatID)
+	err = m.ctx.EditMessageText(last.om, repeatedMessageText(m.Text, *r, recipient))
+	if err != nil {
+		// message is probably deleted by the chat, so start the new streak
+		log.WithError(err).WithField("chat", m.ChatID).Warn("Can't edit the repeated notification, sending it again")
+		db.C("messages").UpdateId(last.om.ID, bson.M{"$unset": bson.M{"repeat": ""}})
+		return false
+	}
+
+	m.ID = last.om.ID
+	m.MsgID = last.om.MsgID
+	m.Repeat = r
+	return true
+}
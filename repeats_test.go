@@ -0,0 +1,59 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_nextMessageRepeat(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+	first := now.Add(-time.Minute * 30)
+
+	sent := func(hash string, date time.Time, r *messageRepeat) *OutgoingMessage {
+		m := &OutgoingMessage{Repeat: r}
+		m.TextHash = hash
+		m.Date = date
+		return m
+	}
+
+	tests := []struct {
+		name string
+		last *OutgoingMessage
+		hash string
+		want *messageRepeat
+	}{
+		{"second occurrence", sent("abc", first, nil), "abc", &messageRepeat{Hash: "abc", Count: 2, First: first, Last: now}},
+		{"other text", sent("abc", first, nil), "def", nil},
+		{"after quiet period", sent("abc", now.Add(-time.Hour*2), nil), "abc", nil},
+		{"streak continued", sent("edited", first, &messageRepeat{Hash: "abc", Count: 3, First: first, Last: now.Add(-time.Minute)}), "abc", &messageRepeat{Hash: "abc", Count: 4, First: first, Last: now}},
+		{"streak reset", sent("edited", first, &messageRepeat{Hash: "abc", Count: 3, First: first, Last: now.Add(-time.Hour * 2)}), "abc", nil},
+		{"edited text isn't repeated", sent("edited", first, &messageRepeat{Hash: "abc", Count: 3, First: first, Last: now}), "edited", nil},
+	}
+	for _, tt := range tests {
+		if got := nextMessageRepeat(tt.last, tt.hash, now, time.Hour); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. nextMessageRepeat() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_repeatedMessageText(t *testing.T) {
+	first := time.Date(2018, 5, 10, 9, 5, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		last time.Time
+		tz   string
+		want string
+	}{
+		{"same day", first.Add(time.Hour), "", "Monitor is down\n\n🔁 ×3 · first 09:05, last 10:05"},
+		{"recipient's timezone", first.Add(time.Hour), "Europe/Moscow", "Monitor is down\n\n🔁 ×3 · first 12:05, last 13:05"},
+		{"next day", first.Add(time.Hour * 20), "", "Monitor is down\n\n🔁 ×3 · first May 10 09:05, last May 11 05:05"},
+	}
+	for _, tt := range tests {
+		got := repeatedMessageText("Monitor is down", messageRepeat{Count: 3, First: first, Last: tt.last}, newRecipient(1, "", tt.tz))
+		if got != tt.want {
+			t.Errorf("%q. repeatedMessageText() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
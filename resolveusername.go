@@ -0,0 +1,280 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	usernameCacheTTL         = 10 * time.Minute
+	usernameNotFoundCacheTTL = time.Minute
+
+	// Bot API lookups per bot: one in usernameLookupInterval with the burst
+	usernameLookupInterval = 3 * time.Second
+	usernameLookupBurst    = 5
+
+	usernameLookupMinBackoff = 30 * time.Second
+	usernameLookupMaxBackoff = time.Hour
+)
+
+// max number of the stored users with the same username checked for the chat membership
+const usernameMaxCandidates = 5
+
+// Telegram usernames are 5-32 chars, case-insensitive
+var usernameRE = regexp.MustCompile(`^@?([a-zA-Z0-9_]{5,32})$`)
+
+// ErrUsernameNotFound returned by Context.ResolveUsername when the user is unknown to the bot
+var ErrUsernameNotFound = errors.New("username not found")
+
+var resolvedUsernames = &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+var usernameLookups = &usernameLookupLimiter{buckets: make(map[int64]*rateBucket), backoff: make(map[int64]usernameLookupBackoff)}
+
+// ResolvedUser is the Telegram user found by the username
+type ResolvedUser struct {
+	User
+	Source     string            // where the user was found: "chat" for the current chat's members, "users" for the users known to the bot or "api"
+	Identities map[string]string // upstream identities per service, e.g. {"github": "bob"}. See Service.UserIdentity
+}
+
+// usernameLookupBackoff pauses the bot's API lookups after Telegram's errors
+type usernameLookupBackoff struct {
+	until time.Time
+	delay time.Duration
+}
+
+// usernameLookupLimiter limits the Bot API lookups of the usernames per bot
+type usernameLookupLimiter struct {
+	mu      sync.Mutex
+	buckets map[int64]*rateBucket
+	backoff map[int64]usernameLookupBackoff
+}
+
+// allow returns true and takes the slot if the bot can make the lookup now
+func (l *usernameLookupLimiter) allow(botID int64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.backoff[botID].until) {
+		return false
+	}
+
+	b, exists := l.buckets[botID]
+	if !exists {
+		b = &rateBucket{interval: usernameLookupInterval, burst: usernameLookupBurst}
+		l.buckets[botID] = b
+	}
+
+	tat := b.tat
+	if b.reserve(now).After(now) {
+		// give the slot back
+		b.tat = tat
+		return false
+	}
+	return true
+}
+
+// failed doubles the bot's backoff, but not less than Telegram's retry_after
+func (l *usernameLookupLimiter) failed(botID int64, now time.Time, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delay := l.backoff[botID].delay * 2
+	if delay < usernameLookupMinBackoff {
+		delay = usernameLookupMinBackoff
+	}
+	if delay < retryAfter {
+		delay = retryAfter
+	}
+	if delay > usernameLookupMaxBackoff {
+		delay = usernameLookupMaxBackoff
+	}
+
+	l.backoff[botID] = usernameLookupBackoff{until: now.Add(delay), delay: delay}
+}
+
+func (l *usernameLookupLimiter) succeeded(botID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.backoff, botID)
+}
+
+// normalizeUsername returns the username without @ or false if it isn't the valid Telegram username
+func normalizeUsername(username string) (string, bool) {
+	match := usernameRE.FindStringSubmatch(strings.TrimSpace(username))
+	if len(match) < 2 {
+		return "", false
+	}
+	return match[1], true
+}
+
+func resolvedUsernameCacheKey(botID int64, chatID int64, username string) string {
+	return fmt.Sprintf("username:%d:%d:%s", botID, chatID, strings.ToLower(username))
+}
+
+// ResolveUsername returns the user by the "@username", e.g. to process "/assign @bob". Users stored in the DB are checked first, the current chat's members preferred.
+// Then Bot API is asked where possible. Results are cached, API lookups are rate-limited. Returns ErrUsernameNotFound if the user is unknown
+func (c *Context) ResolveUsername(username string) (*ResolvedUser, error) {
+	name, ok := normalizeUsername(username)
+	if !ok {
+		return nil, ErrUsernameNotFound
+	}
+
+	bot := c.Bot()
+	if bot == nil {
+		return nil, errors.New("ResolveUsername: bot not found")
+	}
+
+	key := resolvedUsernameCacheKey(bot.ID, c.Chat.ID, name)
+	if val, exists := resolvedUsernames.get(key); exists {
+		if val == nil {
+			return nil, ErrUsernameNotFound
+		}
+		res := *val.(*ResolvedUser)
+		return &res, nil
+	}
+
+	res, err := c.resolveStoredUsername(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if res == nil {
+		var final bool
+		res, final, err = c.resolveUsernameWithAPI(bot, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if res == nil {
+			if final {
+				resolvedUsernames.set(key, nil, usernameNotFoundCacheTTL)
+			}
+			return nil, ErrUsernameNotFound
+		}
+	}
+
+	res.Identities = c.userIdentities(res.User)
+	resolvedUsernames.set(key, res, usernameCacheTTL)
+
+	cp := *res
+	return &cp, nil
+}
+
+// resolveStoredUsername returns the user with the username stored in the DB or nil if not found
+func (c *Context) resolveStoredUsername(username string) (*ResolvedUser, error) {
+	var users []User
+	// usernames are case-insensitive but stored as the user set it, so match the lowercased copy
+	err := c.db.C("users").Find(bson.M{"usernamelower": strings.ToLower(username)}).Select(bson.M{"firstname": 1, "lastname": 1, "username": 1, "tz": 1, "lang": 1}).Limit(usernameMaxCandidates).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	if c.Chat.IsGroup() {
+		if u := c.chatMemberByUsername(users); u != nil {
+			return &ResolvedUser{User: *u, Source: "chat"}, nil
+		}
+	}
+
+	// the same username may be stored for the several users if they swapped usernames
+	return &ResolvedUser{User: users[0], Source: "users"}, nil
+}
+
+// chatMemberByUsername returns the user which is the member of the current chat
+func (c *Context) chatMemberByUsername(users []User) *User {
+	if d, _ := c.Chat.getData(); d != nil {
+		for i := range users {
+			for _, memberID := range d.MembersIDs {
+				if memberID == users[i].ID {
+					return &users[i]
+				}
+			}
+		}
+	}
+
+	for i := range users {
+		member, err := c.GetChatMember(c.Chat.ID, users[i].ID)
+		if err != nil || member.User == nil || member.HasLeft() || member.WasKicked() {
+			continue
+		}
+
+		if strings.EqualFold(member.User.UserName, users[i].UserName) {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// resolveUsernameWithAPI asks Telegram for the user with the username. final is false if the lookup wasn't made because of the limits
+func (c *Context) resolveUsernameWithAPI(bot *Bot, username string) (res *ResolvedUser, final bool, err error) {
+	if !usernameLookups.allow(bot.ID, time.Now()) {
+		return nil, false, nil
+	}
+
+	chat, err := bot.API.GetChat(tg.ChatConfig{SuperGroupUsername: "@" + username})
+	if err != nil {
+		if tgErr, ok := err.(tg.Error); ok && tgErr.Code == 400 {
+			// unknown username
+			usernameLookups.succeeded(bot.ID)
+			return nil, true, nil
+		}
+
+		retryAfter := time.Duration(0)
+		if tgErr, ok := err.(tg.Error); ok && tgErr.Parameters != nil {
+			retryAfter = time.Duration(tgErr.Parameters.RetryAfter) * time.Second
+		}
+		usernameLookups.failed(bot.ID, time.Now(), retryAfter)
+		c.Log().WithError(err).WithField("username", username).Warn("ResolveUsername: API lookup failed")
+		return nil, false, nil
+	}
+	usernameLookups.succeeded(bot.ID)
+
+	// getChat resolves public groups and channels as well
+	if chat.Type != "private" {
+		return nil, true, nil
+	}
+
+	return &ResolvedUser{User: User{ID: chat.ID, FirstName: chat.FirstName, LastName: chat.LastName, UserName: chat.UserName}, Source: "api"}, true, nil
+}
+
+// userIdentities returns the user's identities in the services which define Service.UserIdentity
+func (c *Context) userIdentities(user User) map[string]string {
+	serviceMapMutex.RLock()
+	var withIdentity []*Service
+	for _, s := range services {
+		if s.UserIdentity != nil {
+			withIdentity = append(withIdentity, s)
+		}
+	}
+	serviceMapMutex.RUnlock()
+
+	identities := make(map[string]string)
+	for _, s := range withIdentity {
+		ctx := &Context{db: c.db, ServiceName: s.Name, Chat: c.Chat, User: user, requestID: c.requestID}
+		ctx.Chat.ctx = ctx
+		ctx.User.ctx = ctx
+
+		identity, err := s.UserIdentity(ctx)
+		if err != nil {
+			c.Log().WithError(err).WithField("service", s.Name).Error("ResolveUsername: can't get the user's identity")
+			continue
+		}
+
+		if identity != "" {
+			identities[s.Name] = identity
+		}
+	}
+	return identities
+}
@@ -0,0 +1,75 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_normalizeUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		want     string
+		wantOK   bool
+	}{
+		{"with at", "@bob_smith", "bob_smith", true},
+		{"without at", "bob_smith", "bob_smith", true},
+		{"spaces", " @BobSmith ", "BobSmith", true},
+		{"too short", "@bob", "", false},
+		{"too long", "@" + "b123456789b123456789b123456789xyz", "", false},
+		{"wrong chars", "@bob-smith", "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeUsername(tt.username)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("normalizeUsername() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_usernameLookupLimiter(t *testing.T) {
+	l := &usernameLookupLimiter{buckets: make(map[int64]*rateBucket), backoff: make(map[int64]usernameLookupBackoff)}
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < usernameLookupBurst; i++ {
+		if !l.allow(1111, now) {
+			t.Fatalf("allow() #%d within the burst = false, want true", i+1)
+		}
+	}
+
+	if l.allow(1111, now) {
+		t.Errorf("allow() above the burst = true, want false")
+	}
+
+	if !l.allow(2222, now) {
+		t.Errorf("allow() for the other bot = false, want true")
+	}
+
+	if !l.allow(1111, now.Add(usernameLookupInterval)) {
+		t.Errorf("allow() after the interval = false, want true")
+	}
+
+	now = now.Add(time.Hour)
+	l.failed(1111, now, 0)
+	if l.allow(1111, now.Add(usernameLookupMinBackoff-time.Second)) {
+		t.Errorf("allow() within the backoff = true, want false")
+	}
+
+	l.failed(1111, now, 0)
+	if got := l.backoff[1111].delay; got != 2*usernameLookupMinBackoff {
+		t.Errorf("backoff after the second failure = %v, want %v", got, 2*usernameLookupMinBackoff)
+	}
+
+	l.failed(1111, now, 10*time.Minute)
+	if got := l.backoff[1111].delay; got != 10*time.Minute {
+		t.Errorf("backoff with retry_after = %v, want %v", got, 10*time.Minute)
+	}
+
+	l.succeeded(1111)
+	if !l.allow(1111, now) {
+		t.Errorf("allow() after the success = false, want true")
+	}
+}
@@ -1,8 +1,30 @@
 package integram
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// TelegramMessageMaxLength is the max length of the message's text after entities parsing
+const TelegramMessageMaxLength = 4096
+
+// max width of the table's column. Longer values are truncated
+const tableColumnMaxWidth = 32
+
+// leave some space for the text around the table or diff
+const richTextBlockMaxLength = TelegramMessageMaxLength - 256
+
+const (
+	diffAddedPrefix   = "🟢"
+	diffRemovedPrefix = "🔴"
+	diffFilePrefix    = "📄 "
 )
 
 // MarkdownRichText produce Markdown that can be sent to Telegram. Not recommended to use because of tricky escaping
@@ -54,6 +76,16 @@ func (hrt HTMLRichText) Italic(text string) string {
 	return text
 }
 
+// Table generates <pre> with the columns aligned. Rows that don't fit the Telegram message are cut
+func (hrt HTMLRichText) Table(header []string, rows [][]string) string {
+	return hrt.Pre(renderTable(header, rows, richTextBlockMaxLength))
+}
+
+// Diff generates <pre> with the unified diff's lines prefixed with 🟢/🔴. Lines that don't fit the Telegram message are cut
+func (hrt HTMLRichText) Diff(diff string) string {
+	return hrt.Pre(renderDiff(diff, richTextBlockMaxLength))
+}
+
 // Pre generates```text```
 func (mrt MarkdownRichText) Pre(text string) string {
 	if text == "" {
@@ -72,6 +104,16 @@ func (mrt MarkdownRichText) Fixed(text string) string {
 	return "`" + repalcer.Replace(text) + "`"
 }
 
+// Table generates```table``` with the columns aligned. Rows that don't fit the Telegram message are cut
+func (mrt MarkdownRichText) Table(header []string, rows [][]string) string {
+	return mrt.Pre(renderTable(header, rows, richTextBlockMaxLength))
+}
+
+// Diff generates```diff``` with the unified diff's lines prefixed with 🟢/🔴. Lines that don't fit the Telegram message are cut
+func (mrt MarkdownRichText) Diff(diff string) string {
+	return mrt.Pre(renderDiff(diff, richTextBlockMaxLength))
+}
+
 // Esc escapes '[', ']', '(', ')', "`", "_", "*" with \
 func (mrt MarkdownRichText) Esc(s string) string {
 	repalcer := strings.NewReplacer("[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "`", "\\`", "_", "\\_", "*", "\\*")
@@ -102,3 +144,404 @@ func (mrt MarkdownRichText) Italic(text string) string {
 	repalcer := strings.NewReplacer("_", "＿")
 	return "_" + repalcer.Replace(text) + "_"
 }
+
+// FromHTML converts arbitrary upstream HTML into the Telegram-safe HTML: b, i, code, pre and links are kept,
+// headers become bold, lists become bullets and other tags are stripped. Images are returned as attachments
+func (hrt HTMLRichText) FromHTML(s string) (text string, images []Attachment) {
+	return convertHTML(s, false)
+}
+
+// FromHTML converts arbitrary upstream HTML into Telegram's Markdown. See HTMLRichText.FromHTML
+func (mrt MarkdownRichText) FromHTML(s string) (text string, images []Attachment) {
+	return convertHTML(s, true)
+}
+
+var htmlSpacesRE = regexp.MustCompile(`[ \t\r\n]+`)
+var htmlLineSpacesRE = regexp.MustCompile(`[ ]+\n`)
+var htmlExtraNewlinesRE = regexp.MustCompile(`\n{3,}`)
+
+// tags that are converted to the Telegram's formatting. Telegram doesn't support nested formatting
+var htmlFormatTags = map[string]string{"b": "b", "strong": "b", "h1": "b", "h2": "b", "h3": "b", "h4": "b", "h5": "b", "h6": "b", "i": "i", "em": "i", "code": "code", "pre": "pre", "a": "a"}
+
+// tags that start the new line (or paragraph)
+var htmlBlockTags = map[string]int{"p": 2, "h1": 2, "h2": 2, "h3": 2, "h4": 2, "h5": 2, "h6": 2, "pre": 2, "blockquote": 2, "table": 2, "div": 1, "tr": 1, "hr": 1, "section": 1, "article": 1}
+
+type htmlListState struct {
+	ordered bool
+	n       int
+}
+
+type htmlConverter struct {
+	markdown bool
+	out      bytes.Buffer
+	images   []Attachment
+
+	format string // currently opened formatting tag
+	href   string
+	depth  int // nesting depth of the format tag with the same name
+	buf    bytes.Buffer
+
+	lists []htmlListState
+	skip  int // inside script or style
+}
+
+func convertHTML(s string, markdown bool) (string, []Attachment) {
+	c := htmlConverter{markdown: markdown}
+	z := html.NewTokenizer(strings.NewReader(s))
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// unparsed tail will be returned as the text
+				c.text(string(z.Raw()))
+			}
+			break
+		}
+
+		t := z.Token()
+		switch tt {
+		case html.TextToken:
+			if c.skip == 0 {
+				c.text(t.Data)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			c.startTag(t, tt == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			c.endTag(t)
+		}
+	}
+
+	if c.format != "" {
+		c.closeFormat()
+	}
+
+	text := htmlLineSpacesRE.ReplaceAllString(c.out.String(), "\n")
+	text = htmlExtraNewlinesRE.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text), c.images
+}
+
+func (c *htmlConverter) startTag(t html.Token, selfClosing bool) {
+	switch t.Data {
+	case "script", "style":
+		if !selfClosing {
+			c.skip++
+		}
+		return
+	case "br":
+		if c.format == "pre" {
+			c.buf.WriteString("\n")
+		} else if c.format != "" {
+			c.buf.WriteString(" ")
+		} else {
+			c.newline(1)
+		}
+		return
+	case "img":
+		c.image(t)
+		return
+	}
+
+	if c.format != "" {
+		if htmlFormatTags[t.Data] == c.format && !selfClosing {
+			c.depth++
+		}
+		return
+	}
+
+	if n, isBlock := htmlBlockTags[t.Data]; isBlock {
+		c.newline(n)
+	}
+
+	switch t.Data {
+	case "ul", "ol":
+		c.newline(1)
+		c.lists = append(c.lists, htmlListState{ordered: t.Data == "ol"})
+		return
+	case "li":
+		c.newline(1)
+		c.listItem()
+		return
+	}
+
+	format, isFormat := htmlFormatTags[t.Data]
+	if !isFormat || selfClosing {
+		return
+	}
+
+	if format == "a" {
+		href := htmlAttr(t, "href")
+		if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "mailto:") && !strings.HasPrefix(href, "tg://") {
+			// unsafe or relative links are replaced with the text
+			return
+		}
+		c.href = href
+	}
+
+	c.format = format
+	c.depth = 0
+	c.buf.Reset()
+}
+
+func (c *htmlConverter) endTag(t html.Token) {
+	switch t.Data {
+	case "script", "style":
+		if c.skip > 0 {
+			c.skip--
+		}
+		return
+	}
+
+	if c.format != "" {
+		if htmlFormatTags[t.Data] != c.format {
+			return
+		}
+		if c.depth > 0 {
+			c.depth--
+			return
+		}
+		c.closeFormat()
+	}
+
+	switch t.Data {
+	case "ul", "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[0 : len(c.lists)-1]
+		}
+		c.newline(1)
+	}
+
+	if n, isBlock := htmlBlockTags[t.Data]; isBlock {
+		c.newline(n)
+	}
+}
+
+func (c *htmlConverter) text(s string) {
+	if c.format == "pre" {
+		c.buf.WriteString(s)
+		return
+	}
+
+	s = htmlSpacesRE.ReplaceAllString(s, " ")
+
+	if c.format != "" {
+		c.buf.WriteString(s)
+		return
+	}
+
+	if c.endsWithSpace() {
+		s = strings.TrimLeft(s, " ")
+	}
+	c.out.WriteString(c.escape(s))
+}
+
+func (c *htmlConverter) closeFormat() {
+	text := c.buf.String()
+	format, href := c.format, c.href
+	c.format, c.href = "", ""
+	c.buf.Reset()
+
+	if format != "pre" {
+		// keep the spaces around the formatted text
+		trimmed := strings.TrimSpace(text)
+		if trimmed != "" && strings.HasPrefix(text, " ") && !c.endsWithSpace() {
+			c.out.WriteString(" ")
+		}
+		if trimmed != "" && strings.HasSuffix(text, " ") {
+			defer c.out.WriteString(" ")
+		}
+		text = trimmed
+	}
+
+	if text == "" {
+		return
+	}
+
+	if c.markdown {
+		mrt := MarkdownRichText{}
+		switch format {
+		case "b":
+			c.out.WriteString(mrt.Bold(text))
+		case "i":
+			c.out.WriteString(mrt.Italic(text))
+		case "code":
+			c.out.WriteString(mrt.Fixed(text))
+		case "pre":
+			c.out.WriteString(mrt.Pre(text))
+		case "a":
+			c.out.WriteString(mrt.URL(text, href))
+		}
+		return
+	}
+
+	hrt := HTMLRichText{}
+	text = strings.Replace(text, "&", "&amp;", -1)
+	switch format {
+	case "b":
+		c.out.WriteString(hrt.Bold(text))
+	case "i":
+		c.out.WriteString(hrt.Italic(text))
+	case "code":
+		c.out.WriteString(hrt.Fixed(text))
+	case "pre":
+		c.out.WriteString(hrt.Pre(text))
+	case "a":
+		c.out.WriteString(hrt.URL(text, html.EscapeString(href)))
+	}
+}
+
+func (c *htmlConverter) listItem() {
+	if len(c.lists) == 0 {
+		c.out.WriteString("• ")
+		return
+	}
+
+	list := &c.lists[len(c.lists)-1]
+	c.out.WriteString(strings.Repeat("  ", len(c.lists)-1))
+	if list.ordered {
+		list.n++
+		c.out.WriteString(strconv.Itoa(list.n) + ". ")
+	} else {
+		c.out.WriteString("• ")
+	}
+}
+
+func (c *htmlConverter) image(t html.Token) {
+	src := htmlAttr(t, "src")
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return
+	}
+
+	c.images = append(c.images, AttachmentFromURL(FileTypePhoto, src, htmlAttr(t, "alt")))
+}
+
+// newline ensures the output ends with at least n newlines
+func (c *htmlConverter) newline(n int) {
+	if c.out.Len() == 0 {
+		return
+	}
+
+	s := c.out.String()
+	existing := len(s) - len(strings.TrimRight(s, "\n"))
+	if existing < n {
+		c.out.WriteString(strings.Repeat("\n", n-existing))
+	}
+}
+
+func (c *htmlConverter) endsWithSpace() bool {
+	s := c.out.String()
+	return len(s) == 0 || strings.HasSuffix(s, " ") || strings.HasSuffix(s, "\n")
+}
+
+func (c *htmlConverter) escape(s string) string {
+	if c.markdown {
+		return MarkdownRichText{}.Esc(s)
+	}
+	return HTMLRichText{}.EncodeEntities(strings.Replace(s, "&", "&amp;", -1))
+}
+
+func htmlAttr(t html.Token, key string) string {
+	for _, attr := range t.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// renderTable aligns the columns with spaces. maxLength is in characters
+func renderTable(header []string, rows [][]string, maxLength int) string {
+	all := rows
+	if len(header) > 0 {
+		all = append([][]string{header}, rows...)
+	}
+
+	var widths []int
+	for _, row := range all {
+		for i, cell := range row {
+			w := utf8.RuneCountInString(truncateRunes(cell, tableColumnMaxWidth))
+			if i >= len(widths) {
+				widths = append(widths, w)
+			} else if w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	formatRow := func(row []string) string {
+		cells := make([]string, len(row))
+		for i := range row {
+			cell := truncateRunes(row[i], tableColumnMaxWidth)
+			cells[i] = cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		}
+		return strings.TrimRight(strings.Join(cells, " | "), " ")
+	}
+
+	var lines []string
+	if len(header) > 0 {
+		lines = append(lines, formatRow(header))
+		separators := make([]string, len(widths))
+		for i, w := range widths {
+			separators[i] = strings.Repeat("-", w)
+		}
+		lines = append(lines, strings.Join(separators, "-+-"))
+	}
+
+	for _, row := range rows {
+		lines = append(lines, formatRow(row))
+	}
+
+	return joinLinesLimited(lines, maxLength, len(lines)-len(rows), "rows")
+}
+
+// renderDiff prefixes added and removed lines of the unified diff with emoji
+func renderDiff(diff string, maxLength int) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "), strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "\\ "):
+			continue
+		case strings.HasPrefix(line, "+++ "):
+			lines = append(lines, diffFilePrefix+strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/"))
+		case strings.HasPrefix(line, "@@"):
+			lines = append(lines, line)
+		case strings.HasPrefix(line, "+"):
+			lines = append(lines, diffAddedPrefix+line)
+		case strings.HasPrefix(line, "-"):
+			lines = append(lines, diffRemovedPrefix+line)
+		default:
+			lines = append(lines, "  "+line)
+		}
+	}
+
+	return joinLinesLimited(lines, maxLength, 0, "lines")
+}
+
+// joinLinesLimited joins lines and replaces the ones that don't fit maxLength with "… N more". The length is counted in UTF-16 code units as Telegram does. First keepLines are always included
+func joinLinesLimited(lines []string, maxLength int, keepLines int, unit string) string {
+	text := strings.Join(lines, "\n")
+	if utf16Len(text) <= maxLength {
+		return text
+	}
+
+	// leave the space for the "more" line
+	maxLength -= utf16Len(fmt.Sprintf("… %d more %s", len(lines), unit)) + 1
+
+	i, length := 0, 0
+	for ; i < len(lines); i++ {
+		length += utf16Len(lines[i]) + 1
+		if i >= keepLines && length > maxLength {
+			break
+		}
+	}
+
+	return strings.Join(append(lines[0:i:i], fmt.Sprintf("… %d more %s", len(lines)-i, unit)), "\n")
+}
+
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[0:max-1]) + "…"
+}
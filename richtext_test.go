@@ -1,6 +1,10 @@
 package integram
 
-import "testing"
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
 
 func TestHTMLRichText_Pre(t *testing.T) {
 	type args struct {
@@ -253,3 +257,110 @@ func TestMarkdownRichText_Italic(t *testing.T) {
 		}
 	}
 }
+
+func TestHTMLRichText_FromHTML(t *testing.T) {
+	tests := []struct {
+		name       string
+		html       string
+		want       string
+		wantImages []Attachment
+	}{
+		{"plain", "just text", "just text", nil},
+		{"formatting", "<p>Hello <strong>big</strong> <em>world</em> &amp; <code>a &lt; b</code></p>", "Hello <b>big</b> <i>world</i> &amp; <code>a &lt; b</code>", nil},
+		{"nested", "<b>bold <i>and italic</i></b>", "<b>bold and italic</b>", nil},
+		{"link", `see <a href="https://example.com/?a=1&b=2">the <b>docs</b></a>, <a href="javascript:alert(1)">bad</a>`, `see <a href="https://example.com/?a=1&amp;b=2">the docs</a>, bad`, nil},
+		{"lists", "<h2>Todo</h2><ul><li>one</li><li>two<ol><li>sub</li></ol></li></ul>", "<b>Todo</b>\n\n• one\n• two\n  1. sub", nil},
+		{"pre", "<pre>line 1\n  line <2></pre>", "<pre>line 1\n  line &lt;2&gt;</pre>", nil},
+		{"script", "a<script>alert(1)</script><br>b", "a\nb", nil},
+		{"image", `<p>screenshot: <img src="https://example.com/s.png" alt="s.png"><img src="/relative.png"></p>`, "screenshot:", []Attachment{AttachmentFromURL(FileTypePhoto, "https://example.com/s.png", "s.png")}},
+	}
+	for _, tt := range tests {
+		got, gotImages := HTMLRichText{}.FromHTML(tt.html)
+		if got != tt.want {
+			t.Errorf("%q. HTMLRichText.FromHTML() = %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(gotImages, tt.wantImages) {
+			t.Errorf("%q. HTMLRichText.FromHTML() images = %v, want %v", tt.name, gotImages, tt.wantImages)
+		}
+	}
+}
+
+func TestMarkdownRichText_FromHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"formatting", "<p>Hello <b>big</b> world_name</p>", "Hello *big* world\\_name"},
+		{"link", `<a href="https://example.com">the [docs]</a>`, "[the ⟦docs⟧](https://example.com)"},
+		{"lists", "<ol><li>one</li><li>two</li></ol>", "1. one\n2. two"},
+	}
+	for _, tt := range tests {
+		if got, _ := (MarkdownRichText{}).FromHTML(tt.html); got != tt.want {
+			t.Errorf("%q. MarkdownRichText.FromHTML() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_renderTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    []string
+		rows      [][]string
+		maxLength int
+		want      string
+	}{
+		{"aligned", []string{"Name", "Status"}, [][]string{{"api", "ok"}, {"frontend", "failed"}}, 1000, "Name     | Status\n---------+-------\napi      | ok\nfrontend | failed"},
+		{"no header", nil, [][]string{{"a", "1"}, {"bbb"}}, 1000, "a   | 1\nbbb"},
+		{"truncated cell", nil, [][]string{{strings.Repeat("x", 40)}}, 1000, strings.Repeat("x", 31) + "…"},
+		{"limited", []string{"N"}, [][]string{{"1"}, {"2"}, {"3"}, {"4"}, {"5"}, {"6"}, {"7"}, {"8"}, {"9"}, {"10"}, {"11"}, {"12"}}, 24, "N\n--\n1\n2\n… 10 more rows"},
+	}
+	for _, tt := range tests {
+		if got := renderTable(tt.header, tt.rows, tt.maxLength); got != tt.want {
+			t.Errorf("%q. renderTable() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_renderDiff(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+index 83db48f..bf269f4 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@
+ package main
+-var a = 1
++var a = 2
+\ No newline at end of file
+`
+	tests := []struct {
+		name      string
+		maxLength int
+		want      string
+	}{
+		{"full", 1000, "📄 main.go\n@@ -1,3 +1,3 @@\n   package main\n🔴-var a = 1\n🟢+var a = 2"},
+		{"limited", 45, "📄 main.go\n@@ -1,3 +1,3 @@\n… 3 more lines"},
+	}
+	for _, tt := range tests {
+		if got := renderDiff(diff, tt.maxLength); got != tt.want {
+			t.Errorf("%q. renderDiff() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_joinLinesLimited(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []string
+		maxLength int
+		want      string
+	}{
+		{"fits", []string{"🔴 one", "🟢 two"}, 13, "🔴 one\n🟢 two"},
+		{"emoji counted as 2 units", []string{"🔴 one", "🟢 two"}, 12, "🔴 one\n… 1 more lines"},
+	}
+	for _, tt := range tests {
+		if got := joinLinesLimited(tt.lines, tt.maxLength, 1, "lines"); got != tt.want {
+			t.Errorf("%q. joinLinesLimited() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
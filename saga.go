@@ -0,0 +1,322 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SagaDefaultRetries is the default number of attempts for each saga's step
+const SagaDefaultRetries = 3
+
+// pending saga without progress for this period was interrupted, e.g. by the restart, and its done steps are compensated
+const sagaStuckTimeout = time.Minute * 10
+
+// interval to check the interrupted sagas
+const sagaResumeInterval = time.Minute
+
+// delay before the step's next attempt is multiplied by the attempt's number
+var sagaRetryDelay = time.Second
+
+const (
+	SagaStatusPending     = "pending"
+	SagaStatusDone        = "done"
+	SagaStatusCompensated = "compensated" // one of steps failed and all done steps were reverted
+	SagaStatusFailed      = "failed"      // one of steps failed and some of done steps can't be reverted
+)
+
+const (
+	sagaActionSend  = "send"
+	sagaActionEdit  = "edit"
+	sagaActionPin   = "pin"
+	sagaActionUnpin = "unpin"
+)
+
+type sagaStep struct {
+	Action      string
+	Message     *OutgoingMessage
+	ChatID      int64  `bson:",omitempty"` // chat of the unpin step
+	Text        string `bson:",omitempty"` // new text for the edit step. Message's text is not stored in DB
+	RevertText  string `bson:",omitempty"` // text to restore when compensating the edit step
+	Silent      bool   `bson:",omitempty"` // pin without notification
+	RevertMsgID int    `bson:",omitempty"` // message pinned by the bot before the pin or unpin step
+	Done        bool
+	Compensated bool   `bson:",omitempty"`
+	Error       string `bson:",omitempty"`
+}
+
+func (step *sagaStep) chatID() int64 {
+	if step.Message != nil {
+		return step.Message.ChatID
+	}
+	return step.ChatID
+}
+
+// sagaActions performs the steps in Telegram
+type sagaActions interface {
+	send(m *OutgoingMessage) error
+	edit(om *OutgoingMessage, text string) error
+	delete(om *OutgoingMessage) error
+	pin(om *OutgoingMessage, silent bool) error
+	unpin(chatID int64) error
+	pinnedMsgID(chatID int64) (int, error)
+}
+
+type contextSagaActions struct {
+	ctx *Context
+}
+
+func (a contextSagaActions) send(m *OutgoingMessage) error {
+	return m.sendNow()
+}
+
+func (a contextSagaActions) edit(om *OutgoingMessage, text string) error {
+	return a.ctx.EditMessageText(om, text)
+}
+
+func (a contextSagaActions) delete(om *OutgoingMessage) error {
+	return a.ctx.DeleteMessage(om)
+}
+
+func (a contextSagaActions) pin(om *OutgoingMessage, silent bool) error {
+	return a.ctx.PinChatMessage(om, silent)
+}
+
+func (a contextSagaActions) unpin(chatID int64) error {
+	return a.ctx.UnpinChatMessage(chatID)
+}
+
+// pinnedMsgID returns the chat's message pinned by the bot most recently, 0 if there is none
+func (a contextSagaActions) pinnedMsgID(chatID int64) (int, error) {
+	om, err := lastPinnedMessage(a.ctx.db, bson.M{"chatid": chatID, "botid": a.ctx.Bot().ID, "pin": bson.M{"$exists": true}})
+	if om == nil || err != nil {
+		return 0, err
+	}
+	return om.MsgID, nil
+}
+
+// Saga posts several messages, edits and pins as the single transaction: in case one of steps can't be performed after retries
+// all the done steps will be reverted (sent messages deleted, edited messages restored and the previous pin restored).
+// Done steps of the saga interrupted by the restart are reverted too
+type Saga struct {
+	ID        bson.ObjectId `bson:"_id"`
+	Service   string
+	Status    string
+	Steps     []*sagaStep
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	retries int
+	ctx     *Context
+	actions sagaActions
+}
+
+// NewSaga returns the empty saga. Add the steps and call Execute
+func (c *Context) NewSaga() *Saga {
+	return &Saga{ID: bson.NewObjectId(), Service: c.ServiceName, Status: SagaStatusPending, CreatedAt: time.Now(), retries: SagaDefaultRetries, ctx: c, actions: contextSagaActions{ctx: c}}
+}
+
+// Send adds the step to send the message
+func (s *Saga) Send(m *OutgoingMessage) *Saga {
+	s.Steps = append(s.Steps, &sagaStep{Action: sagaActionSend, Message: m})
+	return s
+}
+
+// EditText adds the step to edit the message's text. revertText will be used in case the saga will be compensated
+func (s *Saga) EditText(om *OutgoingMessage, text string, revertText string) *Saga {
+	s.Steps = append(s.Steps, &sagaStep{Action: sagaActionEdit, Message: om, Text: text, RevertText: revertText})
+	return s
+}
+
+// Pin adds the step to pin the message with PinChatMessage, e.g. the status message sent by the previous step.
+// Compensation pins back the message pinned by the bot before or unpins the chat
+func (s *Saga) Pin(om *OutgoingMessage, silent bool) *Saga {
+	s.Steps = append(s.Steps, &sagaStep{Action: sagaActionPin, Message: om, Silent: silent})
+	return s
+}
+
+// Unpin adds the step to unpin the chat's pinned message. Compensation pins back the message pinned by the bot before
+func (s *Saga) Unpin(chatID int64) *Saga {
+	s.Steps = append(s.Steps, &sagaStep{Action: sagaActionUnpin, ChatID: chatID})
+	return s
+}
+
+// SetRetries sets the number of attempts for each step
+func (s *Saga) SetRetries(n int) *Saga {
+	if n < 1 {
+		n = 1
+	}
+	s.retries = n
+	return s
+}
+
+// Execute performs the steps in order. If one of them fails, done steps are compensated in reverse order and the step's error is returned
+func (s *Saga) Execute() error {
+	if len(s.Steps) == 0 {
+		return errors.New("Saga has no steps")
+	}
+
+	s.save()
+
+	for i, step := range s.Steps {
+		err := s.perform(step)
+		if err == nil {
+			step.Done = true
+			s.save()
+			continue
+		}
+
+		step.Error = err.Error()
+		s.ctx.Log().WithError(err).WithField("saga", s.ID.Hex()).Errorf("Saga step %d (%s) failed, compensating", i, step.Action)
+
+		s.compensateDone()
+		s.save()
+
+		return fmt.Errorf("Saga step %d (%s) failed: %s", i, step.Action, err.Error())
+	}
+
+	s.Status = SagaStatusDone
+	s.save()
+
+	return nil
+}
+
+// compensateDone reverts the done steps in reverse order and sets the final status
+func (s *Saga) compensateDone() {
+	s.Status = SagaStatusCompensated
+	for j := len(s.Steps) - 1; j >= 0; j-- {
+		step := s.Steps[j]
+		if !step.Done || step.Compensated {
+			continue
+		}
+
+		if err := s.compensate(step); err != nil {
+			step.Error = err.Error()
+			s.Status = SagaStatusFailed
+			s.ctx.Log().WithError(err).WithField("saga", s.ID.Hex()).Errorf("Saga step %d (%s) compensation failed", j, step.Action)
+		} else {
+			step.Compensated = true
+		}
+	}
+}
+
+func (s *Saga) perform(step *sagaStep) error {
+	if step.Action == sagaActionPin || step.Action == sagaActionUnpin {
+		// remember the current pin to restore it on the compensation
+		msgID, err := s.actions.pinnedMsgID(step.chatID())
+		if err != nil {
+			return err
+		}
+		step.RevertMsgID = msgID
+	}
+
+	var err error
+	for attempt := 1; attempt <= s.retries; attempt++ {
+		switch step.Action {
+		case sagaActionSend:
+			err = s.actions.send(step.Message)
+		case sagaActionEdit:
+			err = s.actions.edit(step.Message, step.Text)
+		case sagaActionPin:
+			err = s.actions.pin(step.Message, step.Silent)
+		case sagaActionUnpin:
+			err = s.actions.unpin(step.ChatID)
+		default:
+			return fmt.Errorf("Unknown saga action '%s'", step.Action)
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt < s.retries {
+			time.Sleep(sagaRetryDelay * time.Duration(attempt))
+		}
+	}
+	return err
+}
+
+func (s *Saga) compensate(step *sagaStep) error {
+	switch step.Action {
+	case sagaActionSend:
+		return s.actions.delete(step.Message)
+	case sagaActionEdit:
+		return s.actions.edit(step.Message, step.RevertText)
+	case sagaActionPin, sagaActionUnpin:
+		if step.RevertMsgID != 0 {
+			return s.actions.pin(&OutgoingMessage{Message: Message{ChatID: step.chatID(), MsgID: step.RevertMsgID}}, true)
+		}
+		if step.Action == sagaActionPin {
+			return s.actions.unpin(step.chatID())
+		}
+	}
+	return nil
+}
+
+func (s *Saga) save() {
+	s.UpdatedAt = time.Now()
+	_, err := s.ctx.Db().C("sagas").UpsertId(s.ID, s)
+	if err != nil {
+		s.ctx.Log().WithError(err).WithField("saga", s.ID.Hex()).Error("Can't save the saga")
+	}
+}
+
+// claimStuckSaga returns the pending saga without progress for sagaStuckTimeout. It's claimed for the next period, so only one instance compensates it
+func claimStuckSaga(db *mgo.Database, now time.Time) (*Saga, error) {
+	var s Saga
+	_, err := db.C("sagas").Find(bson.M{"status": SagaStatusPending, "updatedat": bson.M{"$lt": now.Add(-sagaStuckTimeout)}}).
+		Apply(mgo.Change{Update: bson.M{"$set": bson.M{"updatedat": now}}, ReturnNew: true}, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// compensateStuckSaga reverts the done steps of the saga interrupted in the middle.
+// Step interrupted after it was performed in Telegram but before it was saved as done can't be reverted
+func compensateStuckSaga(db *mgo.Database, s *Saga) {
+	s.ctx = &Context{db: db, ServiceName: s.Service}
+	s.actions = contextSagaActions{ctx: s.ctx}
+
+	s.ctx.Log().WithField("saga", s.ID.Hex()).Warn("Saga was interrupted, compensating")
+	s.compensateDone()
+	s.save()
+}
+
+// stuckSagasCompensator compensates the sagas interrupted by the restart or crash
+func stuckSagasCompensator() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("stuckSagasCompensator panic recovered %v", r)
+			stuckSagasCompensator()
+		}
+	}()
+
+	if Config.IsStandAloneServiceInstance() {
+		return
+	}
+
+	session := mongoSession.Clone()
+	defer session.Close()
+	db := session.DB(mongo.Database)
+
+	for {
+		for {
+			s, err := claimStuckSaga(db, time.Now())
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.WithError(err).Error("stuckSagasCompensator: can't get the interrupted sagas")
+				break
+			}
+
+			compensateStuckSaga(db, s)
+		}
+
+		time.Sleep(sagaResumeInterval)
+	}
+}
@@ -0,0 +1,215 @@
+package integram
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeSagaActions records the performed actions and fails them according to failures
+type fakeSagaActions struct {
+	failures map[string]int // action is failed this number of times, -1 to fail always
+	pinned   int
+	calls    []string
+}
+
+func (a *fakeSagaActions) do(action string) error {
+	a.calls = append(a.calls, action)
+	if n := a.failures[action]; n != 0 {
+		if n > 0 {
+			a.failures[action]--
+		}
+		return errors.New(action + " failed")
+	}
+	return nil
+}
+
+func (a *fakeSagaActions) send(m *OutgoingMessage) error {
+	err := a.do("send")
+	if err == nil {
+		m.MsgID = 100 + len(a.calls)
+	}
+	return err
+}
+
+func (a *fakeSagaActions) edit(om *OutgoingMessage, text string) error {
+	return a.do("edit " + text)
+}
+
+func (a *fakeSagaActions) delete(om *OutgoingMessage) error {
+	return a.do("delete")
+}
+
+func (a *fakeSagaActions) pin(om *OutgoingMessage, silent bool) error {
+	err := a.do("pin")
+	if err == nil {
+		a.pinned = om.MsgID
+	}
+	return err
+}
+
+func (a *fakeSagaActions) unpin(chatID int64) error {
+	err := a.do("unpin")
+	if err == nil {
+		a.pinned = 0
+	}
+	return err
+}
+
+func (a *fakeSagaActions) pinnedMsgID(chatID int64) (int, error) {
+	return a.pinned, nil
+}
+
+func newTestSaga(actions *fakeSagaActions) *Saga {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	s := ctx.NewSaga()
+	s.actions = actions
+	return s
+}
+
+func TestSaga_Execute(t *testing.T) {
+	prevDelay := sagaRetryDelay
+	sagaRetryDelay = 0
+	defer func() { sagaRetryDelay = prevDelay }()
+
+	tests := []struct {
+		name       string
+		failures   map[string]int
+		pinned     int
+		wantErr    bool
+		wantStatus string
+		wantCalls  []string
+		wantPinned int
+	}{
+		{"done", nil, 0, false, SagaStatusDone, []string{"send", "edit new", "pin"}, 101},
+		{"retried", map[string]int{"edit new": 2}, 0, false, SagaStatusDone, []string{"send", "edit new", "edit new", "edit new", "pin"}, 101},
+		{"pin compensated", map[string]int{"pin": -1}, 7, true, SagaStatusCompensated, []string{"send", "edit new", "pin", "pin", "pin", "edit old", "delete"}, 7},
+		{"edit compensated", map[string]int{"edit new": -1}, 0, true, SagaStatusCompensated, []string{"send", "edit new", "edit new", "edit new", "delete"}, 0},
+		{"compensation failed", map[string]int{"pin": -1, "delete": -1}, 0, true, SagaStatusFailed, []string{"send", "edit new", "pin", "pin", "pin", "edit old", "delete"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := &fakeSagaActions{failures: tt.failures, pinned: tt.pinned}
+			if actions.failures == nil {
+				actions.failures = map[string]int{}
+			}
+
+			status := &OutgoingMessage{Message: Message{ChatID: 1}}
+			s := newTestSaga(actions).
+				Send(status).
+				EditText(&OutgoingMessage{Message: Message{ChatID: 2, MsgID: 10}}, "new", "old").
+				Pin(status, true)
+
+			err := s.Execute()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Saga.Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.Status != tt.wantStatus {
+				t.Errorf("Saga.Status = %s, want %s", s.Status, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(actions.calls, tt.wantCalls) {
+				t.Errorf("Saga.Execute() calls = %v, want %v", actions.calls, tt.wantCalls)
+			}
+			if actions.pinned != tt.wantPinned {
+				t.Errorf("pinned message = %d, want %d", actions.pinned, tt.wantPinned)
+			}
+		})
+	}
+}
+
+func TestSaga_perform(t *testing.T) {
+	prevDelay := sagaRetryDelay
+	sagaRetryDelay = 0
+	defer func() { sagaRetryDelay = prevDelay }()
+
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"first attempt", 3, 0, false, 1},
+		{"last attempt", 3, 2, false, 3},
+		{"out of attempts", 3, 3, true, 3},
+		{"single attempt", 1, 1, true, 1},
+		{"at least one attempt", 0, 0, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := &fakeSagaActions{failures: map[string]int{"send": tt.failures}}
+			s := newTestSaga(actions).SetRetries(tt.retries)
+
+			err := s.perform(&sagaStep{Action: sagaActionSend, Message: &OutgoingMessage{}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Saga.perform() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(actions.calls) != tt.wantCalls {
+				t.Errorf("Saga.perform() attempts = %d, want %d", len(actions.calls), tt.wantCalls)
+			}
+		})
+	}
+
+	s := newTestSaga(&fakeSagaActions{pinned: 7})
+	step := &sagaStep{Action: sagaActionUnpin, ChatID: 1}
+	if err := s.perform(step); err != nil || step.RevertMsgID != 7 {
+		t.Errorf("Saga.perform() unpin error = %v, RevertMsgID = %d, want 7", err, step.RevertMsgID)
+	}
+}
+
+func TestSaga_compensate(t *testing.T) {
+	om := &OutgoingMessage{Message: Message{ChatID: 1, MsgID: 5}}
+	tests := []struct {
+		name       string
+		step       *sagaStep
+		wantCalls  []string
+		wantPinned int
+	}{
+		{"send", &sagaStep{Action: sagaActionSend, Message: om}, []string{"delete"}, 3},
+		{"edit", &sagaStep{Action: sagaActionEdit, Message: om, Text: "new", RevertText: "old"}, []string{"edit old"}, 3},
+		{"pin over the previous", &sagaStep{Action: sagaActionPin, Message: om, RevertMsgID: 4}, []string{"pin"}, 4},
+		{"first pin", &sagaStep{Action: sagaActionPin, Message: om}, []string{"unpin"}, 0},
+		{"unpin", &sagaStep{Action: sagaActionUnpin, ChatID: 1, RevertMsgID: 4}, []string{"pin"}, 4},
+		{"unpin of nothing", &sagaStep{Action: sagaActionUnpin, ChatID: 1}, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := &fakeSagaActions{pinned: 3}
+			if err := newTestSaga(actions).compensate(tt.step); err != nil {
+				t.Fatalf("Saga.compensate() error = %v", err)
+			}
+			if !reflect.DeepEqual(actions.calls, tt.wantCalls) {
+				t.Errorf("Saga.compensate() calls = %v, want %v", actions.calls, tt.wantCalls)
+			}
+			if actions.pinned != tt.wantPinned {
+				t.Errorf("pinned message = %d, want %d", actions.pinned, tt.wantPinned)
+			}
+		})
+	}
+}
+
+func Test_claimStuckSaga(t *testing.T) {
+	db.C("sagas").RemoveAll(bson.M{"status": SagaStatusPending})
+
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	s := ctx.NewSaga().Send(&OutgoingMessage{})
+	s.save()
+	defer db.C("sagas").RemoveId(s.ID)
+
+	if _, err := claimStuckSaga(db, time.Now()); err == nil {
+		t.Fatalf("claimStuckSaga() claimed the saga in progress")
+	}
+
+	now := time.Now().Add(sagaStuckTimeout + time.Minute)
+	got, err := claimStuckSaga(db, now)
+	if err != nil || got.ID != s.ID {
+		t.Fatalf("claimStuckSaga() = %v, %v, want the saga %s", got, err, s.ID.Hex())
+	}
+
+	if _, err := claimStuckSaga(db, now); err == nil {
+		t.Errorf("claimStuckSaga() claimed the saga twice")
+	}
+}
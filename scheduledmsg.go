@@ -0,0 +1,168 @@
+package integram
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to check the due scheduled messages
+const scheduledMessagesCheckInterval = time.Second * 10
+
+// scheduled message is claimed by the instance for this period, so it is retried after the failed delivery or the crash
+const scheduledMessageLockTTL = time.Minute * 5
+
+// message is dropped after this number of failed deliveries
+const scheduledMessageMaxAttempts = 5
+
+// scheduledMessage is the message stored by OutgoingMessage.SendAt until it is due
+type scheduledMessage struct {
+	ID          bson.ObjectId `bson:"_id"`
+	At          time.Time     `bson:"at"`
+	Service     string        `bson:"s"`
+	ChatID      int64         `bson:"c"`
+	EventID     []string      `bson:"eid,omitempty"`
+	Data        []byte        `bson:"d"`           // gob-encoded OutgoingMessage, because the text and keyboard are not stored in bson
+	LockedUntil time.Time     `bson:"l,omitempty"` // set when the message is claimed for the delivery
+	Attempts    int           `bson:"n,omitempty"`
+}
+
+// SendAt stores the message and sends it at the time, e.g. the reminder. Unlike SetSendAfter it survives the restarts and can be canceled with Context.CancelScheduledMessages
+func (m *OutgoingMessage) SendAt(at time.Time) error {
+	if m.ChatID == 0 {
+		return errors.New("ChatID is empty")
+	}
+
+	if m.BotID == 0 {
+		return errors.New("BotID is empty")
+	}
+
+	m.applySafeText()
+
+	if m.Text == "" && m.FilePath == "" && m.FileID == "" && m.Location == nil {
+		return errors.New("Text, FilePath, FileID and Location are empty")
+	}
+
+	if !at.After(time.Now()) {
+		return m.Send()
+	}
+
+	data, err := encode(m)
+	if err != nil {
+		return err
+	}
+
+	sm := scheduledMessage{ID: bson.NewObjectId(), At: at, ChatID: m.ChatID, EventID: m.EventID, Data: data}
+
+	var db *mgo.Database
+	if m.ctx != nil {
+		sm.Service = m.ctx.ServiceName
+		db = m.ctx.db
+	} else {
+		db = mongoSession.Clone().DB(mongo.Database)
+		defer db.Session.Close()
+	}
+
+	err = db.C("messages_scheduled").Insert(sm)
+	if err != nil {
+		return err
+	}
+
+	m.processed = true
+	return nil
+}
+
+// CancelScheduledMessages removes the service's messages scheduled with SendAt which have the event ID. Returns the number of canceled messages
+func (c *Context) CancelScheduledMessages(eventID string) (int, error) {
+	info, err := c.db.C("messages_scheduled").RemoveAll(bson.M{"s": c.ServiceName, "eid": eventID})
+	if err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// claimScheduledMessage locks the due message for the delivery. Returns mgo.ErrNotFound if there are no due messages
+func claimScheduledMessage(db *mgo.Database, now time.Time) (*scheduledMessage, error) {
+	var sm scheduledMessage
+	_, err := db.C("messages_scheduled").Find(bson.M{
+		"at": bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"l": bson.M{"$exists": false}},
+			{"l": bson.M{"$lt": now}},
+		},
+	}).Sort("at").Apply(mgo.Change{Update: bson.M{"$set": bson.M{"l": now.Add(scheduledMessageLockTTL)}, "$inc": bson.M{"n": 1}}, ReturnNew: true}, &sm)
+
+	if err != nil {
+		return nil, err
+	}
+	return &sm, nil
+}
+
+// deliverScheduledMessage sends the claimed message and removes it
+func deliverScheduledMessage(db *mgo.Database, sm *scheduledMessage) error {
+	var m OutgoingMessage
+	err := decode(sm.Data, &m)
+	if err != nil {
+		// message can't be delivered anymore
+		db.C("messages_scheduled").RemoveId(sm.ID)
+		return err
+	}
+
+	if sm.Service != "" {
+		ctx := &Context{db: db, ServiceName: sm.Service}
+		ctx.Chat = Chat{ID: m.ChatID, ctx: ctx}
+		ctx.User.ctx = ctx
+		m.ctx = ctx
+	}
+
+	m.SendAfter = nil
+	err = m.Send()
+	if err != nil {
+		if sm.Attempts >= scheduledMessageMaxAttempts {
+			db.C("messages_scheduled").RemoveId(sm.ID)
+		}
+		return err
+	}
+
+	return db.C("messages_scheduled").RemoveId(sm.ID)
+}
+
+// scheduledMessagesSender delivers the due messages scheduled with SendAt
+func scheduledMessagesSender() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("scheduledMessagesSender panic recovered %v", r)
+			scheduledMessagesSender()
+		}
+	}()
+
+	if Config.IsStandAloneServiceInstance() {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		for {
+			sm, err := claimScheduledMessage(db, time.Now())
+			if err == mgo.ErrNotFound {
+				break
+			} else if err != nil {
+				log.WithError(err).Error("scheduledMessagesSender: can't get the due messages")
+				break
+			}
+
+			err = deliverScheduledMessage(db, sm)
+			if err != nil {
+				log.WithError(err).WithField("chat", sm.ChatID).WithField("service", sm.Service).Error("scheduledMessagesSender: can't send the message")
+			}
+		}
+
+		time.Sleep(scheduledMessagesCheckInterval)
+	}
+}
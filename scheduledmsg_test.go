@@ -0,0 +1,57 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestOutgoingMessage_SendAt(t *testing.T) {
+	ctx := &Context{db: db, ServiceName: "servicewithbottoken"}
+	defer db.C("messages_scheduled").RemoveAll(bson.M{"s": ctx.ServiceName})
+
+	now := time.Now()
+	schedule := func(text string, at time.Time) {
+		m := &OutgoingMessage{ctx: ctx}
+		m.BotID = 100
+		m.ChatID = -100900
+		m.Text = text
+		m.AddEventID("reminder_" + text)
+
+		if err := m.SendAt(at); err != nil {
+			t.Fatalf("SendAt() error = %v", err)
+		}
+	}
+
+	schedule("later", now.Add(time.Hour))
+	schedule("second", now.Add(time.Second*2))
+	schedule("first", now.Add(time.Second))
+
+	var want = []string{"first", "second"}
+	for _, text := range want {
+		sm, err := claimScheduledMessage(db, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("claimScheduledMessage() error = %v", err)
+		}
+
+		var m OutgoingMessage
+		if err := decode(sm.Data, &m); err != nil || m.Text != text || sm.Attempts != 1 {
+			t.Errorf("claimScheduledMessage() = %q (attempt %d), %v, want %q", m.Text, sm.Attempts, err, text)
+		}
+	}
+
+	if _, err := claimScheduledMessage(db, now.Add(time.Minute)); err != mgo.ErrNotFound {
+		t.Errorf("claimScheduledMessage() of the locked messages error = %v, want %v", err, mgo.ErrNotFound)
+	}
+
+	if sm, err := claimScheduledMessage(db, now.Add(scheduledMessageLockTTL*2)); err != nil || sm.Attempts != 2 {
+		t.Errorf("claimScheduledMessage() after the lock expired = %v, %v, want the retry", sm, err)
+	}
+
+	canceled, err := ctx.CancelScheduledMessages("reminder_later")
+	if err != nil || canceled != 1 {
+		t.Errorf("CancelScheduledMessages() = %d, %v, want 1", canceled, err)
+	}
+}
@@ -0,0 +1,308 @@
+// Package sdk is the stable, service-author-facing API of Integram.
+//
+// Services should import this package instead of the root integram package.
+// Everything exported here follows semantic versioning (see Version): within the same major version
+// identifiers are never removed or changed in an incompatible way, while the storage and dispatch code
+// in the root package is free to be refactored.
+//
+//	import "github.com/requilence/integram/sdk"
+//
+//	func Register() {
+//		sdk.Register(Config{...}, os.Getenv("INTEGRAM_BOT_TOKEN"))
+//	}
+package sdk
+
+import (
+	"io"
+
+	"github.com/requilence/integram"
+)
+
+// Version of the SDK API. Major version is increased only on incompatible changes, including the methods of the types listed here:
+//
+//	2.0.0 Context.DeleteMessagesWithEventID takes the bot's ID
+const Version = "2.0.0"
+
+// Service registration
+type (
+	// Servicer is interface to match service's config from which the service itself can be produced
+	Servicer = integram.Servicer
+	// Service describes the service handlers and settings
+	Service = integram.Service
+	// Module is the set of jobs and actions that can be shared between services
+	Module = integram.Module
+	// Job 's handler that may be used when scheduling
+	Job = integram.Job
+	// CronJob is run periodically by the cron spec on one of the instances
+	CronJob = integram.CronJob
+	// DefaultOAuth1 is the default OAuth1 config for the service
+	DefaultOAuth1 = integram.DefaultOAuth1
+	// DefaultOAuth2 is the default OAuth2 config for the service
+	DefaultOAuth2 = integram.DefaultOAuth2
+	// OAuthProvider is the OAuth app credentials for the specific host
+	OAuthProvider = integram.OAuthProvider
+	// ExecService is the config of service which webhooks are handled by the external program
+	ExecService = integram.ExecService
+	// ExecInput is the JSON piped to the exec service's program stdin
+	ExecInput = integram.ExecInput
+	// ExecOutput is the JSON the exec service's program must print to stdout
+	ExecOutput = integram.ExecOutput
+	// ExecMessage is the message produced by the exec service's program
+	ExecMessage = integram.ExecMessage
+)
+
+// Handler contexts
+type (
+	// Context of the Telegram update or the service event
+	Context = integram.Context
+	// WebhookContext of the incoming webhook request
+	WebhookContext = integram.WebhookContext
+	// User of the service
+	User = integram.User
+	// Chat of the service
+	Chat = integram.Chat
+	// Bot of the service
+	Bot = integram.Bot
+)
+
+// Messages
+type (
+	// Message is the common part of incoming and outgoing messages
+	Message = integram.Message
+	// IncomingMessage received from Telegram
+	IncomingMessage = integram.IncomingMessage
+	// OutgoingMessage to be sent to Telegram
+	OutgoingMessage = integram.OutgoingMessage
+	// FileType of the incoming media
+	FileType = integram.FileType
+	// FileInfo of the incoming media
+	FileInfo = integram.FileInfo
+	// Attachment is the file used both in incoming and outgoing messages
+	Attachment = integram.Attachment
+	// AttachmentSource specifies where the attachment's content is located
+	AttachmentSource = integram.AttachmentSource
+	// StatKey identifies the statistic counter
+	StatKey = integram.StatKey
+	// Branding is the instance-wide bot personality configured with INTEGRAM_BRANDING_* env vars
+	Branding = integram.Branding
+	// ChatErrors contains the errors returned by ForEachChat handler per chat
+	ChatErrors = integram.ChatErrors
+	// ServiceCollection is the service's namespaced collection declared in Service.Collections
+	ServiceCollection = integram.ServiceCollection
+	// WorkingHours of the user. Personal notifications outside of them are held and delivered with the digest
+	WorkingHours = integram.WorkingHours
+	// MessageBuilder composes the message with the fluent API, e.g. c.Reply().Text("Done").Silent().Send()
+	MessageBuilder = integram.MessageBuilder
+	// SentMessage is the result of MessageBuilder.Send used for the follow-up edits
+	SentMessage = integram.SentMessage
+	// ChatEvent is the sanitized event streamed to the external dashboards
+	ChatEvent = integram.ChatEvent
+	// Translator translates the notifications to the chat's language. See SetTranslator
+	Translator = integram.Translator
+	// FileScanner checks the forwarded files for viruses. See SetFileScanner
+	FileScanner = integram.FileScanner
+	// FileInfectedError is returned when the file is blocked by the virus scanner
+	FileInfectedError = integram.FileInfectedError
+	// Recipient is the target chat's language and timezone to render the message for
+	Recipient = integram.Recipient
+	// RenderFunc produces the message for the specific recipient right before sending
+	RenderFunc = integram.RenderFunc
+	// RenderEditFunc produces the text and keyboard to edit the message for the specific recipient
+	RenderEditFunc = integram.RenderEditFunc
+	// EventTransition is the upstream event's state change
+	EventTransition = integram.EventTransition
+	// EventUpdateMode defines how the chat receives the updates of already posted event
+	EventUpdateMode = integram.EventUpdateMode
+)
+
+// Keyboards
+type (
+	// Keyboard is the reply keyboard
+	Keyboard = integram.Keyboard
+	// Buttons is the row of the reply keyboard
+	Buttons = integram.Buttons
+	// Button of the reply keyboard
+	Button = integram.Button
+	// InlineKeyboard is the inline keyboard with state
+	InlineKeyboard = integram.InlineKeyboard
+	// InlineButtons is the row of the inline keyboard
+	InlineButtons = integram.InlineButtons
+	// InlineButton of the inline keyboard
+	InlineButton = integram.InlineButton
+	// KeyboardMarkup is implemented by the reply keyboard builders
+	KeyboardMarkup = integram.KeyboardMarkup
+	// InlineKeyboardMarkup is implemented by the inline keyboard builders
+	InlineKeyboardMarkup = integram.InlineKeyboardMarkup
+)
+
+// Rich text
+type (
+	// HTMLRichText produce HTML that can be sent to Telegram
+	HTMLRichText = integram.HTMLRichText
+	// MarkdownRichText produce Markdown that can be sent to Telegram
+	MarkdownRichText = integram.MarkdownRichText
+)
+
+// Job retry politics
+const (
+	JobRetryLinear    = integram.JobRetryLinear
+	JobRetryFibonacci = integram.JobRetryFibonacci
+)
+
+// Incoming media types
+const (
+	FileTypeDocument = integram.FileTypeDocument
+	FileTypePhoto    = integram.FileTypePhoto
+	FileTypeAudio    = integram.FileTypeAudio
+	FileTypeSticker  = integram.FileTypeSticker
+	FileTypeVideo    = integram.FileTypeVideo
+	FileTypeVoice    = integram.FileTypeVoice
+)
+
+// Attachment sources
+const (
+	AttachmentSourceURL    = integram.AttachmentSourceURL
+	AttachmentSourceFileID = integram.AttachmentSourceFileID
+	AttachmentSourceLocal  = integram.AttachmentSourceLocal
+	AttachmentSourceStream = integram.AttachmentSourceStream
+)
+
+// Event lifecycle transitions
+const (
+	EventCreated = integram.EventCreated
+	EventUpdated = integram.EventUpdated
+	EventClosed  = integram.EventClosed
+)
+
+// Event update modes
+const (
+	EventUpdateEdit  = integram.EventUpdateEdit
+	EventUpdateReply = integram.EventUpdateReply
+	EventUpdateNew   = integram.EventUpdateNew
+)
+
+// Branding error tones
+const (
+	BrandingErrorToneNeutral  = integram.BrandingErrorToneNeutral
+	BrandingErrorToneFriendly = integram.BrandingErrorToneFriendly
+)
+
+// API key scopes
+const (
+	APIScopeSend   = integram.APIScopeSend
+	APIScopeStats  = integram.APIScopeStats
+	APIScopeEvents = integram.APIScopeEvents
+)
+
+// Types of the chat events
+const (
+	ChatEventMessageSent     = integram.ChatEventMessageSent
+	ChatEventButtonPressed   = integram.ChatEventButtonPressed
+	ChatEventWebhookReceived = integram.ChatEventWebhookReceived
+)
+
+// Errors that can be returned by the handlers
+type (
+	// ServiceError is shown to the user with the remediation buttons
+	ServiceError = integram.ServiceError
+	// ServiceErrorSeverity specifies how the error is presented to the user
+	ServiceErrorSeverity = integram.ServiceErrorSeverity
+	// ServiceErrorAction is the button suggested to the user to fix the error
+	ServiceErrorAction = integram.ServiceErrorAction
+)
+
+// Errors that can be returned by the handlers
+var (
+	ErrorFlood           = integram.ErrorFlood
+	ErrorBadRequstPrefix = integram.ErrorBadRequstPrefix
+
+	ErrServiceQuotaExceeded = integram.ErrServiceQuotaExceeded
+	ErrMessageNotSentYet    = integram.ErrMessageNotSentYet
+)
+
+// Service error severities
+const (
+	ServiceErrorSeverityInfo    = integram.ServiceErrorSeverityInfo
+	ServiceErrorSeverityWarning = integram.ServiceErrorSeverityWarning
+	ServiceErrorSeverityError   = integram.ServiceErrorSeverityError
+)
+
+// Register the service's config and corresponding botToken
+func Register(servicer Servicer, botToken string) {
+	integram.Register(servicer, botToken)
+}
+
+// SetTranslator sets the custom translator instead of the one configured with INTEGRAM_TRANSLATION_PROVIDER
+func SetTranslator(t Translator) {
+	integram.SetTranslator(t)
+}
+
+// SetFileScanner sets the custom virus scanner instead of the one configured with INTEGRAM_FILE_SCAN_URL
+func SetFileScanner(s FileScanner) {
+	integram.SetFileScanner(s)
+}
+
+// IsFileInfected checks if the error was caused by the file blocked by the virus scanner
+func IsFileInfected(err error) bool {
+	return integram.IsFileInfected(err)
+}
+
+// Run the instance. Must be called after all services are registered
+func Run() {
+	integram.Run()
+}
+
+// GetRemoteFilePath returns the URL of the Telegram file
+func GetRemoteFilePath(c *Context, fileID string) (string, error) {
+	return integram.GetRemoteFilePath(c, fileID)
+}
+
+// GetLocalFilePath downloads the Telegram file and returns the local path
+func GetLocalFilePath(c *Context, fileID string) (string, error) {
+	return integram.GetLocalFilePath(c, fileID)
+}
+
+// AttachmentFromURL returns the attachment that will be downloaded from URL
+func AttachmentFromURL(kind FileType, url string, name string) Attachment {
+	return integram.AttachmentFromURL(kind, url, name)
+}
+
+// AttachmentFromFileID returns the attachment already uploaded to Telegram
+func AttachmentFromFileID(kind FileType, fileID string, name string) Attachment {
+	return integram.AttachmentFromFileID(kind, fileID, name)
+}
+
+// AttachmentFromLocalPath returns the attachment for the local file
+func AttachmentFromLocalPath(kind FileType, localPath string, name string) Attachment {
+	return integram.AttachmentFromLocalPath(kind, localPath, name)
+}
+
+// AttachmentFromReader returns the attachment that will be read from r
+func AttachmentFromReader(kind FileType, r io.Reader, name string) Attachment {
+	return integram.AttachmentFromReader(kind, r, name)
+}
+
+// NewServiceError returns the error with user-facing text and severity 'error'
+func NewServiceError(text string, err error) *ServiceError {
+	return integram.NewServiceError(text, err)
+}
+
+// ReauthAction suggests the user to authorize in the service again
+func ReauthAction() ServiceErrorAction {
+	return integram.ReauthAction()
+}
+
+// SettingsAction suggests the user to open the bot's settings in the private chat
+func SettingsAction() ServiceErrorAction {
+	return integram.SettingsAction()
+}
+
+// URLAction suggests the user to open the URL
+func URLAction(text string, url string) ServiceErrorAction {
+	return integram.URLAction(text, url)
+}
+
+// SnapshotDiff returns the sorted JSON names of the top-level fields that differ between prev and cur objects(structs or maps)
+func SnapshotDiff(prev interface{}, cur interface{}) ([]string, error) {
+	return integram.SnapshotDiff(prev, cur)
+}
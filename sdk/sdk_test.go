@@ -0,0 +1,142 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/requilence/integram"
+)
+
+// exported API of the current major Version. Build of this file fails when the signature is changed incompatibly,
+// so the change must bump the major version and update this list
+var (
+	_ func(Servicer, string)                 = Register
+	_ func(Translator)                       = SetTranslator
+	_ func(FileScanner)                      = SetFileScanner
+	_ func(error) bool                       = IsFileInfected
+	_ func()                                 = Run
+	_ func(*Context, string) (string, error) = GetRemoteFilePath
+	_ func(*Context, string) (string, error) = GetLocalFilePath
+
+	_ func(FileType, string, string) Attachment    = AttachmentFromURL
+	_ func(FileType, string, string) Attachment    = AttachmentFromFileID
+	_ func(FileType, string, string) Attachment    = AttachmentFromLocalPath
+	_ func(FileType, io.Reader, string) Attachment = AttachmentFromReader
+
+	_ func(string, error) *ServiceError                = NewServiceError
+	_ func() ServiceErrorAction                        = ReauthAction
+	_ func() ServiceErrorAction                        = SettingsAction
+	_ func(string, string) ServiceErrorAction          = URLAction
+	_ func(interface{}, interface{}) ([]string, error) = SnapshotDiff
+
+	_ func(*Context) *OutgoingMessage                                     = (*Context).NewMessage
+	_ func(*Context) *MessageBuilder                                      = (*Context).Reply
+	_ func(*Context) *Service                                             = (*Context).Service
+	_ func(*Context) *Bot                                                 = (*Context).Bot
+	_ func(*Context) context.Context                                      = (*Context).Ctx
+	_ func(*Context) bool                                                 = (*Context).Aborted
+	_ func(*Context) Branding                                             = (*Context).Branding
+	_ func(*Context) Recipient                                            = (*Context).Recipient
+	_ func(*Context, StatKey) error                                       = (*Context).StatInc
+	_ func(*Context, string, bool) error                                  = (*Context).AnswerCallbackQuery
+	_ func(*Context, string) error                                        = (*Context).EditPressedMessageText
+	_ func(*Context, string, string) (int, error)                         = (*Context).EditMessagesTextWithEventID
+	_ func(*Context, string, string, string, InlineKeyboard) (int, error) = (*Context).EditMessagesWithEventID
+	_ func(*Context, int64, string) (int, error)                          = (*Context).DeleteMessagesWithEventID
+	_ func(*Context, int64) *Context                                      = (*Context).ForkForChat
+	_ func(*Context, []int64, func(*Context) error) error                 = (*Context).ForEachChat
+	_ func(*Context, Attachment, string) error                            = (*Context).SendDocument
+
+	_ func(*OutgoingMessage, string) *OutgoingMessage               = (*OutgoingMessage).SetText
+	_ func(*OutgoingMessage, int64) *OutgoingMessage                = (*OutgoingMessage).SetChat
+	_ func(*OutgoingMessage, string) *OutgoingMessage               = (*OutgoingMessage).SetParseMode
+	_ func(*OutgoingMessage) *OutgoingMessage                       = (*OutgoingMessage).EnableHTML
+	_ func(*OutgoingMessage) *OutgoingMessage                       = (*OutgoingMessage).EnableMarkdown
+	_ func(*OutgoingMessage, InlineKeyboardMarkup) *OutgoingMessage = (*OutgoingMessage).SetInlineKeyboard
+	_ func(*OutgoingMessage, KeyboardMarkup, bool) *OutgoingMessage = (*OutgoingMessage).SetKeyboard
+	_ func(*OutgoingMessage, int) *OutgoingMessage                  = (*OutgoingMessage).SetReplyToMsgID
+	_ func(*OutgoingMessage, ...string) *OutgoingMessage            = (*OutgoingMessage).AddEventID
+	_ func(*OutgoingMessage, Attachment) *OutgoingMessage           = (*OutgoingMessage).SetAttachment
+	_ func(*OutgoingMessage) error                                  = (*OutgoingMessage).Send
+
+	_ func(*WebhookContext, string) string     = (*WebhookContext).Header
+	_ func(*WebhookContext, string) string     = (*WebhookContext).FormValue
+	_ func(*WebhookContext, interface{}) error = (*WebhookContext).JSON
+	_ func(*WebhookContext) (*[]byte, error)   = (*WebhookContext).RAW
+	_ func(*WebhookContext) string             = (*WebhookContext).HookID
+	_ func(*WebhookContext) string             = (*WebhookContext).RequestID
+
+	_ func(*InlineKeyboard, ...InlineButtons)                 = (*InlineKeyboard).AppendRows
+	_ func(*InlineKeyboard, *Bot, string, string)             = (*InlineKeyboard).AddPMSwitchButton
+	_ func(*InlineKeyboard, string) (int, int, *InlineButton) = (*InlineKeyboard).Find
+
+	_ func(*Service) *Bot                                        = (*Service).Bot
+	_ func(*Service, string, string, func(*Context) error) error = (*Service).RegisterJob
+)
+
+func TestVersion(t *testing.T) {
+	if !regexp.MustCompile(`^\d+\.\d+\.\d+$`).MatchString(Version) {
+		t.Errorf("Version = %q, want MAJOR.MINOR.PATCH", Version)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	reader := strings.NewReader("data")
+	tests := []struct {
+		name string
+		got  Attachment
+		want Attachment
+	}{
+		{"URL", AttachmentFromURL(FileTypePhoto, "https://example.com/a.png", "a.png"), Attachment{Kind: FileTypePhoto, Source: AttachmentSourceURL, URL: "https://example.com/a.png", Name: "a.png"}},
+		{"file ID", AttachmentFromFileID(FileTypeDocument, "BQAD", "report.pdf"), Attachment{Kind: FileTypeDocument, Source: AttachmentSourceFileID, FileID: "BQAD", Name: "report.pdf"}},
+		{"local path", AttachmentFromLocalPath(FileTypeDocument, "/tmp/report.pdf", ""), Attachment{Kind: FileTypeDocument, Source: AttachmentSourceLocal, LocalPath: "/tmp/report.pdf", Name: "report.pdf"}},
+		{"reader", AttachmentFromReader(FileTypeVoice, reader, "voice.ogg"), Attachment{Kind: FileTypeVoice, Source: AttachmentSourceStream, Reader: reader, Name: "voice.ogg"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%q. attachment = %+v, want %+v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestServiceErrors(t *testing.T) {
+	cause := errors.New("401 Unauthorized")
+	err := NewServiceError("Trello rejected the token", cause)
+	if err.Text != "Trello rejected the token" || err.Err != cause || err.Severity != ServiceErrorSeverityError {
+		t.Errorf("NewServiceError() = %+v", err)
+	}
+
+	if got, want := URLAction("Open", "https://example.com"), integram.URLAction("Open", "https://example.com"); got != want {
+		t.Errorf("URLAction() = %+v, want %+v", got, want)
+	}
+	if got, want := ReauthAction(), integram.ReauthAction(); got != want {
+		t.Errorf("ReauthAction() = %+v, want %+v", got, want)
+	}
+	if got, want := SettingsAction(), integram.SettingsAction(); got != want {
+		t.Errorf("SettingsAction() = %+v, want %+v", got, want)
+	}
+}
+
+func TestIsFileInfected(t *testing.T) {
+	if !IsFileInfected(integram.FileInfectedError{Name: "a.exe", Threat: "Eicar-Test-Signature"}) {
+		t.Errorf("IsFileInfected() = false for the infected file")
+	}
+	if IsFileInfected(errors.New("timeout")) {
+		t.Errorf("IsFileInfected() = true for the other error")
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	got, err := SnapshotDiff(map[string]interface{}{"status": "open", "title": "Bug"}, map[string]interface{}{"status": "closed", "title": "Bug"})
+	if err != nil {
+		t.Fatalf("SnapshotDiff() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"status"}) {
+		t.Errorf("SnapshotDiff() = %v, want [status]", got)
+	}
+}
@@ -0,0 +1,275 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrServiceQuotaExceeded is returned by ServiceCollection's Insert and Upsert when the service reached Service.CollectionsQuota
+var ErrServiceQuotaExceeded = errors.New("Service storage quota exceeded")
+
+// ServiceCollection is the service's namespaced collection declared in Service.Collections.
+// Queries are tagged with the request ID and inserted documents are counted against Service.CollectionsQuota
+type ServiceCollection struct {
+	ctx     *Context
+	name    string
+	service string
+	c       *mgo.Collection
+}
+
+func init() {
+	registerAdminCommand("quota", adminServicesQuota)
+}
+
+// serviceCollectionName returns the full collection name, e.g. "s_trello_cards"
+func serviceCollectionName(service string, name string) string {
+	return "s_" + service + "_" + name
+}
+
+// ensureServiceCollections creates the indexes declared in Service.Collections
+func ensureServiceCollections(db *mgo.Database, service *Service) {
+	for name, indexes := range service.Collections {
+		for _, index := range indexes {
+			err := db.C(serviceCollectionName(service.Name, name)).EnsureIndex(index)
+			if err != nil {
+				log.WithError(err).WithField("service", service.Name).WithField("collection", name).Errorf("Can't ensure the index %v", index.Key)
+			}
+		}
+	}
+}
+
+// ServiceCollection returns the service's collection declared in Service.Collections
+func (c *Context) ServiceCollection(name string) *ServiceCollection {
+	if s := c.Service(); s != nil {
+		if _, declared := s.Collections[name]; !declared {
+			c.Log().WithField("collection", name).Error("ServiceCollection: collection is not declared in Service.Collections, indexes won't be ensured")
+		}
+	}
+
+	return &ServiceCollection{ctx: c, name: name, service: c.ServiceName, c: c.db.C(serviceCollectionName(c.ServiceName, name))}
+}
+
+// RequestID returns the ID of the current webhook or update. It is used to tag the queries and in logs
+func (c *Context) RequestID() string {
+	if c.requestID == "" {
+		c.requestID = rndStr.Get(10)
+	}
+	return c.requestID
+}
+
+// Collection returns the underlying collection. Operations on it are not accounted in the quota
+func (sc *ServiceCollection) Collection() *mgo.Collection {
+	return sc.c
+}
+
+func (sc *ServiceCollection) comment() string {
+	return fmt.Sprintf("%s %s", sc.service, sc.ctx.RequestID())
+}
+
+func (sc *ServiceCollection) log(err error, op string) {
+	if err != nil && err != mgo.ErrNotFound {
+		sc.ctx.Log().WithError(err).WithField("collection", sc.name).WithField("request", sc.ctx.RequestID()).Errorf("ServiceCollection %s failed", op)
+	}
+}
+
+// Find prepares the query tagged with the request ID
+func (sc *ServiceCollection) Find(query interface{}) *mgo.Query {
+	return sc.c.Find(query).Comment(sc.comment())
+}
+
+// FindId prepares the query by _id tagged with the request ID
+func (sc *ServiceCollection) FindId(id interface{}) *mgo.Query {
+	return sc.c.FindId(id).Comment(sc.comment())
+}
+
+// Count returns the number of documents in the collection
+func (sc *ServiceCollection) Count() (int, error) {
+	return sc.c.Count()
+}
+
+// Insert inserts the documents if the service's quota allows it
+func (sc *ServiceCollection) Insert(docs ...interface{}) error {
+	err := sc.reserveQuota(len(docs))
+	if err != nil {
+		return err
+	}
+
+	err = sc.c.Insert(docs...)
+	if err != nil {
+		// some of the documents may be inserted before the error
+		sc.recountQuota()
+	}
+	sc.log(err, "Insert")
+	return err
+}
+
+// Update updates the single document
+func (sc *ServiceCollection) Update(selector interface{}, update interface{}) error {
+	err := sc.c.Update(selector, update)
+	sc.log(err, "Update")
+	return err
+}
+
+// UpdateId updates the single document by _id
+func (sc *ServiceCollection) UpdateId(id interface{}, update interface{}) error {
+	return sc.Update(bson.M{"_id": id}, update)
+}
+
+// UpdateAll updates all matched documents
+func (sc *ServiceCollection) UpdateAll(selector interface{}, update interface{}) (*mgo.ChangeInfo, error) {
+	info, err := sc.c.UpdateAll(selector, update)
+	sc.log(err, "UpdateAll")
+	return info, err
+}
+
+// Upsert updates the document or inserts it if the service's quota allows it
+func (sc *ServiceCollection) Upsert(selector interface{}, update interface{}) (*mgo.ChangeInfo, error) {
+	err := sc.reserveQuota(1)
+	if err == ErrServiceQuotaExceeded {
+		// the document can still be updated
+		err = sc.c.Update(selector, update)
+		if err == mgo.ErrNotFound {
+			return nil, ErrServiceQuotaExceeded
+		}
+		sc.log(err, "Upsert")
+		return &mgo.ChangeInfo{Updated: 1}, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := sc.c.Upsert(selector, update)
+	if err != nil {
+		sc.recountQuota()
+	} else if info.UpsertedId == nil {
+		sc.releaseQuota(1)
+	}
+	sc.log(err, "Upsert")
+	return info, err
+}
+
+// Remove removes the single document
+func (sc *ServiceCollection) Remove(selector interface{}) error {
+	err := sc.c.Remove(selector)
+	if err == nil {
+		sc.releaseQuota(1)
+	} else if err != mgo.ErrNotFound {
+		sc.recountQuota()
+	}
+	sc.log(err, "Remove")
+	return err
+}
+
+// RemoveId removes the single document by _id
+func (sc *ServiceCollection) RemoveId(id interface{}) error {
+	return sc.Remove(bson.M{"_id": id})
+}
+
+// RemoveAll removes all matched documents
+func (sc *ServiceCollection) RemoveAll(selector interface{}) (*mgo.ChangeInfo, error) {
+	info, err := sc.c.RemoveAll(selector)
+	if err == nil {
+		sc.releaseQuota(info.Removed)
+	} else {
+		sc.recountQuota()
+	}
+	sc.log(err, "RemoveAll")
+	return info, err
+}
+
+// reserveQuota increments the service's documents counter. Returns ErrServiceQuotaExceeded if the quota doesn't allow n more documents
+func (sc *ServiceCollection) reserveQuota(n int) error {
+	s := sc.ctx.Service()
+	if s == nil || n == 0 {
+		return nil
+	}
+
+	if s.CollectionsQuota > 0 && n > s.CollectionsQuota {
+		return ErrServiceQuotaExceeded
+	}
+
+	f := bson.M{"_id": sc.service}
+	if s.CollectionsQuota > 0 {
+		f["docs"] = bson.M{"$lte": s.CollectionsQuota - n}
+	}
+
+	_, err := sc.ctx.db.C("services_quota").Upsert(f, bson.M{"$inc": bson.M{"docs": n}})
+	if mgo.IsDup(err) {
+		// counter exists, but the filter didn't match it
+		return ErrServiceQuotaExceeded
+	}
+	return err
+}
+
+func (sc *ServiceCollection) releaseQuota(n int) {
+	if n == 0 {
+		return
+	}
+
+	err := sc.ctx.db.C("services_quota").UpdateId(sc.service, bson.M{"$inc": bson.M{"docs": -n}})
+	if err != nil && err != mgo.ErrNotFound {
+		sc.ctx.Log().WithError(err).Error("Can't release the service's storage quota")
+	}
+}
+
+// recountQuota sets the service's documents counter to the number of documents in its collections. Used when the result of the failed operation is unknown
+func (sc *ServiceCollection) recountQuota() {
+	names := []string{sc.name}
+	if s := sc.ctx.Service(); s != nil {
+		for name := range s.Collections {
+			if name != sc.name {
+				names = append(names, name)
+			}
+		}
+	}
+
+	docs := 0
+	for _, name := range names {
+		n, err := sc.ctx.db.C(serviceCollectionName(sc.service, name)).Count()
+		if err != nil {
+			sc.ctx.Log().WithError(err).WithField("collection", name).Error("Can't recount the service's storage quota")
+			return
+		}
+		docs += n
+	}
+
+	_, err := sc.ctx.db.C("services_quota").UpsertId(sc.service, bson.M{"$set": bson.M{"docs": docs}})
+	if err != nil {
+		sc.ctx.Log().WithError(err).Error("Can't recount the service's storage quota")
+	}
+}
+
+// adminServicesQuota: /integram quota
+func adminServicesQuota(c *Context, args []string) (string, error) {
+	var usage []struct {
+		Service string `bson:"_id"`
+		Docs    int
+	}
+
+	err := c.db.C("services_quota").Find(nil).All(&usage)
+	if err != nil {
+		return "", err
+	}
+
+	if len(usage) == 0 {
+		return "No service collections used yet", nil
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Docs > usage[j].Docs })
+
+	var lines []string
+	for _, u := range usage {
+		line := fmt.Sprintf("%s: %d documents", u.Service, u.Docs)
+		if s, _ := serviceByName(u.Service); s != nil && s.CollectionsQuota > 0 {
+			line += fmt.Sprintf(" of %d (%.0f%%)", s.CollectionsQuota, float64(u.Docs)*100/float64(s.CollectionsQuota))
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,67 @@
+package integram
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestServiceCollection_quota(t *testing.T) {
+	s, _ := serviceByName("servicewithbottoken")
+	s.CollectionsQuota = 2
+	defer func() { s.CollectionsQuota = 0 }()
+
+	ctx := &Context{db: db, ServiceName: s.Name}
+	sc := ctx.ServiceCollection("cards")
+
+	db.C("services_quota").RemoveId(s.Name)
+	sc.RemoveAll(nil)
+	defer db.C("services_quota").RemoveId(s.Name)
+	defer sc.Collection().DropCollection()
+
+	if sc.Collection().Name != "s_servicewithbottoken_cards" {
+		t.Errorf("ServiceCollection() name = %s", sc.Collection().Name)
+	}
+
+	if err := sc.Insert(bson.M{"_id": 1}, bson.M{"_id": 2}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	if err := sc.Insert(bson.M{"_id": 3}); err != ErrServiceQuotaExceeded {
+		t.Errorf("Insert() over quota error = %v, want %v", err, ErrServiceQuotaExceeded)
+	}
+
+	// existing document can be updated with Upsert
+	if _, err := sc.Upsert(bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "card"}}); err != nil {
+		t.Errorf("Upsert() existing error = %v", err)
+	}
+
+	if _, err := sc.Upsert(bson.M{"_id": 3}, bson.M{"$set": bson.M{"name": "card"}}); err != ErrServiceQuotaExceeded {
+		t.Errorf("Upsert() new over quota error = %v, want %v", err, ErrServiceQuotaExceeded)
+	}
+
+	if err := sc.RemoveId(1); err != nil {
+		t.Fatalf("RemoveId() error = %v", err)
+	}
+
+	if err := sc.Insert(bson.M{"_id": 3}); err != nil {
+		t.Errorf("Insert() after remove error = %v", err)
+	}
+
+	var q struct{ Docs int }
+	db.C("services_quota").FindId(s.Name).One(&q)
+	if q.Docs != 2 {
+		t.Errorf("quota counter = %d, want 2", q.Docs)
+	}
+
+	// the first document is inserted before the duplicate key error
+	s.CollectionsQuota = 0
+	if err := sc.Insert(bson.M{"_id": 4}, bson.M{"_id": 2}); err == nil {
+		t.Fatalf("Insert() of the duplicate didn't fail")
+	}
+
+	db.C("services_quota").FindId(s.Name).One(&q)
+	if q.Docs != 3 {
+		t.Errorf("quota counter after the failed insert = %d, want 3", q.Docs)
+	}
+}
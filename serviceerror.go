@@ -0,0 +1,131 @@
+package integram
+
+import (
+	"fmt"
+)
+
+// ServiceErrorSeverity specifies how the error is presented to the user
+type ServiceErrorSeverity string
+
+const (
+	ServiceErrorSeverityInfo    ServiceErrorSeverity = "info"
+	ServiceErrorSeverityWarning ServiceErrorSeverity = "warning"
+	ServiceErrorSeverityError   ServiceErrorSeverity = "error"
+)
+
+const (
+	serviceErrorActionReauth   = "reauth"
+	serviceErrorActionSettings = "settings"
+	serviceErrorActionURL      = "url"
+)
+
+var serviceErrorSeverityEmoji = map[ServiceErrorSeverity]string{
+	ServiceErrorSeverityInfo:    "ℹ️",
+	ServiceErrorSeverityWarning: "⚠️",
+	ServiceErrorSeverityError:   "❗️",
+}
+
+// ServiceErrorAction is the button suggested to the user to fix the error
+type ServiceErrorAction struct {
+	Type string
+	Text string
+	URL  string `bson:",omitempty"` // only for the custom URL action. Reauth and settings URLs are resolved when rendering
+}
+
+// ServiceError can be returned from any handler to show the user-facing text with the remediation buttons instead of the generic error
+type ServiceError struct {
+	Text     string // user-facing text
+	Severity ServiceErrorSeverity
+	Actions  []ServiceErrorAction
+	Err      error // underlying error, only logged
+}
+
+// NewServiceError returns the error with user-facing text and severity 'error'
+func NewServiceError(text string, err error) *ServiceError {
+	return &ServiceError{Text: text, Severity: ServiceErrorSeverityError, Err: err}
+}
+
+// ReauthAction suggests the user to authorize in the service again
+func ReauthAction() ServiceErrorAction {
+	return ServiceErrorAction{Type: serviceErrorActionReauth, Text: "Authorize"}
+}
+
+// SettingsAction suggests the user to open the bot's settings in the private chat
+func SettingsAction() ServiceErrorAction {
+	return ServiceErrorAction{Type: serviceErrorActionSettings, Text: "Open settings"}
+}
+
+// URLAction suggests the user to open the URL
+func URLAction(text string, url string) ServiceErrorAction {
+	return ServiceErrorAction{Type: serviceErrorActionURL, Text: text, URL: url}
+}
+
+// SetSeverity sets the error's severity
+func (e *ServiceError) SetSeverity(severity ServiceErrorSeverity) *ServiceError {
+	e.Severity = severity
+	return e
+}
+
+// AddActions adds the buttons to the error's message
+func (e *ServiceError) AddActions(actions ...ServiceErrorAction) *ServiceError {
+	e.Actions = append(e.Actions, actions...)
+	return e
+}
+
+func (e *ServiceError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Text, e.Err.Error())
+	}
+	return e.Text
+}
+
+// AsServiceError returns the ServiceError if err is the one
+func AsServiceError(err error) (*ServiceError, bool) {
+	se, ok := err.(*ServiceError)
+	return se, ok && se != nil
+}
+
+// renderServiceError sends the ServiceError's text and actions to the current chat. Returns false if err isn't ServiceError
+func (c *Context) renderServiceError(err error) bool {
+	se, ok := AsServiceError(err)
+	if !ok {
+		return false
+	}
+
+	b := c.Branding()
+	text := b.EmojiPrefix(serviceErrorSeverityEmoji[se.Severity]) + HTMLRichText{}.EncodeEntities(b.ErrorText(se.Text))
+
+	msg := c.NewMessage().SetText(text).EnableHTML().DisableWebPreview().applyBrandingFooter()
+
+	buttons := c.serviceErrorButtons(se.Actions)
+	if len(buttons) > 0 {
+		msg.SetInlineKeyboard(buttons.Markup(1, ""))
+	}
+
+	if c.Message != nil {
+		msg.SetReplyToMsgID(c.Message.MsgID)
+	}
+
+	if sendErr := msg.Send(); sendErr != nil {
+		c.Log().WithError(sendErr).Error("Can't send the service error")
+	}
+
+	return true
+}
+
+func (c *Context) serviceErrorButtons(actions []ServiceErrorAction) InlineButtons {
+	buttons := InlineButtons{}
+	for _, action := range actions {
+		switch action.Type {
+		case serviceErrorActionReauth:
+			if c.User.ID != 0 {
+				buttons.AddURL(c.User.OauthInitURL(), action.Text)
+			}
+		case serviceErrorActionSettings:
+			buttons.AddURL(c.Bot().PMURL("settings"), action.Text)
+		case serviceErrorActionURL:
+			buttons.AddURL(action.URL, action.Text)
+		}
+	}
+	return buttons
+}
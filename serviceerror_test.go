@@ -0,0 +1,41 @@
+package integram
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestServiceError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *ServiceError
+		want string
+	}{
+		{"text only", NewServiceError("Board not found", nil), "Board not found"},
+		{"with underlying", NewServiceError("Board not found", errors.New("404")), "Board not found: 404"},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Error(); got != tt.want {
+			t.Errorf("%q. ServiceError.Error() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAsServiceError(t *testing.T) {
+	var nilServiceError *ServiceError
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"service error", NewServiceError("Token expired", nil).AddActions(ReauthAction()), true},
+		{"plain error", errors.New("Token expired"), false},
+		{"nil", nil, false},
+		{"typed nil", nilServiceError, false},
+	}
+	for _, tt := range tests {
+		if _, got := AsServiceError(tt.err); got != tt.want {
+			t.Errorf("%q. AsServiceError() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -55,6 +55,9 @@ type Service struct {
 	DefaultOAuth2  *DefaultOAuth2 // Cloud(not self-hosted) app data
 	OAuthRequired  bool           // Is OAuth required in order to receive webhook updates
 
+	// Name of the service for the same vendor (f.e. "gitlab" for GitLab CI) which OAuth token can be used after the user's consent instead of the separate authorization. See Context.OfferSharedOAuth
+	SharedOAuthFrom string
+
 	JobsPool int // Worker pool to be created for service. Default to 1 worker. Workers will be inited only if jobs types are available
 
 	JobOldPrefix 	string
@@ -70,19 +73,46 @@ type Service struct {
 	// Handler to produce the user/chat search query based on the http request. Set queryChat to true to perform chat search
 	TokenHandler func(ctx *Context, request *WebhookContext) (queryChat bool, bsonQuery map[string]interface{}, err error)
 
-	// Handler to receive webhooks from outside
+	// Handler to receive webhooks from outside. Called for each target chat separately, use ctx.Recipient() to render the message in the chat's language and timezone
 	WebhookHandler func(ctx *Context, request *WebhookContext) error
 
+	// Handler to receive the burst of webhooks at once instead of WebhookHandler, e.g. push storm of commits rendered as the single message.
+	// Webhooks of the same hook in the same chat with the same WebhookBurstKey are buffered for WebhookBurstWindow. Batch is in the order of receiving
+	WebhookBurstHandler func(ctx *Context, batch []*WebhookContext) error
+
+	// Optional key to aggregate the webhooks, e.g. the repository and the branch. Empty key means the webhook is passed to WebhookHandler as usual
+	WebhookBurstKey func(ctx *Context, request *WebhookContext) (string, error)
+
+	// Period the webhooks are buffered for since the first one in the burst. Default to 5 seconds
+	WebhookBurstWindow time.Duration
+
+	// Handler to register the webhook in the upstream again after it was paused or disabled there. Called when chat admin presses the "reconnect" button
+	// ctx.User is the admin who pressed it, so the user's stored credentials can be used
+	WebhookReconnectHandler func(ctx *Context, webhookURL string) error
+
+	// Handler to check that the webhook is registered in the upstream, e.g. via the upstream's API. Used by /diagnose
+	WebhookStatusHandler func(ctx *Context, webhookURL string) (registered bool, err error)
+
 	// Handler to receive already prepared data. Useful for manual interval grabbing jobs
 	EventHandler func(ctx *Context, data interface{}) error
 
 	// Worker wil be run in goroutine after service and framework started. In case of error or crash it will be restarted
 	Worker func(ctx *Context) error
 
+	// Polls the targets the chats are subscribed to with Context.Subscribe and sends their new entries, e.g. the RSS feeds
+	Poller *Poller
+
+	// Jobs run periodically by the cron spec on one instance at the time, e.g. the daily digest
+	CronJobs []CronJob
+
 	// Handler to receive new messages from Telegram
 	TGNewMessageHandler func(ctx *Context) error
 
-	// Handler to receive new messages from Telegram
+	// Returns the service's current reply keyboard and the text sent with its inline equivalent. When set, the chat's stored reply keyboard is migrated
+	// to the inline one after the user presses its button, see Context.MigrateReplyKeyboard. Empty layout skips the chat
+	ReplyKeyboardMigration func(ctx *Context) (layout Keyboard, text string)
+
+	// Handler to receive edits of the incoming messages from Telegram. Text before the edit is available in ctx.MessagePrevText
 	TGEditMessageHandler func(ctx *Context) error
 
 	// Handler to receive inline queries from Telegram
@@ -91,6 +121,30 @@ type Service struct {
 	// Handler to receive chosen inline results from Telegram
 	TGChosenInlineResultHandler func(ctx *Context) error
 
+	// Object types users can create from any chat by typing '@bot new <type>: <title>', see QuickCreate
+	QuickCreates []QuickCreate
+
+	// Handler to receive answers in the non-anonymous polls sent by the bot, see OutgoingMessage.SetPoll. Answer is available in ctx.PollAnswer
+	PollAnswerHandler func(ctx *Context) error
+
+	// Commands shown in Telegram's autocomplete. Registered with setMyCommands on startup when changed
+	Commands []BotCommand
+
+	// Conversations started with Context.StartWizard. User's messages are routed to the wizard's current step before TGNewMessageHandler
+	Wizards []Wizard
+
+	// Forms started with Context.StartForm. Filled in the private chat with the bot
+	Forms []Form
+
+	// Directory with the translation files '<lang>.json' used by Context.T, e.g. de.json: {"Hello, %s": "Hallo, %s", "_name": "Deutsch"}.
+	// Users can choose the language with /language
+	TranslationsDir string
+	// Language of the texts used as the keys of the translations. "en" if empty
+	DefaultLanguage string
+
+	// Set when the service is retired: notices are sent to the affected chats, then the service is switched to read-only and disabled by the dates
+	Deprecation *ServiceDeprecation
+
 	OAuthSuccessful func(ctx *Context) error
 	// Can be used for services with tiny load
 	UseWebhookInsteadOfLongPolling bool
@@ -98,8 +152,44 @@ type Service struct {
 	// Can be used to automatically clean up old messages metadata from database
 	RemoveMessagesOlderThan *time.Duration
 
+	// Max execution time of the message, callback and webhook handlers. Overrides INTEGRAM_HANDLER_TIMEOUT, negative value disables the deadline
+	HandlerTimeout time.Duration
+
+	// Identical webhook payloads received on the same hook within this window are ignored, e.g. resent by upstream with the new delivery ID after our 5xx. Overrides INTEGRAM_WEBHOOK_DEDUP_WINDOW, negative value disables the dedup
+	WebhookDedupWindow time.Duration
+
+	// Returns the upstream's idempotency key of the webhook, e.g. WebhookDeliveryIDKey. Webhooks with the already received key are ignored, so upstream's retry doesn't produce the duplicate messages. Empty key skips the check
+	WebhookIdempotencyKey func(wc *WebhookContext) string
+
+	// How long the received idempotency keys are remembered. Default is 24h
+	WebhookIdempotencyTTL time.Duration
+
+	// Returns the upstream's identity of c.User, e.g. the GitHub login. Returned by Context.ResolveUsername to map the "@username" mentions to the upstream's users. Empty if the user isn't linked
+	UserIdentity func(c *Context) (string, error)
+
+	// Accept the payloads encrypted with the chat's key, so intermediaries and logs never see the plaintext. Chat admins get the key with /webhook encrypt
+	WebhookEncryption bool
+
+	// Store the webhooks and answer 202 immediately. WebhookHandler is called by the service's worker pool with retries, so the heavy handlers don't block upstream.
+	// Webhooks failed after the last retry are moved to the "webhooks_dead" collection, see /admin deadwebhooks
+	WebhookAsync bool
+
+	// Common rules of the chats' notification filters offered as buttons by /filter, e.g. "author != bot". Fields are passed with Context.SendEventToChats or checked with Chat.AcceptsEvent
+	NotificationFilterPresets []string
+
+	// Collections available with Context.ServiceCollection and their indexes, e.g. {"cards": {{Key: []string{"boardid"}}}}
+	Collections map[string][]mgo.Index
+	// Max number of documents in all Collections. 0 means unlimited
+	CollectionsQuota int
+
 	machineURL string // in case of multi-instance mode URL is used to talk with the service
 
+	middlewares []Middleware // added with Use
+
+	cronJobs []cronJob // CronJobs and the ones added with RegisterJob
+
+	translations map[string]map[string]string // loaded from TranslationsDir per language
+
 	rootPackagePath string
 }
 
@@ -214,6 +304,10 @@ func healthCheck(db *mgo.Database) error {
 func init() {
 
 	jobs.Config.Db.Address = Config.RedisURL
+	if renderMode {
+		return
+	}
+
 	if Config.IsMainInstance() {
 		err := loadStandAloneServicesFromFile()
 		if err != nil {
@@ -403,11 +497,16 @@ func (s *Service) trimFuncPath(fullPath string) string{
 // Register the service's config and corresponding botToken
 func Register(servicer Servicer, botToken string) {
 	//jobs.Config.Db.Address="192.168.1.101:6379"
-	db := mongoSession.Clone().DB(mongo.Database)
 	service := servicer.Service()
-	err := migrations(db, service.Name)
-	if err != nil {
-		log.Fatalf("failed to apply migrations: %s", err.Error())
+
+	var db *mgo.Database
+	var err error
+	if !renderMode {
+		db = mongoSession.Clone().DB(mongo.Database)
+		err = migrations(db, service.Name)
+		if err != nil {
+			log.Fatalf("failed to apply migrations: %s", err.Error())
+		}
 	}
 
 	if service.DefaultOAuth1 != nil {
@@ -425,9 +524,20 @@ func Register(servicer Servicer, botToken string) {
 	service.DefaultBaseURL.RawPath = ""
 	service.DefaultBaseURL.RawQuery = ""
 
+	for _, job := range service.CronJobs {
+		err := service.RegisterJob(job.Name, job.Spec, job.Handler)
+		if err != nil {
+			log.WithError(err).WithField("service", service.Name).Panic("Can't register the cron job")
+		}
+	}
+
 	services[service.Name] = service
 
-	if len(service.Jobs) > 0 || service.OAuthSuccessful != nil {
+	if len(service.Collections) > 0 && !renderMode {
+		ensureServiceCollections(db, service)
+	}
+
+	if len(service.Jobs) > 0 || service.OAuthSuccessful != nil || service.WebhookAsync {
 		if service.JobsPool == 0 {
 			service.JobsPool = 1
 		}
@@ -490,22 +600,28 @@ func Register(servicer Servicer, botToken string) {
 
 		}
 
+		if service.WebhookAsync {
+			registerAsyncWebhookJob(service)
+		}
+
 		rootPackagePath := reflect.TypeOf(servicer).PkgPath()
 		service.rootPackagePath = rootPackagePath
 
 		log.Debugf("RootPackagePath of %s is %s", service.Name, rootPackagePath)
 
-		go func(pool *jobs.Pool, service *Service) {
-			time.Sleep(time.Second * 5)
+		if !renderMode {
+			go func(pool *jobs.Pool, service *Service) {
+				time.Sleep(time.Second * 5)
 
-			err = pool.Start()
+				err = pool.Start()
 
-			if err != nil {
-				log.Panicf("Can't start jobs pool: %v\n", err)
-			}
-			log.Infof("%s service: workers pool [%d] started", service.Name, service.JobsPool)
+				if err != nil {
+					log.Panicf("Can't start jobs pool: %v\n", err)
+				}
+				log.Infof("%s service: workers pool [%d] started", service.Name, service.JobsPool)
 
-		}(pool, service)
+			}(pool, service)
+		}
 
 	}
 
@@ -526,7 +642,33 @@ func Register(servicer Servicer, botToken string) {
 			actionFuncs[service.getShortFuncPath(actionFunc)] = actionFunc
 		}
 	}
-	if botToken == "" {
+
+	if service.TranslationsDir != "" {
+		service.translations, err = loadTranslations(service.TranslationsDir)
+		if err != nil {
+			log.WithError(err).WithField("dir", service.TranslationsDir).Panic("Can't load the translations")
+		}
+	}
+
+	for i := range service.Forms {
+		w, err := service.Forms[i].wizard()
+		if err != nil {
+			log.WithError(err).WithField("form", service.Forms[i].Name).Panic("Can't register the form")
+		}
+		service.Wizards = append(service.Wizards, w)
+	}
+
+	if service.WebhookReconnectHandler != nil {
+		actionFuncs[service.getShortFuncPath(webhookReconnectAction)] = webhookReconnectAction
+	}
+
+	actionFuncs[service.getShortFuncPath(viewerActionPressed)] = viewerActionPressed
+	actionFuncs[service.getShortFuncPath(diagnoseFixAction)] = diagnoseFixAction
+
+	if service.SharedOAuthFrom != "" {
+		actionFuncs[service.getShortFuncPath(oauthSharingConsentAction)] = oauthSharingConsentAction
+	}
+	if botToken == "" || renderMode {
 		return
 	}
 
@@ -540,6 +682,14 @@ func Register(servicer Servicer, botToken string) {
 		go ServiceWorkerAutorespawnGoroutine(service)
 	}
 
+	if service.Poller != nil {
+		go servicePoller(service)
+	}
+
+	if len(service.cronJobs) > 0 {
+		go serviceCronScheduler(service)
+	}
+
 	// todo: here is possible bug if service just want to use inline keyboard callbacks via setCallbackAction
 	if service.TGNewMessageHandler == nil && service.TGInlineQueryHandler == nil {
 		return
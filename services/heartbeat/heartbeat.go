@@ -0,0 +1,358 @@
+// Package heartbeat is the built-in "dead man's switch" service. Chat gets the unique URL per check which the cron job must ping periodically.
+// When pings stop for longer than the check's interval the chat is alerted, then reminded while the check is down, and notified when it's back
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/requilence/integram"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// max number of the checks per chat
+const maxChecksPerChat = 20
+
+// min interval between the pings
+const minInterval = time.Minute
+
+const defaultEscalationInterval = time.Hour
+
+const defaultEscalations = 2
+
+const helpText = "Get alerted when your cron jobs stop running:\n" +
+	"/heartbeat add <name> <interval> [grace] – create the check, e.g. /heartbeat add backup 24h 30m\n" +
+	"/heartbeat remove <name> – remove the check\n" +
+	"/heartbeat list – checks of this chat\n\n" +
+	"Request the check's URL at the end of the job, e.g. curl -fsS <url>. Append &status=fail to report the failure"
+
+var checkNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_\-.]{1,32}$`)
+
+var errCheckName = errors.New("Name must be 1-32 letters, digits, '_', '-' or '.'")
+
+// Config of the service
+type Config struct {
+	integram.BotConfig
+
+	// Interval of the reminders while the check is down. Default to 1 hour
+	EscalationInterval time.Duration
+	// Number of the reminders after the alert. Default to 2, negative value disables the reminders
+	Escalations int
+}
+
+// check is stored in the service's "checks" collection
+type check struct {
+	ID       bson.ObjectId `bson:"_id"`
+	ChatID   int64         `bson:"chatid"`
+	Name     string        `bson:"name"`
+	Interval time.Duration `bson:"interval"`
+	Grace    time.Duration `bson:"grace"`
+	LastPing *time.Time    `bson:"lastping,omitempty"`
+	Deadline time.Time     `bson:"deadline"` // chat is alerted if there is no ping until then
+}
+
+// isDown returns true if the chat was already alerted about the check
+func (ch *check) isDown(now time.Time) bool {
+	return !now.Before(ch.Deadline)
+}
+
+// eventID is used to cancel the check's scheduled alerts
+func (ch *check) eventID() string {
+	return fmt.Sprintf("heartbeat_%d_%s", ch.ChatID, ch.Name)
+}
+
+// parseCheckArgs parses the arguments of /heartbeat add. Grace period defaults to the 10th of the interval, but at least 1 minute
+func parseCheckArgs(args []string) (name string, interval time.Duration, grace time.Duration, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "", 0, 0, errors.New("Usage: /heartbeat add <name> <interval> [grace], e.g. /heartbeat add backup 24h 30m")
+	}
+
+	name = args[0]
+	if !checkNameRegexp.MatchString(name) {
+		return "", 0, 0, errCheckName
+	}
+
+	interval, err = time.ParseDuration(args[1])
+	if err != nil || interval < minInterval {
+		return "", 0, 0, fmt.Errorf("Interval must be at least %s, e.g. 15m, 1h or 24h", formatDuration(minInterval))
+	}
+
+	grace = interval / 10
+	if grace < time.Minute {
+		grace = time.Minute
+	}
+
+	if len(args) == 3 {
+		grace, err = time.ParseDuration(args[2])
+		if err != nil || grace < 0 {
+			return "", 0, 0, errors.New("Grace period must be the duration, e.g. 5m")
+		}
+	}
+
+	return name, interval, grace, nil
+}
+
+// formatDuration returns the duration without the zero units, e.g. "1h30m" instead of "1h30m0s"
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// Service returns the service produced from the config
+func (cfg Config) Service() *integram.Service {
+	if cfg.EscalationInterval == 0 {
+		cfg.EscalationInterval = defaultEscalationInterval
+	}
+	if cfg.Escalations == 0 {
+		cfg.Escalations = defaultEscalations
+	}
+
+	return &integram.Service{
+		Name:        "heartbeat",
+		NameToPrint: "Heartbeat",
+		Collections: map[string][]mgo.Index{
+			"checks": {{Key: []string{"chatid", "name"}, Unique: true}},
+		},
+		Commands: []integram.BotCommand{
+			{Command: "heartbeat", Description: "Manage the checks: add <name> <interval>, remove <name>, list"},
+		},
+		// identical pings must not be ignored
+		WebhookDedupWindow: -1,
+		TokenHandler:       tokenHandler,
+		WebhookHandler: func(c *integram.Context, wc *integram.WebhookContext) error {
+			return pingHandler(c, wc, cfg)
+		},
+		TGNewMessageHandler: func(c *integram.Context) error {
+			return newMessageHandler(c, cfg)
+		},
+	}
+}
+
+// tokenHandler passes the GET pings to WebhookHandler, by default they are answered with the instructions
+func tokenHandler(c *integram.Context, wc *integram.WebhookContext) (bool, map[string]interface{}, error) {
+	return true, bson.M{"hooks.token": wc.HookID()}, nil
+}
+
+// schedule replaces the check's scheduled alert and reminders with the ones starting at the check's deadline
+func schedule(c *integram.Context, cfg Config, ch *check, reason string) error {
+	_, err := c.CancelScheduledMessages(ch.eventID())
+	if err != nil {
+		return err
+	}
+
+	r := c.Recipient()
+	lastPing := "never"
+	if ch.LastPing != nil {
+		lastPing = r.In(*ch.LastPing).Format("Jan 2 15:04 MST")
+	}
+
+	err = c.NewMessage().
+		SetText(fmt.Sprintf("🔴 <b>%s</b> is down: %s. Last ping: %s", ch.Name, reason, lastPing)).
+		EnableHTML().
+		AddEventID(ch.eventID()).
+		SendAt(ch.Deadline)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= cfg.Escalations; i++ {
+		at := ch.Deadline.Add(cfg.EscalationInterval * time.Duration(i))
+		err = c.NewMessage().
+			SetText(fmt.Sprintf("🔴 <b>%s</b> is still down for %s. Last ping: %s", ch.Name, formatDuration(at.Sub(ch.Deadline)), lastPing)).
+			EnableHTML().
+			AddEventID(ch.eventID()).
+			SendAt(at)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pingHandler receives the check's pings. Successful ping moves the deadline and sends the recovery notice if the check was down.
+// Ping with status=fail alerts the chat immediately
+func pingHandler(c *integram.Context, wc *integram.WebhookContext, cfg Config) error {
+	var ch check
+	err := c.ServiceCollection("checks").Find(bson.M{"chatid": c.Chat.ID, "name": wc.Query("check")}).One(&ch)
+	if err == mgo.ErrNotFound {
+		wc.RespondString(http.StatusNotFound, "Unknown check, see /heartbeat list in the chat")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	wasDown := ch.isDown(now)
+	downSince := ch.Deadline
+
+	if wc.Query("status") == "fail" {
+		wc.RespondString(http.StatusOK, "OK")
+		if wasDown {
+			return nil
+		}
+
+		ch.Deadline = now
+		err = c.ServiceCollection("checks").UpdateId(ch.ID, bson.M{"$set": bson.M{"deadline": ch.Deadline}})
+		if err != nil {
+			return err
+		}
+		return schedule(c, cfg, &ch, "the job reported the failure")
+	}
+
+	ch.LastPing = &now
+	ch.Deadline = now.Add(ch.Interval + ch.Grace)
+	err = c.ServiceCollection("checks").UpdateId(ch.ID, bson.M{"$set": bson.M{"lastping": ch.LastPing, "deadline": ch.Deadline}})
+	if err != nil {
+		return err
+	}
+
+	err = schedule(c, cfg, &ch, fmt.Sprintf("no ping for %s", formatDuration(ch.Interval+ch.Grace)))
+	if err != nil {
+		return err
+	}
+
+	wc.RespondString(http.StatusOK, "OK")
+	if !wasDown {
+		return nil
+	}
+
+	return c.NewMessage().
+		SetText(fmt.Sprintf("✅ <b>%s</b> is back up after %s", ch.Name, formatDuration(now.Sub(downSince).Round(time.Minute)))).
+		EnableHTML().
+		Send()
+}
+
+func newMessageHandler(c *integram.Context, cfg Config) error {
+	command, param := c.Message.GetCommand()
+	if command == "start" || command == "help" {
+		return c.NewMessage().SetText(helpText).Send()
+	} else if command != "heartbeat" {
+		return nil
+	}
+
+	args := strings.Fields(param)
+	if len(args) == 0 {
+		return c.NewMessage().SetText(helpText).Send()
+	}
+
+	switch args[0] {
+	case "list":
+		return listChecks(c)
+	case "add", "remove":
+		isAdmin, err := c.IsChatAdmin()
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return c.NewMessage().SetText("Only the chat admins can manage the checks").Send()
+		}
+
+		if args[0] == "add" {
+			return addCheck(c, cfg, args[1:])
+		}
+		return removeCheck(c, args[1:])
+	}
+
+	return c.NewMessage().SetText(helpText).Send()
+}
+
+// pingURL returns the URL of the check
+func pingURL(c *integram.Context, name string) string {
+	return c.Chat.ServiceHookURL() + "?check=" + url.QueryEscape(name)
+}
+
+func addCheck(c *integram.Context, cfg Config, args []string) error {
+	name, interval, grace, err := parseCheckArgs(args)
+	if err != nil {
+		return c.NewMessage().SetText(err.Error()).Send()
+	}
+
+	n, err := c.ServiceCollection("checks").Find(bson.M{"chatid": c.Chat.ID}).Count()
+	if err != nil {
+		return err
+	}
+	if n >= maxChecksPerChat {
+		return c.NewMessage().SetTextFmt("Chat can have %d checks at most, remove some of them first", maxChecksPerChat).Send()
+	}
+
+	ch := check{ID: bson.NewObjectId(), ChatID: c.Chat.ID, Name: name, Interval: interval, Grace: grace, Deadline: time.Now().Add(interval + grace)}
+	err = c.ServiceCollection("checks").Insert(ch)
+	if mgo.IsDup(err) {
+		return c.NewMessage().SetTextFmt("Check %s already exists. Its URL:\n%s", name, pingURL(c, name)).DisableWebPreview().Send()
+	} else if err != nil {
+		return err
+	}
+
+	err = schedule(c, cfg, &ch, "it was never pinged")
+	if err != nil {
+		return err
+	}
+
+	return c.NewMessage().
+		SetTextFmt("Check %s is created. Request this URL every %s, the chat will be alerted if there is no ping for %s:\n%s", name, formatDuration(interval), formatDuration(interval+grace), pingURL(c, name)).
+		DisableWebPreview().
+		Send()
+}
+
+func removeCheck(c *integram.Context, args []string) error {
+	if len(args) != 1 {
+		return c.NewMessage().SetText("Usage: /heartbeat remove <name>").Send()
+	}
+
+	ch := check{ChatID: c.Chat.ID, Name: args[0]}
+	err := c.ServiceCollection("checks").Remove(bson.M{"chatid": ch.ChatID, "name": ch.Name})
+	if err == mgo.ErrNotFound {
+		return c.NewMessage().SetText("Check not found, see /heartbeat list").Send()
+	} else if err != nil {
+		return err
+	}
+
+	_, err = c.CancelScheduledMessages(ch.eventID())
+	if err != nil {
+		return err
+	}
+
+	return c.NewMessage().SetTextFmt("Check %s is removed", ch.Name).Send()
+}
+
+func listChecks(c *integram.Context) error {
+	var checks []check
+	err := c.ServiceCollection("checks").Find(bson.M{"chatid": c.Chat.ID}).Sort("name").All(&checks)
+	if err != nil {
+		return err
+	}
+	if len(checks) == 0 {
+		return c.NewMessage().SetText("Chat has no checks. Add one with /heartbeat add <name> <interval>").Send()
+	}
+
+	now := time.Now()
+	r := c.Recipient()
+	lines := []string{"Checks of this chat:"}
+	for _, ch := range checks {
+		state := "🟢"
+		if ch.isDown(now) {
+			state = "🔴"
+		}
+
+		lastPing := "never"
+		if ch.LastPing != nil {
+			lastPing = r.In(*ch.LastPing).Format("Jan 2 15:04 MST")
+		}
+
+		lines = append(lines, fmt.Sprintf("%s %s every %s, last ping: %s\n%s", state, ch.Name, formatDuration(ch.Interval), lastPing, pingURL(c, ch.Name)))
+	}
+
+	return c.NewMessage().SetText(strings.Join(lines, "\n")).DisableWebPreview().Send()
+}
@@ -0,0 +1,65 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseCheckArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantName     string
+		wantInterval time.Duration
+		wantGrace    time.Duration
+		wantErr      bool
+	}{
+		{"default grace", []string{"backup", "24h"}, "backup", time.Hour * 24, time.Minute * 144, false},
+		{"min grace", []string{"sync", "5m"}, "sync", time.Minute * 5, time.Minute, false},
+		{"grace", []string{"db.dump", "1h", "30m"}, "db.dump", time.Hour, time.Minute * 30, false},
+		{"no interval", []string{"backup"}, "", 0, 0, true},
+		{"bad name", []string{"my backup!", "1h"}, "", 0, 0, true},
+		{"too short", []string{"backup", "30s"}, "", 0, 0, true},
+		{"bad grace", []string{"backup", "1h", "soon"}, "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, interval, grace, err := parseCheckArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCheckArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || interval != tt.wantInterval || grace != tt.wantGrace {
+				t.Errorf("parseCheckArgs() = %q, %v, %v, want %q, %v, %v", name, interval, grace, tt.wantName, tt.wantInterval, tt.wantGrace)
+			}
+		})
+	}
+}
+
+func Test_formatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Hour * 24, "24h"},
+		{time.Minute * 90, "1h30m"},
+		{time.Minute * 5, "5m"},
+		{time.Second * 90, "1m30s"},
+	}
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func Test_check_isDown(t *testing.T) {
+	deadline := time.Date(2020, 3, 3, 10, 0, 0, 0, time.UTC)
+	ch := check{Deadline: deadline}
+
+	if ch.isDown(deadline.Add(-time.Second)) {
+		t.Errorf("isDown() before the deadline = true, want false")
+	}
+	if !ch.isDown(deadline) {
+		t.Errorf("isDown() at the deadline = false, want true")
+	}
+}
@@ -0,0 +1,379 @@
+// Package rss is the built-in service to subscribe the chats to the RSS and Atom feeds with /rss add <url>.
+// It's the reference implementation of integram.Poller
+package rss
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/requilence/integram"
+)
+
+// DefaultTemplate renders the feed's entry when Config.Template is empty
+const DefaultTemplate = `<b>{{.Feed}}</b>
+<a href="{{.Link}}">{{.Title}}</a>{{if .Summary}}
+{{.Summary}}{{end}}`
+
+// max number of the feeds per chat
+const maxFeedsPerChat = 50
+
+// max size of the feed's document
+const maxFeedSize = 5 << 20
+
+// summary of the entry is truncated to this number of runes
+const maxSummaryLength = 300
+
+var errNotFeed = errors.New("document is not an RSS or Atom feed")
+
+var tagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// Config of the service
+type Config struct {
+	integram.BotConfig
+
+	// html/template of the entry's message, Entry is passed. DefaultTemplate is used if empty
+	Template string
+	// Interval between the polls of the same feed. Default to 10 minutes
+	Interval time.Duration
+}
+
+// Entry is the feed's item passed to the template
+type Entry struct {
+	ID        string
+	Feed      string // title of the feed
+	Title     string
+	Link      string
+	Summary   string // text of the description without the markup
+	Author    string
+	Published time.Time
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"creator"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"date"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Author    string     `xml:"author>name"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+// feedDocument matches RSS 2.0, RSS 1.0 (RDF) and Atom, namespaces are ignored
+type feedDocument struct {
+	XMLName xml.Name
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+
+	Items   []rssItem   `xml:"item"` // RSS 1.0 items are outside of the channel
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+var dateLayouts = []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC822, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2006-01-02T15:04:05"}
+
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// plainText strips the markup and truncates the text to max runes
+func plainText(s string, max int) string {
+	s = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(tagRegexp.ReplaceAllString(s, " "))
+	s = strings.Join(strings.Fields(s), " ")
+
+	if r := []rune(s); len(r) > max {
+		return strings.TrimSpace(string(r[:max])) + "…"
+	}
+	return s
+}
+
+// entryID returns the entry's own ID or the hash of its link and title
+func entryID(id, link, title string) string {
+	if id = strings.TrimSpace(id); id != "" {
+		return id
+	}
+
+	h := sha1.Sum([]byte(strings.TrimSpace(link) + "\n" + strings.TrimSpace(title)))
+	return hex.EncodeToString(h[:])
+}
+
+// parseFeed returns the feed's title and entries in the chronological order
+func parseFeed(data []byte) (title string, entries []Entry, err error) {
+	var doc feedDocument
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	// feeds in the legacy charsets are read as is instead of failing
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	err = d.Decode(&doc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		title = doc.Channel.Title
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			author := item.Author
+			if author == "" {
+				author = item.Creator
+			}
+
+			published := item.PubDate
+			if published == "" {
+				published = item.Date
+			}
+
+			entries = append(entries, Entry{
+				ID:        entryID(item.GUID, item.Link, item.Title),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Summary:   plainText(item.Description, maxSummaryLength),
+				Author:    strings.TrimSpace(author),
+				Published: parseDate(published),
+			})
+		}
+	case "feed":
+		title = doc.Title
+		for _, entry := range doc.Entries {
+			link := ""
+			for _, l := range entry.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+
+			summary := entry.Summary
+			if summary == "" {
+				summary = entry.Content
+			}
+
+			published := entry.Published
+			if published == "" {
+				published = entry.Updated
+			}
+
+			entries = append(entries, Entry{
+				ID:        entryID(entry.ID, link, entry.Title),
+				Title:     strings.TrimSpace(entry.Title),
+				Link:      strings.TrimSpace(link),
+				Summary:   plainText(summary, maxSummaryLength),
+				Author:    strings.TrimSpace(entry.Author),
+				Published: parseDate(published),
+			})
+		}
+	default:
+		return "", nil, errNotFeed
+	}
+
+	title = plainText(title, maxSummaryLength)
+	for i := range entries {
+		entries[i].Feed = title
+	}
+
+	// feeds list the newest entries first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return title, entries, nil
+}
+
+// fetchFeed requests the feed with the target's validators and parses it
+func fetchFeed(target *integram.PollTarget) (title string, entries []Entry, err error) {
+	resp, err := target.Get(target.Key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return parseFeed(data)
+}
+
+// Service returns the service produced from the config
+func (cfg Config) Service() *integram.Service {
+	tmpl := template.Must(template.New("entry").Parse(DefaultTemplate))
+	if cfg.Template != "" {
+		tmpl = template.Must(template.New("entry").Parse(cfg.Template))
+	}
+
+	return &integram.Service{
+		Name:                "rss",
+		NameToPrint:         "RSS",
+		TGNewMessageHandler: newMessageHandler,
+		Commands: []integram.BotCommand{
+			{Command: "rss", Description: "Manage the feeds: add <url>, remove <url>, list"},
+		},
+		Poller: &integram.Poller{
+			Interval: cfg.Interval,
+			Poll: func(c *integram.Context, target *integram.PollTarget) ([]integram.PollEntry, error) {
+				return poll(tmpl, target)
+			},
+		},
+	}
+}
+
+// poll fetches the feed and renders its entries with the template
+func poll(tmpl *template.Template, target *integram.PollTarget) ([]integram.PollEntry, error) {
+	_, entries, err := fetchFeed(target)
+	if err != nil {
+		return nil, err
+	}
+
+	pollEntries := make([]integram.PollEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry := entry
+		pollEntries = append(pollEntries, integram.PollEntry{
+			ID: entry.ID,
+			Render: func(c *integram.Context, r integram.Recipient) (*integram.OutgoingMessage, error) {
+				var text bytes.Buffer
+				err := tmpl.Execute(&text, entry)
+				if err != nil {
+					return nil, err
+				}
+				return c.NewMessage().SetText(text.String()).EnableHTML(), nil
+			},
+		})
+	}
+	return pollEntries, nil
+}
+
+const helpText = "Subscribe this chat to the RSS and Atom feeds:\n/rss add <url> – subscribe to the feed\n/rss remove <url> – unsubscribe from the feed\n/rss list – feeds of this chat"
+
+func newMessageHandler(c *integram.Context) error {
+	command, param := c.Message.GetCommand()
+	if command == "start" || command == "help" {
+		return c.NewMessage().SetText(helpText).Send()
+	} else if command != "rss" {
+		return nil
+	}
+
+	args := strings.Fields(param)
+	if len(args) == 0 {
+		return c.NewMessage().SetText(helpText).Send()
+	}
+
+	switch args[0] {
+	case "list":
+		return listFeeds(c)
+	case "add", "remove":
+		if len(args) < 2 {
+			return c.NewMessage().SetTextFmt("Usage: /rss %s <url>", args[0]).Send()
+		}
+
+		isAdmin, err := c.IsChatAdmin()
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return c.NewMessage().SetText("Only the chat admins can manage the feeds").Send()
+		}
+
+		if args[0] == "add" {
+			return addFeed(c, args[1])
+		}
+		return removeFeed(c, args[1])
+	}
+
+	return c.NewMessage().SetText(helpText).Send()
+}
+
+func addFeed(c *integram.Context, feedURL string) error {
+	u, err := url.Parse(feedURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return c.NewMessage().SetText("Please specify the feed's http(s) URL").Send()
+	}
+
+	feeds, err := c.Subscriptions()
+	if err != nil {
+		return err
+	}
+	if len(feeds) >= maxFeedsPerChat {
+		return c.NewMessage().SetTextFmt("Chat can be subscribed to %d feeds at most, remove some of them first", maxFeedsPerChat).Send()
+	}
+
+	// check the feed before subscribing, its entries are remembered on the first poll
+	title, _, err := fetchFeed(&integram.PollTarget{Key: u.String()})
+	if err != nil {
+		c.Log().WithError(err).WithField("url", u.String()).Info("rss: can't fetch the feed")
+		// the details may reveal the internal network, they are only logged
+		return c.NewMessage().SetText("Can't read the feed. Please check that the URL is public and points to the RSS or Atom feed").Send()
+	}
+
+	subscribed, err := c.Subscribe(u.String())
+	if err != nil {
+		return err
+	}
+	if !subscribed {
+		return c.NewMessage().SetTextFmt("Chat is already subscribed to %s", title).DisableWebPreview().Send()
+	}
+
+	return c.NewMessage().SetTextFmt("Subscribed to %s. New entries will be posted here", title).DisableWebPreview().Send()
+}
+
+func removeFeed(c *integram.Context, feedURL string) error {
+	unsubscribed, err := c.Unsubscribe(feedURL)
+	if err != nil {
+		return err
+	}
+	if !unsubscribed {
+		return c.NewMessage().SetText("Chat isn't subscribed to this feed, see /rss list").DisableWebPreview().Send()
+	}
+
+	return c.NewMessage().SetText("Unsubscribed").Send()
+}
+
+func listFeeds(c *integram.Context) error {
+	feeds, err := c.Subscriptions()
+	if err != nil {
+		return err
+	}
+	if len(feeds) == 0 {
+		return c.NewMessage().SetText("Chat isn't subscribed to any feed. Add one with /rss add <url>").Send()
+	}
+
+	return c.NewMessage().SetText(fmt.Sprintf("Feeds of this chat:\n%s", strings.Join(feeds, "\n"))).DisableWebPreview().Send()
+}
@@ -0,0 +1,88 @@
+package rss
+
+import (
+	"testing"
+	"time"
+)
+
+const rss2Feed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+	<title>Release notes</title>
+	<item>
+		<guid>v1.1</guid>
+		<title>v1.1 &amp; fixes</title>
+		<link>https://example.com/v1.1</link>
+		<description><![CDATA[<p>Fixed <b>the crash</b>&nbsp;on start</p>]]></description>
+		<pubDate>Tue, 03 Mar 2020 10:00:00 +0000</pubDate>
+	</item>
+	<item>
+		<title>v1.0</title>
+		<link>https://example.com/v1.0</link>
+		<dc:creator>alice</dc:creator>
+	</item>
+</channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Blog</title>
+	<entry>
+		<id>tag:example.com,2020:2</id>
+		<title>Second post</title>
+		<link rel="edit" href="https://example.com/edit/2"/>
+		<link href="https://example.com/2"/>
+		<updated>2020-03-03T10:00:00Z</updated>
+		<author><name>bob</name></author>
+		<content type="html">Hello</content>
+	</entry>
+	<entry>
+		<id>tag:example.com,2020:1</id>
+		<title>First post</title>
+		<link rel="alternate" href="https://example.com/1"/>
+		<published>2020-03-01T10:00:00Z</published>
+		<summary>World</summary>
+	</entry>
+</feed>`
+
+func Test_parseFeed(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantTitle string
+		want      []Entry
+		wantErr   bool
+	}{
+		{"rss 2.0", rss2Feed, "Release notes", []Entry{
+			{ID: entryID("", "https://example.com/v1.0", "v1.0"), Feed: "Release notes", Title: "v1.0", Link: "https://example.com/v1.0", Author: "alice"},
+			{ID: "v1.1", Feed: "Release notes", Title: "v1.1 & fixes", Link: "https://example.com/v1.1", Summary: "Fixed the crash on start", Published: time.Date(2020, 3, 3, 10, 0, 0, 0, time.UTC)},
+		}, false},
+		{"atom", atomFeed, "Blog", []Entry{
+			{ID: "tag:example.com,2020:1", Feed: "Blog", Title: "First post", Link: "https://example.com/1", Summary: "World", Published: time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)},
+			{ID: "tag:example.com,2020:2", Feed: "Blog", Title: "Second post", Link: "https://example.com/2", Summary: "Hello", Author: "bob", Published: time.Date(2020, 3, 3, 10, 0, 0, 0, time.UTC)},
+		}, false},
+		{"html page", "<html><body>Not a feed</body></html>", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, entries, err := parseFeed([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFeed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if title != tt.wantTitle || len(entries) != len(tt.want) {
+				t.Fatalf("parseFeed() = %q with %d entries, want %q with %d", title, len(entries), tt.wantTitle, len(tt.want))
+			}
+
+			for i, entry := range entries {
+				if !entry.Published.Equal(tt.want[i].Published) {
+					t.Errorf("parseFeed() entry %d published at %v, want %v", i, entry.Published, tt.want[i].Published)
+				}
+
+				entry.Published, tt.want[i].Published = time.Time{}, time.Time{}
+				if entry != tt.want[i] {
+					t.Errorf("parseFeed() entry %d = %+v, want %+v", i, entry, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package integram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the subscription share link is valid
+const shareLinkTTL = time.Hour * 24
+
+// prefix of the /start param to distinguish the share token
+const shareTokenPrefix = "s"
+
+// length of the truncated HMAC in the token. Token must fit the 64 chars limit of /start param
+const shareTokenSignatureLength = 10
+
+var shareTokenRE = regexp.MustCompile(`(?:startgroup=|^/start(?:@[a-zA-Z0-9_]+)? )` + shareTokenPrefix + `([A-Za-z0-9_-]{30})`)
+
+// signShareToken returns the signed token containing the source chat ID and the expiration time
+func signShareToken(key string, chatID int64, expiresAt time.Time) string {
+	b := make([]byte, 12, 12+shareTokenSignatureLength)
+	binary.BigEndian.PutUint64(b[0:8], uint64(chatID))
+	binary.BigEndian.PutUint32(b[8:12], uint32(expiresAt.Unix()))
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(b)
+	b = append(b, mac.Sum(nil)[0:shareTokenSignatureLength]...)
+
+	return shareTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseShareToken verifies the token's signature and expiration and returns the source chat ID
+func parseShareToken(key string, token string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, shareTokenPrefix))
+	if err != nil || len(b) != 12+shareTokenSignatureLength {
+		return 0, errors.New("Wrong share token format")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(b[0:12])
+	if !hmac.Equal(b[12:], mac.Sum(nil)[0:shareTokenSignatureLength]) {
+		return 0, errors.New("Wrong share token signature")
+	}
+
+	if time.Now().Unix() > int64(binary.BigEndian.Uint32(b[8:12])) {
+		return 0, errors.New("Share token expired")
+	}
+
+	return int64(binary.BigEndian.Uint64(b[0:8])), nil
+}
+
+func (c *Context) shareTokenKey() string {
+	return c.Bot().token + ":" + c.ServiceName
+}
+
+// SubscriptionShareURL returns the deep link to connect another chat to the current chat's subscriptions
+func (c *Context) SubscriptionShareURL() string {
+	token := signShareToken(c.shareTokenKey(), c.Chat.ID, time.Now().Add(shareLinkTTL))
+	return fmt.Sprintf("https://telegram.me/%s?startgroup=%s", c.Bot().Username, token)
+}
+
+// CloneSubscriptionFrom adds the current chat to the service's hooks delivering to the source chat and copies the source chat's settings
+func (c *Context) CloneSubscriptionFrom(sourceChatID int64) error {
+	if sourceChatID == c.Chat.ID {
+		return errors.New("Source and target chats are the same")
+	}
+
+	// users' hooks delivering to the source chat
+	_, err := c.Db().C("users").UpdateAll(
+		bson.M{"hooks": bson.M{"$elemMatch": bson.M{"chats": sourceChatID, "services": c.ServiceName}}},
+		bson.M{"$addToSet": bson.M{"hooks.$.chats": c.Chat.ID}})
+	if err != nil {
+		return err
+	}
+
+	sourceChat, err := c.FindChat(bson.M{"_id": sourceChatID})
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	// the source chat's own hooks. Hook without chats delivers to the owner chat, so keep it in the list
+	for _, hook := range sourceChat.Hooks {
+		if !SliceContainsString(hook.Services, c.ServiceName) {
+			continue
+		}
+
+		chats := []int64{c.Chat.ID}
+		if len(hook.Chats) == 0 {
+			chats = append(chats, sourceChatID)
+		}
+
+		err = c.Db().C("chats").Update(bson.M{"_id": sourceChatID, "hooks.token": hook.Token}, bson.M{"$addToSet": bson.M{"hooks.$.chats": bson.M{"$each": chats}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	serviceID := c.getServiceID()
+	if settings, exists := sourceChat.Settings[serviceID]; exists {
+		var targetSettings map[string]interface{}
+		err = c.Chat.Settings(&targetSettings)
+		if err == nil && len(targetSettings) == 0 {
+			err = c.Chat.SaveSettings(settings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleSubscriptionShare process '/webhook share' and the pasted share links. Returns true if message was handled
+func (c *Context) handleSubscriptionShare() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd == coreCommand("webhook") && strings.TrimSpace(param) == "share" {
+		c.sendSubscriptionShareURL()
+		return true
+	}
+
+	match := shareTokenRE.FindStringSubmatch(c.Message.Text)
+	if len(match) < 2 {
+		return false
+	}
+
+	text := "This chat is now connected to the same notifications"
+	if err := c.connectSharedSubscription(shareTokenPrefix + match[1]); err != nil {
+		c.Log().WithError(err).Info("handleSubscriptionShare: can't connect the chat")
+		text = "Can't connect this chat: " + err.Error()
+	}
+
+	err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleSubscriptionShare: can't send the reply")
+	}
+
+	return true
+}
+
+func (c *Context) sendSubscriptionShareURL() {
+	text := "Only chat admins can share the notifications"
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("sendSubscriptionShareURL: can't check chat admin")
+		text = "Can't check your permissions in this chat. Please try again later"
+	} else if isAdmin {
+		text = fmt.Sprintf("Open this link to add the bot to another chat with the same notifications. Or paste it there. The link is valid for %d hours:\n%s", int(shareLinkTTL.Hours()), c.SubscriptionShareURL())
+	}
+
+	err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).DisableWebPreview().Send()
+	if err != nil {
+		c.Log().WithError(err).Error("sendSubscriptionShareURL: can't send the link")
+	}
+}
+
+// connectSharedSubscription checks the token and the user's permissions in both chats and clones the subscription
+func (c *Context) connectSharedSubscription(token string) error {
+	sourceChatID, err := parseShareToken(c.shareTokenKey(), token)
+	if err != nil {
+		return err
+	}
+
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		return err
+	} else if !isAdmin {
+		return errors.New("only chat admins can connect the chat")
+	}
+
+	member, err := c.GetChatMember(sourceChatID, c.User.ID)
+	if err != nil {
+		return err
+	}
+
+	if member.HasLeft() || member.WasKicked() {
+		return errors.New("you are not a member of the shared chat")
+	}
+
+	return c.CloneSubscriptionFrom(sourceChatID)
+}
@@ -0,0 +1,55 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseShareToken(t *testing.T) {
+	valid := signShareToken("key", -1001234567890, time.Now().Add(time.Hour))
+	tampered := []byte(valid)
+	tampered[3] ^= 1
+
+	tests := []struct {
+		name    string
+		key     string
+		token   string
+		want    int64
+		wantErr bool
+	}{
+		{"valid", "key", valid, -1001234567890, false},
+		{"another key", "key2", valid, 0, true},
+		{"tampered", "key", string(tampered), 0, true},
+		{"expired", "key", signShareToken("key", 1, time.Now().Add(-time.Minute)), 0, true},
+		{"wrong format", "key", "s123", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseShareToken(tt.key, tt.token)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. parseShareToken() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q. parseShareToken() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_shareTokenRE(t *testing.T) {
+	token := signShareToken("key", 1, time.Now().Add(time.Hour))
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"start command", "/start " + token, true},
+		{"start command with bot", "/start@trello_bot " + token, true},
+		{"pasted link", "connect https://telegram.me/trello_bot?startgroup=" + token, true},
+		{"another param", "/start settings", false},
+	}
+	for _, tt := range tests {
+		if got := shareTokenRE.MatchString(tt.text); got != tt.want {
+			t.Errorf("%q. shareTokenRE.MatchString() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package integram
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// userSnapshot is stored in the "users_snapshots" collection
+type userSnapshot struct {
+	UserID    int64     `bson:"userid"`
+	Service   string    `bson:"service"`
+	Key       string    `bson:"key"`
+	Val       bson.Raw  `bson:"val"`
+	Hash      string    `bson:"hash"`
+	ChangedAt time.Time `bson:"changedat"` // last time the value was changed
+	UpdatedAt time.Time `bson:"updatedat"` // last time the value was synced
+}
+
+// snapshotHash returns the hash of value's JSON. Unlike BSON, JSON encoding has the sorted map keys
+func snapshotHash(val interface{}) (string, error) {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Snapshot gets the User's stored snapshot of the upstream object. Returns the time it was changed last time or nil if not exists
+func (user *User) Snapshot(key string, out interface{}) (changedAt *time.Time, err error) {
+	var s userSnapshot
+	err = user.ctx.db.C("users_snapshots").Find(bson.M{"userid": user.ID, "service": user.ctx.getServiceID(), "key": strings.ToLower(key)}).One(&s)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = s.Val.Unmarshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s.ChangedAt, nil
+}
+
+// SyncSnapshot stores the fresh upstream object and decodes the previously stored one into prev (can be nil).
+// Returns true if the object was changed or stored for the first time, so services can compute diffs on webhook receipt instead of re-fetching the upstream
+func (user *User) SyncSnapshot(key string, val interface{}, prev interface{}) (changed bool, err error) {
+	if val == nil {
+		return false, errors.New("SyncSnapshot: val is nil")
+	}
+
+	hash, err := snapshotHash(val)
+	if err != nil {
+		return false, err
+	}
+
+	serviceID := user.ctx.getServiceID()
+	key = strings.ToLower(key)
+	query := bson.M{"userid": user.ID, "service": serviceID, "key": key}
+
+	var s userSnapshot
+	err = user.ctx.db.C("users_snapshots").Find(query).One(&s)
+	if err != nil && err != mgo.ErrNotFound {
+		return false, err
+	}
+
+	exists := err == nil
+	if exists && prev != nil {
+		err = s.Val.Unmarshal(prev)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	now := time.Now()
+	set := bson.M{"val": val, "updatedat": now}
+	if !exists || s.Hash != hash {
+		changed = true
+		set["hash"] = hash
+		set["changedat"] = now
+	}
+
+	_, err = user.ctx.db.C("users_snapshots").Upsert(query, bson.M{"$set": set})
+	return changed, err
+}
+
+// RemoveSnapshot removes the User's stored snapshot
+func (user *User) RemoveSnapshot(key string) error {
+	err := user.ctx.db.C("users_snapshots").Remove(bson.M{"userid": user.ID, "service": user.ctx.getServiceID(), "key": strings.ToLower(key)})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// SnapshotKeysChangedSince returns the keys of User's snapshots changed after the time
+func (user *User) SnapshotKeysChangedSince(since time.Time) ([]string, error) {
+	var snapshots []userSnapshot
+	err := user.ctx.db.C("users_snapshots").Find(bson.M{"userid": user.ID, "service": user.ctx.getServiceID(), "changedat": bson.M{"$gt": since}}).Select(bson.M{"key": 1}).All(&snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		keys = append(keys, s.Key)
+	}
+	return keys, nil
+}
+
+// SnapshotDiff returns the sorted JSON names of the top-level fields that differ between prev and cur objects(structs or maps)
+func SnapshotDiff(prev interface{}, cur interface{}) ([]string, error) {
+	prevMap, err := snapshotToMap(prev)
+	if err != nil {
+		return nil, err
+	}
+
+	curMap, err := snapshotToMap(cur)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for field, val := range curMap {
+		if prevVal, exists := prevMap[field]; !exists || !reflect.DeepEqual(prevVal, val) {
+			fields = append(fields, field)
+		}
+	}
+
+	for field := range prevMap {
+		if _, exists := curMap[field]; !exists {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func snapshotToMap(val interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if val == nil {
+		return m, nil
+	}
+
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
@@ -0,0 +1,54 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_snapshotHash(t *testing.T) {
+	a := map[string]interface{}{"name": "Backlog", "closed": false, "pos": 1}
+	b := map[string]interface{}{"pos": 1, "closed": false, "name": "Backlog"}
+
+	for i := 0; i < 10; i++ {
+		ha, _ := snapshotHash(a)
+		hb, _ := snapshotHash(b)
+		if ha != hb {
+			t.Fatalf("snapshotHash() differs for the same maps: %s != %s", ha, hb)
+		}
+	}
+
+	hc, _ := snapshotHash(map[string]interface{}{"name": "Done"})
+	if ha, _ := snapshotHash(a); ha == hc {
+		t.Errorf("snapshotHash() is the same for different maps")
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	type card struct {
+		Name   string   `json:"name"`
+		Labels []string `json:"labels"`
+		Due    string   `json:"due,omitempty"`
+	}
+
+	tests := []struct {
+		name string
+		prev interface{}
+		cur  interface{}
+		want []string
+	}{
+		{"same", card{Name: "a", Labels: []string{"x"}}, card{Name: "a", Labels: []string{"x"}}, nil},
+		{"changed", card{Name: "a", Labels: []string{"x"}}, card{Name: "b", Labels: []string{"x", "y"}}, []string{"labels", "name"}},
+		{"added and removed", card{Name: "a", Due: "tomorrow"}, map[string]interface{}{"name": "a", "labels": nil, "list": "Done"}, []string{"due", "list"}},
+		{"no prev", nil, card{Name: "a"}, []string{"labels", "name"}},
+	}
+	for _, tt := range tests {
+		got, err := SnapshotDiff(tt.prev, tt.cur)
+		if err != nil {
+			t.Errorf("%q. SnapshotDiff() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. SnapshotDiff() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package integram
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// prefix of the event ID added to the split message's parts when it has no event ID
+const splitEventIDPrefix = "split_"
+
+// boundaries to split the text at in the order of preference
+var splitSeparators = []string{"\n\n", "\n", " "}
+
+// SetAutoSplit splits the text longer than TelegramMessageMaxLength into several messages instead of failing with "message is too long".
+// Text is split at the paragraphs, lines or words, entities and code blocks cut at the boundary are closed and reopened in the next part.
+// Each next part is sent as the reply to the previous one once it's sent. All parts have the same event IDs, the keyboard is kept on the first part
+func (m *OutgoingMessage) SetAutoSplit(b bool) *OutgoingMessage {
+	m.AutoSplit = b
+	return m
+}
+
+// splitText leaves the first part of the long text in the message and keeps the rest in SplitParts
+func (m *OutgoingMessage) splitText() {
+	if !m.AutoSplit || len(m.SplitParts) > 0 || m.FilePath != "" || m.FileID != "" || m.Poll != nil {
+		return
+	}
+
+	parts := splitMessageText(m.Text, m.ParseMode, TelegramMessageMaxLength)
+	if len(parts) < 2 {
+		return
+	}
+
+	m.Text = parts[0]
+	m.SplitParts = parts[1:]
+
+	if len(m.EventID) == 0 {
+		m.AddEventID(splitEventIDPrefix + bson.NewObjectId().Hex())
+	}
+}
+
+// sendNextPart schedules the next part of the split message as the reply to the sent one
+func (m *OutgoingMessage) sendNextPart() {
+	if len(m.SplitParts) == 0 {
+		return
+	}
+
+	next := &OutgoingMessage{
+		Message: Message{
+			ChatID:       m.ChatID,
+			BotID:        m.BotID,
+			FromID:       m.FromID,
+			EventID:      m.EventID,
+			ReplyToMsgID: m.MsgID,
+			Text:         m.SplitParts[0],
+		},
+		ParseMode:  m.ParseMode,
+		WebPreview: m.WebPreview,
+		Silent:     m.Silent,
+		AutoSplit:  true,
+		SplitParts: m.SplitParts[1:],
+	}
+
+	err := next.prepare()
+	if err == nil {
+		_, err = sendMessageJob.Schedule(0, time.Now(), &next)
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("chat", m.ChatID).Error("Can't schedule the next part of the split message")
+	}
+}
+
+// splitMessageText splits the text into the parts not longer than maxLength chars. Entities cut at the boundary are closed and reopened in the next part
+func splitMessageText(text string, parseMode string, maxLength int) []string {
+	var parts []string
+	for {
+		limit := textLengthLimit(text, parseMode, maxLength)
+		if limit == len(text) {
+			break
+		}
+
+		cut, skip := splitBoundary(text, parseMode, limit)
+		part, rest := text[:cut], text[cut+skip:]
+
+		closing, reopening := openEntitiesMarkup(part, parseMode)
+		parts = append(parts, part+closing)
+		text = reopening + rest
+	}
+
+	// only the markup is left after the separator
+	if len(parts) > 0 && strings.TrimSpace(htmlTagRE.ReplaceAllString(text, "")) == "" {
+		return parts
+	}
+	return append(parts, text)
+}
+
+// textLengthLimit returns the byte offset where the text reaches maxLength chars or the text's length if it's shorter.
+// HTML tags are not counted and the entities are counted as the single char. Markdown is counted as is, so the parts may be a bit shorter
+func textLengthLimit(text string, parseMode string, maxLength int) int {
+	n := 0
+	for i := 0; i < len(text); {
+		if parseMode == "HTML" {
+			if text[i] == '<' {
+				if end := strings.IndexByte(text[i:], '>'); end > -1 {
+					i += end + 1
+					continue
+				}
+			}
+
+			if n == maxLength {
+				return i
+			}
+
+			if text[i] == '&' {
+				if end := strings.IndexByte(text[i:], ';'); end > -1 && end < 10 {
+					i += end + 1
+					n++
+					continue
+				}
+			}
+		} else if n == maxLength {
+			return i
+		}
+
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		n++
+	}
+	return len(text)
+}
+
+// splitBoundary returns the byte offset to cut the text at before the limit and the length of the separator to skip
+func splitBoundary(text string, parseMode string, limit int) (int, int) {
+	// the boundary too close to the beginning makes too many small parts
+	min := limit / 4
+
+	window := text[:limit]
+	for _, sep := range splitSeparators {
+		for i := strings.LastIndex(window, sep); i > min; i = strings.LastIndex(window[:i], sep) {
+			if isSafeSplitPoint(text, i, parseMode) {
+				return i, len(sep)
+			}
+		}
+	}
+
+	for i := limit; i > min; i-- {
+		if utf8.RuneStart(text[i]) && isSafeSplitPoint(text, i, parseMode) {
+			return i, 0
+		}
+	}
+	return limit, 0
+}
+
+// isSafeSplitPoint returns false if the offset is inside the HTML tag or entity or right after the escaping backslash
+func isSafeSplitPoint(text string, offset int, parseMode string) bool {
+	before := text[:offset]
+	switch parseMode {
+	case "HTML":
+		if strings.LastIndex(before, "<") > strings.LastIndex(before, ">") {
+			return false
+		}
+		if amp := strings.LastIndex(before, "&"); amp > strings.LastIndex(before, ";") && offset-amp < 10 {
+			return false
+		}
+	case "Markdown", ParseModeMarkdownV2:
+		// even number of the backslashes means they escape each other
+		n := len(before) - len(strings.TrimRight(before, "\\"))
+		if n%2 == 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// openEntitiesMarkup returns the markup to close the entities left open at the end of the part and to reopen them in the next part
+func openEntitiesMarkup(part string, parseMode string) (closing string, reopening string) {
+	switch parseMode {
+	case "HTML":
+		var stack []string
+		for _, match := range htmlTagRE.FindAllStringSubmatch(part, -1) {
+			name := strings.ToLower(match[1])
+			if !strings.HasPrefix(match[0], "</") {
+				stack = append(stack, match[0])
+				continue
+			}
+
+			if len(stack) > 0 && strings.ToLower(htmlTagRE.FindStringSubmatch(stack[len(stack)-1])[1]) == name {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			closing += "</" + htmlTagRE.FindStringSubmatch(stack[i])[1] + ">"
+		}
+		return closing, strings.Join(stack, "")
+	case "Markdown", ParseModeMarkdownV2:
+		stack := openMarkdownMarkers(part, parseMode == ParseModeMarkdownV2)
+		for i := len(stack) - 1; i >= 0; i-- {
+			closing += stack[i]
+		}
+
+		for _, marker := range stack {
+			if marker == "```" {
+				// otherwise the first line is taken as the code block's language
+				marker += "\n"
+			}
+			reopening += marker
+		}
+		return closing, reopening
+	}
+
+	return "", ""
+}
+
+// openMarkdownMarkers returns the markers of the entities left open at the end of the text
+func openMarkdownMarkers(text string, v2 bool) []string {
+	markers := []string{"*", "_"}
+	if v2 {
+		markers = markdownV2Markers
+	}
+
+	var stack []string
+	top := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		return stack[len(stack)-1]
+	}
+
+	for i := 0; i < len(text); {
+		inCode := top() == "`" || top() == "```"
+		if text[i] == '\\' && (v2 || !inCode) {
+			i += 2
+			continue
+		}
+
+		if strings.HasPrefix(text[i:], "```") && (top() == "```" || !inCode) {
+			if top() == "```" {
+				stack = stack[:len(stack)-1]
+			} else {
+				stack = append(stack, "```")
+			}
+			i += 3
+			continue
+		}
+
+		if inCode {
+			if text[i] == '`' && top() == "`" {
+				stack = stack[:len(stack)-1]
+			}
+			i++
+			continue
+		}
+
+		if text[i] == '`' {
+			stack = append(stack, "`")
+			i++
+			continue
+		}
+
+		marker := ""
+		for _, m := range markers {
+			if strings.HasPrefix(text[i:], m) {
+				marker = m
+				break
+			}
+		}
+
+		if marker == "" {
+			i++
+			continue
+		}
+
+		if top() == marker {
+			stack = stack[:len(stack)-1]
+		} else {
+			stack = append(stack, marker)
+		}
+		i += len(marker)
+	}
+
+	return stack
+}
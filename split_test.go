@@ -0,0 +1,64 @@
+package integram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_splitMessageText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		parseMode string
+		maxLength int
+		want      []string
+	}{
+		{"short", "hello world", "", 20, []string{"hello world"}},
+		{"paragraphs", "first paragraph\n\nsecond one", "", 20, []string{"first paragraph", "second one"}},
+		{"lines", "line one\nline two\nline three", "", 20, []string{"line one\nline two", "line three"}},
+		{"words", "lorem ipsum dolor sit amet", "", 12, []string{"lorem ipsum", "dolor sit", "amet"}},
+		{"hard cut", "abcdefghijklmnop", "", 10, []string{"abcdefghij", "klmnop"}},
+		{"multibyte", "ёжикёжикёжик", "", 5, []string{"ёжикё", "жикёж", "ик"}},
+		{"html pre", "<pre>line 1\nline 2\nline 3</pre>", "HTML", 15, []string{"<pre>line 1\nline 2</pre>", "<pre>line 3</pre>"}},
+		{"html link", `<b>see <a href="https://x.io">the docs</a></b>`, "HTML", 8, []string{`<b>see <a href="https://x.io">the</a></b>`, `<b><a href="https://x.io">docs</a></b>`}},
+		{"html tags not counted", `<b>see <a href="https://x.io">the docs</a></b>`, "HTML", 12, []string{`<b>see <a href="https://x.io">the docs</a></b>`}},
+		{"html entity", "a &amp;&amp;&amp; b", "HTML", 4, []string{"a &amp;&amp;", "&amp; b"}},
+		{"html trailing space", "<b>abcde </b>", "HTML", 5, []string{"<b>abcde</b>"}},
+		{"markdown pre", "```\nline 1\nline 2\nline 3```", "Markdown", 20, []string{"```\nline 1\nline 2```", "```\nline 3```"}},
+		{"markdownv2 nested", "*bold _italic words here_*", ParseModeMarkdownV2, 16, []string{"*bold _italic_*", "*_words here_*"}},
+		{"markdownv2 escape", "abcdefgh\\.ijk", ParseModeMarkdownV2, 9, []string{"abcdefgh", "\\.ijk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessageText(tt.text, tt.parseMode, tt.maxLength)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessageText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitMessageText_log(t *testing.T) {
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, strings.Repeat("x", 20))
+	}
+	text := "<pre>" + strings.Join(lines, "\n") + "</pre>"
+
+	parts := splitMessageText(text, "HTML", TelegramMessageMaxLength)
+	if len(parts) != 3 {
+		t.Fatalf("splitMessageText() returned %d parts, want 3", len(parts))
+	}
+
+	for i, part := range parts {
+		plain, _, err := parseMessageEntities(part, "HTML")
+		if err != nil {
+			t.Errorf("part %d can't be parsed: %v", i, err)
+		}
+		if l := utf8.RuneCountInString(plain); l > TelegramMessageMaxLength {
+			t.Errorf("part %d is %d chars", i, l)
+		}
+	}
+}
@@ -25,6 +25,36 @@ const (
 	StatIncomingMessageNotAnswered StatKey = "im_not_replied"
 
 	StatOAuthSuccess StatKey = "oauth_success"
+
+	StatTGWebhookError  StatKey = "tg_wh_error"
+	StatTGWebhookHealed StatKey = "tg_wh_healed"
+
+	StatMessageShed     StatKey = "tg_shed"
+	StatWebhookSpilled  StatKey = "wh_spilled"
+	StatWebhookRejected StatKey = "wh_rejected"
+	StatWebhookQueued   StatKey = "wh_queued"
+	StatWebhookDead     StatKey = "wh_dead"
+
+	StatFileScanned     StatKey = "file_scanned"
+	StatFileScanSkipped StatKey = "file_scan_skipped"
+	StatFileInfected    StatKey = "file_infected"
+
+	StatCallbackRedelivered StatKey = "cb_redelivered"
+
+	StatHandlerTimeout StatKey = "handler_timeout"
+
+	StatNotificationFiltered StatKey = "notification_filtered"
+
+	StatAntiFloodRetry StatKey = "tg_antiflood_retry"
+
+	StatWebhookDuplicate StatKey = "wh_duplicate"
+
+	StatPollAnswer StatKey = "poll_answer"
+
+	StatNotificationChannelDelivered StatKey = "notify_channel_delivered"
+
+	StatWebhookBurstBuffered StatKey = "wh_burst_buffered"
+	StatWebhookBurstHandled  StatKey = "wh_burst_handled"
 )
 
 type stat struct {
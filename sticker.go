@@ -0,0 +1,103 @@
+package integram
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strconv"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// attachmentFileType returns OutgoingMessage.FileType of the attachment's kind. Kinds without the own send method are sent as the document
+func attachmentFileType(kind FileType) string {
+	switch kind {
+	case FileTypePhoto:
+		return "image"
+	case FileTypeSticker, FileTypeAnimation, FileTypeVoice, FileTypeVideoNote:
+		return string(kind)
+	}
+	return "document"
+}
+
+// SetStickerFileID adds the sticker already uploaded to Telegram, e.g. the one from the bot's sticker set. Message's text is not sent with the sticker
+func (m *OutgoingMessage) SetStickerFileID(fileID string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromFileID(FileTypeSticker, fileID, ""))
+}
+
+// SetAnimationFileID adds the GIF or the silent MP4 animation already uploaded to Telegram. Message's text is sent as the caption using the message's parse mode
+func (m *OutgoingMessage) SetAnimationFileID(fileID string) *OutgoingMessage {
+	return m.SetAttachment(AttachmentFromFileID(FileTypeAnimation, fileID, ""))
+}
+
+// SendSticker sends the WEBP or TGS sticker to the current chat. The same file sent again by the bot is not uploaded twice, its Telegram's file_id is reused
+func (c *Context) SendSticker(a Attachment) error {
+	a.Kind = FileTypeSticker
+	return c.NewMessage().SetAttachment(a).Send()
+}
+
+// SendAnimation sends the GIF or the silent MP4 animation to the current chat with the caption using the default parse mode. The same file sent again by the bot is not uploaded twice, its Telegram's file_id is reused
+func (c *Context) SendAnimation(a Attachment, caption string) error {
+	a.Kind = FileTypeAnimation
+	return c.NewMessage().SetText(caption).SetAttachment(a).Send()
+}
+
+// sendAnimation sends the message's animation with the sendAnimation request, which Bot API client doesn't support. Local file is uploaded if fileID is empty
+func (m *OutgoingMessage) sendAnimation(bot *Bot, fileID string) (tg.Message, error) {
+	params := map[string]string{
+		"chat_id":              strconv.FormatInt(m.ChatID, 10),
+		"disable_notification": strconv.FormatBool(m.Silent),
+	}
+
+	if m.Text != "" {
+		params["caption"] = m.Text
+		if m.ParseMode != "" {
+			params["parse_mode"] = m.ParseMode
+		}
+	}
+
+	if m.ReplyToMsgID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(m.ReplyToMsgID)
+	}
+
+	if len(m.InlineKeyboardMarkup.Buttons) > 0 {
+		data, err := json.Marshal(tg.InlineKeyboardMarkup{InlineKeyboard: m.InlineKeyboardMarkup.tg()})
+		if err != nil {
+			return tg.Message{}, err
+		}
+		params["reply_markup"] = string(data)
+	}
+
+	var resp tg.APIResponse
+	var err error
+	if fileID != "" {
+		v := url.Values{}
+		for key, value := range params {
+			v.Set(key, value)
+		}
+		v.Set("animation", fileID)
+
+		resp, err = bot.API.MakeRequest("sendAnimation", v)
+	} else {
+		f, openErr := os.Open(m.FilePath)
+		if openErr != nil {
+			return tg.Message{}, openErr
+		}
+		defer f.Close()
+
+		name := m.FileName
+		if name == "" {
+			name = "animation.gif"
+		}
+
+		resp, err = bot.API.UploadFile("sendAnimation", params, "animation", tg.FileReader{Name: name, Reader: f, Size: -1})
+	}
+
+	if err != nil {
+		return tg.Message{}, err
+	}
+
+	var tgMsg tg.Message
+	err = json.Unmarshal(resp.Result, &tgMsg)
+	return tgMsg, err
+}
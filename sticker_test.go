@@ -0,0 +1,42 @@
+package integram
+
+import "testing"
+
+func Test_attachmentFileType(t *testing.T) {
+	tests := []struct {
+		kind FileType
+		want string
+	}{
+		{FileTypePhoto, "image"},
+		{FileTypeSticker, "sticker"},
+		{FileTypeAnimation, "animation"},
+		{FileTypeVoice, "voice"},
+		{FileTypeVideoNote, "video_note"},
+		{FileTypeDocument, "document"},
+		{FileTypeVideo, "document"},
+	}
+	for _, tt := range tests {
+		if got := attachmentFileType(tt.kind); got != tt.want {
+			t.Errorf("attachmentFileType(%v) = %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestOutgoingMessage_SetStickerFileID(t *testing.T) {
+	m := &OutgoingMessage{}
+	m.SetStickerFileID("CAADAgAD")
+
+	if m.FileID != "CAADAgAD" || m.FileType != "sticker" || m.FilePath != "" {
+		t.Errorf("SetStickerFileID() = %+v", m)
+	}
+}
+
+func TestOutgoingMessage_SetAnimationFileID(t *testing.T) {
+	m := &OutgoingMessage{}
+	m.Text = "Deployed 🎉"
+	m.SetAnimationFileID("CgADBAAD")
+
+	if m.FileID != "CgADBAAD" || m.FileType != "animation" || m.Text != "Deployed 🎉" {
+		t.Errorf("SetAnimationFileID() = %+v", m)
+	}
+}
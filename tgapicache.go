@@ -0,0 +1,148 @@
+package integram
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// expired responses are removed when the cache grows above this size
+const tgAPICacheMaxItems = 10000
+
+type tgAPICacheItem struct {
+	val       interface{}
+	expiresAt time.Time
+}
+
+// tgAPICache stores the responses of the frequently repeated Bot API reads, e.g. getChatMember for the permission checks
+type tgAPICache struct {
+	mu    sync.Mutex
+	items map[string]tgAPICacheItem
+}
+
+var tgAPIResponses = &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+func tgChatMemberCacheKey(botID int64, chatID int64, userID int64) string {
+	return fmt.Sprintf("member:%d:%d:%d", botID, chatID, userID)
+}
+
+func tgChatCacheKey(botID int64, chatID int64) string {
+	return fmt.Sprintf("chat:%d:%d", botID, chatID)
+}
+
+func (cache *tgAPICache) get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item, exists := cache.items[key]
+	if !exists || time.Now().After(item.expiresAt) {
+		return nil, false
+	}
+	return item.val, true
+}
+
+func (cache *tgAPICache) set(key string, val interface{}, ttl time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.items) >= tgAPICacheMaxItems {
+		now := time.Now()
+		for k, item := range cache.items {
+			if now.After(item.expiresAt) {
+				delete(cache.items, k)
+			}
+		}
+
+		if len(cache.items) >= tgAPICacheMaxItems {
+			cache.items = make(map[string]tgAPICacheItem)
+		}
+	}
+
+	cache.items[key] = tgAPICacheItem{val: val, expiresAt: time.Now().Add(ttl)}
+}
+
+func (cache *tgAPICache) delete(keys ...string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for _, key := range keys {
+		delete(cache.items, key)
+	}
+}
+
+// invalidateTGChatMembers removes the cached chat and its members affected by the service message
+func invalidateTGChatMembers(botID int64, chatID int64, userIDs ...int64) {
+	keys := []string{tgChatCacheKey(botID, chatID)}
+	for _, userID := range userIDs {
+		keys = append(keys, tgChatMemberCacheKey(botID, chatID, userID))
+	}
+	tgAPIResponses.delete(keys...)
+}
+
+// invalidateTGAPICache removes the cached responses outdated by the incoming update, e.g. the member left or the chat title changed
+func invalidateTGAPICache(botID int64, m *tg.Message) {
+	if m == nil || m.Chat == nil {
+		return
+	}
+
+	var userIDs []int64
+	if m.LeftChatMember != nil {
+		userIDs = append(userIDs, m.LeftChatMember.ID)
+	}
+
+	if m.NewChatMembers != nil {
+		for _, member := range *m.NewChatMembers {
+			userIDs = append(userIDs, member.ID)
+		}
+	}
+
+	if len(userIDs) > 0 || m.NewChatTitle != "" || m.NewChatPhoto != nil || m.DeleteChatPhoto || m.MigrateToChatID != 0 || m.PinnedMessage != nil {
+		invalidateTGChatMembers(botID, m.Chat.ID, userIDs...)
+	}
+}
+
+// GetChatMember returns the user's membership in the chat. Responses are cached for INTEGRAM_TG_API_CACHE_TTL, so use it for the permission checks
+func (c *Context) GetChatMember(chatID int64, userID int64) (tg.ChatMember, error) {
+	bot := c.Bot()
+	key := tgChatMemberCacheKey(bot.ID, chatID, userID)
+
+	if Config.TGAPICacheTTL > 0 {
+		if val, exists := tgAPIResponses.get(key); exists {
+			return val.(tg.ChatMember), nil
+		}
+	}
+
+	member, err := bot.API.GetChatMember(tg.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return member, err
+	}
+
+	if Config.TGAPICacheTTL > 0 {
+		tgAPIResponses.set(key, member, Config.TGAPICacheTTL)
+	}
+	return member, nil
+}
+
+// GetChat returns the chat's info from Telegram. Responses are cached for INTEGRAM_TG_API_CACHE_TTL
+func (c *Context) GetChat(chatID int64) (tg.Chat, error) {
+	bot := c.Bot()
+	key := tgChatCacheKey(bot.ID, chatID)
+
+	if Config.TGAPICacheTTL > 0 {
+		if val, exists := tgAPIResponses.get(key); exists {
+			return val.(tg.Chat), nil
+		}
+	}
+
+	chat, err := bot.API.GetChat(tg.ChatConfig{ChatID: chatID})
+	if err != nil {
+		return chat, err
+	}
+
+	if Config.TGAPICacheTTL > 0 {
+		tgAPIResponses.set(key, chat, Config.TGAPICacheTTL)
+	}
+	return chat, nil
+}
@@ -0,0 +1,63 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_tgAPICache(t *testing.T) {
+	cache := &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+	cache.set("fresh", 1, time.Minute)
+	cache.set("expired", 2, -time.Second)
+
+	if val, exists := cache.get("fresh"); !exists || val != 1 {
+		t.Errorf("tgAPICache.get(fresh) = %v, %v, want 1, true", val, exists)
+	}
+	if _, exists := cache.get("expired"); exists {
+		t.Error("tgAPICache.get(expired) should not return the expired item")
+	}
+
+	for i := 0; i < tgAPICacheMaxItems; i++ {
+		cache.set(tgChatCacheKey(1, int64(i)), i, time.Minute)
+	}
+	if len(cache.items) > tgAPICacheMaxItems {
+		t.Errorf("tgAPICache size = %d, max %d", len(cache.items), tgAPICacheMaxItems)
+	}
+}
+
+func Test_invalidateTGAPICache(t *testing.T) {
+	chat := &tg.Chat{ID: -100800}
+	member := tgChatMemberCacheKey(1, chat.ID, 10)
+	other := tgChatMemberCacheKey(1, chat.ID, 11)
+
+	tests := []struct {
+		name       string
+		msg        *tg.Message
+		wantMember bool
+		wantChat   bool
+	}{
+		{"regular message", &tg.Message{Chat: chat, Text: "hi"}, true, true},
+		{"member left", &tg.Message{Chat: chat, LeftChatMember: &tg.User{ID: 10}}, false, false},
+		{"title changed", &tg.Message{Chat: chat, NewChatTitle: "Team"}, true, false},
+	}
+	for _, tt := range tests {
+		tgAPIResponses.set(member, tg.ChatMember{Status: "administrator"}, time.Minute)
+		tgAPIResponses.set(other, tg.ChatMember{Status: "member"}, time.Minute)
+		tgAPIResponses.set(tgChatCacheKey(1, chat.ID), tg.Chat{ID: chat.ID}, time.Minute)
+
+		invalidateTGAPICache(1, tt.msg)
+
+		if _, exists := tgAPIResponses.get(member); exists != tt.wantMember {
+			t.Errorf("%q. member cached = %v, want %v", tt.name, exists, tt.wantMember)
+		}
+		if _, exists := tgAPIResponses.get(tgChatCacheKey(1, chat.ID)); exists != tt.wantChat {
+			t.Errorf("%q. chat cached = %v, want %v", tt.name, exists, tt.wantChat)
+		}
+		if _, exists := tgAPIResponses.get(other); !exists {
+			t.Errorf("%q. other member should stay cached", tt.name)
+		}
+	}
+}
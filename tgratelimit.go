@@ -0,0 +1,224 @@
+package integram
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// per chat buckets are removed when there are more of them than this number
+const tgRateLimitMaxChats = 10000
+
+// rateBucket spaces the messages by the interval allowing the burst of messages at once
+type rateBucket struct {
+	interval time.Duration
+	burst    int
+	tat      time.Time // theoretical arrival time of the next message
+}
+
+// reserve returns the time when the message can be sent not earlier than at and takes the slot
+func (b *rateBucket) reserve(at time.Time) time.Time {
+	tat := b.tat
+	if tat.Before(at) {
+		tat = at
+	}
+
+	sendAt := tat.Add(-time.Duration(b.burst-1) * b.interval)
+	if sendAt.Before(at) {
+		sendAt = at
+	}
+
+	b.tat = tat.Add(b.interval)
+	return sendAt
+}
+
+// tgRateLimiter queues the bot's messages to fit Telegram limits: ~30 messages per second in total, 1 per second in the chat and 20 per minute in the group
+type tgRateLimiter struct {
+	mu     sync.Mutex
+	global *rateBucket
+	chats  map[int64]*rateBucket
+}
+
+func newTGRateLimiter() *tgRateLimiter {
+	l := &tgRateLimiter{chats: make(map[int64]*rateBucket)}
+	if Config.TGRateLimit > 0 {
+		l.global = &rateBucket{interval: time.Second / time.Duration(Config.TGRateLimit), burst: Config.TGRateLimit}
+	}
+	return l
+}
+
+// chatBucket returns nil if the chat isn't limited
+func (l *tgRateLimiter) chatBucket(chatID int64, now time.Time) *rateBucket {
+	if b, exists := l.chats[chatID]; exists {
+		return b
+	}
+
+	var b *rateBucket
+	if chatID < 0 && Config.TGRateLimitPerGroup > 0 {
+		b = &rateBucket{interval: time.Minute / time.Duration(Config.TGRateLimitPerGroup), burst: 3}
+	} else if chatID > 0 && Config.TGRateLimitPerChat > 0 {
+		b = &rateBucket{interval: time.Second / time.Duration(Config.TGRateLimitPerChat), burst: 1}
+	} else {
+		return nil
+	}
+
+	if len(l.chats) >= tgRateLimitMaxChats {
+		for id, cb := range l.chats {
+			if cb.tat.Before(now) {
+				delete(l.chats, id)
+			}
+		}
+	}
+
+	l.chats[chatID] = b
+	return b
+}
+
+// reserveChat returns the time when the message can be sent to the chat
+func (l *tgRateLimiter) reserveChat(chatID int64, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b := l.chatBucket(chatID, now); b != nil {
+		return b.reserve(now)
+	}
+	return now
+}
+
+// reserveGlobal returns the time when the next bot's message can be sent
+func (l *tgRateLimiter) reserveGlobal(now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.global != nil {
+		return l.global.reserve(now)
+	}
+	return now
+}
+
+// wait blocks until the message can be sent to the chat. The chat's slot is taken first, so the slow chat doesn't hold the global slots
+func (l *tgRateLimiter) wait(req *http.Request, chatID int64) error {
+	for _, reserve := range []func(time.Time) time.Time{
+		func(now time.Time) time.Time { return l.reserveChat(chatID, now) },
+		l.reserveGlobal,
+	} {
+		delay := reserve(time.Now()).Sub(time.Now())
+		if delay <= 0 {
+			continue
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	}
+	return nil
+}
+
+// tgRateLimitedMethod returns true for the Bot API methods that post the messages to the chat
+func tgRateLimitedMethod(method string) bool {
+	return strings.HasPrefix(method, "send") && method != "sendChatAction" || strings.HasPrefix(method, "edit") || method == "forwardMessage"
+}
+
+// chat_id of the multipart request is looked up only in this prefix of the body, so the uploads aren't buffered in the memory.
+// Bot API client writes the fields before the files
+const tgRequestChatIDMaxPrefix = 1024
+
+// tgRequestChatID reads chat_id from the query, url-encoded or multipart request and restores the body
+func tgRequestChatID(req *http.Request) (int64, error) {
+	chatID := req.URL.Query().Get("chat_id")
+	if chatID == "" && req.Body != nil {
+		mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+		switch mediaType {
+		case "multipart/form-data":
+			prefix, err := ioutil.ReadAll(io.LimitReader(req.Body, tgRequestChatIDMaxPrefix))
+			if err != nil {
+				req.Body.Close()
+				return 0, err
+			}
+			req.Body = prefixedReadCloser{Reader: io.MultiReader(bytes.NewReader(prefix), req.Body), Closer: req.Body}
+			chatID = multipartChatID(prefix, params["boundary"])
+		case "application/x-www-form-urlencoded":
+			body, err := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return 0, err
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			values, _ := url.ParseQuery(string(body))
+			chatID = values.Get("chat_id")
+		}
+	}
+
+	if chatID == "" {
+		// inline message or channel's username
+		return 0, nil
+	}
+
+	id, _ := strconv.ParseInt(chatID, 10, 64)
+	return id, nil
+}
+
+// multipartChatID returns chat_id from the beginning of the multipart body or empty string if it isn't there
+func multipartChatID(prefix []byte, boundary string) string {
+	r := multipart.NewReader(bytes.NewReader(prefix), boundary)
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			return ""
+		}
+
+		if part.FormName() == "chat_id" {
+			b, err := ioutil.ReadAll(io.LimitReader(part, 64))
+			if err != nil {
+				return ""
+			}
+			return string(b)
+		}
+	}
+}
+
+// prefixedReadCloser reads the already consumed prefix and then the rest of the original body
+type prefixedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// tgRateLimitedTransport delays the bot's requests which post the messages to fit Telegram limits, so bursts from the webhooks don't trigger anti-flood
+type tgRateLimitedTransport struct {
+	limiter *tgRateLimiter
+	next    http.RoundTripper
+}
+
+func (t *tgRateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if tgRateLimitedMethod(path.Base(req.URL.Path)) {
+		chatID, err := tgRequestChatID(req)
+		if err != nil {
+			return nil, err
+		}
+
+		err = t.limiter.wait(req, chatID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// newTGHTTPClient returns the client for the bot's API requests
+func newTGHTTPClient() *http.Client {
+	return &http.Client{Transport: &tgRateLimitedTransport{limiter: newTGRateLimiter(), next: http.DefaultTransport}}
+}
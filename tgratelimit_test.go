@@ -0,0 +1,108 @@
+package integram
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rateBucket_reserve(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		burst int
+		at    []time.Duration // offsets of the messages from now
+		want  []time.Duration
+	}{
+		{"1 per second", 1, []time.Duration{0, 0, 0}, []time.Duration{0, time.Second, time.Second * 2}},
+		{"burst", 3, []time.Duration{0, 0, 0, 0}, []time.Duration{0, 0, 0, time.Second}},
+		{"refilled after idle", 1, []time.Duration{0, time.Second * 5, time.Second * 5}, []time.Duration{0, time.Second * 5, time.Second * 6}},
+	}
+	for _, tt := range tests {
+		b := &rateBucket{interval: time.Second, burst: tt.burst}
+		for i, at := range tt.at {
+			if got := b.reserve(now.Add(at)).Sub(now); got != tt.want[i] {
+				t.Errorf("%q. rateBucket.reserve() #%d = +%s, want +%s", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func Test_tgRequestChatID(t *testing.T) {
+	form, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/sendMessage", strings.NewReader("chat_id=-100500&text=hi"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("chat_id", "42")
+	part, _ := w.CreateFormFile("photo", "a.png")
+	part.Write([]byte("png"))
+	w.Close()
+	upload, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/sendPhoto", body)
+	upload.Header.Set("Content-Type", w.FormDataContentType())
+
+	largeBody := &bytes.Buffer{}
+	w = multipart.NewWriter(largeBody)
+	w.WriteField("chat_id", "43")
+	part, _ = w.CreateFormFile("document", "a.bin")
+	part.Write(bytes.Repeat([]byte("a"), tgRequestChatIDMaxPrefix*10))
+	w.Close()
+	largeBodyLen := largeBody.Len()
+	large, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/sendDocument", largeBody)
+	large.Header.Set("Content-Type", w.FormDataContentType())
+
+	lateBody := &bytes.Buffer{}
+	w = multipart.NewWriter(lateBody)
+	part, _ = w.CreateFormFile("document", "a.bin")
+	part.Write(bytes.Repeat([]byte("a"), tgRequestChatIDMaxPrefix*2))
+	w.WriteField("chat_id", "44")
+	w.Close()
+	late, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/sendDocument", lateBody)
+	late.Header.Set("Content-Type", w.FormDataContentType())
+
+	query, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/sendMessage?chat_id=45&text=hi", nil)
+
+	inline, _ := http.NewRequest("POST", "https://api.telegram.org/bot1:x/editMessageText", strings.NewReader("inline_message_id=abc&text=hi"))
+	inline.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int64
+	}{
+		{"form", form, -100500},
+		{"multipart", upload, 42},
+		{"large multipart", large, 43},
+		{"chat_id after the file", late, 0},
+		{"query", query, 45},
+		{"inline message", inline, 0},
+	}
+	for _, tt := range tests {
+		got, err := tgRequestChatID(tt.req)
+		if err != nil || got != tt.want {
+			t.Errorf("%q. tgRequestChatID() = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+
+		if err := tt.req.ParseForm(); tt.req == form && (err != nil || tt.req.PostForm.Get("text") != "hi") {
+			t.Errorf("%q. tgRequestChatID() doesn't restore the body", tt.name)
+		}
+	}
+
+	if largeBody.Len() < largeBodyLen-tgRequestChatIDMaxPrefix {
+		t.Errorf("tgRequestChatID() read %d bytes of the multipart body, want at most %d", largeBodyLen-largeBody.Len(), tgRequestChatIDMaxPrefix)
+	}
+	if data, _ := ioutil.ReadAll(large.Body); len(data) != largeBodyLen {
+		t.Errorf("tgRequestChatID() restored %d bytes of the multipart body, want %d", len(data), largeBodyLen)
+	}
+
+	for method, want := range map[string]bool{"sendMessage": true, "editMessageReplyMarkup": true, "sendChatAction": false, "getChatMember": false} {
+		if got := tgRateLimitedMethod(method); got != want {
+			t.Errorf("tgRateLimitedMethod(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package integram
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -40,6 +42,14 @@ func updateRoutine(b *Bot, u *tg.Update) {
 	}
 	updateReceivedAt := time.Now()
 
+	if handleUpdateInMaintenance(b, u) {
+		return
+	}
+
+	if handleUpdateInDegradedMode(b, u) {
+		return
+	}
+
 	var chatID int64
 	if u.Message != nil {
 		chatID = u.Message.Chat.ID
@@ -88,6 +98,18 @@ func updateRoutine(b *Bot, u *tg.Update) {
 		db.Session.Close()
 	}()
 
+	if Config.Profiling {
+		serviceName := "unknown"
+		if s, err := detectServiceByBot(b.ID); err == nil {
+			serviceName = s.Name
+		}
+		defer startProfiling(serviceName, tgUpdateType(u))()
+	}
+
+	if chatID < 0 && (u.Message != nil && u.Message.From != nil && !u.Message.From.IsBot || u.CallbackQuery != nil) {
+		trackHumanActivity(db, chatID)
+	}
+
 	service, context := tgUpdateHandler(u, b, db)
 
 	if service == nil || context == nil {
@@ -95,6 +117,13 @@ func updateRoutine(b *Bot, u *tg.Update) {
 	}
 
 	if context.Message != nil && !context.MessageEdited {
+		if context.Message.IsEventBotAddedToGroup() {
+			context.sendBrandingGreeting()
+		}
+
+		if context.handleAdminCommand() || context.handleWebhookStatsCommand() || context.handleWebhookEncryptCommand() || context.handleSubscriptionShare() || context.handleRevokeSharedOAuthCommand() || context.handleAPIKeyCommand() || context.handleWorkingHoursCommand() || context.handleTranslateCommand() || context.handleDensityCommand() || context.handleLanguageCommand() || context.handleNotificationFilterCommand() || context.handleDiagnoseCommand() || context.handleNotifyChannelCommand() || context.handleViewerActionsStart() || context.handleQuickCreateAuthStart() || context.handleDeprecatedServiceMessage() || context.handleWizardMessage() {
+			return
+		}
 
 		replyActionProcessed := false
 		if context.Message.ReplyToMessage != nil {
@@ -124,12 +153,20 @@ func updateRoutine(b *Bot, u *tg.Update) {
 
 					if len(handlerArgs) > 0 {
 						handlerVal := reflect.ValueOf(handler)
-						returnVals := handlerVal.Call(handlerArgs)
+						err := context.runHandler("reply", service.withMiddlewares(func(hc *Context) error {
+							handlerArgs[0] = reflect.ValueOf(hc)
+							returnVals := handlerVal.Call(handlerArgs)
 
-						if !returnVals[0].IsNil() {
-							err := returnVals[0].Interface().(error)
+							if !returnVals[0].IsNil() {
+								return returnVals[0].Interface().(error)
+							}
+							return nil
+						}), nil)
+
+						if err != nil && err != ErrHandlerTimeout {
 							// NOTE: panics will be caught by the recover statement above
 							log.WithField("handler", rm.OnReplyAction).WithError(err).Error("replyHandler failed")
+							context.renderServiceError(err)
 						}
 
 						replyActionProcessed = true
@@ -148,9 +185,14 @@ func updateRoutine(b *Bot, u *tg.Update) {
 				return
 			}
 
-			err := service.TGNewMessageHandler(context)
-			if err != nil {
+			err := context.runHandler("message", service.withMiddlewares(service.TGNewMessageHandler), nil)
+			if err != nil && err != ErrHandlerTimeout {
 				context.Log().WithError(err).Error("BotUpdateHandler error")
+				context.renderServiceError(err)
+			}
+
+			if service.ReplyKeyboardMigration != nil {
+				context.migrateReplyKeyboardOnAnswer(service)
 			}
 		}
 
@@ -162,21 +204,38 @@ func updateRoutine(b *Bot, u *tg.Update) {
 			}
 		}
 
+		// text is stored separately to provide the previous text to TGEditMessageHandler
+		if service.TGEditMessageHandler != nil {
+			err := saveIncomingText(db, context.Message)
+			if err != nil {
+				log.WithError(err).Error("can't save incoming message text")
+			}
+		}
+
 		if context.messageAnsweredAt != nil {
 			context.StatIncChat(StatIncomingMessageAnswered)
 		} else {
 			context.StatIncChat(StatIncomingMessageNotAnswered)
 		}
+	} else if context.Message != nil && context.MessageEdited {
+		context.handleEditedMessage(service)
 	} else if context.InlineQuery != nil {
+		if handled, err := context.handleQuickCreateQuery(); handled {
+			if err != nil {
+				context.Log().WithError(err).Error("Can't answer the quick-create inline query")
+			}
+			return
+		}
+
 		if service.TGInlineQueryHandler == nil {
 			context.Log().Warn("Received InlineQuery but TGInlineQueryHandler not set for service")
 			return
 		}
 
 		queryHandlerStarted := time.Now()
-		err := service.TGInlineQueryHandler(context)
+		err := context.runHandler("inline query", service.TGInlineQueryHandler, nil)
 		if err != nil {
-			if strings.Contains(err.Error(), "QUERY_ID_INVALID") {
+			if err == ErrHandlerTimeout || strings.Contains(err.Error(), "QUERY_ID_INVALID") {
 				context.StatIncUser(StatInlineQueryTimeouted)
 			} else if !strings.Contains(err.Error(), "context canceled") {
 				context.StatIncUser(StatInlineQueryCanceled)
@@ -199,16 +258,20 @@ func updateRoutine(b *Bot, u *tg.Update) {
 		}
 		return
 	} else if context.ChosenInlineResult != nil {
+		if context.handleQuickCreateResult() {
+			context.StatIncUser(StatInlineQueryChosen)
+			return
+		}
 
 		if service.TGChosenInlineResultHandler == nil {
 			context.Log().Warn("Received ChosenInlineResult but TGChosenInlineResultHandler not set for service")
 			return
 		}
 
-		err := service.TGChosenInlineResultHandler(context)
+		err := context.runHandler("chosen inline result", service.TGChosenInlineResultHandler, nil)
 		context.StatIncUser(StatInlineQueryChosen)
 
-		if err != nil {
+		if err != nil && err != ErrHandlerTimeout {
 			context.Log().WithError(err).Error("BotUpdateHandler error")
 		}
 		return
@@ -242,13 +305,8 @@ func updateRoutine(b *Bot, u *tg.Update) {
 }
 
 func (bot *Bot) listen() {
-	api := bot.API
 	if bot.updatesChan == nil {
-		var err error
-		bot.updatesChan, err = api.GetUpdatesChan(tg.UpdateConfig{Timeout: randomInRange(10, 20), Limit: 100})
-		if err != nil {
-			log.WithField("bot", bot.ID).WithError(err).Panic("Can't get updatesChan")
-		}
+		bot.updatesChan = bot.getUpdatesChan(tg.UpdateConfig{Timeout: randomInRange(10, 20), Limit: 100})
 	}
 	go func(c <-chan tg.Update, b *Bot) {
 		var context Context
@@ -271,6 +329,63 @@ func (bot *Bot) listen() {
 	}(bot.updatesChan, bot)
 }
 
+// getUpdatesChan long-polls the bot's updates like tg.BotAPI.GetUpdatesChan. Quotes and poll answers, which Bot API client doesn't decode, are dispatched from the raw updates
+func (bot *Bot) getUpdatesChan(config tg.UpdateConfig) <-chan tg.Update {
+	ch := make(chan tg.Update, 100)
+
+	go func() {
+		for {
+			updates, err := bot.getUpdates(config)
+			if err != nil {
+				log.WithField("bot", bot.ID).WithError(err).Error("Can't get updates, retrying in 3 seconds")
+				time.Sleep(time.Second * 3)
+				continue
+			}
+
+			for _, u := range updates {
+				if u.UpdateID >= config.Offset {
+					config.Offset = u.UpdateID + 1
+					ch <- u
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// getUpdates requests the updates and dispatches their quotes and poll answers
+func (bot *Bot) getUpdates(config tg.UpdateConfig) ([]tg.Update, error) {
+	v := url.Values{}
+	if config.Offset != 0 {
+		v.Set("offset", strconv.Itoa(config.Offset))
+	}
+	if config.Limit > 0 {
+		v.Set("limit", strconv.Itoa(config.Limit))
+	}
+	if config.Timeout > 0 {
+		v.Set("timeout", strconv.Itoa(config.Timeout))
+	}
+
+	resp, err := bot.API.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []tg.Update
+	err = json.Unmarshal(resp.Result, &updates)
+	if err != nil {
+		return nil, err
+	}
+
+	extractIncomingQuotes(resp.Result)
+	for _, answer := range extractPollAnswers(resp.Result) {
+		go pollAnswerRoutine(bot.ID, answer)
+	}
+
+	return updates, nil
+}
+
 func tgUserPointer(u *tg.User) *User {
 	if u == nil {
 		return nil
@@ -332,6 +447,8 @@ func incomingMessageFromTGMessage(m *tg.Message) IncomingMessage {
 		if rm.From != nil {
 			im.ReplyToMessage.FromID = rm.From.ID
 		}
+
+		im.Quote = incomingQuote(m.Chat.ID, m.MessageID)
 	}
 
 	im.Caption = m.Caption
@@ -350,6 +467,7 @@ func incomingMessageFromTGMessage(m *tg.Message) IncomingMessage {
 	im.Sticker = m.Sticker
 	im.Video = m.Video
 	im.Voice = m.Voice
+	im.VideoNote = m.VideoNote
 	im.Contact = m.Contact
 	im.Location = m.Location
 	im.NewChatTitle = m.NewChatTitle
@@ -414,10 +532,84 @@ func tgCallbackHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *Conte
 		ctx.User.ctx = ctx
 		ctx.Chat.ctx = ctx
 
+		if cbData == translationShowOriginalData || cbData == translationShowTranslatedData {
+			err := ctx.toggleTranslation(cbData == translationShowOriginalData)
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't toggle the message translation")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
+		if cbData == densityExpandData || cbData == densityCollapseData {
+			err := ctx.toggleDensity(cbData == densityExpandData)
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't toggle the message density")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
+		if cbData == quickCreatePendingData {
+			err := ctx.answerQuickCreatePendingCallback()
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't answer the quick-create callback")
+			}
+			return nil, ctx
+		}
+
+		if ctx.answerDeprecatedServiceCallback() {
+			return nil, ctx
+		}
+
+		if cbData == undoButtonData {
+			err := ctx.handleUndoCallback()
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't undo the action")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
+		if isLanguageCallback(cbData) {
+			err := ctx.handleLanguageCallback()
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't change the user's language")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
+		if isNotificationFilterCallback(cbData) {
+			err := ctx.handleNotificationFilterCallback()
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't change the notification filters")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
+		if isReplyKeyboardCallback(cbData) {
+			err := ctx.handleReplyKeyboardCallback(service)
+			if err != nil {
+				ctx.Log().WithError(err).Error("Can't handle the migrated reply keyboard's button")
+				ctx.AnswerCallbackQuery("Oops! Please try again", false)
+			}
+			return nil, ctx
+		}
+
 		if rm.OnCallbackAction != "" {
 			log.Debugf("CallbackAction found %s", rm.OnCallbackAction)
 			// Instantiate a new variable to hold this argument
 			if handler, ok := actionFuncs[service.trimFuncPath(rm.OnCallbackAction)]; ok {
+				prev, err := ctx.beginCallbackExecution(rm.OnCallbackAction)
+				if err != nil {
+					ctx.Log().WithError(err).Error("can't record callback execution")
+				} else if prev != nil {
+					ctx.answerRedeliveredCallback(prev)
+					return nil, ctx
+				}
+
 				handlerType := reflect.TypeOf(handler)
 				log.Debugf("handler %v: %v %v\n", rm.OnCallbackAction, handlerType.String(), handlerType.Kind().String())
 				handlerArgsInterfaces := make([]interface{}, handlerType.NumIn()-1)
@@ -430,25 +622,65 @@ func tgCallbackHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *Conte
 				if err := decode(rm.OnCallbackData, &handlerArgsInterfaces); err != nil {
 					ctx.Log().WithField("handler", rm.OnCallbackAction).WithError(err).Error("Can't decode replyHandler's args")
 				}
-				handlerArgs[0] = reflect.ValueOf(ctx)
 				for i := 0; i < len(handlerArgsInterfaces); i++ {
 					handlerArgs[i+1] = reflect.ValueOf(handlerArgsInterfaces[i])
 				}
 
 				if len(handlerArgs) > 0 {
 					handlerVal := reflect.ValueOf(handler)
-					returnVals := handlerVal.Call(handlerArgs)
+					handlerStarted := time.Now()
+					handlerErr := ctx.runHandler("callback", service.withMiddlewares(func(hc *Context) error {
+						handlerArgs[0] = reflect.ValueOf(hc)
+						returnVals := handlerVal.Call(handlerArgs)
 
-					if !returnVals[0].IsNil() {
-						err := returnVals[0].Interface().(error)
+						if !returnVals[0].IsNil() {
+							return returnVals[0].Interface().(error)
+						}
+						return nil
+					}), func(hc *Context, err error) {
+						// redeliveries are answered with the handler's own answer or the empty one
+						if hc.Callback.answerText == callbackInProgressText {
+							hc.Callback.answerText = ""
+						}
+
+						err = hc.finishCallbackExecution(rm.OnCallbackAction, err)
+						if err != nil {
+							hc.Log().WithError(err).Error("can't save callback execution")
+						}
+					})
+
+					err := ctx.callbackStatRecord(rm.OnCallbackAction, cbData, time.Since(handlerStarted), handlerErr)
+					if err != nil {
+						ctx.Log().WithError(err).Error("can't save callback stat")
+					}
+					ctx.publishChatEvent(ChatEventButtonPressed, rm.om, cbData)
+
+					if handlerErr == ErrHandlerTimeout {
+						// execution is finished by the handler in the background
+						ctx.AnswerCallbackQuery(callbackInProgressText, false)
+						return nil, ctx
+					} else if handlerErr != nil {
+						err := handlerErr
 						// NOTE: panics will be caught by the recover statement above
 						ctx.Log().WithField("handler", rm.OnCallbackAction).WithError(err).Error("callbackAction failed")
-						ctx.AnswerCallbackQuery("Oops! Please try again", false)
+						if se, ok := AsServiceError(err); ok {
+							ctx.AnswerCallbackQuery(se.Text, se.Severity == ServiceErrorSeverityError)
+							if len(se.Actions) > 0 {
+								ctx.renderServiceError(se)
+							}
+						} else {
+							ctx.AnswerCallbackQuery("Oops! Please try again", false)
+						}
 					} else {
 						if ctx.Callback.AnsweredAt == nil {
 							ctx.AnswerCallbackQuery("", false)
 						}
 					}
+
+					err = ctx.finishCallbackExecution(rm.OnCallbackAction, handlerErr)
+					if err != nil {
+						ctx.Log().WithError(err).Error("can't save callback execution")
+					}
 				}
 			} else {
 				ctx.Log().WithField("handler", rm.OnCallbackAction).Error("Callback handler not registered")
@@ -467,7 +699,8 @@ func tgInlineQueryHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *Co
 		log.WithError(err).WithField("bot", b.ID).Error("Can't detect service")
 	}
 	user := tgUser(u.InlineQuery.From)
-	ctx := &Context{ServiceName: service.Name, User: user, db: db, InlineQuery: u.InlineQuery}
+	// inline queries are frequent and usually answered from the cache, so the user is stored on the next update
+	ctx := &Context{ServiceName: service.Name, User: user, db: db, InlineQuery: u.InlineQuery, readOnly: true}
 	ctx.User.ctx = ctx
 
 	return service, ctx
@@ -547,6 +780,8 @@ func tgEditedMessageHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *
 
 		if rm.OnEditAction != "" {
 			log.Debugf("onEditHandler found %s", rm.OnEditAction)
+			// TGEditMessageHandler is not called for the messages with own edit action
+			im.OnEditAction = rm.OnEditAction
 			// Instantiate a new variable to hold this argument
 			if handler, ok := actionFuncs[service.trimFuncPath(rm.OnEditAction)]; ok {
 				handlerType := reflect.TypeOf(handler)
@@ -655,6 +890,11 @@ func tgIncomingMessageHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service,
 	if ctx != nil && ctx.Message != nil {
 		key := "protected." + ctx.ServiceName + ".botstoppedorkickedat"
 		db.C("chats").Update(bson.M{"_id": ctx.Chat.ID, key: bson.M{"$exists": true}}, bson.M{"$unset": bson.M{key: ""}})
+
+		// critical alerts are delivered to Telegram again
+		if ctx.Chat.IsPrivate() {
+			db.C("users").Update(bson.M{"_id": ctx.User.ID, "tgfailures": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"tgfailures": ""}})
+		}
 	}
 
 	return service, ctx
@@ -707,6 +947,8 @@ func migrateToSuperGroup(db *mgo.Database, fromChatID int64, toChatID int64) {
 func tgUpdateHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *Context) {
 
 	if u.Message != nil && u.ChosenInlineResult == nil {
+		invalidateTGAPICache(b.ID, u.Message)
+
 		if u.Message.LeftChatMember != nil {
 			db.C("chats").UpdateId(u.Message.Chat.ID, bson.M{"$pull": bson.M{"membersids": u.Message.From.ID}})
 			return nil, nil
@@ -716,6 +958,22 @@ func tgUpdateHandler(u *tg.Update, b *Bot, db *mgo.Database) (*Service, *Context
 			return nil, nil
 		}
 
+		if u.Message.NewChatMembers != nil {
+			completeChatHandoff(db, b, u.Message.Chat.ID, *u.Message.NewChatMembers)
+		}
+
+		if u.Message.PinnedMessage != nil {
+			serviceName := ""
+			if s, err := detectServiceByBot(b.ID); err == nil {
+				serviceName = s.Name
+			}
+
+			err := syncChatPin(db, b.ID, serviceName, u.Message.Chat.ID, u.Message.PinnedMessage.MessageID)
+			if err != nil {
+				log.WithError(err).WithField("chat", u.Message.Chat.ID).Error("Can't update the chat's pinned message")
+			}
+		}
+
 		return tgIncomingMessageHandler(u, b, db)
 	} else if u.CallbackQuery != nil {
 		if u.CallbackQuery.Message != nil && (u.CallbackQuery.Message.Chat.IsGroup() || u.CallbackQuery.Message.Chat.IsSuperGroup()) {
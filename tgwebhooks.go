@@ -0,0 +1,217 @@
+package integram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// Telegram's last error is considered actual for this period
+const tgWebhookErrorActualPeriod = time.Hour
+
+// don't re-issue setWebhook for the same bot more often than this
+const tgWebhookHealInterval = time.Hour
+
+// warn about the pending updates above this number
+const tgWebhookPendingUpdatesWarning = 100
+
+// Issues detected in the Telegram webhook info
+const (
+	tgWebhookIssueNotSet      = "not_set"
+	tgWebhookIssueWrongURL    = "wrong_url"
+	tgWebhookIssueCertificate = "certificate"
+	tgWebhookIssueDelivery    = "delivery"
+)
+
+// tgWebhookState is the last getWebhookInfo result stored in the "bots_webhooks" collection
+type tgWebhookState struct {
+	BotID            int64      `bson:"_id"`
+	Service          string     `bson:"s"`
+	URL              string     `bson:"url"`
+	PendingUpdates   int        `bson:"pending"`
+	LastErrorAt      *time.Time `bson:"errat,omitempty"`
+	LastErrorMessage string     `bson:"err,omitempty"`
+	Issue            string     `bson:"issue,omitempty"`
+	CheckedAt        time.Time  `bson:"checkedat"`
+	HealedAt         *time.Time `bson:"healedat,omitempty"`
+	Heals            int        `bson:"heals"`
+}
+
+func init() {
+	registerAdminCommand("tgwebhooks", adminTGWebhooksReport)
+}
+
+// tgWebhookIssue returns the issue of the bot's webhook or empty string if it's fine
+func tgWebhookIssue(info tg.WebhookInfo, expectedURL string, now time.Time) string {
+	if !info.IsSet() {
+		return tgWebhookIssueNotSet
+	}
+
+	if info.URL != expectedURL {
+		return tgWebhookIssueWrongURL
+	}
+
+	if info.LastErrorDate == 0 || time.Unix(int64(info.LastErrorDate), 0).Before(now.Add(-tgWebhookErrorActualPeriod)) {
+		return ""
+	}
+
+	msg := strings.ToLower(info.LastErrorMessage)
+	if strings.Contains(msg, "certificate") || strings.Contains(msg, "ssl") {
+		return tgWebhookIssueCertificate
+	}
+
+	return tgWebhookIssueDelivery
+}
+
+// tgWebhookIssueHealable returns true if the issue can be fixed by setting the webhook again
+func tgWebhookIssueHealable(issue string) bool {
+	return issue == tgWebhookIssueNotSet || issue == tgWebhookIssueWrongURL || issue == tgWebhookIssueCertificate
+}
+
+// tgWebhooksChecker periodically checks the Telegram webhooks of the bots running on this instance
+func tgWebhooksChecker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("tgWebhooksChecker panic recovered %v", r)
+			tgWebhooksChecker()
+		}
+	}()
+
+	if Config.IsMainInstance() || Config.TGWebhookCheckInterval <= 0 {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		time.Sleep(Config.TGWebhookCheckInterval)
+
+		var webhookServices []*Service
+		serviceMapMutex.RLock()
+		for _, service := range services {
+			if service.UseWebhookInsteadOfLongPolling {
+				webhookServices = append(webhookServices, service)
+			}
+		}
+		serviceMapMutex.RUnlock()
+
+		for _, service := range webhookServices {
+			bot := service.Bot()
+			if bot == nil {
+				continue
+			}
+
+			checkTGWebhook(db, service, bot)
+		}
+	}
+}
+
+// checkTGWebhook saves the bot's webhook info and sets the webhook again if the issue can be fixed this way
+func checkTGWebhook(db *mgo.Database, service *Service, bot *Bot) {
+	info, err := bot.API.GetWebhookInfo()
+	if err != nil {
+		log.WithError(err).WithField("botID", bot.ID).Error("checkTGWebhook: GetWebhookInfo error")
+		return
+	}
+
+	now := time.Now()
+	state := tgWebhookState{
+		BotID:            bot.ID,
+		Service:          service.Name,
+		URL:              info.URL,
+		PendingUpdates:   info.PendingUpdateCount,
+		LastErrorMessage: info.LastErrorMessage,
+		Issue:            tgWebhookIssue(info, bot.webhookURL().String(), now),
+		CheckedAt:        now,
+	}
+
+	if info.LastErrorDate > 0 {
+		t := time.Unix(int64(info.LastErrorDate), 0)
+		state.LastErrorAt = &t
+	}
+
+	ctx := &Context{db: db, ServiceName: service.Name}
+	if state.Issue != "" {
+		ctx.StatInc(StatTGWebhookError)
+	}
+
+	if state.PendingUpdates > tgWebhookPendingUpdatesWarning {
+		log.WithField("botID", bot.ID).Warnf("Telegram webhook has %d pending updates, last error: %s", state.PendingUpdates, state.LastErrorMessage)
+	}
+
+	var prev tgWebhookState
+	db.C("bots_webhooks").FindId(bot.ID).One(&prev)
+	state.HealedAt = prev.HealedAt
+	state.Heals = prev.Heals
+
+	if tgWebhookIssueHealable(state.Issue) && (prev.HealedAt == nil || now.Sub(*prev.HealedAt) > tgWebhookHealInterval) {
+		log.WithField("botID", bot.ID).Warnf("Telegram webhook issue '%s', setting the webhook again. Last error: %s", state.Issue, state.LastErrorMessage)
+
+		_, err = bot.API.SetWebhook(tg.WebhookConfig{URL: bot.webhookURL()})
+		if err != nil {
+			log.WithError(err).WithField("botID", bot.ID).Error("checkTGWebhook: SetWebhook error")
+		} else {
+			state.HealedAt = &now
+			state.Heals++
+			ctx.StatInc(StatTGWebhookHealed)
+		}
+	}
+
+	_, err = db.C("bots_webhooks").UpsertId(bot.ID, state)
+	if err != nil {
+		log.WithError(err).WithField("botID", bot.ID).Error("checkTGWebhook: can't save the webhook info")
+	}
+}
+
+func (s tgWebhookState) String() string {
+	line := fmt.Sprintf("%s (%d): %d pending", s.Service, s.BotID, s.PendingUpdates)
+	if s.Issue != "" {
+		line += ", " + s.Issue
+	}
+	if s.LastErrorAt != nil {
+		line += fmt.Sprintf(", last error at %s: %s", s.LastErrorAt.UTC().Format("2006-01-02 15:04"), s.LastErrorMessage)
+	}
+	if s.HealedAt != nil {
+		line += fmt.Sprintf(", set again %d times, last at %s", s.Heals, s.HealedAt.UTC().Format("2006-01-02 15:04"))
+	}
+	return line
+}
+
+// adminTGWebhooksReport: /integram tgwebhooks
+func adminTGWebhooksReport(c *Context, args []string) (string, error) {
+	var states []tgWebhookState
+	err := c.db.C("bots_webhooks").Find(nil).All(&states)
+	if err != nil {
+		return "", err
+	}
+
+	if len(states) == 0 {
+		return "No Telegram webhooks checked yet", nil
+	}
+
+	// bots with issues first
+	sort.Slice(states, func(i, j int) bool {
+		if (states[i].Issue != "") != (states[j].Issue != "") {
+			return states[i].Issue != ""
+		}
+		return states[i].PendingUpdates > states[j].PendingUpdates
+	})
+
+	lines := []string{"Telegram webhooks:"}
+	for _, s := range states {
+		line := s.String()
+		if time.Since(s.CheckedAt) > Config.TGWebhookCheckInterval*3 {
+			line += ", checked " + s.CheckedAt.UTC().Format("2006-01-02 15:04")
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
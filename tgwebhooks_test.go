@@ -0,0 +1,40 @@
+package integram
+
+import (
+	"testing"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+func Test_tgWebhookIssue(t *testing.T) {
+	now := time.Now()
+	url := "https://integram.org/tg/123"
+	recent := int(now.Add(-time.Minute).Unix())
+	old := int(now.Add(-time.Hour * 2).Unix())
+
+	tests := []struct {
+		name     string
+		info     tg.WebhookInfo
+		want     string
+		healable bool
+	}{
+		{"ok", tg.WebhookInfo{URL: url}, "", false},
+		{"not set", tg.WebhookInfo{}, tgWebhookIssueNotSet, true},
+		{"wrong url", tg.WebhookInfo{URL: "https://old.integram.org/tg/123"}, tgWebhookIssueWrongURL, true},
+		{"old error", tg.WebhookInfo{URL: url, LastErrorDate: old, LastErrorMessage: "Connection timed out"}, "", false},
+		{"certificate", tg.WebhookInfo{URL: url, LastErrorDate: recent, LastErrorMessage: "SSL error {error:14090086:SSL routines:ssl3_get_server_certificate:certificate verify failed}"}, tgWebhookIssueCertificate, true},
+		{"delivery", tg.WebhookInfo{URL: url, LastErrorDate: recent, LastErrorMessage: "Wrong response from the webhook: 502 Bad Gateway"}, tgWebhookIssueDelivery, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tgWebhookIssue(tt.info, url, now)
+			if got != tt.want {
+				t.Errorf("tgWebhookIssue() = %v, want %v", got, tt.want)
+			}
+			if tgWebhookIssueHealable(got) != tt.healable {
+				t.Errorf("tgWebhookIssueHealable(%s) = %v, want %v", got, !tt.healable, tt.healable)
+			}
+		})
+	}
+}
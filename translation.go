@@ -0,0 +1,731 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chat command to set the target language: '/translate de', '/translate off'
+const translateCommand = "translate"
+
+// data of the button to toggle between the translated and the original text
+const (
+	translationShowOriginalData   = "_tr_orig"
+	translationShowTranslatedData = "_tr_transl"
+)
+
+const translationRequestTimeout = time.Second * 10
+
+// max time the notification waits for the translation before it's sent untranslated. Late translation is still cached for the next notifications
+const translationWaitTimeout = time.Second * 2
+
+// same notification is often sent to many chats with the same language
+const translationCacheTTL = time.Hour
+
+// translations are available behind the "Show original" button for this period
+const translationTTL = time.Hour * 24 * 30
+
+var translationLanguageRegexp = regexp.MustCompile(`^[a-z]{2}(-[a-z]{2,4})?$`)
+
+var translationClient = &http.Client{Timeout: translationRequestTimeout}
+
+var translationsCache = &tgAPICache{items: make(map[string]tgAPICacheItem)}
+
+var errTranslationTimeout = errors.New("translation timed out")
+
+// markup, code and URLs are replaced with this tag in the HTML sent to the translator
+var translationPlaceholderRegexp = regexp.MustCompile(`(?i)<x id="?(\d+)"?\s*/?>(\s*</x>)?`)
+
+var markdownTranslatedTextEscaper = newBackslashEscaper("_*`[")
+var htmlTranslatedTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+type translationResult struct {
+	text       string
+	sourceLang string
+	err        error
+}
+
+// Translator translates the text to the target language and returns the detected source language
+type Translator interface {
+	Translate(text string, html bool, targetLang string) (translated string, sourceLang string, err error)
+}
+
+// messageTranslation is stored in the "messages_translations" collection to toggle the message between the original and the translated text
+type messageTranslation struct {
+	ID         bson.ObjectId `bson:"_id"` // ID of the outgoing message
+	Lang       string        `bson:"l"`
+	SourceLang string        `bson:"s,omitempty"`
+	Original   string        `bson:"o"`
+	Translated string        `bson:"t"`
+	Date       time.Time     `bson:"d"`
+}
+
+var customTranslatorMutex = sync.RWMutex{}
+var customTranslator Translator
+
+// SetTranslator sets the translator used instead of the one configured with INTEGRAM_TRANSLATION_PROVIDER. Set nil to use the configured one
+func SetTranslator(t Translator) {
+	customTranslatorMutex.Lock()
+	defer customTranslatorMutex.Unlock()
+
+	customTranslator = t
+}
+
+// translator returns the translator of the instance or nil if the translation is disabled
+func translator() Translator {
+	customTranslatorMutex.RLock()
+	t := customTranslator
+	customTranslatorMutex.RUnlock()
+
+	if t != nil {
+		return t
+	}
+
+	switch strings.ToLower(Config.TranslationProvider) {
+	case "deepl":
+		return deeplTranslator{apiKey: Config.TranslationAPIKey, url: Config.TranslationURL}
+	case "google":
+		return googleTranslator{apiKey: Config.TranslationAPIKey, url: Config.TranslationURL}
+	case "custom":
+		if Config.TranslationURL != "" {
+			return endpointTranslator{url: Config.TranslationURL, apiKey: Config.TranslationAPIKey}
+		}
+	}
+
+	return nil
+}
+
+// normalizeTranslationLanguage returns the lowercase language code, e.g. "de" or "pt-br", or empty string if it's invalid
+func normalizeTranslationLanguage(lang string) string {
+	lang = strings.Replace(strings.ToLower(strings.TrimSpace(lang)), "_", "-", -1)
+	if !translationLanguageRegexp.MatchString(lang) {
+		return ""
+	}
+	return lang
+}
+
+// TranslationLanguage returns the language the chat's notifications are translated to. Empty string means translation is off
+func (chat *Chat) TranslationLanguage() (string, error) {
+	var data struct {
+		TranslateTo string
+	}
+
+	err := chat.ctx.db.C("chats").FindId(chat.ID).Select(bson.M{"translateto": 1}).One(&data)
+	if err == mgo.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return data.TranslateTo, nil
+}
+
+// SetTranslationLanguage sets the language the chat's notifications are translated to. Empty string turns translation off
+func (chat *Chat) SetTranslationLanguage(lang string) error {
+	if lang == "" {
+		return chat.ctx.db.C("chats").UpdateId(chat.ID, bson.M{"$unset": bson.M{"translateto": ""}})
+	}
+
+	_, err := chat.ctx.db.C("chats").UpsertId(chat.ID, bson.M{"$set": bson.M{"translateto": lang}, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	return err
+}
+
+// translate replaces the notification's text with the translation to the chat's language and adds the "Show original" button. Returns nil if the message wasn't translated
+func (m *OutgoingMessage) translate(db *mgo.Database) *messageTranslation {
+	if m.Text == "" || !m.isNotification() || len(m.KeyboardMarkup) > 0 || m.ForceReply {
+		return nil
+	}
+
+	t := translator()
+	if t == nil {
+		return nil
+	}
+
+	var data struct {
+		TranslateTo string
+	}
+	err := db.C("chats").FindId(m.ChatID).Select(bson.M{"translateto": 1}).One(&data)
+	if err != nil || data.TranslateTo == "" {
+		return nil
+	}
+
+	translated, sourceLang, err := translateMarkup(t, m.Text, m.ParseMode, data.TranslateTo)
+	if err != nil {
+		log.WithError(err).WithField("chat", m.ChatID).Error("Can't translate the message, sending the original")
+		return nil
+	}
+
+	if translated == "" || translated == m.Text || strings.EqualFold(sourceLang, data.TranslateTo) {
+		return nil
+	}
+
+	tr := &messageTranslation{Lang: data.TranslateTo, SourceLang: strings.ToLower(sourceLang), Original: m.Text, Translated: translated, Date: time.Now()}
+
+	m.Text = translated
+	m.InlineKeyboardMarkup.AppendRows(InlineButtons{InlineButton{Text: translationButtonText(true, tr.SourceLang), Data: translationShowOriginalData}})
+
+	return tr
+}
+
+// translateText returns the text translated to the language or the original text if it can't be translated
+func translateText(text string, parseMode string, lang string) string {
+	t := translator()
+	if t == nil {
+		return text
+	}
+
+	translated, _, err := translateMarkup(t, text, parseMode, lang)
+	if err != nil || translated == "" {
+		return text
+	}
+	return translated
+}
+
+// translateMarkup translates only the text nodes of the text formatted with the parse mode, so the markup, code and URLs are kept as is.
+// Results are cached and the caller waits for the translator not longer than translationWaitTimeout
+func translateMarkup(t Translator, text string, parseMode string, lang string) (string, string, error) {
+	key := compactHash(lang + "\n" + parseMode + "\n" + text)
+	if v, exists := translationsCache.get(key); exists {
+		r := v.(translationResult)
+		return r.text, r.sourceLang, nil
+	}
+
+	done := make(chan translationResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- translationResult{err: fmt.Errorf("translator panic: %v", r)}
+			}
+		}()
+
+		source, tokens := translatableText(text, parseMode)
+		translated, sourceLang, err := t.Translate(source, true, lang)
+		if err == nil {
+			translated, err = restoreTranslatedText(translated, tokens, parseMode)
+		}
+		if err != nil {
+			done <- translationResult{err: err}
+			return
+		}
+
+		r := translationResult{text: translated, sourceLang: sourceLang}
+		translationsCache.set(key, r, translationCacheTTL)
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.text, r.sourceLang, r.err
+	case <-time.After(translationWaitTimeout):
+		return "", "", errTranslationTimeout
+	}
+}
+
+// translatableHTML is the HTML sent to the translator. Markup is replaced with the placeholders, so only the text is translated
+type translatableHTML struct {
+	buf     bytes.Buffer
+	pending bytes.Buffer
+	tokens  []string
+}
+
+func (t *translatableHTML) text(s string) {
+	t.pending.WriteString(s)
+}
+
+func (t *translatableHTML) markup(s string) {
+	t.flush()
+	fmt.Fprintf(&t.buf, `<x id="%d"></x>`, len(t.tokens))
+	t.tokens = append(t.tokens, s)
+}
+
+func (t *translatableHTML) flush() {
+	t.buf.WriteString(html.EscapeString(t.pending.String()))
+	t.pending.Reset()
+}
+
+// translatableText returns the HTML to translate and the markup replaced with the placeholders
+func translatableText(text string, parseMode string) (string, []string) {
+	t := &translatableHTML{}
+
+	switch parseMode {
+	case "HTML":
+		translatableFromHTML(t, text)
+	case "Markdown":
+		translatableFromMarkdown(t, text)
+	case ParseModeMarkdownV2:
+		translatableFromMarkdownV2(t, text)
+	default:
+		t.text(text)
+	}
+
+	t.flush()
+	return t.buf.String(), t.tokens
+}
+
+// translatableFromHTML keeps the tags and the content of code and pre as the markup
+func translatableFromHTML(t *translatableHTML, text string) {
+	z := html.NewTokenizer(strings.NewReader(text))
+
+	var code bytes.Buffer
+	codeTag := ""
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		raw := string(z.Raw())
+		name, _ := z.TagName()
+
+		if codeTag != "" {
+			code.WriteString(raw)
+			if tt == html.EndTagToken && string(name) == codeTag {
+				t.markup(code.String())
+				code.Reset()
+				codeTag = ""
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			t.text(html.UnescapeString(raw))
+		case html.StartTagToken:
+			if string(name) == "code" || string(name) == "pre" {
+				codeTag = string(name)
+				code.WriteString(raw)
+				continue
+			}
+			t.markup(raw)
+		default:
+			t.markup(raw)
+		}
+	}
+
+	if codeTag != "" {
+		t.markup(code.String())
+	}
+}
+
+// translatableFromMarkdown keeps the entities' markers, code and the links' URLs as the markup
+func translatableFromMarkdown(t *translatableHTML, text string) {
+	for i := 0; i < len(text); {
+		if m := markdownLinkRE.FindStringSubmatch(text[i:]); m != nil {
+			t.markup("[")
+			t.text(m[1])
+			t.markup("](" + m[2] + ")")
+			i += len(m[0])
+			continue
+		}
+
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("_*`[", text[i+1]) > -1:
+			t.text(text[i+1 : i+2])
+			i += 2
+		case c == '`':
+			marker := "`"
+			if strings.HasPrefix(text[i:], "```") {
+				marker = "```"
+			}
+
+			end := strings.Index(text[i+len(marker):], marker)
+			if end == -1 {
+				t.markup(text[i:])
+				return
+			}
+			t.markup(text[i : i+len(marker)*2+end])
+			i += len(marker)*2 + end
+		case c == '*' || c == '_':
+			t.markup(text[i : i+1])
+			i++
+		default:
+			t.text(text[i : i+1])
+			i++
+		}
+	}
+}
+
+// translatableFromMarkdownV2 keeps the entities' markers, code and the links' URLs as the markup. Escaped chars are translated as the text
+func translatableFromMarkdownV2(t *translatableHTML, text string) {
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		if c == '\\' && i+1 < len(text) {
+			_, size := utf8.DecodeRuneInString(text[i+1:])
+			t.text(text[i+1 : i+1+size])
+			i += 1 + size
+			continue
+		}
+
+		if c == '`' {
+			marker := "`"
+			if strings.HasPrefix(text[i:], "```") {
+				marker = "```"
+			}
+
+			_, n, err := readMarkdownV2Code(text, i+len(marker), marker)
+			if err != nil {
+				t.markup(text[i:])
+				return
+			}
+			t.markup(text[i : i+len(marker)+n])
+			i += len(marker) + n
+			continue
+		}
+
+		if c == ']' && strings.HasPrefix(text[i+1:], "(") {
+			_, n, err := readMarkdownV2URL(text, i+2)
+			if err != nil {
+				t.markup(text[i:])
+				return
+			}
+			t.markup(text[i : i+2+n])
+			i += 2 + n
+			continue
+		}
+
+		if c == '[' || c == '>' {
+			t.markup(text[i : i+1])
+			i++
+			continue
+		}
+
+		marker := ""
+		for _, m := range markdownV2Markers {
+			if strings.HasPrefix(text[i:], m) {
+				marker = m
+				break
+			}
+		}
+		if marker != "" {
+			t.markup(marker)
+			i += len(marker)
+			continue
+		}
+
+		t.text(text[i : i+1])
+		i++
+	}
+}
+
+// restoreTranslatedText replaces the placeholders in the translated HTML with the markup and escapes the text for the parse mode.
+// Returns the error if the translator lost or broke any placeholder
+func restoreTranslatedText(translated string, tokens []string, parseMode string) (string, error) {
+	var res bytes.Buffer
+	restored := make([]bool, len(tokens))
+
+	last := 0
+	for _, loc := range translationPlaceholderRegexp.FindAllStringSubmatchIndex(translated, -1) {
+		res.WriteString(escapeTranslatedText(html.UnescapeString(translated[last:loc[0]]), parseMode))
+
+		n, _ := strconv.Atoi(translated[loc[2]:loc[3]])
+		if n >= len(tokens) || restored[n] {
+			return "", fmt.Errorf("translation has the unexpected placeholder %d", n)
+		}
+
+		res.WriteString(tokens[n])
+		restored[n] = true
+		last = loc[1]
+	}
+	res.WriteString(escapeTranslatedText(html.UnescapeString(translated[last:]), parseMode))
+
+	for n, ok := range restored {
+		if !ok {
+			return "", fmt.Errorf("translation lost the placeholder %d", n)
+		}
+	}
+
+	return res.String(), nil
+}
+
+// escapeTranslatedText escapes the translated text node for the parse mode
+func escapeTranslatedText(s string, parseMode string) string {
+	switch parseMode {
+	case "HTML":
+		return htmlTranslatedTextEscaper.Replace(s)
+	case "Markdown":
+		return markdownTranslatedTextEscaper.Replace(s)
+	case ParseModeMarkdownV2:
+		return EscapeMarkdownV2(s)
+	}
+	return s
+}
+
+// translationButtonText returns the text of the button to toggle the translation
+func translationButtonText(showOriginal bool, sourceLang string) string {
+	if !showOriginal {
+		return "🌐 Show translation"
+	}
+
+	if sourceLang != "" {
+		return fmt.Sprintf("🌐 Show original (%s)", sourceLang)
+	}
+	return "🌐 Show original"
+}
+
+// toggleTranslation edits the pressed message to show the original or the translated text
+func (c *Context) toggleTranslation(showOriginal bool) error {
+	om := c.Callback.Message
+
+	var tr messageTranslation
+	err := c.db.C("messages_translations").FindId(om.ID).One(&tr)
+	if err == mgo.ErrNotFound {
+		return c.AnswerCallbackQuery("Original text is no longer available", false)
+	} else if err != nil {
+		return err
+	}
+
+	kb := om.InlineKeyboardMarkup
+	kb.Buttons = make([]InlineButtons, len(om.InlineKeyboardMarkup.Buttons))
+	for i, row := range om.InlineKeyboardMarkup.Buttons {
+		kb.Buttons[i] = append(InlineButtons{}, row...)
+	}
+
+	text, from, to := tr.Translated, translationShowTranslatedData, translationShowOriginalData
+	if showOriginal {
+		text, from, to = tr.Original, translationShowOriginalData, translationShowTranslatedData
+	}
+
+	if i, j, b := kb.Find(from); b != nil {
+		kb.Buttons[i][j].Data = to
+		kb.Buttons[i][j].Text = translationButtonText(!showOriginal, tr.SourceLang)
+	}
+
+	err = c.editMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, text, kb)
+	if err != nil {
+		return err
+	}
+
+	return c.AnswerCallbackQuery("", false)
+}
+
+// handleTranslateCommand process '/translate [lang|off]' sent by the chat admin. Returns true if message was handled
+func (c *Context) handleTranslateCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand(translateCommand) {
+		return false
+	}
+
+	reply := func(text string) {
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).SetParseMode("").applyBrandingFooter().Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleTranslateCommand: can't send the reply")
+		}
+	}
+
+	if translator() == nil {
+		reply("Translation is not enabled on this instance")
+		return true
+	}
+
+	param = strings.TrimSpace(param)
+	if param == "" {
+		lang, err := c.Chat.TranslationLanguage()
+		if err != nil {
+			c.Log().WithError(err).Error("handleTranslateCommand: can't get the language")
+			reply(c.Branding().ErrorText("Can't get the translation settings. Please try again later"))
+			return true
+		}
+
+		usage := "Usage: /" + coreCommand(translateCommand) + " language code (e.g. en, de, pt-br) or off"
+		if lang == "" {
+			reply("Translation is off.\n" + usage)
+		} else {
+			reply(fmt.Sprintf("Notifications are translated to '%s'.\n%s", lang, usage))
+		}
+		return true
+	}
+
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("handleTranslateCommand: can't check chat admin")
+		reply("Can't check your permissions in this chat. Please try again later")
+		return true
+	} else if !isAdmin {
+		reply("Only chat admins can change the translation settings")
+		return true
+	}
+
+	lang := ""
+	if strings.ToLower(param) != "off" {
+		lang = normalizeTranslationLanguage(param)
+		if lang == "" {
+			reply(fmt.Sprintf("'%s' is not a language code. Please use the code like en, de or pt-br", param))
+			return true
+		}
+	}
+
+	err := c.Chat.SetTranslationLanguage(lang)
+	if err != nil {
+		c.Log().WithError(err).Error("handleTranslateCommand: can't save the language")
+		reply(c.Branding().ErrorText("Can't save the translation settings. Please try again later"))
+		return true
+	}
+
+	if lang == "" {
+		reply("Translation is turned off")
+	} else {
+		reply(fmt.Sprintf("Notifications will be translated to '%s'. Use the button below the message to see the original", lang))
+	}
+
+	return true
+}
+
+func readTranslationResponse(resp *http.Response, err error, v interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("translation request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// deeplTranslator uses DeepL API. INTEGRAM_TRANSLATION_URL overrides the API URL, e.g. for the free plan
+type deeplTranslator struct {
+	apiKey string
+	url    string
+}
+
+func (t deeplTranslator) Translate(text string, html bool, targetLang string) (string, string, error) {
+	apiURL := t.url
+	if apiURL == "" {
+		apiURL = "https://api.deepl.com/v2/translate"
+	}
+
+	form := url.Values{"text": {text}, "target_lang": {strings.ToUpper(targetLang)}}
+	if html {
+		form.Set("tag_handling", "html")
+	}
+
+	req, err := http.NewRequest("POST", apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+t.apiKey)
+
+	var res struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+
+	resp, err := translationClient.Do(req)
+	err = readTranslationResponse(resp, err, &res)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(res.Translations) == 0 {
+		return "", "", errors.New("DeepL returned no translations")
+	}
+
+	return res.Translations[0].Text, res.Translations[0].DetectedSourceLanguage, nil
+}
+
+// googleTranslator uses Google Cloud Translation API v2
+type googleTranslator struct {
+	apiKey string
+	url    string
+}
+
+func (t googleTranslator) Translate(text string, html bool, targetLang string) (string, string, error) {
+	apiURL := t.url
+	if apiURL == "" {
+		apiURL = "https://translation.googleapis.com/language/translate/v2"
+	}
+
+	format := "text"
+	if html {
+		format = "html"
+	}
+
+	form := url.Values{"q": {text}, "target": {targetLang}, "format": {format}, "key": {t.apiKey}}
+
+	var res struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText         string `json:"translatedText"`
+				DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+
+	resp, err := translationClient.PostForm(apiURL, form)
+	err = readTranslationResponse(resp, err, &res)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(res.Data.Translations) == 0 {
+		return "", "", errors.New("Google returned no translations")
+	}
+
+	return res.Data.Translations[0].TranslatedText, res.Data.Translations[0].DetectedSourceLanguage, nil
+}
+
+// endpointTranslator posts {"text", "html", "target"} to the custom endpoint and expects {"text", "source"} in response
+type endpointTranslator struct {
+	url    string
+	apiKey string
+}
+
+func (t endpointTranslator) Translate(text string, html bool, targetLang string) (string, string, error) {
+	body, err := json.Marshal(map[string]interface{}{"text": text, "html": html, "target": targetLang})
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequest("POST", t.url, strings.NewReader(string(body)))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	var res struct {
+		Text   string `json:"text"`
+		Source string `json:"source"`
+	}
+
+	resp, err := translationClient.Do(req)
+	err = readTranslationResponse(resp, err, &res)
+	if err != nil {
+		return "", "", err
+	}
+
+	return res.Text, res.Source, nil
+}
@@ -0,0 +1,133 @@
+package integram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_normalizeTranslationLanguage(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"de", "de"},
+		{" EN ", "en"},
+		{"pt-BR", "pt-br"},
+		{"zh_Hans", "zh-hans"},
+		{"german", ""},
+		{"d", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			if got := normalizeTranslationLanguage(tt.lang); got != tt.want {
+				t.Errorf("normalizeTranslationLanguage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_translators(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deepl":
+			if r.Header.Get("Authorization") != "DeepL-Auth-Key key" || r.FormValue("target_lang") != "DE" || r.FormValue("tag_handling") != "html" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"translations":[{"detected_source_language":"EN","text":"<b>Hallo</b>"}]}`))
+		case "/google":
+			if r.FormValue("key") != "key" || r.FormValue("target") != "de" || r.FormValue("format") != "html" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"data":{"translations":[{"translatedText":"<b>Hallo</b>","detectedSourceLanguage":"en"}]}}`))
+		case "/custom":
+			var req struct {
+				Text   string
+				HTML   bool
+				Target string
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.Header.Get("Authorization") != "Bearer key" || req.Target != "de" || !req.HTML || req.Text != "<b>Hello</b>" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"text":"<b>Hallo</b>","source":"en"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		translator Translator
+		wantErr    bool
+	}{
+		{"deepl", deeplTranslator{apiKey: "key", url: srv.URL + "/deepl"}, false},
+		{"google", googleTranslator{apiKey: "key", url: srv.URL + "/google"}, false},
+		{"custom", endpointTranslator{apiKey: "key", url: srv.URL + "/custom"}, false},
+		{"wrong key", endpointTranslator{apiKey: "wrong", url: srv.URL + "/custom"}, true},
+		{"not found", endpointTranslator{url: srv.URL + "/unknown"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translated, sourceLang, err := tt.translator.Translate("<b>Hello</b>", true, "de")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Translate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if translated != "<b>Hallo</b>" {
+				t.Errorf("Translate() translated = %v, want <b>Hallo</b>", translated)
+			}
+			if normalizeTranslationLanguage(sourceLang) != "en" {
+				t.Errorf("Translate() sourceLang = %v, want en", sourceLang)
+			}
+		})
+	}
+}
+
+// replaceTranslator replaces the words in the HTML as is, including the placeholders
+type replaceTranslator struct {
+	replacer *strings.Replacer
+}
+
+func (t replaceTranslator) Translate(text string, html bool, targetLang string) (string, string, error) {
+	return t.replacer.Replace(text), "en", nil
+}
+
+func Test_translateMarkup(t *testing.T) {
+	translator := replaceTranslator{strings.NewReplacer("Hello", "Hallo")}
+
+	tests := []struct {
+		name       string
+		translator Translator
+		text       string
+		parseMode  string
+		want       string
+		wantErr    bool
+	}{
+		{"plain", translator, "Hello & <you>", "", "Hallo & <you>", false},
+		{"markdown", translator, "*Hello* [Hello](http://example.com/Hello) `Hello` \\_", "Markdown", "*Hallo* [Hallo](http://example.com/Hello) `Hello` \\_", false},
+		{"html", translator, `<b>Hello</b> &amp; <a href="http://example.com/Hello">Hello</a> <code>Hello</code>`, "HTML", `<b>Hallo</b> &amp; <a href="http://example.com/Hello">Hallo</a> <code>Hello</code>`, false},
+		{"markdown v2", translator, "*Hello* \\. [Hello](http://example.com/Hello) `Hello`", ParseModeMarkdownV2, "*Hallo* \\. [Hallo](http://example.com/Hello) `Hello`", false},
+		{"placeholder lost", replaceTranslator{strings.NewReplacer(`<x id="1"></x>`, "")}, "*Hello*", "Markdown", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := translateMarkup(tt.translator, tt.text, tt.parseMode, "de")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("translateMarkup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("translateMarkup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ type User struct {
 	UserName  string `bson:",omitempty"`
 	Tz        string
 	Lang	  string
+	Locale    string `bson:",omitempty"` // chosen by the user with /language, see Language
 
 	ctx  *Context // provide pointer to Context for convenient nesting and DB quering
 	data *userData
@@ -33,6 +34,7 @@ type Chat struct {
 	UserName  string `bson:",omitempty"`
 	Title     string `bson:",omitempty"`
 	Tz        string `bson:",omitempty"`
+	Lang      string `bson:",omitempty"` // set with SetLang to render messages for the group chat in this language
 
 	ctx  *Context // provide pointer to Context for convenient nesting and DB quering
 	data *chatData
@@ -119,6 +121,7 @@ type userProtected struct {
 	OAuthExpireDate   *time.Time
 	OAuthRefreshToken string
 	AuthTempToken     string // Temp token for redirect to time-limited Oauth URL to authorize the user (F.e. Trello)
+	OAuthSharedFrom   string `bson:",omitempty"` // Service name which OAuth token is used with the user's consent instead of the own one
 	OAuthValid    	  bool // used for stat purposes
 	OAuthStore    	  string // to detect whether non-standard store used
 
@@ -129,6 +132,7 @@ type userProtected struct {
 // Core settings for Telegram Chat behavior per Service
 type chatProtected struct {
 	BotStoppedOrKickedAt *time.Time `bson:",omitempty"`  // when we informed that bot was stopped by user
+	ReconciledAt         *time.Time `bson:",omitempty"` // when the bot's membership in the chat was verified last time
 }
 
 // Struct for chat's data. Used to store in MongoDB
@@ -144,6 +148,8 @@ type chatData struct {
 	IgnoreRateLimit    bool  	  `bson:",omitempty"`
 	MigratedToChatID   int64	  `bson:",omitempty"`
 	MigratedFromChatID int64	  `bson:",omitempty"`
+	LastHumanAt        *time.Time `bson:",omitempty"` // last message or button press in the chat, stored with a day precision
+	HibernatedAt       *time.Time `bson:",omitempty"` // when the chat was hibernated because of no human activity
 }
 
 type chatKeyboard struct {
@@ -0,0 +1,187 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// data of the Undo button added by Context.WithUndo
+const undoButtonData = "_undo"
+
+// used when WithUndo is called without the window
+const undoDefaultWindow = time.Second * 30
+
+// messageUndo is stored in the "messages_undo" collection until the Undo button is pressed or the window ends
+type messageUndo struct {
+	ID          bson.ObjectId `bson:"_id"` // ID of the confirmation message
+	Service     string        `bson:"s"`
+	ChatID      int64         `bson:"c"`
+	UserID      int64         `bson:"u"` // only the user performed the action can undo it
+	Compensator string        `bson:"f"` // short path of the func in actionFuncs
+	Args        []byte        `bson:"a"`
+	Text        string        `bson:"t"` // confirmation text, the undone mark is appended to it
+	ExpiresAt   time.Time     `bson:"x"`
+}
+
+// WithUndo performs the action and sends the confirmation message it returns with the Undo button. Button is removed after the window.
+// When it's pressed within the window by the current user, compensator is called with args and the message is marked as undone.
+// compensator is func(c *Context, args...) error and must be added to Service.Actions. Omit the first *Context arg in args
+func (c *Context) WithUndo(action func(c *Context) (*OutgoingMessage, error), compensator interface{}, window time.Duration, args ...interface{}) error {
+	if window <= 0 {
+		window = undoDefaultWindow
+	}
+
+	s := c.Service()
+	if s == nil {
+		return errors.New("WithUndo: service not found")
+	}
+
+	funcName := s.getShortFuncPath(compensator)
+	if _, ok := actionFuncs[funcName]; !ok {
+		return fmt.Errorf("WithUndo: compensator '%s' not registered in Service.Actions", funcName)
+	}
+
+	if err := verifyTypeMatching(compensator, args...); err != nil {
+		return fmt.Errorf("WithUndo: compensator's args: %s", err.Error())
+	}
+
+	data, err := encode(args)
+	if err != nil {
+		return err
+	}
+
+	m, err := action(c)
+	if err != nil || m == nil {
+		return err
+	}
+
+	u := messageUndo{Service: c.ServiceName, ChatID: m.ChatID, UserID: c.User.ID, Compensator: funcName, Args: data, Text: m.Text}
+
+	m.InlineKeyboardMarkup.AppendRows(InlineButtons{InlineButton{Text: c.T("↩️ Undo"), Data: undoButtonData}})
+	err = m.Send()
+	if err != nil {
+		return err
+	}
+
+	u.ID = m.ID
+	u.ExpiresAt = time.Now().Add(window)
+	if m.SendAfter != nil && m.SendAfter.After(time.Now()) {
+		u.ExpiresAt = m.SendAfter.Add(window)
+	}
+
+	err = c.db.C("messages_undo").Insert(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = undoExpireJob.Schedule(0, u.ExpiresAt, u.ID.Hex())
+	return err
+}
+
+// withoutInlineButton returns the copy of the keyboard without the button. Rows left empty are removed
+func withoutInlineButton(kb InlineKeyboard, buttonData string) InlineKeyboard {
+	res := kb
+	res.Buttons = nil
+	for _, row := range kb.Buttons {
+		var newRow InlineButtons
+		for _, b := range row {
+			if b.Data != buttonData {
+				newRow = append(newRow, b)
+			}
+		}
+
+		if len(newRow) > 0 {
+			res.Buttons = append(res.Buttons, newRow)
+		}
+	}
+	return res
+}
+
+// handleUndoCallback calls the compensator of the pressed message and marks it as undone
+func (c *Context) handleUndoCallback() error {
+	om := c.Callback.Message
+
+	var u messageUndo
+	err := c.db.C("messages_undo").FindId(om.ID).One(&u)
+	if err == mgo.ErrNotFound || (err == nil && time.Now().After(u.ExpiresAt)) {
+		return c.AnswerCallbackQuery(c.T("It's too late to undo"), false)
+	} else if err != nil {
+		return err
+	}
+
+	if u.UserID != 0 && u.UserID != c.User.ID {
+		return c.AnswerCallbackQuery(c.T("Only the author of the action can undo it"), false)
+	}
+
+	// the button may be pressed twice or the window may end meanwhile, so only the one who removed the undo proceeds
+	err = c.db.C("messages_undo").Remove(bson.M{"_id": u.ID, "x": bson.M{"$gt": time.Now()}})
+	if err == mgo.ErrNotFound {
+		return c.AnswerCallbackQuery(c.T("It's too late to undo"), false)
+	} else if err != nil {
+		return err
+	}
+
+	handler, ok := actionFuncs[u.Compensator]
+	if !ok {
+		return fmt.Errorf("Undo compensator '%s' not registered in Service.Actions", u.Compensator)
+	}
+
+	err = c.runHandler("callback", c.Service().withMiddlewares(func(hc *Context) error {
+		return callEncodedHandler(hc, handler, u.Args)
+	}), nil)
+	if err != nil {
+		if err != ErrHandlerTimeout {
+			// user can try again until the window ends
+			if insertErr := c.db.C("messages_undo").Insert(u); insertErr != nil {
+				c.Log().WithError(insertErr).Error("handleUndoCallback: can't restore the undo")
+			}
+			c.Log().WithError(err).WithField("compensator", u.Compensator).Error("Undo compensator failed")
+			c.renderServiceError(err)
+		}
+		return nil
+	}
+
+	err = c.EditMessageTextAndInlineKeyboard(om, om.InlineKeyboardMarkup.State, u.Text+"\n\n"+c.T("↩️ Undone"), withoutInlineButton(om.InlineKeyboardMarkup, undoButtonData))
+	if err != nil {
+		c.Log().WithError(err).Error("handleUndoCallback: can't mark the message as undone")
+	}
+
+	return c.AnswerCallbackQuery(c.T("Undone"), false)
+}
+
+// expireUndo removes the Undo button after the window ends. Executed with undoExpireJob
+func expireUndo(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return nil
+	}
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	var u messageUndo
+	_, err := db.C("messages_undo").FindId(bson.ObjectIdHex(id)).Apply(mgo.Change{Remove: true}, &u)
+	if err == mgo.ErrNotFound {
+		// already undone
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var om OutgoingMessage
+	err = db.C("messages").FindId(u.ID).One(&om)
+	if err == mgo.ErrNotFound {
+		// message wasn't sent, e.g. collapsed into the repeated one
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	ctx := &Context{db: db, ServiceName: u.Service}
+	ctx.Chat = Chat{ID: u.ChatID, ctx: ctx}
+
+	return ctx.EditInlineKeyboard(&om, om.InlineKeyboardMarkup.State, withoutInlineButton(om.InlineKeyboardMarkup, undoButtonData))
+}
@@ -0,0 +1,37 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_withoutInlineButton(t *testing.T) {
+	tests := []struct {
+		name string
+		kb   InlineKeyboard
+		want InlineKeyboard
+	}{
+		{
+			"own row",
+			InlineKeyboard{State: "s", Buttons: []InlineButtons{{{Text: "Open", Data: "open"}}, {{Text: "Undo", Data: undoButtonData}}}},
+			InlineKeyboard{State: "s", Buttons: []InlineButtons{{{Text: "Open", Data: "open"}}}},
+		},
+		{
+			"shared row",
+			InlineKeyboard{Buttons: []InlineButtons{{{Text: "Open", Data: "open"}, {Text: "Undo", Data: undoButtonData}}}},
+			InlineKeyboard{Buttons: []InlineButtons{{{Text: "Open", Data: "open"}}}},
+		},
+		{
+			"only button",
+			InlineKeyboard{Buttons: []InlineButtons{{{Text: "Undo", Data: undoButtonData}}}},
+			InlineKeyboard{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withoutInlineButton(tt.kb, undoButtonData); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withoutInlineButton() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
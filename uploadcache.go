@@ -0,0 +1,210 @@
+package integram
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the file_id of the uploaded file is reused since the last send
+const uploadedFileTTL = time.Hour * 24 * 30
+
+// uploadedFile is stored in the "files_uploaded" collection to send the same file again without uploading it
+type uploadedFile struct {
+	ID     string    `bson:"_id"` // see uploadedFileKey
+	BotID  int64     `bson:"b"`
+	FileID string    `bson:"fid"`
+	Date   time.Time `bson:"d"` // last time the file was sent
+}
+
+// uploadedFileKey returns the key of the file's content uploaded by the bot. file_id is bound to the bot and to the file's type and name, so they are the part of the key
+func uploadedFileKey(botID int64, fileType string, fileName string, r io.Reader) (string, error) {
+	content := sha256.New()
+	_, err := io.Copy(content, r)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.Sum([]byte(fmt.Sprintf("%d:%s:%s:%x", botID, fileType, fileName, content.Sum(nil))))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// localFileUploadKey returns the key of the message's local file or empty string if it can't be read
+func (m *OutgoingMessage) localFileUploadKey() string {
+	f, err := os.Open(m.FilePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	key, err := uploadedFileKey(m.BotID, m.FileType, m.FileName, f)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// findUploadedFileID returns Telegram's file_id of the file uploaded before or empty string
+func findUploadedFileID(db *mgo.Database, key string) string {
+	var f uploadedFile
+	_, err := db.C("files_uploaded").FindId(key).Apply(mgo.Change{Update: bson.M{"$set": bson.M{"d": time.Now()}}}, &f)
+	if err != nil {
+		return ""
+	}
+	return f.FileID
+}
+
+func saveUploadedFileID(db *mgo.Database, key string, botID int64, fileID string) error {
+	_, err := db.C("files_uploaded").UpsertId(key, uploadedFile{ID: key, BotID: botID, FileID: fileID, Date: time.Now()})
+	return err
+}
+
+func forgetUploadedFileID(db *mgo.Database, key string) error {
+	err := db.C("files_uploaded").RemoveId(key)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// fileUploadConfig returns the request to upload the message's local file
+func (m *OutgoingMessage) fileUploadConfig() tg.Chattable {
+	if m.FileType == string(FileTypeSticker) {
+		msg := tg.NewStickerUpload(m.ChatID, m.FilePath)
+		msg.FileName = m.FileName
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == string(FileTypeVoice) {
+		msg := tg.NewVoiceUpload(m.ChatID, m.FilePath)
+		msg.FileName = m.FileName
+		msg.Duration = m.FileDuration
+		msg.Caption = m.Text
+		msg.ParseMode = m.ParseMode
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == string(FileTypeVideoNote) {
+		msg := tg.NewVideoNoteUpload(m.ChatID, 0, m.FilePath)
+		msg.FileName = m.FileName
+		msg.Duration = m.FileDuration
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == "image" {
+		msg := tg.NewPhotoUpload(m.ChatID, m.FilePath)
+		msg.FileName = m.FileName
+		msg.Caption = m.Text
+		msg.ParseMode = m.ParseMode
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	msg := tg.NewDocumentUpload(m.ChatID, m.FilePath)
+	msg.FileName = m.FileName
+	msg.Caption = m.Text
+	msg.ParseMode = m.ParseMode
+	m.fillFileBaseChat(&msg.BaseChat)
+	return msg
+}
+
+// fileShareConfig returns the request to send the file already uploaded to Telegram
+func (m *OutgoingMessage) fileShareConfig(fileID string) tg.Chattable {
+	if m.FileType == string(FileTypeSticker) {
+		msg := tg.NewStickerShare(m.ChatID, fileID)
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == string(FileTypeVoice) {
+		msg := tg.NewVoiceShare(m.ChatID, fileID)
+		msg.Duration = m.FileDuration
+		msg.Caption = m.Text
+		msg.ParseMode = m.ParseMode
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == string(FileTypeVideoNote) {
+		msg := tg.NewVideoNoteShare(m.ChatID, 0, fileID)
+		msg.Duration = m.FileDuration
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	if m.FileType == "image" {
+		msg := tg.NewPhotoShare(m.ChatID, fileID)
+		msg.Caption = m.Text
+		msg.ParseMode = m.ParseMode
+		m.fillFileBaseChat(&msg.BaseChat)
+		return msg
+	}
+
+	msg := tg.NewDocumentShare(m.ChatID, fileID)
+	msg.Caption = m.Text
+	msg.ParseMode = m.ParseMode
+	m.fillFileBaseChat(&msg.BaseChat)
+	return msg
+}
+
+// sendFileShare sends the file already uploaded to Telegram
+func (m *OutgoingMessage) sendFileShare(bot *Bot, fileID string) (tg.Message, error) {
+	if m.FileType == string(FileTypeAnimation) {
+		return m.sendAnimation(bot, fileID)
+	}
+	return bot.API.Send(m.fileShareConfig(fileID))
+}
+
+// sendLocalFile sends the message's local file reusing the file_id if the bot has already uploaded the same file
+func (m *OutgoingMessage) sendLocalFile(db *mgo.Database, bot *Bot) (tg.Message, error) {
+	key := m.localFileUploadKey()
+
+	if key != "" {
+		if fileID := findUploadedFileID(db, key); fileID != "" {
+			tgMsg, err := m.sendFileShare(bot, fileID)
+			tgErr, isTGErr := err.(tg.Error)
+			if err == nil || !isTGErr || tgErr.Code != 400 || !strings.Contains(strings.ToLower(err.Error()), "file") {
+				return tgMsg, err
+			}
+
+			// file_id is no longer valid, upload the file again
+			log.WithError(err).WithField("bot", bot.ID).Warn("Can't reuse the uploaded file")
+			forgetUploadedFileID(db, key)
+		}
+	}
+
+	var tgMsg tg.Message
+	var err error
+	if m.FileType == string(FileTypeAnimation) {
+		tgMsg, err = m.sendAnimation(bot, "")
+	} else if m.uploadProgress != nil {
+		tgMsg, err = m.sendFileWithProgress(bot)
+	} else {
+		tgMsg, err = bot.API.Send(m.fileUploadConfig())
+	}
+
+	if err != nil || key == "" {
+		return tgMsg, err
+	}
+
+	if fileID := uploadedFileID(&tgMsg); fileID != "" {
+		if err := saveUploadedFileID(db, key, bot.ID, fileID); err != nil {
+			log.WithError(err).Error("Can't save the uploaded file's file_id")
+		}
+	}
+
+	return tgMsg, nil
+}
@@ -0,0 +1,60 @@
+package integram
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_uploadedFileKey(t *testing.T) {
+	key := func(botID int64, fileType, fileName, content string) string {
+		k, err := uploadedFileKey(botID, fileType, fileName, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("uploadedFileKey() error = %v", err)
+		}
+		return k
+	}
+
+	base := key(1, "document", "build.log", "ok")
+
+	tests := []struct {
+		name     string
+		key      string
+		wantSame bool
+	}{
+		{"same file", key(1, "document", "build.log", "ok"), true},
+		{"other bot", key(2, "document", "build.log", "ok"), false},
+		{"other type", key(1, "image", "build.log", "ok"), false},
+		{"other name", key(1, "document", "build2.log", "ok"), false},
+		{"other content", key(1, "document", "build.log", "failed"), false},
+	}
+	for _, tt := range tests {
+		if (tt.key == base) != tt.wantSame {
+			t.Errorf("%q. uploadedFileKey() same = %v, want %v", tt.name, tt.key == base, tt.wantSame)
+		}
+	}
+}
+
+func Test_findUploadedFileID(t *testing.T) {
+	const key = "test_uploaded_file"
+	forgetUploadedFileID(db, key)
+
+	if fileID := findUploadedFileID(db, key); fileID != "" {
+		t.Fatalf("findUploadedFileID() = %q before upload, want empty", fileID)
+	}
+
+	if err := saveUploadedFileID(db, key, 1, "BQADAgADxx"); err != nil {
+		t.Fatalf("saveUploadedFileID() error = %v", err)
+	}
+
+	if fileID := findUploadedFileID(db, key); fileID != "BQADAgADxx" {
+		t.Errorf("findUploadedFileID() = %q, want BQADAgADxx", fileID)
+	}
+
+	if err := forgetUploadedFileID(db, key); err != nil {
+		t.Fatalf("forgetUploadedFileID() error = %v", err)
+	}
+
+	if fileID := findUploadedFileID(db, key); fileID != "" {
+		t.Errorf("findUploadedFileID() = %q after forget, want empty", fileID)
+	}
+}
@@ -0,0 +1,250 @@
+package integram
+
+import (
+	"fmt"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// start parameter of the private chat link that opens the viewer's actions for the group message
+const viewerActionsStartPrefix = "va_"
+
+const viewerActionsButtonText = "👤 Open my actions"
+
+var viewerActionsStartRE = regexp.MustCompile(`^/start(?:@[a-zA-Z0-9_]+)? ` + viewerActionsStartPrefix + `([0-9a-f]{24})$`)
+
+// SetViewerKeyboardAction sets the func that renders the keyboard for the specific viewer of the group message with Context.RenderViewerKeyboard.
+// Telegram shows the same keyboard to all the group members, so the viewer opens their actions in the private chat with "Open my actions" button.
+// Presses are checked against the viewer's keyboard and passed to the message's callback action with the group chat in the context
+// !!! Please note that you must omit first arg *integram.Context, because it will be automatically prepended and will contain the viewer and the group chat
+func (m *Message) SetViewerKeyboardAction(handlerFunc interface{}, args ...interface{}) *Message {
+	service, err := detectServiceByBot(m.BotID)
+
+	if err != nil {
+		log.WithError(err).Errorf("SetViewerKeyboardAction detectServiceByBot")
+	}
+	funcName := service.getShortFuncPath(handlerFunc)
+
+	err = verifyTypeMatching(handlerFunc, args...)
+
+	if err != nil {
+		log.WithError(err).Error("Can't verify onViewer args for " + funcName + ". Be sure to omit first arg of type '*integram.Context'")
+		return m
+	}
+
+	bytes, err := encode(args)
+
+	if err != nil {
+		log.WithError(err).Error("Can't encode onViewer args")
+		return m
+	}
+
+	m.OnViewerData = bytes
+	m.OnViewerAction = funcName
+
+	return m
+}
+
+// SetViewerKeyboardAction sets the func that renders the keyboard for the specific viewer of the group message. See Message.SetViewerKeyboardAction
+func (m *OutgoingMessage) SetViewerKeyboardAction(handlerFunc interface{}, args ...interface{}) *OutgoingMessage {
+	m.Message.SetViewerKeyboardAction(handlerFunc, args...)
+	return m
+}
+
+// RenderViewerKeyboard sets the viewer's keyboard. Must be called from the func set with SetViewerKeyboardAction
+func (c *Context) RenderViewerKeyboard(kb InlineKeyboard) {
+	c.viewerKeyboard = &kb
+}
+
+// IsChatMember checks if the user is still the member of the chat
+func (c *Context) IsChatMember() (bool, error) {
+	if c.Chat.IsPrivate() {
+		return c.Chat.ID == c.User.ID, nil
+	}
+
+	member, err := c.GetChatMember(c.Chat.ID, c.User.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return !member.HasLeft() && !member.WasKicked(), nil
+}
+
+// addViewerActionsButton adds the button to open the viewer's actions in the private chat. Message ID must be set
+func (m *OutgoingMessage) addViewerActionsButton() {
+	if m.OnViewerAction == "" || m.ChatID > 0 {
+		return
+	}
+
+	bot := botByID(m.BotID)
+	if bot == nil {
+		return
+	}
+
+	m.InlineKeyboardMarkup.AppendRows(InlineButtons{InlineButton{Text: viewerActionsButtonText, URL: bot.PMURL(viewerActionsStartPrefix + m.ID.Hex())}})
+}
+
+// viewerGroupContext returns the context of the group message for the current user. Returns ServiceError if the user isn't the group member
+func (c *Context) viewerGroupContext(om *OutgoingMessage) (*Context, error) {
+	gctx := &Context{db: c.db, ServiceName: c.ServiceName, User: c.User}
+	gctx.User.ctx = gctx
+	gctx.Chat = Chat{ID: om.ChatID, ctx: gctx}
+
+	if chat, err := gctx.FindChat(bson.M{"_id": om.ChatID}); err == nil {
+		gctx.Chat = chat.Chat
+		gctx.Chat.ctx = gctx
+	}
+
+	isMember, err := gctx.IsChatMember()
+	if err != nil {
+		return nil, err
+	} else if !isMember {
+		return nil, NewServiceError("Only the chat members can use these actions", nil).SetSeverity(ServiceErrorSeverityWarning)
+	}
+
+	return gctx, nil
+}
+
+// renderViewerKeyboard calls the message's viewer keyboard action and returns the keyboard it rendered
+func (c *Context) renderViewerKeyboard(om *OutgoingMessage) (InlineKeyboard, error) {
+	handler, ok := actionFuncs[c.Service().trimFuncPath(om.OnViewerAction)]
+	if !ok {
+		return InlineKeyboard{}, fmt.Errorf("Viewer keyboard handler '%s' not registred in service's configuration", om.OnViewerAction)
+	}
+
+	c.viewerKeyboard = nil
+	err := callEncodedHandler(c, handler, om.OnViewerData)
+	if err != nil {
+		return InlineKeyboard{}, err
+	}
+
+	if c.viewerKeyboard == nil {
+		return InlineKeyboard{}, nil
+	}
+	return *c.viewerKeyboard, nil
+}
+
+// findViewerMessage returns the group message with the viewer keyboard action
+func (c *Context) findViewerMessage(id bson.ObjectId) (*OutgoingMessage, error) {
+	msg, err := findMessageByBsonID(c.db, id)
+	if err != nil || msg.om.OnViewerAction == "" || msg.om.BotID != c.Bot().ID {
+		return nil, NewServiceError("This message is no longer available", err).SetSeverity(ServiceErrorSeverityWarning)
+	}
+
+	return msg.om, nil
+}
+
+// handleViewerActionsStart process '/start va_ID' in the private chat opened with "Open my actions" button. Returns true if message was handled
+func (c *Context) handleViewerActionsStart() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() {
+		return false
+	}
+
+	match := viewerActionsStartRE.FindStringSubmatch(c.Message.Text)
+	if len(match) < 2 {
+		return false
+	}
+
+	err := c.sendViewerActions(bson.ObjectIdHex(match[1]))
+	if err != nil {
+		c.Log().WithError(err).Error("handleViewerActionsStart: can't send the actions")
+
+		text := c.Branding().ErrorText("Can't show your actions. Please try again later")
+		if se, ok := AsServiceError(err); ok {
+			text = se.Text
+		}
+
+		err = c.NewMessage().SetText(text).SetParseMode("").Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleViewerActionsStart: can't send the error")
+		}
+	}
+
+	return true
+}
+
+// sendViewerActions sends the keyboard rendered for the current user to the private chat
+func (c *Context) sendViewerActions(id bson.ObjectId) error {
+	om, err := c.findViewerMessage(id)
+	if err != nil {
+		return err
+	}
+
+	gctx, err := c.viewerGroupContext(om)
+	if err != nil {
+		return err
+	}
+
+	kb, err := gctx.renderViewerKeyboard(om)
+	if err != nil {
+		return err
+	}
+
+	if len(kb.Buttons) == 0 {
+		return c.NewMessage().SetText("You have no actions for this message").SetParseMode("").Send()
+	}
+
+	text := "Your actions for the message"
+	if gctx.Chat.Title != "" {
+		text += " in " + gctx.Chat.Title
+	}
+
+	return c.NewMessage().SetText(text).SetParseMode("").SetInlineKeyboard(kb).SetCallbackAction(viewerActionPressed, id.Hex()).Send()
+}
+
+// viewerActionPressed checks the button pressed in the private chat against the viewer's keyboard and passes it to the group message's callback action
+func viewerActionPressed(c *Context, groupMsgID string) error {
+	if !bson.IsObjectIdHex(groupMsgID) {
+		return fmt.Errorf("viewerActionPressed: wrong message ID '%s'", groupMsgID)
+	}
+
+	om, err := c.findViewerMessage(bson.ObjectIdHex(groupMsgID))
+	if err != nil {
+		return err
+	}
+
+	gctx, err := c.viewerGroupContext(om)
+	if err != nil {
+		return err
+	}
+
+	kb, err := gctx.renderViewerKeyboard(om)
+	if err != nil {
+		return err
+	}
+
+	if _, _, button := kb.Find(c.Callback.Data); button == nil {
+		// show the actual actions
+		c.EditPressedInlineKeyboard(kb)
+		return NewServiceError("This action is no longer available for you", nil).SetSeverity(ServiceErrorSeverityWarning)
+	}
+
+	handler, ok := actionFuncs[c.Service().trimFuncPath(om.OnCallbackAction)]
+	if om.OnCallbackAction == "" || !ok {
+		return fmt.Errorf("Callback handler '%s' not registred in service's configuration", om.OnCallbackAction)
+	}
+
+	gctx.Callback = &callback{ID: c.Callback.ID, Data: c.Callback.Data, Message: om, State: c.Callback.State}
+	err = callEncodedHandler(gctx, handler, om.OnCallbackData)
+
+	// the query is answered once
+	c.Callback.AnsweredAt = gctx.Callback.AnsweredAt
+	c.Callback.answerText, c.Callback.answerAlert = gctx.Callback.answerText, gctx.Callback.answerAlert
+	if err != nil {
+		return err
+	}
+
+	// actions may change after the press
+	newKb, err := gctx.renderViewerKeyboard(om)
+	if err != nil {
+		return err
+	}
+
+	if !whetherTGInlineKeyboardsAreEqual(kb.tg(), newKb.tg()) {
+		return c.EditPressedInlineKeyboard(newKb)
+	}
+
+	return nil
+}
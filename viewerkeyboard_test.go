@@ -0,0 +1,27 @@
+package integram
+
+import "testing"
+
+func Test_viewerActionsStartRE(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/start va_5a0c9c6e1d41c8a1b8c4e7f2", "5a0c9c6e1d41c8a1b8c4e7f2"},
+		{"/start@trello_bot va_5a0c9c6e1d41c8a1b8c4e7f2", "5a0c9c6e1d41c8a1b8c4e7f2"},
+		{"/start va_5a0c9c6e1d41c8a1b8c4e7f", ""},
+		{"/start 5a0c9c6e1d41c8a1b8c4e7f2", ""},
+		{"/start va_5a0c9c6e1d41c8a1b8c4e7f2 extra", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := ""
+			if match := viewerActionsStartRE.FindStringSubmatch(tt.text); len(match) == 2 {
+				got = match[1]
+			}
+			if got != tt.want {
+				t.Errorf("viewerActionsStartRE = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package integram
+
+import (
+	"time"
+
+	tg "github.com/requilence/telegram-bot-api"
+)
+
+// SetVoiceFileID adds the voice message already uploaded to Telegram, e.g. IncomingMessage.Voice.FileID. Message's text is sent as the caption using the message's parse mode
+func (m *OutgoingMessage) SetVoiceFileID(fileID string, duration int) *OutgoingMessage {
+	m.FileDuration = duration
+	return m.SetAttachment(AttachmentFromFileID(FileTypeVoice, fileID, ""))
+}
+
+// SetVideoNoteFileID adds the round video note already uploaded to Telegram, e.g. IncomingMessage.VideoNote.FileID. Message's text is not sent with the video note
+func (m *OutgoingMessage) SetVideoNoteFileID(fileID string, duration int) *OutgoingMessage {
+	m.FileDuration = duration
+	return m.SetAttachment(AttachmentFromFileID(FileTypeVideoNote, fileID, ""))
+}
+
+// SendVoice sends the OGG/OPUS voice message to the current chat with the caption using the default parse mode. Duration in seconds is optional
+func (c *Context) SendVoice(a Attachment, duration int, caption string) error {
+	a.Kind = FileTypeVoice
+	m := c.NewMessage().SetText(caption)
+	m.FileDuration = duration
+	return m.SetAttachment(a).Send()
+}
+
+// SendVideoNote sends the square MPEG4 video as the round video note to the current chat. Duration in seconds is optional
+func (c *Context) SendVideoNote(a Attachment, duration int) error {
+	a.Kind = FileTypeVideoNote
+	m := c.NewMessage()
+	m.FileDuration = duration
+	return m.SetAttachment(a).Send()
+}
+
+// GetFile downloads the file sent to the bot by its Telegram's file_id, e.g. IncomingMessage.Voice.FileID, and returns the local path.
+// The file isn't downloaded if its size exceeds maxSize bytes and GetFileMaxSizeExceedError is returned. Zero maxSize means no limit
+func (c *Context) GetFile(fileID string, maxSize int) (string, error) {
+	if maxSize > 0 {
+		bot := c.Bot()
+		f, err := bot.API.GetFile(tg.FileConfig{FileID: fileID})
+		if err != nil {
+			return "", err
+		}
+
+		if f.FileSize > maxSize {
+			return "", GetFileMaxSizeExceedError
+		}
+
+		// resolved file path is reused by GetRemoteFilePath
+		c.SetServiceCache("file_path_"+fileID, f.FilePath, time.Hour*1)
+	}
+
+	return GetLocalFilePath(c, fileID)
+}
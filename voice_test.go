@@ -0,0 +1,21 @@
+package integram
+
+import "testing"
+
+func TestOutgoingMessage_SetVoiceFileID(t *testing.T) {
+	m := &OutgoingMessage{}
+	m.SetVoiceFileID("AwADBAAD", 7)
+
+	if m.FileID != "AwADBAAD" || m.FileType != "voice" || m.FileDuration != 7 {
+		t.Errorf("SetVoiceFileID() = %+v", m)
+	}
+}
+
+func TestOutgoingMessage_SetVideoNoteFileID(t *testing.T) {
+	m := &OutgoingMessage{}
+	m.SetVideoNoteFileID("DQADBAAD", 12)
+
+	if m.FileID != "DQADBAAD" || m.FileType != "video_note" || m.FileDuration != 12 {
+		t.Errorf("SetVideoNoteFileID() = %+v", m)
+	}
+}
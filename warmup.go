@@ -0,0 +1,219 @@
+package integram
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	tg "github.com/requilence/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// how long the preloaded chats and users are kept in memory waiting for the first request
+const warmupCacheTTL = time.Minute * 10
+
+// period to look for the most active chats
+const warmupActivityPeriod = time.Hour * 24
+
+// set to 1 when the instance is ready to accept the traffic
+var instanceReady int32
+
+type warmChat struct {
+	data      chatData
+	expiresAt time.Time
+}
+
+type warmUser struct {
+	data      userData
+	expiresAt time.Time
+}
+
+var warmCacheMutex = sync.Mutex{}
+var warmChats = make(map[int64]warmChat)
+var warmUsers = make(map[int64]warmUser)
+
+// IsReady returns true when the instance finished the warmup and is ready to accept the traffic
+func IsReady() bool {
+	return atomic.LoadInt32(&instanceReady) == 1
+}
+
+func setReady() {
+	atomic.StoreInt32(&instanceReady, 1)
+}
+
+// readinessHandler responds 503 until the warmup is finished
+func readinessHandler(c *gin.Context) {
+	if !IsReady() {
+		c.String(http.StatusServiceUnavailable, "Warming up")
+		return
+	}
+
+	c.String(http.StatusOK, "OK")
+}
+
+// warmup preloads the most active chats and users and checks the bots webhooks. The instance is marked as ready after it
+func warmup() {
+	defer setReady()
+
+	if !Config.Warmup || Config.IsMainInstance() {
+		return
+	}
+
+	startedAt := time.Now()
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	ids, err := mostActiveChatIDs(db, time.Now().Add(-warmupActivityPeriod), Config.WarmupChats)
+	if err != nil {
+		log.WithError(err).Error("warmup: can't get the most active chats")
+	}
+
+	if len(ids) > 0 {
+		chatsCount, usersCount := preloadChatsAndUsers(db, ids)
+		log.Infof("warmup: %d chats and %d users preloaded", chatsCount, usersCount)
+	}
+
+	for _, service := range services {
+		if service.UseWebhookInsteadOfLongPolling {
+			ensureBotWebhook(service.Bot())
+		}
+	}
+
+	log.Infof("warmup: finished in %.2f sec", time.Now().Sub(startedAt).Seconds())
+}
+
+// mostActiveChatIDs returns chats sorted by the number of messages since the time
+func mostActiveChatIDs(db *mgo.Database, since time.Time, limit int) ([]int64, error) {
+	var res []struct {
+		ID int64 `bson:"_id"`
+	}
+
+	err := db.C("messages").Pipe([]bson.M{
+		{"$match": bson.M{"_id": bson.M{"$gt": bson.NewObjectIdWithTime(since)}}},
+		{"$group": bson.M{"_id": "$chatid", "n": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"n": -1}},
+		{"$limit": limit},
+	}).All(&res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(res))
+	for _, r := range res {
+		ids = append(ids, r.ID)
+	}
+
+	return ids, nil
+}
+
+func preloadChatsAndUsers(db *mgo.Database, chatIDs []int64) (chatsCount int, usersCount int) {
+	var chats []chatData
+	err := db.C("chats").Find(bson.M{"_id": bson.M{"$in": chatIDs}}).All(&chats)
+	if err != nil {
+		log.WithError(err).Error("warmup: can't preload chats")
+	}
+
+	userIDs := make([]int64, 0, len(chatIDs))
+	for _, id := range chatIDs {
+		// private chat ID is the same as user's
+		if id > 0 {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	var users []userData
+	err = db.C("users").Find(bson.M{"_id": bson.M{"$in": userIDs}}).All(&users)
+	if err != nil {
+		log.WithError(err).Error("warmup: can't preload users")
+	}
+
+	expiresAt := time.Now().Add(warmupCacheTTL)
+
+	warmCacheMutex.Lock()
+	defer warmCacheMutex.Unlock()
+
+	for _, chat := range chats {
+		warmChats[chat.ID] = warmChat{data: chat, expiresAt: expiresAt}
+	}
+
+	for _, user := range users {
+		warmUsers[user.ID] = warmUser{data: user, expiresAt: expiresAt}
+	}
+
+	return len(chats), len(users)
+}
+
+// popWarmChat returns the preloaded chat's data. Data is removed from the memory to avoid using the stale settings
+func popWarmChat(id int64) (*chatData, bool) {
+	warmCacheMutex.Lock()
+	defer warmCacheMutex.Unlock()
+
+	if len(warmChats) == 0 {
+		return nil, false
+	}
+
+	wc, exists := warmChats[id]
+	if !exists {
+		return nil, false
+	}
+	delete(warmChats, id)
+
+	if time.Now().After(wc.expiresAt) {
+		return nil, false
+	}
+
+	return &wc.data, true
+}
+
+// popWarmUser returns the preloaded user's data. Data is removed from the memory to avoid using the stale settings
+func popWarmUser(id int64) (*userData, bool) {
+	warmCacheMutex.Lock()
+	defer warmCacheMutex.Unlock()
+
+	if len(warmUsers) == 0 {
+		return nil, false
+	}
+
+	wu, exists := warmUsers[id]
+	if !exists {
+		return nil, false
+	}
+	delete(warmUsers, id)
+
+	if time.Now().After(wu.expiresAt) {
+		return nil, false
+	}
+
+	return &wu.data, true
+}
+
+// ensureBotWebhook sets the bot's webhook again if Telegram has another URL or reports the delivery errors
+func ensureBotWebhook(bot *Bot) {
+	if bot == nil {
+		return
+	}
+
+	info, err := bot.API.GetWebhookInfo()
+	if err != nil {
+		log.WithError(err).WithField("botID", bot.ID).Error("warmup: GetWebhookInfo error")
+		return
+	}
+
+	if tgWebhookIssue(info, bot.webhookURL().String(), time.Now()) == "" {
+		return
+	}
+
+	log.WithField("botID", bot.ID).Warnf("warmup: re-registering the webhook, last error: %s", info.LastErrorMessage)
+
+	_, err = bot.API.SetWebhook(tg.WebhookConfig{URL: bot.webhookURL()})
+	if err != nil {
+		log.WithError(err).WithField("botID", bot.ID).Error("warmup: SetWebhook error")
+	}
+}
@@ -0,0 +1,94 @@
+package integram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_popWarmChat(t *testing.T) {
+	warmCacheMutex.Lock()
+	warmChats[1] = warmChat{data: chatData{Chat: Chat{ID: 1}}, expiresAt: time.Now().Add(time.Minute)}
+	warmChats[2] = warmChat{data: chatData{Chat: Chat{ID: 2}}, expiresAt: time.Now().Add(-time.Minute)}
+	warmCacheMutex.Unlock()
+
+	tests := []struct {
+		name string
+		id   int64
+		want bool
+	}{
+		{"preloaded", 1, true},
+		{"popped", 1, false},
+		{"expired", 2, false},
+		{"unknown", 3, false},
+	}
+	for _, tt := range tests {
+		got, exists := popWarmChat(tt.id)
+		if exists != tt.want || exists && got.ID != tt.id {
+			t.Errorf("%q. popWarmChat() = %v, %v, want %v", tt.name, got, exists, tt.want)
+		}
+	}
+
+	if len(warmChats) != 0 {
+		t.Errorf("warmChats = %v, want the expired chat removed too", warmChats)
+	}
+}
+
+func Test_popWarmUser(t *testing.T) {
+	warmCacheMutex.Lock()
+	warmUsers[1] = warmUser{data: userData{User: User{ID: 1}}, expiresAt: time.Now().Add(time.Minute)}
+	warmUsers[2] = warmUser{data: userData{User: User{ID: 2}}, expiresAt: time.Now().Add(-time.Minute)}
+	warmCacheMutex.Unlock()
+
+	tests := []struct {
+		name string
+		id   int64
+		want bool
+	}{
+		{"preloaded", 1, true},
+		{"popped", 1, false},
+		{"expired", 2, false},
+		{"unknown", 3, false},
+	}
+	for _, tt := range tests {
+		got, exists := popWarmUser(tt.id)
+		if exists != tt.want || exists && got.ID != tt.id {
+			t.Errorf("%q. popWarmUser() = %v, %v, want %v", tt.name, got, exists, tt.want)
+		}
+	}
+
+	if len(warmUsers) != 0 {
+		t.Errorf("warmUsers = %v, want the expired user removed too", warmUsers)
+	}
+}
+
+func Test_readinessHandler(t *testing.T) {
+	prev := atomic.LoadInt32(&instanceReady)
+	defer atomic.StoreInt32(&instanceReady, prev)
+
+	atomic.StoreInt32(&instanceReady, 0)
+	tests := []struct {
+		name       string
+		set        func()
+		wantStatus int
+		wantBody   string
+	}{
+		{"warming up", func() {}, http.StatusServiceUnavailable, "Warming up"},
+		{"ready", setReady, http.StatusOK, "OK"},
+	}
+	for _, tt := range tests {
+		tt.set()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		readinessHandler(c)
+
+		if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+			t.Errorf("%q. readinessHandler() = %d %q, want %d %q", tt.name, w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+	}
+}
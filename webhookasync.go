@@ -0,0 +1,244 @@
+package integram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/requilence/jobs"
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// async webhook is retried this number of times after the first attempt. Then it's moved to the "webhooks_dead" collection
+const webhookAsyncRetries = 10
+
+// failed async webhook is rescheduled after this delay, it's doubled after each attempt up to webhookAsyncMaxRetryDelay
+const (
+	webhookAsyncMinRetryDelay = time.Second * 30
+	webhookAsyncMaxRetryDelay = time.Hour
+)
+
+// name of the async webhooks job in the service's jobsPerService
+const asyncWebhookJobName = "asyncWebhook"
+
+// key of the request's context value to mark the webhooks processed by the worker pool. Value is the number of the attempt starting from 1
+type asyncWebhookKey struct{}
+
+// asyncWebhook is the webhook stored in the "webhooks_async" collection to be processed by the service's worker pool, see Service.WebhookAsync.
+// Permanently failed webhooks are moved to the "webhooks_dead" collection
+type asyncWebhook struct {
+	ID        bson.ObjectId  `bson:"_id"`
+	Service   string         `bson:"s"`
+	Request   spilledWebhook `bson:"r"`
+	Attempts  int            `bson:"a"`
+	LastError string         `bson:"e,omitempty"`
+	FailedAt  *time.Time     `bson:"f,omitempty"`
+}
+
+func init() {
+	registerAdminCommand("deadwebhooks", adminDeadWebhooksReport)
+}
+
+// asyncWebhookAttempt returns the number of the worker pool's attempt to process the webhook or 0 if the webhook is processed synchronously
+func asyncWebhookAttempt(r *http.Request) int {
+	attempt, _ := r.Context().Value(asyncWebhookKey{}).(int)
+	return attempt
+}
+
+// registerAsyncWebhookJob registers the job processing the service's async webhooks in its pool
+func registerAsyncWebhookJob(s *Service) {
+	// retries are rescheduled by processAsyncWebhook with the backoff
+	jobType, err := jobs.RegisterTypeWithPoolKey(s.Name+"."+asyncWebhookJobName, "_"+s.Name, 0, processAsyncWebhook)
+	if err != nil {
+		log.WithError(err).WithField("service", s.Name).Error("Can't register the async webhooks job")
+		return
+	}
+	jobsPerService[s.Name][asyncWebhookJobName] = jobType
+}
+
+// enqueueAsyncWebhook stores the webhook for the services with WebhookAsync and answers 202. Returns true if the response was written
+func enqueueAsyncWebhook(c *gin.Context, db *mgo.Database, s *Service) bool {
+	if s == nil || !s.WebhookAsync || c.Request.Method != "POST" || Config.IsMainInstance() || asyncWebhookAttempt(c.Request) > 0 {
+		return false
+	}
+
+	jobType := jobsPerService[s.Name][asyncWebhookJobName]
+	if jobType == nil {
+		return false
+	}
+
+	r, err := newSpilledWebhook(c.Request)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Can't read the webhook")
+		return true
+	}
+	// keep the original time for the webhooks spilled to disk before
+	r.SpilledAt = webhookReceivedAt(c.Request)
+
+	w := asyncWebhook{ID: bson.NewObjectId(), Service: s.Name, Request: *r}
+	err = db.C("webhooks_async").Insert(w)
+	if err == nil {
+		_, err = jobType.Schedule(0, time.Now(), w.ID.Hex())
+		if err != nil {
+			db.C("webhooks_async").RemoveId(w.ID)
+		}
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("service", s.Name).Error("enqueueAsyncWebhook: can't queue the webhook")
+		c.Header("Retry-After", "60")
+		c.String(http.StatusServiceUnavailable, "Webhook can't be queued, please retry later")
+		return true
+	}
+
+	ctx := &Context{db: db, ServiceName: s.Name}
+	ctx.StatInc(StatWebhookQueued)
+
+	c.String(http.StatusAccepted, "Webhook accepted and queued")
+	return true
+}
+
+// asyncWebhookFailure returns the error if the webhook wasn't processed and whether it's permanent, e.g. the hook is unknown or upstream's payload is rejected
+func asyncWebhookFailure(code int, body string) (permanent bool, err error) {
+	if code < 400 {
+		return false, nil
+	}
+
+	return code < 500 && code != http.StatusTooManyRequests, fmt.Errorf("%d %s", code, strings.TrimSpace(body))
+}
+
+// webhookAsyncRetryDelay returns the delay before the next attempt after the number of failed attempts
+func webhookAsyncRetryDelay(attempts int) time.Duration {
+	delay := webhookAsyncMinRetryDelay
+	for i := 1; i < attempts && delay < webhookAsyncMaxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > webhookAsyncMaxRetryDelay {
+		return webhookAsyncMaxRetryDelay
+	}
+	return delay
+}
+
+// processAsyncWebhook passes the stored webhook to the router. Failed webhook is rescheduled with the backoff and moved to the "webhooks_dead" collection
+// after the last attempt or the permanent failure
+func processAsyncWebhook(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return nil
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	var w asyncWebhook
+	err := db.C("webhooks_async").FindId(bson.ObjectIdHex(id)).One(&w)
+	if err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// counted before the processing, so the attempt crashed the process isn't repeated endlessly
+	w.Attempts++
+	err = db.C("webhooks_async").UpdateId(w.ID, bson.M{"$set": bson.M{"a": w.Attempts}})
+	if err != nil {
+		return err
+	}
+
+	r, err := w.Request.request()
+	if err != nil {
+		moveToDeadWebhooks(db, &w, err)
+		return nil
+	}
+
+	rec := httptest.NewRecorder()
+	webhookRouter.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), asyncWebhookKey{}, w.Attempts)))
+
+	permanent, handlerErr := asyncWebhookFailure(rec.Code, rec.Body.String())
+	if handlerErr == nil {
+		return db.C("webhooks_async").RemoveId(w.ID)
+	}
+
+	if permanent || w.Attempts > webhookAsyncRetries {
+		moveToDeadWebhooks(db, &w, handlerErr)
+		return nil
+	}
+
+	err = db.C("webhooks_async").UpdateId(w.ID, bson.M{"$set": bson.M{"e": handlerErr.Error()}})
+	if err != nil {
+		log.WithError(err).Error("processAsyncWebhook: can't save the error")
+	}
+
+	jobType := jobsPerService[w.Service][asyncWebhookJobName]
+	if jobType == nil {
+		moveToDeadWebhooks(db, &w, handlerErr)
+		return nil
+	}
+
+	delay := webhookAsyncRetryDelay(w.Attempts)
+	_, err = jobType.Schedule(0, time.Now().Add(delay), w.ID.Hex())
+	if err != nil {
+		log.WithError(err).WithField("service", w.Service).Error("processAsyncWebhook: can't reschedule the webhook")
+		moveToDeadWebhooks(db, &w, handlerErr)
+		return nil
+	}
+
+	log.WithFields(log.Fields{"service": w.Service, "attempts": w.Attempts, "delay": delay}).WithError(handlerErr).Warn("Async webhook failed, rescheduled")
+	return nil
+}
+
+// moveToDeadWebhooks moves the permanently failed webhook to the "webhooks_dead" collection
+func moveToDeadWebhooks(db *mgo.Database, w *asyncWebhook, reason error) {
+	now := time.Now()
+	w.LastError = reason.Error()
+	w.FailedAt = &now
+
+	log.WithFields(log.Fields{"service": w.Service, "url": w.Request.URL, "attempts": w.Attempts}).WithError(reason).Error("Async webhook failed permanently")
+
+	err := db.C("webhooks_dead").Insert(w)
+	if err != nil {
+		log.WithError(err).Error("moveToDeadWebhooks: can't store the webhook")
+		return
+	}
+
+	err = db.C("webhooks_async").RemoveId(w.ID)
+	if err != nil {
+		log.WithError(err).Error("moveToDeadWebhooks: can't remove the webhook from the queue")
+	}
+
+	ctx := &Context{db: db, ServiceName: w.Service}
+	ctx.StatInc(StatWebhookDead)
+}
+
+// adminDeadWebhooksReport lists the last permanently failed async webhooks
+func adminDeadWebhooksReport(c *Context, args []string) (string, error) {
+	queued, err := c.db.C("webhooks_async").Count()
+	if err != nil {
+		return "", err
+	}
+
+	var dead []asyncWebhook
+	err = c.db.C("webhooks_dead").Find(nil).Sort("-f").Limit(20).All(&dead)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("Async webhooks in the queue: %d", queued)}
+	if len(dead) == 0 {
+		return strings.Join(append(lines, "No failed async webhooks"), "\n"), nil
+	}
+
+	lines = append(lines, "Last failed async webhooks:")
+	for _, w := range dead {
+		lines = append(lines, fmt.Sprintf("%s %s %s, %d attempts: %s", w.FailedAt.UTC().Format("2006-01-02 15:04"), w.Service, w.ID.Hex(), w.Attempts, w.LastError))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
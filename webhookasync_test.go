@@ -0,0 +1,63 @@
+package integram
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_asyncWebhookFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"handled", http.StatusOK, false, false},
+		{"accepted", http.StatusAccepted, false, false},
+		{"handler failed", http.StatusInternalServerError, true, false},
+		{"flood", http.StatusTooManyRequests, true, false},
+		{"bad request", http.StatusBadRequest, true, true},
+		{"unknown hook", http.StatusNotFound, true, true},
+		{"chat deactivated", http.StatusGone, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permanent, err := asyncWebhookFailure(tt.code, "body\n")
+			if (err != nil) != tt.wantErr || permanent != tt.wantPermanent {
+				t.Errorf("asyncWebhookFailure() = %v, %v, want error %v, permanent %v", permanent, err, tt.wantErr, tt.wantPermanent)
+			}
+		})
+	}
+}
+
+func Test_asyncWebhookAttempt(t *testing.T) {
+	r, _ := http.NewRequest("POST", "https://integram.org/webhook/uGs32432novfdc", nil)
+	if got := asyncWebhookAttempt(r); got != 0 {
+		t.Errorf("asyncWebhookAttempt() of the sync webhook = %d, want 0", got)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), asyncWebhookKey{}, 3))
+	if got := asyncWebhookAttempt(r); got != 3 {
+		t.Errorf("asyncWebhookAttempt() = %d, want 3", got)
+	}
+}
+
+func Test_webhookAsyncRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, webhookAsyncMinRetryDelay},
+		{2, webhookAsyncMinRetryDelay * 2},
+		{4, webhookAsyncMinRetryDelay * 8},
+		{8, webhookAsyncMaxRetryDelay},
+		{webhookAsyncRetries, webhookAsyncMaxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := webhookAsyncRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("webhookAsyncRetryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
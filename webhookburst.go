@@ -0,0 +1,148 @@
+package integram
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const webhookBurstDefaultWindow = time.Second * 5
+
+// batch is passed to the handler before the window ends when it reaches this size
+const webhookBurstMaxBatch = 100
+
+// webhookBurst is the batch of webhooks received for the same route and key within the window
+type webhookBurst struct {
+	serviceName string
+	chat        Chat
+	user        User
+	batch       []*WebhookContext
+	timer       *time.Timer
+}
+
+// buffered bursts per webhookBurstID. Bursts are kept in memory so they are lost if the process is restarted within the window
+var webhookBurstsMutex = sync.Mutex{}
+var webhookBursts = make(map[string]*webhookBurst)
+
+// webhookBurstWindow returns the period the service's webhooks are buffered for
+func (s *Service) webhookBurstWindow() time.Duration {
+	if s.WebhookBurstWindow > 0 {
+		return s.WebhookBurstWindow
+	}
+	return webhookBurstDefaultWindow
+}
+
+// webhookBurstID returns the ID of the burst for the webhook's route (hook token and target chat) and the key returned by the service's WebhookBurstKey
+func webhookBurstID(serviceName string, route string, chatID int64, key string) string {
+	return fmt.Sprintf("%s:%s:%d:%s", serviceName, route, chatID, key)
+}
+
+// detachedWebhookContext returns the copy of the webhook which can be used after the request is answered. Request's body is read in advance
+func detachedWebhookContext(wc *WebhookContext) (*WebhookContext, error) {
+	body, err := wc.RAW()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookContext{gin: wc.gin.Copy(), body: *body, firstParse: wc.firstParse, serviceName: wc.serviceName, route: wc.route, requestID: wc.requestID, receivedAt: wc.receivedAt}, nil
+}
+
+// bufferWebhookBurst adds the webhook to the burst of the route in the current chat if the service aggregates the webhooks.
+// Returns false if the webhook must be handled with WebhookHandler as usual
+func (c *Context) bufferWebhookBurst(s *Service, route string, wc *WebhookContext) (bool, error) {
+	if s.WebhookBurstHandler == nil {
+		return false, nil
+	}
+
+	key := ""
+	if s.WebhookBurstKey != nil {
+		var err error
+		key, err = s.WebhookBurstKey(c, wc)
+		if err != nil || key == "" {
+			return false, err
+		}
+	}
+
+	dwc, err := detachedWebhookContext(wc)
+	if err != nil {
+		return false, err
+	}
+
+	id := webhookBurstID(s.Name, route, c.Chat.ID, key)
+
+	webhookBurstsMutex.Lock()
+	defer webhookBurstsMutex.Unlock()
+
+	b, exists := webhookBursts[id]
+	if !exists {
+		b = &webhookBurst{serviceName: s.Name, chat: Chat{ID: c.Chat.ID}, user: c.User}
+		b.timer = time.AfterFunc(s.webhookBurstWindow(), func() { flushWebhookBurst(id) })
+		webhookBursts[id] = b
+	}
+
+	b.batch = append(b.batch, dwc)
+	c.StatIncChat(StatWebhookBurstBuffered)
+
+	if len(b.batch) >= webhookBurstMaxBatch && b.timer.Stop() {
+		go flushWebhookBurst(id)
+	}
+
+	return true, nil
+}
+
+// flushWebhookBurst passes the buffered batch to the service's WebhookBurstHandler
+func flushWebhookBurst(id string) {
+	webhookBurstsMutex.Lock()
+	b, exists := webhookBursts[id]
+	delete(webhookBursts, id)
+	webhookBurstsMutex.Unlock()
+
+	if !exists || len(b.batch) == 0 {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("burst", id).Errorf("flushWebhookBurst panic recovered %v", r)
+		}
+	}()
+
+	s, _ := serviceByName(b.serviceName)
+	if s == nil || s.WebhookBurstHandler == nil {
+		return
+	}
+
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	// messages sent for the digest refer to the latest webhook
+	ctx := &Context{ServiceName: b.serviceName, User: b.user, Chat: b.chat, db: db, webhook: b.batch[len(b.batch)-1]}
+	ctx.User.ctx = ctx
+	ctx.Chat.ctx = ctx
+
+	// detached webhooks aren't closed by the request, so the multipart temp files are removed after the handler returns
+	closeBatch := func() {
+		for _, wc := range b.batch {
+			wc.closeMultipart()
+		}
+	}
+
+	err := ctx.runHandler("webhook", func(hc *Context) error { return s.WebhookBurstHandler(hc, b.batch) }, func(hc *Context, err error) { closeBatch() })
+	if err != ErrHandlerTimeout {
+		closeBatch()
+	}
+	if err != nil {
+		if err != ErrHandlerTimeout {
+			ctx.Log().WithError(err).WithField("webhooks", len(b.batch)).Error("WebhookBurstHandler returned error")
+			ctx.renderServiceError(err)
+		}
+		return
+	}
+
+	ctx.StatIncChat(StatWebhookBurstHandled)
+	if ctx.messageAnsweredAt != nil {
+		ctx.StatIncChat(StatWebhookProducedMessageToChat)
+	}
+}
@@ -0,0 +1,57 @@
+package integram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestService_webhookBurstWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   time.Duration
+	}{
+		{"default", 0, webhookBurstDefaultWindow},
+		{"service's", time.Second * 30, time.Second * 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{WebhookBurstWindow: tt.window}
+			if got := s.webhookBurstWindow(); got != tt.want {
+				t.Errorf("Service.webhookBurstWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_webhookBurstID(t *testing.T) {
+	a := webhookBurstID("github", "hToken", -100, "repo/master")
+	if b := webhookBurstID("github", "hToken", -200, "repo/master"); a == b {
+		t.Errorf("webhookBurstID() is the same for the different chats: %v", a)
+	}
+
+	if b := webhookBurstID("github", "hToken", -100, "repo/dev"); a == b {
+		t.Errorf("webhookBurstID() is the same for the different keys: %v", a)
+	}
+}
+
+func TestContext_bufferWebhookBurst(t *testing.T) {
+	c := &Context{ServiceName: "github", Chat: Chat{ID: -100}}
+	wc := &WebhookContext{body: []byte(`{}`)}
+
+	buffered, err := c.bufferWebhookBurst(&Service{Name: "github"}, "hToken", wc)
+	if buffered || err != nil {
+		t.Errorf("bufferWebhookBurst() without WebhookBurstHandler = %v, %v, want false", buffered, err)
+	}
+
+	s := &Service{
+		Name:                "github",
+		WebhookBurstHandler: func(ctx *Context, batch []*WebhookContext) error { return nil },
+		WebhookBurstKey:     func(ctx *Context, request *WebhookContext) (string, error) { return "", nil },
+	}
+
+	buffered, err = c.bufferWebhookBurst(s, "hToken", wc)
+	if buffered || err != nil {
+		t.Errorf("bufferWebhookBurst() with the empty key = %v, %v, want false", buffered, err)
+	}
+}
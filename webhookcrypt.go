@@ -0,0 +1,335 @@
+package integram
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// header set by the sender of the encrypted payload. The only supported version is "v1"
+const webhookEncryptionHeader = "X-Integram-Encryption"
+
+const webhookEncryptionVersion = "v1"
+
+const webhookKeyBits = 2048
+
+var errWebhookEncryptionRequired = errors.New(ErrorBadRequstPrefix + "payload must be encrypted with the chat's key, see /webhook encrypt")
+var errWebhookKeyNotFound = errors.New(ErrorBadRequstPrefix + "encryption key not found")
+var errWebhookCantDecrypt = errors.New(ErrorBadRequstPrefix + "can't decrypt the payload")
+
+// webhookKey is the chat's key pair to encrypt the payloads sent to the service's hooks. Stored in the "webhook_keys" collection
+type webhookKey struct {
+	ID        string `bson:"_id"` // fingerprint of the public key, sent by the sender as "kid"
+	ChatID    int64
+	Service   string
+	Private   []byte // PKCS#8 DER
+	CreatedAt time.Time
+}
+
+// webhookEnvelope is the encrypted payload: the random AES-256 key encrypted with RSA-OAEP(SHA-256) and the payload encrypted with AES-256-GCM using the key ID as the additional data
+type webhookEnvelope struct {
+	KeyID string `json:"kid"`
+	Key   string `json:"key"`
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+// webhookKeyID returns the fingerprint of the public key
+func webhookKeyID(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:8]), nil
+}
+
+// generateWebhookKey returns the new key pair of the chat
+func generateWebhookKey(chatID int64, serviceName string) (*webhookKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, webhookKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := webhookKeyID(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookKey{ID: id, ChatID: chatID, Service: serviceName, Private: der, CreatedAt: time.Now()}, nil
+}
+
+func (k *webhookKey) privateKey() (*rsa.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("webhook key is not RSA")
+	}
+	return priv, nil
+}
+
+// PublicKeyPEM returns the public key to share with the sender
+func (k *webhookKey) PublicKeyPEM() (string, error) {
+	priv, err := k.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// encryptWebhookPayload returns the envelope of the payload encrypted with the chat's public key. It's the reference implementation for the senders
+func encryptWebhookPayload(pub *rsa.PublicKey, payload []byte) ([]byte, error) {
+	kid, err := webhookKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := webhookGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(webhookEnvelope{
+		KeyID: kid,
+		Key:   base64.StdEncoding.EncodeToString(encryptedKey),
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+		Data:  base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, payload, []byte(kid))),
+	})
+}
+
+// decryptWebhookPayload returns the plaintext of the envelope
+func decryptWebhookPayload(priv *rsa.PrivateKey, env *webhookEnvelope) ([]byte, error) {
+	encryptedKey, err := base64.StdEncoding.DecodeString(env.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, encryptedKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := webhookGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("wrong nonce size")
+	}
+	return gcm.Open(nil, nonce, data, []byte(env.KeyID))
+}
+
+func webhookGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseWebhookEnvelope returns the envelope of the encrypted payload
+func parseWebhookEnvelope(body []byte) (*webhookEnvelope, error) {
+	env := &webhookEnvelope{}
+	if err := json.Unmarshal(body, env); err != nil {
+		return nil, err
+	}
+
+	if env.KeyID == "" || env.Key == "" || env.Nonce == "" || env.Data == "" {
+		return nil, errors.New("kid, key, nonce and data are required")
+	}
+	return env, nil
+}
+
+// decryptWebhook replaces the encrypted body of the webhook with the plaintext, so the handler's RAW and JSON never see the envelope.
+// Payload must be encrypted with the key of one of the hook's chats. Plaintext payloads are rejected if any of the chats has the key
+func decryptWebhook(db *mgo.Database, s *Service, wc *WebhookContext, chatIDs []int64) error {
+	version := wc.gin.Request.Header.Get(webhookEncryptionHeader)
+	if version == "" {
+		n, err := db.C("webhook_keys").Find(bson.M{"chatid": bson.M{"$in": chatIDs}, "service": s.Name}).Count()
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return errWebhookEncryptionRequired
+		}
+		return nil
+	}
+
+	if version != webhookEncryptionVersion {
+		return fmt.Errorf("%sunsupported %s: %q", ErrorBadRequstPrefix, webhookEncryptionHeader, version)
+	}
+
+	body, err := wc.RAW()
+	if err != nil {
+		return err
+	}
+
+	env, err := parseWebhookEnvelope(*body)
+	if err != nil {
+		return fmt.Errorf("%swrong encrypted payload: %s", ErrorBadRequstPrefix, err.Error())
+	}
+
+	key := webhookKey{}
+	err = db.C("webhook_keys").Find(bson.M{"_id": env.KeyID, "chatid": bson.M{"$in": chatIDs}, "service": s.Name}).One(&key)
+	if err == mgo.ErrNotFound {
+		return errWebhookKeyNotFound
+	} else if err != nil {
+		return err
+	}
+
+	priv, err := key.privateKey()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := decryptWebhookPayload(priv, env)
+	if err != nil {
+		return errWebhookCantDecrypt
+	}
+
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	wc.body = plaintext
+	return nil
+}
+
+// webhookKey returns the chat's key for the current service. The new key is generated if the chat doesn't have it and create is true
+func (c *Context) webhookKey(create bool) (*webhookKey, error) {
+	key := &webhookKey{}
+	err := c.db.C("webhook_keys").Find(bson.M{"chatid": c.Chat.ID, "service": c.ServiceName}).One(key)
+	if err == nil {
+		return key, nil
+	} else if err != mgo.ErrNotFound || !create {
+		return nil, err
+	}
+
+	key, err = generateWebhookKey(c.Chat.ID, c.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.db.C("webhook_keys").Insert(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// handleWebhookEncryptCommand process '/webhook encrypt [off]' sent by the chat admin. Returns true if message was handled
+func (c *Context) handleWebhookEncryptCommand() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	if s := c.Service(); s == nil || !s.WebhookEncryption {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	args := strings.Fields(param)
+	if cmd != coreCommand("webhook") || len(args) == 0 || args[0] != "encrypt" {
+		return false
+	}
+
+	text, err := c.webhookEncryptCommand(len(args) > 1 && args[1] == "off")
+	if err != nil {
+		c.Log().WithError(err).Error("handleWebhookEncryptCommand: can't process the command")
+		text = "Can't change the encryption. Please try again later"
+	}
+
+	err = c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(text).EnableHTML().Send()
+	if err != nil {
+		c.Log().WithError(err).Error("handleWebhookEncryptCommand: can't send the reply")
+	}
+
+	return true
+}
+
+func (c *Context) webhookEncryptCommand(off bool) (string, error) {
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		c.Log().WithError(err).Error("webhookEncryptCommand: can't check chat admin")
+		return "Can't check your permissions in this chat. Please try again later", nil
+	} else if !isAdmin {
+		return "Only chat admins can change the webhook encryption", nil
+	}
+
+	if off {
+		_, err := c.db.C("webhook_keys").RemoveAll(bson.M{"chatid": c.Chat.ID, "service": c.ServiceName})
+		if err != nil {
+			return "", err
+		}
+		return "Encryption disabled. Plain payloads are accepted again", nil
+	}
+
+	key, err := c.webhookKey(true)
+	if err != nil {
+		return "", err
+	}
+
+	pub, err := key.PublicKeyPEM()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Encrypt the payloads with this key and send them with the %s: %s header. Plain payloads are rejected now.\n"+
+		"Envelope: {\"kid\": %q, \"key\": base64(RSA-OAEP-SHA256(aes_key)), \"nonce\": base64(12 bytes), \"data\": base64(AES-256-GCM(payload, aad=kid))}\n%s\n"+
+		"Send /%s encrypt off to accept plain payloads again",
+		webhookEncryptionHeader, webhookEncryptionVersion, key.ID, HTMLRichText{}.Pre(pub), coreCommand("webhook")), nil
+}
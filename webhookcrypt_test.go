@@ -0,0 +1,95 @@
+package integram
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_encryptWebhookPayload(t *testing.T) {
+	key, err := generateWebhookKey(-100, "webhook")
+	if err != nil {
+		t.Fatalf("generateWebhookKey() error = %v", err)
+	}
+
+	priv, err := key.privateKey()
+	if err != nil {
+		t.Fatalf("webhookKey.privateKey() error = %v", err)
+	}
+
+	other, err := generateWebhookKey(-200, "webhook")
+	if err != nil {
+		t.Fatalf("generateWebhookKey() error = %v", err)
+	}
+
+	otherPriv, err := other.privateKey()
+	if err != nil {
+		t.Fatalf("webhookKey.privateKey() error = %v", err)
+	}
+
+	payload := []byte(`{"text":"secret"}`)
+	body, err := encryptWebhookPayload(&priv.PublicKey, payload)
+	if err != nil {
+		t.Fatalf("encryptWebhookPayload() error = %v", err)
+	}
+
+	if bytes.Contains(body, []byte("secret")) {
+		t.Errorf("encryptWebhookPayload() = %s, contains the plaintext", body)
+	}
+
+	env, err := parseWebhookEnvelope(body)
+	if err != nil {
+		t.Fatalf("parseWebhookEnvelope() error = %v", err)
+	}
+
+	if env.KeyID != key.ID {
+		t.Errorf("envelope's kid = %q, want %q", env.KeyID, key.ID)
+	}
+
+	got, err := decryptWebhookPayload(priv, env)
+	if err != nil {
+		t.Fatalf("decryptWebhookPayload() error = %v", err)
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Errorf("decryptWebhookPayload() = %s, want %s", got, payload)
+	}
+
+	if _, err := decryptWebhookPayload(otherPriv, env); err == nil {
+		t.Errorf("decryptWebhookPayload() with the other chat's key error = nil, want error")
+	}
+
+	tampered := *env
+	tampered.KeyID = other.ID
+	if _, err := decryptWebhookPayload(priv, &tampered); err == nil {
+		t.Errorf("decryptWebhookPayload() with the replaced kid error = nil, want error")
+	}
+}
+
+func Test_parseWebhookEnvelope(t *testing.T) {
+	full := webhookEnvelope{KeyID: "kid", Key: "a2V5", Nonce: "bm9uY2U=", Data: "ZGF0YQ=="}
+	fullJSON, _ := json.Marshal(full)
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"full", string(fullJSON), false},
+		{"plain payload", `{"text":"hello"}`, true},
+		{"no data", `{"kid":"kid","key":"a2V5","nonce":"bm9uY2U="}`, true},
+		{"not JSON", `payload=%7B%7D`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWebhookEnvelope([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWebhookEnvelope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && *got != full {
+				t.Errorf("parseWebhookEnvelope() = %+v, want %+v", got, full)
+			}
+		})
+	}
+}
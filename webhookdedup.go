@@ -0,0 +1,131 @@
+package integram
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// processed idempotency keys are remembered for this period if the service doesn't set WebhookIdempotencyTTL
+const webhookIdempotencyDefaultTTL = time.Hour * 24
+
+// webhookDedupWindow returns the service's window to detect the duplicate payloads or 0 if disabled
+func webhookDedupWindow(s *Service) time.Duration {
+	window := Config.WebhookDedupWindow
+	if s != nil && s.WebhookDedupWindow != 0 {
+		window = s.WebhookDedupWindow
+	}
+
+	if window < 0 {
+		return 0
+	}
+	return window
+}
+
+// webhookDedupKey returns the hash of the payload received on the hook. Headers are not the part of the key because upstreams set the new delivery ID on resend
+func webhookDedupKey(serviceName string, token string, method string, body []byte) string {
+	h := sha1.New()
+	h.Write([]byte(serviceName + "\n" + token + "\n" + method + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isDuplicateWebhook returns true if the identical payload was received on the hook within the service's window.
+// Otherwise the payload is remembered in the "webhooks_dedup" collection and its key returned to forget it if the webhook is rejected
+func isDuplicateWebhook(db *mgo.Database, s *Service, token string, r *http.Request) (duplicate bool, key string) {
+	// the payload was remembered on the first attempt of the async webhook
+	if asyncWebhookAttempt(r) > 1 {
+		return false, ""
+	}
+
+	window := webhookDedupWindow(s)
+	if window == 0 || r.Method != "POST" || r.Body == nil {
+		return false, ""
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false, ""
+	}
+
+	key = webhookDedupKey(s.Name, token, r.Method, body)
+	if rememberWebhookKey(db, key, window) {
+		return true, ""
+	}
+	return false, key
+}
+
+// WebhookDeliveryIDKey returns the upstream's delivery ID, e.g. X-GitHub-Delivery. Set it as Service.WebhookIdempotencyKey to ignore the redelivered webhooks
+func WebhookDeliveryIDKey(wc *WebhookContext) string {
+	return wc.Delivery().ID
+}
+
+// webhookIdempotencyTTL returns the period to remember the service's processed idempotency keys
+func webhookIdempotencyTTL(s *Service) time.Duration {
+	if s.WebhookIdempotencyTTL > 0 {
+		return s.WebhookIdempotencyTTL
+	}
+	return webhookIdempotencyDefaultTTL
+}
+
+// webhookIdempotencyDedupKey returns the hash of the idempotency key received on the hook
+func webhookIdempotencyDedupKey(serviceName string, token string, idempotencyKey string) string {
+	h := sha1.New()
+	h.Write([]byte("idempotency\n" + serviceName + "\n" + token + "\n" + idempotencyKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isRetriedWebhook returns true if the webhook with the same service's idempotency key was already received on the hook, see Service.WebhookIdempotencyKey.
+// Otherwise the key is remembered in the "webhooks_dedup" collection and returned to forget it if the webhook is rejected
+func isRetriedWebhook(db *mgo.Database, s *Service, token string, wc *WebhookContext) (retried bool, key string) {
+	if s == nil || s.WebhookIdempotencyKey == nil {
+		return false, ""
+	}
+
+	if asyncWebhookAttempt(wc.gin.Request) > 1 {
+		return false, ""
+	}
+
+	idempotencyKey := s.WebhookIdempotencyKey(wc)
+	if idempotencyKey == "" {
+		return false, ""
+	}
+
+	key = webhookIdempotencyDedupKey(s.Name, token, idempotencyKey)
+	if rememberWebhookKey(db, key, webhookIdempotencyTTL(s)) {
+		return true, ""
+	}
+	return false, key
+}
+
+// rememberWebhookKey stores the key for the period. Returns true if the key is already stored and not expired
+func rememberWebhookKey(db *mgo.Database, key string, ttl time.Duration) bool {
+	now := time.Now()
+
+	// expired key is updated, the existing one fails to match and the insert fails on the same _id
+	_, err := db.C("webhooks_dedup").Upsert(bson.M{"_id": key, "exp": bson.M{"$lt": now}}, bson.M{"$set": bson.M{"exp": now.Add(ttl)}})
+	return mgo.IsDup(err)
+}
+
+// forgetWebhookPayload removes the payload and idempotency keys, so upstream's retry of the failed webhook is processed
+func forgetWebhookPayload(db *mgo.Database, keys ...string) error {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		err := db.C("webhooks_dedup").RemoveId(key)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
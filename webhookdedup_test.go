@@ -0,0 +1,111 @@
+package integram
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func Test_isDuplicateWebhook(t *testing.T) {
+	s := &Service{Name: "servicewithbottoken", WebhookDedupWindow: time.Minute}
+	defer db.C("webhooks_dedup").RemoveAll(bson.M{})
+
+	post := func(body string) *http.Request {
+		r, _ := http.NewRequest("POST", "https://example.com/servicewithbottoken/c123", strings.NewReader(body))
+		return r
+	}
+
+	first := post(`{"event":"push"}`)
+	duplicate, key := isDuplicateWebhook(db, s, "c123", first)
+	if duplicate || key == "" {
+		t.Fatalf("isDuplicateWebhook() of the first payload = %v, %q, want false and the key", duplicate, key)
+	}
+
+	if body, _ := ioutil.ReadAll(first.Body); string(body) != `{"event":"push"}` {
+		t.Errorf("isDuplicateWebhook() doesn't restore the body, got %q", body)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  bool
+	}{
+		{"resent", "c123", `{"event":"push"}`, true},
+		{"other payload", "c123", `{"event":"tag"}`, false},
+		{"other hook", "c456", `{"event":"push"}`, false},
+	}
+	for _, tt := range tests {
+		if got, _ := isDuplicateWebhook(db, s, tt.token, post(tt.body)); got != tt.want {
+			t.Errorf("%q. isDuplicateWebhook() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	forgetWebhookPayload(db, key)
+	if got, _ := isDuplicateWebhook(db, s, "c123", post(`{"event":"push"}`)); got {
+		t.Errorf("isDuplicateWebhook() after forgetWebhookPayload() = %v, want false", got)
+	}
+
+	db.C("webhooks_dedup").UpdateId(key, bson.M{"$set": bson.M{"exp": time.Now().Add(-time.Second)}})
+	if got, _ := isDuplicateWebhook(db, s, "c123", post(`{"event":"push"}`)); got {
+		t.Errorf("isDuplicateWebhook() after the window expired = %v, want false", got)
+	}
+
+	s.WebhookDedupWindow = -1
+	if got, _ := isDuplicateWebhook(db, s, "c123", post(`{"event":"push"}`)); got {
+		t.Errorf("isDuplicateWebhook() with dedup disabled = %v, want false", got)
+	}
+}
+
+func Test_isRetriedWebhook(t *testing.T) {
+	s := &Service{Name: "servicewithbottoken", WebhookIdempotencyKey: WebhookDeliveryIDKey}
+	defer db.C("webhooks_dedup").RemoveAll(bson.M{})
+
+	delivery := func(id string) *WebhookContext {
+		r, _ := http.NewRequest("POST", "https://example.com/servicewithbottoken/c123", strings.NewReader(`{"event":"push"}`))
+		if id != "" {
+			r.Header.Set("X-GitHub-Delivery", id)
+		}
+		gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+		gc.Request = r
+		return &WebhookContext{gin: gc}
+	}
+
+	retried, key := isRetriedWebhook(db, s, "c123", delivery("d1"))
+	if retried || key == "" {
+		t.Fatalf("isRetriedWebhook() of the first delivery = %v, %q, want false and the key", retried, key)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		id    string
+		want  bool
+	}{
+		{"redelivered", "c123", "d1", true},
+		{"other delivery", "c123", "d2", false},
+		{"other hook", "c456", "d1", false},
+		{"no delivery ID", "c123", "", false},
+	}
+	for _, tt := range tests {
+		if got, _ := isRetriedWebhook(db, s, tt.token, delivery(tt.id)); got != tt.want {
+			t.Errorf("%q. isRetriedWebhook() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	forgetWebhookPayload(db, "", key)
+	if got, _ := isRetriedWebhook(db, s, "c123", delivery("d1")); got {
+		t.Errorf("isRetriedWebhook() after forgetWebhookPayload() = %v, want false", got)
+	}
+
+	s.WebhookIdempotencyKey = nil
+	if got, _ := isRetriedWebhook(db, s, "c123", delivery("d1")); got {
+		t.Errorf("isRetriedWebhook() without the service's idempotency key = %v, want false", got)
+	}
+}
@@ -0,0 +1,54 @@
+package integram
+
+import (
+	"time"
+)
+
+// WebhookEvent is the envelope of the received webhook. Dedup, tracing, burst digests and replays refer to the webhook with it
+type WebhookEvent struct {
+	Service    string
+	Route      string    // token from the webhook URL the webhook was received on, for the services with TokenHandler too
+	DeliveryID string    // upstream's delivery ID kept on the redelivery, see WebhookDelivery. Empty if upstream doesn't provide it
+	ReceivedAt time.Time // original time for the webhooks spilled to disk and replayed later
+	RequestID  string    // random ID of the request, also logged as "request"
+	Payload    []byte    // request's body, decrypted for the services with WebhookEncryption
+}
+
+// MessageProvenance is the webhook the message was sent on. Stored with the message to trace it back to the upstream's delivery
+type MessageProvenance struct {
+	Service    string    `bson:"s"`
+	DeliveryID string    `bson:"d,omitempty"`
+	RequestID  string    `bson:"r"`
+	ReceivedAt time.Time `bson:"t"`
+}
+
+// Event returns the envelope of the webhook. Payload is nil if the body can't be read
+func (wc *WebhookContext) Event() WebhookEvent {
+	e := WebhookEvent{
+		Service:    wc.serviceName,
+		Route:      wc.route,
+		DeliveryID: wc.Delivery().ID,
+		ReceivedAt: wc.receivedAt,
+		RequestID:  wc.requestID,
+	}
+
+	if body, err := wc.RAW(); err == nil {
+		e.Payload = *body
+	}
+	return e
+}
+
+// provenance returns the message's reference to the webhook. Unlike Event it doesn't read the body
+func (wc *WebhookContext) provenance() *MessageProvenance {
+	return &MessageProvenance{Service: wc.serviceName, DeliveryID: wc.Delivery().ID, RequestID: wc.requestID, ReceivedAt: wc.receivedAt}
+}
+
+// WebhookEvent returns the envelope of the webhook being handled or nil if the context wasn't created for the webhook
+func (c *Context) WebhookEvent() *WebhookEvent {
+	if c.webhook == nil {
+		return nil
+	}
+
+	e := c.webhook.Event()
+	return &e
+}
@@ -0,0 +1,53 @@
+package integram
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookContext_Event(t *testing.T) {
+	receivedAt := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		deliveryID string
+		body       string
+	}{
+		{"with delivery ID", "72d3162e-cc78-11e3-81ab-4c9367dc0958", `{"action":"opened"}`},
+		{"without delivery ID", "", `{"action":"closed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "https://integram.org/webhook/uGs32432novfdc", bytes.NewReader([]byte(tt.body)))
+			if tt.deliveryID != "" {
+				r.Header.Set("X-GitHub-Delivery", tt.deliveryID)
+			}
+			wc := &WebhookContext{gin: &gin.Context{Request: r}, serviceName: "github", route: "uGs32432novfdc", requestID: "abcdefghij", receivedAt: receivedAt}
+
+			e := wc.Event()
+			if e.Service != "github" || e.Route != "uGs32432novfdc" || e.RequestID != "abcdefghij" || !e.ReceivedAt.Equal(receivedAt) {
+				t.Errorf("WebhookContext.Event() = %+v", e)
+			}
+			if e.DeliveryID != tt.deliveryID {
+				t.Errorf("WebhookContext.Event() DeliveryID = %q, want %q", e.DeliveryID, tt.deliveryID)
+			}
+			if string(e.Payload) != tt.body {
+				t.Errorf("WebhookContext.Event() Payload = %s, want %s", e.Payload, tt.body)
+			}
+
+			// the body is still available to the handler
+			if body, err := wc.RAW(); err != nil || string(*body) != tt.body {
+				t.Errorf("WebhookContext.RAW() after Event() = %v, %v", body, err)
+			}
+
+			p := wc.provenance()
+			if p.Service != e.Service || p.DeliveryID != e.DeliveryID || p.RequestID != e.RequestID || !p.ReceivedAt.Equal(e.ReceivedAt) {
+				t.Errorf("WebhookContext.provenance() = %+v, want to match %+v", p, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// interval to look for the hooks that stopped receiving the deliveries
+const webhookHealthCheckInterval = time.Hour
+
+const (
+	webhookAlertReasonFailing = "failing"
+	webhookAlertReasonStopped = "stopped"
+)
+
+// webhookHealth is stored in the "webhooks_health" collection while the hook's deliveries are failing
+type webhookHealth struct {
+	Token        string     `bson:"_id"`
+	Service      string     `bson:"s"`
+	Failures     int        `bson:"f"` // number of failed deliveries in a row
+	LastDelivery time.Time  `bson:"d"`
+	PausedAt     *time.Time `bson:"p,omitempty"`
+	AlertedAt    *time.Time `bson:"a,omitempty"`
+}
+
+// updateWebhookHealth counts the hook's failed deliveries in a row and pauses the hook when they reach the limit
+func updateWebhookHealth(db *mgo.Database, token string, service string, handlerErr error) {
+	if handlerErr == nil {
+		err := db.C("webhooks_health").Remove(bson.M{"_id": token, "p": bson.M{"$exists": false}})
+		if err != nil && err != mgo.ErrNotFound {
+			log.WithError(err).WithField("token", token).Error("Can't reset the webhook health")
+		}
+		return
+	}
+
+	var h webhookHealth
+	_, err := db.C("webhooks_health").FindId(token).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"f": 1}, "$set": bson.M{"s": service, "d": time.Now()}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &h)
+
+	if err != nil {
+		log.WithError(err).WithField("token", token).Error("Can't update the webhook health")
+		return
+	}
+
+	if Config.WebhookPauseAfterFailures <= 0 || h.Failures < Config.WebhookPauseAfterFailures || h.PausedAt != nil {
+		return
+	}
+
+	now := time.Now()
+	err = db.C("webhooks_health").Update(bson.M{"_id": token, "p": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"p": now, "a": now}})
+	if err == mgo.ErrNotFound {
+		// already paused by the concurrent delivery
+		return
+	} else if err != nil {
+		log.WithError(err).WithField("token", token).Error("Can't pause the webhook")
+		return
+	}
+
+	log.WithField("token", token).Warnf("Webhook paused after %d failed deliveries in a row", h.Failures)
+	alertWebhookAdmins(db, h, webhookAlertReasonFailing)
+}
+
+// isWebhookPaused returns true if the hook's deliveries must be skipped until the chat admin reconnects it
+func isWebhookPaused(db *mgo.Database, token string) bool {
+	n, err := db.C("webhooks_health").Find(bson.M{"_id": token, "p": bson.M{"$exists": true}}).Count()
+	if err != nil {
+		log.WithError(err).WithField("token", token).Error("Can't check if the webhook is paused")
+		return false
+	}
+
+	return n > 0
+}
+
+// resumeWebhook removes the hook's pause and resets the failures counter
+func resumeWebhook(db *mgo.Database, token string) error {
+	err := db.C("webhooks_health").RemoveId(token)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// webhooksHealthChecker periodically looks for the hooks that stopped receiving deliveries after the failures. It is likely upstream disabled them
+func webhooksHealthChecker() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("webhooksHealthChecker panic recovered %v", r)
+			webhooksHealthChecker()
+		}
+	}()
+
+	if Config.IsMainInstance() || Config.WebhookStoppedAfterFailures <= 0 {
+		return
+	}
+
+	s := mongoSession.Clone()
+	defer s.Close()
+	db := s.DB(mongo.Database)
+
+	for {
+		time.Sleep(webhookHealthCheckInterval)
+
+		var serviceNames []string
+		serviceMapMutex.RLock()
+		for name := range services {
+			serviceNames = append(serviceNames, name)
+		}
+		serviceMapMutex.RUnlock()
+
+		var stopped []webhookHealth
+		err := db.C("webhooks_health").Find(bson.M{
+			"s": bson.M{"$in": serviceNames},
+			"f": bson.M{"$gte": Config.WebhookStoppedAfterFailures},
+			"d": bson.M{"$lt": time.Now().Add(-Config.WebhookStoppedPeriod)},
+			"a": bson.M{"$exists": false},
+		}).All(&stopped)
+
+		if err != nil {
+			log.WithError(err).Error("webhooksHealthChecker: can't find the stopped webhooks")
+			continue
+		}
+
+		for _, h := range stopped {
+			err := db.C("webhooks_health").Update(bson.M{"_id": h.Token, "a": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"a": time.Now()}})
+			if err != nil {
+				continue
+			}
+
+			log.WithField("token", h.Token).Warnf("Webhook stopped receiving deliveries after %d failures", h.Failures)
+			alertWebhookAdmins(db, h, webhookAlertReasonStopped)
+		}
+	}
+}
+
+// webhookAlertChats returns the chats that receive the hook's deliveries
+func webhookAlertChats(hooks []serviceHook, token string, ownerChatID int64) []int64 {
+	for _, hook := range hooks {
+		if hook.Token != token {
+			continue
+		}
+
+		if len(hook.Chats) == 0 {
+			return []int64{ownerChatID}
+		}
+
+		return hook.Chats
+	}
+
+	return nil
+}
+
+func webhookAlertText(reason string, serviceName string, canReconnect bool) string {
+	var text string
+	if reason == webhookAlertReasonStopped {
+		text = fmt.Sprintf("%s stopped sending notifications to this chat after the failed deliveries. Most likely it has disabled the webhook", serviceName)
+	} else {
+		text = fmt.Sprintf("%s notifications for this chat are paused because the last deliveries failed", serviceName)
+	}
+
+	if canReconnect {
+		return text + ". Chat admin can reconnect it with the button below"
+	}
+	return text + ". Please set up the webhook in " + serviceName + " again"
+}
+
+// alertWebhookAdmins informs the hook's chats and suggests the "reconnect" button if the service supports it
+func alertWebhookAdmins(db *mgo.Database, h webhookHealth, reason string) {
+	s, err := serviceByName(h.Service)
+	if err != nil {
+		log.WithError(err).WithField("token", h.Token).Error("alertWebhookAdmins: can't find the service")
+		return
+	}
+
+	var chatIDs []int64
+	if h.Token[0:1] == "u" {
+		var user userData
+		err = db.C("users").Find(bson.M{"hooks.token": h.Token}).One(&user)
+		chatIDs = webhookAlertChats(user.Hooks, h.Token, user.ID)
+	} else {
+		var chat chatData
+		err = db.C("chats").Find(bson.M{"hooks.token": h.Token}).One(&chat)
+		chatIDs = webhookAlertChats(chat.Hooks, h.Token, chat.ID)
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("token", h.Token).Error("alertWebhookAdmins: can't find the hook owner")
+		return
+	}
+
+	text := webhookAlertText(reason, s.NameToPrint, s.WebhookReconnectHandler != nil)
+
+	for _, chatID := range chatIDs {
+		ctx := &Context{db: db, ServiceName: s.Name}
+		ctx.Chat = Chat{ID: chatID, ctx: ctx}
+
+		msg := ctx.NewMessage().SetText(text).SetParseMode("").applyBrandingFooter()
+		if s.WebhookReconnectHandler != nil {
+			buttons := InlineButtons{}
+			buttons.Append("reconnect", "🔄 Reconnect")
+			msg.SetInlineKeyboard(buttons.Markup(1, "")).SetCallbackAction(webhookReconnectAction, h.Token)
+		}
+
+		err := msg.Send()
+		if err != nil {
+			ctx.Log().WithError(err).WithField("token", h.Token).Error("alertWebhookAdmins: can't send the alert")
+		}
+	}
+}
+
+// webhookReconnectAction registers the webhook in the upstream again on behalf of the chat admin who pressed the button and resumes it
+func webhookReconnectAction(c *Context, token string) error {
+	if isAdmin, err := c.IsChatAdmin(); err != nil {
+		return err
+	} else if !isAdmin {
+		return NewServiceError("Only chat admins can reconnect the webhook", nil).SetSeverity(ServiceErrorSeverityWarning)
+	}
+
+	s := c.Service()
+	if s.WebhookReconnectHandler == nil {
+		return errors.New("WebhookReconnectHandler is not set for the service")
+	}
+
+	err := s.WebhookReconnectHandler(c, Config.BaseURL+"/"+s.Name+"/"+token)
+	if IsOAuthUnauthorized(err) {
+		return NewServiceError(fmt.Sprintf("Please authorize in %s to reconnect the webhook", s.NameToPrint), err).AddActions(ReauthAction())
+	} else if err != nil {
+		return NewServiceError(fmt.Sprintf("%s refused to reconnect the webhook", s.NameToPrint), err)
+	}
+
+	err = resumeWebhook(c.Db(), token)
+	if err != nil {
+		return err
+	}
+
+	c.AnswerCallbackQuery("Webhook reconnected", false)
+	return c.EditPressedMessageText(fmt.Sprintf("✅ %s webhook was reconnected by %s", s.NameToPrint, c.User.Mention()))
+}
@@ -0,0 +1,28 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_webhookAlertChats(t *testing.T) {
+	hooks := []serviceHook{
+		{Token: "u1", Services: []string{"trello"}, Chats: []int64{-100, 200}},
+		{Token: "u2", Services: []string{"gitlab"}},
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  []int64
+	}{
+		{"hook chats", "u1", []int64{-100, 200}},
+		{"owner chat", "u2", []int64{1}},
+		{"unknown token", "u3", nil},
+	}
+	for _, tt := range tests {
+		if got := webhookAlertChats(hooks, tt.token, 1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. webhookAlertChats() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
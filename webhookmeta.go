@@ -0,0 +1,248 @@
+package integram
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// headers with the upstream's delivery ID kept on the redelivery, in the order of preference
+var webhookDeliveryIDHeaders = []string{"Idempotency-Key", "X-GitHub-Delivery", "X-Gitlab-Event-UUID", "X-Request-UUID", "X-Shopify-Webhook-Id"}
+
+// headers with the number of the upstream's previous delivery attempts
+var webhookRetriesHeaders = []string{"X-Slack-Retry-Num", "X-Retry-Count"}
+
+// TLS versions and cipher suites by their IANA numbers. crypto/tls of the older Go versions lacks TLS 1.3 and the names
+var webhookTLSVersions = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+var webhookTLSCipherSuites = map[uint16]string{
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x000a: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x003c: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0xc007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xc012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xc023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xc027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+var trustedProxiesOnce sync.Once
+var trustedProxies []*net.IPNet
+
+// WebhookTLS describes the TLS connection the webhook was received over
+type WebhookTLS struct {
+	Version     string // e.g. "TLS 1.3". Empty if TLS was terminated by the trusted proxy
+	CipherSuite string
+	ServerName  string // requested with SNI
+	Proxy       bool   // TLS was terminated by the trusted proxy, see INTEGRAM_WEBHOOK_TRUSTED_PROXIES
+}
+
+// WebhookDelivery describes the upstream's delivery attempt of the webhook
+type WebhookDelivery struct {
+	ID       string // upstream's delivery ID kept on the redelivery, e.g. X-GitHub-Delivery. Empty if upstream doesn't provide it
+	Retries  int    // number of the previous attempts reported by upstream, e.g. X-Slack-Retry-Num
+	Replayed bool   // webhook was spilled to disk under the load and processed later
+}
+
+// IsRetry returns true if upstream reported the previous attempts
+func (d WebhookDelivery) IsRetry() bool {
+	return d.Retries > 0
+}
+
+// ClientIP returns the sender's IP. X-Forwarded-For and X-Real-IP are used only if the request came from the trusted proxy, see INTEGRAM_WEBHOOK_TRUSTED_PROXIES
+func (wc *WebhookContext) ClientIP() string {
+	return webhookClientIP(wc.gin.Request, webhookTrustedProxies())
+}
+
+// TLS returns the TLS connection details or nil if the webhook was received over the plain HTTP
+func (wc *WebhookContext) TLS() *WebhookTLS {
+	return webhookTLS(wc.gin.Request, webhookTrustedProxies())
+}
+
+// ContentLength returns the size of the request's body in bytes. -1 if the size is unknown until the body is read
+func (wc *WebhookContext) ContentLength() int64 {
+	if wc.body != nil {
+		return int64(len(wc.body))
+	}
+	return wc.gin.Request.ContentLength
+}
+
+// ReceivedAt returns the time the webhook was received. It's the original time for the webhooks spilled to disk under the load
+func (wc *WebhookContext) ReceivedAt() time.Time {
+	return wc.receivedAt
+}
+
+// Delivery returns the upstream's delivery ID and retry counters
+func (wc *WebhookContext) Delivery() WebhookDelivery {
+	return webhookDeliveryFrom(wc.gin.Request)
+}
+
+// webhookReceivedAt returns the time the spilled webhook was received at or now for the new one
+func webhookReceivedAt(r *http.Request) time.Time {
+	if spilledAt, ok := r.Context().Value(replayedWebhookKey{}).(time.Time); ok {
+		return spilledAt
+	}
+	return time.Now()
+}
+
+// webhookTrustedProxies returns the parsed INTEGRAM_WEBHOOK_TRUSTED_PROXIES
+func webhookTrustedProxies() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		trustedProxies = parseTrustedProxies(Config.WebhookTrustedProxies)
+	})
+	return trustedProxies
+}
+
+// parseTrustedProxies parses the list of IPs and CIDRs. Invalid items are skipped
+func parseTrustedProxies(list []string) []*net.IPNet {
+	var res []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				log.WithField("proxy", item).Error("INTEGRAM_WEBHOOK_TRUSTED_PROXIES: wrong IP")
+				continue
+			}
+
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			log.WithField("proxy", item).Error("INTEGRAM_WEBHOOK_TRUSTED_PROXIES: wrong CIDR")
+			continue
+		}
+		res = append(res, ipNet)
+	}
+	return res
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP of the request's peer
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
+// webhookClientIP returns the sender's IP. X-Forwarded-For is walked from the right to the first address which isn't the trusted proxy
+func webhookClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(net.ParseIP(remote), trusted) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// the rest of the header can't be trusted
+			break
+		}
+
+		if i == 0 || !isTrustedProxy(ip, trusted) {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remote
+}
+
+// webhookTLS returns the TLS details of the connection or the TLS terminated by the trusted proxy
+func webhookTLS(r *http.Request, trusted []*net.IPNet) *WebhookTLS {
+	if r.TLS != nil {
+		return &WebhookTLS{
+			Version:     tlsName(webhookTLSVersions, r.TLS.Version),
+			CipherSuite: tlsName(webhookTLSCipherSuites, r.TLS.CipherSuite),
+			ServerName:  r.TLS.ServerName,
+		}
+	}
+
+	if isTrustedProxy(net.ParseIP(remoteIP(r)), trusted) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return &WebhookTLS{Proxy: true}
+	}
+	return nil
+}
+
+// tlsName returns the name of the TLS version or cipher suite or its hex number if unknown, e.g. "0x0a0a"
+func tlsName(names map[uint16]string, id uint16) string {
+	if name, exists := names[id]; exists {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", id)
+}
+
+// webhookDeliveryFrom returns the delivery ID and the retry counters from the upstream's headers
+func webhookDeliveryFrom(r *http.Request) WebhookDelivery {
+	d := WebhookDelivery{Replayed: r.Context().Value(replayedWebhookKey{}) != nil}
+
+	for _, header := range webhookDeliveryIDHeaders {
+		if id := strings.TrimSpace(r.Header.Get(header)); id != "" {
+			d.ID = id
+			break
+		}
+	}
+
+	for _, header := range webhookRetriesHeaders {
+		if n, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(header))); err == nil && n > 0 {
+			d.Retries = n
+			break
+		}
+	}
+	return d
+}
@@ -0,0 +1,129 @@
+package integram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_webhookClientIP(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "bad"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		{"direct", "1.2.3.4:5555", nil, "", "1.2.3.4"},
+		{"untrusted peer's header ignored", "1.2.3.4:5555", []string{"5.6.7.8"}, "5.6.7.8", "1.2.3.4"},
+		{"trusted proxy", "10.1.1.1:5555", []string{"5.6.7.8"}, "", "5.6.7.8"},
+		{"proxies chain", "10.1.1.1:5555", []string{"9.9.9.9, 5.6.7.8, 192.168.1.1"}, "", "5.6.7.8"},
+		{"spoofed leftmost ignored", "10.1.1.1:5555", []string{"9.9.9.9", "5.6.7.8"}, "", "5.6.7.8"},
+		{"all trusted", "10.1.1.1:5555", []string{"10.2.2.2, 10.3.3.3"}, "", "10.2.2.2"},
+		{"malformed hop", "10.1.1.1:5555", []string{"5.6.7.8, garbage"}, "", "10.1.1.1"},
+		{"real IP", "10.1.1.1:5555", nil, "5.6.7.8", "5.6.7.8"},
+		{"IPv6", "[2001:db8::1]:443", nil, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/webhook/token", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := webhookClientIP(r, trusted); got != tt.want {
+				t.Errorf("webhookClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_webhookTLS(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.0/8"})
+
+	r := httptest.NewRequest("POST", "https://example.com/webhook/token", nil)
+	if got := webhookTLS(r, trusted); got == nil || got.Proxy || got.Version == "" || got.ServerName != "example.com" {
+		t.Errorf("webhookTLS() = %+v, want the connection's details", got)
+	}
+
+	r = httptest.NewRequest("POST", "/webhook/token", nil)
+	r.RemoteAddr = "10.1.1.1:5555"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got := webhookTLS(r, trusted); got == nil || !got.Proxy {
+		t.Errorf("webhookTLS() = %+v, want terminated by the proxy", got)
+	}
+
+	r.RemoteAddr = "1.2.3.4:5555"
+	if got := webhookTLS(r, trusted); got != nil {
+		t.Errorf("webhookTLS() = %+v, want nil for the untrusted peer", got)
+	}
+}
+
+func Test_tlsName(t *testing.T) {
+	tests := []struct {
+		names map[uint16]string
+		id    uint16
+		want  string
+	}{
+		{webhookTLSVersions, 0x0304, "TLS 1.3"},
+		{webhookTLSCipherSuites, 0xc02f, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		{webhookTLSCipherSuites, 0x0a0a, "0x0a0a"},
+	}
+	for _, tt := range tests {
+		if got := tlsName(tt.names, tt.id); got != tt.want {
+			t.Errorf("tlsName(%#04x) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func Test_webhookDeliveryFrom(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		replayed bool
+		want     WebhookDelivery
+	}{
+		{"no headers", nil, false, WebhookDelivery{}},
+		{"github", map[string]string{"X-GitHub-Delivery": "72d3162e-cc78-11e3-81ab-4c9367dc0958"}, false, WebhookDelivery{ID: "72d3162e-cc78-11e3-81ab-4c9367dc0958"}},
+		{"idempotency key preferred", map[string]string{"X-Gitlab-Event-UUID": "gl", "Idempotency-Key": "key"}, false, WebhookDelivery{ID: "key"}},
+		{"slack retry", map[string]string{"X-Slack-Retry-Num": "2"}, false, WebhookDelivery{Retries: 2}},
+		{"bad retry counter", map[string]string{"X-Slack-Retry-Num": "x"}, false, WebhookDelivery{}},
+		{"replayed", nil, true, WebhookDelivery{Replayed: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/webhook/token", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if tt.replayed {
+				r = r.WithContext(context.WithValue(r.Context(), replayedWebhookKey{}, time.Now()))
+			}
+
+			if got := webhookDeliveryFrom(r); got != tt.want {
+				t.Errorf("webhookDeliveryFrom() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_webhookReceivedAt(t *testing.T) {
+	spilledAt := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	r, _ := http.NewRequest("POST", "/webhook/token", nil)
+
+	if got := webhookReceivedAt(r.WithContext(context.WithValue(r.Context(), replayedWebhookKey{}, spilledAt))); !got.Equal(spilledAt) {
+		t.Errorf("webhookReceivedAt() = %v, want the spilled time %v", got, spilledAt)
+	}
+
+	if got := webhookReceivedAt(r); time.Since(got) > time.Minute {
+		t.Errorf("webhookReceivedAt() = %v, want now", got)
+	}
+}
@@ -0,0 +1,160 @@
+package integram
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	uurl "net/url"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errWebhookNotMultipart = errors.New(ErrorBadRequstPrefix + "request is not multipart/form-data")
+
+// WebhookMultipart is the parsed multipart/form-data webhook, e.g. Mailgun's inbound email with the attachments
+type WebhookMultipart struct {
+	Fields uurl.Values
+	Files  []*WebhookFilePart
+
+	form *multipart.Form
+}
+
+// WebhookFilePart is the file part of the multipart webhook. Parts above INTEGRAM_WEBHOOK_MULTIPART_MAX_MEMORY are stored in the temp files until the request is answered and the handlers returned
+type WebhookFilePart struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Size        int64
+
+	header *multipart.FileHeader
+}
+
+// Open returns the reader of the file's content. Close it after use
+func (f *WebhookFilePart) Open() (io.ReadCloser, error) {
+	return f.header.Open()
+}
+
+// File returns the first file part of the field or nil if not found
+func (m *WebhookMultipart) File(field string) *WebhookFilePart {
+	for _, f := range m.Files {
+		if f.Field == field {
+			return f
+		}
+	}
+	return nil
+}
+
+// Multipart parses the multipart/form-data request's body. Body is limited with INTEGRAM_WEBHOOK_MULTIPART_MAX_SIZE.
+// RAW() is unavailable afterwards, unless it was called before
+func (wc *WebhookContext) Multipart() (*WebhookMultipart, error) {
+	if wc.multipart != nil {
+		return wc.multipart, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(wc.gin.Request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, errWebhookNotMultipart
+	}
+
+	var body io.Reader
+	if wc.body != nil {
+		body = bytes.NewReader(wc.body)
+	} else {
+		wc.firstParse = true
+		body = wc.gin.Request.Body
+	}
+
+	wc.multipart, err = parseWebhookMultipart(body, params["boundary"], Config.WebhookMultipartMaxMemory, Config.WebhookMultipartMaxSize)
+	return wc.multipart, err
+}
+
+// parseWebhookMultipart reads the form. Files above maxMemory are stored in the temp files. maxSize limits the whole body, 0 for unlimited
+func parseWebhookMultipart(body io.Reader, boundary string, maxMemory int64, maxSize int64) (*WebhookMultipart, error) {
+	if maxSize > 0 {
+		body = &limitedBodyReader{r: body, n: maxSize}
+	}
+
+	form, err := multipart.NewReader(body, boundary).ReadForm(maxMemory)
+	if err == errWebhookBodyTooLarge || err != nil && strings.HasSuffix(err.Error(), errWebhookBodyTooLarge.Error()) {
+		return nil, fmt.Errorf("%smultipart body exceeds %d bytes", ErrorBadRequstPrefix, maxSize)
+	} else if err != nil {
+		return nil, fmt.Errorf("%swrong multipart body: %s", ErrorBadRequstPrefix, err.Error())
+	}
+
+	m := &WebhookMultipart{Fields: uurl.Values(form.Value), form: form}
+	for field, headers := range form.File {
+		for _, h := range headers {
+			m.Files = append(m.Files, &WebhookFilePart{
+				Field:       field,
+				Filename:    h.Filename,
+				ContentType: h.Header.Get("Content-Type"),
+				Size:        h.Size,
+				header:      h,
+			})
+		}
+	}
+	return m, nil
+}
+
+// holdMultipart defers the removal of the multipart temp files until the matching closeMultipart call
+func (wc *WebhookContext) holdMultipart() {
+	atomic.AddInt32(&wc.multipartHolds, 1)
+}
+
+// closeMultipart removes the temp files of the parsed multipart body, unless they are still held by the handler
+func (wc *WebhookContext) closeMultipart() {
+	if atomic.AddInt32(&wc.multipartHolds, -1) >= 0 {
+		return
+	}
+
+	if wc.multipart == nil {
+		return
+	}
+
+	if err := wc.multipart.form.RemoveAll(); err != nil {
+		log.WithField("request_id", wc.requestID).WithError(err).Error("Can't remove the multipart temp files")
+	}
+	wc.multipart = nil
+}
+
+// runWebhookHandler runs the handler with the service's deadline. Multipart temp files are kept until the handler returns, even if it timed out
+func (c *Context) runWebhookHandler(wc *WebhookContext, handler func(hc *Context) error) error {
+	wc.holdMultipart()
+
+	err := c.runHandler("webhook", handler, func(hc *Context, err error) { wc.closeMultipart() })
+	if err != ErrHandlerTimeout {
+		wc.closeMultipart()
+	}
+	return err
+}
+
+var errWebhookBodyTooLarge = errors.New("webhook body too large")
+
+// limitedBodyReader returns errWebhookBodyTooLarge instead of EOF when the body exceeds n bytes
+type limitedBodyReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedBodyReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errWebhookBodyTooLarge
+	}
+
+	// read one byte more to detect the overflow
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errWebhookBodyTooLarge
+	}
+	return n, err
+}
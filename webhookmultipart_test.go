@@ -0,0 +1,98 @@
+package integram
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func Test_parseWebhookMultipart(t *testing.T) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("subject", "Invoice")
+	w.WriteField("sender", "bob@example.com")
+	f, _ := w.CreateFormFile("attachment-1", "invoice.pdf")
+	f.Write([]byte(strings.Repeat("x", 1000)))
+	w.Close()
+
+	tests := []struct {
+		name      string
+		maxMemory int64
+		maxSize   int64
+		wantErr   bool
+	}{
+		{"in memory", 1 << 20, 0, false},
+		{"file stored on disk", 100, 0, false},
+		{"within size", 1 << 20, int64(body.Len()), false},
+		{"too large", 1 << 20, 500, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseWebhookMultipart(bytes.NewReader(body.Bytes()), w.Boundary(), tt.maxMemory, tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWebhookMultipart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer m.form.RemoveAll()
+
+			if m.Fields.Get("subject") != "Invoice" || m.Fields.Get("sender") != "bob@example.com" {
+				t.Errorf("parseWebhookMultipart() fields = %v", m.Fields)
+			}
+
+			file := m.File("attachment-1")
+			if file == nil || file.Filename != "invoice.pdf" || file.Size != 1000 {
+				t.Fatalf("parseWebhookMultipart() file = %+v", file)
+			}
+
+			r, err := file.Open()
+			if err != nil {
+				t.Fatalf("WebhookFilePart.Open() error = %v", err)
+			}
+			defer r.Close()
+
+			if content, _ := ioutil.ReadAll(r); len(content) != 1000 {
+				t.Errorf("WebhookFilePart content size = %d, want 1000", len(content))
+			}
+
+			if m.File("attachment-2") != nil {
+				t.Errorf("WebhookMultipart.File() of the missing field = %+v, want nil", m.File("attachment-2"))
+			}
+		})
+	}
+}
+
+func TestWebhookContext_closeMultipart(t *testing.T) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	f, _ := w.CreateFormFile("attachment-1", "invoice.pdf")
+	f.Write([]byte(strings.Repeat("x", 1000)))
+	w.Close()
+
+	m, err := parseWebhookMultipart(bytes.NewReader(body.Bytes()), w.Boundary(), 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc := &WebhookContext{multipart: m}
+
+	// e.g. the timed out handler is still running after the request was answered
+	wc.holdMultipart()
+	wc.closeMultipart()
+
+	r, err := m.File("attachment-1").Open()
+	if err != nil {
+		t.Fatalf("WebhookFilePart.Open() of the held multipart error = %v", err)
+	}
+	r.Close()
+
+	wc.closeMultipart()
+	if wc.multipart != nil {
+		t.Errorf("closeMultipart() of the released multipart didn't remove it")
+	}
+	if _, err := m.File("attachment-1").Open(); err == nil {
+		t.Errorf("WebhookFilePart.Open() of the removed multipart succeeded")
+	}
+}
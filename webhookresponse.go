@@ -0,0 +1,93 @@
+package integram
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// webhookResponse is the answer to the webhook set by the service. Written after the handlers are finished instead of Integram's default one
+type webhookResponse struct {
+	mu     sync.Mutex
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Status sets the HTTP status code of the webhook's response
+func (wc *WebhookContext) Status(code int) {
+	wc.response.mu.Lock()
+	defer wc.response.mu.Unlock()
+
+	wc.response.status = code
+}
+
+// SetHeader sets the header of the webhook's response, e.g. the upstream's verification signature
+func (wc *WebhookContext) SetHeader(key, value string) {
+	wc.response.mu.Lock()
+	defer wc.response.mu.Unlock()
+
+	if wc.response.header == nil {
+		wc.response.header = make(http.Header)
+	}
+	wc.response.header.Set(key, value)
+}
+
+// RespondJSON answers the webhook with the JSON-encoded body, e.g. to complete Slack's URL verification
+func (wc *WebhookContext) RespondJSON(code int, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	wc.respond(code, "application/json; charset=utf-8", data)
+	return nil
+}
+
+// RespondString answers the webhook with the plain text, e.g. to echo Facebook's hub.challenge
+func (wc *WebhookContext) RespondString(code int, text string) {
+	wc.respond(code, "text/plain; charset=utf-8", []byte(text))
+}
+
+func (wc *WebhookContext) respond(code int, contentType string, body []byte) {
+	wc.response.mu.Lock()
+	defer wc.response.mu.Unlock()
+
+	if wc.response.header == nil {
+		wc.response.header = make(http.Header)
+	}
+	wc.response.header.Set("Content-Type", contentType)
+	wc.response.status = code
+	wc.response.body = body
+}
+
+// writeServiceResponse writes the response set by the service. Headers set with SetHeader are always added.
+// Returns false if the service didn't set the status or the body, so the default response must be written
+func (wc *WebhookContext) writeServiceResponse() bool {
+	wc.response.mu.Lock()
+	defer wc.response.mu.Unlock()
+
+	for key, values := range wc.response.header {
+		for _, value := range values {
+			wc.gin.Writer.Header().Add(key, value)
+		}
+	}
+
+	if wc.response.status == 0 && wc.response.body == nil {
+		return false
+	}
+
+	status := wc.response.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if wc.response.body == nil {
+		wc.gin.AbortWithStatus(status)
+		return true
+	}
+
+	wc.gin.Data(status, wc.response.header.Get("Content-Type"), wc.response.body)
+	wc.gin.Abort()
+	return true
+}
@@ -0,0 +1,51 @@
+package integram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookContext_writeServiceResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		set        func(wc *WebhookContext)
+		want       bool
+		wantStatus int
+		wantBody   string
+		wantType   string
+	}{
+		{"default", func(wc *WebhookContext) {}, false, http.StatusOK, "", ""},
+		{"header only", func(wc *WebhookContext) { wc.SetHeader("X-Hook-Secret", "secret") }, false, http.StatusOK, "", ""},
+		{"status", func(wc *WebhookContext) { wc.Status(http.StatusCreated) }, true, http.StatusCreated, "", ""},
+		{"json", func(wc *WebhookContext) {
+			wc.RespondJSON(http.StatusOK, map[string]string{"challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"})
+		}, true, http.StatusOK, `{"challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`, "application/json; charset=utf-8"},
+		{"string", func(wc *WebhookContext) { wc.RespondString(http.StatusOK, "1158201444") }, true, http.StatusOK, "1158201444", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			wc := &WebhookContext{gin: c}
+
+			tt.set(wc)
+			if got := wc.writeServiceResponse(); got != tt.want {
+				t.Fatalf("WebhookContext.writeServiceResponse() = %v, want %v", got, tt.want)
+			}
+			c.Writer.WriteHeaderNow()
+
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if tt.name == "header only" && w.Header().Get("X-Hook-Secret") != "secret" {
+				t.Errorf("X-Hook-Secret header wasn't written")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package integram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// commands to stop the active wizard and to skip the optional step
+const (
+	wizardCancelCommand = "cancel"
+	wizardSkipCommand   = "skip"
+)
+
+// wizard is abandoned if the user doesn't answer within this period
+const wizardStateTTL = time.Hour * 24
+
+// WizardStep is the single question of the Wizard
+type WizardStep struct {
+	Name     string                                                // key of the answer in WizardState.Values
+	Prompt   string                                                // question sent to the user using the default parse mode
+	Optional bool                                                  // step can be skipped with /skip, its answer is not set in WizardState.Values
+	Validate func(c *Context, answer string) error                 // optional. Error's text is sent to the user and the question is asked again
+	Handler  func(c *Context, w *WizardState, answer string) error // optional. Called with the valid answer, use WizardState.GoTo to choose the next step
+}
+
+// Wizard is the conversation that asks the user the questions one by one. Add it to Service.Wizards and start with Context.StartWizard.
+// User's messages are routed to the current step automatically until the last one is answered or /cancel is sent
+type Wizard struct {
+	Name  string
+	Steps []WizardStep
+	Done  func(c *Context, w *WizardState) error // called with all the answers after the last step
+}
+
+// WizardState is the user's progress in the wizard in the chat. Stored in the "wizards" collection
+type WizardState struct {
+	ID        string `bson:"_id"` // see wizardStateID
+	Service   string
+	Wizard    string
+	ChatID    int64
+	UserID    int64
+	Step      int
+	Values    map[string]string
+	StartedAt time.Time
+	UpdatedAt time.Time
+
+	next string // set with GoTo
+}
+
+// wizardStateID returns the ID of the user's wizard in the chat. User has at most one active wizard per chat
+func wizardStateID(serviceName string, chatID int64, userID int64) string {
+	return fmt.Sprintf("%s_%d_%d", serviceName, chatID, userID)
+}
+
+// GoTo sets the step that will be asked after the current one instead of the next in order
+func (w *WizardState) GoTo(step string) {
+	w.next = step
+}
+
+// wizard returns the service's wizard with the name or nil
+func (s *Service) wizard(name string) *Wizard {
+	for i := range s.Wizards {
+		if s.Wizards[i].Name == name {
+			return &s.Wizards[i]
+		}
+	}
+	return nil
+}
+
+// stepIndex returns the index of the step with the name or -1
+func (w *Wizard) stepIndex(name string) int {
+	for i, step := range w.Steps {
+		if step.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextStep returns the index of the step after the current one. Returns len(Steps) if the wizard is finished
+func (w *Wizard) nextStep(state *WizardState) int {
+	if state.next != "" {
+		if i := w.stepIndex(state.next); i >= 0 {
+			return i
+		}
+	}
+	return state.Step + 1
+}
+
+// StartWizard starts the service's wizard with the name for the current user in the current chat and asks the first question.
+// Previous wizard of the user in the chat is cancelled. values are available in WizardState.Values along with the answers
+func (c *Context) StartWizard(name string, values map[string]string) error {
+	w := c.Service().wizard(name)
+	if w == nil {
+		return fmt.Errorf("Wizard '%s' not found in Service.Wizards", name)
+	}
+
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("Wizard '%s' has no steps", name)
+	}
+
+	state := &WizardState{
+		ID:        wizardStateID(c.ServiceName, c.Chat.ID, c.User.ID),
+		Service:   c.ServiceName,
+		Wizard:    name,
+		ChatID:    c.Chat.ID,
+		UserID:    c.User.ID,
+		Values:    make(map[string]string),
+		StartedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	for key, value := range values {
+		state.Values[key] = value
+	}
+
+	_, err := c.db.C("wizards").UpsertId(state.ID, state)
+	if err != nil {
+		return err
+	}
+
+	return c.askWizardStep(w, state)
+}
+
+// CancelWizard stops the current user's wizard in the current chat. Returns false if there is no active wizard
+func (c *Context) CancelWizard() (bool, error) {
+	err := c.db.C("wizards").RemoveId(wizardStateID(c.ServiceName, c.Chat.ID, c.User.ID))
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// askWizardStep sends the current step's question. In groups it is the selective ForceReply, so the answer is received in the privacy mode
+func (c *Context) askWizardStep(w *Wizard, state *WizardState) error {
+	step := w.Steps[state.Step]
+	text := step.Prompt
+	if step.Optional {
+		text += "\n\n/" + wizardSkipCommand + " to leave it empty"
+	}
+
+	m := c.NewMessage().SetText(text).EnableForceReply()
+
+	if c.Chat.IsGroup() {
+		m.SetSelective(true)
+		if c.Message != nil {
+			m.SetReplyToMsgID(c.Message.MsgID)
+		}
+	}
+
+	return m.Send()
+}
+
+// handleWizardMessage routes the message to the current step of the user's active wizard. Returns true if message was handled
+func (c *Context) handleWizardMessage() bool {
+	if c.Message == nil {
+		return false
+	}
+
+	if s := c.Service(); s == nil || len(s.Wizards) == 0 {
+		return false
+	}
+
+	var state WizardState
+	err := c.db.C("wizards").FindId(wizardStateID(c.ServiceName, c.Chat.ID, c.User.ID)).One(&state)
+	if err != nil {
+		if err != mgo.ErrNotFound {
+			c.Log().WithError(err).Error("handleWizardMessage: can't get the wizard")
+		}
+		return false
+	}
+
+	w := c.Service().wizard(state.Wizard)
+	if w == nil || state.Step < 0 || state.Step >= len(w.Steps) {
+		// wizard was removed or changed since it was started
+		c.CancelWizard()
+		return false
+	}
+
+	cmd, _ := c.Message.GetCommand()
+	if cmd == wizardCancelCommand {
+		if _, err := c.CancelWizard(); err != nil {
+			c.Log().WithError(err).Error("handleWizardMessage: can't cancel the wizard")
+		}
+
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText("Cancelled").SetParseMode("").Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleWizardMessage: can't send the reply")
+		}
+		return true
+	}
+
+	step := w.Steps[state.Step]
+	skipped := cmd == wizardSkipCommand
+
+	if skipped && !step.Optional {
+		err := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText("This step can't be skipped. Send /" + wizardCancelCommand + " to stop").SetParseMode("").Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleWizardMessage: can't send the reply")
+		}
+		return true
+	}
+
+	if cmd != "" && cmd != wizardSkipCommand {
+		// other commands are handled as usual, the wizard is continued after them
+		return false
+	}
+
+	answer := strings.TrimSpace(c.Message.Text)
+	if state.Values == nil {
+		state.Values = make(map[string]string)
+	}
+
+	if skipped {
+		answer = ""
+		delete(state.Values, step.Name)
+	} else {
+		if step.Validate != nil {
+			if err := step.Validate(c, answer); err != nil {
+				sendErr := c.NewMessage().SetReplyToMsgID(c.Message.MsgID).SetText(err.Error()).SetParseMode("").Send()
+				if sendErr != nil {
+					c.Log().WithError(sendErr).Error("handleWizardMessage: can't send the validation error")
+				}
+
+				if err := c.askWizardStep(w, &state); err != nil {
+					c.Log().WithError(err).Error("handleWizardMessage: can't ask the step again")
+				}
+				return true
+			}
+		}
+
+		state.Values[step.Name] = answer
+	}
+
+	if step.Handler != nil {
+		err := c.runHandler("wizard step", func(hc *Context) error { return step.Handler(hc, &state, answer) }, nil)
+		if err == ErrHandlerTimeout {
+			// the state may still be changed by the timed out handler
+			return true
+		}
+		if err != nil {
+			c.Log().WithError(err).WithField("step", step.Name).Error("Wizard step's handler failed")
+			c.renderServiceError(err)
+			return true
+		}
+	}
+
+	state.Step = w.nextStep(&state)
+	state.next = ""
+	state.UpdatedAt = time.Now()
+
+	if state.Step < len(w.Steps) {
+		err := c.db.C("wizards").UpdateId(state.ID, bson.M{"$set": bson.M{"step": state.Step, "values": state.Values, "updatedat": state.UpdatedAt}})
+		if err != nil {
+			c.Log().WithError(err).Error("handleWizardMessage: can't save the wizard")
+			return true
+		}
+
+		if err := c.askWizardStep(w, &state); err != nil {
+			c.Log().WithError(err).Error("handleWizardMessage: can't ask the next step")
+		}
+		return true
+	}
+
+	if _, err := c.CancelWizard(); err != nil {
+		c.Log().WithError(err).Error("handleWizardMessage: can't remove the finished wizard")
+	}
+
+	if w.Done == nil {
+		return true
+	}
+
+	err = c.runHandler("wizard", func(hc *Context) error { return w.Done(hc, &state) }, nil)
+	if err != nil && err != ErrHandlerTimeout {
+		c.Log().WithError(err).WithField("wizard", w.Name).Error("Wizard's Done handler failed")
+		c.renderServiceError(err)
+	}
+
+	return true
+}
@@ -0,0 +1,50 @@
+package integram
+
+import "testing"
+
+func Test_wizardStateID(t *testing.T) {
+	if got := wizardStateID("trello", -100123, 42); got != "trello_-100123_42" {
+		t.Errorf("wizardStateID() = %v, want trello_-100123_42", got)
+	}
+}
+
+func TestService_wizard(t *testing.T) {
+	s := &Service{Wizards: []Wizard{{Name: "card"}, {Name: "comment"}}}
+
+	if w := s.wizard("comment"); w == nil || w.Name != "comment" {
+		t.Errorf("Service.wizard() = %v, want comment", w)
+	}
+
+	if w := s.wizard("unknown"); w != nil {
+		t.Errorf("Service.wizard() = %v, want nil", w)
+	}
+}
+
+func TestWizard_nextStep(t *testing.T) {
+	w := &Wizard{Steps: []WizardStep{{Name: "board"}, {Name: "list"}, {Name: "title"}}}
+
+	tests := []struct {
+		name string
+		step int
+		goTo string
+		want int
+	}{
+		{"next in order", 0, "", 1},
+		{"last step", 2, "", 3},
+		{"go to", 0, "title", 2},
+		{"go back", 2, "board", 0},
+		{"unknown step", 1, "due", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &WizardState{Step: tt.step}
+			if tt.goTo != "" {
+				state.GoTo(tt.goTo)
+			}
+
+			if got := w.nextStep(state); got != tt.want {
+				t.Errorf("Wizard.nextStep() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package integram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// chat command to manage the working hours in the private chat: '/workinghours 9-18 mon-fri', '/workinghours off', '/workinghours urgent'
+const workingHoursCommand = "workinghours"
+
+// held messages are delivered after the digest
+const workingHoursDigestDelay = time.Second * 10
+
+var weekdaysShort = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// WorkingHours of the user in the user's timezone. Personal notifications outside of them are held and delivered with the digest when the next working period starts
+type WorkingHours struct {
+	From   int            `bson:"f"`           // minutes since midnight
+	To     int            `bson:"t"`           // minutes since midnight. Less than From for the overnight period
+	Days   []time.Weekday `bson:"d,omitempty"` // days when the working period starts. Empty means every day
+	Urgent []string       `bson:"u,omitempty"` // services which notifications are always delivered immediately
+}
+
+// workingHoursDigest is stored in the "working_hours_digests" collection until the held messages are delivered
+type workingHoursDigest struct {
+	ID       string         `bson:"_id"` // botID_chatID_unixStart
+	BotID    int64          `bson:"b"`
+	ChatID   int64          `bson:"c"`
+	At       time.Time      `bson:"at"`
+	Services map[string]int `bson:"s"` // number of held messages per service
+}
+
+func (wh WorkingHours) isWorkday(d time.Weekday) bool {
+	if len(wh.Days) == 0 {
+		return true
+	}
+
+	for _, wd := range wh.Days {
+		if wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUrgent returns true if the service's notifications are delivered outside of the working hours
+func (wh WorkingHours) IsUrgent(service string) bool {
+	for _, s := range wh.Urgent {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns true if t is within the working hours. t must be in the user's timezone
+func (wh WorkingHours) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+
+	if wh.From <= wh.To {
+		return m >= wh.From && m < wh.To && wh.isWorkday(t.Weekday())
+	}
+
+	// overnight period belongs to the day it started
+	if m >= wh.From {
+		return wh.isWorkday(t.Weekday())
+	}
+	return m < wh.To && wh.isWorkday(t.AddDate(0, 0, -1).Weekday())
+}
+
+// NextStart returns the start of the next working period after t. t must be in the user's timezone
+func (wh WorkingHours) NextStart(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		d := t.AddDate(0, 0, i)
+		start := time.Date(d.Year(), d.Month(), d.Day(), wh.From/60, wh.From%60, 0, 0, t.Location())
+		if start.After(t) && wh.isWorkday(start.Weekday()) {
+			return start
+		}
+	}
+
+	// no workdays
+	return t
+}
+
+func formatDayMinutes(m int) string {
+	return fmt.Sprintf("%d:%02d", m/60, m%60)
+}
+
+func (wh WorkingHours) String() string {
+	s := formatDayMinutes(wh.From) + "-" + formatDayMinutes(wh.To)
+	if len(wh.Days) == 0 || len(wh.Days) == 7 {
+		return s + " every day"
+	}
+
+	var days []string
+	for _, d := range wh.Days {
+		days = append(days, weekdaysShort[d])
+	}
+	return s + " " + strings.Join(days, ",")
+}
+
+func parseDayMinutes(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("wrong hour '%s'", s)
+	}
+
+	m := 0
+	if len(parts) == 2 {
+		m, err = strconv.Atoi(parts[1])
+		if err != nil || m < 0 || m > 59 {
+			return 0, fmt.Errorf("wrong minutes '%s'", s)
+		}
+	}
+
+	if h == 24 && m > 0 {
+		return 0, fmt.Errorf("wrong time '%s'", s)
+	}
+
+	return h*60 + m, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, d := range weekdaysShort {
+		if strings.HasPrefix(strings.ToLower(s), d) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("wrong day '%s'", s)
+}
+
+// parseWorkingHours parses the period and optional days, e.g. "9-18", "9:30-18:00 mon-fri", "22-6 sun,mon,tue"
+func parseWorkingHours(s string) (*WorkingHours, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, errors.New("specify the hours and optionally the days, e.g. 9-18 mon-fri")
+	}
+
+	period := strings.SplitN(fields[0], "-", 2)
+	if len(period) != 2 {
+		return nil, fmt.Errorf("wrong period '%s'", fields[0])
+	}
+
+	wh := &WorkingHours{}
+	var err error
+	if wh.From, err = parseDayMinutes(period[0]); err != nil {
+		return nil, err
+	}
+	if wh.To, err = parseDayMinutes(period[1]); err != nil {
+		return nil, err
+	}
+	if wh.From == wh.To {
+		return nil, errors.New("working hours can't be empty")
+	}
+
+	if len(fields) == 1 {
+		return wh, nil
+	}
+
+	days := map[time.Weekday]bool{}
+	for _, item := range strings.Split(fields[1], ",") {
+		rng := strings.SplitN(item, "-", 2)
+		from, err := parseWeekday(rng[0])
+		if err != nil {
+			return nil, err
+		}
+
+		to := from
+		if len(rng) == 2 {
+			if to, err = parseWeekday(rng[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	if len(days) < 7 {
+		for d := range days {
+			wh.Days = append(wh.Days, d)
+		}
+		sort.Slice(wh.Days, func(i, j int) bool { return wh.Days[i] < wh.Days[j] })
+	}
+
+	return wh, nil
+}
+
+// WorkingHours returns the user's working hours or nil if they aren't set
+func (user *User) WorkingHours() (*WorkingHours, error) {
+	var data struct {
+		WorkingHours *WorkingHours
+	}
+
+	err := user.ctx.db.C("users").FindId(user.ID).Select(bson.M{"workinghours": 1}).One(&data)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return data.WorkingHours, nil
+}
+
+// SetWorkingHours saves the user's working hours. Nil disables them and personal notifications are always delivered immediately
+func (user *User) SetWorkingHours(wh *WorkingHours) error {
+	if wh == nil {
+		return user.ctx.db.C("users").UpdateId(user.ID, bson.M{"$unset": bson.M{"workinghours": ""}})
+	}
+
+	_, err := user.ctx.db.C("users").UpsertId(user.ID, bson.M{"$set": bson.M{"workinghours": wh}, "$setOnInsert": bson.M{"createdat": time.Now()}})
+	return err
+}
+
+// isNotification returns true if the message isn't the response to the user's action
+func (m *OutgoingMessage) isNotification() bool {
+	c := m.ctx
+	return c != nil && c.Message == nil && c.Callback == nil && c.InlineQuery == nil && c.ChosenInlineResult == nil
+}
+
+// holdOutsideWorkingHours postpones the personal notification until the next working period and adds it to the digest. Returns true if the message was held
+func (m *OutgoingMessage) holdOutsideWorkingHours(db *mgo.Database) bool {
+	if m.ChatID <= 0 || m.SendAfter != nil || !m.isNotification() {
+		return false
+	}
+
+	var data struct {
+		Tz           string
+		WorkingHours *WorkingHours
+	}
+
+	err := db.C("users").FindId(m.ChatID).Select(bson.M{"tz": 1, "workinghours": 1}).One(&data)
+	if err != nil || data.WorkingHours == nil || data.WorkingHours.IsUrgent(m.ctx.ServiceName) {
+		return false
+	}
+
+	now := time.Now().In(tzLocation(data.Tz))
+	if data.WorkingHours.Contains(now) {
+		return false
+	}
+
+	start := data.WorkingHours.NextStart(now)
+	if !start.After(now) {
+		return false
+	}
+
+	err = addToWorkingHoursDigest(db, m.BotID, m.ChatID, m.ctx.ServiceName, start)
+	if err != nil {
+		log.WithError(err).WithField("chat", m.ChatID).Error("Can't add the message to the working hours digest")
+		return false
+	}
+
+	m.SetSendAfter(start.Add(workingHoursDigestDelay)).SetSilent(true)
+	return true
+}
+
+// addToWorkingHoursDigest counts the held message and schedules the digest when the first message is held
+func addToWorkingHoursDigest(db *mgo.Database, botID int64, chatID int64, service string, at time.Time) error {
+	id := fmt.Sprintf("%d_%d_%d", botID, chatID, at.Unix())
+
+	info, err := db.C("working_hours_digests").UpsertId(id, bson.M{
+		"$inc":         bson.M{"s." + service: 1},
+		"$setOnInsert": bson.M{"b": botID, "c": chatID, "at": at},
+	})
+	if err != nil {
+		return err
+	}
+
+	if info.UpsertedId == nil {
+		return nil
+	}
+
+	_, err = workingHoursDigestJob.Schedule(0, at, id)
+	return err
+}
+
+// workingHoursDigestText lists the number of the held notifications per service
+func workingHoursDigestText(d workingHoursDigest) string {
+	var names []string
+	total := 0
+	for name, n := range d.Services {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+
+	b := instanceBranding()
+	lines := []string{fmt.Sprintf("%s%d notifications arrived outside of your working hours:", b.EmojiPrefix("☀️"), total)}
+	for _, name := range names {
+		nameToPrint := name
+		if s, _ := serviceByName(name); s != nil {
+			nameToPrint = s.NameToPrint
+		}
+		lines = append(lines, fmt.Sprintf("• %s: %d", nameToPrint, d.Services[name]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// sendWorkingHoursDigest is the job that sends the digest right before the held messages are delivered
+func sendWorkingHoursDigest(id string) error {
+	db := mongoSession.Clone().DB(mongo.Database)
+	defer db.Session.Close()
+
+	var d workingHoursDigest
+	_, err := db.C("working_hours_digests").FindId(id).Apply(mgo.Change{Remove: true}, &d)
+	if err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	bot := botByID(d.BotID)
+	if bot == nil || len(bot.services) == 0 {
+		return fmt.Errorf("sendWorkingHoursDigest: bot %d not found", d.BotID)
+	}
+
+	ctx := &Context{db: db, ServiceName: bot.services[0].Name}
+	ctx.Chat = Chat{ID: d.ChatID, ctx: ctx}
+
+	return ctx.NewMessage().SetText(workingHoursDigestText(d)).SetParseMode("").applyBrandingFooter().SetLowPriority(true).SetSendAfter(time.Now()).Send()
+}
+
+// handleWorkingHoursCommand process '/workinghours' in the private chat. Returns true if message was handled
+func (c *Context) handleWorkingHoursCommand() bool {
+	if c.Message == nil || !c.Chat.IsPrivate() {
+		return false
+	}
+
+	cmd, param := c.Message.GetCommand()
+	if cmd != coreCommand(workingHoursCommand) {
+		return false
+	}
+
+	reply := func(text string) {
+		err := c.NewMessage().SetText(text).SetParseMode("").Send()
+		if err != nil {
+			c.Log().WithError(err).Error("handleWorkingHoursCommand: can't send the reply")
+		}
+	}
+
+	wh, err := c.User.WorkingHours()
+	if err != nil {
+		c.Log().WithError(err).Error("handleWorkingHoursCommand: can't get the working hours")
+		reply(c.Branding().ErrorText("Can't get your working hours. Please try again later"))
+		return true
+	}
+
+	tz := c.User.Tz
+	if tz == "" {
+		tz = "UTC"
+	}
+
+	param = strings.TrimSpace(param)
+	switch param {
+	case "":
+		if wh == nil {
+			reply(fmt.Sprintf("Working hours are not set, notifications are delivered immediately. Set them with /%s 9-18 mon-fri", coreCommand(workingHoursCommand)))
+			return true
+		}
+
+		text := fmt.Sprintf("Your working hours: %s (%s). Notifications outside of them are delivered as a digest when the working hours start", wh.String(), tz)
+		if wh.IsUrgent(c.ServiceName) {
+			text += fmt.Sprintf(".\n%s notifications are urgent and always delivered immediately", c.Service().NameToPrint)
+		}
+		reply(text)
+		return true
+	case "off":
+		if wh != nil {
+			err = c.User.SetWorkingHours(nil)
+		}
+		if err != nil {
+			c.Log().WithError(err).Error("handleWorkingHoursCommand: can't disable the working hours")
+			reply(c.Branding().ErrorText("Can't disable your working hours. Please try again later"))
+			return true
+		}
+		reply("Working hours disabled, notifications are delivered immediately")
+		return true
+	case "urgent":
+		if wh == nil {
+			reply(fmt.Sprintf("Set your working hours first, e.g. /%s 9-18 mon-fri", coreCommand(workingHoursCommand)))
+			return true
+		}
+
+		var text string
+		if wh.IsUrgent(c.ServiceName) {
+			var urgent []string
+			for _, s := range wh.Urgent {
+				if s != c.ServiceName {
+					urgent = append(urgent, s)
+				}
+			}
+			wh.Urgent = urgent
+			text = fmt.Sprintf("%s notifications outside of your working hours will be held until %s", c.Service().NameToPrint, formatDayMinutes(wh.From))
+		} else {
+			wh.Urgent = append(wh.Urgent, c.ServiceName)
+			text = fmt.Sprintf("%s notifications will be always delivered immediately", c.Service().NameToPrint)
+		}
+
+		err = c.User.SetWorkingHours(wh)
+		if err != nil {
+			c.Log().WithError(err).Error("handleWorkingHoursCommand: can't save the working hours")
+			reply(c.Branding().ErrorText("Can't save your working hours. Please try again later"))
+			return true
+		}
+		reply(text)
+		return true
+	}
+
+	newWH, err := parseWorkingHours(param)
+	if err != nil {
+		reply(c.Branding().ErrorText(fmt.Sprintf("Can't parse the working hours: %s", err.Error())))
+		return true
+	}
+
+	if wh != nil {
+		newWH.Urgent = wh.Urgent
+	}
+
+	err = c.User.SetWorkingHours(newWH)
+	if err != nil {
+		c.Log().WithError(err).Error("handleWorkingHoursCommand: can't save the working hours")
+		reply(c.Branding().ErrorText("Can't save your working hours. Please try again later"))
+		return true
+	}
+
+	reply(fmt.Sprintf("Your working hours: %s (%s). Notifications outside of them will be delivered as a digest when the working hours start. Mark this bot's notifications as urgent with /%s urgent",
+		newWH.String(), tz, coreCommand(workingHoursCommand)))
+	return true
+}
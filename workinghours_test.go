@@ -0,0 +1,74 @@
+package integram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseWorkingHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *WorkingHours
+		wantErr bool
+	}{
+		{"hours only", "9-18", &WorkingHours{From: 540, To: 1080}, false},
+		{"minutes and range", "9:30-18:00 mon-fri", &WorkingHours{From: 570, To: 1080, Days: []time.Weekday{1, 2, 3, 4, 5}}, false},
+		{"list and overnight", "22-6 sun,tue", &WorkingHours{From: 1320, To: 360, Days: []time.Weekday{0, 2}}, false},
+		{"range over sunday", "10-19 fri-mon", &WorkingHours{From: 600, To: 1140, Days: []time.Weekday{0, 1, 5, 6}}, false},
+		{"all days", "10-19 mon-sun", &WorkingHours{From: 600, To: 1140}, false},
+		{"empty period", "9-9", nil, true},
+		{"wrong hour", "9-25", nil, true},
+		{"wrong day", "9-18 mon-foo", nil, true},
+		{"no period", "mon-fri", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWorkingHours(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseWorkingHours() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseWorkingHours() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkingHours_ContainsAndNextStart(t *testing.T) {
+	weekdays := WorkingHours{From: 540, To: 1080, Days: []time.Weekday{1, 2, 3, 4, 5}}
+	overnight := WorkingHours{From: 1320, To: 360, Days: []time.Weekday{1}}
+
+	// 2018-01-01 is Monday
+	at := func(day int, hour int, min int) time.Time {
+		return time.Date(2018, 1, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name          string
+		wh            WorkingHours
+		t             time.Time
+		wantContains  bool
+		wantNextStart time.Time
+	}{
+		{"monday morning", weekdays, at(1, 8, 59), false, at(1, 9, 0)},
+		{"monday day", weekdays, at(1, 12, 0), true, at(2, 9, 0)},
+		{"friday evening", weekdays, at(5, 18, 0), false, at(8, 9, 0)},
+		{"saturday", weekdays, at(6, 12, 0), false, at(8, 9, 0)},
+		{"overnight start", overnight, at(1, 23, 0), true, at(8, 22, 0)},
+		{"overnight next day", overnight, at(2, 5, 59), true, at(8, 22, 0)},
+		{"overnight end", overnight, at(2, 6, 0), false, at(8, 22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.wh.Contains(tt.t); got != tt.wantContains {
+				t.Errorf("WorkingHours.Contains() = %v, want %v", got, tt.wantContains)
+			}
+			if got := tt.wh.NextStart(tt.t); !got.Equal(tt.wantNextStart) {
+				t.Errorf("WorkingHours.NextStart() = %v, want %v", got, tt.wantNextStart)
+			}
+		})
+	}
+}